@@ -0,0 +1,74 @@
+// Package bufpool 提供中继、终端和 agent 读写路径共用的缓冲区池。
+//
+// 读消息时先读入池中的缓冲区，再按实际长度拷贝一份返回，每条消息只分配一次；
+// io.ReadAll 从 512 字节起按倍数扩容，大消息要分配多次、总量接近消息长度的两倍。
+// 写缓冲通过 WritePool 交给 gorilla/websocket，空闲连接不再各自占用一块写缓冲。
+// 用法和收益见 poolbench
+package bufpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxPooled 超过该容量的缓冲区用完后不放回池中，避免偶尔的大消息长期占用内存
+const MaxPooled = 1 << 20
+
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Get 取出一个空的缓冲区，用完后调用 Put
+func Get() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// Put 归还缓冲区，之后不能再使用 b 及其中的数据
+func Put(b *bytes.Buffer) {
+	if b.Cap() > MaxPooled {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
+
+// WritePool 设置到 websocket.Upgrader 和 websocket.Dialer 的 WriteBufferPool
+var WritePool websocket.BufferPool = &sync.Pool{}
+
+// ReadMessage 与 websocket.Conn.ReadMessage 相同，limit > 0 时最多读取 limit+1 个字节，
+// 调用方据此判断是否超限；返回的 data 归调用方所有
+func ReadMessage(conn *websocket.Conn, limit int64) (int, []byte, error) {
+	msgType, r, err := conn.NextReader()
+	if err != nil {
+		return msgType, nil, err
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	data, err := ReadAll(r)
+	return msgType, data, err
+}
+
+// ReadAll 与 io.ReadAll 相同，读入池中的缓冲区后按实际长度拷贝一份返回
+func ReadAll(r io.Reader) ([]byte, error) {
+	buf := Get()
+	defer Put(buf)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return append([]byte{}, buf.Bytes()...), nil
+}
+
+// EncodeJSON 把 v 按 json.Marshal 的格式编码到池中的缓冲区后交给 write，
+// 用于编码后立即写出的消息；write 返回后缓冲区即归还，不能保留 data
+func EncodeJSON(v any, write func(data []byte) error) error {
+	buf := Get()
+	defer Put(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	// Encode 在末尾追加换行
+	return write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
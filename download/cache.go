@@ -0,0 +1,273 @@
+package download
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 热点文件缓存：下载过的远程文件缓存在 hub 本地磁盘，按远程文件的 mtime 和大小校验，
+// 命中时直接从本地返回（支持 Range 断点续传），总大小超过上限时按 LRU 淘汰
+// -----------------------
+
+// CacheConfig 缓存配置
+type CacheConfig struct {
+	Dir         string // 缓存目录，为空时不启用缓存
+	MaxBytes    int64  // 缓存总字节上限
+	MaxFileSize int64  // 超过该大小的文件不缓存，0 表示不限制
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Entries   int64 `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// cacheOwner 缓存条目所属的 profile 和 SSH 账号，不同账号对远程文件的权限不同，缓存不能跨账号共享
+type cacheOwner struct {
+	host string // 请求中的 host（profile 名称），为空时是默认主机
+	user string // 实际登录的 SSH 用户
+}
+
+type cacheEntry struct {
+	key     string
+	owner   cacheOwner
+	file    string
+	size    int64
+	modTime time.Time
+}
+
+type fileCache struct {
+	cfg CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // key -> lru 中的元素
+	lru     *list.List               // 队首为最近使用
+	bytes   int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+var cache = newFileCache(CacheConfig{})
+
+func newFileCache(cfg CacheConfig) *fileCache {
+	return &fileCache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// ConfigureCache 设置下载缓存，需在注册路由前调用。启动时清理目录中上次遗留的缓存文件
+func ConfigureCache(cfg CacheConfig) error {
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, os.ModePerm); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(cfg.Dir)
+		if err != nil {
+			return err
+		}
+		// 只删除缓存自己创建的文件（sha256 十六进制命名及其临时文件），目录中的其它文件不受影响
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || (len(name) != sha256.Size*2 && !strings.HasSuffix(name, ".tmp")) {
+				continue
+			}
+			if err := os.Remove(path.Join(cfg.Dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	cache = newFileCache(cfg)
+	return nil
+}
+
+// Stats 返回缓存统计
+func Stats() CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return CacheStats{
+		Entries:   int64(len(cache.entries)),
+		Bytes:     cache.bytes,
+		Hits:      cache.hits.Load(),
+		Misses:    cache.misses.Load(),
+		Evictions: cache.evictions.Load(),
+	}
+}
+
+func (fc *fileCache) enabled() bool {
+	return fc.cfg.Dir != ""
+}
+
+// cacheable 判断某个大小的文件是否可以放入缓存
+func (fc *fileCache) cacheable(size int64) bool {
+	if !fc.enabled() || size > fc.cfg.MaxBytes {
+		return false
+	}
+	return fc.cfg.MaxFileSize <= 0 || size <= fc.cfg.MaxFileSize
+}
+
+// cacheKey 按 profile、SSH 账号、地址和远程路径区分缓存
+func cacheKey(owner cacheOwner, addr, remotePath string) string {
+	sum := sha256.Sum256([]byte(owner.host + "\x00" + owner.user + "@" + addr + "\x00" + remotePath))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup 查找与远程文件 mtime、大小一致的缓存，返回打开的缓存文件和条目所属的 owner；
+// 远程文件已变化的缓存会被删除
+func (fc *fileCache) lookup(key string, size int64, modTime time.Time) (*os.File, cacheOwner) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	elem, ok := fc.entries[key]
+	if !ok {
+		return nil, cacheOwner{}
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.size != size || !entry.modTime.Equal(modTime) {
+		fc.removeLocked(elem)
+		return nil, cacheOwner{}
+	}
+	f, err := os.Open(entry.file)
+	if err != nil {
+		log.Printf("打开缓存文件失败：%v", err)
+		fc.removeLocked(elem)
+		return nil, cacheOwner{}
+	}
+	fc.lru.MoveToFront(elem)
+	return f, entry.owner
+}
+
+// store 将已写完的临时文件登记为缓存，必要时淘汰最久未使用的缓存
+func (fc *fileCache) store(key string, owner cacheOwner, tmpFile string, size int64, modTime time.Time) {
+	file := path.Join(fc.cfg.Dir, key)
+	if err := os.Rename(tmpFile, file); err != nil {
+		log.Printf("保存缓存文件失败：%v", err)
+		os.Remove(tmpFile)
+		return
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if elem, ok := fc.entries[key]; ok {
+		// 并发下载同一文件时后完成的覆盖先完成的，文件已被 rename 替换，只更新记录
+		old := elem.Value.(*cacheEntry)
+		fc.bytes -= old.size
+		fc.lru.Remove(elem)
+		delete(fc.entries, key)
+	}
+	fc.entries[key] = fc.lru.PushFront(&cacheEntry{key: key, owner: owner, file: file, size: size, modTime: modTime})
+	fc.bytes += size
+	for fc.bytes > fc.cfg.MaxBytes && fc.lru.Len() > 1 {
+		fc.removeLocked(fc.lru.Back())
+		fc.evictions.Add(1)
+	}
+}
+
+// removeLocked 删除一条缓存，调用方需持有 mu。已打开该文件的下载不受影响
+func (fc *fileCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	fc.lru.Remove(elem)
+	delete(fc.entries, entry.key)
+	fc.bytes -= entry.size
+	if err := os.Remove(entry.file); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除缓存文件失败：%v", err)
+	}
+}
+
+// purge 清空全部缓存，返回删除的条数
+func (fc *fileCache) purge() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	n := fc.lru.Len()
+	for fc.lru.Len() > 0 {
+		fc.removeLocked(fc.lru.Back())
+	}
+	return n
+}
+
+// cacheWriter 在向客户端传输的同时写入临时文件，传输完整后登记为缓存
+type cacheWriter struct {
+	key     string
+	owner   cacheOwner
+	size    int64
+	modTime time.Time
+	tmp     *os.File
+	written int64
+	failed  bool
+}
+
+// newCacheWriter 在缓存目录下创建临时文件，失败时返回 nil（只是不缓存，不影响下载）
+func (fc *fileCache) newCacheWriter(key string, owner cacheOwner, size int64, modTime time.Time) *cacheWriter {
+	tmp, err := os.CreateTemp(fc.cfg.Dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("创建缓存临时文件失败：%v", err)
+		return nil
+	}
+	return &cacheWriter{key: key, owner: owner, size: size, modTime: modTime, tmp: tmp}
+}
+
+// Write 写入失败时只放弃缓存，不向调用方返回错误，避免中断下载
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if !w.failed {
+		n, err := w.tmp.Write(p)
+		w.written += int64(n)
+		if err != nil {
+			log.Printf("写入缓存临时文件失败：%v", err)
+			w.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// finish 传输结束后调用，complete 表示文件已完整传输
+func (w *cacheWriter) finish(fc *fileCache, complete bool) {
+	name := w.tmp.Name()
+	if err := w.tmp.Close(); err != nil {
+		w.failed = true
+	}
+	if !complete || w.failed || w.written != w.size {
+		os.Remove(name)
+		return
+	}
+	fc.store(w.key, w.owner, name, w.size, w.modTime)
+}
+
+// serveCached 从缓存文件返回内容，由 http.ServeContent 处理 Range 和 If-Range
+func serveCached(c echo.Context, f *os.File, filename string, modTime time.Time) {
+	c.Response().Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	c.Response().Header().Set("Content-Type", "application/octet-stream")
+	c.Response().Header().Set("X-Cache", "HIT")
+	http.ServeContent(c.Response(), c.Request(), filename, modTime, f)
+}
+
+// CacheStatsHandler 查询下载缓存统计
+func CacheStatsHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, Stats())
+}
+
+// CachePurgeHandler 清空下载缓存
+func CachePurgeHandler(c echo.Context) error {
+	purged := cache.purge()
+	log.Printf("下载缓存已清空，删除 %d 个文件", purged)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"purged": purged,
+		"stats":  Stats(),
+	})
+}
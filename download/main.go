@@ -1,6 +1,8 @@
 package download
 
 import (
+	"echo_demo/sshutil"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,10 +10,16 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+)
+
+// OpTimeout 单次 SFTP 操作超时，IdleTimeout 传输过程中无进展的最长时间
+var (
+	OpTimeout   = 30 * time.Second
+	IdleTimeout = 60 * time.Second
 )
 
 // DownloadSftpHandler 通过 SSH 登陆远程服务器建立 SFTP 客户端，将指定远程文件下载给客户端
@@ -27,22 +35,37 @@ func DownloadSftpHandler(c echo.Context) error {
 		remoteFilePath = u.Path
 	}
 
-	// 配置 SSH 连接参数
-	sshConfig := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("vUbFTsMJUY3AhpyT"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	// 配置 SSH 连接参数，指定 host 时按资产清单中的主机连接，否则连接默认主机
+	host := c.QueryParam("host")
+	if err := sshutil.Authorize(c.Request(), sshutil.CapDownload, host); err != nil {
+		log.Printf("下载未授权：%v", err)
+		return c.String(http.StatusForbidden, "无权下载该主机上的文件")
 	}
+	sshAddr, sshConfig, err := sshutil.ResolveRequest(c.Request(), host)
+	if errors.Is(err, sshutil.ErrUnknownProfile) {
+		return c.String(http.StatusNotFound, "未知主机："+host)
+	}
+	if err != nil {
+		log.Printf("主机 %s 配置错误：%v", host, err)
+		return c.String(http.StatusInternalServerError, "主机配置错误")
+	}
+
+	// 客户端断开时 ctx 取消，关闭 SSH 连接以中断阻塞中的 SFTP 调用
+	ctx := c.Request().Context()
 
 	// 建立 SSH 连接
-	sshClient, err := ssh.Dial("tcp", "39.98.79.46:22", sshConfig)
+	sshClient, err := sshutil.DialContext(ctx, "tcp", sshAddr, sshConfig)
+	if _, ok := sshutil.AsHostKeyError(err); ok {
+		log.Printf("主机密钥校验失败：%v", err)
+		return c.String(http.StatusBadGateway, "主机密钥校验失败")
+	}
 	if err != nil {
 		log.Printf("建立 SSH 连接失败：%v", err)
 		return c.String(http.StatusInternalServerError, "建立 SSH 连接失败")
 	}
 	defer sshClient.Close()
+	stop := sshutil.CloseOnDone(ctx, sshClient)
+	defer stop()
 
 	// 创建 SFTP 客户端
 	sftpClient, err := sftp.NewClient(sshClient)
@@ -53,26 +76,54 @@ func DownloadSftpHandler(c echo.Context) error {
 	defer sftpClient.Close()
 
 	// 获取文件信息
-	fileInfo, err := sftpClient.Stat(remoteFilePath)
+	var fileInfo os.FileInfo
+	err = sshutil.Do(ctx, OpTimeout, sshClient, func() error {
+		var statErr error
+		fileInfo, statErr = sftpClient.Stat(remoteFilePath)
+		return statErr
+	})
 	if err != nil {
 		log.Printf("获取文件信息失败：%v", err)
 		return c.String(http.StatusInternalServerError, "获取文件信息失败")
 	}
 
+	// 获取文件名作为下载时的文件名
+	filename := path.Base(remoteFilePath)
+	if filename == "" {
+		filename = "downloaded_file"
+	}
+
+	// 缓存中的文件与远程 mtime、大小一致时直接从本地返回；缓存按 profile 和 SSH 账号区分，
+	// 命中时再按条目所属的主机检查一次下载权限
+	owner := cacheOwner{host: host, user: sshConfig.User}
+	key := cacheKey(owner, sshAddr, remoteFilePath)
+	if cache.enabled() {
+		if f, cached := cache.lookup(key, fileInfo.Size(), fileInfo.ModTime()); f != nil {
+			defer f.Close()
+			if err := sshutil.Authorize(c.Request(), sshutil.CapDownload, cached.host); err != nil {
+				log.Printf("下载未授权：%v", err)
+				return c.String(http.StatusForbidden, "无权下载该主机上的文件")
+			}
+			cache.hits.Add(1)
+			serveCached(c, f, filename, fileInfo.ModTime())
+			return nil
+		}
+		cache.misses.Add(1)
+	}
+
 	// 打开远程文件
-	remoteFile, err := sftpClient.OpenFile(remoteFilePath, os.O_RDONLY)
+	var remoteFile *sftp.File
+	err = sshutil.Do(ctx, OpTimeout, sshClient, func() error {
+		var openErr error
+		remoteFile, openErr = sftpClient.OpenFile(remoteFilePath, os.O_RDONLY)
+		return openErr
+	})
 	if err != nil {
 		log.Printf("打开远程文件失败：%v", err)
 		return c.String(http.StatusInternalServerError, "打开远程文件失败")
 	}
 	defer remoteFile.Close()
 
-	// 获取文件名作为下载时的文件名
-	filename := path.Base(remoteFilePath)
-	if filename == "" {
-		filename = "downloaded_file"
-	}
-
 	// 设置响应头：通知浏览器以附件形式下载
 	c.Response().Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
 	// 设置通用的二进制数据流（或根据实际情况设置 Content-Type）
@@ -82,8 +133,21 @@ func DownloadSftpHandler(c echo.Context) error {
 	c.Response().Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
 	c.Response().WriteHeader(http.StatusOK)
 
-	// 将远程文件内容通过流式传输发送给客户端
-	if _, err := io.Copy(c.Response(), remoteFile); err != nil {
+	// 可缓存的文件在传输的同时写入缓存
+	var dst io.Writer = c.Response()
+	var cw *cacheWriter
+	if cache.cacheable(fileInfo.Size()) {
+		if cw = cache.newCacheWriter(key, owner, fileInfo.Size(), fileInfo.ModTime()); cw != nil {
+			dst = io.MultiWriter(c.Response(), cw)
+		}
+	}
+
+	// 将远程文件内容通过流式传输发送给客户端，长时间无进展或客户端断开时中断
+	_, err = sshutil.CopyContext(ctx, dst, remoteFile, IdleTimeout, sshClient)
+	if cw != nil {
+		cw.finish(cache, err == nil)
+	}
+	if err != nil {
 		log.Printf("传输文件内容失败：%v", err)
 		return c.String(http.StatusInternalServerError, "传输文件内容失败")
 	}
@@ -0,0 +1,199 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// -----------------------
+// 端到端加密：前端和 agent 通过 e2e_key 交换临时 X25519 公钥，按 HKDF-SHA256 派生 AES-256-GCM 密钥，
+// 之后消息的 d 字段为 base64(nonce || 密文)，x 字段为密钥 ID。
+// 公钥经 hub 转发，hub 可以替换双方的公钥做中间人，所以 agent 的响应用其长期 Ed25519 身份密钥
+// 对两个临时公钥和密钥 ID 签名，前端用预先固定的 agent 公钥验证签名（Verify）后才使用密钥。
+// agent 的身份公钥必须通过 hub 以外的途径（部署配置、人工核对指纹）分发给前端，从 hub 获取的公钥不可信；
+// 只有验证过签名，hub 才看不到明文和密钥。
+// 附加数据为 t、r、a、ch 四个字段，防止密文被挪到其它消息上。浏览器端用 WebCrypto 按同样的参数实现，
+// 本包供 Go 实现的 agent 和测试工具使用
+// -----------------------
+
+// Algorithm 目前唯一支持的算法
+const Algorithm = "x25519-hkdf-sha256-aes256gcm"
+
+// hkdfInfo 派生密钥时的 info，协议升级时修改
+const hkdfInfo = "go_ws_hub e2e v1"
+
+var (
+	ErrAlgorithm  = errors.New("e2e: unsupported algorithm")
+	ErrCiphertext = errors.New("e2e: malformed ciphertext")
+	ErrSignature  = errors.New("e2e: agent signature verification failed")
+)
+
+// KeyExchange e2e_key 请求和响应中的数据，Pub 为 base64 编码的 X25519 公钥
+type KeyExchange struct {
+	Alg   string `json:"alg"`
+	Pub   string `json:"pub"`
+	KeyID string `json:"keyId,omitempty"` // 响应中由 agent 填写，双方据此确认派生的是同一把密钥
+	Sig   string `json:"sig,omitempty"`   // 响应中由 agent 填写，base64 编码的 Ed25519 签名，见 transcript
+}
+
+// Identity agent 的长期签名密钥，前端固定其公钥
+type Identity struct {
+	priv ed25519.PrivateKey
+}
+
+// NewIdentity 使用已有的 Ed25519 私钥
+func NewIdentity(priv ed25519.PrivateKey) *Identity {
+	return &Identity{priv: priv}
+}
+
+// PublicKey 返回分发给前端固定的公钥
+func (id *Identity) PublicKey() ed25519.PublicKey {
+	return id.priv.Public().(ed25519.PublicKey)
+}
+
+// Fingerprint 公钥的 SHA-256 前 8 字节，用于人工核对
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// transcript 签名的内容：协议版本、前端公钥、agent 公钥和密钥 ID，替换任意一方的公钥都会使签名失效
+func transcript(offerPub, respPub, keyID string) []byte {
+	return []byte(hkdfInfo + "\x00" + offerPub + "\x00" + respPub + "\x00" + keyID)
+}
+
+// KeyPair 一次密钥交换使用的临时密钥
+type KeyPair struct {
+	priv *ecdh.PrivateKey
+}
+
+// GenerateKey 生成临时密钥
+func GenerateKey() (*KeyPair, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{priv: priv}, nil
+}
+
+// Offer 返回发给对端的公钥
+func (k *KeyPair) Offer() KeyExchange {
+	return KeyExchange{Alg: Algorithm, Pub: base64.StdEncoding.EncodeToString(k.priv.PublicKey().Bytes())}
+}
+
+// Cipher 加解密 d 字段，并发安全
+type Cipher struct {
+	ID   string
+	aead cipher.AEAD
+}
+
+// Respond agent 处理前端的 offer：派生 Cipher，并返回带签名的响应
+func (k *KeyPair) Respond(offer KeyExchange, id *Identity) (KeyExchange, *Cipher, error) {
+	c, err := k.Derive(offer)
+	if err != nil {
+		return KeyExchange{}, nil, err
+	}
+	resp := k.Offer()
+	resp.KeyID = c.ID
+	resp.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(id.priv, transcript(offer.Pub, resp.Pub, c.ID)))
+	return resp, c, nil
+}
+
+// Verify 前端处理 agent 的响应：用固定的 agent 公钥验证签名后派生 Cipher，签名或密钥 ID 不符时返回 ErrSignature
+func (k *KeyPair) Verify(resp KeyExchange, agentKey ed25519.PublicKey) (*Cipher, error) {
+	sig, err := base64.StdEncoding.DecodeString(resp.Sig)
+	if err != nil || len(agentKey) != ed25519.PublicKeySize {
+		return nil, ErrSignature
+	}
+	c, err := k.Derive(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.KeyID != c.ID || !ed25519.Verify(agentKey, transcript(k.Offer().Pub, resp.Pub, resp.KeyID), sig) {
+		return nil, ErrSignature
+	}
+	return c, nil
+}
+
+// Derive 用对端的公钥派生 Cipher，双方调用得到相同的密钥和 ID；不验证对端身份，前端应使用 Verify
+func (k *KeyPair) Derive(peer KeyExchange) (*Cipher, error) {
+	if peer.Alg != Algorithm {
+		return nil, ErrAlgorithm
+	}
+	raw, err := base64.StdEncoding.DecodeString(peer.Pub)
+	if err != nil {
+		return nil, err
+	}
+	peerKey, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := k.priv.ECDH(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	// salt 为两个公钥按字节序拼接，与调用方是哪一端无关
+	pubs := [][]byte{k.priv.PublicKey().Bytes(), raw}
+	if bytes.Compare(pubs[0], pubs[1]) > 0 {
+		pubs[0], pubs[1] = pubs[1], pubs[0]
+	}
+	salt := append(append([]byte{}, pubs[0]...), pubs[1]...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(hkdfInfo)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(salt)
+	return &Cipher{ID: hex.EncodeToString(id[:8]), aead: aead}, nil
+}
+
+// AAD 消息的附加数据
+func AAD(msgType, requestID, action, channel string) []byte {
+	return []byte(msgType + "\x00" + requestID + "\x00" + action + "\x00" + channel)
+}
+
+// Seal 把 v 编码为 JSON 后加密，返回 d 字段的值
+func (c *Cipher) Seal(v interface{}, aad []byte) (string, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plain, aad)), nil
+}
+
+// Open 解密 d 字段并解析到 v
+func (c *Cipher) Open(data string, aad []byte, v interface{}) error {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return ErrCiphertext
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, raw[:n], raw[n:], aad)
+	if err != nil {
+		return ErrCiphertext
+	}
+	return json.Unmarshal(plain, v)
+}
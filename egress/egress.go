@@ -0,0 +1,256 @@
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------
+// 中继流量外送：把中继的消息（可按 action 过滤）镜像发布到 NATS 或 Kafka，供分析和合规系统消费，不需要在 hub 上抓包。
+// NATS 的主题为 {Subject}.{token}.{up|down}；Kafka 写入 Subject 指定的 topic，消息 key 为 token，方向放在 dir 头中。
+// 镜像是旁路的：Mirror 只把消息放入队列，队列满时丢弃，后台按批发布，失败重试 MaxRetries 次后丢弃该批。
+// 没有引入外部库，NATS 只实现了 CONNECT/PUB/PING，Kafka 只实现了 Metadata v1 和 Produce v3（不压缩，acks=1）
+// -----------------------
+
+// 驱动
+const (
+	DriverNATS  = "nats"
+	DriverKafka = "kafka"
+)
+
+// 消息方向
+const (
+	DirectionUp   = "up"   // 前端 -> agent
+	DirectionDown = "down" // agent -> 前端
+)
+
+// Config Driver 为空表示不启用
+type Config struct {
+	Driver         string   `json:"driver"`
+	Addrs          []string `json:"addrs"`             // NATS 服务器或 Kafka bootstrap broker 的 host:port，依次尝试
+	Subject        string   `json:"subject,omitempty"` // NATS 主题前缀（默认 "wshub"）或 Kafka topic（必填）
+	Actions        []string `json:"actions,omitempty"` // 只镜像这些 action，为空表示全部
+	Username       string   `json:"username,omitempty"`
+	Password       string   `json:"password,omitempty"`
+	AuthToken      string   `json:"authToken,omitempty"` // NATS 的 auth_token
+	QueueSize      int      `json:"queueSize"`
+	BatchSize      int      `json:"batchSize"`
+	MaxRetries     int      `json:"maxRetries"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+}
+
+// Enabled 是否配置了驱动
+func (cfg Config) Enabled() bool {
+	return cfg.Driver != ""
+}
+
+// Message 一条镜像的消息
+type Message struct {
+	Token     string
+	Direction string
+	Action    string
+	Data      []byte
+	Time      time.Time
+}
+
+// publisher 由驱动实现，只在 Bridge 的发送协程中调用
+type publisher interface {
+	publish(msgs []Message) error
+	close()
+}
+
+// Stats 累计计数，Queued 为当前队列长度
+type Stats struct {
+	Published int64 `json:"published"`
+	Dropped   int64 `json:"dropped"` // 队列满或重试后仍失败而丢弃的消息
+	Failures  int64 `json:"failures"`
+	Queued    int64 `json:"queued"`
+}
+
+// Bridge 并发安全
+type Bridge struct {
+	cfg     Config
+	pub     publisher
+	actions map[string]bool
+	queue   chan Message
+
+	published atomic.Int64
+	dropped   atomic.Int64
+	failures  atomic.Int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// New 检查配置并启动发送协程，不会立即连接服务器
+func New(cfg Config) (*Bridge, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("egress: addrs is required")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 10
+	}
+	b := &Bridge{
+		cfg:     cfg,
+		queue:   make(chan Message, cfg.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	switch cfg.Driver {
+	case DriverNATS:
+		if b.cfg.Subject == "" {
+			b.cfg.Subject = "wshub"
+		}
+		b.pub = newNATSPublisher(b.cfg)
+	case DriverKafka:
+		if cfg.Subject == "" {
+			return nil, errors.New("egress: kafka topic (subject) is required")
+		}
+		b.pub = newKafkaPublisher(b.cfg)
+	default:
+		return nil, fmt.Errorf("egress: unknown driver %q", cfg.Driver)
+	}
+	if len(cfg.Actions) > 0 {
+		b.actions = make(map[string]bool, len(cfg.Actions))
+		for _, a := range cfg.Actions {
+			b.actions[a] = true
+		}
+	}
+	go b.run()
+	return b, nil
+}
+
+// Wants 判断 action 是否需要镜像
+func (b *Bridge) Wants(action string) bool {
+	return b.actions == nil || b.actions[action]
+}
+
+// Mirror 把消息放入发送队列，队列满或已关闭时丢弃并返回 false；data 会被复制
+func (b *Bridge) Mirror(token, direction, action string, data []byte) bool {
+	if !b.Wants(action) {
+		return false
+	}
+	msg := Message{
+		Token:     token,
+		Direction: direction,
+		Action:    action,
+		Data:      append([]byte(nil), data...),
+		Time:      time.Now(),
+	}
+	select {
+	case <-b.done:
+		return false
+	default:
+	}
+	select {
+	case b.queue <- msg:
+		return true
+	default:
+		b.dropped.Add(1)
+		return false
+	}
+}
+
+// Stats 返回累计计数
+func (b *Bridge) Stats() Stats {
+	return Stats{
+		Published: b.published.Load(),
+		Dropped:   b.dropped.Load(),
+		Failures:  b.failures.Load(),
+		Queued:    int64(len(b.queue)),
+	}
+}
+
+// Close 发布队列中剩余的消息后断开连接
+func (b *Bridge) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		<-b.stopped
+		b.pub.close()
+	})
+}
+
+func (b *Bridge) run() {
+	defer close(b.stopped)
+	batch := make([]Message, 0, b.cfg.BatchSize)
+	for {
+		select {
+		case msg := <-b.queue:
+			batch = append(batch[:0], msg)
+		case <-b.done:
+			b.drain(batch[:0])
+			return
+		}
+		batch = b.fill(batch)
+		b.send(batch)
+	}
+}
+
+// fill 不等待地从队列中取出更多消息，直到 BatchSize
+func (b *Bridge) fill(batch []Message) []Message {
+	for len(batch) < b.cfg.BatchSize {
+		select {
+		case msg := <-b.queue:
+			batch = append(batch, msg)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// drain 关闭时发布队列中剩余的消息，不再重试
+func (b *Bridge) drain(batch []Message) {
+	for {
+		batch = b.fill(batch[:0])
+		if len(batch) == 0 {
+			return
+		}
+		if err := b.pub.publish(batch); err != nil {
+			b.failures.Add(1)
+			b.dropped.Add(int64(len(b.queue) + len(batch)))
+			log.Println("Egress flush error:", err)
+			return
+		}
+		b.published.Add(int64(len(batch)))
+	}
+}
+
+func (b *Bridge) send(batch []Message) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := b.pub.publish(batch)
+		if err == nil {
+			b.published.Add(int64(len(batch)))
+			return
+		}
+		b.failures.Add(1)
+		if attempt >= b.cfg.MaxRetries {
+			b.dropped.Add(int64(len(batch)))
+			log.Printf("Egress publish error, dropped %d messages: %v", len(batch), err)
+			return
+		}
+		log.Println("Egress publish error, retrying:", err)
+		select {
+		case <-time.After(backoff):
+		case <-b.done:
+			b.dropped.Add(int64(len(batch)))
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
@@ -0,0 +1,406 @@
+package egress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Kafka 协议：启动时用 Metadata v1 查询 topic 的分区和 leader，按 key 的 murmur2 选择分区（与 Java 客户端的默认分区器一致），
+// 每个 leader 发送一个 Produce v3 请求，消息格式为 record batch v2。出错时丢弃缓存的元数据，下次发布前重新查询
+
+const (
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+
+	kafkaClientID = "go_ws_hub"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaError broker 返回的错误码
+type KafkaError struct {
+	Code      int16
+	Topic     string
+	Partition int32
+}
+
+func (e *KafkaError) Error() string {
+	return fmt.Sprintf("kafka: topic %s partition %d: error code %d", e.Topic, e.Partition, e.Code)
+}
+
+type kafkaBroker struct {
+	addr string
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type kafkaPublisher struct {
+	cfg           Config
+	correlationID int32
+
+	brokers map[int32]*kafkaBroker
+	leaders []int32 // 分区号 -> leader 的 node id，为空表示需要查询元数据
+}
+
+func newKafkaPublisher(cfg Config) *kafkaPublisher {
+	return &kafkaPublisher{cfg: cfg, brokers: make(map[int32]*kafkaBroker)}
+}
+
+func (p *kafkaPublisher) timeout() time.Duration {
+	return time.Duration(p.cfg.TimeoutSeconds) * time.Second
+}
+
+// kafkaEncoder 按 Kafka 协议的大端格式追加字段
+type kafkaEncoder struct{ b []byte }
+
+func (e *kafkaEncoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+func (e *kafkaEncoder) varint(v int64) {
+	e.b = binary.AppendVarint(e.b, v)
+}
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// varbytes record 中的变长字段，nil 编码为 -1
+func (e *kafkaEncoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// kafkaDecoder 读取响应，越界时记录错误，之后的读取都返回零值
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if v := d.take(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if v := d.take(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) skip(n int) { d.take(n) }
+
+// request 发送请求并返回去掉 correlation id 后的响应体
+func (p *kafkaPublisher) request(b *kafkaBroker, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	if b.conn == nil {
+		conn, err := net.DialTimeout("tcp", b.addr, p.timeout())
+		if err != nil {
+			return nil, err
+		}
+		b.conn, b.r = conn, bufio.NewReader(conn)
+	}
+	p.correlationID++
+	var e kafkaEncoder
+	e.int32(0) // 长度，最后回填
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(p.correlationID)
+	e.string(kafkaClientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+
+	_ = b.conn.SetDeadline(time.Now().Add(p.timeout()))
+	resp, err := func() ([]byte, error) {
+		if _, err := b.conn.Write(e.b); err != nil {
+			return nil, err
+		}
+		var size [4]byte
+		if _, err := io.ReadFull(b.r, size[:]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(b.r, resp); err != nil {
+			return nil, err
+		}
+		if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != p.correlationID {
+			return nil, errors.New("kafka: correlation id mismatch")
+		}
+		return resp[4:], nil
+	}()
+	if err != nil {
+		b.conn.Close()
+		b.conn, b.r = nil, nil
+	}
+	return resp, err
+}
+
+// refreshMetadata 依次向 bootstrap broker 查询 topic 的 broker 列表和分区 leader
+func (p *kafkaPublisher) refreshMetadata() error {
+	var body kafkaEncoder
+	body.int32(1)
+	body.string(p.cfg.Subject)
+	var lastErr error
+	for _, addr := range p.cfg.Addrs {
+		bootstrap := &kafkaBroker{addr: addr}
+		resp, err := p.request(bootstrap, kafkaAPIMetadata, 1, body.b)
+		if bootstrap.conn != nil {
+			bootstrap.conn.Close()
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return p.parseMetadata(resp)
+	}
+	return lastErr
+}
+
+func (p *kafkaPublisher) parseMetadata(resp []byte) error {
+	d := &kafkaDecoder{b: resp}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		if rack := d.int16(); rack > 0 {
+			d.skip(int(rack))
+		}
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+	var leaders []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := d.int16()
+		name := d.string()
+		d.skip(1) // is_internal
+		partitions := make(map[int32]int32)
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			d.int16() // 分区错误码，leader 不可用时 leader 为 -1
+			index := d.int32()
+			partitions[index] = d.int32()
+			d.skip(4 * int(d.int32())) // replicas
+			d.skip(4 * int(d.int32())) // isr
+		}
+		if name != p.cfg.Subject {
+			continue
+		}
+		if code != 0 {
+			return &KafkaError{Code: code, Topic: name, Partition: -1}
+		}
+		leaders = make([]int32, len(partitions))
+		for index, leader := range partitions {
+			if int(index) >= len(leaders) || index < 0 {
+				return fmt.Errorf("kafka: topic %s has non-contiguous partitions", name)
+			}
+			leaders[index] = leader
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: metadata response: %w", d.err)
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("kafka: topic %s not found", p.cfg.Subject)
+	}
+	for id, addr := range brokers {
+		if b := p.brokers[id]; b != nil && b.addr == addr {
+			continue
+		}
+		if b := p.brokers[id]; b != nil && b.conn != nil {
+			b.conn.Close()
+		}
+		p.brokers[id] = &kafkaBroker{addr: addr}
+	}
+	p.leaders = leaders
+	return nil
+}
+
+// murmur2 与 Java 客户端 Utils.murmur2 相同
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+func (p *kafkaPublisher) partition(key string) int32 {
+	return (murmur2([]byte(key)) & 0x7fffffff) % int32(len(p.leaders))
+}
+
+// recordBatch 编码 record batch v2，key 为 token，带 dir 和 action 两个头
+func recordBatch(msgs []Message) []byte {
+	first, last := msgs[0].Time.UnixMilli(), msgs[0].Time.UnixMilli()
+	for _, m := range msgs {
+		first, last = min(first, m.Time.UnixMilli()), max(last, m.Time.UnixMilli())
+	}
+	var records kafkaEncoder
+	for i, m := range msgs {
+		var rec kafkaEncoder
+		rec.int8(0) // attributes
+		rec.varint(m.Time.UnixMilli() - first)
+		rec.varint(int64(i))
+		rec.varbytes([]byte(m.Token))
+		rec.varbytes(m.Data)
+		rec.varint(2)
+		rec.varbytes([]byte("dir"))
+		rec.varbytes([]byte(m.Direction))
+		rec.varbytes([]byte("action"))
+		rec.varbytes([]byte(m.Action))
+		records.varint(int64(len(rec.b)))
+		records.b = append(records.b, rec.b...)
+	}
+
+	// crc 覆盖 attributes 到末尾
+	var tail kafkaEncoder
+	tail.int16(0) // attributes：不压缩
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(first)
+	tail.int64(last)
+	tail.int64(-1) // producer id
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(msgs)))
+	tail.b = append(tail.b, records.b...)
+
+	var e kafkaEncoder
+	e.int64(0)                              // base offset
+	e.int32(int32(4 + 1 + 4 + len(tail.b))) // batch length：partition leader epoch 到末尾
+	e.int32(-1)                             // partition leader epoch
+	e.int8(2)                               // magic
+	e.int32(int32(crc32.Checksum(tail.b, crc32c)))
+	e.b = append(e.b, tail.b...)
+	return e.b
+}
+
+func (p *kafkaPublisher) publish(msgs []Message) error {
+	if p.leaders == nil {
+		if err := p.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+	// 按 leader 和分区分组
+	byLeader := make(map[int32]map[int32][]Message)
+	for _, m := range msgs {
+		part := p.partition(m.Token)
+		leader := p.leaders[part]
+		if byLeader[leader] == nil {
+			byLeader[leader] = make(map[int32][]Message)
+		}
+		byLeader[leader][part] = append(byLeader[leader][part], m)
+	}
+	for leader, parts := range byLeader {
+		if err := p.produce(leader, parts); err != nil {
+			p.leaders = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kafkaPublisher) produce(leader int32, parts map[int32][]Message) error {
+	b := p.brokers[leader]
+	if b == nil {
+		return fmt.Errorf("kafka: no leader for topic %s", p.cfg.Subject)
+	}
+	var body kafkaEncoder
+	body.int16(-1) // transactional id
+	body.int16(1)  // acks
+	body.int32(int32(p.timeout() / time.Millisecond))
+	body.int32(1)
+	body.string(p.cfg.Subject)
+	body.int32(int32(len(parts)))
+	for part, msgs := range parts {
+		body.int32(part)
+		body.bytes(recordBatch(msgs))
+	}
+	resp, err := p.request(b, kafkaAPIProduce, 3, body.b)
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{b: resp}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		topic := d.string()
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			part := d.int32()
+			if code := d.int16(); code != 0 {
+				return &KafkaError{Code: code, Topic: topic, Partition: part}
+			}
+			d.skip(16) // base offset, log append time
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: produce response: %w", d.err)
+	}
+	return nil
+}
+
+func (p *kafkaPublisher) close() {
+	for _, b := range p.brokers {
+		if b.conn != nil {
+			b.conn.Close()
+			b.conn, b.r = nil, nil
+		}
+	}
+}
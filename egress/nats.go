@@ -0,0 +1,162 @@
+package egress
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// NATS 客户端协议：连接后服务端先发 INFO，客户端发 CONNECT；每批 PUB 之后发 PING，收到 PONG 说明服务端已处理完该批
+
+type natsPublisher struct {
+	cfg  Config
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func newNATSPublisher(cfg Config) *natsPublisher {
+	return &natsPublisher{cfg: cfg}
+}
+
+func (p *natsPublisher) timeout() time.Duration {
+	return time.Duration(p.cfg.TimeoutSeconds) * time.Second
+}
+
+// connect 依次尝试 Addrs，成功完成 CONNECT/PING/PONG 握手的第一个地址生效
+func (p *natsPublisher) connect() error {
+	var lastErr error
+	for _, addr := range p.cfg.Addrs {
+		conn, err := net.DialTimeout("tcp", addr, p.timeout())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p.conn, p.r, p.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+		if err := p.handshake(); err != nil {
+			p.close()
+			lastErr = fmt.Errorf("nats %s: %w", addr, err)
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+func (p *natsPublisher) handshake() error {
+	_ = p.conn.SetDeadline(time.Now().Add(p.timeout()))
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	_ = json.Unmarshal([]byte(line[len("INFO "):]), &info)
+	if info.TLSRequired {
+		return errors.New("server requires tls, which is not supported")
+	}
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "go_ws_hub",
+		"lang":     "go",
+		"version":  "1.0",
+		"protocol": 1,
+	}
+	if p.cfg.Username != "" {
+		opts["user"], opts["pass"] = p.cfg.Username, p.cfg.Password
+	}
+	if p.cfg.AuthToken != "" {
+		opts["auth_token"] = p.cfg.AuthToken
+	}
+	connect, _ := json.Marshal(opts)
+	p.w.WriteString("CONNECT ")
+	p.w.Write(connect)
+	p.w.WriteString("\r\nPING\r\n")
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	return p.waitPong()
+}
+
+func (p *natsPublisher) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// waitPong 读取到 PONG 为止，期间回应服务端的 PING，收到 -ERR 时返回错误
+func (p *natsPublisher) waitPong() error {
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			p.w.WriteString("PONG\r\n")
+			if err := p.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(line[len("-ERR"):]))
+		}
+	}
+}
+
+// natsSubject {Subject}.{token}.{direction}，token 中的分隔符和通配符替换为下划线
+func natsSubject(prefix string, m Message) string {
+	token := strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, m.Token)
+	if token == "" {
+		token = "_"
+	}
+	return prefix + "." + token + "." + m.Direction
+}
+
+func (p *natsPublisher) publish(msgs []Message) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	_ = p.conn.SetDeadline(time.Now().Add(p.timeout()))
+	for _, m := range msgs {
+		fmt.Fprintf(p.w, "PUB %s %d\r\n", natsSubject(p.cfg.Subject, m), len(m.Data))
+		p.w.Write(m.Data)
+		p.w.WriteString("\r\n")
+	}
+	p.w.WriteString("PING\r\n")
+	err := p.w.Flush()
+	if err == nil {
+		err = p.waitPong()
+	}
+	if err != nil {
+		p.close()
+	}
+	return err
+}
+
+func (p *natsPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.r, p.w = nil, nil, nil
+	}
+}
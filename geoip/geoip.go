@@ -0,0 +1,201 @@
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// GeoIP 查询：从 CSV 文件加载 IP 段到国家和 ASN 的映射，按起始地址排序后二分查找。
+// 每行格式为 network,country[,asn[,org]]，network 为 CIDR，# 开头的行和表头行忽略；
+// IP 段之间不能重叠。文件修改后按 ReloadSeconds 定期重新加载，加载失败时保留旧数据
+// -----------------------
+
+// Config File 为空表示不启用
+type Config struct {
+	File          string `json:"file"`
+	ReloadSeconds int    `json:"reloadSeconds"` // 0 表示不重新加载
+}
+
+// Enabled 是否配置了数据文件
+func (cfg Config) Enabled() bool {
+	return cfg.File != ""
+}
+
+// Location 查询结果，Country 为 ISO 3166 两位国家代码（大写）
+type Location struct {
+	Country string `json:"country,omitempty"`
+	ASN     int    `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+type ipRange struct {
+	start, end net.IP // 均为 16 字节形式
+	loc        Location
+}
+
+// DB 并发安全
+type DB struct {
+	cfg Config
+
+	mu      sync.RWMutex
+	ranges  []ipRange
+	modTime time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New 加载数据文件，配置了 ReloadSeconds 时启动后台检查
+func New(cfg Config) (*DB, error) {
+	db := &DB{cfg: cfg, stop: make(chan struct{})}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadSeconds > 0 {
+		go db.reloadLoop(time.Duration(cfg.ReloadSeconds) * time.Second)
+	}
+	return db, nil
+}
+
+func (db *DB) load() error {
+	f, err := os.Open(db.cfg.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ranges, err := parse(f)
+	if err != nil {
+		return fmt.Errorf("geoip %s: %w", db.cfg.File, err)
+	}
+	db.mu.Lock()
+	db.ranges = ranges
+	db.modTime = st.ModTime()
+	db.mu.Unlock()
+	return nil
+}
+
+func (db *DB) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+		}
+		st, err := os.Stat(db.cfg.File)
+		if err != nil {
+			continue
+		}
+		db.mu.RLock()
+		changed := !st.ModTime().Equal(db.modTime)
+		db.mu.RUnlock()
+		if changed {
+			_ = db.load()
+		}
+	}
+}
+
+// parse 读取 CSV，返回按起始地址排序的 IP 段
+func parse(r io.Reader) ([]ipRange, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	var ranges []ipRange
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected network,country", line)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(rec[0]))
+		if err != nil {
+			if line == 1 {
+				continue // 表头
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		loc := Location{Country: strings.ToUpper(strings.TrimSpace(rec[1]))}
+		if len(rec) > 2 && strings.TrimSpace(rec[2]) != "" {
+			asn := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rec[2])), "AS")
+			if loc.ASN, err = strconv.Atoi(asn); err != nil {
+				return nil, fmt.Errorf("line %d: invalid asn %q", line, rec[2])
+			}
+		}
+		if len(rec) > 3 {
+			loc.Org = strings.TrimSpace(rec[3])
+		}
+		ranges = append(ranges, ipRange{start: network.IP.To16(), end: lastIP(network), loc: loc})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].start, ranges[j].start) < 0 })
+	for i := 1; i < len(ranges); i++ {
+		if bytes.Compare(ranges[i].start, ranges[i-1].end) <= 0 {
+			return nil, errors.New("overlapping networks " + ranges[i-1].start.String() + " and " + ranges[i].start.String())
+		}
+	}
+	return ranges, nil
+}
+
+// lastIP 网段的最后一个地址
+func lastIP(n *net.IPNet) net.IP {
+	ip := n.IP.To16()
+	mask := n.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+	}
+	end := make(net.IP, net.IPv6len)
+	for i := range ip {
+		end[i] = ip[i] | ^mask[i]
+	}
+	return end
+}
+
+// Lookup 查询 IP 所在的网段
+func (db *DB) Lookup(ip net.IP) (Location, bool) {
+	key := ip.To16()
+	if key == nil {
+		return Location{}, false
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	// 第一个起始地址大于 ip 的网段的前一个
+	i := sort.Search(len(db.ranges), func(i int) bool { return bytes.Compare(db.ranges[i].start, key) > 0 }) - 1
+	if i < 0 || bytes.Compare(key, db.ranges[i].end) > 0 {
+		return Location{}, false
+	}
+	return db.ranges[i].loc, true
+}
+
+// Len 已加载的网段数
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.ranges)
+}
+
+// Close 停止后台重新加载
+func (db *DB) Close() {
+	db.once.Do(func() { close(db.stop) })
+}
@@ -0,0 +1,235 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 访问时间窗：按 token 或身份主体限制可以连接的时间段（比如外包人员只能在工作日 09:00–18:00 连接）。
+// 窗口外的连接在升级前以 403 拒绝；已连接的前端在窗口结束前 Warning 收到 access_window_closing，
+// 结束时收到 access_window_closed 后被关闭。token 和主体都配置了时间窗时两者都要满足
+// -----------------------
+
+const (
+	ActionAccessWindowClosing = "access_window_closing" // hub -> 前端，d 为 {"closesAt": ...}
+	ActionAccessWindowClosed  = "access_window_closed"
+)
+
+// AccessWindow Start、End 为 "HH:MM"，End 不晚于 Start 表示跨过午夜；Days 为 mon..sun，为空表示每天，按开始的那天判断
+type AccessWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// AccessSchedule 满足任意一个窗口即可连接，Timezone 为空时使用本地时区
+type AccessSchedule struct {
+	Timezone string         `json:"timezone,omitempty"`
+	Windows  []AccessWindow `json:"windows"`
+}
+
+// AccessWindowConfig Tokens、Subjects 都为空时不限制
+type AccessWindowConfig struct {
+	Tokens   map[string]AccessSchedule `json:"tokens,omitempty"`
+	Subjects map[string]AccessSchedule `json:"subjects,omitempty"`
+	Warning  Duration                  `json:"warning"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func (cfg AccessWindowConfig) validate() error {
+	if cfg.Warning.D() < 0 {
+		return errors.New("accessWindows.warning must not be negative")
+	}
+	for token, sched := range cfg.Tokens {
+		if err := sched.validate(); err != nil {
+			return fmt.Errorf("accessWindows token %s: %w", token, err)
+		}
+	}
+	for subject, sched := range cfg.Subjects {
+		if err := sched.validate(); err != nil {
+			return fmt.Errorf("accessWindows subject %s: %w", subject, err)
+		}
+	}
+	return nil
+}
+
+func (sched AccessSchedule) validate() error {
+	if _, err := time.LoadLocation(sched.Timezone); err != nil {
+		return err
+	}
+	if len(sched.Windows) == 0 {
+		return errors.New("no windows")
+	}
+	for _, w := range sched.Windows {
+		if _, err := parseClock(w.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(w.End); err != nil {
+			return err
+		}
+		for _, d := range w.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("unknown day %q", d)
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock 把 "HH:MM" 转为距离零点的时长
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// onDay 窗口是否在 day 这天开始
+func (w AccessWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// openUntil 返回 now 所在窗口的结束时间，不在任何窗口内时 ok 为 false；相邻的窗口不合并，结束时重新检查
+func (sched AccessSchedule) openUntil(now time.Time) (end time.Time, ok bool) {
+	loc, _ := time.LoadLocation(sched.Timezone)
+	now = now.In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for _, w := range sched.Windows {
+		start, _ := parseClock(w.Start)
+		stop, _ := parseClock(w.End)
+		overnight := stop <= start
+		// 今天开始的窗口
+		if w.onDay(now.Weekday()) && !now.Before(midnight.Add(start)) {
+			closes := midnight.Add(stop)
+			if overnight {
+				closes = midnight.AddDate(0, 0, 1).Add(stop)
+			}
+			if now.Before(closes) && closes.After(end) {
+				end, ok = closes, true
+			}
+		}
+		// 昨天开始、跨过午夜的窗口
+		yesterday := midnight.AddDate(0, 0, -1)
+		if overnight && w.onDay(yesterday.Weekday()) {
+			if closes := midnight.Add(stop); now.Before(closes) && closes.After(end) {
+				end, ok = closes, true
+			}
+		}
+	}
+	return end, ok
+}
+
+// accessSchedules 返回适用于 token 和主体的时间窗
+func accessSchedules(token, subject string) []AccessSchedule {
+	var out []AccessSchedule
+	if sched, ok := hubConfig.AccessWindows.Tokens[token]; ok {
+		out = append(out, sched)
+	}
+	if sched, ok := hubConfig.AccessWindows.Subjects[subject]; ok && subject != "" {
+		out = append(out, sched)
+	}
+	return out
+}
+
+var errOutsideAccessWindow = errors.New("outside of the allowed access window")
+
+// accessWindowEnd 检查 now 是否在时间窗内，返回最早结束的时间；limited 为 false 表示不受时间窗限制
+func accessWindowEnd(token, subject string, now time.Time) (end time.Time, limited bool, err error) {
+	for _, sched := range accessSchedules(token, subject) {
+		closes, ok := sched.openUntil(now)
+		if !ok {
+			return time.Time{}, true, errOutsideAccessWindow
+		}
+		if !limited || closes.Before(end) {
+			end = closes
+		}
+		limited = true
+	}
+	return end, limited, nil
+}
+
+// rejectAccessWindow 连接不在时间窗内时返回 403
+func rejectAccessWindow(c echo.Context, ident *Identity) error {
+	hubMetrics.Inc("hub_access_window_rejections_total")
+	log.Printf("Reject client %s for token %s: %v", ident.Subject, ident.Token, errOutsideAccessWindow)
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error":   "outside_access_window",
+		"message": "Connections are not allowed at this time",
+	})
+}
+
+// watchAccessWindow 为受时间窗限制的前端安排结束前的提醒和结束时的关闭
+func (s *RelaySession) watchAccessWindow(client *wsClientConn) {
+	subject := ""
+	if client.ident != nil {
+		subject = client.ident.Subject
+	}
+	end, limited, err := accessWindowEnd(s.token, subject, time.Now())
+	if !limited {
+		return
+	}
+	if err != nil {
+		s.closeAccessWindow(client)
+		return
+	}
+	var timers []*time.Timer
+	if warn := time.Until(end) - hubConfig.AccessWindows.Warning.D(); hubConfig.AccessWindows.Warning.D() > 0 && warn > 0 {
+		timers = append(timers, time.AfterFunc(warn, func() {
+			s.notifyClient(client, WebSocketMessage{
+				Type:   MessageTypeNotify,
+				Action: ActionAccessWindowClosing,
+				Data:   map[string]interface{}{"closesAt": end},
+			})
+		}))
+	}
+	timers = append(timers, time.AfterFunc(time.Until(end), func() {
+		// 紧接着的下一个窗口已经开始时继续保持连接
+		s.watchAccessWindow(client)
+	}))
+	client.mu.Lock()
+	client.accessTimers = timers
+	client.mu.Unlock()
+}
+
+// closeAccessWindow 通知前端时间窗已结束并关闭连接
+func (s *RelaySession) closeAccessWindow(client *wsClientConn) {
+	hubMetrics.Inc("hub_access_window_closures_total")
+	log.Printf("Session %s client access window ended, closing", s.token)
+	s.notifyClient(client, WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: ActionAccessWindowClosed,
+		Data:   "Access window has ended",
+	})
+	client.closeWithCode(websocket.ClosePolicyViolation, "access window ended")
+}
+
+// stopAccessWindow 前端断开时停止时间窗定时器
+func (c *wsClientConn) stopAccessWindow() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.accessTimers {
+		t.Stop()
+	}
+	c.accessTimers = nil
+}
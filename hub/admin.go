@@ -0,0 +1,239 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"echo_demo/download"
+	"echo_demo/term"
+	"echo_demo/upload2"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 管理接口：通过 X-Admin-Token 请求头鉴权，未配置 AdminToken 时全部拒绝
+// -----------------------
+
+func adminAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Request().Header.Get("X-Admin-Token")
+		if hubConfig.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(hubConfig.AdminToken)) != 1 {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid or missing admin token",
+			})
+		}
+		return next(c)
+	}
+}
+
+// registerAdminRoutes 注册 /admin 下的全部管理接口
+func registerAdminRoutes(e *echo.Echo) {
+	adminGroup := e.Group("/admin")
+	adminGroup.Use(adminAuthMiddleware)
+	{
+		adminGroup.GET("/maintenance", GetMaintenanceHandler)
+		adminGroup.PUT("/maintenance", SetMaintenanceHandler)
+		adminGroup.GET("/chaos", GetChaosHandler)
+		adminGroup.PUT("/chaos", SetChaosHandler)
+		adminGroup.DELETE("/chaos", DeleteChaosHandler)
+		adminGroup.GET("/readonly", GetReadOnlyHandler)
+		adminGroup.PUT("/readonly", SetReadOnlyHandler)
+		adminGroup.GET("/sessions/usage", SessionUsageHandler)
+		adminGroup.GET("/sessions", ListSessionsHandler)
+		adminGroup.GET("/sessions/:token", GetSessionHandler)
+		adminGroup.DELETE("/sessions/:token", CloseSessionHandler)
+		adminGroup.GET("/sessions/:token/trace", GetFrameTraceHandler)
+		adminGroup.PUT("/sessions/:token/trace", StartFrameTraceHandler)
+		adminGroup.DELETE("/sessions/:token/trace", StopFrameTraceHandler)
+		adminGroup.GET("/fingerprints", ListFingerprintsHandler)
+		adminGroup.GET("/fingerprints/:subject", GetFingerprintHandler)
+		adminGroup.POST("/broadcast", BroadcastHandler)
+		adminGroup.GET("/outbox", ListOutboxHandler)
+		adminGroup.DELETE("/outbox/:token", PurgeOutboxHandler)
+		adminGroup.GET("/groups", ListGroupsAdminHandler)
+		adminGroup.POST("/groups/:name/publish", PublishGroupHandler)
+		adminGroup.GET("/features", ListFeaturesHandler)
+		adminGroup.PUT("/features/:name", SetFeatureHandler)
+		adminGroup.DELETE("/features/:name", UnsetFeatureHandler)
+		adminGroup.GET("/download/cache", download.CacheStatsHandler)
+		adminGroup.DELETE("/download/cache", download.CachePurgeHandler)
+		adminGroup.POST("/upload/blocks/gc", upload2.BlockGCHandler)
+		adminGroup.GET("/cleanup", GetCleanupHandler)
+		adminGroup.GET("/jobs", ListJobsHandler, jobsEnabled)
+		adminGroup.GET("/jobs/:id", GetJobHandler, jobsEnabled)
+		adminGroup.POST("/jobs/:id/cancel", CancelJobHandler, jobsEnabled)
+		adminGroup.POST("/jobs/:id/retry", RetryJobHandler, jobsEnabled)
+		adminGroup.GET("/term/sessions", term.ListTermSessionsHandler)
+		adminGroup.GET("/approvals", ListApprovalsHandler)
+		adminGroup.POST("/approvals/:id/approve", ApproveHandler)
+		adminGroup.POST("/approvals/:id/deny", DenyHandler)
+		adminGroup.GET("/term/sessions/:id", term.GetTermCwdHandler)
+		adminGroup.GET("/metrics/history", MetricsHistoryHandler)
+		adminGroup.GET("/metrics/history/names", MetricsHistoryNamesHandler)
+		adminGroup.GET("/alerts", ListAlertsHandler)
+		adminGroup.GET("/reports", ListReportsHandler)
+		adminGroup.POST("/reports", GenerateReportHandler)
+		adminGroup.GET("/reports/:name", GetReportHandler)
+		adminGroup.GET("/recordings", ListRecordingsHandler, recordingsEnabled)
+		adminGroup.POST("/recordings/import", ImportRecordingHandler, recordingsEnabled)
+		adminGroup.GET("/recordings/:id", GetRecordingHandler, recordingsEnabled)
+		adminGroup.GET("/recordings/:id/content", GetRecordingContentHandler, recordingsEnabled)
+		adminGroup.DELETE("/recordings/:id", DeleteRecordingHandler, recordingsEnabled)
+		adminGroup.GET("/search", SearchHandler, searchEnabled)
+		adminGroup.GET("/investigate", InvestigateHandler, searchEnabled)
+		adminGroup.GET("/bundle", ExportBundleHandler)
+		adminGroup.POST("/bundle/import", ImportBundleHandler)
+
+		adminGroup.GET("/users", ListUsersHandler, usersEnabled)
+		adminGroup.POST("/users", CreateUserHandler, usersEnabled)
+		adminGroup.GET("/users/:name", GetUserHandler, usersEnabled)
+		adminGroup.PUT("/users/:name", UpdateUserHandler, usersEnabled)
+		adminGroup.DELETE("/users/:name", DeleteUserHandler, usersEnabled)
+		adminGroup.GET("/users/:name/keys", ListAPIKeysHandler, usersEnabled)
+		adminGroup.POST("/users/:name/keys", CreateAPIKeyHandler, usersEnabled)
+		adminGroup.DELETE("/users/:name/keys/:id", DeleteAPIKeyHandler, usersEnabled)
+		adminGroup.POST("/users/:name/totp", EnrollTOTPHandler, usersEnabled)
+		adminGroup.DELETE("/users/:name/totp", DeleteTOTPHandler, usersEnabled)
+
+		adminGroup.GET("/inventory/hosts", ListHostsHandler)
+		adminGroup.POST("/inventory/hosts", PutHostHandler)
+		adminGroup.GET("/inventory/hosts/:id", GetHostHandler)
+		adminGroup.PUT("/inventory/hosts/:id", PutHostHandler)
+		adminGroup.DELETE("/inventory/hosts/:id", DeleteHostHandler)
+		adminGroup.GET("/inventory/agents", ListAgentsHandler)
+		adminGroup.POST("/inventory/agents", PutAgentHandler)
+		adminGroup.GET("/inventory/agents/:id", GetAgentHandler)
+		adminGroup.PUT("/inventory/agents/:id", PutAgentHandler)
+		adminGroup.DELETE("/inventory/agents/:id", DeleteAgentHandler)
+		adminGroup.GET("/inventory/groups", ListGroupsHandler)
+		adminGroup.PUT("/inventory/groups/:id", PutGroupHandler)
+		adminGroup.DELETE("/inventory/groups/:id", DeleteGroupHandler)
+	}
+}
+
+// SessionInfo 为管理接口中展示的会话信息
+type SessionInfo struct {
+	Token           string            `json:"token"`
+	ConnectedAt     time.Time         `json:"connectedAt"`
+	LastActivity    time.Time         `json:"lastActivity"`
+	Clients         int               `json:"clients"`
+	AgentState      string            `json:"agentState"` // connected / reconnecting / none
+	AgentID         string            `json:"agentId,omitempty"`
+	BytesFromClient int64             `json:"bytesFromClient"`
+	BytesFromAgent  int64             `json:"bytesFromAgent"`
+	MsgsFromClient  int64             `json:"messagesFromClient"`
+	MsgsFromAgent   int64             `json:"messagesFromAgent"`
+	Reconnects      int64             `json:"reconnects"`
+	Cohorts         map[string]string `json:"cohorts,omitempty"`
+	Origin          ClientOrigin      `json:"origin"`        // 第一个前端的来源
+	ClientOrigins   []ClientOrigin    `json:"clientOrigins"` // 当前各前端的来源
+	// 重发 notify 后仍未确认的前端数
+	UnhealthyClients int `json:"unhealthyClients,omitempty"`
+	// 发起过端到端加密密钥交换的前端数
+	E2EClients int `json:"e2eClients,omitempty"`
+	// hub 使用各能力的前端数
+	Capabilities map[string]int `json:"capabilities,omitempty"`
+}
+
+func (s *RelaySession) info() SessionInfo {
+	info := SessionInfo{
+		Token:           s.token,
+		ConnectedAt:     s.createdAt,
+		LastActivity:    s.lastActive(),
+		BytesFromClient: s.bytesFromClient.Load(),
+		BytesFromAgent:  s.bytesFromAgent.Load(),
+		MsgsFromClient:  s.msgsFromClient.Load(),
+		MsgsFromAgent:   s.msgsFromAgent.Load(),
+		Reconnects:      s.reconnects.Load(),
+		Cohorts:         s.cohorts,
+	}
+	info.AgentState, info.AgentID = s.agentState()
+	s.clientMu.Lock()
+	info.Clients = len(s.clients)
+	info.ClientOrigins = make([]ClientOrigin, 0, len(s.clients))
+	for _, c := range s.clients {
+		info.ClientOrigins = append(info.ClientOrigins, c.origin)
+		if c.notifyUnhealthy() {
+			info.UnhealthyClients++
+		}
+		if c.e2eKeyed() {
+			info.E2EClients++
+		}
+		for _, capability := range c.capabilities() {
+			if info.Capabilities == nil {
+				info.Capabilities = make(map[string]int)
+			}
+			info.Capabilities[capability]++
+		}
+	}
+	s.clientMu.Unlock()
+
+	s.stateMu.Lock()
+	info.Origin = s.origin
+	s.stateMu.Unlock()
+	return info
+}
+
+// agentState 返回 connected / reconnecting / none，以及已连接 agent 的 ID
+func (s *RelaySession) agentState() (string, string) {
+	s.stateMu.Lock()
+	reconnecting := s.agentReconnecting
+	s.stateMu.Unlock()
+	state, id := "none", ""
+	s.agentMu.Lock()
+	if s.agent != nil {
+		state, id = "connected", s.agent.id
+	}
+	s.agentMu.Unlock()
+	if reconnecting {
+		state = "reconnecting"
+	}
+	return state, id
+}
+
+// ListSessionsHandler 列出全部活动会话
+func ListSessionsHandler(c echo.Context) error {
+	infos := []SessionInfo{}
+	for _, sess := range relayHub.listSessions() {
+		infos = append(infos, sess.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return c.JSON(http.StatusOK, infos)
+}
+
+// GetSessionHandler 查询单个会话
+func GetSessionHandler(c echo.Context) error {
+	sess := relayHub.findSession(c.Param("token"))
+	if sess == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "session not found"})
+	}
+	return c.JSON(http.StatusOK, sess.info())
+}
+
+// CloseSessionHandler 强制关闭会话，关闭前通知全部前端
+func CloseSessionHandler(c echo.Context) error {
+	sess := relayHub.findSession(c.Param("token"))
+	if sess == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "session not found"})
+	}
+	sess.closeByAdmin("admin")
+	return c.JSON(http.StatusOK, sess.info())
+}
+
+// closeByAdmin 通知全部前端后关闭会话，by 为操作者
+func (s *RelaySession) closeByAdmin(by string) {
+	log.Printf("Session %s closed by %s", s.token, by)
+	s.sendNotify(WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: "session_closed",
+		Data:   "Session closed by administrator",
+	})
+	// 留出时间让通知写出后再关闭
+	s.setEndReason(EndReasonLogout)
+	time.AfterFunc(time.Second, s.cleanup)
+}
@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+)
+
+// -----------------------
+// 会话亲和：多个 hub 节点在负载均衡后面时，前端断线重连可能被分到另一个节点，而 agent 连接和续传缓存
+// 只在原节点上。开启后前端连接时 hub 在升级响应中下发 Cookie，值为本节点 ID，并在 hello 响应的 node 中返回；
+// 负载均衡按 Cookie（浏览器不便带 Cookie 时按前端重连 URL 上的 ?node= 参数）把重连路由回原节点，
+// 在 ClientResumeGrace 内接上原会话。重连落到其它节点时记录 hub_affinity_misses_total
+// -----------------------
+
+// defaultAffinityCookie 未配置 CookieName 时使用的 Cookie 名称
+const defaultAffinityCookie = "wshub_node"
+
+// AffinityConfig NodeID 为空时使用主机名，需要与负载均衡中配置的节点名一致；MaxAge 为 0 表示浏览器会话 Cookie
+type AffinityConfig struct {
+	Enabled    bool     `json:"enabled"`
+	NodeID     string   `json:"nodeId,omitempty"`
+	CookieName string   `json:"cookieName,omitempty"`
+	MaxAge     Duration `json:"maxAge,omitempty"`
+}
+
+func (cfg AffinityConfig) validate() error {
+	if cfg.MaxAge < 0 {
+		return errors.New("affinity.maxAge must not be negative")
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	cookie := http.Cookie{Name: cfg.cookieName(), Value: cfg.node()}
+	if err := cookie.Valid(); err != nil {
+		return errors.New("affinity: " + err.Error())
+	}
+	return nil
+}
+
+func (cfg AffinityConfig) cookieName() string {
+	if cfg.CookieName == "" {
+		return defaultAffinityCookie
+	}
+	return cfg.CookieName
+}
+
+// node 返回本节点 ID
+func (cfg AffinityConfig) node() string {
+	if cfg.NodeID != "" {
+		return cfg.NodeID
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// affinityNode 开启会话亲和时返回本节点 ID，用于 hello 响应
+func affinityNode() string {
+	if !hubConfig.Affinity.Enabled {
+		return ""
+	}
+	return hubConfig.Affinity.node()
+}
+
+// affinityHeader 在升级响应头中加上亲和 Cookie，请求已带有指向本节点的 Cookie 时不重复下发；
+// 需要在创建会话之前调用，以便判断重连是否落到了没有该会话的节点
+func affinityHeader(r *http.Request, token string, header http.Header) http.Header {
+	cfg := hubConfig.Affinity
+	if !cfg.Enabled {
+		return header
+	}
+	node := cfg.node()
+	current := ""
+	if cookie, err := r.Cookie(cfg.cookieName()); err == nil {
+		current = cookie.Value
+	}
+	hint := r.URL.Query().Get("node")
+	if hint == "" {
+		hint = current
+	}
+	if hint != "" && hint != node && !relayHub.hasSession(token) {
+		hubMetrics.Inc("hub_affinity_misses_total")
+		log.Printf("Session %s reconnect for node %s landed on %s", token, hint, node)
+	}
+	if current == node {
+		return header
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	cookie := &http.Cookie{
+		Name:     cfg.cookieName(),
+		Value:    node,
+		Path:     hubPath("/"),
+		MaxAge:   int(cfg.MaxAge.D().Seconds()),
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	header.Add("Set-Cookie", cookie.String())
+	return header
+}
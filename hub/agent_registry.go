@@ -0,0 +1,165 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 反向注册：agent 主动连接 hub 并按 token 登记，由 hub 与前端会话配对
+// -----------------------
+
+var (
+	errAgentNotRegistered = errors.New("agent did not register in time")
+	errMissingAgentKey    = errors.New("missing agent key")
+	errUnknownAgentKey    = errors.New("unknown agent key")
+)
+
+// AgentCredential 反向注册 agent 的密钥及其服务的会话 token，密钥与前端使用的 token 分开
+type AgentCredential struct {
+	Key   string `json:"key"`
+	Token string `json:"token"`
+}
+
+// authenticateAgent 识别反向注册的 agent，返回它服务的会话 token 和 agent ID。
+// agent 不接受前端凭据，只接受 MTLS.Identities 中标记为 agent 的证书，或 Agents、清单中登记的 agent 密钥
+// （放在 Sec-WebSocket-Protocol 或 Authorization: Bearer 中），会话 token 由凭据决定
+func authenticateAgent(r *http.Request) (token, agentID string, err error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if mapped, ok := hubConfig.MTLS.Identities[subject]; ok && mapped.Agent {
+			token = mapped.Token
+			if token == "" {
+				token = subject
+			}
+			return token, subject, nil
+		}
+	}
+	key := r.Header.Get("Sec-WebSocket-Protocol")
+	if key == "" {
+		key, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if key == "" {
+		return "", "", errMissingAgentKey
+	}
+	for id, a := range hubConfig.Agents {
+		if a.Key != "" && subtle.ConstantTimeCompare([]byte(a.Key), []byte(key)) == 1 {
+			return a.Token, id, nil
+		}
+	}
+	if a, ok := hubInventory.agentByKey(key); ok {
+		return a.Token, a.ID, nil
+	}
+	return "", "", errUnknownAgentKey
+}
+
+// HandleAgentConnection agent 通过 /agent/ws 连入，以 agent 密钥或 agent 证书认证（见 authenticateAgent），
+// agent_id 查询参数仅用于日志，agent 的标识取认证结果
+func HandleAgentConnection(c echo.Context) error {
+	token, agentID, err := authenticateAgent(c.Request())
+	if err != nil {
+		hubMetrics.Inc("hub_agent_auth_failures_total")
+		log.Printf("Agent auth error from %s: %v", c.RealIP(), err)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	if reported := c.QueryParam("agent_id"); reported != "" && reported != agentID {
+		log.Printf("Agent %s reported agent_id %q", agentID, reported)
+	}
+	if hubDraining.Load() {
+		return rejectDraining(c)
+	}
+	var respHeader http.Header
+	if protocol := c.Request().Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		respHeader = http.Header{"Sec-WebSocket-Protocol": []string{protocol}}
+	}
+	agentConn, err := upgrader.Upgrade(c.Response(), c.Request(), respHeader)
+	if err != nil {
+		log.Println("Agent upgrade error:", err)
+		return err
+	}
+	agent := &wsAgentConn{
+		id:       agentID,
+		conn:     agentConn,
+		send:     make(chan []byte, 1000),
+		sendHigh: priorityLane[[]byte](1000),
+		sendLow:  priorityLane[[]byte](1000),
+		overflow: hubConfig.AgentOverflowPolicy,
+	}
+	setupKeepalive(agentConn)
+	setupCompression(agentConn)
+	setAgentReadLimit(agentConn)
+	go agent.writePump()
+
+	log.Printf("Agent %q registered with token %s", agentID, token)
+	relayHub.registerAgent(token, agent)
+	return nil
+}
+
+// registerAgent 登记一个反向连接的 agent：
+// 若对应会话正在等待 agent 重连则直接交给会话，否则放入待配对表等前端连接并补发发件箱中的消息；
+// 启用 Redis 中继时改为桥接到 Redis
+func (h *RelayHub) registerAgent(token string, agent *wsAgentConn) {
+	// 启用 Redis 中继时 agent 的消息统一经 Redis 转发，前端可以连在任意节点
+	if hubRedis != nil {
+		go hubRedis.bridgeAgent(token, agent)
+		return
+	}
+	h.mu.Lock()
+	sess := h.sessions[token]
+	if sess == nil {
+		if old := h.agents[token]; old != nil {
+			log.Printf("Agent for token %s replaced by a new registration", token)
+			old.conn.Close()
+			old.closeSend()
+		}
+		h.agents[token] = agent
+		h.mu.Unlock()
+		// 没有会话时也先补发离线消息，响应留在连接中，前端连入后随中继送达
+		replayOutbox(token, agent)
+		return
+	}
+	h.mu.Unlock()
+	sess.offerAgent(agent)
+}
+
+// offerAgent 把新连入的 agent 交给会话，会话已有一个待接收的 agent 时关闭旧的保留新的
+func (s *RelaySession) offerAgent(agent *wsAgentConn) {
+	select {
+	case s.agentReady <- agent:
+	default:
+		select {
+		case old := <-s.agentReady:
+			old.conn.Close()
+			old.closeSend()
+		default:
+		}
+		s.agentReady <- agent
+	}
+}
+
+// takeAgent 取出 token 对应的已登记 agent，没有则返回 nil
+func (h *RelayHub) takeAgent(token string) *wsAgentConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	agent := h.agents[token]
+	delete(h.agents, token)
+	return agent
+}
+
+// waitAgent 等待反向连接的 agent 重新注册，超时或会话关闭时返回错误
+func (s *RelaySession) waitAgent(timeout time.Duration) (*wsAgentConn, error) {
+	select {
+	case agent := <-s.agentReady:
+		return agent, nil
+	case <-hubClock.After(timeout):
+		return nil, errAgentNotRegistered
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
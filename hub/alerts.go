@@ -0,0 +1,529 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 告警：每隔 Interval 按规则检查 hub 指标和目标主机状态，状态变化（触发、恢复）时通过通道发送，
+// 持续触发的告警每隔 RepeatInterval 重复发送一次。通道支持 webhook、email（见 notifier.go）、notify（推送给指定的管理会话）和 chat（见 chatops.go）
+// -----------------------
+
+// 告警规则类型
+const (
+	AlertAgentOffline      = "agent_offline"       // 清单中的 agent 离线超过 For
+	AlertReconnectStorm    = "reconnect_storm"     // For 内 agent 重连次数达到 Threshold
+	AlertUploadFailureRate = "upload_failure_rate" // For 内上传失败比例达到 Threshold（0-1），请求数不少于 MinCount 时才判断
+	AlertDiskUsage         = "disk_usage"          // 清单主机上 Path 所在磁盘使用率达到 Threshold（百分比）
+)
+
+// 告警通道类型
+const (
+	AlertChannelWebhook = "webhook"
+	AlertChannelEmail   = "email"
+	AlertChannelNotify  = "notify"
+	AlertChannelChat    = "chat"
+)
+
+// AlertRule 告警规则；Tokens、Hosts 为空时按 Groups 选择清单中的目标，Groups 也为空时选择全部
+type AlertRule struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	For       Duration `json:"for"`
+	Threshold float64  `json:"threshold"`
+	MinCount  int64    `json:"minCount,omitempty"`
+	Tokens    []string `json:"tokens,omitempty"` // agent_offline 的目标 token
+	Hosts     []string `json:"hosts,omitempty"`  // disk_usage 的目标主机 ID
+	Groups    []string `json:"groups,omitempty"`
+	Path      string   `json:"path,omitempty"` // disk_usage 检查的路径，默认 "/"
+	Severity  string   `json:"severity,omitempty"`
+	Channels  []string `json:"channels"`
+}
+
+// AlertChannel 告警通道，各类型只使用自己的字段
+type AlertChannel struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// webhook：POST Alert 的 JSON
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// email：通过 Config.SMTP 发送，To 为空时发送给 SMTP 的默认收件人
+	To []string `json:"to,omitempty"`
+
+	// notify：推送给这些 token 的会话，action 为 "alert"
+	Tokens []string `json:"tokens,omitempty"`
+
+	// chat：发送到 chatOps 中名为 Chat 的群
+	Chat string `json:"chat,omitempty"`
+}
+
+// AlertingConfig 没有规则时不启动告警
+type AlertingConfig struct {
+	Interval       Duration       `json:"interval"`
+	RepeatInterval Duration       `json:"repeatInterval"` // 0 表示持续触发时不重复发送
+	Rules          []AlertRule    `json:"rules,omitempty"`
+	Channels       []AlertChannel `json:"channels,omitempty"`
+}
+
+func (cfg AlertingConfig) validate() error {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	if cfg.Interval.D() <= 0 {
+		return fmt.Errorf("alerting.interval must be positive")
+	}
+	channels := make(map[string]bool)
+	for _, ch := range cfg.Channels {
+		switch ch.Type {
+		case AlertChannelWebhook:
+			if ch.URL == "" {
+				return fmt.Errorf("alert channel %q: url is required", ch.Name)
+			}
+		case AlertChannelEmail:
+			if !hubConfig.SMTP.Enabled() {
+				return fmt.Errorf("alert channel %q: smtp is not configured", ch.Name)
+			}
+		case AlertChannelNotify:
+			if len(ch.Tokens) == 0 {
+				return fmt.Errorf("alert channel %q: tokens are required", ch.Name)
+			}
+		case AlertChannelChat:
+			if _, ok := chatChannel(ch.Chat); !ok {
+				return fmt.Errorf("alert channel %q: unknown chatOps channel %q", ch.Name, ch.Chat)
+			}
+		default:
+			return fmt.Errorf("alert channel %q: unknown type %q", ch.Name, ch.Type)
+		}
+		channels[ch.Name] = true
+	}
+	names := make(map[string]bool)
+	for _, rule := range cfg.Rules {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("alert rule name %q is empty or duplicated", rule.Name)
+		}
+		names[rule.Name] = true
+		switch rule.Type {
+		case AlertAgentOffline, AlertReconnectStorm, AlertUploadFailureRate, AlertDiskUsage:
+		default:
+			return fmt.Errorf("alert rule %q: unknown type %q", rule.Name, rule.Type)
+		}
+		if rule.Type != AlertDiskUsage && rule.For.D() <= 0 {
+			return fmt.Errorf("alert rule %q: for must be positive", rule.Name)
+		}
+		for _, name := range rule.Channels {
+			if !channels[name] {
+				return fmt.Errorf("alert rule %q: unknown channel %q", rule.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Alert 发送给通道的告警，Target 为触发告警的 token、主机 ID，hub 级别的规则为 "hub"
+type Alert struct {
+	Rule     string    `json:"rule"`
+	Type     string    `json:"type"`
+	Severity string    `json:"severity,omitempty"`
+	Target   string    `json:"target"`
+	State    string    `json:"state"` // firing / resolved
+	Value    float64   `json:"value"`
+	Message  string    `json:"message"`
+	StartsAt time.Time `json:"startsAt"`
+	At       time.Time `json:"at"`
+}
+
+// alertResult 一次检查中一个目标的结果
+type alertResult struct {
+	target  string
+	firing  bool
+	value   float64
+	message string
+}
+
+// counterSample 计数器在某一时刻的值，用于计算窗口内的增量
+type counterSample struct {
+	at     time.Time
+	values []int64
+}
+
+type alerter struct {
+	cfg      AlertingConfig
+	channels map[string]AlertChannel
+	client   *http.Client
+
+	mu           sync.Mutex
+	active       map[string]*Alert          // rule/target -> 正在触发的告警
+	lastSent     map[string]time.Time       // rule/target -> 最后一次发送时间
+	offlineSince map[string]time.Time       // token -> 首次发现离线的时间
+	samples      map[string][]counterSample // rule -> 窗口内的计数器采样
+}
+
+// hubAlerter 为 nil 表示未配置告警
+var hubAlerter *alerter
+
+func newAlerter(cfg AlertingConfig) *alerter {
+	a := &alerter{
+		cfg:          cfg,
+		channels:     make(map[string]AlertChannel),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		active:       make(map[string]*Alert),
+		lastSent:     make(map[string]time.Time),
+		offlineSince: make(map[string]time.Time),
+		samples:      make(map[string][]counterSample),
+	}
+	for _, ch := range cfg.Channels {
+		a.channels[ch.Name] = ch
+	}
+	return a
+}
+
+// run 定时检查全部规则，hub 退出或 ctx 结束时停止
+func (a *alerter) run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval.D())
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if hubDraining.Load() {
+			return
+		}
+		a.evaluate(now)
+	}
+}
+
+func (a *alerter) evaluate(now time.Time) {
+	values := hubMetrics.Snapshot()
+	for _, rule := range a.cfg.Rules {
+		var results []alertResult
+		switch rule.Type {
+		case AlertAgentOffline:
+			results = a.checkAgentOffline(rule, now)
+		case AlertReconnectStorm:
+			results = a.checkReconnectStorm(rule, now, values)
+		case AlertUploadFailureRate:
+			results = a.checkUploadFailures(rule, now, values)
+		case AlertDiskUsage:
+			results = a.checkDiskUsage(rule)
+		}
+		for _, r := range results {
+			a.transition(rule, r, now)
+		}
+	}
+}
+
+// transition 根据检查结果更新告警状态，需要时发送
+func (a *alerter) transition(rule AlertRule, r alertResult, now time.Time) {
+	key := rule.Name + "/" + r.target
+	a.mu.Lock()
+	cur := a.active[key]
+	var send *Alert
+	switch {
+	case r.firing && cur == nil:
+		cur = &Alert{
+			Rule: rule.Name, Type: rule.Type, Severity: rule.Severity, Target: r.target,
+			State: "firing", StartsAt: now,
+		}
+		a.active[key] = cur
+		send = cur
+	case r.firing:
+		if repeat := a.cfg.RepeatInterval.D(); repeat > 0 && now.Sub(a.lastSent[key]) >= repeat {
+			send = cur
+		}
+	case cur != nil:
+		delete(a.active, key)
+		cur.State = "resolved"
+		send = cur
+	}
+	if cur != nil {
+		cur.Value, cur.Message, cur.At = r.value, r.message, now
+	}
+	var alert Alert
+	if send != nil {
+		alert = *send
+		a.lastSent[key] = now
+		if alert.State == "resolved" {
+			delete(a.lastSent, key)
+		}
+	}
+	a.mu.Unlock()
+
+	if send != nil {
+		hubMetrics.Inc("hub_alerts_total", "rule", rule.Name, "state", alert.State)
+		log.Printf("Alert %s %s on %s: %s", alert.Rule, alert.State, alert.Target, alert.Message)
+		go a.deliver(rule, alert)
+	}
+}
+
+// deliver 把告警发送到规则的全部通道，单个通道失败不影响其它通道
+func (a *alerter) deliver(rule AlertRule, alert Alert) {
+	for _, name := range rule.Channels {
+		ch := a.channels[name]
+		var err error
+		switch ch.Type {
+		case AlertChannelWebhook:
+			err = a.sendWebhook(ch, alert)
+		case AlertChannelEmail:
+			sendMail(MailEventAlert, alert.Rule+"/"+alert.Target+"/"+alert.State, ch.To, alert)
+		case AlertChannelNotify:
+			sendAlertNotify(ch, alert)
+		case AlertChannelChat:
+			err = sendAlertChat(ch, alert)
+		}
+		if err != nil {
+			hubMetrics.Inc("hub_alert_delivery_errors_total", "channel", name)
+			log.Printf("Alert channel %s error: %v", name, err)
+		}
+	}
+}
+
+func (a *alerter) sendWebhook(ch AlertChannel, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ch.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range ch.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendAlertNotify 推送给在线的管理会话，会话不在线时不补发
+func sendAlertNotify(ch AlertChannel, alert Alert) {
+	for _, token := range ch.Tokens {
+		if sess := relayHub.findSession(token); sess != nil {
+			sess.sendNotify(WebSocketMessage{Type: MessageTypeNotify, Action: "alert", Data: alert})
+		}
+	}
+}
+
+// ruleAgentTokens 选择规则检查的 agent token
+func ruleAgentTokens(rule AlertRule) []string {
+	if len(rule.Tokens) > 0 {
+		return rule.Tokens
+	}
+	hubInventory.mu.RLock()
+	defer hubInventory.mu.RUnlock()
+	var tokens []string
+	for _, agent := range hubInventory.data.Agents {
+		if len(rule.Groups) == 0 || matchAnyGroup(agent.Groups, rule.Groups) {
+			tokens = append(tokens, agent.Token)
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// ruleHosts 选择规则检查的主机
+func ruleHosts(rule AlertRule) []string {
+	if len(rule.Hosts) > 0 {
+		return rule.Hosts
+	}
+	hubInventory.mu.RLock()
+	defer hubInventory.mu.RUnlock()
+	var ids []string
+	for id, h := range hubInventory.data.Hosts {
+		if len(rule.Groups) == 0 || matchAnyGroup(h.Groups, rule.Groups) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func matchAnyGroup(groups, want []string) bool {
+	for _, g := range want {
+		if containsString(groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *alerter) checkAgentOffline(rule AlertRule, now time.Time) []alertResult {
+	var results []alertResult
+	for _, token := range ruleAgentTokens(rule) {
+		a.mu.Lock()
+		if relayHub.agentOnline(token) {
+			delete(a.offlineSince, token)
+			a.mu.Unlock()
+			results = append(results, alertResult{target: token})
+			continue
+		}
+		since, ok := a.offlineSince[token]
+		if !ok {
+			// 启动时已离线的 agent 从 hub 启动时开始计算
+			since = now
+			a.offlineSince[token] = since
+		}
+		a.mu.Unlock()
+		offline := now.Sub(since)
+		results = append(results, alertResult{
+			target:  token,
+			firing:  offline >= rule.For.D(),
+			value:   offline.Minutes(),
+			message: fmt.Sprintf("agent %s offline for %s", token, offline.Truncate(time.Second)),
+		})
+	}
+	return results
+}
+
+// window 记录规则窗口内的计数器采样，返回窗口起点以来各计数器的增量
+func (a *alerter) window(rule AlertRule, now time.Time, current ...int64) []int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	samples := append(a.samples[rule.Name], counterSample{at: now, values: current})
+	for len(samples) > 1 && now.Sub(samples[1].at) >= rule.For.D() {
+		samples = samples[1:]
+	}
+	a.samples[rule.Name] = samples
+	deltas := make([]int64, len(current))
+	for i := range current {
+		deltas[i] = current[i] - samples[0].values[i]
+	}
+	return deltas
+}
+
+func (a *alerter) checkReconnectStorm(rule AlertRule, now time.Time, values map[string]int64) []alertResult {
+	reconnects := a.window(rule, now, sumMetric(values, "hub_agent_reconnects_total"))[0]
+	return []alertResult{{
+		target:  "hub",
+		firing:  float64(reconnects) >= rule.Threshold,
+		value:   float64(reconnects),
+		message: fmt.Sprintf("%d agent reconnects in the last %s", reconnects, rule.For.D()),
+	}}
+}
+
+func (a *alerter) checkUploadFailures(rule AlertRule, now time.Time, values map[string]int64) []alertResult {
+	deltas := a.window(rule, now,
+		sumMetric(values, "hub_upload_requests_total"),
+		sumMetric(values, "hub_upload_requests_total", "result", "failed"))
+	total, failed := deltas[0], deltas[1]
+	var rate float64
+	if total > 0 {
+		rate = float64(failed) / float64(total)
+	}
+	return []alertResult{{
+		target:  "hub",
+		firing:  total > 0 && total >= rule.MinCount && rate >= rule.Threshold,
+		value:   rate,
+		message: fmt.Sprintf("%d of %d uploads failed in the last %s", failed, total, rule.For.D()),
+	}}
+}
+
+// checkDiskUsage 通过 SSH 执行 df 检查磁盘使用率，连接失败的主机保持原状态
+func (a *alerter) checkDiskUsage(rule AlertRule) []alertResult {
+	path := rule.Path
+	if path == "" {
+		path = "/"
+	}
+	var results []alertResult
+	for _, id := range ruleHosts(rule) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		res := execOnProfile(ctx, id, ExecRequest{Command: "df -P " + shellQuote(path) + " | tail -n 1"})
+		cancel()
+		if !res.ok() {
+			log.Printf("Alert rule %s: disk check on %s failed: %s", rule.Name, id, res.Error)
+			continue
+		}
+		used, err := parseDfUsage(res.Result.Stdout)
+		if err != nil {
+			log.Printf("Alert rule %s: disk check on %s: %v", rule.Name, id, err)
+			continue
+		}
+		results = append(results, alertResult{
+			target:  id,
+			firing:  used >= rule.Threshold,
+			value:   used,
+			message: fmt.Sprintf("disk usage of %s on %s is %.0f%%", path, id, used),
+		})
+	}
+	return results
+}
+
+// parseDfUsage 从 df -P 的数据行中取出使用率（Capacity 列）
+func parseDfUsage(out string) (float64, error) {
+	fields := strings.Fields(out)
+	if len(fields) < 5 {
+		return 0, fmt.Errorf("unexpected df output %q", out)
+	}
+	return strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64)
+}
+
+// sumMetric 汇总同名指标中带有指定标签的全部值，labels 按 key、value 成对传入
+func sumMetric(values map[string]int64, name string, labels ...string) int64 {
+	var sum int64
+	for key, v := range values {
+		if metricName(key) != name {
+			continue
+		}
+		match := true
+		for i := 0; i+1 < len(labels); i += 2 {
+			if !strings.Contains(key, fmt.Sprintf("%s=%q", labels[i], labels[i+1])) {
+				match = false
+				break
+			}
+		}
+		if match {
+			sum += v
+		}
+	}
+	return sum
+}
+
+// uploadOutcomeMiddleware 按响应状态统计上传请求结果，供上传失败率告警使用
+func uploadOutcomeMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		result := "ok"
+		if err != nil || c.Response().Status >= http.StatusBadRequest {
+			result = "failed"
+		}
+		hubMetrics.Inc("hub_upload_requests_total", "result", result)
+		return err
+	}
+}
+
+// ListAlertsHandler 列出正在触发的告警
+func ListAlertsHandler(c echo.Context) error {
+	alerts := []Alert{}
+	if hubAlerter != nil {
+		hubAlerter.mu.Lock()
+		for _, alert := range hubAlerter.active {
+			alerts = append(alerts, *alert)
+		}
+		hubAlerter.mu.Unlock()
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].StartsAt.Before(alerts[j].StartsAt) })
+	return c.JSON(http.StatusOK, alerts)
+}
@@ -0,0 +1,385 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"echo_demo/sshutil"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 终端审批：打开带有 Tag 标签的清单主机上的终端时先创建一条待审批请求，审批人通过 admin API 或
+// 聊天 webhook 消息中的签名链接批准后才开始 SSH 拨号；Timeout 内没有处理的请求过期。
+// 请求的创建和每次状态变化都写入审计日志
+// -----------------------
+
+// 审批状态
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalExpired  = "expired"
+	ApprovalCanceled = "canceled" // 前端在审批前断开
+)
+
+// ApprovalConfig Enabled 为 false 时不需要审批
+type ApprovalConfig struct {
+	Enabled bool     `json:"enabled"`
+	Tag     string   `json:"tag"` // 清单主机带有该标签（值不为 "false"）时需要审批
+	Timeout Duration `json:"timeout"`
+	// 聊天 webhook：POST 待审批请求和批准、拒绝链接，链接按 Secret 签名，PublicURL 为 hub 对外的地址
+	WebhookURL     string            `json:"webhookURL,omitempty"`
+	WebhookHeaders map[string]string `json:"webhookHeaders,omitempty"`
+	PublicURL      string            `json:"publicURL,omitempty"`
+	Secret         string            `json:"secret,omitempty"`
+	// 已处理的请求保留多久，用于查询
+	Retention Duration `json:"retention"`
+}
+
+func (cfg ApprovalConfig) validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Tag == "" {
+		return errors.New("approval.tag is required")
+	}
+	if cfg.Timeout.D() <= 0 {
+		return errors.New("approval.timeout must be positive")
+	}
+	if cfg.WebhookURL != "" && (cfg.Secret == "" || cfg.PublicURL == "") {
+		return errors.New("approval.webhookURL needs approval.secret and approval.publicURL")
+	}
+	return nil
+}
+
+// ApprovalRequest 一条审批请求
+type ApprovalRequest struct {
+	ID        string     `json:"id"`
+	Host      string     `json:"host"`
+	Subject   string     `json:"subject,omitempty"`
+	ClientIP  string     `json:"clientIP,omitempty"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+	DecidedBy string     `json:"decidedBy,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+var (
+	errApprovalDenied   = errors.New("terminal request was denied")
+	errApprovalExpired  = errors.New("terminal request was not approved in time")
+	errApprovalNotFound = errors.New("approval request not found")
+	errApprovalDecided  = errors.New("approval request is no longer pending")
+)
+
+type approvalEntry struct {
+	req  ApprovalRequest
+	done chan struct{} // 状态离开 pending 时关闭
+}
+
+type approvalStore struct {
+	mu      sync.Mutex
+	entries map[string]*approvalEntry
+}
+
+var hubApprovals = &approvalStore{entries: make(map[string]*approvalEntry)}
+
+// protectedHost 主机是否带有需要审批的标签，未指定主机时终端连接 default 主机，按 default 判断
+func protectedHost(host string) bool {
+	if host == "" {
+		host = sshutil.DefaultProfile
+	}
+	h, ok := hubInventory.host(host)
+	if !ok {
+		return false
+	}
+	v, ok := h.Tags[hubConfig.Approval.Tag]
+	return ok && v != "false"
+}
+
+func (a *approvalStore) create(host, subject, clientIP string) (ApprovalRequest, *approvalEntry, error) {
+	id, err := randomString(12)
+	if err != nil {
+		return ApprovalRequest{}, nil, err
+	}
+	now := time.Now()
+	e := &approvalEntry{
+		req: ApprovalRequest{
+			ID:        id,
+			Host:      host,
+			Subject:   subject,
+			ClientIP:  clientIP,
+			Status:    ApprovalPending,
+			CreatedAt: now,
+			ExpiresAt: now.Add(hubConfig.Approval.Timeout.D()),
+		},
+		done: make(chan struct{}),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pruneLocked(now)
+	a.entries[id] = e
+	return e.req, e, nil
+}
+
+// pruneLocked 删除处理完超过 Retention 的请求
+func (a *approvalStore) pruneLocked(now time.Time) {
+	retention := hubConfig.Approval.Retention.D()
+	for id, e := range a.entries {
+		if e.req.DecidedAt != nil && now.Sub(*e.req.DecidedAt) > retention {
+			delete(a.entries, id)
+		}
+	}
+}
+
+// decide 把待审批的请求改为 status，请求已处理时返回 errApprovalDecided
+func (a *approvalStore) decide(id, status, by, reason string) (ApprovalRequest, error) {
+	a.mu.Lock()
+	e, ok := a.entries[id]
+	if !ok {
+		a.mu.Unlock()
+		return ApprovalRequest{}, errApprovalNotFound
+	}
+	if e.req.Status != ApprovalPending {
+		req := e.req
+		a.mu.Unlock()
+		return req, errApprovalDecided
+	}
+	now := time.Now()
+	e.req.Status, e.req.DecidedAt, e.req.DecidedBy, e.req.Reason = status, &now, by, reason
+	close(e.done)
+	req := e.req
+	a.mu.Unlock()
+	auditApproval(req)
+	return req, nil
+}
+
+func (a *approvalStore) list(status string) []ApprovalRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]ApprovalRequest, 0, len(a.entries))
+	for _, e := range a.entries {
+		if status == "" || e.req.Status == status {
+			out = append(out, e.req)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// auditApproval 记录审批请求的状态变化
+func auditApproval(req ApprovalRequest) {
+	hubMetrics.Inc("hub_approvals_total", "status", req.Status)
+	log.Printf("Terminal approval %s for %s on %s: %s %s", req.ID, req.Subject, req.Host, req.Status, req.DecidedBy)
+	if hubAudit == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:      time.Now(),
+		Subject:   req.Subject,
+		ClientIP:  req.ClientIP,
+		Direction: "approval",
+		Action:    "terminal",
+		RequestID: req.ID,
+		Target:    req.Host,
+		Outcome:   req.Status,
+		Error:     req.Reason,
+	}
+	if req.DecidedBy != "" {
+		e.Error = strings.TrimSpace("by " + req.DecidedBy + " " + req.Reason)
+	}
+	hubAudit.write(e)
+}
+
+// requireApproval 由 term.Approve 调用，受保护的主机等待审批结果，其它主机直接放行
+func requireApproval(ctx context.Context, r *http.Request, host string, notify func(string)) error {
+	if host == "" {
+		host = sshutil.DefaultProfile
+	}
+	if !hubConfig.Approval.Enabled || !protectedHost(host) {
+		return nil
+	}
+	subject := ""
+	if ident, err := authProvider.Authenticate(r); err == nil {
+		subject = ident.Subject
+	}
+	req, e, err := hubApprovals.create(host, subject, resolveOrigin(r).IP)
+	if err != nil {
+		return err
+	}
+	auditApproval(req)
+	go sendApprovalWebhook(req)
+	postApprovalChat(req)
+	notify(fmt.Sprintf("Host %s requires approval, waiting for request %s (expires %s)",
+		host, req.ID, req.ExpiresAt.Format(time.RFC3339)))
+
+	timer := time.NewTimer(time.Until(req.ExpiresAt))
+	defer timer.Stop()
+	select {
+	case <-e.done:
+	case <-timer.C:
+		hubApprovals.decide(req.ID, ApprovalExpired, "", "")
+	case <-ctx.Done():
+		hubApprovals.decide(req.ID, ApprovalCanceled, "", "")
+	}
+	hubApprovals.mu.Lock()
+	final := e.req
+	hubApprovals.mu.Unlock()
+	switch final.Status {
+	case ApprovalApproved:
+		notify(fmt.Sprintf("Request %s approved by %s", final.ID, final.DecidedBy))
+		return nil
+	case ApprovalDenied:
+		return errApprovalDenied
+	case ApprovalCanceled:
+		return ctx.Err()
+	default:
+		return errApprovalExpired
+	}
+}
+
+// approvalSignature 聊天链接的签名
+func approvalSignature(id, decision string) string {
+	mac := hmac.New(sha256.New, []byte(hubConfig.Approval.Secret))
+	mac.Write([]byte(id + ":" + decision))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func approvalLink(id, decision string) string {
+	base := strings.TrimRight(hubConfig.Approval.PublicURL, "/")
+	return fmt.Sprintf("%s/approvals/%s/%s?sig=%s", base, url.PathEscape(id), decision, approvalSignature(id, decision))
+}
+
+// approvalWebhook 发给聊天 webhook 的内容，Text 可直接作为消息正文
+type approvalWebhook struct {
+	Text       string          `json:"text"`
+	Request    ApprovalRequest `json:"request"`
+	ApproveURL string          `json:"approveURL"`
+	DenyURL    string          `json:"denyURL"`
+}
+
+func sendApprovalWebhook(req ApprovalRequest) {
+	cfg := hubConfig.Approval
+	if cfg.WebhookURL == "" {
+		return
+	}
+	payload := approvalWebhook{
+		Request:    req,
+		ApproveURL: approvalLink(req.ID, ApprovalApproved),
+		DenyURL:    approvalLink(req.ID, ApprovalDenied),
+	}
+	payload.Text = fmt.Sprintf("%s requests a terminal on protected host %s (from %s, expires %s)\nApprove: %s\nDeny: %s",
+		req.Subject, req.Host, req.ClientIP, req.ExpiresAt.Format(time.RFC3339), payload.ApproveURL, payload.DenyURL)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Println("Approval webhook error:", err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.WebhookHeaders {
+		httpReq.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		hubMetrics.Inc("hub_approval_webhook_errors_total")
+		log.Println("Approval webhook error:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		hubMetrics.Inc("hub_approval_webhook_errors_total")
+		log.Printf("Approval webhook returned %s", resp.Status)
+	}
+}
+
+// ListApprovalsHandler 列出审批请求，?status=pending 时只返回待审批的请求
+func ListApprovalsHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, hubApprovals.list(c.QueryParam("status")))
+}
+
+// ApprovalDecision 批准或拒绝的请求体
+type ApprovalDecision struct {
+	By     string `json:"by"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func decideApproval(c echo.Context, status string) error {
+	var d ApprovalDecision
+	if err := c.Bind(&d); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if d.By == "" {
+		d.By = "admin"
+	}
+	req, err := hubApprovals.decide(c.Param("id"), status, d.By, d.Reason)
+	switch {
+	case errors.Is(err, errApprovalNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, errApprovalDecided):
+		return c.JSON(http.StatusConflict, map[string]interface{}{"error": err.Error(), "request": req})
+	}
+	return c.JSON(http.StatusOK, req)
+}
+
+// ApproveHandler 批准一条审批请求
+func ApproveHandler(c echo.Context) error {
+	return decideApproval(c, ApprovalApproved)
+}
+
+// DenyHandler 拒绝一条审批请求
+func DenyHandler(c echo.Context) error {
+	return decideApproval(c, ApprovalDenied)
+}
+
+// ApprovalLinkHandler /approvals/:id/:decision，聊天消息中的签名链接，不需要管理令牌。
+// GET 只显示确认页面，避免聊天软件预览链接时误操作；确认页面 POST 到同一地址后才生效
+func ApprovalLinkHandler(c echo.Context) error {
+	if !hubConfig.Approval.Enabled || hubConfig.Approval.Secret == "" {
+		return c.String(http.StatusNotFound, "approval links are not enabled")
+	}
+	id, decision := c.Param("id"), c.Param("decision")
+	if decision != ApprovalApproved && decision != ApprovalDenied {
+		return c.String(http.StatusBadRequest, "unknown decision")
+	}
+	if !hmac.Equal([]byte(c.QueryParam("sig")), []byte(approvalSignature(id, decision))) {
+		return c.String(http.StatusForbidden, "invalid signature")
+	}
+	if c.Request().Method == http.MethodGet {
+		verb := "Approve"
+		if decision == ApprovalDenied {
+			verb = "Deny"
+		}
+		return c.HTML(http.StatusOK, fmt.Sprintf(
+			`<form method="post"><p>%s terminal request %s?</p><button type="submit">%s</button></form>`,
+			verb, html.EscapeString(id), verb))
+	}
+	req, err := hubApprovals.decide(id, decision, "chat", "")
+	switch {
+	case errors.Is(err, errApprovalNotFound):
+		return c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, errApprovalDecided):
+		return c.String(http.StatusConflict, fmt.Sprintf("Request %s is already %s", req.ID, req.Status))
+	}
+	return c.String(http.StatusOK, fmt.Sprintf("Request %s for %s on %s is %s", req.ID, req.Subject, req.Host, req.Status))
+}
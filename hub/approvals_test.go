@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setApprovalConfig(t *testing.T, cfg ApprovalConfig) {
+	t.Helper()
+	saved := hubConfig.Approval
+	hubConfig.Approval = cfg
+	t.Cleanup(func() { hubConfig.Approval = saved })
+}
+
+func TestApprovalSignature(t *testing.T) {
+	setApprovalConfig(t, ApprovalConfig{Enabled: true, Secret: "s1", PublicURL: "https://hub.example/"})
+	sig := approvalSignature("req1", ApprovalApproved)
+	if len(sig) != 64 || sig != approvalSignature("req1", ApprovalApproved) {
+		t.Fatalf("signature %q is not a stable hex sha256", sig)
+	}
+	for _, other := range []string{
+		approvalSignature("req1", ApprovalDenied),
+		approvalSignature("req2", ApprovalApproved),
+	} {
+		if other == sig {
+			t.Fatalf("signature %q reused for a different request or decision", sig)
+		}
+	}
+	hubConfig.Approval.Secret = "s2"
+	if approvalSignature("req1", ApprovalApproved) == sig {
+		t.Fatal("signature does not depend on the secret")
+	}
+
+	link, err := url.Parse(approvalLink("a/b", ApprovalDenied))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Host != "hub.example" || link.EscapedPath() != "/approvals/a%2Fb/denied" || link.Query().Get("sig") != approvalSignature("a/b", ApprovalDenied) {
+		t.Fatalf("approvalLink = %s", link)
+	}
+}
+
+func TestApprovalLinkHandler(t *testing.T) {
+	setApprovalConfig(t, ApprovalConfig{Enabled: true, Secret: "secret", Timeout: Duration(time.Minute)})
+	pending, _, err := hubApprovals.create("db1", "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decided, _, err := hubApprovals.create("db1", "bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hubApprovals.decide(decided.ID, ApprovalDenied, "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      *ApprovalConfig
+		method   string
+		id       string
+		decision string
+		sig      string
+		want     int
+		status   string // 处理后请求的状态
+	}{
+		{name: "disabled", cfg: &ApprovalConfig{Secret: "secret"}, method: http.MethodPost, id: pending.ID, decision: ApprovalApproved, want: http.StatusNotFound},
+		{name: "no secret", cfg: &ApprovalConfig{Enabled: true}, method: http.MethodPost, id: pending.ID, decision: ApprovalApproved, want: http.StatusNotFound},
+		{name: "unknown decision", method: http.MethodPost, id: pending.ID, decision: "expired", want: http.StatusBadRequest},
+		{name: "missing signature", method: http.MethodPost, id: pending.ID, decision: ApprovalApproved, sig: "-", want: http.StatusForbidden},
+		{name: "signature for other decision", method: http.MethodPost, id: pending.ID, decision: ApprovalApproved, sig: approvalSignature(pending.ID, ApprovalDenied), want: http.StatusForbidden},
+		{name: "signature for other request", method: http.MethodPost, id: pending.ID, decision: ApprovalApproved, sig: approvalSignature(decided.ID, ApprovalApproved), want: http.StatusForbidden},
+		{name: "get shows confirmation", method: http.MethodGet, id: pending.ID, decision: ApprovalApproved, want: http.StatusOK, status: ApprovalPending},
+		{name: "unknown request", method: http.MethodPost, id: "missing", decision: ApprovalApproved, want: http.StatusNotFound},
+		{name: "already decided", method: http.MethodPost, id: decided.ID, decision: ApprovalApproved, want: http.StatusConflict},
+		{name: "post approves", method: http.MethodPost, id: pending.ID, decision: ApprovalApproved, want: http.StatusOK, status: ApprovalApproved},
+		{name: "replayed link", method: http.MethodPost, id: pending.ID, decision: ApprovalDenied, want: http.StatusConflict, status: ApprovalApproved},
+	}
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				setApprovalConfig(t, *tt.cfg)
+			}
+			sig := tt.sig
+			switch sig {
+			case "":
+				sig = approvalSignature(tt.id, tt.decision)
+			case "-":
+				sig = ""
+			}
+			req := httptest.NewRequest(tt.method, "/approvals/"+tt.id+"/"+tt.decision+"?sig="+sig, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id", "decision")
+			c.SetParamValues(tt.id, tt.decision)
+			if err := ApprovalLinkHandler(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.method == http.MethodGet && !strings.Contains(rec.Body.String(), `<form method="post">`) {
+				t.Fatalf("GET did not render a confirmation form: %s", rec.Body)
+			}
+			if tt.status != "" {
+				hubApprovals.mu.Lock()
+				got := hubApprovals.entries[pending.ID].req.Status
+				hubApprovals.mu.Unlock()
+				if got != tt.status {
+					t.Fatalf("request status = %s, want %s", got, tt.status)
+				}
+			}
+		})
+	}
+}
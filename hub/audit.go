@@ -0,0 +1,352 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// 审计日志：会话中继的每个前端请求追加一行 JSON 到 File，记录时间、token、主体、来源、action、
+// RequestID、字节数和结果（转发、暂存、丢弃、拒绝、限流、重复、发送失败）；开启 Responses 时同时记录 agent 的响应。
+// 文件只追加不修改，超过 MaxSize 时按时间戳改名轮转，保留最近 MaxBackups 个。
+// 写入前依次执行脱敏函数：Redact 中列出的字段由内置函数处理，其它处理通过 RegisterAuditRedactor 注册
+// -----------------------
+
+// 审计记录的结果
+const (
+	AuditRelayed    = "relayed"
+	AuditQueued     = "queued"      // agent 重连中，已暂存
+	AuditDropped    = "dropped"     // agent 重连中且暂存队列已满
+	AuditSendFailed = "send_failed" // 没有进入 agent 的发送队列
+	AuditRouteError = "route_error" // 路由的 agent 不可用
+	AuditRejected   = "rejected"    // 被拦截器拒绝
+	AuditThrottled  = "throttled"
+	AuditDuplicate  = "duplicate" // RequestID 近期已出现过
+	AuditAnomaly    = "anomaly"   // 连接指纹偏离历史，Action 为异常类型
+	AuditResponseOK = "ok"        // agent 响应成功
+	AuditResponseKO = "error"     // agent 响应带有错误
+)
+
+// 可以脱敏的字段：token 和 subject 替换为 sha256 前缀（同一取值的记录仍可关联），clientIP 保留网段
+const (
+	AuditFieldToken    = "token"
+	AuditFieldSubject  = "subject"
+	AuditFieldClientIP = "clientIP"
+)
+
+// AuditConfig File 为空时不启用；MaxSize 为 0 表示不轮转
+type AuditConfig struct {
+	File       string   `json:"file"`
+	MaxSize    int64    `json:"maxSize"`
+	MaxBackups int      `json:"maxBackups"` // 0 表示保留全部轮转文件
+	Responses  bool     `json:"responses"`
+	Redact     []string `json:"redact,omitempty"`
+}
+
+func (cfg AuditConfig) validate() error {
+	if cfg.File == "" {
+		return nil
+	}
+	if cfg.MaxSize < 0 || cfg.MaxBackups < 0 {
+		return errors.New("audit.maxSize and audit.maxBackups must not be negative")
+	}
+	for _, f := range cfg.Redact {
+		if f != AuditFieldToken && f != AuditFieldSubject && f != AuditFieldClientIP {
+			return fmt.Errorf("audit.redact: unknown field %q", f)
+		}
+	}
+	return nil
+}
+
+// AuditCommand exec 接口执行的命令，Target 为主机 profile
+const AuditCommand = "command"
+
+// AuditEntry 审计文件中的一行，Direction 为 request、response 或 command
+type AuditEntry struct {
+	Time      time.Time `json:"ts"`
+	Token     string    `json:"token"`
+	Subject   string    `json:"subject,omitempty"`
+	ClientIP  string    `json:"clientIP,omitempty"`
+	Direction string    `json:"dir"`
+	Action    string    `json:"action"`
+	RequestID string    `json:"requestId,omitempty"`
+	Target    string    `json:"target,omitempty"` // 按路由转发时的 agent 地址
+	Command   string    `json:"command,omitempty"`
+	Size      int       `json:"size"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditRedactor 在写入前修改记录，比如去掉或替换个人信息
+type AuditRedactor func(e *AuditEntry)
+
+var (
+	auditRedactorsMu sync.RWMutex
+	auditRedactors   []AuditRedactor
+)
+
+// RegisterAuditRedactor 追加一个脱敏函数，应在服务启动前调用
+func RegisterAuditRedactor(r AuditRedactor) {
+	auditRedactorsMu.Lock()
+	defer auditRedactorsMu.Unlock()
+	auditRedactors = append(auditRedactors, r)
+}
+
+func currentAuditRedactors() []AuditRedactor {
+	auditRedactorsMu.RLock()
+	defer auditRedactorsMu.RUnlock()
+	return auditRedactors
+}
+
+// redactHash 同一取值得到相同结果，便于在脱敏后按 token 或主体关联记录
+func redactHash(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// redactIP IPv4 保留 /24，IPv6 保留 /48
+func redactIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// fieldRedactor 按 Redact 配置生成内置的脱敏函数
+func fieldRedactor(fields []string) AuditRedactor {
+	return func(e *AuditEntry) {
+		for _, f := range fields {
+			switch f {
+			case AuditFieldToken:
+				e.Token = redactHash(e.Token)
+			case AuditFieldSubject:
+				e.Subject = redactHash(e.Subject)
+			case AuditFieldClientIP:
+				e.ClientIP = redactIP(e.ClientIP)
+			}
+		}
+	}
+}
+
+type auditLog struct {
+	cfg    AuditConfig
+	redact AuditRedactor
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// hubAudit 为 nil 表示未启用
+var hubAudit *auditLog
+
+func newAuditLog(cfg AuditConfig) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.File), 0o755); err != nil {
+		return nil, err
+	}
+	a := &auditLog{cfg: cfg, redact: fieldRedactor(cfg.Redact)}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, st.Size()
+	return nil
+}
+
+// write 追加一条记录，写入失败只记录日志，不影响中继
+func (a *auditLog) write(e AuditEntry) {
+	for _, r := range currentAuditRedactors() {
+		r(&e)
+	}
+	a.redact(&e)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	indexAuditEntry(e)
+	line = append(line, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.MaxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.cfg.MaxSize {
+		if err := a.rotate(); err != nil {
+			log.Println("Audit rotate error:", err)
+		}
+	}
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			log.Println("Audit open error:", err)
+			return
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		hubMetrics.Inc("hub_audit_errors_total")
+		log.Println("Audit write error:", err)
+	}
+}
+
+// rotate 当前文件改名为 File.YYYYMMDD-HHMMSS 并删除超出 MaxBackups 的旧文件
+func (a *auditLog) rotate() error {
+	a.file.Close()
+	a.file = nil
+	backup := a.cfg.File + "." + time.Now().Format("20060102-150405")
+	if _, err := os.Stat(backup); err == nil {
+		// 一秒内多次轮转
+		backup += fmt.Sprintf(".%d", time.Now().UnixNano())
+	}
+	if err := os.Rename(a.cfg.File, backup); err != nil {
+		return err
+	}
+	if hubObjects != nil && hubObjects.cfg.Audit {
+		go hubObjects.uploadAuditBackup(backup)
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	if a.cfg.MaxBackups <= 0 {
+		return nil
+	}
+	backups, _ := filepath.Glob(a.cfg.File + ".*")
+	sort.Strings(backups)
+	for len(backups) > a.cfg.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Println("Audit prune error:", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (a *auditLog) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// auditRequest 记录一个前端请求的处理结果，target 为按路由转发时的 agent 地址，err 为失败原因
+func (s *RelaySession) auditRequest(client *wsClientConn, msg WebSocketMessage, size int, target, outcome string, err error) {
+	if hubAudit == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:      time.Now(),
+		Token:     s.token,
+		Direction: "request",
+		Action:    msg.Action,
+		RequestID: msg.RequestID,
+		Target:    target,
+		Size:      size,
+		Outcome:   outcome,
+	}
+	if client != nil {
+		if client.ident != nil {
+			e.Subject = client.ident.Subject
+		}
+		e.ClientIP = client.origin.IP
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	hubAudit.write(e)
+}
+
+// auditResponse 记录 agent 对请求的响应，只在开启 Responses 时记录
+func (s *RelaySession) auditResponse(data []byte, target string) {
+	if hubAudit == nil || !hubAudit.cfg.Responses {
+		return
+	}
+	var head struct {
+		Type      string        `json:"t"`
+		RequestID string        `json:"r"`
+		Action    string        `json:"a"`
+		Error     *MessageError `json:"e"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil || head.Type != MessageTypeResponse {
+		return
+	}
+	s.stateMu.Lock()
+	subject, ip := s.subject, s.origin.IP
+	s.stateMu.Unlock()
+	e := AuditEntry{
+		Time:      time.Now(),
+		Token:     s.token,
+		Subject:   subject,
+		ClientIP:  ip,
+		Direction: "response",
+		Action:    head.Action,
+		RequestID: head.RequestID,
+		Target:    target,
+		Size:      len(data),
+		Outcome:   AuditResponseOK,
+	}
+	if head.Error != nil {
+		e.Outcome = AuditResponseKO
+		e.Error = head.Error.Code + ": " + head.Error.Reason
+	}
+	hubAudit.write(e)
+}
+
+// auditCommand 记录通过 exec 接口在一台主机上执行的命令；审计未启用时仍加入检索索引
+func auditCommand(ident *Identity, ip, command string, host HostExecResult) {
+	if hubAudit == nil && hubSearch == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:      time.Now(),
+		ClientIP:  ip,
+		Direction: AuditCommand,
+		Action:    "exec",
+		Target:    host.Profile,
+		Command:   command,
+		Size:      len(command),
+		Outcome:   AuditResponseOK,
+		Error:     host.Error,
+	}
+	if ident != nil {
+		e.Token, e.Subject = ident.Token, ident.Subject
+	}
+	if !host.ok() {
+		e.Outcome = AuditResponseKO
+	}
+	if hubAudit != nil {
+		hubAudit.write(e)
+		return
+	}
+	for _, r := range currentAuditRedactors() {
+		r(&e)
+	}
+	indexAuditEntry(e)
+}
@@ -0,0 +1,195 @@
+package hub
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"echo_demo/jwtauth"
+	"echo_demo/users"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// -----------------------
+// 身份认证：浏览器通过 Sec-WebSocket-Protocol 携带 token，
+// CLI、agent 等机器客户端可以在独立的 mTLS 监听上以客户端证书认证，证书主体映射为相同的 Identity
+// -----------------------
+
+var errMissingCredentials = errors.New("missing token")
+
+// Identity 认证后的调用方身份，后续按 Token 关联会话、按 Tenant 读取功能开关
+type Identity struct {
+	Method  string   `json:"method"`  // "token"、"jwt"、"apikey" 或 "mtls"
+	Subject string   `json:"subject"` // token 本身、证书的 CN 或本地用户名
+	Token   string   `json:"token"`
+	Tenant  string   `json:"tenant,omitempty"`
+	Roles   []string `json:"roles,omitempty"` // 本地用户的角色，与 Authz.Users 中的角色合并
+}
+
+// AuthProvider 从请求中识别调用方身份
+type AuthProvider interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// TokenAuthProvider 使用 Sec-WebSocket-Protocol 中的 token（同时请求编码子协议时取其余的第一项），
+// 普通 HTTP 请求可以改用 Authorization: Bearer。租户只取 JWT 的 tenant 声明，
+// 开启 TenantFromQuery 后声明为空时才取 tenant 查询参数
+type TokenAuthProvider struct{}
+
+func (TokenAuthProvider) Authenticate(r *http.Request) (*Identity, error) {
+	token, _ := splitSubprotocols(r.Header.Get("Sec-WebSocket-Protocol"))
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return nil, errMissingCredentials
+	}
+	if hubUsers != nil && users.IsKey(token) {
+		return authenticateAPIKey(token)
+	}
+	ident := &Identity{
+		Method:  "token",
+		Subject: token,
+		Token:   token,
+	}
+	if tokenValidator == nil {
+		ident.Tenant = queryTenant(r)
+		return ident, nil
+	}
+	// token 为 JWT 时，主体取 sub，会话取 sid（没有时与 sub 相同），租户以声明为准
+	claims, err := tokenValidator.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	ident.Method = "jwt"
+	ident.Subject = claims.Subject
+	ident.Token = claims.Session
+	if ident.Token == "" {
+		ident.Token = claims.Subject
+	}
+	if ident.Token == "" {
+		return nil, errors.New("jwt has neither sid nor sub claim")
+	}
+	ident.Tenant = claims.Tenant
+	if ident.Tenant == "" {
+		ident.Tenant = queryTenant(r)
+	}
+	ident.Roles = rolesClaim(claims)
+	return ident, nil
+}
+
+// queryTenant 客户端可以随意填写 tenant 查询参数，只有部署方显式开启 TenantFromQuery 时才采用
+func queryTenant(r *http.Request) string {
+	if !hubConfig.TenantFromQuery {
+		return ""
+	}
+	return r.URL.Query().Get("tenant")
+}
+
+// tokenValidator 为 nil 时 token 不做校验，直接作为会话标识
+var tokenValidator jwtauth.TokenValidator
+
+// CertIdentity 证书主体对应的 token 和租户，Token 为空时使用证书 CN；
+// Agent 为 true 的证书只能用于 agent 反向注册，不能作为前端连接
+type CertIdentity struct {
+	Token  string `json:"token,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Agent  bool   `json:"agent,omitempty"`
+}
+
+// CertAuthProvider 使用已通过校验的客户端证书，只接受 Identities 中登记的 CN
+type CertAuthProvider struct {
+	Identities map[string]CertIdentity
+}
+
+func (p CertAuthProvider) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errMissingCredentials
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	mapped, ok := p.Identities[subject]
+	if !ok || mapped.Agent {
+		return nil, fmt.Errorf("certificate subject %q is not allowed", subject)
+	}
+	token := mapped.Token
+	if token == "" {
+		token = subject
+	}
+	return &Identity{
+		Method:  "mtls",
+		Subject: subject,
+		Token:   token,
+		Tenant:  mapped.Tenant,
+	}, nil
+}
+
+// ChainAuthProvider 依次尝试，第一个识别出凭证的 provider 的结果为准
+type ChainAuthProvider []AuthProvider
+
+func (chain ChainAuthProvider) Authenticate(r *http.Request) (*Identity, error) {
+	for _, p := range chain {
+		ident, err := p.Authenticate(r)
+		if errors.Is(err, errMissingCredentials) {
+			continue
+		}
+		return ident, err
+	}
+	return nil, errMissingCredentials
+}
+
+var authProvider AuthProvider = TokenAuthProvider{}
+
+// subprotocolHeader 只在客户端请求了子协议时回显，证书认证的客户端可以不带子协议；
+// 回显请求中的原值而不是会话 token，token 为 JWT 时两者不同，浏览器要求回显值必须是请求过的子协议。
+// 请求了编码子协议时回显编码子协议
+func subprotocolHeader(r *http.Request, ident *Identity) http.Header {
+	header := r.Header.Get("Sec-WebSocket-Protocol")
+	if header == "" {
+		return nil
+	}
+	token, encoding := splitSubprotocols(header)
+	if encoding != "" {
+		return http.Header{"Sec-WebSocket-Protocol": []string{encodingSubprotocolPrefix + encoding}}
+	}
+	return http.Header{"Sec-WebSocket-Protocol": []string{token}}
+}
+
+// MTLSConfig 机器客户端使用的 mTLS 监听，ListenAddr 为空时不启用
+type MTLSConfig struct {
+	ListenAddr   string                  `json:"listenAddr"`
+	CertFile     string                  `json:"certFile"`
+	KeyFile      string                  `json:"keyFile"`
+	ClientCAFile string                  `json:"clientCAFile"`
+	Identities   map[string]CertIdentity `json:"identities,omitempty"` // 证书 CN -> 身份
+}
+
+// mtlsListener 创建要求并校验客户端证书的 TLS 监听
+func mtlsListener(cfg MTLSConfig) (net.Listener, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates found in client CA file")
+	}
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("mTLS listener on %s with %d mapped identities", ln.Addr(), len(cfg.Identities))
+	return tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
@@ -0,0 +1,113 @@
+package hub
+
+import (
+	"echo_demo/sshutil"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 授权：按角色限定用户可以访问哪些分组的主机、可以使用哪些能力。
+// 终端、上传、下载、命令执行在建立 SSH 连接前检查，前端连接在中继到 agent 前检查
+// -----------------------
+
+// CapAgent 通过 hub 中继访问 agent，其余能力见 sshutil.CapTerminal 等
+const CapAgent = "agent"
+
+// AuthzRule 角色 Role 可以对 Groups 中的主机和 agent 使用 Capabilities，Groups 含 "*" 表示全部（包括未登记在清单中的目标）
+type AuthzRule struct {
+	Role         string   `json:"role"`
+	Groups       []string `json:"groups"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// AuthzConfig Enabled 为 false 时不做授权检查；Users 为身份主体（token 或证书 CN）-> 角色
+type AuthzConfig struct {
+	Enabled bool                `json:"enabled"`
+	Users   map[string][]string `json:"users,omitempty"`
+	Rules   []AuthzRule         `json:"rules,omitempty"`
+}
+
+// allows 判断角色列表中是否有角色可以对属于 groups 的目标使用 capability
+func (cfg AuthzConfig) allows(roles []string, groups []string, capability string) bool {
+	for _, rule := range cfg.Rules {
+		if !containsString(roles, rule.Role) || !containsString(rule.Capabilities, capability) {
+			continue
+		}
+		if containsString(rule.Groups, "*") {
+			return true
+		}
+		for _, g := range groups {
+			if containsString(rule.Groups, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorize 检查身份能否对目标使用 capability，ident 为 nil 表示通过管理令牌调用，不受限制
+func authorize(ident *Identity, capability string, groups []string, target string) error {
+	if !hubConfig.Authz.Enabled || ident == nil {
+		return nil
+	}
+	roles := append(append([]string(nil), hubConfig.Authz.Users[ident.Subject]...), ident.Roles...)
+	if hubConfig.Authz.allows(roles, groups, capability) || (AuthzConfig{Rules: ldapHostRules}).allows(roles, groups, capability) {
+		return nil
+	}
+	hubMetrics.Inc("hub_authz_denied_total", "capability", capability)
+	log.Printf("Authz denied: %s %s on %q", ident.Subject, capability, target)
+	return fmt.Errorf("%w: %s on %q", sshutil.ErrForbidden, capability, target)
+}
+
+// authorizeHost 检查对清单主机的访问，不在清单中的主机（包括 default 主机）不属于任何分组
+func authorizeHost(ident *Identity, capability, hostID string) error {
+	h, _ := hubInventory.host(hostID)
+	return authorize(ident, capability, h.Groups, hostID)
+}
+
+// authorizeAgent 检查能否中继到 token 对应的 agent，按清单中该 agent 的分组判断
+func authorizeAgent(ident *Identity, token string) error {
+	a, _ := hubInventory.agentByToken(token)
+	return authorize(ident, CapAgent, a.Groups, token)
+}
+
+// authorizeSSHRequest 供 sshutil.Authorize 使用，子包的 HTTP 请求按 authProvider 识别身份
+func authorizeSSHRequest(r *http.Request, capability, host string) error {
+	if !hubConfig.Authz.Enabled {
+		return nil
+	}
+	ident, err := authProvider.Authenticate(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", sshutil.ErrForbidden, err)
+	}
+	return authorizeHost(ident, capability, host)
+}
+
+// apiAuthMiddleware /api 接口的认证：带管理令牌的调用不受授权限制；
+// 启用授权后也接受用户身份，由各接口按目标主机检查权限
+func apiAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	admin := adminAuthMiddleware(next)
+	return func(c echo.Context) error {
+		if c.Request().Header.Get("X-Admin-Token") != "" || !hubConfig.Authz.Enabled {
+			return admin(c)
+		}
+		ident, err := authProvider.Authenticate(c.Request())
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		c.Set(identityContextKey, ident)
+		return next(c)
+	}
+}
+
+const identityContextKey = "identity"
+
+// requestIdentity 返回 apiAuthMiddleware 识别出的用户身份，管理令牌调用时为 nil
+func requestIdentity(c echo.Context) *Identity {
+	ident, _ := c.Get(identityContextKey).(*Identity)
+	return ident
+}
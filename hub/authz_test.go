@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"echo_demo/sshutil"
+	"errors"
+	"testing"
+)
+
+func TestAuthorizeHost(t *testing.T) {
+	savedAuthz, savedInventory, savedLDAP := hubConfig.Authz, hubInventory, ldapHostRules
+	t.Cleanup(func() { hubConfig.Authz, hubInventory, ldapHostRules = savedAuthz, savedInventory, savedLDAP })
+
+	hubConfig.Authz = AuthzConfig{
+		Enabled: true,
+		Users: map[string][]string{
+			"alice": {"dev"},
+			"root":  {"admin"},
+		},
+		Rules: []AuthzRule{
+			{Role: "dev", Groups: []string{"staging"}, Capabilities: []string{sshutil.CapTerminal, sshutil.CapDownload}},
+			{Role: "dba", Groups: []string{"db"}, Capabilities: []string{sshutil.CapTerminal}},
+			{Role: "admin", Groups: []string{"*"}, Capabilities: []string{sshutil.CapTerminal, sshutil.CapUpload, CapAgent}},
+		},
+	}
+	ldapHostRules = []AuthzRule{{Role: "ldap-ops", Groups: []string{"prod"}, Capabilities: []string{sshutil.CapExec}}}
+	hubInventory = newInventory()
+	hubInventory.data.Hosts["web1"] = &InventoryHost{ID: "web1", Groups: []string{"staging"}}
+	hubInventory.data.Hosts["db1"] = &InventoryHost{ID: "db1", Groups: []string{"prod", "db"}}
+
+	tests := []struct {
+		name       string
+		ident      *Identity
+		capability string
+		host       string
+		allowed    bool
+	}{
+		{"admin token", nil, sshutil.CapUpload, "db1", true},
+		{"role from config users", &Identity{Subject: "alice"}, sshutil.CapTerminal, "web1", true},
+		{"capability not granted", &Identity{Subject: "alice"}, sshutil.CapUpload, "web1", false},
+		{"group not granted", &Identity{Subject: "alice"}, sshutil.CapTerminal, "db1", false},
+		{"role from identity", &Identity{Subject: "bob", Roles: []string{"dba"}}, sshutil.CapTerminal, "db1", true},
+		{"capability checked per rule", &Identity{Subject: "bob", Roles: []string{"dba"}}, sshutil.CapDownload, "db1", false},
+		{"roles merged", &Identity{Subject: "alice", Roles: []string{"dba"}}, sshutil.CapTerminal, "db1", true},
+		{"wildcard group", &Identity{Subject: "root"}, sshutil.CapUpload, "db1", true},
+		{"wildcard covers hosts outside inventory", &Identity{Subject: "root"}, sshutil.CapTerminal, "", true},
+		{"host outside inventory has no groups", &Identity{Subject: "alice"}, sshutil.CapTerminal, "unknown", false},
+		{"ldap group rule", &Identity{Subject: "carol", Roles: []string{"ldap-ops"}}, sshutil.CapExec, "db1", true},
+		{"ldap group rule other capability", &Identity{Subject: "carol", Roles: []string{"ldap-ops"}}, sshutil.CapTerminal, "db1", false},
+		{"no roles", &Identity{Subject: "mallory"}, sshutil.CapTerminal, "web1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizeHost(tt.ident, tt.capability, tt.host)
+			if tt.allowed && err != nil {
+				t.Fatalf("authorizeHost denied: %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, sshutil.ErrForbidden) {
+				t.Fatalf("authorizeHost error = %v, want ErrForbidden", err)
+			}
+		})
+	}
+
+	hubConfig.Authz.Enabled = false
+	if err := authorizeHost(&Identity{Subject: "mallory"}, sshutil.CapTerminal, "db1"); err != nil {
+		t.Fatalf("authz disabled but authorizeHost denied: %v", err)
+	}
+}
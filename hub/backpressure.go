@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// -----------------------
+// 发送队列背压：send 通道满时按连接的策略处理，避免读循环被阻塞；
+// 所有发送都通过 Send 入队，连接关闭后返回 errSendClosed，不会向已关闭的通道发送导致 panic。
+// 实际写出由 writePump 完成，每次写入受 WriteTimeout 限制
+// -----------------------
+
+var (
+	errSendClosed    = errors.New("connection is closed")
+	errSendQueueFull = errors.New("send queue is full")
+)
+
+// OverflowPolicy 发送队列满时的处理策略
+type OverflowPolicy string
+
+const (
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // 丢弃队列中最早的一帧，放入新帧
+	OverflowDropNewest OverflowPolicy = "drop_newest" // 丢弃新帧
+	OverflowDisconnect OverflowPolicy = "disconnect"  // 断开连接，由读循环走正常的清理或重连流程
+)
+
+func (p OverflowPolicy) validate() error {
+	switch p {
+	case OverflowDropOldest, OverflowDropNewest, OverflowDisconnect:
+		return nil
+	default:
+		return fmt.Errorf("unknown overflow policy %q", p)
+	}
+}
+
+// recordOverflow 记录一次队列溢出
+func recordOverflow(leg string, policy OverflowPolicy, droppedBytes int) {
+	hubMetrics.Inc("hub_send_overflow_total", "leg", leg, "policy", string(policy))
+	if droppedBytes > 0 {
+		hubMetrics.Add("hub_send_dropped_bytes_total", int64(droppedBytes), "leg", leg)
+	}
+}
+
+// Send 放入发送队列并计入排队字节数。连接已关闭时返回 errSendClosed，
+// 队列满且按策略丢弃了该帧或断开了连接时返回 errSendQueueFull
+func (c *wsClientConn) Send(data []byte) error {
+	return c.offer(data, c.overflow)
+}
+
+// trySend 不按连接策略处理，队列满时直接丢弃，用于队列已经积压时的附加通知
+func (c *wsClientConn) trySend(data []byte) error {
+	return c.offer(data, OverflowDropNewest)
+}
+
+func (c *wsClientConn) offer(data []byte, policy OverflowPolicy) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return errSendClosed
+	}
+	frame := clientFrame{data: data, queuedAt: time.Now()}
+	lane := pickLane(framePriority(data), c.sendHigh, c.send, c.sendLow)
+	c.queuedBytes.Add(int64(len(data)))
+	select {
+	case lane <- frame:
+		return nil
+	default:
+	}
+
+	switch policy {
+	case OverflowDropOldest:
+		select {
+		case old := <-lane:
+			c.queuedBytes.Add(-int64(len(old.data)))
+			recordOverflow("client", policy, len(old.data))
+		default:
+		}
+		// 持有 sendMu 时只有 writePump 会取走数据，这里一定有空位
+		lane <- frame
+		return nil
+	case OverflowDisconnect:
+		c.queuedBytes.Add(-int64(len(data)))
+		recordOverflow("client", policy, len(data))
+		log.Println("Client send queue is full, disconnecting")
+		// 调用方可能持有 clientMu，写关闭帧可能阻塞
+		go c.abortWithCode(CloseCodeSlowConsumer, SlowConsumerReason)
+		return errSendQueueFull
+	default:
+		c.queuedBytes.Add(-int64(len(data)))
+		recordOverflow("client", policy, len(data))
+		return errSendQueueFull
+	}
+}
+
+// closeSend 关闭发送队列，可重复调用
+func (c *wsClientConn) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.send)
+		closeLanes(c.sendHigh, c.sendLow)
+	}
+}
+
+// closeLanes 关闭高、低优先级队列，未开启消息优先级时两者为 nil
+func closeLanes[T any](high, low chan T) {
+	if high != nil {
+		close(high)
+		close(low)
+	}
+}
+
+// Send 放入发送队列并计入排队字节数，返回值与 wsClientConn.Send 相同
+func (a *wsAgentConn) Send(data []byte) error {
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+	if a.sendClosed {
+		return errSendClosed
+	}
+	lane := pickLane(framePriority(data), a.sendHigh, a.send, a.sendLow)
+	a.queuedBytes.Add(int64(len(data)))
+	select {
+	case lane <- data:
+		return nil
+	default:
+	}
+
+	switch a.overflow {
+	case OverflowDropOldest:
+		select {
+		case old := <-lane:
+			a.queuedBytes.Add(-int64(len(old)))
+			recordOverflow("agent", a.overflow, len(old))
+		default:
+		}
+		lane <- data
+		return nil
+	case OverflowDisconnect:
+		a.queuedBytes.Add(-int64(len(data)))
+		recordOverflow("agent", a.overflow, len(data))
+		log.Printf("Agent %q send queue is full, disconnecting", a.id)
+		a.conn.Close()
+		return errSendQueueFull
+	default:
+		a.queuedBytes.Add(-int64(len(data)))
+		recordOverflow("agent", a.overflow, len(data))
+		return errSendQueueFull
+	}
+}
+
+// closeSend 关闭发送队列，可重复调用
+func (a *wsAgentConn) closeSend() {
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+	if !a.sendClosed {
+		a.sendClosed = true
+		close(a.send)
+		closeLanes(a.sendHigh, a.sendLow)
+	}
+}
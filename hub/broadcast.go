@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 服务端广播：向全部会话、token 前缀匹配的会话或资产清单分组内 agent 的会话推送 notify，
+// 用于维护公告和强制下线。POST /admin/broadcast
+// -----------------------
+
+const (
+	BroadcastAll    = "all"
+	BroadcastPrefix = "prefix"
+	BroadcastGroup  = "group" // 资产清单中的分组，按分组内 agent 的 token 匹配会话
+
+	ActionBroadcast = "broadcast"
+	ActionLogout    = "logout"
+)
+
+// BroadcastRequest Action 为空时为 broadcast（Logout 时为 logout）；Logout 为 true 时发送后关闭会话
+type BroadcastRequest struct {
+	Target string      `json:"target"`
+	Prefix string      `json:"prefix,omitempty"`
+	Group  string      `json:"group,omitempty"`
+	Action string      `json:"action,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	Logout bool        `json:"logout,omitempty"`
+}
+
+// BroadcastResult 收到消息的会话 token
+type BroadcastResult struct {
+	Sessions []string `json:"sessions"`
+	Clients  int      `json:"clients"`
+}
+
+// Broadcast 向 match 返回 true 的会话的全部前端发送 msg，logout 时留出时间写出后关闭会话
+func (h *RelayHub) Broadcast(match func(*RelaySession) bool, msg WebSocketMessage, logout bool) BroadcastResult {
+	result := BroadcastResult{Sessions: []string{}}
+	for _, sess := range h.listSessions() {
+		if !match(sess) {
+			continue
+		}
+		sess.sendNotify(msg)
+		result.Sessions = append(result.Sessions, sess.token)
+		result.Clients += sess.info().Clients
+		if logout {
+			sess.setEndReason(EndReasonLogout)
+			time.AfterFunc(time.Second, sess.cleanup)
+		}
+	}
+	sort.Strings(result.Sessions)
+	hubMetrics.Add("hub_broadcast_sessions_total", int64(len(result.Sessions)), "action", msg.Action)
+	return result
+}
+
+// tokensInGroup 返回分组内全部 agent 的 token
+func (inv *inventory) tokensInGroup(group string) map[string]bool {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	tokens := make(map[string]bool)
+	for _, a := range inv.data.Agents {
+		if containsString(a.Groups, group) {
+			tokens[a.Token] = true
+		}
+	}
+	return tokens
+}
+
+// BroadcastHandler 按 target 选择会话并推送消息
+func BroadcastHandler(c echo.Context) error {
+	var req BroadcastRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	var match func(*RelaySession) bool
+	switch req.Target {
+	case BroadcastAll:
+		match = func(*RelaySession) bool { return true }
+	case BroadcastPrefix:
+		if req.Prefix == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "prefix is required"})
+		}
+		match = func(s *RelaySession) bool { return strings.HasPrefix(s.token, req.Prefix) }
+	case BroadcastGroup:
+		if req.Group == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "group is required"})
+		}
+		hubInventory.mu.RLock()
+		_, ok := hubInventory.data.Groups[req.Group]
+		hubInventory.mu.RUnlock()
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "group not found"})
+		}
+		tokens := hubInventory.tokensInGroup(req.Group)
+		match = func(s *RelaySession) bool { return tokens[s.token] }
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "target must be all, prefix or group"})
+	}
+	if req.Action == "" {
+		req.Action = ActionBroadcast
+		if req.Logout {
+			req.Action = ActionLogout
+		}
+	}
+
+	result := relayHub.Broadcast(match, WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: req.Action,
+		Data:   req.Data,
+	}, req.Logout)
+	log.Printf("Broadcast %s to %d sessions (target=%s, logout=%v)", req.Action, len(result.Sessions), req.Target, req.Logout)
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,292 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"echo_demo/mailer"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 配置包：导出完整配置（含 SSH 配置）和资产清单，用 BundleKey 做 HMAC-SHA256 签名，
+// 在另一台 hub 上校验签名后导入，便于迁移和灾备。配置包未加密，其中的密码和私钥需要妥善保管。
+// 导入时清单立即生效；配置写回 HUB_CONFIG 指向的文件，重启后生效。dryRun=true 时只返回差异
+// -----------------------
+
+const bundleVersion = 1
+
+var errBundleSignature = errors.New("bundle signature mismatch")
+
+// hubBundle 签名的内容
+type hubBundle struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exportedAt"`
+	Config     json.RawMessage `json:"config"`
+	Inventory  inventoryData   `json:"inventory"`
+}
+
+// SignedBundle 导出和导入的格式，Signature 为 Bundle 原始字节的 HMAC-SHA256（hex）
+type SignedBundle struct {
+	Bundle    json.RawMessage `json:"bundle"`
+	Signature string          `json:"signature"`
+}
+
+// BundleDiff 导入前后的差异，Config 只列出有变化的顶层配置项，不包含取值
+type BundleDiff struct {
+	Config []string      `json:"config"`
+	Hosts  InventoryDiff `json:"hosts"`
+	Agents InventoryDiff `json:"agents"`
+	Groups InventoryDiff `json:"groups"`
+}
+
+type InventoryDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+func signBundle(key string, bundle []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(bundle)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// exportBundle 生成当前配置和清单的配置包，BundleKey 本身不导出
+func exportBundle() (*SignedBundle, error) {
+	cfg := *hubConfig
+	cfg.BundleKey = ""
+	cfgData, err := json.Marshal(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	hubInventory.mu.RLock()
+	inv := hubInventory.data
+	data, err := json.Marshal(hubBundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now(),
+		Config:     cfgData,
+		Inventory:  inv,
+	})
+	hubInventory.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return &SignedBundle{Bundle: data, Signature: signBundle(hubConfig.BundleKey, data)}, nil
+}
+
+// openBundle 校验签名并解析，导出和导入的 hub 需要配置相同的 BundleKey；配置在默认值上覆盖后校验
+func openBundle(sb SignedBundle) (*Config, *inventoryData, error) {
+	want := signBundle(hubConfig.BundleKey, sb.Bundle)
+	if !hmac.Equal([]byte(want), []byte(sb.Signature)) {
+		return nil, nil, errBundleSignature
+	}
+	var b hubBundle
+	if err := json.Unmarshal(sb.Bundle, &b); err != nil {
+		return nil, nil, err
+	}
+	if b.Version != bundleVersion {
+		return nil, nil, errors.New("unsupported bundle version")
+	}
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(b.Config, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := mailer.New(cfg.SMTP, defaultMailTemplates); err != nil {
+		return nil, nil, err
+	}
+	// 导入后保留本机的签名密钥
+	cfg.BundleKey = hubConfig.BundleKey
+	inv := b.Inventory
+	if inv.Hosts == nil {
+		inv.Hosts = make(map[string]*InventoryHost)
+	}
+	if inv.Agents == nil {
+		inv.Agents = make(map[string]*InventoryAgent)
+	}
+	if inv.Groups == nil {
+		inv.Groups = make(map[string]*InventoryGroup)
+	}
+	return cfg, &inv, nil
+}
+
+// diffConfig 比较两份配置的 JSON 顶层字段
+func diffConfig(oldCfg, newCfg *Config) ([]string, error) {
+	var before, after map[string]json.RawMessage
+	for _, x := range []struct {
+		cfg *Config
+		out *map[string]json.RawMessage
+	}{{oldCfg, &before}, {newCfg, &after}} {
+		data, err := json.Marshal(x.cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, x.out); err != nil {
+			return nil, err
+		}
+	}
+	changed := []string{}
+	for key, v := range after {
+		if !jsonEqual(before[key], v) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// diffItems 比较两个按 ID 索引的清单集合
+func diffItems[T any](before, after map[string]*T) InventoryDiff {
+	d := InventoryDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for id, item := range after {
+		old, ok := before[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, id)
+		case !reflect.DeepEqual(old, item):
+			d.Changed = append(d.Changed, id)
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+// replace 用导入的清单整体替换当前清单并保存
+func (inv *inventory) replace(data inventoryData) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	old := inv.data
+	inv.data = data
+	if err := inv.saveLocked(); err != nil {
+		inv.data = old
+		return err
+	}
+	return nil
+}
+
+// writeConfigFile 原子地写回配置文件
+func writeConfigFile(path string, cfg *Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ExportBundleHandler 导出签名的配置包
+func ExportBundleHandler(c echo.Context) error {
+	if hubConfig.BundleKey == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "bundle key is not configured"})
+	}
+	sb, err := exportBundle()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	name := "hub-bundle-" + time.Now().Format("20060102-150405") + ".json"
+	if hubObjects != nil && hubObjects.cfg.Bundles {
+		if data, err := json.Marshal(sb); err == nil {
+			hubObjects.uploadBundle(name, data)
+		}
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+name+`"`)
+	return c.JSON(http.StatusOK, sb)
+}
+
+// ImportBundleHandler 导入配置包：dryRun=true 时只返回差异；
+// 配置有变化时需要设置 HUB_CONFIG，写回后重启生效
+func ImportBundleHandler(c echo.Context) error {
+	if hubConfig.BundleKey == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "bundle key is not configured"})
+	}
+	var sb SignedBundle
+	if err := c.Bind(&sb); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	cfg, inv, err := openBundle(sb)
+	if errors.Is(err, errBundleSignature) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid bundle: " + err.Error()})
+	}
+
+	var diff BundleDiff
+	if diff.Config, err = diffConfig(hubConfig, cfg); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	hubInventory.mu.RLock()
+	diff.Hosts = diffItems(hubInventory.data.Hosts, inv.Hosts)
+	diff.Agents = diffItems(hubInventory.data.Agents, inv.Agents)
+	diff.Groups = diffItems(hubInventory.data.Groups, inv.Groups)
+	hubInventory.mu.RUnlock()
+
+	dryRun := c.QueryParam("dryRun") == "true"
+	configPath := os.Getenv("HUB_CONFIG")
+	restart := len(diff.Config) > 0
+	if dryRun {
+		return c.JSON(http.StatusOK, map[string]interface{}{"dryRun": true, "diff": diff, "restartRequired": restart})
+	}
+	if restart && configPath == "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "configuration differs but HUB_CONFIG is not set"})
+	}
+
+	if err := hubInventory.replace(*inv); err != nil {
+		log.Println("Import inventory error:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if restart {
+		if err := writeConfigFile(configPath, cfg); err != nil {
+			log.Println("Import config error:", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	log.Printf("Bundle imported: %d config changes, hosts %+v, agents %+v, groups %+v",
+		len(diff.Config), diff.Hosts, diff.Agents, diff.Groups)
+	return c.JSON(http.StatusOK, map[string]interface{}{"dryRun": false, "diff": diff, "restartRequired": restart})
+}
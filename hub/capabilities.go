@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// -----------------------
+// 前端能力声明：前端在 hello 的 capabilities 中列出自己支持的能力（binary、compression、msgpack、protobuf、multiplex），
+// hub 按连接记录，并自动避开对方不支持的能力：升级时选了二进制编码但未声明 binary 或该编码时退回 JSON 文本帧，
+// 未声明 compression 时不再压缩发出的帧，未声明 multiplex 时拒绝 channel_open。
+// hello 响应的 capabilities 为 hub 在该连接上实际使用的能力。不带 capabilities 的 hello 和旧前端保持升级时协商的结果
+// -----------------------
+
+const (
+	CapabilityBinary      = "binary"
+	CapabilityCompression = "compression"
+	CapabilityMsgpack     = EncodingMsgpack
+	CapabilityProtobuf    = EncodingProtobuf
+	CapabilityMultiplex   = "multiplex"
+)
+
+// clientCapabilities 一个前端连接的能力降级状态，前端未声明时只有 compressed
+type clientCapabilities struct {
+	compressed  bool // 握手时协商了 permessage-deflate
+	textOnly    bool // 不再使用二进制编码
+	noCompress  bool // 不再压缩发出的帧
+	noMultiplex bool
+	flagOff     bool // 会话的 compression 功能开关关闭
+}
+
+// negotiatedCompression 握手请求是否带有 permessage-deflate，hub 开启压缩时 gorilla 会接受
+func negotiatedCompression(r *http.Request) bool {
+	return hubConfig.Compression.Enabled && strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+}
+
+// applyCapabilities 记录 hello 中声明的能力，advertised 为 nil 表示未声明，保持原状；返回实际使用的能力
+func (c *wsClientConn) applyCapabilities(token string, advertised []string) []string {
+	c.mu.Lock()
+	if advertised != nil {
+		caps := &c.caps
+		caps.textOnly = c.codec != nil && (!containsString(advertised, CapabilityBinary) || !containsString(advertised, c.encoding))
+		caps.noCompress = !containsString(advertised, CapabilityCompression)
+		caps.noMultiplex = !containsString(advertised, CapabilityMultiplex)
+		if caps.textOnly {
+			hubMetrics.Inc("hub_capability_degradations_total", "capability", c.encoding)
+			log.Printf("Session %s client lacks %s support, falling back to JSON text frames", token, c.encoding)
+		}
+		if caps.noCompress && caps.compressed {
+			hubMetrics.Inc("hub_capability_degradations_total", "capability", CapabilityCompression)
+		}
+	}
+	c.mu.Unlock()
+	return c.capabilities()
+}
+
+// capabilities 返回 hub 在该连接上使用的能力
+func (c *wsClientConn) capabilities() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	used := []string{}
+	if c.codec != nil && !c.caps.textOnly {
+		used = append(used, CapabilityBinary, c.encoding)
+	}
+	if c.caps.compressed && !c.caps.noCompress && !c.caps.flagOff {
+		used = append(used, CapabilityCompression)
+	}
+	if !c.caps.noMultiplex {
+		used = append(used, CapabilityMultiplex)
+	}
+	return used
+}
+
+// wireCodec 写出时使用的编码，前端不支持二进制时返回 nil；compress 为 false 时不压缩
+func (c *wsClientConn) wireCodec() (codec frameCodec, compress bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.caps.textOnly {
+		codec = c.codec
+	}
+	return codec, !c.caps.noCompress && !c.caps.flagOff
+}
+
+// gateCompression 按会话的 compression 功能开关决定是否压缩发出的帧
+func (c *wsClientConn) gateCompression(enabled bool) {
+	c.mu.Lock()
+	c.caps.flagOff = !enabled
+	c.mu.Unlock()
+}
+
+// multiplexOn 前端未声明 multiplex 时为 false
+func (c *wsClientConn) multiplexOn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.caps.noMultiplex
+}
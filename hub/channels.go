@@ -0,0 +1,293 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// -----------------------
+// 逻辑通道：消息带 ch 字段时属于该通道，一个前端连接可以同时承载终端、文件传输、通知等多路独立的流。
+// 通道由前端发送 channel_open 打开，只有打开它的前端能收到 agent 在该通道上的消息。
+// 流控按通道进行：每个通道有一个字节窗口，前端处理完数据后用 channel_ack 归还；窗口用完时 hub 暂存该通道的消息，
+// 暂存超过 ChannelBufferSize 时通知 agent 暂停该通道（channel_pause），排空一半后恢复（channel_resume），
+// 一个通道阻塞不影响其它通道。任意一方发送 channel_close 即关闭通道，前端断开或 agent 重连时 hub 关闭相关通道。
+// 不带 ch 的消息按原来的方式处理
+// -----------------------
+
+const (
+	ActionChannelOpen   = "channel_open"   // 前端 -> hub -> agent，d 为 ChannelOpenData
+	ActionChannelClose  = "channel_close"  // 双向
+	ActionChannelAck    = "channel_ack"    // 前端 -> hub，d 为 ChannelAckData，不转发给 agent
+	ActionChannelPause  = "channel_pause"  // hub -> agent
+	ActionChannelResume = "channel_resume" // hub -> agent
+)
+
+// ErrCodeChannel 通道不存在、重复打开或被 hub 关闭
+const ErrCodeChannel = "channel"
+
+// ChannelOpenData channel_open 的数据，Window 为 0 时使用 ChannelWindow
+type ChannelOpenData struct {
+	Kind   string `json:"kind,omitempty"` // 例如 terminal、transfer，由 agent 解释
+	Window int64  `json:"window,omitempty"`
+}
+
+// ChannelAckData channel_ack 的数据，Bytes 为前端已处理的字节数
+type ChannelAckData struct {
+	Bytes int64 `json:"bytes"`
+}
+
+type logicalChannel struct {
+	id     string
+	kind   string
+	client *wsClientConn
+	window int64 // 剩余可发送的字节数，可能因单条大消息变为负数
+	queue  [][]byte
+	queued int64
+	paused bool // 已通知 agent 暂停
+}
+
+// channelOf 取出 agent 消息的通道和 action，不带通道的消息不做完整解析
+func channelOf(data []byte) (channel, action string) {
+	if !bytes.Contains(data, []byte(`"ch"`)) {
+		return "", ""
+	}
+	var head struct {
+		Channel string `json:"ch"`
+		Action  string `json:"a"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return "", ""
+	}
+	return head.Channel, head.Action
+}
+
+// decodeData 把消息的 Data 解析为具体类型
+func decodeData(data interface{}, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// handleChannelControl 处理前端的 channel_open、channel_close、channel_ack，返回 false 表示不是通道控制消息
+func (s *RelaySession) handleChannelControl(client *wsClientConn, msg WebSocketMessage, data []byte) bool {
+	switch msg.Action {
+	case ActionChannelOpen:
+		s.openChannel(client, msg, data)
+	case ActionChannelClose:
+		if s.removeChannel(msg.Channel, client) != nil {
+			s.relayToAgent(client, msg, data)
+		}
+	case ActionChannelAck:
+		s.ackChannel(client, msg)
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *RelaySession) openChannel(client *wsClientConn, msg WebSocketMessage, data []byte) {
+	var open ChannelOpenData
+	if msg.Data != nil {
+		if err := decodeData(msg.Data, &open); err != nil {
+			s.notifyError(client, msg.RequestID, ErrCodeBadMessage, "invalid channel_open data: "+err.Error())
+			return
+		}
+	}
+	if msg.Channel == "" {
+		s.notifyError(client, msg.RequestID, ErrCodeChannel, "channel_open requires ch")
+		return
+	}
+	if !client.multiplexOn() {
+		s.notifyError(client, msg.RequestID, ErrCodeChannel, "channel_open requires the multiplex capability")
+		return
+	}
+	if open.Window <= 0 {
+		open.Window = hubConfig.ChannelWindow
+	}
+	s.chanMu.Lock()
+	if s.channels == nil {
+		s.channels = make(map[string]*logicalChannel)
+	}
+	if _, exists := s.channels[msg.Channel]; exists {
+		s.chanMu.Unlock()
+		s.notifyError(client, msg.RequestID, ErrCodeChannel, fmt.Sprintf("channel %q is already open", msg.Channel))
+		return
+	}
+	s.channels[msg.Channel] = &logicalChannel{id: msg.Channel, kind: open.Kind, client: client, window: open.Window}
+	s.chanMu.Unlock()
+	hubMetrics.Inc("hub_channels_opened_total", "kind", open.Kind)
+	// agent 收到后建立对应的流，并在该通道上回复
+	s.relayToAgent(client, msg, data)
+}
+
+// removeChannel 删除通道；client 不为 nil 时只删除该前端打开的通道
+func (s *RelaySession) removeChannel(id string, client *wsClientConn) *logicalChannel {
+	s.chanMu.Lock()
+	defer s.chanMu.Unlock()
+	ch := s.channels[id]
+	if ch == nil || (client != nil && ch.client != client) {
+		return nil
+	}
+	delete(s.channels, id)
+	return ch
+}
+
+// ackChannel 归还窗口并发送暂存的消息
+func (s *RelaySession) ackChannel(client *wsClientConn, msg WebSocketMessage) {
+	var ack ChannelAckData
+	if err := decodeData(msg.Data, &ack); err != nil || ack.Bytes <= 0 {
+		s.notifyError(client, msg.RequestID, ErrCodeBadMessage, "channel_ack requires positive bytes")
+		return
+	}
+	s.chanMu.Lock()
+	ch := s.channels[msg.Channel]
+	if ch == nil || ch.client != client {
+		s.chanMu.Unlock()
+		return
+	}
+	ch.window += ack.Bytes
+	// 持锁发送，保证暂存的消息先于 agent 新到达的消息发出
+	for len(ch.queue) > 0 && ch.window > 0 {
+		frame := ch.queue[0]
+		ch.queue = ch.queue[1:]
+		ch.queued -= int64(len(frame))
+		ch.window -= int64(len(frame))
+		s.sendTo(client, frame)
+	}
+	resume := ch.paused && ch.queued <= hubConfig.ChannelBufferSize/2
+	if resume {
+		ch.paused = false
+	}
+	s.chanMu.Unlock()
+
+	if resume {
+		s.sendChannelControl(msg.Channel, ActionChannelResume, nil)
+	}
+}
+
+// deliverChannel 把 agent 在通道上的消息发给打开通道的前端，窗口用完时暂存
+func (s *RelaySession) deliverChannel(id, action string, data []byte) {
+	s.chanMu.Lock()
+	ch := s.channels[id]
+	if ch == nil {
+		s.chanMu.Unlock()
+		hubMetrics.Inc("hub_channel_dropped_total")
+		return
+	}
+	if action == ActionChannelClose {
+		// 关闭前先发出暂存的数据，保证前端收到完整的流
+		delete(s.channels, id)
+		for _, frame := range ch.queue {
+			s.sendTo(ch.client, frame)
+		}
+		s.sendTo(ch.client, data)
+		s.chanMu.Unlock()
+		return
+	}
+	if ch.window > 0 && len(ch.queue) == 0 {
+		ch.window -= int64(len(data))
+		s.sendTo(ch.client, data)
+		s.chanMu.Unlock()
+		return
+	}
+	ch.queue = append(ch.queue, data)
+	ch.queued += int64(len(data))
+	limit := hubConfig.ChannelBufferSize
+	pause := !ch.paused && ch.queued >= limit
+	if pause {
+		ch.paused = true
+	}
+	// agent 暂停后仍继续发送时关闭通道，避免无限占用内存
+	overflow := ch.queued >= 2*limit
+	if overflow {
+		delete(s.channels, id)
+	}
+	s.chanMu.Unlock()
+
+	switch {
+	case overflow:
+		log.Printf("Session %s channel %s buffer overflow, closing", s.token, id)
+		hubMetrics.Inc("hub_channel_overflow_total")
+		s.closeChannelByHub(ch, "channel buffer overflow")
+	case pause:
+		s.sendChannelControl(id, ActionChannelPause, nil)
+	}
+}
+
+// sendChannelControl 向 agent 发送通道控制消息
+func (s *RelaySession) sendChannelControl(id, action string, payload interface{}) {
+	data, err := json.Marshal(WebSocketMessage{Type: MessageTypeNotify, Action: action, Channel: id, Data: payload})
+	if err != nil {
+		return
+	}
+	s.agentMu.Lock()
+	defer s.agentMu.Unlock()
+	if s.agent != nil {
+		_ = s.agent.Send(data)
+	}
+}
+
+// closeChannelByHub hub 主动关闭通道：通知 agent 关闭对应的流，并告知前端原因
+func (s *RelaySession) closeChannelByHub(ch *logicalChannel, reason string) {
+	s.sendChannelControl(ch.id, ActionChannelClose, nil)
+	hubMetrics.Inc("hub_message_errors_total", "code", ErrCodeChannel)
+	s.notifyClient(ch.client, WebSocketMessage{
+		Type:    MessageTypeNotify,
+		Action:  ActionChannelClose,
+		Channel: ch.id,
+		Data:    reason,
+		Error:   &MessageError{Code: ErrCodeChannel, Reason: reason},
+	})
+}
+
+// closeClientChannels 前端断开时关闭它打开的全部通道
+func (s *RelaySession) closeClientChannels(client *wsClientConn) {
+	s.chanMu.Lock()
+	var closed []string
+	for id, ch := range s.channels {
+		if ch.client == client {
+			delete(s.channels, id)
+			closed = append(closed, id)
+		}
+	}
+	s.chanMu.Unlock()
+	for _, id := range closed {
+		s.sendChannelControl(id, ActionChannelClose, nil)
+	}
+}
+
+// resetChannels agent 重连后原来的流已不存在，关闭全部通道并通知前端
+func (s *RelaySession) resetChannels(reason string) {
+	s.chanMu.Lock()
+	channels := s.channels
+	s.channels = nil
+	s.chanMu.Unlock()
+	for _, ch := range channels {
+		s.notifyClient(ch.client, WebSocketMessage{
+			Type:    MessageTypeNotify,
+			Action:  ActionChannelClose,
+			Channel: ch.id,
+			Data:    reason,
+			Error:   &MessageError{Code: ErrCodeChannel, Reason: reason},
+		})
+	}
+}
+
+// checkChannel 前端在未打开的通道上发送消息时回复错误，返回 false 表示不转发
+func (s *RelaySession) checkChannel(client *wsClientConn, msg WebSocketMessage) bool {
+	if msg.Channel == "" {
+		return true
+	}
+	s.chanMu.Lock()
+	ch := s.channels[msg.Channel]
+	s.chanMu.Unlock()
+	if ch != nil && ch.client == client {
+		return true
+	}
+	s.notifyError(client, msg.RequestID, ErrCodeChannel, fmt.Sprintf("channel %q is not open", msg.Channel))
+	return false
+}
@@ -0,0 +1,218 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 故障注入：在会话的前端连接（client）或 agent 连接（agent）上，对读到的每一帧按概率注入延迟、丢弃或断开，
+// 用于验证重连、暂存和前端的容错。延迟在读循环中同步等待，相当于该方向的链路变慢；断开即关闭该连接，
+// agent 连接断开后走正常的重连流程。
+// 只有配置 chaos.enabled 或设置环境变量 HUB_CHAOS（JSON 格式的 ChaosState，启动即生效）时可用，
+// 运行中通过 /admin/chaos 查询和修改
+// -----------------------
+
+// ChaosEnv 启动时的故障注入状态
+const ChaosEnv = "HUB_CHAOS"
+
+// 注入的连接
+const (
+	ChaosLegClient = "client"
+	ChaosLegAgent  = "agent"
+)
+
+// ChaosConfig Enabled 为 false 且没有设置 HUB_CHAOS 时管理接口返回 404，读循环不做任何检查
+type ChaosConfig struct {
+	Enabled bool       `json:"enabled"`
+	State   ChaosState `json:"state"` // 启动时的状态
+}
+
+func (cfg ChaosConfig) validate() error {
+	return cfg.State.validate()
+}
+
+// ChaosFault 一个连接上的故障，概率取值 [0, 1]，按帧独立判断
+type ChaosFault struct {
+	Latency        Duration `json:"latency,omitempty"`
+	Jitter         Duration `json:"jitter,omitempty"` // 在 Latency 上随机增加 [0, Jitter)
+	DropRate       float64  `json:"dropRate,omitempty"`
+	DisconnectRate float64  `json:"disconnectRate,omitempty"`
+}
+
+func (f ChaosFault) active() bool {
+	return f.Latency > 0 || f.Jitter > 0 || f.DropRate > 0 || f.DisconnectRate > 0
+}
+
+// ChaosState Tokens 为空表示全部会话；Until 之后自动失效，避免忘记关闭
+type ChaosState struct {
+	Enabled bool       `json:"enabled"`
+	Tokens  []string   `json:"tokens,omitempty"`
+	Client  ChaosFault `json:"client"`
+	Agent   ChaosFault `json:"agent"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+func (st ChaosState) validate() error {
+	for leg, f := range map[string]ChaosFault{ChaosLegClient: st.Client, ChaosLegAgent: st.Agent} {
+		if f.Latency < 0 || f.Jitter < 0 {
+			return fmt.Errorf("chaos.%s: latency and jitter must not be negative", leg)
+		}
+		if f.DropRate < 0 || f.DropRate > 1 || f.DisconnectRate < 0 || f.DisconnectRate > 1 {
+			return fmt.Errorf("chaos.%s: dropRate and disconnectRate must be between 0 and 1", leg)
+		}
+	}
+	return nil
+}
+
+type chaosInjector struct {
+	mu    sync.RWMutex
+	state ChaosState
+	rnd   *rand.Rand
+}
+
+// hubChaos 为 nil 表示不可用
+var hubChaos *chaosInjector
+
+// newChaosInjector HUB_CHAOS 不为空时覆盖配置中的状态，并在没有配置 enabled 时也启用
+func newChaosInjector(cfg ChaosConfig) (*chaosInjector, error) {
+	state := cfg.State
+	env := os.Getenv(ChaosEnv)
+	if !cfg.Enabled && env == "" {
+		return nil, nil
+	}
+	if env != "" {
+		state = ChaosState{}
+		if err := json.Unmarshal([]byte(env), &state); err != nil {
+			return nil, fmt.Errorf("%s: %w", ChaosEnv, err)
+		}
+		if err := state.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", ChaosEnv, err)
+		}
+	}
+	return &chaosInjector{state: state, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+}
+
+func (ci *chaosInjector) get() ChaosState {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	return ci.state
+}
+
+func (ci *chaosInjector) set(state ChaosState) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.state = state
+}
+
+// fault 返回 token 在 leg 上当前生效的故障
+func (ci *chaosInjector) fault(token, leg string) (ChaosFault, bool) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	st := ci.state
+	if !st.Enabled || st.Until != nil && time.Now().After(*st.Until) {
+		return ChaosFault{}, false
+	}
+	if len(st.Tokens) > 0 && !containsString(st.Tokens, token) {
+		return ChaosFault{}, false
+	}
+	f := st.Client
+	if leg == ChaosLegAgent {
+		f = st.Agent
+	}
+	return f, f.active()
+}
+
+// roll 以概率 p 返回 true；rand.Rand 不是并发安全的
+func (ci *chaosInjector) roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.rnd.Float64() < p
+}
+
+func (ci *chaosInjector) jitter(d Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return time.Duration(ci.rnd.Int63n(int64(d)))
+}
+
+// injectChaos 在读循环中读到一帧之后调用，返回 false 表示丢弃该帧；disconnect 关闭该连接
+func (s *RelaySession) injectChaos(leg string, disconnect func()) bool {
+	ci := hubChaos
+	if ci == nil {
+		return true
+	}
+	f, ok := ci.fault(s.token, leg)
+	if !ok {
+		return true
+	}
+	if ci.roll(f.DisconnectRate) {
+		hubMetrics.Inc("hub_chaos_injected_total", "leg", leg, "fault", "disconnect")
+		disconnect()
+		return false
+	}
+	if ci.roll(f.DropRate) {
+		hubMetrics.Inc("hub_chaos_injected_total", "leg", leg, "fault", "drop")
+		return false
+	}
+	if delay := time.Duration(f.Latency) + ci.jitter(f.Jitter); delay > 0 {
+		hubMetrics.Inc("hub_chaos_injected_total", "leg", leg, "fault", "latency")
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+var errChaosDisabled = errors.New("chaos injection is not enabled")
+
+// GetChaosHandler 查询故障注入状态
+func GetChaosHandler(c echo.Context) error {
+	if hubChaos == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errChaosDisabled.Error()})
+	}
+	return c.JSON(http.StatusOK, hubChaos.get())
+}
+
+// SetChaosHandler 替换故障注入状态，请求体为 ChaosState，对进行中的会话立即生效
+func SetChaosHandler(c echo.Context) error {
+	if hubChaos == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errChaosDisabled.Error()})
+	}
+	var state ChaosState
+	if err := c.Bind(&state); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if err := state.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	hubChaos.set(state)
+	return c.JSON(http.StatusOK, state)
+}
+
+// DeleteChaosHandler 停止注入，保留其余设置
+func DeleteChaosHandler(c echo.Context) error {
+	if hubChaos == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errChaosDisabled.Error()})
+	}
+	state := hubChaos.get()
+	state.Enabled = false
+	hubChaos.set(state)
+	return c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,488 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// ChatOps：把会话开始、终端审批请求和告警发到 Slack、钉钉、飞书的群机器人。
+// 审批消息带批准、拒绝按钮：Slack 配置 SigningSecret 后按钮回调 /chatops/slack，由 hub 校验签名后
+// 直接处理审批或关闭会话；钉钉、飞书的群机器人不支持回调，按钮打开 approval 的签名链接。
+// 告警通过 alerting 中 type 为 chat 的通道发送，按规则选择群
+// -----------------------
+
+// 群机器人类型
+const (
+	ChatSlack    = "slack"
+	ChatDingTalk = "dingtalk"
+	ChatFeishu   = "feishu"
+)
+
+// 发送到群的事件，告警不在其中，由告警规则的通道决定
+const (
+	ChatEventSessionStart = "session_start"
+	ChatEventApproval     = "approval"
+)
+
+// Slack 按钮的 action_id
+const (
+	slackActionApprove      = "approval_approve"
+	slackActionDeny         = "approval_deny"
+	slackActionCloseSession = "close_session"
+)
+
+// ChatChannel 一个群机器人
+type ChatChannel struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	WebhookURL string `json:"webhookURL"`
+	// 钉钉、飞书机器人安全设置中的加签密钥
+	Secret string `json:"secret,omitempty"`
+	// Slack App 的 Signing Secret，配置后消息带交互按钮，App 的 Request URL 设为 {hub}/chatops/slack
+	SigningSecret string `json:"signingSecret,omitempty"`
+	// 发送的事件，为空时发送全部事件
+	Events []string `json:"events,omitempty"`
+}
+
+// ChatOpsConfig Channels 为空时不发送
+type ChatOpsConfig struct {
+	Channels []ChatChannel `json:"channels,omitempty"`
+}
+
+func (cfg ChatOpsConfig) validate() error {
+	names := make(map[string]bool)
+	for _, ch := range cfg.Channels {
+		if ch.Name == "" || names[ch.Name] {
+			return fmt.Errorf("chatOps channel name %q is empty or duplicated", ch.Name)
+		}
+		names[ch.Name] = true
+		switch ch.Type {
+		case ChatSlack, ChatDingTalk, ChatFeishu:
+		default:
+			return fmt.Errorf("chatOps channel %q: unknown type %q", ch.Name, ch.Type)
+		}
+		if ch.WebhookURL == "" {
+			return fmt.Errorf("chatOps channel %q: webhookURL is required", ch.Name)
+		}
+		if ch.SigningSecret != "" && ch.Type != ChatSlack {
+			return fmt.Errorf("chatOps channel %q: signingSecret is only used by slack", ch.Name)
+		}
+		for _, ev := range ch.Events {
+			if ev != ChatEventSessionStart && ev != ChatEventApproval {
+				return fmt.Errorf("chatOps channel %q: unknown event %q", ch.Name, ev)
+			}
+		}
+	}
+	return nil
+}
+
+func (ch ChatChannel) wants(event string) bool {
+	return len(ch.Events) == 0 || slices.Contains(ch.Events, event)
+}
+
+// interactive 按钮是否回调 hub
+func (ch ChatChannel) interactive() bool {
+	return ch.Type == ChatSlack && ch.SigningSecret != ""
+}
+
+func chatChannel(name string) (ChatChannel, bool) {
+	for _, ch := range hubConfig.ChatOps.Channels {
+		if ch.Name == name {
+			return ch, true
+		}
+	}
+	return ChatChannel{}, false
+}
+
+// chatMessage 与平台无关的消息，Text 为 markdown
+type chatMessage struct {
+	Title   string
+	Text    string
+	Buttons []chatButton
+}
+
+// chatButton 交互的群使用 Action、Value，其它群使用 URL，两者都没有时不显示
+type chatButton struct {
+	Label  string
+	Style  string // primary / danger
+	URL    string
+	Action string
+	Value  string
+}
+
+var chatClient = &http.Client{Timeout: 10 * time.Second}
+
+// postChatEvent 把事件发送到订阅了它的全部群，不阻塞调用方
+func postChatEvent(event string, m chatMessage) {
+	for _, ch := range hubConfig.ChatOps.Channels {
+		if ch.wants(event) {
+			go postChat(ch, m)
+		}
+	}
+}
+
+func postChat(ch ChatChannel, m chatMessage) {
+	err := sendChat(ch, m)
+	result := "ok"
+	if err != nil {
+		result = "error"
+		log.Printf("ChatOps channel %s error: %v", ch.Name, err)
+	}
+	hubMetrics.Inc("hub_chatops_messages_total", "channel", ch.Name, "result", result)
+}
+
+// sendChat 按群的类型编码并发送，平台在响应体中返回的错误码也视为失败
+func sendChat(ch ChatChannel, m chatMessage) error {
+	var payload map[string]interface{}
+	target := ch.WebhookURL
+	switch ch.Type {
+	case ChatSlack:
+		payload = slackPayload(ch, m)
+	case ChatDingTalk:
+		payload = dingTalkPayload(m)
+		if ch.Secret != "" {
+			ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			sign := chatSign(ch.Secret, ts+"\n"+ch.Secret)
+			sep := "?"
+			if strings.Contains(target, "?") {
+				sep = "&"
+			}
+			target += sep + "timestamp=" + ts + "&sign=" + url.QueryEscape(sign)
+		}
+	case ChatFeishu:
+		payload = feishuPayload(m)
+		if ch.Secret != "" {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			payload["timestamp"] = ts
+			payload["sign"] = chatSign(ts+"\n"+ch.Secret, "")
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := chatClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if ch.Type == ChatSlack {
+		return nil
+	}
+	// 钉钉返回 errcode，飞书返回 code，成功时为 0
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    int    `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if json.Unmarshal(raw, &result) == nil {
+		if result.ErrCode != 0 {
+			return fmt.Errorf("webhook error %d: %s", result.ErrCode, result.ErrMsg)
+		}
+		if result.Code != 0 {
+			return fmt.Errorf("webhook error %d: %s", result.Code, result.Msg)
+		}
+	}
+	return nil
+}
+
+// chatSign 钉钉和飞书的加签：base64(HMAC-SHA256)，钉钉以密钥为 key、时间戳和密钥为消息，飞书以时间戳和密钥为 key、消息为空
+func chatSign(key, msg string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(msg))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func slackPayload(ch ChatChannel, m chatMessage) map[string]interface{} {
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": "*" + m.Title + "*\n" + m.Text},
+		},
+	}
+	var elements []interface{}
+	for _, b := range m.Buttons {
+		el := map[string]interface{}{
+			"type": "button",
+			"text": map[string]string{"type": "plain_text", "text": b.Label},
+		}
+		switch {
+		case ch.interactive() && b.Action != "":
+			el["action_id"], el["value"] = b.Action, b.Value
+		case b.URL != "":
+			el["url"] = b.URL
+		default:
+			continue
+		}
+		if b.Style != "" {
+			el["style"] = b.Style
+		}
+		elements = append(elements, el)
+	}
+	if len(elements) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "actions", "elements": elements})
+	}
+	return map[string]interface{}{"text": m.Title + "\n" + m.Text, "blocks": blocks}
+}
+
+func dingTalkPayload(m chatMessage) map[string]interface{} {
+	text := "### " + m.Title + "\n\n" + m.Text
+	var btns []map[string]string
+	for _, b := range m.Buttons {
+		if b.URL != "" {
+			btns = append(btns, map[string]string{"title": b.Label, "actionURL": b.URL})
+		}
+	}
+	if len(btns) == 0 {
+		return map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": m.Title, "text": text},
+		}
+	}
+	return map[string]interface{}{
+		"msgtype": "actionCard",
+		"actionCard": map[string]interface{}{
+			"title":          m.Title,
+			"text":           text,
+			"btnOrientation": "1",
+			"btns":           btns,
+		},
+	}
+}
+
+func feishuPayload(m chatMessage) map[string]interface{} {
+	elements := []interface{}{
+		map[string]interface{}{
+			"tag":  "div",
+			"text": map[string]string{"tag": "lark_md", "content": m.Text},
+		},
+	}
+	var actions []interface{}
+	for _, b := range m.Buttons {
+		if b.URL == "" {
+			continue
+		}
+		style := "default"
+		if b.Style != "" {
+			style = b.Style
+		}
+		actions = append(actions, map[string]interface{}{
+			"tag":  "button",
+			"text": map[string]string{"tag": "plain_text", "content": b.Label},
+			"type": style,
+			"url":  b.URL,
+		})
+	}
+	if len(actions) > 0 {
+		elements = append(elements, map[string]interface{}{"tag": "action", "actions": actions})
+	}
+	return map[string]interface{}{
+		"msg_type": "interactive",
+		"card": map[string]interface{}{
+			"header":   map[string]interface{}{"title": map[string]string{"tag": "plain_text", "content": m.Title}},
+			"elements": elements,
+		},
+	}
+}
+
+// -----------------------
+// 事件
+// -----------------------
+
+// chatInterceptor 会话开始中继时发送 session_start
+type chatInterceptor struct {
+	BaseInterceptor
+}
+
+func (chatInterceptor) OnSessionStart(s HookSession) {
+	sess := relayHub.findSession(s.Token)
+	if sess == nil {
+		return
+	}
+	info := sess.info()
+	text := fmt.Sprintf("Token: `%s`\nFrom: %s\nAgent: %s", s.Token, info.Origin, info.AgentState)
+	if s.Tenant != "" {
+		text += "\nTenant: " + s.Tenant
+	}
+	postChatEvent(ChatEventSessionStart, chatMessage{
+		Title: "Session started",
+		Text:  text,
+		Buttons: []chatButton{
+			{Label: "Close session", Style: "danger", Action: slackActionCloseSession, Value: s.Token},
+		},
+	})
+}
+
+// postApprovalChat 发送待审批的终端请求
+func postApprovalChat(req ApprovalRequest) {
+	if len(hubConfig.ChatOps.Channels) == 0 {
+		return
+	}
+	approve := chatButton{Label: "Approve", Style: "primary", Action: slackActionApprove, Value: req.ID}
+	deny := chatButton{Label: "Deny", Style: "danger", Action: slackActionDeny, Value: req.ID}
+	if hubConfig.Approval.Secret != "" && hubConfig.Approval.PublicURL != "" {
+		approve.URL = approvalLink(req.ID, ApprovalApproved)
+		deny.URL = approvalLink(req.ID, ApprovalDenied)
+	}
+	postChatEvent(ChatEventApproval, chatMessage{
+		Title: "Terminal approval requested",
+		Text: fmt.Sprintf("%s requests a terminal on protected host `%s`\nFrom: %s\nRequest: %s\nExpires: %s",
+			req.Subject, req.Host, req.ClientIP, req.ID, req.ExpiresAt.Format(time.RFC3339)),
+		Buttons: []chatButton{approve, deny},
+	})
+}
+
+// sendAlertChat 告警规则中 chat 通道的发送
+func sendAlertChat(ch AlertChannel, alert Alert) error {
+	chat, ok := chatChannel(ch.Chat)
+	if !ok {
+		return fmt.Errorf("unknown chatOps channel %q", ch.Chat)
+	}
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.State), alert.Rule)
+	text := fmt.Sprintf("Target: %s\n%s", alert.Target, alert.Message)
+	if alert.Severity != "" {
+		text += "\nSeverity: " + alert.Severity
+	}
+	return sendChat(chat, chatMessage{Title: title, Text: text})
+}
+
+// -----------------------
+// Slack 交互回调
+// -----------------------
+
+var errSlackSignature = errors.New("invalid slack signature")
+
+// slackChannelFor 返回签名与请求匹配的 Slack 群，时间戳与当前相差超过 5 分钟的请求视为重放
+func slackChannelFor(r *http.Request, body []byte) (ChatChannel, error) {
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(sec, 0)).Abs() > 5*time.Minute {
+		return ChatChannel{}, errSlackSignature
+	}
+	got := r.Header.Get("X-Slack-Signature")
+	for _, ch := range hubConfig.ChatOps.Channels {
+		if !ch.interactive() {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(ch.SigningSecret))
+		mac.Write([]byte("v0:" + ts + ":"))
+		mac.Write(body)
+		if hmac.Equal([]byte(got), []byte("v0="+hex.EncodeToString(mac.Sum(nil)))) {
+			return ch, nil
+		}
+	}
+	return ChatChannel{}, errSlackSignature
+}
+
+// slackInteraction block_actions 回调中用到的字段
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// SlackInteractionHandler /chatops/slack，Slack App 的交互回调，按 Signing Secret 校验，不需要管理令牌
+func SlackInteractionHandler(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<20))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	ch, err := slackChannelFor(c.Request(), body)
+	if err != nil {
+		hubMetrics.Inc("hub_chatops_callback_rejections_total")
+		return c.String(http.StatusUnauthorized, err.Error())
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	var in slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &in); err != nil {
+		return c.String(http.StatusBadRequest, "invalid payload")
+	}
+	if in.Type != "block_actions" || len(in.Actions) == 0 {
+		return c.NoContent(http.StatusOK)
+	}
+	by := "slack:" + in.User.Username
+	if in.User.Username == "" {
+		by = "slack:" + in.User.ID
+	}
+	action := in.Actions[0]
+	reply := slackAction(action.ActionID, action.Value, by)
+	log.Printf("ChatOps channel %s: %s", ch.Name, reply)
+	hubMetrics.Inc("hub_chatops_callbacks_total", "action", action.ActionID)
+	if in.ResponseURL != "" {
+		go replySlack(in.ResponseURL, reply)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// slackAction 执行按钮对应的审批或管理操作，返回回复到群里的结果
+func slackAction(action, value, by string) string {
+	switch action {
+	case slackActionApprove, slackActionDeny:
+		status := ApprovalApproved
+		if action == slackActionDeny {
+			status = ApprovalDenied
+		}
+		req, err := hubApprovals.decide(value, status, by, "")
+		switch {
+		case errors.Is(err, errApprovalNotFound):
+			return fmt.Sprintf("Request %s not found", value)
+		case errors.Is(err, errApprovalDecided):
+			return fmt.Sprintf("Request %s is already %s", req.ID, req.Status)
+		}
+		return fmt.Sprintf("Request %s for %s on %s %s by %s", req.ID, req.Subject, req.Host, req.Status, by)
+	case slackActionCloseSession:
+		sess := relayHub.findSession(value)
+		if sess == nil {
+			return fmt.Sprintf("Session %s not found", value)
+		}
+		sess.closeByAdmin(by)
+		return fmt.Sprintf("Session %s closed by %s", value, by)
+	}
+	return fmt.Sprintf("Unknown action %q", action)
+}
+
+func replySlack(responseURL, text string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"response_type":    "in_channel",
+		"replace_original": false,
+		"text":             text,
+	})
+	resp, err := chatClient.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Println("Slack response error:", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,196 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+)
+
+// -----------------------
+// 帧级校验：在 hello 阶段协商，对 d 字段计算 CRC32，用于发现中间代理造成的数据损坏
+// -----------------------
+
+const (
+	ActionHello       = "hello"
+	ActionRenegotiate = "renegotiate"
+
+	FeatureChecksum = "crc32"
+
+	// 连续校验失败达到该次数后认为对端实现有问题，自动关闭校验特性
+	MaxCorruptedFrames = 3
+)
+
+// HelloData 为 hello 请求/响应中的数据
+type HelloData struct {
+	Features []string `json:"features"`
+	// 前端支持的能力，响应中为 hub 实际使用的能力，见 capabilities.go
+	Capabilities []string `json:"capabilities"`
+	// 开启会话亲和时为本节点 ID，前端重连时可以作为 ?node= 参数带上
+	Node string `json:"node,omitempty"`
+}
+
+// frameChecksum 计算 d 字段紧凑 JSON 编码的 CRC32（IEEE），以 8 位十六进制表示
+func frameChecksum(rawData json.RawMessage) string {
+	var buf bytes.Buffer
+	if len(rawData) > 0 {
+		if err := json.Compact(&buf, rawData); err != nil {
+			buf.Reset()
+			buf.Write(rawData)
+		}
+	}
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(buf.Bytes()))
+}
+
+// stampChecksum 给一帧 JSON 消息补上 c 字段，非 JSON 对象的帧原样返回
+func stampChecksum(data []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data
+	}
+	sum, _ := json.Marshal(frameChecksum(fields["d"]))
+	fields["c"] = sum
+	stamped, err := json.Marshal(fields)
+	if err != nil {
+		return data
+	}
+	return stamped
+}
+
+// handleHello 处理客户端的特性协商请求，返回 hub 接受的特性列表
+func (s *RelaySession) handleHello(client *wsClientConn, msg WebSocketMessage) {
+	var hello HelloData
+	if raw, err := json.Marshal(msg.Data); err == nil {
+		_ = json.Unmarshal(raw, &hello)
+	}
+
+	accepted := []string{}
+	checksum, notifyAck, fragment, encrypted := false, false, false, false
+	for _, f := range hello.Features {
+		if f == FeatureChecksum && s.feature(FlagChecksum) {
+			checksum = true
+			accepted = append(accepted, f)
+		}
+		if f == FeatureNotifyAck && len(hubConfig.NotifyAck.Actions) > 0 {
+			notifyAck = true
+			accepted = append(accepted, f)
+		}
+		if f == FeatureFragment && hubConfig.Fragment.Size > 0 {
+			fragment = true
+			accepted = append(accepted, f)
+		}
+		if f == FeatureE2E && hubConfig.E2E.Enabled {
+			encrypted = true
+			accepted = append(accepted, f)
+		}
+	}
+
+	client.mu.Lock()
+	client.checksumEnabled = checksum
+	client.corruptedFrames = 0
+	client.fragmentEnabled = fragment
+	client.e2eEnabled = encrypted
+	client.mu.Unlock()
+	client.setNotifyAck(notifyAck)
+	caps := client.applyCapabilities(s.token, hello.Capabilities)
+
+	response := WebSocketMessage{
+		Type:      MessageTypeResponse,
+		RequestID: msg.RequestID,
+		Action:    ActionHello,
+		Data:      HelloData{Features: accepted, Capabilities: caps, Node: affinityNode()},
+	}
+	respData, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Hello marshal error:", err)
+		return
+	}
+	s.sendTo(client, respData)
+}
+
+// checksumOn 返回该连接是否已协商帧校验
+func (c *wsClientConn) checksumOn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checksumEnabled
+}
+
+// fragmentOn 返回该连接是否已协商分片发送
+func (c *wsClientConn) fragmentOn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fragmentEnabled
+}
+
+// verifyChecksum 校验前端发来的帧，返回 false 表示该帧应被丢弃
+func (s *RelaySession) verifyChecksum(client *wsClientConn, data []byte) bool {
+	if !client.checksumOn() {
+		return true
+	}
+
+	var frame struct {
+		RequestID string          `json:"r"`
+		Data      json.RawMessage `json:"d"`
+		Checksum  string          `json:"c"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return true
+	}
+
+	// 协商后仍不带校验值，说明对端并不支持，重新协商关闭该特性
+	if frame.Checksum == "" {
+		s.renegotiateChecksum(client, "peer sent frame without checksum")
+		return true
+	}
+	if frame.Checksum == frameChecksum(frame.Data) {
+		client.mu.Lock()
+		client.corruptedFrames = 0
+		client.mu.Unlock()
+		return true
+	}
+
+	hubMetrics.Inc("hub_frames_corrupted_total", "leg", "client")
+	log.Printf("Session %s corrupted frame, request %q", s.token, frame.RequestID)
+
+	client.mu.Lock()
+	client.corruptedFrames++
+	corrupted := client.corruptedFrames
+	client.mu.Unlock()
+
+	notify := WebSocketMessage{
+		Type:      MessageTypeNotify,
+		RequestID: frame.RequestID,
+		Action:    "checksum_mismatch",
+		Data:      "Frame checksum mismatch, frame dropped",
+		Error:     &MessageError{Code: ErrCodeChecksum, Reason: "frame checksum mismatch"},
+	}
+	s.notifyClient(client, notify)
+
+	if corrupted >= MaxCorruptedFrames {
+		s.renegotiateChecksum(client, "too many corrupted frames")
+	}
+	return false
+}
+
+// renegotiateChecksum 关闭该连接的帧校验并通知前端
+func (s *RelaySession) renegotiateChecksum(client *wsClientConn, reason string) {
+	client.mu.Lock()
+	if !client.checksumEnabled {
+		client.mu.Unlock()
+		return
+	}
+	client.checksumEnabled = false
+	client.corruptedFrames = 0
+	client.mu.Unlock()
+
+	hubMetrics.Inc("hub_checksum_renegotiations_total")
+	log.Printf("Session %s drops %s: %s", s.token, FeatureChecksum, reason)
+
+	notify := WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: ActionRenegotiate,
+		Data:   HelloData{Features: []string{}},
+	}
+	s.notifyClient(client, notify)
+}
@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"echo_demo/upload2"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 启动清理：开始接受连接前检查上次运行遗留的文件——未合并的分片目录、中断的合并标记和输出文件、
+// 写了一半的临时块、任务和资产清单的临时文件，以及录像库中的临时文件和与索引不一致的录像。排队中的合并任务引用的分片目录保留，tmpfs 中保留的分片重新计入内存预算；
+// Mode 为 report 时只报告不删除。结果写入日志，可以通过 GET /admin/cleanup 查看。
+// 通过监听交接启动的新进程不清理，旧进程可能仍在写入这些文件
+// -----------------------
+
+const (
+	CleanupOff    = "off"
+	CleanupReport = "report"
+	CleanupRemove = "remove"
+)
+
+// CleanupConfig ChunkMaxAge 内有写入的分片目录和临时文件视为仍在使用
+type CleanupConfig struct {
+	Mode        string   `json:"mode"`
+	ChunkMaxAge Duration `json:"chunkMaxAge"`
+}
+
+func (cfg CleanupConfig) validate() error {
+	switch cfg.Mode {
+	case "", CleanupOff, CleanupReport, CleanupRemove:
+	default:
+		return fmt.Errorf("unknown cleanup.mode %q", cfg.Mode)
+	}
+	if cfg.ChunkMaxAge.D() < 0 {
+		return fmt.Errorf("cleanup.chunkMaxAge must not be negative")
+	}
+	return nil
+}
+
+// CleanupResult 一次清理的结果
+type CleanupResult struct {
+	StartedAt    time.Time        `json:"startedAt"`
+	DurationMs   int64            `json:"durationMs"`
+	DryRun       bool             `json:"dryRun"`
+	Removed      int              `json:"removed"`
+	RemovedBytes int64            `json:"removedBytes"`
+	Kept         int              `json:"kept"`
+	Failed       int              `json:"failed"`
+	Items        []upload2.Orphan `json:"items"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// lastCleanup 启动时写入一次，之后只读
+var lastCleanup *CleanupResult
+
+// runStartupCleanup 应在暂存、块存储、录像库和任务队列配置完成之后、开始监听之前调用
+func runStartupCleanup(cfg CleanupConfig) *CleanupResult {
+	res := &CleanupResult{StartedAt: time.Now(), DryRun: cfg.Mode == CleanupReport}
+	policy := upload2.CleanupPolicy{
+		ChunkMaxAge: cfg.ChunkMaxAge.D(),
+		Keep:        pendingMergeHashes(),
+		DryRun:      res.DryRun,
+	}
+	items, err := upload2.CleanupOrphans(policy)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if hubConfig.Jobs.Enabled() {
+		items = append(items, cleanupTempFiles(filepath.Join(hubConfig.Jobs.Dir, "*.json.tmp"), "job_tmp", policy)...)
+	}
+	if hubConfig.InventoryFile != "" {
+		items = append(items, cleanupTempFiles(filepath.Join(filepath.Dir(hubConfig.InventoryFile), ".inventory-*"), "inventory_tmp", policy)...)
+	}
+	if hubRecordings != nil {
+		items = append(items, cleanupTempFiles(filepath.Join(hubRecordings.cfg.Dir, ".recording-*"), "recording_tmp", policy)...)
+		items = append(items, hubRecordings.reconcile(policy)...)
+	}
+	res.Items = items
+	for _, o := range items {
+		switch o.Action {
+		case upload2.OrphanRemoved, upload2.OrphanWouldRemove:
+			res.Removed++
+			res.RemovedBytes += o.Bytes
+		case upload2.OrphanFailed:
+			res.Failed++
+			log.Printf("Cleanup %s %s failed: %s", o.Kind, o.Path, o.Reason)
+		default:
+			res.Kept++
+		}
+	}
+	res.DurationMs = time.Since(res.StartedAt).Milliseconds()
+	if !res.DryRun {
+		hubMetrics.Add("hub_cleanup_removed_total", int64(res.Removed))
+		hubMetrics.Add("hub_cleanup_removed_bytes_total", res.RemovedBytes)
+	}
+	verb := "removed"
+	if res.DryRun {
+		verb = "would remove"
+	}
+	log.Printf("Startup cleanup %s %d items (%d bytes), kept %d, failed %d", verb, res.Removed, res.RemovedBytes, res.Kept, res.Failed)
+	if res.Error != "" {
+		log.Println("Startup cleanup error:", res.Error)
+	}
+	return res
+}
+
+// pendingMergeHashes 排队或运行中的合并任务引用的分片 hash
+func pendingMergeHashes() func(hash string) bool {
+	keep := make(map[string]bool)
+	if hubJobs != nil {
+		for _, job := range hubJobs.List("") {
+			if job.Kind != JobMerge || job.State.Finished() {
+				continue
+			}
+			var dto upload2.MergeChunksDto
+			if json.Unmarshal(job.Params, &dto) == nil && dto.Hash != "" {
+				keep[dto.Hash] = true
+			}
+		}
+	}
+	return func(hash string) bool { return keep[hash] }
+}
+
+// cleanupTempFiles 删除匹配 pattern 且超过 ChunkMaxAge 未修改的临时文件
+func cleanupTempFiles(pattern, kind string, p upload2.CleanupPolicy) []upload2.Orphan {
+	matches, _ := filepath.Glob(pattern)
+	var items []upload2.Orphan
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < p.ChunkMaxAge {
+			continue
+		}
+		o := upload2.Orphan{Kind: kind, Path: m, Bytes: info.Size(), Action: upload2.OrphanRemoved}
+		if p.DryRun {
+			o.Action = upload2.OrphanWouldRemove
+		} else if err := os.Remove(m); err != nil {
+			o.Action, o.Reason = upload2.OrphanFailed, err.Error()
+		}
+		items = append(items, o)
+	}
+	return items
+}
+
+// GetCleanupHandler 返回启动清理的结果，未执行清理时返回 404
+func GetCleanupHandler(c echo.Context) error {
+	if lastCleanup == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "startup cleanup did not run"})
+	}
+	return c.JSON(http.StatusOK, lastCleanup)
+}
@@ -0,0 +1,215 @@
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"echo_demo/geoip"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// -----------------------
+// 前端的真实 IP 和地理归属：直连地址属于 TrustedProxies 时，从 X-Forwarded-For 右侧开始跳过可信代理，
+// 取第一个不可信的地址作为前端 IP，否则使用直连地址。配置了 GeoIP 数据时查询国家和 ASN，
+// 结果记录在会话上，出现在管理接口、连接日志和会话报告中。
+// 连接建立前依次执行 IP 策略，BlockCountries、BlockASNs 是内置的一个策略，其它策略通过 RegisterIPPolicy 注册
+// -----------------------
+
+// ClientIPConfig TrustedProxies 为 CIDR 或单个 IP，为空时不信任任何转发头；
+// 来自可信代理的请求同时信任 X-Forwarded-Proto 和 X-Forwarded-Host（见 proxy.go）
+type ClientIPConfig struct {
+	TrustedProxies []string     `json:"trustedProxies,omitempty"`
+	Header         string       `json:"header"` // 默认 X-Forwarded-For
+	GeoIP          geoip.Config `json:"geoip"`
+	BlockCountries []string     `json:"blockCountries,omitempty"` // ISO 3166 两位国家代码
+	BlockASNs      []int        `json:"blockASNs,omitempty"`
+}
+
+func (cfg ClientIPConfig) validate() error {
+	_, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("clientIP.trustedProxies: %w", err)
+	}
+	if (len(cfg.BlockCountries) > 0 || len(cfg.BlockASNs) > 0) && !cfg.GeoIP.Enabled() {
+		return errors.New("clientIP.blockCountries and blockASNs require clientIP.geoip.file")
+	}
+	return nil
+}
+
+// parseCIDRs 单个 IP 按 /32 或 /128 处理
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil {
+				bits := 128
+				if ip.To4() != nil {
+					bits = 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+var (
+	// trustedProxies 启动时由 ClientIP.TrustedProxies 解析
+	trustedProxies []*net.IPNet
+	// hubGeoIP 为 nil 表示未启用 GeoIP
+	hubGeoIP *geoip.DB
+)
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// unixPeerKey 标记经 Unix 套接字建立的连接，见 markUnixPeer
+type unixPeerKey struct{}
+
+// markUnixPeer 作为 http.Server.ConnContext 使用。Unix 套接字只有本机进程能连接，
+// 对端按可信代理处理
+func markUnixPeer(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if _, ok := c.(*net.UnixConn); ok {
+		return context.WithValue(ctx, unixPeerKey{}, true)
+	}
+	return ctx
+}
+
+// peerIP 返回请求的直连地址以及它是否为可信代理，Unix 套接字连接的地址为 nil
+func peerIP(r *http.Request) (net.IP, bool) {
+	if unixPeer, _ := r.Context().Value(unixPeerKey{}).(bool); unixPeer {
+		return nil, true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip, ip != nil && isTrustedProxy(ip)
+}
+
+// clientIP 返回请求的真实来源地址
+func clientIP(r *http.Request) net.IP {
+	ip, trusted := peerIP(r)
+	if !trusted {
+		return ip
+	}
+	header := hubConfig.ClientIP.Header
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	// 多个同名头按顺序拼接，最右侧是离 hub 最近的一跳
+	var hops []string
+	for _, v := range r.Header.Values(header) {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// ClientOrigin 前端的来源地址和地理归属
+type ClientOrigin struct {
+	IP  string          `json:"ip,omitempty"`
+	Geo *geoip.Location `json:"geo,omitempty"`
+}
+
+func (o ClientOrigin) String() string {
+	if o.Geo == nil {
+		return o.IP
+	}
+	return fmt.Sprintf("%s (%s AS%d)", o.IP, o.Geo.Country, o.Geo.ASN)
+}
+
+// resolveOrigin 识别请求的来源地址并查询地理归属
+func resolveOrigin(r *http.Request) ClientOrigin {
+	ip := clientIP(r)
+	if ip == nil {
+		return ClientOrigin{}
+	}
+	origin := ClientOrigin{IP: ip.String()}
+	if hubGeoIP != nil {
+		if loc, ok := hubGeoIP.Lookup(ip); ok {
+			origin.Geo = &loc
+		}
+	}
+	return origin
+}
+
+// IPPolicy 连接建立前检查来源，返回错误表示拒绝；ident 为已识别的身份
+type IPPolicy func(origin ClientOrigin, ident *Identity) error
+
+var (
+	ipPoliciesMu sync.RWMutex
+	ipPolicies   = []IPPolicy{geoBlockPolicy}
+)
+
+// RegisterIPPolicy 追加一个来源策略，应在服务启动前调用
+func RegisterIPPolicy(p IPPolicy) {
+	ipPoliciesMu.Lock()
+	defer ipPoliciesMu.Unlock()
+	ipPolicies = append(ipPolicies, p)
+}
+
+// checkOrigin 依次执行来源策略
+func checkOrigin(origin ClientOrigin, ident *Identity) error {
+	ipPoliciesMu.RLock()
+	policies := ipPolicies
+	ipPoliciesMu.RUnlock()
+	for _, p := range policies {
+		if err := p(origin, ident); err != nil {
+			hubMetrics.Inc("hub_origin_blocked_total")
+			log.Printf("Reject client %s from %s: %v", ident.Subject, origin, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// geoBlockPolicy 按 BlockCountries 和 BlockASNs 拒绝，查不到归属的地址不拦截
+func geoBlockPolicy(origin ClientOrigin, _ *Identity) error {
+	if origin.Geo == nil {
+		return nil
+	}
+	cfg := hubConfig.ClientIP
+	for _, c := range cfg.BlockCountries {
+		if strings.EqualFold(c, origin.Geo.Country) {
+			return fmt.Errorf("connections from country %s are not allowed", origin.Geo.Country)
+		}
+	}
+	for _, asn := range cfg.BlockASNs {
+		if asn == origin.Geo.ASN {
+			return fmt.Errorf("connections from AS%d are not allowed", asn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package hub
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", " 192.168.1.1 ", "fd00::/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.1/32", "fd00::/8", "2001:db8::1/128"}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("parseCIDRs[%d] = %s, want %s", i, n, want[i])
+		}
+	}
+	for _, bad := range []string{"10.0.0.0/33", "example.com", ""} {
+		if _, err := parseCIDRs([]string{bad}); err == nil {
+			t.Errorf("parseCIDRs(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	savedProxies, savedCfg := trustedProxies, hubConfig.ClientIP
+	t.Cleanup(func() { trustedProxies, hubConfig.ClientIP = savedProxies, savedCfg })
+	var err error
+	if trustedProxies, err = parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		peer   string // 为空表示 Unix 套接字
+		header string
+		values []string
+		want   string
+	}{
+		{name: "untrusted peer ignores header", peer: "203.0.113.9:5000", values: []string{"1.2.3.4"}, want: "203.0.113.9"},
+		{name: "trusted peer without header", peer: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "single hop", peer: "10.0.0.1:5000", values: []string{"1.2.3.4"}, want: "1.2.3.4"},
+		{name: "spoofed left hops ignored", peer: "10.0.0.1:5000", values: []string{"9.9.9.9, 1.2.3.4"}, want: "1.2.3.4"},
+		{name: "trusted hops skipped", peer: "10.0.0.1:5000", values: []string{"1.2.3.4, 10.0.0.3, 10.0.0.2"}, want: "1.2.3.4"},
+		{name: "all hops trusted", peer: "10.0.0.1:5000", values: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "repeated headers joined in order", peer: "10.0.0.1:5000", values: []string{"9.9.9.9", "1.2.3.4, 10.0.0.2"}, want: "1.2.3.4"},
+		{name: "whitespace around hops", peer: "10.0.0.1:5000", values: []string{" 1.2.3.4 ,10.0.0.2 "}, want: "1.2.3.4"},
+		{name: "invalid hop stops the walk", peer: "10.0.0.1:5000", values: []string{"1.2.3.4, unknown, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "invalid rightmost hop", peer: "10.0.0.1:5000", values: []string{"1.2.3.4, unknown"}, want: "10.0.0.1"},
+		{name: "single trusted ip is not a range", peer: "192.168.1.2:5000", values: []string{"1.2.3.4"}, want: "192.168.1.2"},
+		{name: "ipv6", peer: "[fd00::1]:443", values: []string{"2001:db8::7"}, want: "2001:db8::7"},
+		{name: "custom header", peer: "10.0.0.1:5000", header: "X-Real-IP", values: []string{"1.2.3.4"}, want: "1.2.3.4"},
+		{name: "unix socket peer is trusted", values: []string{"1.2.3.4"}, want: "1.2.3.4"},
+		{name: "unix socket without header", want: "<nil>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hubConfig.ClientIP.Header = tt.header
+			header := tt.header
+			if header == "" {
+				header = "X-Forwarded-For"
+			}
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = tt.peer
+			if tt.peer == "" {
+				r.RemoteAddr = "@"
+				r = r.WithContext(context.WithValue(r.Context(), unixPeerKey{}, true))
+			}
+			for _, v := range tt.values {
+				r.Header.Add(header, v)
+			}
+			if got := clientIP(r); got.String() != tt.want {
+				t.Fatalf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package hub
+
+import "time"
+
+// -----------------------
+// 时钟：agent 重连的退避等待、反向注册等待和重试计数的重置窗口通过 hubClock 计时，
+// 测试中用 WithClock 换成可以手动推进的时钟（见 hubtest.FakeClock）
+// -----------------------
+
+// Clock 重连逻辑使用的时间来源
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var hubClock Clock = realClock{}
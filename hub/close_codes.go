@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 关闭码：hub 关闭前端连接时发送关闭帧，关闭码表示前端应如何处理，原因为机器可读的短字符串。
+//   1001 going away        hub 停机，原因 shutdown，前端可以退避后重连（会连到其它节点）
+//   1008 policy violation  管理员关闭、时间窗结束、超过会话内存上限，原因 logout、access window ended、
+//                          session memory limit exceeded，前端不应自动重连
+//   1009 message too big   前端消息超过大小限制，原因 message too big
+//   1011 internal error    agent 连接失败或重连次数用尽，原因 agent_failure、dial_failure，前端可以退避后重连
+//   1013 try again later   会话数超过上限，升级时即拒绝
+//   4000 idle timeout      会话空闲或断线续传超时，原因 idle、zombie、resume_expired，用户操作后再重连
+//   4008 slow consumer     前端跟不上发送速度或发送队列已满，原因 slow consumer，前端可以立即重连
+// -----------------------
+
+// CloseCodeIdleTimeout 应用自定义关闭码，会话因空闲被关闭
+const CloseCodeIdleTimeout = 4000
+
+// cleanupCloseTimeout 关闭会话时每个前端写关闭帧的最长等待，避免阻塞的连接拖慢停机
+const cleanupCloseTimeout = 200 * time.Millisecond
+
+// closeFrame 关闭码和原因
+type closeFrame struct {
+	code   int
+	reason string
+}
+
+// endReasonCloseFrames 会话结束原因对应的关闭帧，未列出的原因使用 1000 并以结束原因作为原因
+var endReasonCloseFrames = map[string]closeFrame{
+	EndReasonIdle:          {CloseCodeIdleTimeout, EndReasonIdle},
+	EndReasonZombie:        {CloseCodeIdleTimeout, EndReasonZombie},
+	EndReasonResumeExpired: {CloseCodeIdleTimeout, EndReasonResumeExpired},
+	EndReasonAgentFailure:  {websocket.CloseInternalServerErr, EndReasonAgentFailure},
+	EndReasonDialFailure:   {websocket.CloseInternalServerErr, EndReasonDialFailure},
+	EndReasonMemoryLimit:   {CloseCodeResourceLimit, ResourceLimitReason},
+	EndReasonShutdown:      {websocket.CloseGoingAway, EndReasonShutdown},
+	EndReasonLogout:        {websocket.ClosePolicyViolation, EndReasonLogout},
+}
+
+// closeFrameFor 返回会话以 endReason 结束时发给前端的关闭帧
+func closeFrameFor(endReason string) closeFrame {
+	if f, ok := endReasonCloseFrames[endReason]; ok {
+		return f
+	}
+	if endReason == "" {
+		endReason = EndReasonClosed
+	}
+	return closeFrame{websocket.CloseNormalClosure, endReason}
+}
+
+// writeCloseFrame 直接发送关闭帧，不等待发送队列；连接阻塞时最多等待 timeout
+func (c *wsClientConn) writeCloseFrame(code int, text string, timeout time.Duration) {
+	msg := websocket.FormatCloseMessage(code, text)
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(timeout))
+}
+
+// abortWithCode 不写出队列中积压的数据，直接发送关闭帧并关闭底层连接，
+// 由 clientReadLoop 的读错误触发正常的清理流程
+func (c *wsClientConn) abortWithCode(code int, text string) {
+	c.writeCloseFrame(code, text, time.Second)
+	c.conn.Close()
+}
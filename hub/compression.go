@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"compress/flate"
+	"echo_demo/bufpool"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// permessage-deflate 压缩：前端和 agent 连接在握手时协商，对方不支持时自动退回不压缩。
+// 终端回显、心跳类的小帧压缩收益低于开销，小于 Threshold 的帧不压缩。
+// compression 功能开关关闭的会话不压缩发给前端的帧（握手仍可协商，只是不再使用）
+// -----------------------
+
+// CompressionConfig Level 为 flate 压缩级别（-2 到 9），Threshold 为参与压缩的最小帧长度（字节）
+type CompressionConfig struct {
+	Enabled   bool `json:"enabled"`
+	Level     int  `json:"level"`
+	Threshold int  `json:"threshold"`
+}
+
+func (cfg CompressionConfig) validate() error {
+	if cfg.Level < flate.HuffmanOnly || cfg.Level > flate.BestCompression {
+		return fmt.Errorf("compression level %d out of range [%d, %d]", cfg.Level, flate.HuffmanOnly, flate.BestCompression)
+	}
+	if cfg.Threshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+	return nil
+}
+
+// agentDialer 主动连接 agent 时使用的拨号器
+var agentDialer = websocket.DefaultDialer
+
+// configureCompression 按配置开启升级器和拨号器的压缩协商，应在开始监听前调用
+func configureCompression(cfg CompressionConfig) {
+	upgrader.EnableCompression = cfg.Enabled
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = cfg.Enabled
+	dialer.WriteBufferPool = bufpool.WritePool
+	agentDialer = &dialer
+}
+
+// setupCompression 设置新连接的压缩级别，未协商压缩时不起作用
+func setupCompression(conn *websocket.Conn) {
+	if !hubConfig.Compression.Enabled {
+		return
+	}
+	// 级别已在启动时校验过
+	_ = conn.SetCompressionLevel(hubConfig.Compression.Level)
+}
+
+// writeText 写出一个文本帧，按帧长度决定是否压缩；Redis 中继等非 WebSocket 连接直接写出
+func writeText(conn messageConn, data []byte) error {
+	return writeMessage(conn, websocket.TextMessage, data)
+}
+
+// writeMessage 写出一帧，写入受 WriteTimeout 限制，超时后连接不可再用，由 writePump 退出并关闭
+
+func writeMessage(conn messageConn, messageType int, data []byte) error {
+	return writeMessageWith(conn, messageType, data, true)
+}
+
+// writeMessageWith compress 为 false 时不压缩，用于未声明 compression 能力的前端
+func writeMessageWith(conn messageConn, messageType int, data []byte, compress bool) error {
+	if ws, ok := conn.(*websocket.Conn); ok && hubConfig.Compression.Enabled {
+		ws.EnableWriteCompression(compress && len(data) >= hubConfig.Compression.Threshold)
+	}
+	if timeout := hubConfig.WriteTimeout.D(); timeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	return conn.WriteMessage(messageType, data)
+}
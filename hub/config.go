@@ -0,0 +1,571 @@
+package hub
+
+import (
+	"compress/flate"
+	"echo_demo/egress"
+	"echo_demo/jobs"
+	"echo_demo/jwtauth"
+	"echo_demo/ldapauth"
+	"echo_demo/mailer"
+	"echo_demo/sshutil"
+	"echo_demo/term"
+	"echo_demo/tracing"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// -----------------------
+// 运行配置：默认值，可通过 HUB_CONFIG 指定的 JSON 文件覆盖
+// -----------------------
+
+type Config struct {
+	// 监听地址，unix:/path 表示 Unix 套接字；ReusePort 开启 SO_REUSEPORT，便于新旧进程同时监听。
+	// 由 systemd 套接字激活启动时使用 systemd 传入的套接字
+	ListenAddr string `json:"listenAddr"`
+	ReusePort  bool   `json:"reusePort"`
+	// Unix 套接字文件的权限，比如 "0660"，为空时按 umask
+	UnixSocketMode string `json:"unixSocketMode,omitempty"`
+
+	// 反向代理部署时全部路由的路径前缀，比如 /hub；代理的地址需要加入 ClientIP.TrustedProxies
+	BasePath string `json:"basePath,omitempty"`
+
+	// registerRoutes 注册的前端入口，嵌入时自己挂载 HandleClient 的调用方可以关闭
+	Routes RoutesConfig `json:"routes"`
+
+	// 主监听的 HTTPS/WSS，未配置证书时使用明文 HTTP
+	TLS TLSConfig `json:"tls"`
+	// 主动拨号 wss:// agent 时的 TLS 选项
+	AgentTLS AgentTLSConfig `json:"agentTLS"`
+
+	// 机器客户端的 mTLS 监听，证书 CN 映射为 token 和租户
+	MTLS MTLSConfig `json:"mtls"`
+
+	// WS 心跳：每 KeepalivePingInterval 向前端和 agent 发送 ping 控制帧，
+	// 超过 KeepalivePongWait 没有收到 pong 或其它消息时断开，KeepalivePingInterval 为 0 时不发送
+	KeepalivePingInterval Duration `json:"keepalivePingInterval"`
+	KeepalivePongWait     Duration `json:"keepalivePongWait"`
+
+	// writePump 写出一帧的超时，超时后断开连接，0 表示不限制
+	WriteTimeout Duration `json:"writeTimeout"`
+
+	// 发送队列满时的处理策略：drop_oldest、drop_newest 或 disconnect，前端和 agent 分别配置
+	ClientOverflowPolicy OverflowPolicy `json:"clientOverflowPolicy"`
+	AgentOverflowPolicy  OverflowPolicy `json:"agentOverflowPolicy"`
+
+	// agent 重连期间最多缓存的客户端消息条数，0 表示不缓存直接丢弃
+	PendingQueueSize int `json:"pendingQueueSize"`
+
+	// 反向注册的 agent，键为 agent ID；agent 以 Key 认证，只能服务 Token 对应的会话
+	Agents map[string]AgentCredential `json:"agents,omitempty"`
+
+	// 慢消费者检测：单帧排队时长或写耗时超过阈值记为一次慢帧，
+	// 连续慢帧达到 SlowConsumerStrikes 时通知前端，达到 SlowConsumerDisconnectStrikes 时断开（0 表示不断开）
+	SlowConsumerResidency         Duration `json:"slowConsumerResidency"`
+	SlowConsumerWriteLatency      Duration `json:"slowConsumerWriteLatency"`
+	SlowConsumerStrikes           int      `json:"slowConsumerStrikes"`
+	SlowConsumerDisconnectStrikes int      `json:"slowConsumerDisconnectStrikes"`
+	// 当前一帧写了 SlowConsumerStall 仍未完成，或发送队列积压到 SlowConsumerMaxQueue 帧时直接断开，
+	// 达到一半时通知前端；0 表示不检查
+	SlowConsumerStall    Duration `json:"slowConsumerStall"`
+	SlowConsumerMaxQueue int      `json:"slowConsumerMaxQueue"`
+	// 慢消费者是否降级：丢弃非必要的 notify
+	SlowConsumerDegrade bool `json:"slowConsumerDegrade"`
+
+	// 管理接口使用的令牌，为空时禁用 /admin
+	AdminToken string `json:"adminToken"`
+	// 配置包的 HMAC 签名密钥，为空时不能导出和导入配置包
+	BundleKey string `json:"bundleKey,omitempty"`
+	// 启动时的维护模式状态，运行中可通过 /admin/maintenance 修改
+	Maintenance MaintenanceState `json:"maintenance"`
+	// 启动时的只读模式状态，运行中可通过 /admin/readonly 修改；ReadOnlyActions 为只读模式下拒绝的中继 action
+	ReadOnly        ReadOnlyState `json:"readOnly"`
+	ReadOnlyActions []string      `json:"readOnlyActions,omitempty"`
+
+	// 单个会话排队、暂存、续传缓存和分片重组数据的内存上限（字节），超过后关闭会话，0 表示不限制
+	SessionMemoryLimit int64 `json:"sessionMemoryLimit"`
+
+	// 会话在 SessionIdleTimeout 内没有中继消息时关闭（0 表示不限制），
+	// 每隔 SessionSweepInterval 回收没有前端也没有 agent 的僵尸会话（0 表示不清扫）
+	SessionIdleTimeout   Duration `json:"sessionIdleTimeout"`
+	SessionSweepInterval Duration `json:"sessionSweepInterval"`
+
+	// 断线续传（replay_buffer 开关）：最多缓存的 agent 消息条数，以及最后一个前端断开后会话保留的时长
+	ReplayBufferSize  int      `json:"replayBufferSize"`
+	ClientResumeGrace Duration `json:"clientResumeGrace"`
+	// 会话亲和，多节点部署时让前端重连回到持有 agent 连接的节点，见 affinity.go
+	Affinity AffinityConfig `json:"affinity"`
+	// 带序号的前端消息提前到达时最多暂存的条数，以及等待缺失消息的时长，超过后放弃缺失的消息
+	ClientSeqWindow  int      `json:"clientSeqWindow"`
+	ClientSeqTimeout Duration `json:"clientSeqTimeout"`
+
+	// 协议实验，会话创建时按 token 分组
+	Experiments []Experiment `json:"experiments,omitempty"`
+
+	// 优雅退出时等待进行中请求完成的最长时间
+	DrainTimeout Duration `json:"drainTimeout"`
+
+	// 上传分片暂存：UploadMemoryDir 为 tmpfs 目录（为空表示不启用），
+	// 文件不超过 UploadSmallFileLimit 且 tmpfs 占用不超过 UploadMemoryBudget 时暂存在 tmpfs
+	UploadMemoryDir      string `json:"uploadMemoryDir"`
+	UploadDiskDir        string `json:"uploadDiskDir"`
+	UploadSmallFileLimit int64  `json:"uploadSmallFileLimit"`
+	UploadMemoryBudget   int64  `json:"uploadMemoryBudget"`
+
+	// 去重块存储：UploadBlockStoreDir 为空表示不启用，未被引用的块超过 UploadBlockGCGrace 后才会被清理
+	UploadBlockStoreDir string   `json:"uploadBlockStoreDir"`
+	UploadBlockGCGrace  Duration `json:"uploadBlockGCGrace"`
+
+	// 合并限流：最多同时进行 UploadMergeMaxConcurrent 个合并（0 表示不限制），每个合并的写入速率
+	// 不超过 UploadMergeBytesPerSecond（0 表示不限速）；UploadMergeNice、UploadMergeIOClass 降低合并线程的优先级
+	UploadMergeMaxConcurrent  int    `json:"uploadMergeMaxConcurrent"`
+	UploadMergeBytesPerSecond int64  `json:"uploadMergeBytesPerSecond"`
+	UploadMergeNice           int    `json:"uploadMergeNice"`
+	UploadMergeIOClass        string `json:"uploadMergeIOClass"`
+	UploadMergeIOPriority     int    `json:"uploadMergeIOPriority"`
+
+	// 下载缓存：DownloadCacheDir 为空表示不启用，总大小超过 DownloadCacheMaxBytes 时按 LRU 淘汰，
+	// 超过 DownloadCacheMaxFileSize 的文件不缓存（0 表示不限制）
+	DownloadCacheDir         string `json:"downloadCacheDir"`
+	DownloadCacheMaxBytes    int64  `json:"downloadCacheMaxBytes"`
+	DownloadCacheMaxFileSize int64  `json:"downloadCacheMaxFileSize"`
+
+	// 可通过 /api/exec 等接口按名称引用的 SSH 主机；终端和下载未指定 host 时连接名为 "default" 的主机，
+	// 没有登记时返回未知主机错误
+	SSHProfiles map[string]sshutil.Profile `json:"sshProfiles,omitempty"`
+	// 按调用方覆盖终端使用的账号和凭据：身份主体 -> 主机 ID（默认主机为 "default"）-> 凭据，
+	// 凭据中未填写的 addr 和主机密钥设置沿用主机本身的配置
+	SSHCredentials map[string]map[string]sshutil.Profile `json:"sshCredentials,omitempty"`
+	// SSH 主机密钥校验：profile 未配置 knownHostsFile 或 hostKeyFingerprints 时使用这里的 known_hosts 文件，
+	// trustOnFirstUse 时首次连接的主机记录到该文件；knownHostsFile 显式置空时不校验主机密钥，只记录警告
+	SSHHostKeys sshutil.HostKeyConfig `json:"sshHostKeys"`
+
+	// 资产清单（主机、agent、分组）的持久化文件，为空时清单只保存在内存中
+	InventoryFile string `json:"inventoryFile"`
+
+	// 功能开关的部署默认值和租户覆盖
+	Features FeatureFlagsConfig `json:"features"`
+
+	// 多节点部署时通过 Redis 中继前端与反向注册 agent 之间的消息
+	Redis RedisConfig `json:"redis"`
+
+	// agent 地址解析方式，默认按静态表解析
+	AgentResolver AgentResolverConfig `json:"agentResolver"`
+
+	// 按 action 把消息路由到其它 agent 或本地处理
+	ActionRoutes ActionRoutesConfig `json:"actionRoutes"`
+
+	// 按角色限定可访问的主机分组和能力
+	Authz AuthzConfig `json:"authz"`
+
+	// 终端 WebSocket 关闭时对远程 shell 的处理：INT、HUP、TERM、KILL 等信号名，或 killgroup 结束终端内全部进程
+	TermCloseBehavior string `json:"termCloseBehavior"`
+	// 终端 WebSocket 断开后保留 shell 等待重连的时长，0 表示断开即按 TermCloseBehavior 结束
+	TermReattachGrace Duration `json:"termReattachGrace"`
+
+	// 单条消息的大小上限（字节），0 表示不限制
+	ClientMaxMessageSize int64 `json:"clientMaxMessageSize"`
+	AgentMaxMessageSize  int64 `json:"agentMaxMessageSize"`
+
+	// agent 断线重连策略，TokenReconnectPolicies 按 token 覆盖
+	ReconnectPolicy        ReconnectPolicy            `json:"reconnectPolicy"`
+	TokenReconnectPolicies map[string]ReconnectPolicy `json:"tokenReconnectPolicies,omitempty"`
+
+	// 会话两个方向的限速，TokenRateLimits 按 token 覆盖，零值表示不限速
+	RateLimit       RateLimitConfig            `json:"rateLimit"`
+	TokenRateLimits map[string]RateLimitConfig `json:"tokenRateLimits,omitempty"`
+
+	// 重要 notify 的确认投递，前端在 hello 中协商 notify_ack 后生效
+	NotifyAck NotifyAckConfig `json:"notifyAck"`
+
+	// 重复 RequestID 过滤，Size 为 0 时不启用
+	Dedup DedupConfig `json:"dedup"`
+
+	// 超大消息分片，Size 为 0 时不接受 fragment 协商
+	Fragment FragmentConfig `json:"fragment"`
+
+	// 管理员按会话开启的帧级追踪
+	FrameTrace FrameTraceConfig `json:"frameTrace"`
+
+	// 按身份主体记录连接指纹并标记异常
+	Fingerprint FingerprintConfig `json:"fingerprint"`
+
+	// 把中继的消息镜像到 NATS 或 Kafka，Driver 为空时不启用
+	Egress egress.Config `json:"egress"`
+
+	// 端到端加密模式，前端在 hello 中协商 e2e 后 hub 只转发密文
+	E2E E2EConfig `json:"e2e"`
+
+	// 按 token 或身份主体限制可以连接的时间段
+	AccessWindows AccessWindowConfig `json:"accessWindows"`
+
+	// 打开受保护主机的终端前需要审批
+	Approval ApprovalConfig `json:"approval"`
+
+	// 前端真实 IP（可信代理的转发头）、GeoIP 归属和按国家、ASN 的拦截
+	ClientIP ClientIPConfig `json:"clientIP"`
+
+	// 前端 token 的 JWT 校验，未配置密钥时 token 不做校验
+	JWT jwtauth.Config `json:"jwt"`
+
+	// 允许未携带租户声明的 token 用 tenant 查询参数指定租户，只适用于前端不可直接访问的内网部署
+	TenantFromQuery bool `json:"tenantFromQuery"`
+
+	// 本地用户、API key 和 OIDC 登录，Store 为空时不启用
+	Users UsersConfig `json:"users"`
+
+	// LDAP / AD 认证，Server.URL 为空时不启用
+	LDAP LDAPConfig `json:"ldap"`
+
+	// 敏感操作的二次验证，Rules 为空时不启用
+	StepUp StepUpConfig `json:"stepUp"`
+
+	// 逻辑通道的默认流控窗口，以及每个通道暂存消息的上限（字节），超过上限时通知 agent 暂停该通道
+	ChannelWindow     int64 `json:"channelWindow"`
+	ChannelBufferSize int64 `json:"channelBufferSize"`
+
+	// agent 重连次数用尽后未发出的前端消息持久化到磁盘，Dir 为空时丢弃
+	Outbox OutboxConfig `json:"outbox"`
+
+	// 启动时清理上次运行遗留的分片目录和临时文件
+	Cleanup CleanupConfig `json:"cleanup"`
+
+	// 中继请求的审计日志，File 为空时不记录
+	Audit AuditConfig `json:"audit"`
+
+	// 文件任务队列（合并、清单生成、复制、解压），Dir 为空时不启用，接口同步执行
+	Jobs jobs.Config `json:"jobs"`
+	// /api/jobs 提交的任务在 hub 上读写的路径（解压的文件和目录、复制的源文件、合并和清单的目标）
+	// 只能在这些目录之下，为空时使用 UploadDiskDir 和 UploadMemoryDir
+	JobRoots []string `json:"jobRoots,omitempty"`
+
+	// 同时存在的会话数上限，超出时拒绝创建新会话
+	SessionLimit SessionLimitConfig `json:"sessionLimit"`
+
+	// 每个前端连接最多加入的会话分组数，0 表示不限制
+	GroupMaxPerClient int `json:"groupMaxPerClient"`
+
+	// WebSocket permessage-deflate 压缩，前端和 agent 连接共用
+	Compression CompressionConfig `json:"compression"`
+
+	// 前端可选的线路编码（json、msgpack、protobuf），不在列表中的编码在升级前拒绝
+	ClientEncodings []string `json:"clientEncodings"`
+
+	// 链路追踪，Endpoint 为空时不启用
+	Tracing tracing.Config `json:"tracing"`
+
+	// SMTP 邮件通知，Addr 为空时不发送邮件
+	SMTP mailer.Config `json:"smtp"`
+	// 上传完成邮件：文件不小于该大小（字节）时发送，0 表示不发送
+	UploadMailMinSize int64 `json:"uploadMailMinSize"`
+	// agent 重连次数用尽时发送邮件
+	AgentFailureMail bool `json:"agentFailureMail"`
+
+	// 告警规则和发送通道
+	Alerting AlertingConfig `json:"alerting"`
+
+	// Slack、钉钉、飞书群机器人，发送会话开始、审批请求，并作为告警通道
+	ChatOps ChatOpsConfig `json:"chatOps"`
+
+	// 指标历史，Dir 为空时不记录
+	MetricsHistory MetricsHistoryConfig `json:"metricsHistory"`
+
+	// 定期会话报告，Dir 为空时不记录
+	Reports ReportsConfig `json:"reports"`
+
+	// 终端录像和文本记录的存储，Dir 为空时不启用
+	Recordings RecordingsConfig `json:"recordings"`
+
+	// 审计记录、命令和录像的全文检索，默认关闭
+	Search SearchConfig `json:"search"`
+
+	// 故障注入，用于验证重连和暂存，默认关闭（也可以通过 HUB_CHAOS 环境变量启用）
+	Chaos ChaosConfig `json:"chaos"`
+
+	// 录像、审计文件和配置包的对象存储（S3/MinIO），s3.bucket 为空时不启用
+	ObjectStore ObjectStoreConfig `json:"objectStore"`
+
+	// 消息优先级，默认关闭
+	Priority PriorityConfig `json:"priority"`
+
+	// 透传模式，默认关闭
+	RawRelay RawRelayConfig `json:"rawRelay"`
+}
+
+// RoutesConfig 前端入口开关：Client 为中继入口 /ws，Terminal 为直连 SSH 终端 /term，Download 为 SFTP 下载 /file/download
+type RoutesConfig struct {
+	Client   bool `json:"client"`
+	Terminal bool `json:"terminal"`
+	Download bool `json:"download"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr:                    ":8089",
+		KeepalivePingInterval:         Duration(20 * time.Second),
+		KeepalivePongWait:             Duration(ReadDeadline),
+		WriteTimeout:                  Duration(10 * time.Second),
+		ClientOverflowPolicy:          OverflowDisconnect,
+		AgentOverflowPolicy:           OverflowDisconnect,
+		PendingQueueSize:              100,
+		SlowConsumerResidency:         Duration(2 * time.Second),
+		SlowConsumerWriteLatency:      Duration(500 * time.Millisecond),
+		SlowConsumerStrikes:           50,
+		SlowConsumerDisconnectStrikes: 500,
+		SlowConsumerStall:             Duration(5 * time.Second),
+		SlowConsumerMaxQueue:          800,
+		SlowConsumerDegrade:           true,
+		SessionIdleTimeout:            Duration(30 * time.Minute),
+		SessionSweepInterval:          Duration(time.Minute),
+		ReplayBufferSize:              1000,
+		ClientResumeGrace:             Duration(30 * time.Second),
+		ClientSeqWindow:               64,
+		SSHHostKeys:                   sshutil.HostKeyConfig{KnownHostsFile: sshutil.DefaultKnownHostsFile, TrustOnFirstUse: true},
+		ClientSeqTimeout:              Duration(2 * time.Second),
+		DrainTimeout:                  Duration(30 * time.Second),
+		UploadDiskDir:                 "/tmp",
+		UploadSmallFileLimit:          8 << 20,
+		UploadMemoryBudget:            256 << 20,
+		UploadBlockGCGrace:            Duration(24 * time.Hour),
+		UploadMergeMaxConcurrent:      2,
+		SessionLimit:                  SessionLimitConfig{RetryAfter: Duration(30 * time.Second)},
+		Dedup:                         DedupConfig{TTL: Duration(5 * time.Minute), Mode: DedupDrop},
+		Fragment:                      FragmentConfig{Size: 512 << 10, MaxSize: 64 << 20, Timeout: Duration(time.Minute)},
+		FrameTrace:                    FrameTraceConfig{MaxDuration: Duration(10 * time.Minute), BufferSize: 1000, MaxFrameBytes: 4 << 10},
+		AccessWindows:                 AccessWindowConfig{Warning: Duration(5 * time.Minute)},
+		Approval: ApprovalConfig{
+			Tag:       "protected",
+			Timeout:   Duration(10 * time.Minute),
+			Retention: Duration(24 * time.Hour),
+		},
+		Fingerprint: FingerprintConfig{MinSamples: 200, MaxIdentities: 10000, MaxAnomalies: 20, MaxValues: 8, MaxActions: 256},
+		NotifyAck: NotifyAckConfig{
+			Actions:    []string{"reconnect_success", "exit"},
+			Timeout:    Duration(5 * time.Second),
+			MaxRetries: 3,
+		},
+		DownloadCacheMaxBytes: 1 << 30,
+		TermCloseBehavior:     "INT",
+		ReconnectPolicy:       DefaultReconnectPolicy(),
+		ClientMaxMessageSize:  1 << 20,
+		AgentMaxMessageSize:   16 << 20,
+		ChannelWindow:         256 << 10,
+		ChannelBufferSize:     1 << 20,
+		GroupMaxPerClient:     32,
+		Outbox: OutboxConfig{
+			MaxMessages: 1000,
+			MaxAge:      Duration(24 * time.Hour),
+		},
+		Cleanup: CleanupConfig{
+			Mode:        CleanupRemove,
+			ChunkMaxAge: Duration(24 * time.Hour),
+		},
+		Audit: AuditConfig{
+			MaxSize:    100 << 20,
+			MaxBackups: 10,
+		},
+		Jobs: jobs.Config{
+			Workers:           2,
+			MaxAttempts:       3,
+			RetryDelaySeconds: 30,
+			RetainSeconds:     7 * 24 * 3600,
+		},
+		Compression: CompressionConfig{
+			Level:     flate.BestSpeed,
+			Threshold: 1024,
+		},
+		ClientEncodings: []string{EncodingJSON, EncodingMsgpack, EncodingProtobuf},
+		Tracing: tracing.Config{
+			ServiceName:  "go_ws_hub",
+			SampleRatio:  1,
+			BatchSize:    256,
+			FlushSeconds: 5,
+			QueueSize:    4096,
+		},
+		Egress: egress.Config{
+			QueueSize:      10000,
+			BatchSize:      100,
+			MaxRetries:     3,
+			TimeoutSeconds: 10,
+		},
+		SMTP: mailer.Config{
+			ThrottleSeconds: 300,
+			MaxPerHour:      60,
+		},
+		Alerting: AlertingConfig{
+			Interval:       Duration(30 * time.Second),
+			RepeatInterval: Duration(time.Hour),
+		},
+		MetricsHistory: MetricsHistoryConfig{
+			Interval:  Duration(time.Minute),
+			Retention: Duration(7 * 24 * time.Hour),
+		},
+		Users: UsersConfig{
+			LoginTokenTTL: Duration(12 * time.Hour),
+		},
+		LDAP: LDAPConfig{
+			Server: ldapauth.Config{
+				PoolSize:       4,
+				TimeoutSeconds: 10,
+			},
+			CacheTTL: Duration(time.Minute),
+		},
+		ClientIP: ClientIPConfig{
+			Header: "X-Forwarded-For",
+		},
+		StepUp: StepUpConfig{
+			MaxAge:           Duration(5 * time.Minute),
+			ChallengeTimeout: Duration(2 * time.Minute),
+			MaxAttempts:      3,
+			Lockout:          Duration(15 * time.Minute),
+			Issuer:           "go_ws_hub",
+		},
+		Reports: ReportsConfig{
+			Daily:     true,
+			Weekly:    true,
+			Hour:      1,
+			Top:       10,
+			Retention: Duration(90 * 24 * time.Hour),
+		},
+		Recordings: RecordingsConfig{MaxImportSize: 256 << 20},
+		Search:     SearchConfig{MaxDocuments: 200000, MaxTextBytes: 256 << 10},
+		Priority:   PriorityConfig{LowShare: 8},
+		Routes:     RoutesConfig{Client: true, Terminal: true, Download: true},
+		// 不设默认地址，未配置 endpoint 的 token 只能使用清单中登记或反向注册的 agent
+		AgentResolver: AgentResolverConfig{Type: "static"},
+	}
+}
+
+// Duration 支持在 JSON 中写成 "5s"、"200ms" 形式的时长，也兼容纳秒整数
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return errors.New("invalid duration")
+	}
+	return nil
+}
+
+// D 转为 time.Duration
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+// LoadConfig 在默认配置的基础上读取 JSON 配置文件
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var hubConfig = DefaultConfig()
+
+// validate 检查不依赖外部资源的配置项，启动和导入配置包时调用
+func (cfg *Config) validate() error {
+	for _, policy := range []OverflowPolicy{cfg.ClientOverflowPolicy, cfg.AgentOverflowPolicy} {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+	}
+	for token, policy := range cfg.TokenReconnectPolicies {
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("token %s: %w", token, err)
+		}
+	}
+	for token, limit := range cfg.TokenRateLimits {
+		if err := limit.validate(); err != nil {
+			return fmt.Errorf("token %s rate limit: %w", token, err)
+		}
+	}
+	for _, exp := range cfg.Experiments {
+		if err := exp.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.SSHHostKeys.TrustOnFirstUse && cfg.SSHHostKeys.KnownHostsFile == "" {
+		return errors.New("sshHostKeys.trustOnFirstUse requires knownHostsFile")
+	}
+	validators := []interface{ validate() error }{
+		cfg.ReconnectPolicy,
+		cfg.Features,
+		cfg.RateLimit,
+		cfg.Dedup,
+		cfg.Fragment,
+		cfg.FrameTrace,
+		cfg.Fingerprint,
+		cfg.E2E,
+		cfg.AccessWindows,
+		cfg.Approval,
+		cfg.NotifyAck,
+		cfg.ActionRoutes,
+		cfg.Compression,
+		cfg.ChatOps,
+		cfg.Alerting,
+		cfg.MetricsHistory,
+		cfg.Reports,
+		cfg.Recordings,
+		cfg.Search,
+		cfg.Chaos,
+		cfg.ObjectStore,
+		cfg.Priority,
+		cfg.RawRelay,
+		cfg.Affinity,
+		cfg.Outbox,
+		cfg.Audit,
+		cfg.Cleanup,
+		cfg.SessionLimit,
+		cfg.LDAP,
+		cfg.ClientIP,
+		cfg.TLS,
+		cfg.AgentTLS,
+	}
+	for _, v := range validators {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.Users.validate(cfg.JWT); err != nil {
+		return err
+	}
+	if err := cfg.StepUp.validate(cfg.Users); err != nil {
+		return err
+	}
+	if err := cfg.RawRelay.validateStepUp(cfg.StepUp); err != nil {
+		return err
+	}
+	if err := validateBasePath(cfg.BasePath); err != nil {
+		return err
+	}
+	if err := validateUnixSocketMode(cfg.UnixSocketMode); err != nil {
+		return err
+	}
+	if err := validateEncodings(cfg.ClientEncodings); err != nil {
+		return err
+	}
+	return term.ValidateSignal(cfg.TermCloseBehavior)
+}
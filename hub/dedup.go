@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// 重复请求过滤：每个会话记住最近 Size 个 RequestID（LRU），TTL 内再次收到相同 RequestID 的前端消息时
+// 按 Mode 丢弃或回复错误，不转发给 agent，避免网络抖动后前端的重试风暴打到 agent。
+// 没有 RequestID 的消息以及 hello、resume 不检查
+// -----------------------
+
+const (
+	DedupDrop   = "drop"   // 静默丢弃
+	DedupReject = "reject" // 丢弃并回复 duplicate_request 错误
+)
+
+const ErrCodeDuplicate = "duplicate_request"
+
+// DedupConfig Size 为 0 时不启用
+type DedupConfig struct {
+	Size int      `json:"size"`
+	TTL  Duration `json:"ttl"`
+	Mode string   `json:"mode"`
+}
+
+func (cfg DedupConfig) validate() error {
+	if cfg.Size < 0 || cfg.TTL.D() < 0 {
+		return errors.New("dedup.size and dedup.ttl must not be negative")
+	}
+	switch cfg.Mode {
+	case "", DedupDrop, DedupReject:
+	default:
+		return fmt.Errorf("unknown dedup.mode %q", cfg.Mode)
+	}
+	return nil
+}
+
+type dedupEntry struct {
+	id   string
+	seen time.Time
+}
+
+// requestDedup 按首次出现时间排序的 RequestID，最旧的在链表尾部
+type requestDedup struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+// newRequestDedup 未启用时返回 nil
+func newRequestDedup(cfg DedupConfig) *requestDedup {
+	if cfg.Size <= 0 {
+		return nil
+	}
+	return &requestDedup{size: cfg.Size, ttl: cfg.TTL.D(), order: list.New(), ids: make(map[string]*list.Element)}
+}
+
+// seen 记录 id，TTL 内已出现过时返回 true；TTL 为 0 时只按容量淘汰
+func (d *requestDedup) seen(id string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// 先淘汰过期的记录
+	for d.ttl > 0 {
+		back := d.order.Back()
+		if back == nil || now.Sub(back.Value.(*dedupEntry).seen) < d.ttl {
+			break
+		}
+		d.remove(back)
+	}
+	if _, ok := d.ids[id]; ok {
+		return true
+	}
+	d.ids[id] = d.order.PushFront(&dedupEntry{id: id, seen: now})
+	for d.order.Len() > d.size {
+		d.remove(d.order.Back())
+	}
+	return false
+}
+
+func (d *requestDedup) remove(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.ids, e.Value.(*dedupEntry).id)
+}
+
+// dropDuplicate 消息的 RequestID 近期已出现过时丢弃，返回 true 表示调用方不应再处理该消息
+func (s *RelaySession) dropDuplicate(client *wsClientConn, msg WebSocketMessage, size int) bool {
+	if s.dedup == nil || msg.RequestID == "" || msg.Action == ActionHello || msg.Action == ActionResume {
+		return false
+	}
+	// 同一条消息的分片共享 RequestID，只按第一片判断
+	if msg.Frag != nil && msg.Frag.Index > 0 {
+		return false
+	}
+	if !s.dedup.seen(msg.RequestID, time.Now()) {
+		return false
+	}
+	mode := hubConfig.Dedup.Mode
+	if mode == "" {
+		mode = DedupDrop
+	}
+	hubMetrics.Inc("hub_duplicate_requests_total", "mode", mode)
+	s.auditRequest(client, msg, size, "", AuditDuplicate, nil)
+	if mode == DedupReject {
+		s.notifyClient(client, WebSocketMessage{
+			Type:      MessageTypeNotify,
+			RequestID: msg.RequestID,
+			Action:    ActionError,
+			Data:      "Duplicate request, message dropped",
+			Error:     &MessageError{Code: ErrCodeDuplicate, Reason: "request ID was seen recently"},
+		})
+	}
+	return true
+}
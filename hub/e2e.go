@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"echo_demo/e2e"
+)
+
+// -----------------------
+// 端到端加密模式：前端在 hello 中协商 e2e 后，通过 e2e_key 与 agent 交换公钥（hub 原样转发），
+// 之后转发给 agent 的消息 d 为密文、x 为密钥 ID，hub 只按 t/r/a/ch 路由，不解析 d。
+// 交换本身经过 hub，agent 的响应由其身份密钥签名，前端用预先固定的 agent 公钥验证（见 e2e 包）；
+// hub 不参与验证也不分发 agent 公钥，前端跳过验证时 hub 可以替换公钥读取明文。
+// 协商过的前端发送不带 x 的 d 时 hub 拒绝转发，避免前端出错时把明文发出去；RequireTokens 中的 token
+// 不协商也按同样的规则处理。本地处理的 action 由 hub 执行，必须是明文
+// -----------------------
+
+const (
+	FeatureE2E   = "e2e"
+	ActionE2EKey = "e2e_key" // 前端 -> hub -> agent，d 为 e2e.KeyExchange，agent 的响应带上自己的公钥、密钥 ID 和签名
+
+	// ErrCodeE2ERequired 需要加密的前端发送了明文 d
+	ErrCodeE2ERequired = "e2e_required"
+	// ErrCodeE2ELocal 本地处理的 action 带有密文
+	ErrCodeE2ELocal = "e2e_local_action"
+)
+
+// E2EConfig Enabled 为 false 时不接受 e2e 协商
+type E2EConfig struct {
+	Enabled       bool     `json:"enabled"`
+	RequireTokens []string `json:"requireTokens,omitempty"`
+}
+
+func (cfg E2EConfig) validate() error {
+	if len(cfg.RequireTokens) > 0 && !cfg.Enabled {
+		return errors.New("e2e.requireTokens needs e2e.enabled")
+	}
+	return nil
+}
+
+var (
+	errE2ERequired = errors.New("plaintext data is not allowed in end-to-end encrypted mode")
+	errE2ELocal    = errors.New("action is handled by the hub and must not be encrypted")
+)
+
+// e2eOn 返回该连接是否已协商端到端加密
+func (c *wsClientConn) e2eOn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.e2eEnabled
+}
+
+// e2eRequired 该前端发往 agent 的 d 是否必须为密文
+func (s *RelaySession) e2eRequired(client *wsClientConn) bool {
+	return client.e2eOn() || slices.Contains(hubConfig.E2E.RequireTokens, s.token)
+}
+
+// isLocalAction 判断 action 是否由 hub 处理
+func (s *RelaySession) isLocalAction(action string) bool {
+	if route, ok := s.route(action); ok {
+		return route.Local
+	}
+	_, ok := localHandler(action)
+	return ok
+}
+
+// rejectE2E 检查加密模式的规则，不符合时回复错误，返回 true 表示消息不再处理
+func (s *RelaySession) rejectE2E(client *wsClientConn, msg WebSocketMessage, size int) bool {
+	if msg.Action == ActionHello || msg.Action == ActionResume {
+		return false
+	}
+	local := s.isLocalAction(msg.Action)
+	var code string
+	var err error
+	switch {
+	case msg.Enc != "" && local:
+		code, err = ErrCodeE2ELocal, errE2ELocal
+	case msg.Enc == "" && msg.Data != nil && !local && msg.Action != ActionE2EKey && s.e2eRequired(client):
+		code, err = ErrCodeE2ERequired, errE2ERequired
+	default:
+		if msg.Action == ActionE2EKey {
+			s.recordE2EKey(client, msg)
+		}
+		return false
+	}
+	hubMetrics.Inc("hub_e2e_rejections_total", "code", code)
+	s.auditRequest(client, msg, size, "", AuditRejected, err)
+	s.notifyError(client, msg.RequestID, code, err.Error())
+	return true
+}
+
+// recordE2EKey 记录前端发起的密钥交换，只保存公钥用于在 admin API 中展示，hub 无法据此解密
+func (s *RelaySession) recordE2EKey(client *wsClientConn, msg WebSocketMessage) {
+	var offer e2e.KeyExchange
+	if raw, err := json.Marshal(msg.Data); err == nil {
+		_ = json.Unmarshal(raw, &offer)
+	}
+	if offer.Alg != e2e.Algorithm || offer.Pub == "" {
+		return
+	}
+	hubMetrics.Inc("hub_e2e_key_exchanges_total")
+	client.mu.Lock()
+	client.e2ePub = offer.Pub
+	client.mu.Unlock()
+}
+
+// e2eKeyed 该前端是否发起过密钥交换
+func (c *wsClientConn) e2eKeyed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.e2ePub != ""
+}
@@ -0,0 +1,34 @@
+package hub
+
+import (
+	"encoding/json"
+
+	"echo_demo/egress"
+)
+
+// -----------------------
+// 中继流量外送：启用 Egress 后注册一个只读拦截器，把通过前面拦截器的消息镜像给 egress.Bridge，
+// 不修改也不阻塞中继；前端消息方向为 up，agent 消息方向为 down
+// -----------------------
+
+// hubEgress 为 nil 表示未启用
+var hubEgress *egress.Bridge
+
+type egressInterceptor struct {
+	BaseInterceptor
+	bridge *egress.Bridge
+}
+
+func (e egressInterceptor) OnClientMessage(s HookSession, msg WebSocketMessage, data []byte) ([]byte, error) {
+	e.bridge.Mirror(s.Token, egress.DirectionUp, msg.Action, data)
+	return data, nil
+}
+
+func (e egressInterceptor) OnAgentMessage(s HookSession, data []byte) ([]byte, error) {
+	var head struct {
+		Action string `json:"a"`
+	}
+	_ = json.Unmarshal(data, &head)
+	e.bridge.Mirror(s.Token, egress.DirectionDown, head.Action, data)
+	return data, nil
+}
@@ -0,0 +1,354 @@
+package hub
+
+import (
+	"bytes"
+	"echo_demo/msgpack"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 线路编码：前端在升级时通过 enc 查询参数或子协议（wshub.msgpack、wshub.protobuf）选择编码，默认 JSON。
+// hub 内部和 agent 之间始终使用 JSON：前端的二进制帧在读循环中转为 JSON，发给前端的消息在写循环中转为所选编码。
+// 选择二进制编码后不支持帧校验（hello 中的 checksum 协商），二进制帧本身由 WebSocket 保证完整
+// -----------------------
+
+const (
+	EncodingJSON     = "json"
+	EncodingMsgpack  = "msgpack"
+	EncodingProtobuf = "protobuf"
+
+	encodingSubprotocolPrefix = "wshub."
+)
+
+// frameCodec 前端编码与 hub 内部 JSON 帧之间的转换
+type frameCodec interface {
+	// encode 把 JSON 帧转为线路格式
+	encode(data []byte) ([]byte, error)
+	// decode 把线路格式转为 JSON 帧
+	decode(frame []byte) ([]byte, error)
+}
+
+var frameCodecs = map[string]frameCodec{
+	EncodingMsgpack:  msgpackCodec{},
+	EncodingProtobuf: protobufCodec{},
+}
+
+// splitSubprotocols 拆分 Sec-WebSocket-Protocol，返回 token 和请求的编码（未请求时为空）
+func splitSubprotocols(header string) (token, encoding string) {
+	for _, p := range strings.Split(header, ",") {
+		p = strings.TrimSpace(p)
+		if name, ok := strings.CutPrefix(p, encodingSubprotocolPrefix); ok {
+			encoding = name
+		} else if token == "" {
+			token = p
+		}
+	}
+	return token, encoding
+}
+
+// requestEncoding 取前端请求的编码，enc 查询参数优先；不支持或未启用的编码返回错误
+func requestEncoding(r *http.Request) (string, error) {
+	enc := r.URL.Query().Get("enc")
+	if enc == "" {
+		_, enc = splitSubprotocols(r.Header.Get("Sec-WebSocket-Protocol"))
+	}
+	if enc == "" {
+		enc = EncodingJSON
+	}
+	if !containsString(hubConfig.ClientEncodings, enc) {
+		return "", fmt.Errorf("unsupported encoding %q", enc)
+	}
+	return enc, nil
+}
+
+// validateEncodings 启动时检查 ClientEncodings
+func validateEncodings(encodings []string) error {
+	if len(encodings) == 0 {
+		return errors.New("clientEncodings must not be empty")
+	}
+	for _, enc := range encodings {
+		if _, ok := frameCodecs[enc]; !ok && enc != EncodingJSON {
+			return fmt.Errorf("unknown client encoding %q", enc)
+		}
+	}
+	return nil
+}
+
+// codecFor JSON 编码返回 nil，表示不做转换
+func codecFor(encoding string) frameCodec {
+	return frameCodecs[encoding]
+}
+
+// writeFrame 按前端的编码写出一帧；pong 等非 JSON 消息保持文本帧
+func (c *wsClientConn) writeFrame(data []byte) error {
+	codec, compress := c.wireCodec()
+	if codec == nil || len(data) == 0 || data[0] != '{' {
+		return writeMessageWith(c.conn, websocket.TextMessage, data, compress)
+	}
+	frame, err := codec.encode(data)
+	if err != nil {
+		// 转换失败说明消息本身有问题，按原样发出便于排查
+		log.Println("Client encode error:", err)
+		return writeMessageWith(c.conn, websocket.TextMessage, data, compress)
+	}
+	return writeMessageWith(c.conn, websocket.BinaryMessage, frame, compress)
+}
+
+// msgpackCodec 消息按 JSON 的字段名编码为 MessagePack map
+type msgpackCodec struct{}
+
+func (msgpackCodec) encode(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) decode(frame []byte) ([]byte, error) {
+	v, err := msgpack.Unmarshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, errors.New("msgpack message must be a map")
+	}
+	return json.Marshal(v)
+}
+
+// protobufCodec 按下面的定义手工编解码，d 仍为 JSON，客户端按 action 自行解析：
+//
+//	message Envelope {
+//	  string t = 1;
+//	  string r = 2;
+//	  string a = 3;
+//	  bytes  d = 4;  // JSON
+//	  string c = 5;
+//	  int64  s = 6;
+//	  Error  e = 7;
+//	  string ch = 8;
+//	  string tp = 9;
+//	  int64  n = 10;
+//	  Fragment fg = 11;
+//	  string x = 12;
+//	  string p = 13;
+//	}
+//	message Error {
+//	  string code = 1;
+//	  string reason = 2;
+//	}
+//	message Fragment {
+//	  string id = 1;
+//	  int64  i = 2;
+//	  bool   f = 3;
+//	}
+//
+// WebSocketMessage 增加字段时需要同时在这里分配编号
+type protobufCodec struct{}
+
+// wireMessage 与 WebSocketMessage 相同，但 Data 保持原始 JSON
+type wireMessage struct {
+	Type      string          `json:"t"`
+	RequestID string          `json:"r,omitempty"`
+	Action    string          `json:"a"`
+	Data      json.RawMessage `json:"d,omitempty"`
+	Checksum  string          `json:"c,omitempty"`
+	Seq       int64           `json:"s,omitempty"`
+	Error     *MessageError   `json:"e,omitempty"`
+	Channel   string          `json:"ch,omitempty"`
+	Trace     string          `json:"tp,omitempty"`
+	NotifyID  int64           `json:"n,omitempty"`
+	Frag      *Fragment       `json:"fg,omitempty"`
+	Enc       string          `json:"x,omitempty"`
+	Priority  string          `json:"p,omitempty"`
+}
+
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+func pbAppendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func pbAppendBytes(b []byte, field int, p []byte) []byte {
+	if len(p) == 0 {
+		return b
+	}
+	b = pbAppendTag(b, field, pbBytes)
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+func pbAppendVarint(b []byte, field int, n uint64) []byte {
+	if n == 0 {
+		return b
+	}
+	b = pbAppendTag(b, field, pbVarint)
+	return binary.AppendUvarint(b, n)
+}
+
+func pbAppendString(b []byte, field int, s string) []byte {
+	return pbAppendBytes(b, field, []byte(s))
+}
+
+func (protobufCodec) encode(data []byte) ([]byte, error) {
+	var m wireMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, len(data))
+	b = pbAppendString(b, 1, m.Type)
+	b = pbAppendString(b, 2, m.RequestID)
+	b = pbAppendString(b, 3, m.Action)
+	b = pbAppendBytes(b, 4, m.Data)
+	b = pbAppendString(b, 5, m.Checksum)
+	b = pbAppendVarint(b, 6, uint64(m.Seq))
+	if m.Error != nil {
+		var e []byte
+		e = pbAppendString(e, 1, m.Error.Code)
+		e = pbAppendString(e, 2, m.Error.Reason)
+		b = pbAppendTag(b, 7, pbBytes)
+		b = binary.AppendUvarint(b, uint64(len(e)))
+		b = append(b, e...)
+	}
+	b = pbAppendString(b, 8, m.Channel)
+	b = pbAppendString(b, 9, m.Trace)
+	b = pbAppendVarint(b, 10, uint64(m.NotifyID))
+	if m.Frag != nil {
+		var f []byte
+		f = pbAppendString(f, 1, m.Frag.ID)
+		f = pbAppendVarint(f, 2, uint64(m.Frag.Index))
+		if m.Frag.Final {
+			f = pbAppendVarint(f, 3, 1)
+		}
+		// 第一片的字段可能全为默认值，仍然写出空的 fg 表示分片
+		b = pbAppendTag(b, 11, pbBytes)
+		b = binary.AppendUvarint(b, uint64(len(f)))
+		b = append(b, f...)
+	}
+	b = pbAppendString(b, 12, m.Enc)
+	b = pbAppendString(b, 13, m.Priority)
+	return b, nil
+}
+
+var errProtobufMalformed = errors.New("malformed protobuf message")
+
+// pbFields 依次回调每个字段，varint 字段的值在 n 中，长度字段的值在 p 中；未知字段跳过
+func pbFields(b []byte, fn func(field int, n uint64, p []byte) error) error {
+	for len(b) > 0 {
+		tag, k := binary.Uvarint(b)
+		if k <= 0 {
+			return errProtobufMalformed
+		}
+		b = b[k:]
+		field, wireType := int(tag>>3), int(tag&7)
+		switch wireType {
+		case pbVarint:
+			n, k := binary.Uvarint(b)
+			if k <= 0 {
+				return errProtobufMalformed
+			}
+			b = b[k:]
+			if err := fn(field, n, nil); err != nil {
+				return err
+			}
+		case pbBytes:
+			n, k := binary.Uvarint(b)
+			if k <= 0 || n > uint64(len(b)-k) {
+				return errProtobufMalformed
+			}
+			p := b[k : k+int(n)]
+			b = b[k+int(n):]
+			if err := fn(field, 0, p); err != nil {
+				return err
+			}
+		case 1: // 64 位定长
+			if len(b) < 8 {
+				return errProtobufMalformed
+			}
+			b = b[8:]
+		case 5: // 32 位定长
+			if len(b) < 4 {
+				return errProtobufMalformed
+			}
+			b = b[4:]
+		default:
+			return errProtobufMalformed
+		}
+	}
+	return nil
+}
+
+func (protobufCodec) decode(frame []byte) ([]byte, error) {
+	var m wireMessage
+	err := pbFields(frame, func(field int, n uint64, p []byte) error {
+		switch field {
+		case 1:
+			m.Type = string(p)
+		case 2:
+			m.RequestID = string(p)
+		case 3:
+			m.Action = string(p)
+		case 4:
+			if !json.Valid(p) {
+				return errors.New("protobuf field d is not valid JSON")
+			}
+			m.Data = append(json.RawMessage(nil), p...)
+		case 5:
+			m.Checksum = string(p)
+		case 6:
+			m.Seq = int64(n)
+		case 7:
+			m.Error = &MessageError{}
+			return pbFields(p, func(field int, _ uint64, p []byte) error {
+				switch field {
+				case 1:
+					m.Error.Code = string(p)
+				case 2:
+					m.Error.Reason = string(p)
+				}
+				return nil
+			})
+		case 8:
+			m.Channel = string(p)
+		case 9:
+			m.Trace = string(p)
+		case 10:
+			m.NotifyID = int64(n)
+		case 11:
+			m.Frag = &Fragment{}
+			return pbFields(p, func(field int, n uint64, p []byte) error {
+				switch field {
+				case 1:
+					m.Frag.ID = string(p)
+				case 2:
+					m.Frag.Index = int(n)
+				case 3:
+					m.Frag.Final = n != 0
+				}
+				return nil
+			})
+		case 12:
+			m.Enc = string(p)
+		case 13:
+			m.Priority = string(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// -----------------------
+// 结构化错误：出错的消息在 notify 的 e 字段中带回错误码和原因，前端按 Code 处理，Reason 仅用于展示和排查
+// -----------------------
+
+// MessageError 附加在 WebSocketMessage 上的错误信息
+type MessageError struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// 标准错误码
+const (
+	ErrCodeBadMessage       = "bad_message"       // 消息不是合法的 JSON 或字段类型不符
+	ErrCodeChecksum         = "checksum"          // 帧校验失败
+	ErrCodeQueueFull        = "queue_full"        // agent 重连期间暂存队列已满
+	ErrCodeAgentUnavailable = "agent_unavailable" // 路由指定的 agent 无法连接
+	ErrCodeRejected         = "rejected"          // 被拦截器拒绝
+	ErrCodeLocalHandler     = "local_handler"     // 本地处理器返回错误或不存在
+)
+
+// ActionError 通用的错误通知，具体原因见 e 字段
+const ActionError = "error"
+
+// requestIDOf 尽量从无法按 WebSocketMessage 解析的消息中取出 RequestID
+func requestIDOf(data []byte) string {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return ""
+	}
+	var id string
+	_ = json.Unmarshal(probe["r"], &id)
+	return id
+}
+
+// notifyError 向前端发送结构化错误通知
+func (s *RelaySession) notifyError(client *wsClientConn, requestID, code, reason string) {
+	hubMetrics.Inc("hub_message_errors_total", "code", code)
+	s.notifyClient(client, WebSocketMessage{
+		Type:      MessageTypeNotify,
+		RequestID: requestID,
+		Action:    ActionError,
+		Data:      reason,
+		Error:     &MessageError{Code: code, Reason: reason},
+	})
+}
+
+// rejectBadMessage 客户端消息无法解析时回复错误，而不是静默丢弃
+func (s *RelaySession) rejectBadMessage(client *wsClientConn, data []byte, err error) {
+	log.Println("Client unmarshal error:", err)
+	s.notifyError(client, requestIDOf(data), ErrCodeBadMessage, "invalid message: "+err.Error())
+}
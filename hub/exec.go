@@ -0,0 +1,189 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"echo_demo/sshutil"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/ssh"
+)
+
+// -----------------------
+// 命令执行：POST /api/exec 在 profile 指定的主机上执行一条命令，复用连接池中的 SSH 连接，
+// 以 JSON 返回 stdout、stderr 和退出码，适合不需要完整终端的自动化场景
+// -----------------------
+
+const (
+	defaultExecTimeout = 30 * time.Second
+	maxExecTimeout     = 10 * time.Minute
+	// 每个输出流最多保留的字节数，超出部分丢弃并标记 truncated
+	maxExecOutput = 1 << 20
+)
+
+// ExecRequest 命令执行请求
+type ExecRequest struct {
+	Profile string            `json:"profile"` // sshProfiles 中的名称或资产清单中的主机 ID
+	Command string            `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+	Stdin   string            `json:"stdin,omitempty"`
+	Timeout Duration          `json:"timeout,omitempty"` // 默认 30s，最长 10m
+}
+
+// ExecResult 命令执行结果，ExitCode 为 -1 表示没有拿到退出码（超时或连接中断）
+type ExecResult struct {
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exitCode"`
+	Signal     string `json:"signal,omitempty"`
+	TimedOut   bool   `json:"timedOut,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+var sshPool = sshutil.NewPool(lookupSSHProfile)
+
+// limitedBuffer 只保留前 limit 个字节，写入永远成功，避免输出过多时阻塞远程命令
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// shellQuote 用单引号包裹参数，用于 Setenv 被服务端拒绝时改为在命令前导出变量
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runCommand 在新的 SSH session 中执行命令，ctx 结束时发送 KILL 并关闭 session
+func runCommand(ctx context.Context, client *ssh.Client, req ExecRequest) (*ExecResult, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	command := req.Command
+	names := make([]string, 0, len(req.Env))
+	for name := range req.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var exports []string
+	for _, name := range names {
+		if err := session.Setenv(name, req.Env[name]); err != nil {
+			exports = append(exports, "export "+name+"="+shellQuote(req.Env[name])+";")
+		}
+	}
+	if len(exports) > 0 {
+		command = strings.Join(exports, " ") + " " + command
+	}
+
+	stdout := &limitedBuffer{limit: maxExecOutput}
+	stderr := &limitedBuffer{limit: maxExecOutput}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	session.Stdin = strings.NewReader(req.Stdin)
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	result := &ExecResult{ExitCode: -1}
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		result.TimedOut = true
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+		err = <-done
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.Stdout = stdout.buf.String()
+	result.Stderr = stderr.buf.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+	case result.TimedOut:
+	default:
+		return result, err
+	}
+	return result, nil
+}
+
+// ExecHandler 执行一条命令并返回结果
+func ExecHandler(c echo.Context) error {
+	var req ExecRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if req.Profile == "" || req.Command == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "profile and command are required"})
+	}
+	timeout := req.Timeout.D()
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	if timeout > maxExecTimeout {
+		timeout = maxExecTimeout
+	}
+
+	if err := authorizeHost(requestIdentity(c), sshutil.CapExec, req.Profile); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+	defer cancel()
+	client, err := sshPool.Get(ctx, req.Profile)
+	if err != nil {
+		if errors.Is(err, sshutil.ErrUnknownProfile) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		log.Println("Exec dial error:", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+
+	result, err := runCommand(ctx, client, req)
+	if err != nil {
+		// 连接层面的错误，丢弃该连接，下次请求重新拨号
+		sshPool.Invalidate(req.Profile, client)
+		log.Println("Exec run error:", err)
+		auditCommand(requestIdentity(c), c.RealIP(), req.Command, HostExecResult{Profile: req.Profile, Result: result, Error: err.Error()})
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	auditCommand(requestIdentity(c), c.RealIP(), req.Command, HostExecResult{Profile: req.Profile, Result: result})
+	hubMetrics.Inc("hub_exec_total", "profile", req.Profile, "timed_out", boolLabel(result.TimedOut))
+	return c.JSON(http.StatusOK, result)
+}
+
+func boolLabel(v bool) string {
+	if v {
+		return "true"
+	}
+	return "false"
+}
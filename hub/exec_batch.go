@@ -0,0 +1,180 @@
+package hub
+
+import (
+	"context"
+	"echo_demo/sshutil"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 批量执行：POST /api/exec/batch 在多台主机（或清单中一个分组的全部主机）上并发执行同一条命令（限制并发数），
+// 指定 token 时每台主机完成后向该会话的前端推送 batch_progress，最后返回汇总结果
+// -----------------------
+
+const (
+	defaultBatchParallelism = 10
+	maxBatchParallelism     = 100
+)
+
+// BatchExecRequest 批量执行请求，命令、环境变量、stdin 和超时对每台主机相同
+type BatchExecRequest struct {
+	ExecRequest
+	Profiles    []string `json:"profiles"`
+	Group       string   `json:"group,omitempty"` // 清单中的分组，展开为组内全部主机，与 Profiles 合并
+	Parallelism int      `json:"parallelism,omitempty"`
+	Token       string   `json:"token,omitempty"` // 接收进度通知的会话
+}
+
+// HostExecResult 单台主机的执行结果，Error 为连接或执行层面的错误
+type HostExecResult struct {
+	Profile string      `json:"profile"`
+	Result  *ExecResult `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// BatchExecReport 批量执行汇总，命令退出码非 0、超时或出错都计入 Failed
+type BatchExecReport struct {
+	BatchID    string           `json:"batchId"`
+	Total      int              `json:"total"`
+	Succeeded  int              `json:"succeeded"`
+	Failed     int              `json:"failed"`
+	DurationMs int64            `json:"durationMs"`
+	Hosts      []HostExecResult `json:"hosts"`
+}
+
+func (r HostExecResult) ok() bool {
+	return r.Error == "" && r.Result != nil && !r.Result.TimedOut && r.Result.ExitCode == 0
+}
+
+// execOnProfile 在一台主机上执行命令，连接错误时丢弃池中的连接
+func execOnProfile(ctx context.Context, profile string, req ExecRequest) HostExecResult {
+	client, err := sshPool.Get(ctx, profile)
+	if err != nil {
+		return HostExecResult{Profile: profile, Error: err.Error()}
+	}
+	result, err := runCommand(ctx, client, req)
+	if err != nil {
+		sshPool.Invalidate(profile, client)
+		return HostExecResult{Profile: profile, Result: result, Error: err.Error()}
+	}
+	return HostExecResult{Profile: profile, Result: result}
+}
+
+// BatchExecHandler 批量执行命令并返回汇总结果
+func BatchExecHandler(c echo.Context) error {
+	var req BatchExecRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if req.Group != "" {
+		for _, id := range hubInventory.hostsInGroup(req.Group) {
+			if !containsString(req.Profiles, id) {
+				req.Profiles = append(req.Profiles, id)
+			}
+		}
+	}
+	if len(req.Profiles) == 0 || req.Command == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "profiles (or a non-empty group) and command are required"})
+	}
+	// 任意一台主机无权执行时拒绝整个批次，避免只在部分主机上执行
+	ident := requestIdentity(c)
+	for _, profile := range req.Profiles {
+		if err := authorizeHost(ident, sshutil.CapExec, profile); err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	parallelism := req.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultBatchParallelism
+	}
+	if parallelism > maxBatchParallelism {
+		parallelism = maxBatchParallelism
+	}
+	timeout := req.Timeout.D()
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	if timeout > maxExecTimeout {
+		timeout = maxExecTimeout
+	}
+
+	var progress *RelaySession
+	if req.Token != "" {
+		progress = relayHub.findSession(req.Token)
+	}
+	report := BatchExecReport{
+		BatchID: fmt.Sprintf("batch-%d", time.Now().UnixNano()),
+		Total:   len(req.Profiles),
+		Hosts:   make([]HostExecResult, len(req.Profiles)),
+	}
+	log.Printf("Batch %s running on %d hosts, parallelism %d", report.BatchID, report.Total, parallelism)
+
+	start := time.Now()
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, profile := range req.Profiles {
+		wg.Add(1)
+		go func(i int, profile string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// 每台主机单独计时，排队等待的时间不算在内
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+			host := execOnProfile(ctx, profile, req.ExecRequest)
+			auditCommand(ident, c.RealIP(), req.Command, host)
+			hubMetrics.Inc("hub_exec_batch_hosts_total", "ok", boolLabel(host.ok()))
+
+			mu.Lock()
+			report.Hosts[i] = host
+			done++
+			finished := done
+			mu.Unlock()
+
+			if progress != nil {
+				progress.sendNotify(WebSocketMessage{
+					Type:   MessageTypeNotify,
+					Action: "batch_progress",
+					Data: map[string]interface{}{
+						"batchId": report.BatchID,
+						"done":    finished,
+						"total":   report.Total,
+						"host":    host,
+					},
+				})
+			}
+		}(i, profile)
+	}
+	wg.Wait()
+
+	report.DurationMs = time.Since(start).Milliseconds()
+	for _, host := range report.Hosts {
+		if host.ok() {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	if progress != nil {
+		progress.sendNotify(WebSocketMessage{
+			Type:   MessageTypeNotify,
+			Action: "batch_done",
+			Data: map[string]interface{}{
+				"batchId":   report.BatchID,
+				"total":     report.Total,
+				"succeeded": report.Succeeded,
+				"failed":    report.Failed,
+			},
+		})
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,142 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// -----------------------
+// 协议实验：按 token 将会话分入实验组，每组通过 Flags 覆盖功能开关，分组写入指标标签，便于在线对比。
+// 功能开关的取值顺序为：租户上的覆盖、实验分组的覆盖（多个实验覆盖同一开关时取配置中靠前的实验）、部署默认值
+// -----------------------
+
+// Experiment 定义一个实验，例如
+// {"name":"compression","cohorts":["on","off"],"flags":{"on":{"compression":true},"off":{"compression":false}}}
+type Experiment struct {
+	Name        string                     `json:"name"`
+	Cohorts     []string                   `json:"cohorts"`
+	Weights     []int                      `json:"weights,omitempty"`     // 与 Cohorts 一一对应，缺省时均分
+	Assignments map[string]string          `json:"assignments,omitempty"` // 指定 token 固定分组
+	Flags       map[string]map[string]bool `json:"flags,omitempty"`       // 分组 -> 功能开关覆盖
+}
+
+func (e Experiment) validate() error {
+	if e.Name == "" {
+		return errors.New("experiment name is required")
+	}
+	// 权重写错时不能悄悄退回均分或全部分到第一组
+	if len(e.Weights) > 0 {
+		if len(e.Weights) != len(e.Cohorts) {
+			return fmt.Errorf("experiment %s: %d weights for %d cohorts", e.Name, len(e.Weights), len(e.Cohorts))
+		}
+		total := 0
+		for i, w := range e.Weights {
+			if w < 0 {
+				return fmt.Errorf("experiment %s: negative weight for cohort %q", e.Name, e.Cohorts[i])
+			}
+			total += w
+		}
+		if total == 0 {
+			return fmt.Errorf("experiment %s: weights must not all be zero", e.Name)
+		}
+	}
+	for token, cohort := range e.Assignments {
+		if !containsString(e.Cohorts, cohort) {
+			return fmt.Errorf("experiment %s: token %q assigned to unknown cohort %q", e.Name, token, cohort)
+		}
+	}
+	for cohort, flags := range e.Flags {
+		if !containsString(e.Cohorts, cohort) {
+			return fmt.Errorf("experiment %s: unknown cohort %q in flags", e.Name, cohort)
+		}
+		for name := range flags {
+			if !knownFlag(name) {
+				return fmt.Errorf("experiment %s: unknown feature flag %q", e.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// assign 计算 token 在该实验中的分组，同一 token 结果稳定
+func (e Experiment) assign(token string) string {
+	if cohort, ok := e.Assignments[token]; ok {
+		return cohort
+	}
+	if len(e.Cohorts) == 0 {
+		return ""
+	}
+	weights := e.Weights
+	if len(weights) != len(e.Cohorts) {
+		weights = make([]int, len(e.Cohorts))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return e.Cohorts[0]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(e.Name + ":" + token))
+	bucket := int(h.Sum32() % uint32(total))
+	for i, w := range weights {
+		if bucket < w {
+			return e.Cohorts[i]
+		}
+		bucket -= w
+	}
+	return e.Cohorts[len(e.Cohorts)-1]
+}
+
+// assignCohorts 计算 token 在全部实验中的分组
+func assignCohorts(token string) map[string]string {
+	cohorts := make(map[string]string)
+	for _, exp := range hubConfig.Experiments {
+		if cohort := exp.assign(token); cohort != "" {
+			cohorts[exp.Name] = cohort
+		}
+	}
+	return cohorts
+}
+
+// cohort 返回会话在某个实验中的分组，未参与时返回空字符串
+func (s *RelaySession) cohort(experiment string) string {
+	return s.cohorts[experiment]
+}
+
+// cohortFeature 返回会话所在分组对功能开关的覆盖，ok 为 false 表示没有实验覆盖该开关
+func (s *RelaySession) cohortFeature(name string) (enabled, ok bool) {
+	for _, exp := range hubConfig.Experiments {
+		if enabled, ok := exp.Flags[s.cohort(exp.Name)][name]; ok {
+			return enabled, true
+		}
+	}
+	return false, false
+}
+
+// metricLabels 将实验分组转为指标标签，形如 exp_compression="on"
+func (s *RelaySession) metricLabels(labels ...string) []string {
+	names := make([]string, 0, len(s.cohorts))
+	for name := range s.cohorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels = append(labels, "exp_"+name, s.cohorts[name])
+	}
+	return labels
+}
+
+// recordRelayed 记录一条中继消息，带上实验分组标签
+func (s *RelaySession) recordRelayed(direction string, size int) {
+	labels := s.metricLabels("direction", direction)
+	hubMetrics.Inc("hub_relayed_messages_total", labels...)
+	hubMetrics.Add("hub_relayed_bytes_total", int64(size), labels...)
+}
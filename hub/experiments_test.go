@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExperimentValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  Experiment
+		err  string // 为空表示合法
+	}{
+		{"even split", Experiment{Name: "c", Cohorts: []string{"on", "off"}}, ""},
+		{"weighted", Experiment{Name: "c", Cohorts: []string{"on", "off"}, Weights: []int{1, 9}}, ""},
+		{"zero weight for one cohort", Experiment{Name: "c", Cohorts: []string{"on", "off"}, Weights: []int{0, 1}}, ""},
+		{"flags and assignments", Experiment{
+			Name:        "c",
+			Cohorts:     []string{"on", "off"},
+			Assignments: map[string]string{"t1": "off"},
+			Flags:       map[string]map[string]bool{"on": {FlagCompression: true}},
+		}, ""},
+		{"missing name", Experiment{Cohorts: []string{"on"}}, "name is required"},
+		{"fewer weights than cohorts", Experiment{Name: "c", Cohorts: []string{"on", "off"}, Weights: []int{1}}, "1 weights for 2 cohorts"},
+		{"more weights than cohorts", Experiment{Name: "c", Cohorts: []string{"on"}, Weights: []int{1, 1}}, "2 weights for 1 cohorts"},
+		{"negative weight", Experiment{Name: "c", Cohorts: []string{"on", "off"}, Weights: []int{2, -1}}, `negative weight for cohort "off"`},
+		{"zero total weight", Experiment{Name: "c", Cohorts: []string{"on", "off"}, Weights: []int{0, 0}}, "must not all be zero"},
+		{"unknown assignment cohort", Experiment{Name: "c", Cohorts: []string{"on", "off"}, Assignments: map[string]string{"t1": "of"}}, `unknown cohort "of"`},
+		{"unknown flags cohort", Experiment{Name: "c", Cohorts: []string{"on"}, Flags: map[string]map[string]bool{"off": {}}}, `unknown cohort "off" in flags`},
+		{"unknown flag", Experiment{Name: "c", Cohorts: []string{"on"}, Flags: map[string]map[string]bool{"on": {"nope": true}}}, `unknown feature flag "nope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.exp.validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("validate error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExperimentAssign(t *testing.T) {
+	exp := Experiment{Name: "c", Cohorts: []string{"on", "off"}, Weights: []int{0, 1}, Assignments: map[string]string{"pinned": "on"}}
+	for _, token := range []string{"a", "b", "c", "d"} {
+		if got := exp.assign(token); got != "off" {
+			t.Errorf("assign(%q) = %q, want off", token, got)
+		}
+	}
+	if got := exp.assign("pinned"); got != "on" {
+		t.Errorf("assign(pinned) = %q, want on", got)
+	}
+}
@@ -0,0 +1,191 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 功能开关：按部署设置默认值，可按租户覆盖，运行中通过 /admin/features 修改
+// -----------------------
+
+const (
+	FlagChecksum     = "checksum"      // hello 中协商帧校验
+	FlagPendingQueue = "pending_queue" // agent 重连期间暂存消息
+	FlagCompression  = "compression"   // 压缩发给前端的帧，还需要开启 compression 配置并在握手时协商成功
+	FlagReplayBuffer = "replay_buffer" // 断线续传
+	FlagHubProbe     = "hub_probe"     // 前端可以从 hub 所在位置发起连通性探测
+)
+
+// FeatureFlagsConfig 功能开关配置，未出现在 Defaults 中的开关取 defaultFlags 的值
+type FeatureFlagsConfig struct {
+	Defaults map[string]bool            `json:"defaults,omitempty"`
+	Tenants  map[string]map[string]bool `json:"tenants,omitempty"` // 租户 -> 开关覆盖
+}
+
+var defaultFlags = map[string]bool{
+	FlagChecksum:     true,
+	FlagPendingQueue: true,
+	FlagCompression:  true,
+	FlagReplayBuffer: false,
+	FlagHubProbe:     false,
+}
+
+// knownFlag 只有 defaultFlags 中的开关有效果
+func knownFlag(name string) bool {
+	_, ok := defaultFlags[name]
+	return ok
+}
+
+func (cfg FeatureFlagsConfig) validate() error {
+	for name := range cfg.Defaults {
+		if !knownFlag(name) {
+			return fmt.Errorf("features.defaults: unknown feature flag %q", name)
+		}
+	}
+	for tenant, flags := range cfg.Tenants {
+		for name := range flags {
+			if !knownFlag(name) {
+				return fmt.Errorf("features.tenants.%s: unknown feature flag %q", tenant, name)
+			}
+		}
+	}
+	return nil
+}
+
+type featureFlags struct {
+	mu       sync.RWMutex
+	defaults map[string]bool
+	tenants  map[string]map[string]bool
+}
+
+func newFeatureFlags(cfg FeatureFlagsConfig) *featureFlags {
+	f := &featureFlags{
+		defaults: make(map[string]bool),
+		tenants:  make(map[string]map[string]bool),
+	}
+	for name, enabled := range defaultFlags {
+		f.defaults[name] = enabled
+	}
+	for name, enabled := range cfg.Defaults {
+		f.defaults[name] = enabled
+	}
+	for tenant, flags := range cfg.Tenants {
+		f.tenants[tenant] = make(map[string]bool)
+		for name, enabled := range flags {
+			f.tenants[tenant][name] = enabled
+		}
+	}
+	return f
+}
+
+// Enabled 判断某租户下开关是否开启，租户为空时使用部署默认值
+func (f *featureFlags) Enabled(tenant, name string) bool {
+	if enabled, ok := f.tenantOverride(tenant, name); ok {
+		return enabled
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.defaults[name]
+}
+
+// tenantOverride 返回租户上对开关的覆盖，ok 为 false 表示没有覆盖
+func (f *featureFlags) tenantOverride(tenant, name string) (enabled, ok bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	enabled, ok = f.tenants[tenant][name]
+	return enabled, ok
+}
+
+// Set 设置开关，tenant 为空时修改部署默认值
+func (f *featureFlags) Set(tenant, name string, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tenant == "" {
+		f.defaults[name] = enabled
+		return
+	}
+	if f.tenants[tenant] == nil {
+		f.tenants[tenant] = make(map[string]bool)
+	}
+	f.tenants[tenant][name] = enabled
+}
+
+// Unset 删除租户上的覆盖，恢复为部署默认值
+func (f *featureFlags) Unset(tenant, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tenants[tenant], name)
+	if len(f.tenants[tenant]) == 0 {
+		delete(f.tenants, tenant)
+	}
+}
+
+func (f *featureFlags) snapshot() FeatureFlagsConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	cfg := FeatureFlagsConfig{
+		Defaults: make(map[string]bool),
+		Tenants:  make(map[string]map[string]bool),
+	}
+	for name, enabled := range f.defaults {
+		cfg.Defaults[name] = enabled
+	}
+	for tenant, flags := range f.tenants {
+		cfg.Tenants[tenant] = make(map[string]bool)
+		for name, enabled := range flags {
+			cfg.Tenants[tenant][name] = enabled
+		}
+	}
+	return cfg
+}
+
+var hubFeatures = newFeatureFlags(FeatureFlagsConfig{})
+
+// feature 判断会话是否开启某个功能：租户覆盖优先，其次是会话所在实验分组的覆盖，最后是部署默认值
+func (s *RelaySession) feature(name string) bool {
+	if enabled, ok := hubFeatures.tenantOverride(s.tenant, name); ok {
+		return enabled
+	}
+	if enabled, ok := s.cohortFeature(name); ok {
+		return enabled
+	}
+	return hubFeatures.Enabled(s.tenant, name)
+}
+
+// ListFeaturesHandler 查询全部功能开关
+func ListFeaturesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, hubFeatures.snapshot())
+}
+
+// SetFeatureHandler 修改功能开关，请求体 {"enabled":true,"tenant":"可选"}；未知的开关返回 400
+func SetFeatureHandler(c echo.Context) error {
+	if !knownFlag(c.Param("name")) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown feature flag " + c.Param("name")})
+	}
+	var req struct {
+		Enabled bool   `json:"enabled"`
+		Tenant  string `json:"tenant"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	hubFeatures.Set(req.Tenant, c.Param("name"), req.Enabled)
+	return c.JSON(http.StatusOK, hubFeatures.snapshot())
+}
+
+// UnsetFeatureHandler 删除租户上的开关覆盖，tenant 通过查询参数传入
+func UnsetFeatureHandler(c echo.Context) error {
+	if !knownFlag(c.Param("name")) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown feature flag " + c.Param("name")})
+	}
+	tenant := c.QueryParam("tenant")
+	if tenant == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing tenant"})
+	}
+	hubFeatures.Unset(tenant, c.Param("name"))
+	return c.JSON(http.StatusOK, hubFeatures.snapshot())
+}
@@ -0,0 +1,323 @@
+package hub
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 连接指纹：按身份主体记录前端的 User-Agent、TLS 版本和套件、WS 扩展、来源国家/ASN、action 分布和消息间隔，
+// 并对偏离历史的连接打标记：新的国家（new_geo），或者样本足够后出现从未用过的 action（unusual_action）。
+// 标记写入审计日志并可通过 admin API 查询，只作为安全信号，不拦截连接
+// -----------------------
+
+const (
+	AnomalyNewGeo        = "new_geo"
+	AnomalyUnusualAction = "unusual_action"
+)
+
+// FingerprintConfig Enabled 为 false 时不记录
+type FingerprintConfig struct {
+	Enabled       bool `json:"enabled"`
+	MinSamples    int  `json:"minSamples"`    // 主体累计消息数达到后才检查 action 分布
+	MaxIdentities int  `json:"maxIdentities"` // 超出时淘汰最久未出现的主体
+	MaxAnomalies  int  `json:"maxAnomalies"`  // 每个主体保留的最近标记数
+	MaxValues     int  `json:"maxValues"`     // User-Agent 等每类特征保留的不同取值数
+	MaxActions    int  `json:"maxActions"`    // 每个主体记录的不同 action 数
+}
+
+func (cfg FingerprintConfig) validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MinSamples < 0 {
+		return errors.New("fingerprint.minSamples must not be negative")
+	}
+	if cfg.MaxIdentities <= 0 || cfg.MaxAnomalies <= 0 || cfg.MaxValues <= 0 || cfg.MaxActions <= 0 {
+		return errors.New("fingerprint.maxIdentities, maxAnomalies, maxValues and maxActions must be positive")
+	}
+	return nil
+}
+
+// Anomaly 一条异常标记
+type Anomaly struct {
+	At       time.Time `json:"at"`
+	Kind     string    `json:"kind"`
+	Detail   string    `json:"detail"`
+	Token    string    `json:"token"`
+	ClientIP string    `json:"clientIP,omitempty"`
+}
+
+// ClientFingerprint 单个身份主体的特征，各 map 的值为出现次数
+type ClientFingerprint struct {
+	Subject     string           `json:"subject"`
+	FirstSeen   time.Time        `json:"firstSeen"`
+	LastSeen    time.Time        `json:"lastSeen"`
+	Connections int64            `json:"connections"`
+	Messages    int64            `json:"messages"`
+	IntervalMs  float64          `json:"intervalMs"` // 同一连接内相邻消息间隔的滑动平均
+	UserAgents  map[string]int64 `json:"userAgents"`
+	TLS         map[string]int64 `json:"tls"`
+	Extensions  map[string]int64 `json:"extensions"`
+	Countries   map[string]int64 `json:"countries"`
+	ASNs        map[string]int64 `json:"asns"`
+	Actions     map[string]int64 `json:"actions"`
+	Anomalies   []Anomaly        `json:"anomalies"`
+}
+
+// intervalWeight 消息间隔滑动平均中新样本的权重
+const intervalWeight = 0.05
+
+type fingerprintStore struct {
+	cfg FingerprintConfig
+
+	mu       sync.Mutex
+	subjects map[string]*ClientFingerprint
+}
+
+// hubFingerprints 为 nil 表示未启用
+var hubFingerprints *fingerprintStore
+
+func newFingerprintStore(cfg FingerprintConfig) *fingerprintStore {
+	return &fingerprintStore{cfg: cfg, subjects: make(map[string]*ClientFingerprint)}
+}
+
+// getLocked 返回主体的记录，不存在时创建，超出上限时淘汰最久未出现的主体
+func (f *fingerprintStore) getLocked(subject string, now time.Time) *ClientFingerprint {
+	fp := f.subjects[subject]
+	if fp != nil {
+		return fp
+	}
+	if len(f.subjects) >= f.cfg.MaxIdentities {
+		var oldest *ClientFingerprint
+		for _, c := range f.subjects {
+			if oldest == nil || c.LastSeen.Before(oldest.LastSeen) {
+				oldest = c
+			}
+		}
+		delete(f.subjects, oldest.Subject)
+	}
+	fp = &ClientFingerprint{
+		Subject:    subject,
+		FirstSeen:  now,
+		UserAgents: map[string]int64{},
+		TLS:        map[string]int64{},
+		Extensions: map[string]int64{},
+		Countries:  map[string]int64{},
+		ASNs:       map[string]int64{},
+		Actions:    map[string]int64{},
+	}
+	f.subjects[subject] = fp
+	return fp
+}
+
+// countValue 累加取值的次数，不同取值超过 limit 时归入 "other"
+func countValue(m map[string]int64, value string, limit int) {
+	if value == "" {
+		return
+	}
+	if _, ok := m[value]; !ok && len(m) >= limit {
+		value = "other"
+	}
+	m[value]++
+}
+
+func (f *fingerprintStore) flagLocked(fp *ClientFingerprint, a Anomaly) {
+	fp.Anomalies = append(fp.Anomalies, a)
+	if over := len(fp.Anomalies) - f.cfg.MaxAnomalies; over > 0 {
+		fp.Anomalies = fp.Anomalies[over:]
+	}
+}
+
+// observeConnect 记录一次连接的特征，返回新出现的异常
+func (f *fingerprintStore) observeConnect(subject, token string, r *http.Request, origin ClientOrigin) []Anomaly {
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fp := f.getLocked(subject, now)
+	var flagged []Anomaly
+	if origin.Geo != nil && origin.Geo.Country != "" {
+		if _, seen := fp.Countries[origin.Geo.Country]; !seen && len(fp.Countries) > 0 {
+			a := Anomaly{
+				At:       now,
+				Kind:     AnomalyNewGeo,
+				Detail:   fmt.Sprintf("first connection from %s (AS%d)", origin.Geo.Country, origin.Geo.ASN),
+				Token:    token,
+				ClientIP: origin.IP,
+			}
+			f.flagLocked(fp, a)
+			flagged = append(flagged, a)
+		}
+		countValue(fp.Countries, origin.Geo.Country, f.cfg.MaxValues)
+		countValue(fp.ASNs, fmt.Sprintf("AS%d", origin.Geo.ASN), f.cfg.MaxValues)
+	}
+	countValue(fp.UserAgents, r.UserAgent(), f.cfg.MaxValues)
+	countValue(fp.TLS, tlsFingerprint(r.TLS), f.cfg.MaxValues)
+	countValue(fp.Extensions, r.Header.Get("Sec-WebSocket-Extensions"), f.cfg.MaxValues)
+	fp.Connections++
+	fp.LastSeen = now
+	return flagged
+}
+
+// observeMessage 记录一条消息的 action 和与上一条消息的间隔（gap 为 0 表示连接内的第一条）
+func (f *fingerprintStore) observeMessage(subject, token, action, clientIP string, gap time.Duration) []Anomaly {
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fp := f.getLocked(subject, now)
+	var flagged []Anomaly
+	if _, seen := fp.Actions[action]; !seen && fp.Messages >= int64(f.cfg.MinSamples) && len(fp.Actions) > 0 {
+		a := Anomaly{
+			At:       now,
+			Kind:     AnomalyUnusualAction,
+			Detail:   fmt.Sprintf("action %q not seen in %d previous messages", action, fp.Messages),
+			Token:    token,
+			ClientIP: clientIP,
+		}
+		f.flagLocked(fp, a)
+		flagged = append(flagged, a)
+	}
+	countValue(fp.Actions, action, f.cfg.MaxActions)
+	if gap > 0 {
+		ms := float64(gap) / float64(time.Millisecond)
+		if fp.IntervalMs == 0 {
+			fp.IntervalMs = ms
+		} else {
+			fp.IntervalMs += (ms - fp.IntervalMs) * intervalWeight
+		}
+	}
+	fp.Messages++
+	fp.LastSeen = now
+	return flagged
+}
+
+// snapshot 返回主体记录的副本
+func (f *fingerprintStore) snapshot(subject string) (ClientFingerprint, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fp := f.subjects[subject]
+	if fp == nil {
+		return ClientFingerprint{}, false
+	}
+	out := *fp
+	out.UserAgents = copyCounts(fp.UserAgents)
+	out.TLS = copyCounts(fp.TLS)
+	out.Extensions = copyCounts(fp.Extensions)
+	out.Countries = copyCounts(fp.Countries)
+	out.ASNs = copyCounts(fp.ASNs)
+	out.Actions = copyCounts(fp.Actions)
+	out.Anomalies = append([]Anomaly{}, fp.Anomalies...)
+	return out, true
+}
+
+func copyCounts(m map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// tlsFingerprint 协商的 TLS 版本和套件，明文连接为空
+func tlsFingerprint(cs *tls.ConnectionState) string {
+	if cs == nil {
+		return ""
+	}
+	return tls.VersionName(cs.Version) + "/" + tls.CipherSuiteName(cs.CipherSuite)
+}
+
+// recordAnomalies 把异常写入审计日志和指标
+func recordAnomalies(subject string, anomalies []Anomaly) {
+	for _, a := range anomalies {
+		hubMetrics.Inc("hub_client_anomalies_total", "kind", a.Kind)
+		log.Printf("Client %s anomaly %s: %s", subject, a.Kind, a.Detail)
+		if hubAudit != nil {
+			hubAudit.write(AuditEntry{
+				Time:      a.At,
+				Token:     a.Token,
+				Subject:   subject,
+				ClientIP:  a.ClientIP,
+				Direction: "anomaly",
+				Action:    a.Kind,
+				Outcome:   AuditAnomaly,
+				Error:     a.Detail,
+			})
+		}
+	}
+}
+
+// fingerprintConnect 前端连接建立时调用
+func fingerprintConnect(client *wsClientConn, token string, r *http.Request) {
+	if hubFingerprints == nil || client.ident == nil {
+		return
+	}
+	subject := client.ident.Subject
+	recordAnomalies(subject, hubFingerprints.observeConnect(subject, token, r, client.origin))
+}
+
+// fingerprintMessage 前端消息通过拦截器后调用
+func (s *RelaySession) fingerprintMessage(client *wsClientConn, msg WebSocketMessage, readAt time.Time) {
+	if hubFingerprints == nil || client.ident == nil || msg.Action == "" {
+		return
+	}
+	var gap time.Duration
+	if last := client.lastMessageAt.Swap(readAt.UnixNano()); last != 0 {
+		gap = readAt.Sub(time.Unix(0, last))
+	}
+	subject := client.ident.Subject
+	recordAnomalies(subject, hubFingerprints.observeMessage(subject, s.token, msg.Action, client.origin.IP, gap))
+}
+
+// FingerprintSummary 指纹列表中的一项
+type FingerprintSummary struct {
+	Subject     string    `json:"subject"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Connections int64     `json:"connections"`
+	Messages    int64     `json:"messages"`
+	Anomalies   int       `json:"anomalies"`
+}
+
+// ListFingerprintsHandler 列出已记录的主体，?anomalous=true 时只返回有异常标记的主体
+func ListFingerprintsHandler(c echo.Context) error {
+	if hubFingerprints == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "fingerprinting is not enabled"})
+	}
+	anomalous := c.QueryParam("anomalous") == "true"
+	hubFingerprints.mu.Lock()
+	list := make([]FingerprintSummary, 0, len(hubFingerprints.subjects))
+	for _, fp := range hubFingerprints.subjects {
+		if anomalous && len(fp.Anomalies) == 0 {
+			continue
+		}
+		list = append(list, FingerprintSummary{
+			Subject:     fp.Subject,
+			LastSeen:    fp.LastSeen,
+			Connections: fp.Connections,
+			Messages:    fp.Messages,
+			Anomalies:   len(fp.Anomalies),
+		})
+	}
+	hubFingerprints.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	return c.JSON(http.StatusOK, list)
+}
+
+// GetFingerprintHandler 查询单个主体的特征和异常标记
+func GetFingerprintHandler(c echo.Context) error {
+	if hubFingerprints == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "fingerprinting is not enabled"})
+	}
+	fp, ok := hubFingerprints.snapshot(c.Param("subject"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "subject not found"})
+	}
+	return c.JSON(http.StatusOK, fp)
+}
@@ -0,0 +1,215 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// -----------------------
+// 超大消息分片：发送方把 Data 序列化后的 JSON 按字节切成若干段，每段作为一条消息发送，
+// d 为该段的字符串，fg 标明分片所属的消息、序号和是否为最后一片；同一条消息的分片共享 t/r/a/ch。
+// 接收方按序号拼接全部分片后再反序列化得到原来的 Data，从而让超过单条消息上限的数据通过中转。
+// hub 对转发给 agent 或路由端点的分片原样转发，只有本地处理的 action 才在 hub 上重组；
+// hub 自己产生的响应超过 Size 时，对在 hello 中协商了 fragment 的前端分片发送
+// -----------------------
+
+const (
+	FeatureFragment = "fragment"
+	// ErrCodeFragment 分片序号不连续、重组超过上限或超时
+	ErrCodeFragment = "bad_fragment"
+)
+
+// Fragment 分片信息，ID 在发送方连接内唯一
+type Fragment struct {
+	ID    string `json:"id"`
+	Index int    `json:"i"`
+	Final bool   `json:"f,omitempty"`
+}
+
+// FragmentConfig Size 为 0 时不接受 fragment 协商
+type FragmentConfig struct {
+	Size    int      `json:"size"`    // hub 发送时每片 Data 的最大字节数
+	MaxSize int64    `json:"maxSize"` // 单条消息重组后的最大字节数
+	Timeout Duration `json:"timeout"` // 从第一片到最后一片的最长时间
+}
+
+func (cfg FragmentConfig) validate() error {
+	if cfg.Size < 0 {
+		return errors.New("fragment.size must not be negative")
+	}
+	if cfg.Size == 0 {
+		return nil
+	}
+	if cfg.MaxSize <= 0 {
+		return errors.New("fragment.maxSize must be positive")
+	}
+	if cfg.Timeout.D() <= 0 {
+		return errors.New("fragment.timeout must be positive")
+	}
+	return nil
+}
+
+var (
+	errFragmentOrder    = errors.New("fragment out of order")
+	errFragmentTooBig   = errors.New("reassembled message exceeds size limit")
+	errFragmentTimeout  = errors.New("fragmented message timed out")
+	errFragmentNotFirst = errors.New("fragment without a preceding first fragment")
+)
+
+type partialMessage struct {
+	parts   []byte
+	next    int
+	started time.Time
+}
+
+// fragmentBuffer 单个前端连接正在重组的消息
+type fragmentBuffer struct {
+	mu      sync.Mutex
+	partial map[string]*partialMessage
+	seq     int64 // hub 发送分片时的 ID 计数
+}
+
+// add 追加一片，最后一片到达时返回重组后的 Data
+func (b *fragmentBuffer) add(msg WebSocketMessage, now time.Time) (data interface{}, done bool, err error) {
+	cfg := hubConfig.Fragment
+	chunk, _ := msg.Data.(string)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, p := range b.partial {
+		if now.Sub(p.started) > cfg.Timeout.D() {
+			delete(b.partial, id)
+			hubMetrics.Inc("hub_fragment_errors_total", "reason", "timeout")
+		}
+	}
+	p := b.partial[msg.Frag.ID]
+	if p == nil {
+		if msg.Frag.Index != 0 {
+			return nil, false, errFragmentNotFirst
+		}
+		p = &partialMessage{started: now}
+		if b.partial == nil {
+			b.partial = make(map[string]*partialMessage)
+		}
+		b.partial[msg.Frag.ID] = p
+	}
+	if msg.Frag.Index != p.next {
+		delete(b.partial, msg.Frag.ID)
+		return nil, false, errFragmentOrder
+	}
+	if int64(len(p.parts)+len(chunk)) > cfg.MaxSize {
+		delete(b.partial, msg.Frag.ID)
+		return nil, false, errFragmentTooBig
+	}
+	p.parts = append(p.parts, chunk...)
+	p.next++
+	if !msg.Frag.Final {
+		return nil, false, nil
+	}
+	delete(b.partial, msg.Frag.ID)
+	if err := json.Unmarshal(p.parts, &data); err != nil {
+		return nil, false, fmt.Errorf("reassembled data: %w", err)
+	}
+	return data, true, nil
+}
+
+// size 返回正在重组的消息已收到的字节数
+func (b *fragmentBuffer) size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var n int64
+	for _, p := range b.partial {
+		n += int64(len(p.parts))
+	}
+	return n
+}
+
+// reset 前端断开时丢弃未完成的消息
+func (b *fragmentBuffer) reset() {
+	b.mu.Lock()
+	b.partial = nil
+	b.mu.Unlock()
+}
+
+// splitFragments 把 msg 的 Data 切成每片不超过 size 字节的分片，切分点落在 UTF-8 字符边界上
+func splitFragments(msg WebSocketMessage, id string, size int) ([][]byte, error) {
+	raw, err := json.Marshal(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	var frames [][]byte
+	for i := 0; ; i++ {
+		end := min(size, len(raw))
+		for end < len(raw) && end > 0 && !utf8.RuneStart(raw[end]) {
+			end--
+		}
+		frag := msg
+		frag.Data = string(raw[:end])
+		frag.Frag = &Fragment{ID: id, Index: i, Final: end == len(raw)}
+		data, err := json.Marshal(frag)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, data)
+		raw = raw[end:]
+		if len(raw) == 0 {
+			return frames, nil
+		}
+	}
+}
+
+// reassembleLocal 本地处理的分片消息在 hub 上重组，返回 false 表示消息尚未完整或已出错
+func (s *RelaySession) reassembleLocal(client *wsClientConn, msg *WebSocketMessage) bool {
+	if msg.Frag == nil {
+		return true
+	}
+	if hubConfig.Fragment.Size <= 0 {
+		s.notifyFragmentError(client, *msg, errors.New("fragmented messages are not enabled"))
+		return false
+	}
+	data, done, err := client.frags.add(*msg, time.Now())
+	if err != nil {
+		s.notifyFragmentError(client, *msg, err)
+		return false
+	}
+	if !done {
+		return false
+	}
+	hubMetrics.Inc("hub_fragments_reassembled_total")
+	msg.Data, msg.Frag = data, nil
+	return true
+}
+
+func (s *RelaySession) notifyFragmentError(client *wsClientConn, msg WebSocketMessage, err error) {
+	reason := err.Error()
+	if errors.Is(err, errFragmentTooBig) {
+		reason = fmt.Sprintf("%s (%d bytes)", reason, hubConfig.Fragment.MaxSize)
+	}
+	hubMetrics.Inc("hub_message_errors_total", "code", ErrCodeFragment)
+	s.notifyError(client, msg.RequestID, ErrCodeFragment, reason)
+}
+
+// sendFragmented 对协商了 fragment 的前端，超过 Size 的消息分片发送；返回 false 表示应按原消息发送
+func (s *RelaySession) sendFragmented(client *wsClientConn, msg WebSocketMessage, encoded int) bool {
+	size := hubConfig.Fragment.Size
+	if size <= 0 || encoded <= size || !client.fragmentOn() {
+		return false
+	}
+	client.frags.mu.Lock()
+	client.frags.seq++
+	id := "h" + strconv.FormatInt(client.frags.seq, 10)
+	client.frags.mu.Unlock()
+	frames, err := splitFragments(msg, id, size)
+	if err != nil {
+		return false
+	}
+	for _, frame := range frames {
+		s.sendTo(client, frame)
+	}
+	hubMetrics.Add("hub_fragments_sent_total", int64(len(frames)))
+	return true
+}
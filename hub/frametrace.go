@@ -0,0 +1,263 @@
+package hub
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 单会话帧级追踪：管理员通过 admin API 为某个会话临时开启，记录四个方向的每一帧（附带解析出的 t/r/a 和大小），
+// 写入环形缓冲区，按需同时追加到 Dir 下的 JSON Lines 文件；到期后自动关闭，避免全局日志刷屏
+// -----------------------
+
+const (
+	FrameClientIn  = "client_in"  // 前端 -> hub
+	FrameClientOut = "client_out" // hub -> 前端
+	FrameAgentIn   = "agent_in"   // agent -> hub
+	FrameAgentOut  = "agent_out"  // hub -> agent
+)
+
+// FrameTraceConfig MaxDuration 为单次追踪的最长时间，Dir 为空时不允许写文件
+type FrameTraceConfig struct {
+	MaxDuration   Duration `json:"maxDuration"`
+	BufferSize    int      `json:"bufferSize"`    // 环形缓冲区保留的帧数
+	MaxFrameBytes int      `json:"maxFrameBytes"` // 每帧记录的最大字节数，超出部分截断
+	Dir           string   `json:"dir,omitempty"`
+}
+
+func (cfg FrameTraceConfig) validate() error {
+	if cfg.MaxDuration.D() <= 0 {
+		return errors.New("frameTrace.maxDuration must be positive")
+	}
+	if cfg.BufferSize <= 0 {
+		return errors.New("frameTrace.bufferSize must be positive")
+	}
+	if cfg.MaxFrameBytes < 0 {
+		return errors.New("frameTrace.maxFrameBytes must not be negative")
+	}
+	return nil
+}
+
+// TracedFrame 一条追踪记录，Type/RequestID/Action 从帧头解析，非 JSON 帧为空
+type TracedFrame struct {
+	At        time.Time `json:"at"`
+	Dir       string    `json:"dir"`
+	Size      int       `json:"size"`
+	Type      string    `json:"t,omitempty"`
+	RequestID string    `json:"r,omitempty"`
+	Action    string    `json:"a,omitempty"`
+	Data      string    `json:"data"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// FrameTraceState 追踪状态
+type FrameTraceState struct {
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	File    string    `json:"file,omitempty"`
+	Frames  int64     `json:"frames"`
+}
+
+type frameTracer struct {
+	on     atomic.Bool // 到期或关闭后为 false，记录仍保留以便查询
+	mu     sync.Mutex
+	state  FrameTraceState
+	frames []TracedFrame
+	next   int
+	file   *os.File
+	enc    *json.Encoder
+	timer  *time.Timer
+}
+
+// traceFrame 记录一帧，未开启追踪时直接返回
+func (s *RelaySession) traceFrame(dir string, data []byte) {
+	t := s.frameTrace.Load()
+	if t == nil || !t.on.Load() {
+		return
+	}
+	var head struct {
+		Type      string `json:"t"`
+		RequestID string `json:"r"`
+		Action    string `json:"a"`
+	}
+	_ = json.Unmarshal(data, &head)
+	f := TracedFrame{
+		At:        time.Now(),
+		Dir:       dir,
+		Size:      len(data),
+		Type:      head.Type,
+		RequestID: head.RequestID,
+		Action:    head.Action,
+	}
+	if limit := hubConfig.FrameTrace.MaxFrameBytes; limit > 0 && len(data) > limit {
+		data, f.Truncated = data[:limit], true
+	}
+	f.Data = string(data)
+	t.add(f)
+}
+
+func (t *frameTracer) add(f TracedFrame) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.state.Enabled {
+		return
+	}
+	if len(t.frames) < cap(t.frames) {
+		t.frames = append(t.frames, f)
+	} else {
+		t.frames[t.next] = f
+	}
+	t.next = (t.next + 1) % cap(t.frames)
+	t.state.Frames++
+	if t.enc != nil {
+		if err := t.enc.Encode(f); err != nil {
+			log.Println("Frame trace write error:", err)
+			t.file.Close()
+			t.file, t.enc = nil, nil
+		}
+	}
+}
+
+// snapshot 按时间顺序返回缓冲区中的帧
+func (t *frameTracer) snapshot() (FrameTraceState, []TracedFrame) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	frames := make([]TracedFrame, 0, len(t.frames))
+	if len(t.frames) == cap(t.frames) {
+		frames = append(frames, t.frames[t.next:]...)
+		frames = append(frames, t.frames[:t.next]...)
+	} else {
+		frames = append(frames, t.frames...)
+	}
+	return t.state, frames
+}
+
+func (t *frameTracer) stop() {
+	t.on.Store(false)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Enabled = false
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if t.file != nil {
+		t.file.Close()
+		t.file, t.enc = nil, nil
+	}
+}
+
+// startFrameTrace 开启追踪，替换上一次追踪及其记录；toFile 为 true 时同时写入文件
+func (s *RelaySession) startFrameTrace(d time.Duration, toFile bool) (FrameTraceState, error) {
+	cfg := hubConfig.FrameTrace
+	if d <= 0 || d > cfg.MaxDuration.D() {
+		d = cfg.MaxDuration.D()
+	}
+	now := time.Now()
+	t := &frameTracer{
+		state:  FrameTraceState{Enabled: true, Since: now, Until: now.Add(d)},
+		frames: make([]TracedFrame, 0, cfg.BufferSize),
+	}
+	if toFile {
+		if cfg.Dir == "" {
+			return FrameTraceState{}, errors.New("frameTrace.dir is not configured")
+		}
+		name := fmt.Sprintf("%s-%d.jsonl", hex.EncodeToString([]byte(s.token)), now.Unix())
+		path := filepath.Join(cfg.Dir, name)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return FrameTraceState{}, err
+		}
+		t.file, t.enc, t.state.File = f, json.NewEncoder(f), path
+	}
+	t.on.Store(true)
+	t.mu.Lock()
+	t.timer = time.AfterFunc(d, func() {
+		log.Printf("Session %s frame trace expired", s.token)
+		t.stop()
+	})
+	t.mu.Unlock()
+	if old := s.frameTrace.Swap(t); old != nil {
+		old.stop()
+	}
+	hubMetrics.Inc("hub_frame_traces_total")
+	log.Printf("Session %s frame trace enabled for %s", s.token, d)
+	return t.state, nil
+}
+
+// stopFrameTrace 关闭追踪，返回最后一次追踪；会话清理时也会调用
+func (s *RelaySession) stopFrameTrace() *frameTracer {
+	t := s.frameTrace.Load()
+	if t != nil {
+		t.stop()
+	}
+	return t
+}
+
+// FrameTraceRequest 开启追踪的请求体，Duration 为 0 或超过上限时取 MaxDuration
+type FrameTraceRequest struct {
+	Duration Duration `json:"duration"`
+	File     bool     `json:"file"`
+}
+
+// FrameTraceResponse 追踪状态和缓冲区中的帧
+type FrameTraceResponse struct {
+	FrameTraceState
+	Records []TracedFrame `json:"records"`
+}
+
+// StartFrameTraceHandler 为会话开启帧级追踪
+func StartFrameTraceHandler(c echo.Context) error {
+	sess := relayHub.findSession(c.Param("token"))
+	if sess == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "session not found"})
+	}
+	var req FrameTraceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	state, err := sess.startFrameTrace(req.Duration.D(), req.File)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// GetFrameTraceHandler 查询最近一次追踪的状态和记录，到期后记录仍可查询
+func GetFrameTraceHandler(c echo.Context) error {
+	sess := relayHub.findSession(c.Param("token"))
+	if sess == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "session not found"})
+	}
+	t := sess.frameTrace.Load()
+	if t == nil {
+		return c.JSON(http.StatusOK, FrameTraceResponse{Records: []TracedFrame{}})
+	}
+	state, frames := t.snapshot()
+	return c.JSON(http.StatusOK, FrameTraceResponse{FrameTraceState: state, Records: frames})
+}
+
+// StopFrameTraceHandler 关闭追踪并返回缓冲区中的记录
+func StopFrameTraceHandler(c echo.Context) error {
+	sess := relayHub.findSession(c.Param("token"))
+	if sess == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "session not found"})
+	}
+	t := sess.stopFrameTrace()
+	if t == nil {
+		return c.JSON(http.StatusOK, FrameTraceResponse{Records: []TracedFrame{}})
+	}
+	state, frames := t.snapshot()
+	return c.JSON(http.StatusOK, FrameTraceResponse{FrameTraceState: state, Records: frames})
+}
@@ -0,0 +1,235 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 会话分组（房间）：前端通过 group_join / group_leave 加入或离开命名分组，分组可以跨 token，
+// 发布到分组的消息以 group_message 推送给全部成员，例如多个仪表盘同时观察同一台主机。
+// 前端和 agent 都可以发送 group_publish，管理接口也可以向分组发布。
+// 启用授权时加入分组需要 group 能力，规则的 Groups 按分组名匹配
+// -----------------------
+
+const (
+	ActionGroupJoin    = "group_join"    // 前端 -> hub，d 为 GroupData
+	ActionGroupLeave   = "group_leave"   // 前端 -> hub，d 为 GroupData
+	ActionGroupPublish = "group_publish" // 前端或 agent -> hub，d 为 GroupData，不转发给 agent
+	ActionGroupMessage = "group_message" // hub -> 前端，d 为 GroupMessage
+)
+
+// CapGroup 加入会话分组
+const CapGroup = "group"
+
+// ErrCodeGroup 分组名无效、未授权、未加入或超过上限
+const ErrCodeGroup = "group"
+
+// GroupData 分组控制消息的数据，Data 只在 group_publish 中使用
+type GroupData struct {
+	Group string      `json:"group"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// GroupMessage 推送给分组成员的消息，From 为发布者的会话 token，管理接口发布时为空
+type GroupMessage struct {
+	Group string      `json:"group"`
+	From  string      `json:"from,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// GroupInfo 管理接口中展示的分组
+type GroupInfo struct {
+	Name     string   `json:"name"`
+	Members  int      `json:"members"`
+	Sessions []string `json:"sessions"`
+}
+
+// joinGroup 加入分组，返回加入后的成员数
+func (h *RelayHub) joinGroup(name string, s *RelaySession, client *wsClientConn) (int, error) {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	members := h.groups[name]
+	if _, ok := members[client]; ok {
+		return len(members), nil
+	}
+	joined := 0
+	for _, m := range h.groups {
+		if _, ok := m[client]; ok {
+			joined++
+		}
+	}
+	if limit := hubConfig.GroupMaxPerClient; limit > 0 && joined >= limit {
+		return 0, fmt.Errorf("client has joined %d groups, limit reached", joined)
+	}
+	if members == nil {
+		members = make(map[*wsClientConn]*RelaySession)
+		h.groups[name] = members
+	}
+	members[client] = s
+	return len(members), nil
+}
+
+// leaveGroup 离开分组，最后一个成员离开时删除分组
+func (h *RelayHub) leaveGroup(name string, client *wsClientConn) bool {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	members := h.groups[name]
+	if _, ok := members[client]; !ok {
+		return false
+	}
+	delete(members, client)
+	if len(members) == 0 {
+		delete(h.groups, name)
+	}
+	return true
+}
+
+// leaveAllGroups 前端断开时调用
+func (h *RelayHub) leaveAllGroups(client *wsClientConn) {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	for name, members := range h.groups {
+		delete(members, client)
+		if len(members) == 0 {
+			delete(h.groups, name)
+		}
+	}
+}
+
+func (h *RelayHub) inGroup(name string, client *wsClientConn) bool {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	_, ok := h.groups[name][client]
+	return ok
+}
+
+// PublishGroup 向分组的全部成员推送 group_message，exclude 不为 nil 时跳过发布者自己，返回送达的前端数
+func (h *RelayHub) PublishGroup(name, from string, payload interface{}, exclude *wsClientConn) int {
+	data, err := json.Marshal(WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: ActionGroupMessage,
+		Data:   GroupMessage{Group: name, From: from, Data: payload},
+	})
+	if err != nil {
+		return 0
+	}
+	h.groupMu.Lock()
+	targets := make(map[*wsClientConn]*RelaySession, len(h.groups[name]))
+	for client, s := range h.groups[name] {
+		if client != exclude {
+			targets[client] = s
+		}
+	}
+	h.groupMu.Unlock()
+	for client, s := range targets {
+		s.sendTo(client, data)
+	}
+	hubMetrics.Inc("hub_group_published_total")
+	hubMetrics.Add("hub_group_delivered_total", int64(len(targets)))
+	return len(targets)
+}
+
+func (h *RelayHub) listGroups() []GroupInfo {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	infos := make([]GroupInfo, 0, len(h.groups))
+	for name, members := range h.groups {
+		info := GroupInfo{Name: name, Members: len(members), Sessions: []string{}}
+		seen := make(map[string]bool)
+		for _, s := range members {
+			if !seen[s.token] {
+				seen[s.token] = true
+				info.Sessions = append(info.Sessions, s.token)
+			}
+		}
+		sort.Strings(info.Sessions)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// handleGroupControl 处理前端的分组消息，返回 false 表示不是分组消息
+func (s *RelaySession) handleGroupControl(client *wsClientConn, msg WebSocketMessage) bool {
+	switch msg.Action {
+	case ActionGroupJoin, ActionGroupLeave, ActionGroupPublish:
+	default:
+		return false
+	}
+	var req GroupData
+	if err := decodeData(msg.Data, &req); err != nil || req.Group == "" {
+		s.notifyError(client, msg.RequestID, ErrCodeBadMessage, msg.Action+" requires group")
+		return true
+	}
+
+	result := map[string]interface{}{"group": req.Group}
+	switch msg.Action {
+	case ActionGroupJoin:
+		if err := authorize(client.ident, CapGroup, []string{req.Group}, req.Group); err != nil {
+			s.notifyError(client, msg.RequestID, ErrCodeGroup, err.Error())
+			return true
+		}
+		members, err := relayHub.joinGroup(req.Group, s, client)
+		if err != nil {
+			s.notifyError(client, msg.RequestID, ErrCodeGroup, err.Error())
+			return true
+		}
+		result["members"] = members
+	case ActionGroupLeave:
+		result["left"] = relayHub.leaveGroup(req.Group, client)
+	case ActionGroupPublish:
+		if !relayHub.inGroup(req.Group, client) {
+			s.notifyError(client, msg.RequestID, ErrCodeGroup, fmt.Sprintf("not a member of group %q", req.Group))
+			return true
+		}
+		result["delivered"] = relayHub.PublishGroup(req.Group, s.token, req.Data, client)
+	}
+	data, err := json.Marshal(WebSocketMessage{
+		Type:      MessageTypeResponse,
+		RequestID: msg.RequestID,
+		Action:    msg.Action,
+		Data:      result,
+	})
+	if err == nil {
+		s.sendTo(client, data)
+	}
+	return true
+}
+
+// agentGroupPublish agent 发送的 group_publish 由 hub 发布到分组，返回 false 表示不是分组消息
+func (s *RelaySession) agentGroupPublish(data []byte) bool {
+	if !bytes.Contains(data, []byte(`"`+ActionGroupPublish+`"`)) {
+		return false
+	}
+	var msg WebSocketMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Action != ActionGroupPublish {
+		return false
+	}
+	var req GroupData
+	if err := decodeData(msg.Data, &req); err != nil || req.Group == "" {
+		return true
+	}
+	relayHub.PublishGroup(req.Group, s.token, req.Data, nil)
+	return true
+}
+
+// ListGroupsAdminHandler 列出当前的会话分组
+func ListGroupsAdminHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, relayHub.listGroups())
+}
+
+// PublishGroupHandler 向分组发布消息，请求体为任意 JSON，作为 group_message 的 data
+func PublishGroupHandler(c echo.Context) error {
+	var payload interface{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	delivered := relayHub.PublishGroup(c.Param("name"), "", payload, nil)
+	return c.JSON(http.StatusOK, map[string]int{"delivered": delivered})
+}
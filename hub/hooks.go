@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// -----------------------
+// 消息拦截器：在不修改读循环的前提下接入日志、过滤、改写和策略检查。
+// 拦截器按注册顺序依次执行，前一个的输出作为后一个的输入
+// -----------------------
+
+// HookSession 拦截器可见的会话信息
+type HookSession struct {
+	Token  string
+	Tenant string
+}
+
+// Interceptor 各回调在读循环中同步执行，不应阻塞；只关心部分回调时可以嵌入 BaseInterceptor。
+// OnClientMessage、OnAgentMessage 返回要继续转发的数据：返回 nil 表示静默丢弃，返回错误表示拒绝
+type Interceptor interface {
+	OnSessionStart(s HookSession)
+	OnSessionEnd(s HookSession)
+	OnClientMessage(s HookSession, msg WebSocketMessage, data []byte) ([]byte, error)
+	OnAgentMessage(s HookSession, data []byte) ([]byte, error)
+}
+
+// BaseInterceptor 全部回调都不做处理
+type BaseInterceptor struct{}
+
+func (BaseInterceptor) OnSessionStart(HookSession) {}
+func (BaseInterceptor) OnSessionEnd(HookSession)   {}
+func (BaseInterceptor) OnClientMessage(_ HookSession, _ WebSocketMessage, data []byte) ([]byte, error) {
+	return data, nil
+}
+func (BaseInterceptor) OnAgentMessage(_ HookSession, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+var (
+	interceptorsMu sync.RWMutex
+	interceptors   []Interceptor
+)
+
+// RegisterInterceptor 追加一个拦截器，应在服务启动前调用
+func RegisterInterceptor(i Interceptor) {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	interceptors = append(interceptors, i)
+}
+
+// truncateInterceptors 只保留前 n 个拦截器
+func truncateInterceptors(n int) {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	if n < len(interceptors) {
+		interceptors = interceptors[:n:n]
+	}
+}
+
+func currentInterceptors() []Interceptor {
+	interceptorsMu.RLock()
+	defer interceptorsMu.RUnlock()
+	return interceptors
+}
+
+func (s *RelaySession) hookSession() HookSession {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return HookSession{Token: s.token, Tenant: s.tenant}
+}
+
+// hookStart 会话开始中继时调用，只有调用过的会话在清理时才会触发 OnSessionEnd
+func (s *RelaySession) hookStart() {
+	s.hooked.Store(true)
+	for _, i := range currentInterceptors() {
+		i.OnSessionStart(s.hookSession())
+	}
+}
+
+func (s *RelaySession) hookEnd() {
+	if !s.hooked.Load() {
+		return
+	}
+	for _, i := range currentInterceptors() {
+		i.OnSessionEnd(s.hookSession())
+	}
+}
+
+// hookClientMessage 依次执行 OnClientMessage；数据被改写时重新解析 msg，ok 为 false 表示不再处理该消息
+func (s *RelaySession) hookClientMessage(client *wsClientConn, msg WebSocketMessage, data []byte) (WebSocketMessage, []byte, bool) {
+	chain := currentInterceptors()
+	if len(chain) == 0 {
+		return msg, data, true
+	}
+	hs := s.hookSession()
+	out := data
+	for _, i := range chain {
+		var err error
+		out, err = i.OnClientMessage(hs, msg, out)
+		if err != nil {
+			hubMetrics.Inc("hub_hook_rejected_total", "direction", "client_to_agent")
+			s.auditRequest(client, msg, len(data), "", AuditRejected, err)
+			s.notifyClient(client, WebSocketMessage{
+				Type:      MessageTypeNotify,
+				RequestID: msg.RequestID,
+				Action:    "message_rejected",
+				Data:      err.Error(),
+				Error:     &MessageError{Code: ErrCodeRejected, Reason: err.Error()},
+			})
+			return msg, nil, false
+		}
+		if out == nil {
+			hubMetrics.Inc("hub_hook_dropped_total", "direction", "client_to_agent")
+			return msg, nil, false
+		}
+	}
+	if !bytes.Equal(out, data) {
+		var rewritten WebSocketMessage
+		if err := json.Unmarshal(out, &rewritten); err != nil {
+			log.Println("Hook rewrote client message into invalid JSON:", err)
+			return msg, nil, false
+		}
+		msg = rewritten
+	}
+	return msg, out, true
+}
+
+// hookAgentMessage 依次执行 OnAgentMessage，ok 为 false 表示丢弃该消息
+func (s *RelaySession) hookAgentMessage(data []byte) ([]byte, bool) {
+	chain := currentInterceptors()
+	if len(chain) == 0 {
+		return data, true
+	}
+	hs := s.hookSession()
+	for _, i := range chain {
+		var err error
+		data, err = i.OnAgentMessage(hs, data)
+		if err != nil {
+			log.Printf("Session %s agent message rejected by hook: %v", s.token, err)
+			hubMetrics.Inc("hub_hook_rejected_total", "direction", "agent_to_client")
+			return nil, false
+		}
+		if data == nil {
+			hubMetrics.Inc("hub_hook_dropped_total", "direction", "agent_to_client")
+			return nil, false
+		}
+	}
+	return data, true
+}
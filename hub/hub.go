@@ -0,0 +1,161 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 嵌入 API：其它服务通过 NewHub 创建中继，把 HandleClient、HandleAgent 挂到自己的路由上，
+// 或者用 Register 注册独立运行时的全部路由。hub 的状态（会话、配置、指标）是包级的，
+// 同一时间只能有一个 Hub
+// -----------------------
+
+// Option 配置 NewHub
+type Option func(*options)
+
+type options struct {
+	config       *Config
+	auth         AuthProvider
+	resolver     AgentResolver
+	interceptors []Interceptor
+	clock        Clock
+	background   bool
+}
+
+// WithConfig 使用 cfg 代替 DefaultConfig，cfg 通常来自 LoadConfig
+func WithConfig(cfg *Config) Option {
+	return func(o *options) { o.config = cfg }
+}
+
+// WithAuthProvider 替换按配置选出的前端鉴权方式
+func WithAuthProvider(p AuthProvider) Option {
+	return func(o *options) { o.auth = p }
+}
+
+// WithAgentResolver 替换 agentResolver 配置的 agent 地址来源，仍会先查主机清单
+func WithAgentResolver(r AgentResolver) Option {
+	return func(o *options) { o.resolver = r }
+}
+
+// WithInterceptor 追加一个拦截器，等同于在 NewHub 之前调用 RegisterInterceptor
+func WithInterceptor(i Interceptor) Option {
+	return func(o *options) { o.interceptors = append(o.interceptors, i) }
+}
+
+// WithClock 替换重连逻辑使用的时钟，用于测试
+func WithClock(c Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithoutBackground 不启动会话清理、指标历史、报告和告警等后台任务，用于测试或由调用方自行清理
+func WithoutBackground() Option {
+	return func(o *options) { o.background = false }
+}
+
+// Hub 嵌入到其它服务中的中继
+type Hub struct {
+	closed atomic.Bool
+	// NewHub 之前已注册的拦截器数，Close 时去掉本 Hub 注册的拦截器
+	interceptors int
+}
+
+var hubCreated atomic.Bool
+
+var ErrHubExists = errors.New("hub: only one Hub can be open at a time")
+
+// NewHub 校验配置并初始化各个组件，失败时已打开的资源会被关闭
+func NewHub(opts ...Option) (*Hub, error) {
+	o := options{background: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !hubCreated.CompareAndSwap(false, true) {
+		return nil, ErrHubExists
+	}
+	h := &Hub{interceptors: len(currentInterceptors())}
+	if o.config != nil {
+		hubConfig = o.config
+	}
+	hubClock = realClock{}
+	if o.clock != nil {
+		hubClock = o.clock
+	}
+	if err := configure(); err != nil {
+		h.Close()
+		return nil, err
+	}
+	if o.auth != nil {
+		authProvider = o.auth
+	}
+	if o.resolver != nil {
+		agentResolver = &InventoryResolver{Next: o.resolver}
+	}
+	for _, i := range o.interceptors {
+		RegisterInterceptor(i)
+	}
+	if o.background {
+		if err := startBackground(); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// HandleClient 前端 WebSocket 入口，token 等参数与独立运行时相同
+func (h *Hub) HandleClient(c echo.Context) error {
+	return HandleConnection(c)
+}
+
+// HandleAgent agent 主动连入的 WebSocket 入口
+func (h *Hub) HandleAgent(c echo.Context) error {
+	return HandleAgentConnection(c)
+}
+
+// Register 注册独立运行时的全部路由，前端入口 /ws、/term、/file/download 按 Config.Routes 开关
+func (h *Hub) Register(e *echo.Echo) {
+	registerRoutes(e)
+}
+
+// Sessions 返回当前所有会话的概要
+func (h *Hub) Sessions() []SessionInfo {
+	sessions := relayHub.listSessions()
+	out := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		out = append(out, sess.info())
+	}
+	return out
+}
+
+// Drain 通知前端并等待会话结束，最长 timeout，用于调用方关闭 HTTP 服务之前
+func (h *Hub) Drain(ctx context.Context, timeout time.Duration) {
+	relayHub.drain(ctx, timeout)
+}
+
+// CloseSessions 立即关闭全部会话并等待会话的读写循环退出，不等待进行中的请求，hub 仍可接受新连接；
+// 用于测试之间清理状态
+func (h *Hub) CloseSessions() {
+	sessions := relayHub.listSessions()
+	for _, sess := range sessions {
+		sess.setEndReason(EndReasonShutdown)
+		sess.cleanup()
+	}
+	for _, sess := range sessions {
+		sess.loops.Wait()
+	}
+}
+
+// Close 停止后台任务和 Redis 订阅，关闭 NewHub 打开的文件和连接，不会断开仍在进行的会话；关闭后可以重新 NewHub
+func (h *Hub) Close() error {
+	if h.closed.CompareAndSwap(false, true) {
+		closeServices()
+		truncateInterceptors(h.interceptors)
+		hubCreated.Store(false)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package hubtest
+
+// -----------------------
+// 测试工具：在进程内启动 hub、模拟 agent 和前端，三者通过 httptest 上的真实 WebSocket 连接，
+// 提供断言转发帧、模拟 agent 断开或拒绝连接、推进重连退避时钟的辅助方法。
+//
+//	h := hubtest.New(t, nil)
+//	c := h.Dial("token-1")
+//	c.Request("r1", "ls", nil)
+//	req := h.Agent.Expect()
+//	h.Agent.Drop()
+//	h.Clock.WaitForWaiters(1, time.Second)
+//	h.Clock.AdvanceNext()
+//	h.Agent.WaitConnected()
+//	c.ExpectAction("reconnect_success")
+//
+// hub 的状态是包级的，使用 Harness 的测试不能并行，不同测试应使用不同的 token
+// -----------------------
+
+import (
+	"echo_demo/hub"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// Timeout 等待帧和连接的默认时长（真实时间）
+var Timeout = 2 * time.Second
+
+// Harness 一个进程内的 hub 以及它使用的模拟 agent 和时钟
+type Harness struct {
+	t      testing.TB
+	Hub    *hub.Hub
+	Config *hub.Config
+	Clock  *FakeClock
+	Agent  *Agent
+	server *httptest.Server
+}
+
+// New 启动 hub，全部 token 解析到 h.Agent；重连策略默认去掉抖动，configure 可以在启动前修改配置。
+// 测试结束时关闭剩余的会话和 hub，状态不会带到下一个测试
+func New(t testing.TB, configure func(*hub.Config)) *Harness {
+	t.Helper()
+	agent := NewAgent(t)
+	cfg := hub.DefaultConfig()
+	cfg.AgentResolver = hub.AgentResolverConfig{Type: "static", Default: &hub.AgentEndpoint{URL: agent.URL}}
+	cfg.Cleanup.Mode = hub.CleanupOff
+	cfg.ReconnectPolicy.Jitter = 0
+	if configure != nil {
+		configure(cfg)
+	}
+	clock := NewFakeClock(time.Now())
+	h, err := hub.NewHub(hub.WithConfig(cfg), hub.WithClock(clock), hub.WithoutBackground())
+	if err != nil {
+		agent.Close()
+		t.Fatalf("hubtest: new hub: %v", err)
+	}
+	e := echo.New()
+	e.GET("/ws", h.HandleClient)
+	e.GET("/agent/ws", h.HandleAgent)
+	srv := httptest.NewServer(e)
+	t.Cleanup(func() {
+		h.CloseSessions()
+		srv.Close()
+		agent.Close()
+		h.Close()
+	})
+	return &Harness{t: t, Hub: h, Config: cfg, Clock: clock, Agent: agent, server: srv}
+}
+
+// URL 前端连接的地址
+func (h *Harness) URL() string {
+	return "ws" + strings.TrimPrefix(h.server.URL, "http") + "/ws"
+}
+
+// Dial 以 token 连接一个前端，测试结束时自动关闭
+func (h *Harness) Dial(token string) *Client {
+	h.t.Helper()
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, resp, err := websocket.DefaultDialer.Dial(h.URL(), header)
+	if err != nil {
+		status := ""
+		if resp != nil {
+			status = resp.Status
+		}
+		h.t.Fatalf("hubtest: dial %s: %v %s", token, err, status)
+	}
+	c := newPeer(h.t, conn)
+	h.t.Cleanup(c.Close)
+	return &Client{peer: c}
+}
+
+// -----------------------
+// 连接的一端：后台读取帧，按 JSON 解析后放入队列
+// -----------------------
+
+type peer struct {
+	t      testing.TB
+	conn   *websocket.Conn
+	frames chan []byte
+	wmu    sync.Mutex
+	once   sync.Once
+}
+
+func newPeer(t testing.TB, conn *websocket.Conn) *peer {
+	p := &peer{t: t, conn: conn, frames: make(chan []byte, 1024)}
+	go func() {
+		defer close(p.frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			p.frames <- data
+		}
+	}()
+	return p
+}
+
+func (p *peer) send(msg hub.WebSocketMessage) {
+	p.t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		p.t.Fatalf("hubtest: marshal: %v", err)
+	}
+	p.sendRaw(data)
+}
+
+func (p *peer) sendRaw(data []byte) {
+	p.t.Helper()
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if err := p.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		p.t.Fatalf("hubtest: write: %v", err)
+	}
+}
+
+// next 返回下一条 JSON 帧，跳过 ping/pong 文本心跳；超时或连接关闭时 ok 为 false
+func (p *peer) next(timeout time.Duration) (hub.WebSocketMessage, []byte, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case data, open := <-p.frames:
+			if !open {
+				return hub.WebSocketMessage{}, nil, false
+			}
+			var msg hub.WebSocketMessage
+			if json.Unmarshal(data, &msg) != nil {
+				continue
+			}
+			return msg, data, true
+		case <-timer.C:
+			return hub.WebSocketMessage{}, nil, false
+		}
+	}
+}
+
+func (p *peer) expect(who string) hub.WebSocketMessage {
+	p.t.Helper()
+	msg, _, ok := p.next(Timeout)
+	if !ok {
+		p.t.Fatalf("hubtest: %s received no frame within %v", who, Timeout)
+	}
+	return msg
+}
+
+func (p *peer) expectAction(who, action string) hub.WebSocketMessage {
+	p.t.Helper()
+	deadline := time.Now().Add(Timeout)
+	var seen []string
+	for {
+		msg, _, ok := p.next(time.Until(deadline))
+		if !ok {
+			p.t.Fatalf("hubtest: %s received no %q within %v, saw %v", who, action, Timeout, seen)
+		}
+		if msg.Action == action {
+			return msg
+		}
+		seen = append(seen, msg.Type+"/"+msg.Action)
+	}
+}
+
+func (p *peer) expectNone(who string, d time.Duration) {
+	p.t.Helper()
+	if msg, data, ok := p.next(d); ok {
+		p.t.Fatalf("hubtest: %s received unexpected frame %s (%s)", who, msg.Action, data)
+	}
+}
+
+func (p *peer) Close() {
+	p.once.Do(func() { p.conn.Close() })
+}
+
+// -----------------------
+// 前端
+// -----------------------
+
+// Client 模拟的前端连接
+type Client struct {
+	*peer
+}
+
+// Send 发送任意消息
+func (c *Client) Send(msg hub.WebSocketMessage) {
+	c.t.Helper()
+	c.send(msg)
+}
+
+// Request 发送一个 request
+func (c *Client) Request(requestID, action string, data interface{}) {
+	c.t.Helper()
+	c.send(hub.WebSocketMessage{Type: hub.MessageTypeRequest, RequestID: requestID, Action: action, Data: data})
+}
+
+// Expect 返回 hub 发来的下一条消息，Timeout 内没有时测试失败
+func (c *Client) Expect() hub.WebSocketMessage {
+	c.t.Helper()
+	return c.expect("client")
+}
+
+// ExpectAction 跳过其它消息直到收到 action
+func (c *Client) ExpectAction(action string) hub.WebSocketMessage {
+	c.t.Helper()
+	return c.expectAction("client", action)
+}
+
+// ExpectNone d 内没有收到消息
+func (c *Client) ExpectNone(d time.Duration) {
+	c.t.Helper()
+	c.expectNone("client", d)
+}
+
+// -----------------------
+// 模拟 agent
+// -----------------------
+
+// Agent 接受 hub 拨号的模拟 agent，同一时间只保留最新的连接
+type Agent struct {
+	t      testing.TB
+	URL    string
+	server *httptest.Server
+
+	mu        sync.Mutex
+	current   *peer
+	accepted  int
+	refusing  bool
+	connected chan *peer
+}
+
+// NewAgent 启动模拟 agent，New 会自动创建一个，单独使用时需要调用 Close
+func NewAgent(t testing.TB) *Agent {
+	a := &Agent{t: t, connected: make(chan *peer, 16)}
+	upgrader := websocket.Upgrader{}
+	a.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		refusing := a.refusing
+		a.mu.Unlock()
+		if refusing {
+			http.Error(w, "agent unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		p := newPeer(t, conn)
+		a.mu.Lock()
+		a.current = p
+		a.accepted++
+		a.mu.Unlock()
+		a.connected <- p
+	}))
+	a.URL = "ws" + strings.TrimPrefix(a.server.URL, "http") + "/"
+	return a
+}
+
+func (a *Agent) conn() *peer {
+	a.t.Helper()
+	a.mu.Lock()
+	p := a.current
+	a.mu.Unlock()
+	if p == nil {
+		a.t.Fatalf("hubtest: agent is not connected")
+	}
+	return p
+}
+
+// WaitConnected 等待 hub 建立一个新连接（包括重连）
+func (a *Agent) WaitConnected() {
+	a.t.Helper()
+	select {
+	case <-a.connected:
+	case <-time.After(Timeout):
+		a.t.Fatalf("hubtest: hub did not connect to agent within %v", Timeout)
+	}
+}
+
+// Connections 返回累计接受的连接数
+func (a *Agent) Connections() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.accepted
+}
+
+// Expect 返回 hub 转发给 agent 的下一条消息
+func (a *Agent) Expect() hub.WebSocketMessage {
+	a.t.Helper()
+	return a.conn().expect("agent")
+}
+
+// ExpectAction 跳过其它消息直到收到 action
+func (a *Agent) ExpectAction(action string) hub.WebSocketMessage {
+	a.t.Helper()
+	return a.conn().expectAction("agent", action)
+}
+
+// ExpectNone d 内 agent 没有收到消息
+func (a *Agent) ExpectNone(d time.Duration) {
+	a.t.Helper()
+	a.conn().expectNone("agent", d)
+}
+
+// Send 向 hub 发送任意消息
+func (a *Agent) Send(msg hub.WebSocketMessage) {
+	a.t.Helper()
+	a.conn().send(msg)
+}
+
+// Respond 以相同的 r、a 回复请求
+func (a *Agent) Respond(req hub.WebSocketMessage, data interface{}) {
+	a.t.Helper()
+	a.conn().send(hub.WebSocketMessage{Type: hub.MessageTypeResponse, RequestID: req.RequestID, Action: req.Action, Data: data})
+}
+
+// Drop 断开当前连接，模拟 agent 掉线
+func (a *Agent) Drop() {
+	a.t.Helper()
+	a.conn().Close()
+}
+
+// Refuse 为 true 时拒绝新的连接，用于模拟重连失败直到次数用尽
+func (a *Agent) Refuse(refuse bool) {
+	a.mu.Lock()
+	a.refusing = refuse
+	a.mu.Unlock()
+}
+
+// Close 关闭 agent 服务和当前连接
+func (a *Agent) Close() {
+	a.mu.Lock()
+	p := a.current
+	a.mu.Unlock()
+	if p != nil {
+		p.Close()
+	}
+	a.server.CloseClientConnections()
+	a.server.Close()
+}
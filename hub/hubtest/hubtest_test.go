@@ -0,0 +1,95 @@
+package hubtest
+
+import (
+	"echo_demo/hub"
+	"echo_demo/jwtauth"
+	"echo_demo/users"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelayRoundTrip(t *testing.T) {
+	h := New(t, nil)
+	c := h.Dial("relay-round-trip")
+	h.Agent.WaitConnected()
+
+	c.Request("r1", "ls", map[string]string{"path": "/tmp"})
+	req := h.Agent.ExpectAction("ls")
+	if req.RequestID != "r1" || req.Type != hub.MessageTypeRequest {
+		t.Fatalf("agent got %+v", req)
+	}
+	h.Agent.Respond(req, []string{"a", "b"})
+
+	resp := c.ExpectAction("ls")
+	if resp.RequestID != "r1" || resp.Type != hub.MessageTypeResponse {
+		t.Fatalf("client got %+v", resp)
+	}
+}
+
+func TestAgentReconnectBackoff(t *testing.T) {
+	h := New(t, nil)
+	c := h.Dial("reconnect-backoff")
+	h.Agent.WaitConnected()
+
+	h.Agent.Drop()
+	if !h.Clock.WaitForWaiters(1, Timeout) {
+		t.Fatal("hub did not back off after the agent dropped")
+	}
+	if d := h.Clock.AdvanceNext(); d <= 0 {
+		t.Fatalf("backoff advanced %v", d)
+	}
+	h.Agent.WaitConnected()
+	c.ExpectAction("reconnect_success")
+	if n := h.Agent.Connections(); n != 2 {
+		t.Fatalf("agent accepted %d connections, want 2", n)
+	}
+
+	c.Request("r2", "ls", nil)
+	req := h.Agent.ExpectAction("ls")
+	h.Agent.Respond(req, "ok")
+	if resp := c.ExpectAction("ls"); resp.RequestID != "r2" {
+		t.Fatalf("client got %+v", resp)
+	}
+}
+
+// TestStepUpEncryptedMatchRule 带 Match 的规则遇到密文 d 时无法比较字段，仍要求二次验证
+func TestStepUpEncryptedMatchRule(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.json")
+	store, err := users.OpenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := users.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateUser(users.User{Username: "stepup-e2e", TOTPSecret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	jwtCfg := jwtauth.Config{HMACSecret: "hubtest-secret"}
+	token, err := jwtauth.Sign(jwtCfg, map[string]interface{}{"sub": "stepup-e2e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(t, func(cfg *hub.Config) {
+		cfg.JWT = jwtCfg
+		cfg.Users.Store = "file"
+		cfg.Users.File = file
+		cfg.StepUp.Rules = []hub.StepUpRule{{Actions: []string{"rm"}, Match: map[string]interface{}{"recursive": true}}}
+	})
+	c := h.Dial(token)
+	h.Agent.WaitConnected()
+
+	c.Send(hub.WebSocketMessage{Type: hub.MessageTypeRequest, RequestID: "r1", Action: "rm", Data: "Y2lwaGVydGV4dA", Enc: "k1"})
+	if got := c.ExpectAction(hub.ActionStepUpRequired); got.RequestID != "r1" {
+		t.Fatalf("client got %+v", got)
+	}
+	h.Agent.ExpectNone(200 * time.Millisecond)
+
+	// 明文且不满足 Match 时直接转发
+	c.Request("r2", "rm", map[string]interface{}{"recursive": false})
+	h.Agent.ExpectAction("rm")
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// -----------------------
+// 空闲超时：会话在 SessionIdleTimeout 内没有任何中继消息时通知前端并关闭；
+// hub 级的清扫器定期回收没有前端也没有 agent 的僵尸会话
+// -----------------------
+
+// touch 在中继一条消息后调用，刷新会话的最近活动时间
+func (s *RelaySession) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *RelaySession) lastActive() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
+}
+
+// startIdleTimer 在会话创建时调用。定时器到期时若期间有过活动则按剩余时间重新计时，
+// 避免每条消息都重置定时器
+func (s *RelaySession) startIdleTimer() {
+	timeout := hubConfig.SessionIdleTimeout.D()
+	if timeout <= 0 {
+		return
+	}
+	s.idleTimer = time.AfterFunc(timeout, func() {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if idle := time.Since(s.lastActive()); idle < timeout {
+			s.idleTimer.Reset(timeout - idle)
+			return
+		}
+		s.expireIdle(timeout)
+	})
+}
+
+// expireIdle 通知前端会话因空闲被关闭，留出时间写出通知后清理
+func (s *RelaySession) expireIdle(timeout time.Duration) {
+	hubMetrics.Inc("hub_session_evictions_total", "reason", "idle")
+	log.Printf("Session %s idle for %v, closing", s.token, timeout)
+	s.sendNotify(WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: "idle_timeout",
+		Data:   map[string]interface{}{"idleSeconds": int(timeout.Seconds())},
+	})
+	s.setEndReason(EndReasonIdle)
+	time.AfterFunc(time.Second, s.cleanup)
+}
+
+// isZombie 会话没有前端也没有 agent，且创建已超过 grace，说明建连失败或清理遗漏
+func (s *RelaySession) isZombie(grace time.Duration) bool {
+	if time.Since(s.createdAt) < grace {
+		return false
+	}
+	s.clientMu.Lock()
+	clients := len(s.clients)
+	s.clientMu.Unlock()
+	s.agentMu.Lock()
+	hasAgent := s.agent != nil
+	s.agentMu.Unlock()
+	s.stateMu.Lock()
+	reconnecting := s.agentReconnecting
+	s.stateMu.Unlock()
+	return clients == 0 && !hasAgent && !reconnecting
+}
+
+// sweep 定期回收僵尸会话，直到 hub 开始退出或 ctx 结束
+func (h *RelayHub) sweep(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if hubDraining.Load() {
+			return
+		}
+		for _, sess := range h.listSessions() {
+			if !sess.isZombie(interval) {
+				continue
+			}
+			hubMetrics.Inc("hub_session_evictions_total", "reason", "zombie")
+			log.Printf("Session %s has no connections, evicting", sess.token)
+			sess.setEndReason(EndReasonZombie)
+			sess.cleanup()
+		}
+	}
+}
@@ -0,0 +1,572 @@
+package hub
+
+import (
+	"context"
+	"crypto/subtle"
+	"echo_demo/sshutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 资产清单：受管主机、agent、分组和标签，持久化为 JSON 文件。
+// 终端、命令执行、文件传输按主机 ID 引用主机，批量操作可以按分组选取目标
+// -----------------------
+
+var (
+	errInventoryNotFound = errors.New("inventory item not found")
+	errInventoryConflict = errors.New("inventory item already exists")
+)
+
+// InventoryHost 一台可以通过 SSH 访问的主机
+type InventoryHost struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name,omitempty"`
+	SSH    sshutil.Profile   `json:"ssh"`
+	Groups []string          `json:"groups,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
+}
+
+// InventoryAgent 一个 agent，Token 为前端连接时使用的 token，Endpoint 为空表示反向注册；
+// 反向注册时 agent 以 Key 认证，Key 与前端凭据分开，查询接口中不返回
+type InventoryAgent struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	Token    string            `json:"token"`
+	Key      string            `json:"key,omitempty"`
+	Endpoint *AgentEndpoint    `json:"endpoint,omitempty"`
+	HostID   string            `json:"hostId,omitempty"` // agent 所在主机
+	Groups   []string          `json:"groups,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// InventoryGroup 主机和 agent 的分组
+type InventoryGroup struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+}
+
+type inventoryData struct {
+	Hosts  map[string]*InventoryHost  `json:"hosts"`
+	Agents map[string]*InventoryAgent `json:"agents"`
+	Groups map[string]*InventoryGroup `json:"groups"`
+}
+
+type inventory struct {
+	path string // 为空时只保存在内存中
+
+	mu   sync.RWMutex
+	data inventoryData
+}
+
+func newInventory() *inventory {
+	return &inventory{data: inventoryData{
+		Hosts:  make(map[string]*InventoryHost),
+		Agents: make(map[string]*InventoryAgent),
+		Groups: make(map[string]*InventoryGroup),
+	}}
+}
+
+// loadInventory 读取清单文件，文件不存在时返回空清单
+func loadInventory(path string) (*inventory, error) {
+	inv := newInventory()
+	inv.path = path
+	if path == "" {
+		return inv, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return inv, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &inv.data); err != nil {
+		return nil, err
+	}
+	if inv.data.Hosts == nil {
+		inv.data.Hosts = make(map[string]*InventoryHost)
+	}
+	if inv.data.Agents == nil {
+		inv.data.Agents = make(map[string]*InventoryAgent)
+	}
+	if inv.data.Groups == nil {
+		inv.data.Groups = make(map[string]*InventoryGroup)
+	}
+	return inv, nil
+}
+
+// saveLocked 先写临时文件再 rename，避免写到一半时进程退出导致清单损坏，调用方需持有写锁
+func (inv *inventory) saveLocked() error {
+	if inv.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(inv.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(inv.path), ".inventory-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), inv.path)
+}
+
+// host 按 ID 查找主机
+func (inv *inventory) host(id string) (InventoryHost, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	h, ok := inv.data.Hosts[id]
+	if !ok {
+		return InventoryHost{}, false
+	}
+	return *h, true
+}
+
+// hostsInGroup 返回分组内全部主机的 ID，按 ID 排序
+func (inv *inventory) hostsInGroup(group string) []string {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	var ids []string
+	for id, h := range inv.data.Hosts {
+		if containsString(h.Groups, group) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// agentByToken 按 token 查找 agent
+func (inv *inventory) agentByToken(token string) (InventoryAgent, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	for _, a := range inv.data.Agents {
+		if a.Token == token {
+			return *a, true
+		}
+	}
+	return InventoryAgent{}, false
+}
+
+// agentByKey 按反向注册密钥查找 agent
+func (inv *inventory) agentByKey(key string) (InventoryAgent, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	for _, a := range inv.data.Agents {
+		if a.Key != "" && subtle.ConstantTimeCompare([]byte(a.Key), []byte(key)) == 1 {
+			return *a, true
+		}
+	}
+	return InventoryAgent{}, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFilter 按 group 和 tag（形如 key=value）过滤
+func matchFilter(groups []string, tags map[string]string, group, tag string) bool {
+	if group != "" && !containsString(groups, group) {
+		return false
+	}
+	if tag != "" {
+		k, v, _ := strings.Cut(tag, "=")
+		if got, ok := tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGroupsLocked 引用的分组必须已存在，调用方需持有锁
+func (inv *inventory) checkGroupsLocked(groups []string) error {
+	for _, g := range groups {
+		if _, ok := inv.data.Groups[g]; !ok {
+			return fmt.Errorf("unknown group %q", g)
+		}
+	}
+	return nil
+}
+
+var hubInventory = newInventory()
+
+// lookupSSHProfile 依次从清单主机和配置的 SSHProfiles 中查找
+func lookupSSHProfile(name string) (sshutil.Profile, bool) {
+	if h, ok := hubInventory.host(name); ok {
+		return h.SSH, true
+	}
+	p, ok := hubConfig.SSHProfiles[name]
+	return p, ok
+}
+
+// sshCredentials 供 sshutil.Credentials 使用，调用方在 SSHCredentials 中有该主机的凭据时替换主机的账号和认证方式
+func sshCredentials(r *http.Request, name string) (sshutil.Profile, bool) {
+	p, ok := lookupSSHProfile(name)
+	if !ok || len(hubConfig.SSHCredentials) == 0 {
+		return p, ok
+	}
+	ident, err := authProvider.Authenticate(r)
+	if err != nil {
+		return p, ok
+	}
+	cred, found := hubConfig.SSHCredentials[ident.Subject][name]
+	if !found {
+		return p, ok
+	}
+	if cred.Addr == "" {
+		cred.Addr = p.Addr
+	}
+	if cred.KnownHostsFile == "" && len(cred.HostKeyFingerprints) == 0 {
+		cred.KnownHostsFile, cred.HostKeyFingerprints = p.KnownHostsFile, p.HostKeyFingerprints
+	}
+	return cred, true
+}
+
+// InventoryResolver 先按清单中登记的 agent 解析，没有登记时交给 Next
+type InventoryResolver struct {
+	Next AgentResolver
+}
+
+func (r *InventoryResolver) Resolve(ctx context.Context, token string) (*AgentEndpoint, error) {
+	if a, ok := hubInventory.agentByToken(token); ok && a.Endpoint != nil {
+		ep := *a.Endpoint
+		return &ep, nil
+	}
+	return r.Next.Resolve(ctx, token)
+}
+
+func inventoryError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errInventoryNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errInventoryConflict):
+		status = http.StatusConflict
+	}
+	return c.JSON(status, map[string]string{"error": err.Error()})
+}
+
+// -----------------------
+// 主机
+// -----------------------
+
+// ListHostsHandler 列出主机，可按 group、tag（key=value）过滤；不返回 SSH 凭证
+func ListHostsHandler(c echo.Context) error {
+	group, tag := c.QueryParam("group"), c.QueryParam("tag")
+	hubInventory.mu.RLock()
+	hosts := []InventoryHost{}
+	for _, h := range hubInventory.data.Hosts {
+		if matchFilter(h.Groups, h.Tags, group, tag) {
+			hosts = append(hosts, redactHost(*h))
+		}
+	}
+	hubInventory.mu.RUnlock()
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
+	return c.JSON(http.StatusOK, hosts)
+}
+
+func redactHost(h InventoryHost) InventoryHost {
+	for _, secret := range []*string{&h.SSH.Password, &h.SSH.PrivateKey, &h.SSH.Passphrase} {
+		if *secret != "" {
+			*secret = "******"
+		}
+	}
+	return h
+}
+
+// GetHostHandler 查询单台主机
+func GetHostHandler(c echo.Context) error {
+	h, ok := hubInventory.host(c.Param("id"))
+	if !ok {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	return c.JSON(http.StatusOK, redactHost(h))
+}
+
+// PutHostHandler 创建或替换主机，POST 时 ID 取请求体，PUT 时取路径参数
+func PutHostHandler(c echo.Context) error {
+	var h InventoryHost
+	if err := c.Bind(&h); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	create := c.Request().Method == http.MethodPost
+	if !create {
+		h.ID = c.Param("id")
+	}
+	if h.ID == "" || h.SSH.Addr == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "id and ssh.addr are required"})
+	}
+
+	hubInventory.mu.Lock()
+	defer hubInventory.mu.Unlock()
+	old, exists := hubInventory.data.Hosts[h.ID]
+	if create && exists {
+		return inventoryError(c, errInventoryConflict)
+	}
+	if !create && !exists {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	if err := hubInventory.checkGroupsLocked(h.Groups); err != nil {
+		return inventoryError(c, err)
+	}
+	// 未传密码、私钥时保留原值，便于只修改分组和标签
+	if exists && h.SSH.Password == "" {
+		h.SSH.Password = old.SSH.Password
+	}
+	if exists && h.SSH.PrivateKey == "" && h.SSH.PrivateKeyFile == "" {
+		h.SSH.PrivateKey, h.SSH.Passphrase = old.SSH.PrivateKey, old.SSH.Passphrase
+	}
+	hubInventory.data.Hosts[h.ID] = &h
+	if err := hubInventory.saveLocked(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	sshPool.Forget(h.ID)
+	status := http.StatusOK
+	if create {
+		status = http.StatusCreated
+	}
+	return c.JSON(status, redactHost(h))
+}
+
+// DeleteHostHandler 删除主机
+func DeleteHostHandler(c echo.Context) error {
+	id := c.Param("id")
+	hubInventory.mu.Lock()
+	defer hubInventory.mu.Unlock()
+	if _, ok := hubInventory.data.Hosts[id]; !ok {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	delete(hubInventory.data.Hosts, id)
+	if err := hubInventory.saveLocked(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	sshPool.Forget(id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// -----------------------
+// agent
+// -----------------------
+
+// ListAgentsHandler 列出 agent，可按 group、tag 过滤，并标出当前是否在线
+func ListAgentsHandler(c echo.Context) error {
+	group, tag := c.QueryParam("group"), c.QueryParam("tag")
+	type agentView struct {
+		InventoryAgent
+		Online bool `json:"online"`
+	}
+	hubInventory.mu.RLock()
+	agents := []agentView{}
+	for _, a := range hubInventory.data.Agents {
+		if matchFilter(a.Groups, a.Tags, group, tag) {
+			agents = append(agents, agentView{InventoryAgent: redactAgent(*a)})
+		}
+	}
+	hubInventory.mu.RUnlock()
+	for i := range agents {
+		agents[i].Online = relayHub.agentOnline(agents[i].Token)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return c.JSON(http.StatusOK, agents)
+}
+
+// GetAgentHandler 查询单个 agent
+func GetAgentHandler(c echo.Context) error {
+	hubInventory.mu.RLock()
+	a, ok := hubInventory.data.Agents[c.Param("id")]
+	hubInventory.mu.RUnlock()
+	if !ok {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	return c.JSON(http.StatusOK, redactAgent(*a))
+}
+
+func redactAgent(a InventoryAgent) InventoryAgent {
+	if a.Key != "" {
+		a.Key = "******"
+	}
+	return a
+}
+
+// PutAgentHandler 创建或替换 agent，POST 时 ID 取请求体，PUT 时取路径参数
+func PutAgentHandler(c echo.Context) error {
+	var a InventoryAgent
+	if err := c.Bind(&a); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	create := c.Request().Method == http.MethodPost
+	if !create {
+		a.ID = c.Param("id")
+	}
+	if a.ID == "" || a.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "id and token are required"})
+	}
+
+	hubInventory.mu.Lock()
+	defer hubInventory.mu.Unlock()
+	old, exists := hubInventory.data.Agents[a.ID]
+	if create && exists {
+		return inventoryError(c, errInventoryConflict)
+	}
+	if !create && !exists {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	if err := hubInventory.checkGroupsLocked(a.Groups); err != nil {
+		return inventoryError(c, err)
+	}
+	if a.HostID != "" {
+		if _, ok := hubInventory.data.Hosts[a.HostID]; !ok {
+			return inventoryError(c, fmt.Errorf("unknown host %q", a.HostID))
+		}
+	}
+	// 未传密钥时保留原密钥
+	if exists && a.Key == "" {
+		a.Key = old.Key
+	}
+	for id, other := range hubInventory.data.Agents {
+		if id != a.ID && other.Token == a.Token {
+			return inventoryError(c, fmt.Errorf("%w: token used by agent %q", errInventoryConflict, id))
+		}
+		if id != a.ID && a.Key != "" && other.Key == a.Key {
+			return inventoryError(c, fmt.Errorf("%w: key used by agent %q", errInventoryConflict, id))
+		}
+	}
+	hubInventory.data.Agents[a.ID] = &a
+	if err := hubInventory.saveLocked(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	status := http.StatusOK
+	if create {
+		status = http.StatusCreated
+	}
+	return c.JSON(status, redactAgent(a))
+}
+
+// DeleteAgentHandler 删除 agent
+func DeleteAgentHandler(c echo.Context) error {
+	id := c.Param("id")
+	hubInventory.mu.Lock()
+	defer hubInventory.mu.Unlock()
+	if _, ok := hubInventory.data.Agents[id]; !ok {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	delete(hubInventory.data.Agents, id)
+	if err := hubInventory.saveLocked(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// -----------------------
+// 分组
+// -----------------------
+
+// ListGroupsHandler 列出分组及其成员数
+func ListGroupsHandler(c echo.Context) error {
+	type groupView struct {
+		InventoryGroup
+		Hosts  int `json:"hosts"`
+		Agents int `json:"agents"`
+	}
+	hubInventory.mu.RLock()
+	groups := []groupView{}
+	for _, g := range hubInventory.data.Groups {
+		v := groupView{InventoryGroup: *g}
+		for _, h := range hubInventory.data.Hosts {
+			if containsString(h.Groups, g.ID) {
+				v.Hosts++
+			}
+		}
+		for _, a := range hubInventory.data.Agents {
+			if containsString(a.Groups, g.ID) {
+				v.Agents++
+			}
+		}
+		groups = append(groups, v)
+	}
+	hubInventory.mu.RUnlock()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return c.JSON(http.StatusOK, groups)
+}
+
+// PutGroupHandler 创建或修改分组，ID 取路径参数
+func PutGroupHandler(c echo.Context) error {
+	var g InventoryGroup
+	if err := c.Bind(&g); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	g.ID = c.Param("id")
+	hubInventory.mu.Lock()
+	defer hubInventory.mu.Unlock()
+	hubInventory.data.Groups[g.ID] = &g
+	if err := hubInventory.saveLocked(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, g)
+}
+
+// DeleteGroupHandler 删除分组，仍有主机或 agent 引用时拒绝
+func DeleteGroupHandler(c echo.Context) error {
+	id := c.Param("id")
+	hubInventory.mu.Lock()
+	defer hubInventory.mu.Unlock()
+	if _, ok := hubInventory.data.Groups[id]; !ok {
+		return inventoryError(c, errInventoryNotFound)
+	}
+	for _, h := range hubInventory.data.Hosts {
+		if containsString(h.Groups, id) {
+			return inventoryError(c, fmt.Errorf("%w: group %q is used by host %q", errInventoryConflict, id, h.ID))
+		}
+	}
+	for _, a := range hubInventory.data.Agents {
+		if containsString(a.Groups, id) {
+			return inventoryError(c, fmt.Errorf("%w: group %q is used by agent %q", errInventoryConflict, id, a.ID))
+		}
+	}
+	delete(hubInventory.data.Groups, id)
+	if err := hubInventory.saveLocked(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// agentOnline 判断 token 对应的 agent 当前是否连接在本节点（会话中或等待配对）
+func (h *RelayHub) agentOnline(token string) bool {
+	h.mu.Lock()
+	_, pending := h.agents[token]
+	sess := h.sessions[token]
+	h.mu.Unlock()
+	if pending {
+		return true
+	}
+	if sess == nil {
+		return false
+	}
+	sess.agentMu.Lock()
+	defer sess.agentMu.Unlock()
+	return sess.agent != nil
+}
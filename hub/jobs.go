@@ -0,0 +1,599 @@
+package hub
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"echo_demo/jobs"
+	"echo_demo/sshutil"
+	"echo_demo/upload2"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/sftp"
+)
+
+// -----------------------
+// 文件任务：分片合并、清单生成、复制到其它主机、解压作为任务在 hubJobs 中执行，
+// 接口提交后返回 202 和任务信息。提交时带上会话 token（owner）的任务，
+// 状态和进度通过该会话以 job_update 通知发给前端。同一 hash 的合并任务尚未结束时再次提交
+// （比如多个浏览器标签页）不会新建任务，提交者加入已有任务的 watchers，收到相同的进度和结果
+// -----------------------
+
+// 任务类型
+const (
+	JobMerge     = "merge"     // 参数为 upload2.MergeChunksDto
+	JobManifest  = "manifest"  // 参数为 upload2.Manifest
+	JobReplicate = "replicate" // 参数为 ReplicateParams
+	JobExtract   = "extract"   // 参数为 ExtractParams
+)
+
+// ActionJobUpdate hub -> 前端，d 为 jobs.Job
+const ActionJobUpdate = "job_update"
+
+// CapJobs 通过 /api/jobs 提交合并、清单和解压任务，这些任务在 hub 本地写文件
+const CapJobs = "jobs"
+
+var errOutsideJobRoots = errors.New("path is outside the job roots")
+
+// hubJobs 为 nil 表示未启用任务队列
+var hubJobs *jobs.Queue
+
+// ReplicateParams 通过 SFTP 把 hub 上的文件复制到多个主机，Hosts 为 sshProfiles 中的名称或清单中的主机 ID
+type ReplicateParams struct {
+	Path       string   `json:"path"`
+	Hosts      []string `json:"hosts"`
+	RemotePath string   `json:"remotePath"`
+}
+
+// ExtractParams 解压 hub 上的 tar、tar.gz 或 zip 文件到 Dest 目录
+type ExtractParams struct {
+	Archive string `json:"archive"`
+	Dest    string `json:"dest"`
+}
+
+func newJobQueue(cfg jobs.Config) (*jobs.Queue, error) {
+	q, err := jobs.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	q.Register(JobMerge, mergeJob)
+	q.Register(JobManifest, manifestJob)
+	q.Register(JobReplicate, replicateJob)
+	q.Register(JobExtract, extractJob)
+	q.OnUpdate = onJobUpdate
+	return q, nil
+}
+
+// onJobUpdate 统计结束的任务，并把状态通知给发起任务和合并到该任务的会话
+func onJobUpdate(job jobs.Job) {
+	if job.State.Finished() {
+		hubMetrics.Inc("hub_jobs_total", "kind", job.Kind, "state", string(job.State))
+	}
+	for _, token := range append([]string{job.Owner}, job.Watchers...) {
+		if token == "" {
+			continue
+		}
+		if s := relayHub.findSession(token); s != nil {
+			s.sendNotify(WebSocketMessage{Type: MessageTypeNotify, Action: ActionJobUpdate, Data: job})
+		}
+	}
+}
+
+// decodeJobParams 参数错误重试也不会成功
+func decodeJobParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return jobs.Permanent(err)
+	}
+	return nil
+}
+
+// bytesReporter 把字节进度转换为任务进度
+func bytesReporter(report func(jobs.Progress), message string) func(done, total int64) {
+	return func(done, total int64) {
+		report(jobs.Progress{Done: done, Total: total, Message: message})
+	}
+}
+
+// mergeJob 和 manifestJob 在执行时检查最终文件的路径，不论任务从哪个接口提交
+func mergeJob(ctx context.Context, params json.RawMessage, report func(jobs.Progress)) (interface{}, error) {
+	var dto upload2.MergeChunksDto
+	if err := decodeJobParams(params, &dto); err != nil {
+		return nil, err
+	}
+	if err := checkJobPath(path.Join(dto.UploadPath, dto.Name)); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	return upload2.MergeChunks(ctx, dto, bytesReporter(report, dto.Name))
+}
+
+func manifestJob(ctx context.Context, params json.RawMessage, report func(jobs.Progress)) (interface{}, error) {
+	var m upload2.Manifest
+	if err := decodeJobParams(params, &m); err != nil {
+		return nil, err
+	}
+	if err := checkJobPath(path.Join(m.UploadPath, m.Name)); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	return upload2.CommitManifest(ctx, m, bytesReporter(report, m.Name))
+}
+
+// replicateJob 依次复制到每个主机，进度为全部主机累计的字节数；已完成的主机在重试时会重新复制
+func replicateJob(ctx context.Context, params json.RawMessage, report func(jobs.Progress)) (interface{}, error) {
+	var p ReplicateParams
+	if err := decodeJobParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := checkJobPath(p.Path); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	st, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	remotePath := p.RemotePath
+	if strings.HasSuffix(remotePath, "/") {
+		remotePath = path.Join(remotePath, filepath.Base(p.Path))
+	}
+	total := st.Size() * int64(len(p.Hosts))
+	for i, host := range p.Hosts {
+		offset := st.Size() * int64(i)
+		err := replicateTo(ctx, host, p.Path, remotePath, func(done, _ int64) {
+			report(jobs.Progress{Done: offset + done, Total: total, Message: host})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return map[string]interface{}{"hosts": p.Hosts, "remotePath": remotePath, "size": st.Size()}, nil
+}
+
+func replicateTo(ctx context.Context, host, localPath, remotePath string, progress func(done, total int64)) error {
+	client, err := sshPool.Get(ctx, host)
+	if err != nil {
+		if errors.Is(err, sshutil.ErrUnknownProfile) {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		sshPool.Invalidate(host, client)
+		return err
+	}
+	defer sc.Close()
+	stop := sshutil.CloseOnDone(ctx, sc)
+	defer stop()
+
+	in, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// 先写临时文件，复制完成后再替换，避免远端看到不完整的文件
+	tmp := remotePath + ".part"
+	out, err := sc.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var done int64
+	_, err = io.Copy(out, io.TeeReader(in, progressFunc(func(n int) {
+		done += int64(n)
+		progress(done, st.Size())
+	})))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = sc.PosixRename(tmp, remotePath)
+	}
+	if err != nil {
+		_ = sc.Remove(tmp)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// progressFunc 作为 TeeReader 的 Writer 统计读取的字节数
+type progressFunc func(n int)
+
+func (f progressFunc) Write(p []byte) (int, error) {
+	f(len(p))
+	return len(p), nil
+}
+
+// extractJob 进度为已读取的压缩文件字节数
+func extractJob(ctx context.Context, params json.RawMessage, report func(jobs.Progress)) (interface{}, error) {
+	var p ExtractParams
+	if err := decodeJobParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Archive == "" || p.Dest == "" {
+		return nil, jobs.Permanent(errors.New("archive and dest are required"))
+	}
+	for _, local := range []string{p.Archive, p.Dest} {
+		if err := checkJobPath(local); err != nil {
+			return nil, jobs.Permanent(err)
+		}
+	}
+	f, err := os.Open(p.Archive)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var read int64
+	counted := io.TeeReader(f, progressFunc(func(n int) {
+		read += int64(n)
+		report(jobs.Progress{Done: read, Total: st.Size(), Message: p.Archive})
+	}))
+
+	var files int
+	name := strings.ToLower(p.Archive)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		files, err = extractZip(ctx, f, st.Size(), p.Dest, report)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, gerr := gzip.NewReader(counted)
+		if gerr != nil {
+			return nil, jobs.Permanent(gerr)
+		}
+		files, err = extractTar(ctx, gz, p.Dest)
+	case strings.HasSuffix(name, ".tar"):
+		files, err = extractTar(ctx, counted, p.Dest)
+	default:
+		return nil, jobs.Permanent(fmt.Errorf("unsupported archive %s", p.Archive))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"dest": p.Dest, "files": files}, nil
+}
+
+// extractPath 返回条目在 dest 下的路径，拒绝绝对路径和 .. 跳出 dest 的条目
+func extractPath(dest, name string) (string, error) {
+	target := filepath.Join(dest, name)
+	if target != filepath.Clean(dest) && !strings.HasPrefix(target, filepath.Clean(dest)+string(filepath.Separator)) {
+		return "", jobs.Permanent(fmt.Errorf("illegal path in archive: %s", name))
+	}
+	return target, nil
+}
+
+func writeExtracted(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0o200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// extractTar 只解出普通文件和目录，跳过链接和设备文件
+func extractTar(ctx context.Context, r io.Reader, dest string) (int, error) {
+	tr := tar.NewReader(r)
+	files := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, jobs.Permanent(err)
+		}
+		target, err := extractPath(dest, hdr.Name)
+		if err != nil {
+			return files, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := writeExtracted(target, tr, hdr.FileInfo().Mode()); err != nil {
+				return files, err
+			}
+			files++
+		default:
+			log.Printf("Extract skip %s (type %c)", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// extractZip zip 需要随机读取，进度按已解出条目的压缩大小计算
+func extractZip(ctx context.Context, f *os.File, size int64, dest string, report func(jobs.Progress)) (int, error) {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return 0, jobs.Permanent(err)
+	}
+	files := 0
+	var done int64
+	for _, zf := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+		target, err := extractPath(dest, zf.Name)
+		if err != nil {
+			return files, err
+		}
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return files, err
+			}
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return files, jobs.Permanent(err)
+		}
+		err = writeExtracted(target, rc, zf.Mode())
+		rc.Close()
+		if err != nil {
+			return files, err
+		}
+		files++
+		done += int64(zf.CompressedSize64)
+		report(jobs.Progress{Done: done, Total: size, Message: zf.Name})
+	}
+	return files, nil
+}
+
+// jobRoots 返回任务可以读写的 hub 目录
+func jobRoots() []string {
+	if len(hubConfig.JobRoots) > 0 {
+		return hubConfig.JobRoots
+	}
+	roots := []string{hubConfig.UploadDiskDir}
+	if hubConfig.UploadMemoryDir != "" {
+		roots = append(roots, hubConfig.UploadMemoryDir)
+	}
+	return roots
+}
+
+// checkJobPath 拒绝不在 jobRoots 之下的路径
+func checkJobPath(p string) error {
+	if p != "" {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		for _, root := range jobRoots() {
+			if root == "" {
+				continue
+			}
+			rootAbs, err := filepath.Abs(root)
+			if err != nil {
+				continue
+			}
+			rel, err := filepath.Rel(rootAbs, abs)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %q", errOutsideJobRoots, p)
+}
+
+// jobPaths 返回任务参数中 hub 本地的路径，合并和清单为最终文件的路径
+func jobPaths(kind string, params json.RawMessage) ([]string, error) {
+	switch kind {
+	case JobReplicate:
+		var p ReplicateParams
+		err := json.Unmarshal(params, &p)
+		return []string{p.Path}, err
+	case JobExtract:
+		var p ExtractParams
+		err := json.Unmarshal(params, &p)
+		return []string{p.Archive, p.Dest}, err
+	case JobMerge:
+		var dto upload2.MergeChunksDto
+		err := json.Unmarshal(params, &dto)
+		return []string{path.Join(dto.UploadPath, dto.Name)}, err
+	case JobManifest:
+		var m upload2.Manifest
+		err := json.Unmarshal(params, &m)
+		return []string{path.Join(m.UploadPath, m.Name)}, err
+	}
+	return nil, nil
+}
+
+// -----------------------
+// 任务接口
+// -----------------------
+
+// jobsEnabled 未启用任务队列时返回 404
+func jobsEnabled(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if hubJobs == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "jobs are not enabled"})
+		}
+		return next(c)
+	}
+}
+
+func jobsError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, jobs.ErrState):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, jobs.ErrUnknownKind):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// authorizeFileJob 检查 /file 接口提交合并或清单任务的请求：启用授权时按 authProvider 识别身份，
+// 要求 jobs 权限，接收进度通知的 token 要求对该 agent 有中继权限；最终文件要在 jobRoots 之下
+func authorizeFileJob(c echo.Context, kind, finalPath string) (int, error) {
+	var ident *Identity
+	if hubConfig.Authz.Enabled {
+		var err error
+		if ident, err = authProvider.Authenticate(c.Request()); err != nil {
+			return http.StatusUnauthorized, err
+		}
+	}
+	if err := authorize(ident, CapJobs, nil, kind); err != nil {
+		return http.StatusForbidden, err
+	}
+	if token := c.QueryParam("token"); token != "" {
+		if err := authorizeAgent(ident, token); err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+	if err := checkJobPath(finalPath); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+// MergeJobHandler 提交分片合并任务，参数与 upload2.MergeChunksHandler 相同；
+// 同一 hash 的合并任务尚未结束时返回该任务
+func MergeJobHandler(c echo.Context) error {
+	var dto upload2.MergeChunksDto
+	if err := c.Bind(&dto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if status, err := authorizeFileJob(c, JobMerge, path.Join(dto.UploadPath, dto.Name)); err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	job, attached, err := hubJobs.SubmitKeyed(JobMerge, dto.Hash, c.QueryParam("token"), dto)
+	if err != nil {
+		return jobsError(c, err)
+	}
+	if attached {
+		hubMetrics.Inc("hub_jobs_coalesced_total", "kind", JobMerge)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// ManifestJobHandler 提交清单生成任务，参数与 upload2.CommitManifestHandler 相同
+func ManifestJobHandler(c echo.Context) error {
+	var m upload2.Manifest
+	if err := c.Bind(&m); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if status, err := authorizeFileJob(c, JobManifest, path.Join(m.UploadPath, m.Name)); err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	job, err := hubJobs.Submit(JobManifest, c.QueryParam("token"), m)
+	if err != nil {
+		return jobsError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// SubmitJobRequest POST /api/jobs 的请求体，Token 为接收进度通知的会话
+type SubmitJobRequest struct {
+	Kind   string          `json:"kind"`
+	Token  string          `json:"token,omitempty"`
+	Params json.RawMessage `json:"params"`
+}
+
+// SubmitJobHandler 提交任意类型的任务；复制任务要求对每个目标主机有 upload 权限，
+// 合并、清单和解压任务要求 jobs 权限，任务读写的本地路径都要在 jobRoots 之下
+func SubmitJobHandler(c echo.Context) error {
+	var req SubmitJobRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	switch req.Kind {
+	case JobReplicate:
+		var p ReplicateParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.Path == "" || p.RemotePath == "" || len(p.Hosts) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "path, hosts and remotePath are required"})
+		}
+		for _, host := range p.Hosts {
+			if err := authorizeHost(requestIdentity(c), sshutil.CapUpload, host); err != nil {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+			}
+		}
+	case JobMerge, JobManifest, JobExtract:
+		if err := authorize(requestIdentity(c), CapJobs, nil, req.Kind); err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	paths, err := jobPaths(req.Kind, req.Params)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid params: " + err.Error()})
+	}
+	for _, p := range paths {
+		if err := checkJobPath(p); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	if req.Token != "" {
+		if err := authorizeAgent(requestIdentity(c), req.Token); err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	job, err := hubJobs.Submit(req.Kind, req.Token, req.Params)
+	if err != nil {
+		return jobsError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// ListJobsHandler 查询参数 state 过滤状态
+func ListJobsHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"kinds": hubJobs.Kinds(),
+		"jobs":  hubJobs.List(jobs.State(c.QueryParam("state"))),
+	})
+}
+
+func GetJobHandler(c echo.Context) error {
+	job, ok := hubJobs.Get(c.Param("id"))
+	if !ok {
+		return jobsError(c, jobs.ErrNotFound)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func CancelJobHandler(c echo.Context) error {
+	job, err := hubJobs.Cancel(c.Param("id"))
+	if err != nil {
+		return jobsError(c, err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func RetryJobHandler(c echo.Context) error {
+	job, err := hubJobs.Retry(c.Param("id"))
+	if err != nil {
+		return jobsError(c, err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
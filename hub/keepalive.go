@@ -0,0 +1,46 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 心跳保活：writePump 定时发送 WS ping 控制帧，收到 pong 时延长读超时，
+// 前端和 agent 两侧都生效；旧的文本 "ping" 心跳仍然兼容
+// -----------------------
+
+// keepaliveWriteWait 发送 ping 控制帧的写超时
+const keepaliveWriteWait = 10 * time.Second
+
+// keepaliveEnabled 是否启用 ping 控制帧，KeepalivePingInterval 为 0 时只支持文本心跳
+func keepaliveEnabled() bool {
+	return hubConfig.KeepalivePingInterval > 0
+}
+
+// setupKeepalive 设置初始读超时和 PongHandler，需在启动读循环前调用
+func setupKeepalive(conn *websocket.Conn) {
+	if !keepaliveEnabled() {
+		return
+	}
+	pongWait := hubConfig.KeepalivePongWait.D()
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+}
+
+// newPingTicker 返回 writePump 使用的 ping 定时器，未启用时返回永不触发的通道
+func newPingTicker() (<-chan time.Time, func()) {
+	if !keepaliveEnabled() {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(hubConfig.KeepalivePingInterval.D())
+	return ticker.C, ticker.Stop
+}
+
+// writePing 发送一个 ping 控制帧，只能在 writePump 中调用
+func writePing(conn messageConn) error {
+	return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepaliveWriteWait))
+}
@@ -0,0 +1,197 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"echo_demo/ldapauth"
+	"echo_demo/sshutil"
+	"echo_demo/users"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// LDAP / AD 认证：HTTP 请求可以直接使用 Basic 认证（LDAPAuthProvider），浏览器和 WebSocket 客户端
+// 通过 /auth/login 用域账号换取登录 token（本地用户不存在时回退到 LDAP）。
+// AD 组按 Groups 映射为 hub 角色；映射中配置了 HostGroups 时，额外生成角色 ldap:<组名>，
+// 可以对这些主机分组使用 Capabilities，不需要再在 Authz.Rules 中逐条配置
+// -----------------------
+
+// LDAPConfig Server.URL 为空时不启用；认证结果按 CacheTTL 缓存，避免 Basic 认证的每个请求都访问目录服务器
+type LDAPConfig struct {
+	Server       ldapauth.Config             `json:"server"`
+	Groups       map[string]LDAPGroupMapping `json:"groups,omitempty"` // AD 组名 -> 映射
+	DefaultRoles []string                    `json:"defaultRoles,omitempty"`
+	CacheTTL     Duration                    `json:"cacheTTL"`
+}
+
+// LDAPGroupMapping Capabilities 为空时可以对 HostGroups 使用全部主机能力
+type LDAPGroupMapping struct {
+	Roles        []string `json:"roles,omitempty"`
+	HostGroups   []string `json:"hostGroups,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// ldapHostCapabilities 映射未指定能力时授予的主机能力
+var ldapHostCapabilities = []string{CapAgent, sshutil.CapTerminal, sshutil.CapUpload, sshutil.CapDownload, sshutil.CapExec}
+
+func (cfg LDAPConfig) validate() error {
+	if !cfg.Server.Enabled() {
+		return nil
+	}
+	if cfg.Server.BaseDN == "" {
+		return errors.New("ldap.server.baseDN is required")
+	}
+	if cfg.CacheTTL.D() < 0 {
+		return errors.New("ldap.cacheTTL must not be negative")
+	}
+	for group, m := range cfg.Groups {
+		if len(m.Capabilities) > 0 && len(m.HostGroups) == 0 {
+			return fmt.Errorf("ldap.groups[%s]: capabilities require hostGroups", group)
+		}
+	}
+	return nil
+}
+
+// hubLDAP 为 nil 表示未启用 LDAP
+var hubLDAP *ldapauth.Client
+
+// ldapHostRules 由 LDAPGroupMapping.HostGroups 生成的授权规则，与 Authz.Rules 一起检查
+var ldapHostRules []AuthzRule
+
+func ldapGroupRole(group string) string {
+	return "ldap:" + group
+}
+
+// buildLDAPHostRules 启动时调用
+func buildLDAPHostRules(cfg LDAPConfig) []AuthzRule {
+	var rules []AuthzRule
+	for group, m := range cfg.Groups {
+		if len(m.HostGroups) == 0 {
+			continue
+		}
+		caps := m.Capabilities
+		if len(caps) == 0 {
+			caps = ldapHostCapabilities
+		}
+		rules = append(rules, AuthzRule{Role: ldapGroupRole(group), Groups: m.HostGroups, Capabilities: caps})
+	}
+	return rules
+}
+
+// ldapRoles 按所在的 AD 组计算 hub 角色
+func ldapRoles(cfg LDAPConfig, groups []string) []string {
+	roles := append([]string(nil), cfg.DefaultRoles...)
+	for _, g := range groups {
+		m, ok := cfg.Groups[g]
+		if !ok {
+			continue
+		}
+		roles = append(roles, m.Roles...)
+		if len(m.HostGroups) > 0 {
+			roles = append(roles, ldapGroupRole(g))
+		}
+	}
+	return mergeRoles(roles)
+}
+
+type ldapCacheEntry struct {
+	user    users.User
+	expires time.Time
+}
+
+// ldapCache 以用户名和密码的哈希为键缓存认证通过的用户，不保存密码本身
+type ldapCache struct {
+	mu      sync.Mutex
+	entries map[[32]byte]ldapCacheEntry
+}
+
+var hubLDAPCache = &ldapCache{entries: make(map[[32]byte]ldapCacheEntry)}
+
+func ldapCacheKey(username, password string) [32]byte {
+	return sha256.Sum256([]byte(username + "\x00" + password))
+}
+
+func (c *ldapCache) get(key [32]byte) (users.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return users.User{}, false
+	}
+	return e.user, true
+}
+
+func (c *ldapCache) put(key [32]byte, u users.User, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	// 顺便清理过期的条目，缓存大小随活跃用户数变化
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ldapCacheEntry{user: u, expires: now.Add(ttl)}
+}
+
+// ldapLogin 用域账号认证，用户不存在和密码错误都返回 users.ErrInvalidLogin
+func ldapLogin(username, password string) (users.User, error) {
+	cfg := hubConfig.LDAP
+	key := ldapCacheKey(username, password)
+	if u, ok := hubLDAPCache.get(key); ok {
+		return u, nil
+	}
+	result, err := hubLDAP.Authenticate(username, password)
+	if errors.Is(err, ldapauth.ErrInvalidCredentials) || errors.Is(err, ldapauth.ErrUserNotFound) {
+		return users.User{}, users.ErrInvalidLogin
+	}
+	if err != nil {
+		log.Println("LDAP error:", err)
+		return users.User{}, err
+	}
+	u := users.User{Username: result.Username, Roles: ldapRoles(cfg, result.Groups)}
+	if ttl := cfg.CacheTTL.D(); ttl > 0 {
+		hubLDAPCache.put(key, u, ttl)
+	}
+	return u, nil
+}
+
+// passwordLogin /auth/login 的用户名密码校验：本地用户优先，本地不存在该用户时回退到 LDAP。
+// 返回实际使用的认证方式，用于登录指标
+func passwordLogin(username, password string) (users.User, string, error) {
+	if hubUsers != nil {
+		if _, err := hubUsers.GetUser(username); err == nil || hubLDAP == nil {
+			u, err := users.Login(hubUsers, username, password)
+			return u, "password", err
+		}
+	}
+	u, err := ldapLogin(username, password)
+	return u, "ldap", err
+}
+
+// LDAPAuthProvider 使用 HTTP Basic 认证中的域账号，会话 token 为用户名；
+// 域账号没有租户映射，只有开启 TenantFromQuery 时才取 tenant 查询参数
+type LDAPAuthProvider struct{}
+
+func (LDAPAuthProvider) Authenticate(r *http.Request) (*Identity, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errMissingCredentials
+	}
+	u, err := ldapLogin(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Method:  "ldap",
+		Subject: u.Username,
+		Token:   u.Username,
+		Tenant:  queryTenant(r),
+		Roles:   u.Roles,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"echo_demo/bufpool"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 消息大小限制：前端连接在读取时按 ClientMaxMessageSize 截断检查，超出时先回复错误通知再以 1009 关闭；
+// agent 连接使用 SetReadLimit，超出时 agent 收到 1009，前端收到错误通知，会话按重连策略处理
+// -----------------------
+
+// ErrCodeMessageTooBig 消息超过大小限制
+const ErrCodeMessageTooBig = "message_too_big"
+
+var errMessageTooBig = errors.New("message exceeds size limit")
+
+// gracefulCloseTimeout 优雅关闭时等待写出剩余数据和关闭帧的最长时间
+const gracefulCloseTimeout = 2 * time.Second
+
+// readLimited 与 ReadMessage 相同，但最多读取 limit 个字节（limit <= 0 表示不限制），超出时返回 errMessageTooBig
+func readLimited(conn *websocket.Conn, limit int64) (int, []byte, error) {
+	msgType, data, err := bufpool.ReadMessage(conn, limit)
+	if err != nil {
+		return msgType, nil, err
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return msgType, nil, errMessageTooBig
+	}
+	return msgType, data, nil
+}
+
+// readMessage 读取 agent 连接的一条消息，WebSocket 连接借用缓冲区池读取
+func readMessage(conn messageConn) (int, []byte, error) {
+	if ws, ok := conn.(*websocket.Conn); ok {
+		return bufpool.ReadMessage(ws, 0)
+	}
+	return conn.ReadMessage()
+}
+
+// setAgentReadLimit 为 agent 的 WebSocket 连接设置读取上限
+func setAgentReadLimit(conn *websocket.Conn) {
+	if limit := hubConfig.AgentMaxMessageSize; limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+}
+
+// closeWithCode 写完队列中已有的数据后发送关闭帧并关闭连接，超时后强制关闭
+func (c *wsClientConn) closeWithCode(code int, text string) {
+	c.sendMu.Lock()
+	c.closeCode, c.closeText = code, text
+	c.sendMu.Unlock()
+	c.closeSend()
+	time.AfterFunc(gracefulCloseTimeout, func() { c.conn.Close() })
+}
+
+// closingGracefully 是否已由 closeWithCode 接管关闭
+func (c *wsClientConn) closingGracefully() bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.closeCode != 0
+}
+
+// writeClose writePump 退出前调用，发送 closeWithCode 指定的关闭帧
+func (c *wsClientConn) writeClose() {
+	c.sendMu.Lock()
+	code, text := c.closeCode, c.closeText
+	c.sendMu.Unlock()
+	if code == 0 {
+		return
+	}
+	msg := websocket.FormatCloseMessage(code, text)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		log.Println("Client close write error:", err)
+	}
+}
+
+// rejectOversized 前端消息超过大小限制时回复错误并关闭该连接
+func (s *RelaySession) rejectOversized(client *wsClientConn) {
+	limit := hubConfig.ClientMaxMessageSize
+	log.Printf("Session %s client message exceeds %d bytes, closing", s.token, limit)
+	s.notifyError(client, "", ErrCodeMessageTooBig, fmt.Sprintf("message exceeds %d bytes", limit))
+	client.closeWithCode(websocket.CloseMessageTooBig, "message too big")
+}
+
+// notifyAgentOversized agent 消息超过大小限制时通知全部前端
+func (s *RelaySession) notifyAgentOversized() {
+	reason := fmt.Sprintf("agent message exceeds %d bytes", hubConfig.AgentMaxMessageSize)
+	hubMetrics.Inc("hub_message_errors_total", "code", ErrCodeMessageTooBig)
+	s.sendNotify(WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: ActionError,
+		Data:   reason,
+		Error:  &MessageError{Code: ErrCodeMessageTooBig, Reason: reason},
+	})
+}
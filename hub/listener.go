@@ -0,0 +1,248 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// -----------------------
+// 监听套接字交接：收到 SIGHUP 时启动新进程并把监听 fd 传给它，新进程开始服务后通过就绪管道通知旧进程；
+// 旧进程随后关闭自己的监听不再接受新连接，已有会话按 drain 流程排空后退出。
+// 新进程在超时前没有就绪（比如配置错误退出）时旧进程结束它并继续服务。
+// 监听地址写成 unix:/path 时监听 Unix 套接字；由 systemd 套接字激活启动时（LISTEN_PID、LISTEN_FDS）
+// 直接使用 systemd 传入的第一个 fd，忽略 ListenAddr
+// -----------------------
+
+// ListenFDEnv 子进程通过该环境变量得知继承的监听 fd
+const ListenFDEnv = "HUB_LISTEN_FD"
+
+// ReadyFDEnv 子进程通过该环境变量得知就绪管道的写端，开始服务时写入一个字节
+const ReadyFDEnv = "HUB_READY_FD"
+
+// handoverReadyTimeout 等待新进程就绪的上限
+const handoverReadyTimeout = 30 * time.Second
+
+// unixAddrPrefix ListenAddr 以该前缀开头时监听 Unix 套接字
+const unixAddrPrefix = "unix:"
+
+// systemdFirstFD systemd 传入的第一个 fd（SD_LISTEN_FDS_START）
+const systemdFirstFD = 3
+
+// hubListener 创建监听：依次尝试父进程传入的 fd、systemd 套接字激活、Unix 套接字，
+// 否则新建 TCP 监听（可选 SO_REUSEPORT）
+func hubListener(addr string, reusePort bool) (net.Listener, error) {
+	if v := os.Getenv(ListenFDEnv); v != "" {
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", ListenFDEnv, err)
+		}
+		ln, err := fileListener(fd)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Inherited listener %s from parent process", ln.Addr())
+		return ln, nil
+	}
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return unixListener(path, hubConfig.UnixSocketMode)
+	}
+
+	lc := net.ListenConfig{}
+	if reusePort {
+		// 允许新旧进程同时监听同一端口，由内核分发新连接
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func fileListener(fd int) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), "hub-listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// systemdListener 不是由 systemd 套接字激活启动时返回 nil, nil。
+// 读取后清除 LISTEN_* 环境变量，避免交接时启动的子进程误用
+func systemdListener() (net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	if n > 1 {
+		log.Printf("systemd passed %d sockets, only the first one is used", n)
+	}
+	// 其余 fd 不使用，避免泄漏给子进程
+	for fd := systemdFirstFD + 1; fd < systemdFirstFD+n; fd++ {
+		unix.CloseOnExec(fd)
+	}
+	ln, err := fileListener(systemdFirstFD)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %w", err)
+	}
+	log.Printf("Using systemd socket %s", ln.Addr())
+	return ln, nil
+}
+
+// unixListener 监听 Unix 套接字，mode 为八进制权限（比如 "0660"），为空时不修改。
+// 路径上残留的套接字文件在确认没有进程监听后删除
+func unixListener(path, mode string) (net.Listener, error) {
+	if st, err := os.Lstat(path); err == nil {
+		if st.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		perm, _ := strconv.ParseUint(mode, 8, 32) // 已在配置校验中检查
+		if err := os.Chmod(path, fs.FileMode(perm)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// validateUnixSocketMode 检查 UnixSocketMode 是否为八进制权限
+func validateUnixSocketMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	if perm, err := strconv.ParseUint(mode, 8, 32); err != nil || perm > 0o777 {
+		return fmt.Errorf("invalid unixSocketMode %q", mode)
+	}
+	return nil
+}
+
+// handoverListener 以相同参数启动新进程，并通过 ExtraFiles 传递监听 fd（在子进程中为 fd 3）和就绪管道（fd 4），
+// 等到新进程就绪才返回；新进程退出或超时未就绪时结束它并返回错误
+func handoverListener(ln net.Listener) (*os.Process, error) {
+	var f *os.File
+	var err error
+	switch l := ln.(type) {
+	case *net.TCPListener:
+		f, err = l.File()
+	case *net.UnixListener:
+		f, err = l.File()
+	default:
+		return nil, errors.New("listener does not support fd passing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{f, readyW}
+	cmd.Env = append(os.Environ(), ListenFDEnv+"=3", ReadyFDEnv+"=4")
+	err = cmd.Start()
+	// 父进程不保留写端，子进程退出时读端才能读到 EOF
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Started new hub process %d for listener handover", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			ready <- fmt.Errorf("new hub process exited before it was ready: %w", err)
+			return
+		}
+		ready <- nil
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(handoverReadyTimeout):
+		err = fmt.Errorf("new hub process not ready after %s", handoverReadyTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	// 子进程在后台运行，不等待它退出
+	go cmd.Wait()
+	if l, ok := ln.(*net.UnixListener); ok {
+		// 新进程继续使用同一个套接字文件，旧进程关闭监听时不能删除它
+		l.SetUnlinkOnClose(false)
+	}
+	log.Printf("New hub process %d is ready", cmd.Process.Pid)
+	return cmd.Process, nil
+}
+
+// notifyReady 由交接启动的进程开始服务时通知父进程，不是交接启动时什么也不做
+func notifyReady() {
+	v := os.Getenv(ReadyFDEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(ReadyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s: %v", ReadyFDEnv, err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "hub-ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Println("Notify parent process error:", err)
+	}
+}
@@ -0,0 +1,141 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+)
+
+// -----------------------
+// 本地 action 处理器：应用通过 RegisterLocalHandler 注册，hub 收到对应 action 时在本地处理并回复，不转发给 agent。
+// 处理器在前端的读循环中同步执行，耗时的处理应自行启动 goroutine 并通过 Notify 推送结果
+// -----------------------
+
+// LocalContext 传给本地处理器的会话上下文
+type LocalContext struct {
+	Ctx     context.Context // 会话结束时取消
+	Token   string
+	Tenant  string
+	session *RelaySession
+	client  *wsClientConn
+}
+
+// Notify 向发出请求的前端推送 notify
+func (lc *LocalContext) Notify(action string, data interface{}) {
+	lc.session.notifyClient(lc.client, WebSocketMessage{Type: MessageTypeNotify, Action: action, Data: data})
+}
+
+// Broadcast 向会话内的全部前端推送 notify
+func (lc *LocalContext) Broadcast(action string, data interface{}) {
+	lc.session.sendNotify(WebSocketMessage{Type: MessageTypeNotify, Action: action, Data: data})
+}
+
+// Feature 按会话的租户读取功能开关
+func (lc *LocalContext) Feature(name string) bool {
+	return lc.session.feature(name)
+}
+
+// ForwardToAgent 把消息原样转发给会话的 agent，处理器随后应返回 ErrNoReply，由 agent 回复
+func (lc *LocalContext) ForwardToAgent(msg WebSocketMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	lc.session.relayToAgent(lc.client, msg, data)
+	return nil
+}
+
+// LocalHandlerFunc 返回值作为 response 的 Data 发回请求方，返回错误时 Data 为 {"error": ...}；
+// 返回 ErrNoReply 时不回复
+type LocalHandlerFunc func(lc *LocalContext, msg WebSocketMessage) (interface{}, error)
+
+// ErrNoReply 处理器已自行回复或把请求交给了 agent
+var ErrNoReply = errors.New("no reply")
+
+var (
+	localHandlersMu sync.RWMutex
+	localHandlers   = map[string]LocalHandlerFunc{}
+)
+
+// RegisterLocalHandler 注册 action 的本地处理器，重复注册时覆盖之前的处理器
+func RegisterLocalHandler(action string, h LocalHandlerFunc) {
+	localHandlersMu.Lock()
+	defer localHandlersMu.Unlock()
+	localHandlers[action] = h
+}
+
+func localHandler(action string) (LocalHandlerFunc, bool) {
+	localHandlersMu.RLock()
+	defer localHandlersMu.RUnlock()
+	h, ok := localHandlers[action]
+	return h, ok
+}
+
+// Respond 向发出请求的前端回复 response，供启动了 goroutine 的处理器在完成后调用，此时处理器本身应返回 ErrNoReply
+func (lc *LocalContext) Respond(msg WebSocketMessage, data interface{}, err error) {
+	lc.session.respondLocal(lc.client, msg, data, err)
+}
+
+// 处理本地事件，不转发给远程 agent
+func (s *RelaySession) handleLocal(client *wsClientConn, msg WebSocketMessage) {
+	h, ok := localHandler(msg.Action)
+	if !ok {
+		s.respondLocal(client, msg, nil, fmt.Errorf("no local handler for action %q", msg.Action))
+		return
+	}
+	lc := &LocalContext{Ctx: s.ctx, Token: s.token, Tenant: s.tenant, session: s, client: client}
+	data, err := h(lc, msg)
+	if errors.Is(err, ErrNoReply) {
+		return
+	}
+	s.respondLocal(client, msg, data, err)
+}
+
+// respondLocal 发送本地处理结果，出错时 Data 为 {"error": ...}
+func (s *RelaySession) respondLocal(client *wsClientConn, msg WebSocketMessage, data interface{}, err error) {
+	response := WebSocketMessage{
+		Type:      MessageTypeResponse,
+		RequestID: msg.RequestID,
+		Action:    msg.Action,
+		Data:      data,
+		Channel:   msg.Channel,
+	}
+	if err != nil {
+		log.Printf("Local handler %q error: %v", msg.Action, err)
+		response.Data = map[string]string{"error": err.Error()}
+		response.Error = &MessageError{Code: ErrCodeLocalHandler, Reason: err.Error()}
+	}
+	if _, ok := localHandler(msg.Action); ok {
+		hubMetrics.Inc("hub_local_actions_total", "action", msg.Action, "ok", boolLabel(err == nil))
+	}
+	respData, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Local event marshal error:", err)
+		return
+	}
+	if s.sendFragmented(client, response, len(respData)) {
+		return
+	}
+	s.sendTo(client, respData)
+}
+
+func init() {
+	// "local" 保留原来的行为：把数据原样带回，便于前端测试连通性
+	RegisterLocalHandler(MessageTypeLocal, func(lc *LocalContext, msg WebSocketMessage) (interface{}, error) {
+		return fmt.Sprintf("Local processing result for data: %v", msg.Data), nil
+	})
+	RegisterLocalHandler("server_stats", func(lc *LocalContext, msg WebSocketMessage) (interface{}, error) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		return map[string]interface{}{
+			"sessions":   len(relayHub.listSessions()),
+			"goroutines": runtime.NumGoroutine(),
+			"heapBytes":  mem.HeapAlloc,
+			"session":    lc.session.info(),
+		}, nil
+	})
+}
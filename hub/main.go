@@ -0,0 +1,1608 @@
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"echo_demo/bufpool"
+	"echo_demo/download"
+	"echo_demo/egress"
+	"echo_demo/geoip"
+	"echo_demo/jwtauth"
+	"echo_demo/ldapauth"
+	"echo_demo/mailer"
+	"echo_demo/sshutil"
+	"echo_demo/term"
+	"echo_demo/tracing"
+	"echo_demo/upload2"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// -----------------------
+// 消息模型定义
+// -----------------------
+
+type WebSocketMessage struct {
+	Type      string        `json:"t"`            // "request", "response", "notify", "ping", "pong"
+	RequestID string        `json:"r,omitempty"`  // 请求ID
+	Action    string        `json:"a"`            // 操作，比如 "download"、"local"、"remote"
+	Data      interface{}   `json:"d,omitempty"`  // 消息数据
+	Checksum  string        `json:"c,omitempty"`  // 帧校验值（协商 crc32 后使用）
+	Seq       int64         `json:"s,omitempty"`  // 按方向递增的序号：agent 消息由 hub 编号（开启断线续传后使用），前端消息由前端编号
+	Error     *MessageError `json:"e,omitempty"`  // 出错时的错误码和原因
+	Channel   string        `json:"ch,omitempty"` // 逻辑通道，为空表示不属于任何通道
+	Trace     string        `json:"tp,omitempty"` // W3C traceparent（启用追踪后使用）
+	NotifyID  int64         `json:"n,omitempty"`  // 需要确认的 notify 的编号（协商 notify_ack 后使用）
+	Frag      *Fragment     `json:"fg,omitempty"` // 超大消息的分片信息，d 为该片的字符串
+	Enc       string        `json:"x,omitempty"`  // 端到端加密的密钥 ID，非空时 d 为密文（协商 e2e 后使用）
+	Priority  string        `json:"p,omitempty"`  // "high" 或 "low"，为空表示普通（开启消息优先级后生效）
+}
+
+const (
+	MessageTypeRequest  = "request"
+	MessageTypeResponse = "response"
+	MessageTypeNotify   = "notify"
+	MessageTypePing     = "ping"
+	MessageTypePong     = "pong"
+	MessageTypeLocal    = "local"
+	MessageTypeRemote   = "remote"
+)
+
+// -----------------------
+// 配置常量
+// -----------------------
+
+const (
+	ReadDeadline         = 30 * time.Second
+	AgentInitialDeadline = 30 * time.Second
+	MaxAgentRetries      = 3
+	InitialRetryInterval = 1 * time.Second
+)
+
+// -----------------------
+// 全局 WS 升级器
+// -----------------------
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin:     func(r *http.Request) bool { return true },
+	WriteBufferPool: bufpool.WritePool,
+}
+
+// -----------------------
+// 前端连接（wsClientConn）
+// -----------------------
+
+type wsClientConn struct {
+	conn  *websocket.Conn
+	send  chan clientFrame
+	stats clientStats // 写耗时和排队时长统计，用于慢消费者检测
+	// 高、低优先级队列，未开启消息优先级时为 nil
+	sendHigh chan clientFrame
+	sendLow  chan clientFrame
+
+	queuedBytes atomic.Int64   // 发送队列中尚未写出的字节数
+	overflow    OverflowPolicy // 发送队列满时的处理策略
+
+	sendMu     sync.Mutex // 保护 send 的入队和关闭
+	sendClosed bool
+	// closeWithCode 设置的关闭码和原因，writePump 写完剩余数据后发送
+	closeCode int
+	closeText string
+
+	mu sync.Mutex // 保护下面的协商状态
+	// 帧校验是否已协商开启，以及连续校验失败次数，按连接分别协商
+	checksumEnabled bool
+	corruptedFrames int
+	// 是否已协商分片发送
+	fragmentEnabled bool
+	// 前端在 hello 中声明的能力
+	caps clientCapabilities
+	// 是否已协商端到端加密，以及前端在密钥交换中发送的公钥
+	e2eEnabled bool
+	e2ePub     string
+	// 访问时间窗结束前的提醒和结束时的定时器
+	accessTimers []*time.Timer
+	// 最近一次二次验证通过的时间，以及等待验证的暂存消息
+	stepUpAt time.Time
+	stepUp   *pendingStepUp
+
+	// 带序号消息的重排状态
+	seq clientSeq
+	// 等待确认的重要 notify
+	acks notifyAcks
+	// 本地处理的分片消息的重组状态
+	frags fragmentBuffer
+
+	// 上一条消息的读取时间（UnixNano），用于连接指纹的消息间隔
+	lastMessageAt atomic.Int64
+
+	codec    frameCodec   // 前端选择的二进制编码，JSON 时为 nil
+	encoding string       // 前端选择的编码名
+	ident    *Identity    // 连接时识别的身份，用于连接内的授权检查
+	origin   ClientOrigin // 连接的来源地址和地理归属
+}
+
+// clientFrame 待发给前端的一帧，记录入队时间用于统计排队时长
+type clientFrame struct {
+	data     []byte
+	queuedAt time.Time
+}
+
+func (c *wsClientConn) writePump() {
+	defer c.conn.Close()
+	pingC, stopPing := newPingTicker()
+	defer stopPing()
+	lanes := newLaneScheduler(c.sendHigh, c.send, c.sendLow)
+	for {
+		frame, ev := lanes.next(pingC)
+		switch ev {
+		case lanePing:
+			if err := writePing(c.conn); err != nil {
+				log.Println("Client ping error:", err)
+				return
+			}
+			continue
+		case laneClosed:
+			// 写出其它队列中剩余的帧再关闭
+			for _, frame := range lanes.drain() {
+				if c.writeQueued(frame) != nil {
+					return
+				}
+			}
+			c.writeClose()
+			return
+		}
+		if err := c.writeQueued(frame); err != nil {
+			log.Println("Client write error:", err)
+			return
+		}
+	}
+}
+
+func (c *wsClientConn) writeQueued(frame clientFrame) error {
+	c.queuedBytes.Add(-int64(len(frame.data)))
+	start := time.Now()
+	c.stats.beginWrite(start)
+	if err := c.writeFrame(frame.data); err != nil {
+		return err
+	}
+	c.stats.observe(time.Since(start), start.Sub(frame.queuedAt))
+	return nil
+}
+
+// -----------------------
+// Agent 连接（wsAgentConn）
+// -----------------------
+
+// messageConn agent 连接的底层传输，通常为 *websocket.Conn，启用 Redis 中继时也可能是 redisAgentConn
+type messageConn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+type wsAgentConn struct {
+	id   string // 反向注册时 agent 上报的标识
+	conn messageConn
+	send chan []byte
+	// 高、低优先级队列，未开启消息优先级时为 nil
+	sendHigh chan []byte
+	sendLow  chan []byte
+
+	queuedBytes atomic.Int64   // 发送队列中尚未写出的字节数
+	overflow    OverflowPolicy // 发送队列满时的处理策略
+
+	sendMu     sync.Mutex // 保护 send 的入队和关闭
+	sendClosed bool
+}
+
+func (a *wsAgentConn) writePump() {
+	defer a.conn.Close()
+	pingC, stopPing := newPingTicker()
+	defer stopPing()
+	lanes := newLaneScheduler(a.sendHigh, a.send, a.sendLow)
+	for {
+		msg, ev := lanes.next(pingC)
+		switch ev {
+		case lanePing:
+			if err := writePing(a.conn); err != nil {
+				log.Println("Agent ping error:", err)
+				return
+			}
+			continue
+		case laneClosed:
+			return
+		}
+		a.queuedBytes.Add(-int64(len(msg)))
+		if err := writeText(a.conn, msg); err != nil {
+			log.Println("Agent write error:", err)
+			return
+		}
+	}
+}
+
+// -----------------------
+// RelaySession：一个 token 对应一个 agent 连接和若干前端连接
+// -----------------------
+
+type RelaySession struct {
+	token     string
+	tenant    string         // 租户，用于功能开关的按租户覆盖，由第一个前端连接时确定
+	subject   string         // 第一个前端的身份主体，用于会话报告
+	origin    ClientOrigin   // 第一个前端的来源，用于会话报告
+	createdIP string         // 创建会话的前端 IP，用于单 IP 会话上限
+	endpoint  *AgentEndpoint // 主动拨号的 agent 地址，反向注册的 agent 为 nil
+
+	clients []*wsClientConn
+	agent   *wsAgentConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	loops  sync.WaitGroup // 会话的读写循环，Hub.CloseSessions 等待其退出
+
+	createdAt       time.Time
+	cohorts         map[string]string           // 实验名 -> 分组，创建时确定
+	bytesFromClient atomic.Int64                // 前端发给 agent 的字节数
+	bytesFromAgent  atomic.Int64                // agent 发给前端的字节数
+	msgsFromClient  atomic.Int64                // 前端发给 agent 的消息数
+	msgsFromAgent   atomic.Int64                // agent 发给前端的消息数
+	notifySeq       atomic.Int64                // 需要确认的 notify 的编号
+	frameTrace      atomic.Pointer[frameTracer] // 管理员开启的帧级追踪，未开启时为 nil
+	reconnects      atomic.Int64                // agent 重连成功次数
+	lastActivity    atomic.Int64                // 最近一次中继消息的时间（UnixNano）
+	idleTimer       *time.Timer                 // 空闲超时定时器，未启用时为 nil
+
+	clientMu sync.Mutex // 保护 clients 的读写操作
+	agentMu  sync.Mutex // 保护 agent 的读写操作
+	stateMu  sync.Mutex // 保护状态更新，比如 agentReconnecting
+	// 是否已由第一个前端完成 agent 建连并启动中继
+	started bool
+	// 标识 agent 当前是否正在重连
+	agentReconnecting bool
+	// agent 重连期间暂存的客户端消息，重连成功后按顺序补发
+	pending [][]byte
+	// 已转发给 agent、尚未收到响应的 RequestID，优雅退出时等待其完成
+	inflight map[string]struct{}
+	// 反向注册的 agent 重新连入后通过该通道交给会话
+	agentReady chan *wsAgentConn
+	// 断线续传：agent 消息序号、最近消息缓存，以及最后一个前端断开后的等待定时器
+	replaySeq   int64
+	replay      []replayEntry
+	resumeTimer *time.Timer
+	// 按 action 路由的 agent 连接，endpoint URL -> 连接
+	routeMu sync.Mutex
+	routed  map[string]*wsAgentConn
+	// agent 重连策略，创建会话时确定
+	reconnect ReconnectPolicy
+	// 两个方向的限速，创建会话时确定
+	limiter sessionLimiter
+	// 最近出现过的 RequestID，未启用重复请求过滤时为 nil
+	dedup *requestDedup
+	// 整个会话透传，创建会话时确定
+	rawAll bool
+	// 启用追踪时已转发给 agent、等待响应的请求，RequestID -> 追踪上下文，由 stateMu 保护
+	traces map[string]requestTrace
+	// 会话结束原因，用于会话报告，为空表示正常关闭
+	endReason string
+	// 是否已触发拦截器的 OnSessionStart
+	hooked atomic.Bool
+	// 前端打开的逻辑通道，通道 ID -> 通道
+	chanMu   sync.Mutex
+	channels map[string]*logicalChannel
+
+	once sync.Once // 确保 cleanup 只执行一次
+}
+
+// broadcast 发送消息给会话内的全部前端
+func (s *RelaySession) broadcast(data []byte) {
+	s.deliver(nil, data, false)
+}
+
+// sendTo 只发送消息给指定前端
+func (s *RelaySession) sendTo(client *wsClientConn, data []byte) {
+	s.deliver(client, data, false)
+}
+
+// sendNotify 广播 notify 给全部前端
+func (s *RelaySession) sendNotify(notify WebSocketMessage) {
+	s.deliverNotify(nil, notify)
+}
+
+// notifyClient 只发送 notify 给指定前端
+func (s *RelaySession) notifyClient(client *wsClientConn, notify WebSocketMessage) {
+	s.deliverNotify(client, notify)
+}
+
+func (s *RelaySession) deliverNotify(target *wsClientConn, notify WebSocketMessage) {
+	acked := ackedNotify(notify.Action)
+	if acked {
+		notify.NotifyID = s.notifySeq.Add(1)
+	}
+	if hubConfig.Priority.Enabled && notify.Priority == "" {
+		notify.Priority = PriorityHigh
+		if nonEssentialNotifies[notify.Action] {
+			notify.Priority = PriorityLow
+		}
+	}
+	notifyData, err := json.Marshal(notify)
+	if err != nil {
+		log.Println("Notify marshal error:", err)
+		return
+	}
+	if acked {
+		s.deliverAcked(target, notifyData, notify.NotifyID)
+		return
+	}
+	s.deliver(target, notifyData, nonEssentialNotifies[notify.Action])
+}
+
+// deliver 把一帧放入前端的发送队列，target 为 nil 时发给全部前端。
+// 已协商帧校验的连接会补上校验值；droppable 的帧不会发给被降级的慢消费者
+func (s *RelaySession) deliver(target *wsClientConn, data []byte, droppable bool) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if len(s.clients) == 0 {
+		log.Println("Session", s.token, "has no client connection")
+		return
+	}
+	s.traceFrame(FrameClientOut, data)
+	var stamped []byte
+	for _, client := range s.clients {
+		if target != nil && client != target {
+			continue
+		}
+		if droppable && client.stats.isDegraded() {
+			hubMetrics.Inc("hub_slow_consumer_dropped_notifies_total")
+			continue
+		}
+		frame := data
+		if client.checksumOn() {
+			if stamped == nil {
+				stamped = stampChecksum(data)
+			}
+			frame = stamped
+		}
+		if err := client.Send(frame); errors.Is(err, errSendQueueFull) {
+			hubMetrics.Inc("hub_send_failures_total", "leg", "client")
+		}
+		s.checkSlowClient(client)
+	}
+}
+
+// addClient 加入一个前端连接
+func (s *RelaySession) addClient(client *wsClientConn) {
+	s.cancelResumeHold()
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	s.clients = append(s.clients, client)
+}
+
+// removeClient 只清理单个前端连接，最后一个前端离开时关闭整个会话（开启断线续传时等待重连）
+func (s *RelaySession) removeClient(client *wsClientConn) {
+	s.clientMu.Lock()
+	kept := make([]*wsClientConn, 0, len(s.clients))
+	for _, c := range s.clients {
+		if c == client {
+			// 优雅关闭时由 writePump 写完关闭帧后关闭连接
+			if !c.closingGracefully() {
+				c.conn.Close()
+			}
+			c.closeSend()
+			continue
+		}
+		kept = append(kept, c)
+	}
+	s.clients = kept
+	empty := len(kept) == 0
+	s.clientMu.Unlock()
+	s.closeClientChannels(client)
+	relayHub.leaveAllGroups(client)
+
+	if empty && !s.holdForResume() {
+		s.cleanup()
+	}
+}
+
+// markStarted 标记会话已启动并记录租户、主体和来源，返回 true 表示调用方是第一个前端，需要负责建立 agent 连接
+func (s *RelaySession) markStarted(client *wsClientConn) bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.started {
+		return false
+	}
+	s.started = true
+	s.tenant = client.ident.Tenant
+	s.subject = client.ident.Subject
+	s.origin = client.origin
+	return true
+}
+
+// setEndReason 记录会话结束原因，只保留第一次设置的值
+func (s *RelaySession) setEndReason(reason string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.endReason == "" {
+		s.endReason = reason
+	}
+}
+
+// clientReadLoop 处理某个前端发送的消息
+func (s *RelaySession) clientReadLoop(client *wsClientConn) {
+	defer s.removeClient(client)
+	defer client.stopSeq()
+	defer client.stopNotifyAcks()
+	defer client.frags.reset()
+	defer client.stopAccessWindow()
+	for {
+		// 检测 context 是否取消
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+
+		msgType, data, err := readLimited(client.conn, hubConfig.ClientMaxMessageSize)
+		readAt := time.Now()
+		if errors.Is(err, errMessageTooBig) {
+			s.rejectOversized(client)
+			break
+		}
+		if err != nil {
+			log.Println("Client read error:", err)
+			break
+		}
+		if !s.injectChaos(ChaosLegClient, func() { client.conn.Close() }) {
+			continue
+		}
+		s.traceFrame(FrameClientIn, data)
+		if s.relayRawToAgent(client, msgType, data) {
+			continue
+		}
+		// 选择了二进制编码的前端发送二进制消息，先转为 JSON；其余情况只处理文本消息
+		binaryFrame := msgType == websocket.BinaryMessage && client.codec != nil
+		if binaryFrame {
+			if data, err = client.codec.decode(data); err != nil {
+				s.rejectBadMessage(client, nil, err)
+				continue
+			}
+		} else if msgType != websocket.TextMessage {
+			continue
+		}
+		// 处理心跳
+		if strings.TrimSpace(string(data)) == MessageTypePing {
+			s.sendTo(client, []byte(MessageTypePong))
+			_ = client.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+			continue
+		}
+		var msg WebSocketMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.rejectBadMessage(client, data, err)
+			continue
+		}
+		if !binaryFrame && !s.verifyChecksum(client, data) {
+			continue
+		}
+		s.sequenceClientMessage(client, msg, data, readAt)
+	}
+}
+
+// handleClientMessage 处理一条已解析并通过校验的前端消息，带序号的消息按序号顺序调用
+func (s *RelaySession) handleClientMessage(client *wsClientConn, msg WebSocketMessage, data []byte, readAt time.Time) {
+	if msg.Action != ActionHello && msg.Action != ActionResume {
+		var ok bool
+		if msg, data, ok = s.hookClientMessage(client, msg, data); !ok {
+			return
+		}
+	}
+	s.traceClientReceive(&msg, readAt)
+	s.fingerprintMessage(client, msg, readAt)
+	// 分组和通道控制消息由 hub 处理，未打开的通道上的消息不转发
+	if s.handleNotifyAck(client, msg) || s.handleGroupControl(client, msg) || s.handleChannelControl(client, msg, data) || s.handleStepUp(client, msg) || !s.checkChannel(client, msg) {
+		return
+	}
+	if s.dropDuplicate(client, msg, len(data)) || s.rejectReadOnly(client, msg, len(data)) || s.rejectE2E(client, msg, len(data)) {
+		return
+	}
+	if msg.Action != ActionHello && msg.Action != ActionResume && !s.allowClientMessage(client, msg, len(data)) {
+		return
+	}
+	if s.requireStepUp(client, msg, data) {
+		return
+	}
+	s.dispatchClientMessage(client, msg, data)
+}
+
+// dispatchClientMessage 根据 msg.Action 判断是本地处理、按路由转发还是转发给主 agent
+func (s *RelaySession) dispatchClientMessage(client *wsClientConn, msg WebSocketMessage, data []byte) {
+	route, routed := s.route(msg.Action)
+	if msg.Action == ActionHello {
+		s.handleHello(client, msg)
+	} else if msg.Action == ActionResume {
+		s.handleResume(client, msg)
+	} else if routed && route.Local {
+		if s.reassembleLocal(client, &msg) {
+			s.handleLocal(client, msg)
+		}
+	} else if routed {
+		s.relayRouted(client, msg, route.Endpoint, data)
+	} else if _, ok := localHandler(msg.Action); ok {
+		if s.reassembleLocal(client, &msg) {
+			s.handleLocal(client, msg)
+		}
+	} else {
+		s.relayToAgent(client, msg, data)
+	}
+}
+
+// relayToAgent 把前端消息转发给会话的主 agent
+func (s *RelaySession) relayToAgent(client *wsClientConn, msg WebSocketMessage, data []byte) {
+	data, span := s.traceAgentForward(msg, data, "main")
+	defer span.End()
+	// 在转发前先检查 Agent 是否正在重连，重连期间暂存消息
+	if queued, ok := s.enqueuePending(data); queued {
+		span.SetAttr("wshub.queued", ok)
+		if ok {
+			s.auditRequest(client, msg, len(data), "", AuditQueued, nil)
+		} else {
+			s.auditRequest(client, msg, len(data), "", AuditDropped, errSendQueueFull)
+		}
+		notify := WebSocketMessage{
+			Type:      MessageTypeNotify,
+			RequestID: msg.RequestID,
+			Action:    "reconnecting",
+			Data:      "Agent connection is reconnecting, please wait",
+		}
+		if !ok {
+			notify.Action = "queue_overflow"
+			notify.Data = "Agent connection is reconnecting and pending queue is full, message dropped"
+			notify.Error = &MessageError{Code: ErrCodeQueueFull, Reason: "pending queue is full"}
+		}
+		s.notifyClient(client, notify)
+		return
+	}
+	s.bytesFromClient.Add(int64(len(data)))
+	s.msgsFromClient.Add(1)
+	s.touch()
+	s.trackRequest(msg.RequestID)
+	s.recordRelayed("client_to_agent", len(data))
+	s.agentMu.Lock()
+	err := errSendClosed
+	if s.agent != nil {
+		s.traceFrame(FrameAgentOut, data)
+		err = s.agent.Send(data)
+	}
+	s.agentMu.Unlock()
+	if err != nil {
+		s.auditRequest(client, msg, len(data), "", AuditSendFailed, err)
+		s.notifySendFailure(client, msg.RequestID, err)
+	} else {
+		s.auditRequest(client, msg, len(data), "", AuditRelayed, nil)
+	}
+	s.checkMemoryLimit()
+}
+
+// notifySendFailure 前端消息没有进入 agent 的发送队列时告知发送方，避免请求静默丢失
+func (s *RelaySession) notifySendFailure(client *wsClientConn, requestID string, err error) {
+	log.Printf("Session %s agent send error: %v", s.token, err)
+	hubMetrics.Inc("hub_send_failures_total", "leg", "agent")
+	code := ErrCodeAgentUnavailable
+	if errors.Is(err, errSendQueueFull) {
+		code = ErrCodeQueueFull
+	}
+	s.notifyError(client, requestID, code, "message was not delivered to agent: "+err.Error())
+}
+
+// agentReadLoop 处理远程 Agent 发来的消息，并按会话的重连策略重连（指数退避）
+func (s *RelaySession) agentReadLoop() {
+	retryCount := 0
+	stableSince := hubClock.Now()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+
+		s.agentMu.Lock()
+		curAgent := s.agent
+		s.agentMu.Unlock()
+		if curAgent == nil {
+			log.Println("No agent connection present, exiting agentReadLoop")
+			return
+		}
+
+		msgType, data, err := readMessage(curAgent.conn)
+		if errors.Is(err, websocket.ErrReadLimit) {
+			s.notifyAgentOversized()
+		}
+		if err != nil {
+			log.Println("Agent read error:", err)
+			retryCount++
+			if s.reconnect.exhausted(retryCount) {
+				// 超过重试次数后发送通知给前端并退出
+				notify := WebSocketMessage{
+					Type:   MessageTypeNotify,
+					Action: "exit",
+					Data:   "Agent connection lost after maximum retries",
+				}
+				s.sendNotify(notify)
+				s.persistPending()
+				s.notifyAgentFailure(retryCount)
+				s.setEndReason(EndReasonAgentFailure)
+				hubClock.Sleep(1 * time.Second)
+				s.cleanup()
+				return
+			}
+			// 标记 Agent 正在重连
+			s.stateMu.Lock()
+			s.agentReconnecting = true
+			s.stateMu.Unlock()
+			// 使用指数退避计算重试等待时间
+			waitTime := s.reconnect.backoff(retryCount)
+			log.Printf("Attempting to reconnect agent, attempt %d, waiting %v", retryCount, waitTime)
+			newAgent, err := s.reconnectAgent(waitTime)
+			if err != nil {
+				log.Println("Reconnect remote agent error:", err)
+				continue
+			}
+			// 重连成功后清除重连状态，补发暂存消息，并通知客户端
+			s.agentMu.Lock()
+			if s.agent != nil && s.agent != newAgent {
+				s.agent.conn.Close()
+				s.agent.closeSend()
+			}
+			s.agent = newAgent
+			s.flushPending()
+			s.agentMu.Unlock()
+			s.reconnects.Add(1)
+			hubMetrics.Inc("hub_agent_reconnects_total")
+			s.resetChannels("agent reconnected")
+			stableSince = hubClock.Now()
+			notify := WebSocketMessage{
+				Type:   MessageTypeNotify,
+				Action: "reconnect_success",
+				Data:   "Agent connection re-established",
+			}
+			s.sendNotify(notify)
+			// 重连成功后继续后续逻辑
+			continue
+		}
+		// 成功读取消息时重试计数器归零，配置了重置窗口时要求重连后已稳定保持该时长
+		if retryCount > 0 && hubClock.Now().Sub(stableSince) >= s.reconnect.ResetWindow.D() {
+			retryCount = 0
+		}
+
+		s.traceFrame(FrameAgentIn, data)
+		if !s.injectChaos(ChaosLegAgent, func() { curAgent.conn.Close() }) {
+			continue
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		// 处理 Agent 的心跳
+		if strings.TrimSpace(string(data)) == "ping" {
+			s.agentMu.Lock()
+			if s.agent != nil {
+				_ = s.agent.Send([]byte(MessageTypePong))
+			}
+			s.agentMu.Unlock()
+			_ = curAgent.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+			continue
+		}
+		if s.relayRawToClients(msgType, data) {
+			continue
+		}
+		data, ok := s.hookAgentMessage(data)
+		if !ok {
+			continue
+		}
+		s.throttleAgentMessage(len(data))
+		// 转发消息给全部前端
+		s.bytesFromAgent.Add(int64(len(data)))
+		s.msgsFromAgent.Add(1)
+		s.touch()
+		s.completeRequest(data)
+		s.auditResponse(data, "")
+		s.recordRelayed("agent_to_client", len(data))
+		if s.agentGroupPublish(data) {
+			continue
+		}
+		deliver := s.traceAgentResponse(data)
+		if ch, action := channelOf(data); ch != "" {
+			s.deliverChannel(ch, action, data)
+		} else {
+			s.broadcast(s.recordReplay(data))
+		}
+		deliver.End()
+		s.checkMemoryLimit()
+	}
+}
+
+// reconnectAgent 重新建立 agent 连接：主动拨号模式下等待退避时间后重新拨号，
+// 反向注册模式（endpoint 为空）下在退避时间内等待 agent 重新连入
+func (s *RelaySession) reconnectAgent(wait time.Duration) (*wsAgentConn, error) {
+	var newAgent *wsAgentConn
+	if s.endpoint == nil {
+		agent, err := s.waitAgent(wait)
+		if err != nil {
+			return nil, err
+		}
+		newAgent = agent
+	} else {
+		select {
+		case <-hubClock.After(wait):
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+		agent, err := dialAgent(s.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		newAgent = agent
+	}
+	_ = newAgent.conn.SetReadDeadline(time.Now().Add(AgentInitialDeadline))
+	return newAgent, nil
+}
+
+// enqueuePending 在 agent 重连期间暂存客户端消息
+// queued 表示 agent 正在重连（消息不应直接转发），ok 表示消息已放入队列而不是因队列满被丢弃
+func (s *RelaySession) enqueuePending(data []byte) (queued bool, ok bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if !s.agentReconnecting {
+		return false, false
+	}
+	if !s.feature(FlagPendingQueue) || len(s.pending) >= hubConfig.PendingQueueSize {
+		log.Println("Session", s.token, "pending queue is full, dropping message")
+		return true, false
+	}
+	s.pending = append(s.pending, data)
+	return true, true
+}
+
+// flushPending 清除重连状态并将暂存消息按顺序发给新的 agent，调用方需持有 agentMu，
+// 以保证暂存消息先于重连后新到达的消息发出
+func (s *RelaySession) flushPending() {
+	s.stateMu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.agentReconnecting = false
+	s.stateMu.Unlock()
+
+	if len(pending) > 0 {
+		log.Printf("Session %s flushing %d pending messages", s.token, len(pending))
+	}
+	for i, data := range pending {
+		if err := s.agent.Send(data); err != nil {
+			log.Printf("Session %s flushing pending messages stopped after %d: %v", s.token, i, err)
+			hubMetrics.Add("hub_send_failures_total", int64(len(pending)-i), "leg", "agent")
+			break
+		}
+	}
+}
+
+// goLoop 在新的 goroutine 中运行会话的读写循环
+func (s *RelaySession) goLoop(fn func()) {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		fn()
+	}()
+}
+
+// cleanup 关闭整个会话，同时关闭 send 通道避免 goroutine 泄漏
+func (s *RelaySession) cleanup() {
+	s.once.Do(func() {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		s.cancelResumeHold()
+		s.stateMu.Lock()
+		frame := closeFrameFor(s.endReason)
+		s.stateMu.Unlock()
+		s.clientMu.Lock()
+		for _, client := range s.clients {
+			client.writeCloseFrame(frame.code, frame.reason, cleanupCloseTimeout)
+			client.conn.Close()
+			client.closeSend()
+			relayHub.leaveAllGroups(client)
+		}
+		s.clients = nil
+		s.clientMu.Unlock()
+		s.agentMu.Lock()
+		if s.agent != nil {
+			s.agent.conn.Close()
+			s.agent.closeSend()
+			s.agent = nil
+		}
+		s.agentMu.Unlock()
+		s.closeRouted()
+		s.stopFrameTrace()
+		s.hookEnd()
+		// 关闭尚未被会话接收的反向注册 agent
+		select {
+		case agent := <-s.agentReady:
+			agent.conn.Close()
+			agent.closeSend()
+		default:
+		}
+		relayHub.removeSession(s.token)
+		if hubReports != nil {
+			hubReports.recordSession(s)
+		}
+	})
+}
+
+// cleanupAgent 只清理 Agent 连接
+func (s *RelaySession) cleanupAgent() {
+	s.agentMu.Lock()
+	if s.agent != nil {
+		s.agent.conn.Close()
+		s.agent.closeSend()
+		s.agent = nil
+	}
+	s.agentMu.Unlock()
+
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if len(s.clients) == 0 && s.agent == nil {
+		relayHub.removeSession(s.token)
+	}
+}
+
+// -----------------------
+// RelayHub：管理所有会话
+// -----------------------
+
+type RelayHub struct {
+	sessions     map[string]*RelaySession
+	sessionsByIP map[string]int          // 来源 IP -> 会话数，用于单 IP 会话上限
+	agents       map[string]*wsAgentConn // 已反向注册、尚未与前端配对的 agent
+	mu           sync.Mutex
+
+	groupMu sync.Mutex
+	groups  map[string]map[*wsClientConn]*RelaySession // 会话分组名 -> 成员前端及其所属会话
+}
+
+func NewRelayHub() *RelayHub {
+	return &RelayHub{
+		sessions:     make(map[string]*RelaySession),
+		sessionsByIP: make(map[string]int),
+		agents:       make(map[string]*wsAgentConn),
+		groups:       make(map[string]map[*wsClientConn]*RelaySession),
+	}
+}
+
+// getSession 返回 token 的会话，不存在时以 ip 为来源创建，超出会话数上限时返回错误
+func (h *RelayHub) getSession(token, ip string) (*RelaySession, *sessionLimitError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess, exists := h.sessions[token]
+	if !exists {
+		if err := h.checkSessionLimit(ip); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sess = &RelaySession{
+			token:      token,
+			createdIP:  ip,
+			ctx:        ctx,
+			cancel:     cancel,
+			createdAt:  time.Now(),
+			cohorts:    assignCohorts(token),
+			agentReady: make(chan *wsAgentConn, 1),
+			reconnect:  reconnectPolicyFor(token),
+			limiter:    newSessionLimiter(rateLimitFor(token)),
+			dedup:      newRequestDedup(hubConfig.Dedup),
+			rawAll:     hubConfig.RawRelay.Enabled && containsString(hubConfig.RawRelay.Tokens, token),
+		}
+		sess.touch()
+		sess.startIdleTimer()
+		h.sessions[token] = sess
+		if ip != "" {
+			h.sessionsByIP[ip]++
+		}
+	}
+	return sess, nil
+}
+
+// listSessions 返回当前全部会话的快照
+func (h *RelayHub) listSessions() []*RelaySession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions := make([]*RelaySession, 0, len(h.sessions))
+	for _, sess := range h.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+func (h *RelayHub) findSession(token string) *RelaySession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[token]
+}
+
+func (h *RelayHub) hasSession(token string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, exists := h.sessions[token]
+	return exists
+}
+
+func (h *RelayHub) removeSession(token string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess, exists := h.sessions[token]
+	if !exists {
+		return
+	}
+	delete(h.sessions, token)
+	if ip := sess.createdIP; ip != "" {
+		if h.sessionsByIP[ip]--; h.sessionsByIP[ip] <= 0 {
+			delete(h.sessionsByIP, ip)
+		}
+	}
+}
+
+var relayHub = NewRelayHub()
+
+// -----------------------
+// HTTP 入口：建立前端连接并主动拨号建立 Agent 连接
+// -----------------------
+
+func HandleConnection(c echo.Context) error {
+	// 识别调用方身份（token 或客户端证书），token 在响应头中返回
+	ident, err := authProvider.Authenticate(c.Request())
+	if err != nil {
+		log.Println("Client auth error:", err)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	token := ident.Token
+	if err := authorizeAgent(ident, token); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	origin := resolveOrigin(c.Request())
+	if err := checkOrigin(origin, ident); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if _, _, err := accessWindowEnd(token, ident.Subject, time.Now()); err != nil {
+		return rejectAccessWindow(c, ident)
+	}
+	if hubDraining.Load() {
+		return rejectDraining(c)
+	}
+	// 维护模式下只有白名单中的 token 或身份能创建新会话，已存在的会话可以继续连接
+	if !hubMaintenance.Allows(ident) && !relayHub.hasSession(token) {
+		log.Printf("Reject token %s during maintenance", token)
+		return rejectMaintenance(c)
+	}
+	encoding, err := requestEncoding(c.Request())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	// 需要创建新会话时先检查会话数上限，超出时在升级前拒绝
+	if err := relayHub.admitSession(token, origin.IP); err != nil {
+		log.Printf("Reject token %s from %s: %v", token, origin.IP, err)
+		return rejectSessionLimit(c, err)
+	}
+	// 升级前端 WS 连接
+	respHeader := affinityHeader(c.Request(), token, subprotocolHeader(c.Request(), ident))
+	clientConn, err := upgrader.Upgrade(c.Response(), c.Request(), respHeader)
+	if err != nil {
+		log.Println("Client upgrade error:", err)
+		return err
+	}
+	client := &wsClientConn{
+		conn:     clientConn,
+		send:     make(chan clientFrame, 1000),
+		sendHigh: priorityLane[clientFrame](1000),
+		sendLow:  priorityLane[clientFrame](1000),
+		overflow: hubConfig.ClientOverflowPolicy,
+		codec:    codecFor(encoding),
+		encoding: encoding,
+		caps:     clientCapabilities{compressed: negotiatedCompression(c.Request())},
+		ident:    ident,
+		origin:   origin,
+	}
+	log.Printf("Client %s connected to session %s from %s", ident.Subject, token, origin)
+	fingerprintConnect(client, token, c.Request())
+	setupKeepalive(clientConn)
+	setupCompression(clientConn)
+
+	// 获取或创建 session，同一 token 可以有多个前端连接；升级期间其它连接占满了名额时关闭本连接
+	session, limitErr := relayHub.getSession(token, origin.IP)
+	if limitErr != nil {
+		log.Printf("Reject token %s from %s: %v", token, origin.IP, limitErr)
+		hubMetrics.Inc("hub_session_limit_rejections_total", "scope", limitErr.Scope)
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, limitErr.Error())
+		_ = clientConn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		clientConn.Close()
+		return nil
+	}
+	session.addClient(client)
+	session.goLoop(client.writePump)
+	session.watchAccessWindow(client)
+
+	// 会话已由其它前端启动时，只需启动本连接的读循环
+	started := session.markStarted(client)
+	client.gateCompression(session.feature(FlagCompression))
+	if !started {
+		log.Printf("Client joined existing session %s", token)
+		session.goLoop(func() { session.clientReadLoop(client) })
+		return nil
+	}
+
+	// 优先使用已反向注册的 agent，没有时再按 resolver 解析的地址主动拨号
+	agent := relayHub.takeAgent(token)
+	if agent == nil {
+		select {
+		case agent = <-session.agentReady:
+		default:
+		}
+	}
+	// 启用 Redis 中继时，agent 可能反向注册在其它节点上
+	if agent == nil && hubRedis != nil {
+		agent, err = hubRedis.attach(c.Request().Context(), token)
+		if err != nil {
+			log.Println("Redis attach error:", err)
+		}
+	}
+	if agent == nil {
+		endpoint, err := agentResolver.Resolve(c.Request().Context(), token)
+		if err != nil {
+			log.Println("Resolve remote agent error:", err)
+			session.setEndReason(EndReasonDialFailure)
+			session.cleanup()
+			return err
+		}
+		agent, err = dialAgent(endpoint)
+		if err != nil {
+			log.Println("Dial remote agent error:", err)
+			session.setEndReason(EndReasonDialFailure)
+			session.cleanup()
+			return err
+		}
+		// 记录 Agent 地址用于重连，反向注册的 agent 保持为空
+		session.endpoint = endpoint
+	}
+	_ = agent.conn.SetReadDeadline(time.Now().Add(AgentInitialDeadline))
+	session.agentMu.Lock()
+	session.agent = agent
+	replayed := replayOutbox(token, agent)
+	session.agentMu.Unlock()
+	if replayed > 0 {
+		session.notifyClient(client, WebSocketMessage{
+			Type:   MessageTypeNotify,
+			Action: "outbox_replayed",
+			Data:   map[string]int{"messages": replayed},
+		})
+	}
+
+	// 启动双向中继处理
+	session.hookStart()
+	session.goLoop(func() { session.clientReadLoop(client) })
+	session.goLoop(session.agentReadLoop)
+
+	return nil
+}
+
+// -----------------------
+// 初始化和 Echo 路由设置
+// -----------------------
+
+// configure 按 hubConfig 初始化各个组件，独立运行和嵌入时共用
+func configure() error {
+	if err := hubConfig.validate(); err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	backgroundCtx, stopBackground = context.WithCancel(context.Background())
+	configureCompression(hubConfig.Compression)
+	if err := configureAgentTLS(hubConfig.AgentTLS); err != nil {
+		return fmt.Errorf("agent TLS config error: %w", err)
+	}
+	mail, err := mailer.New(hubConfig.SMTP, defaultMailTemplates)
+	if err != nil {
+		return fmt.Errorf("SMTP config error: %w", err)
+	}
+	hubMailer = mail
+	if hubConfig.Tracing.Enabled() {
+		tracer, err := tracing.New(hubConfig.Tracing)
+		if err != nil {
+			return fmt.Errorf("tracing config error: %w", err)
+		}
+		hubTracer = tracer
+	}
+	upload2.OnComplete = onUploadComplete
+	term.CloseBehavior = hubConfig.TermCloseBehavior
+	term.ReattachGrace = hubConfig.TermReattachGrace.D()
+	term.Approve = requireApproval
+	if hubConfig.JWT.Enabled() {
+		validator, err := jwtauth.New(hubConfig.JWT)
+		if err != nil {
+			return fmt.Errorf("JWT config error: %w", err)
+		}
+		tokenValidator = validator
+		term.Validator = validator
+	}
+	if hubConfig.Outbox.Dir != "" {
+		box, err := newOutbox(hubConfig.Outbox)
+		if err != nil {
+			return fmt.Errorf("outbox error: %w", err)
+		}
+		hubOutbox = box
+	}
+	if hubConfig.Egress.Enabled() {
+		bridge, err := egress.New(hubConfig.Egress)
+		if err != nil {
+			return fmt.Errorf("egress config error: %w", err)
+		}
+		hubEgress = bridge
+		RegisterInterceptor(egressInterceptor{bridge: bridge})
+	}
+	for _, ch := range hubConfig.ChatOps.Channels {
+		if ch.wants(ChatEventSessionStart) {
+			RegisterInterceptor(chatInterceptor{})
+			break
+		}
+	}
+	if hubConfig.Fingerprint.Enabled {
+		hubFingerprints = newFingerprintStore(hubConfig.Fingerprint)
+	}
+	if hubConfig.ObjectStore.S3.Bucket != "" {
+		sink, err := newObjectSink(hubConfig.ObjectStore)
+		if err != nil {
+			return fmt.Errorf("object store error: %w", err)
+		}
+		hubObjects = sink
+	}
+	if hubConfig.Audit.File != "" {
+		audit, err := newAuditLog(hubConfig.Audit)
+		if err != nil {
+			return fmt.Errorf("audit log error: %w", err)
+		}
+		hubAudit = audit
+	}
+	if hubConfig.Recordings.Dir != "" {
+		store, err := openRecordingStore(hubConfig.Recordings)
+		if err != nil {
+			return fmt.Errorf("recording store error: %w", err)
+		}
+		hubRecordings = store
+	}
+	if hubConfig.Search.Enabled {
+		hubSearch = openSearchIndex(hubConfig.Search)
+	}
+	if hubConfig.Jobs.Enabled() {
+		q, err := newJobQueue(hubConfig.Jobs)
+		if err != nil {
+			return fmt.Errorf("job queue error: %w", err)
+		}
+		hubJobs = q
+		hubJobs.Start()
+	}
+	if hubConfig.Users.Store != "" {
+		store, err := openUserStore(hubConfig.Users)
+		if err != nil {
+			return fmt.Errorf("user store error: %w", err)
+		}
+		hubUsers = store
+	}
+	if trustedProxies, err = parseCIDRs(hubConfig.ClientIP.TrustedProxies); err != nil {
+		return fmt.Errorf("client IP config error: %w", err)
+	}
+	if hubConfig.ClientIP.GeoIP.Enabled() {
+		hubGeoIP, err = geoip.New(hubConfig.ClientIP.GeoIP)
+		if err != nil {
+			return fmt.Errorf("GeoIP config error: %w", err)
+		}
+		log.Printf("GeoIP loaded %d networks", hubGeoIP.Len())
+	}
+	authProvider = TokenAuthProvider{}
+	if hubConfig.LDAP.Server.Enabled() {
+		client, err := ldapauth.New(hubConfig.LDAP.Server)
+		if err != nil {
+			return fmt.Errorf("LDAP config error: %w", err)
+		}
+		hubLDAP = client
+		ldapHostRules = buildLDAPHostRules(hubConfig.LDAP)
+		authProvider = ChainAuthProvider{LDAPAuthProvider{}, TokenAuthProvider{}}
+	}
+	// 客户端证书身份优先于其它凭据；嵌入时由调用方提供 mTLS 监听，同样按 Identities 识别证书
+	if hubConfig.MTLS.ListenAddr != "" || len(hubConfig.MTLS.Identities) > 0 {
+		authProvider = ChainAuthProvider{CertAuthProvider{Identities: hubConfig.MTLS.Identities}, authProvider}
+	}
+	if hubConfig.Users.OIDC.AuthURL != "" {
+		oidcValidator, err = newOIDCValidator(hubConfig.Users.OIDC)
+		if err != nil {
+			return fmt.Errorf("OIDC config error: %w", err)
+		}
+	}
+	hubMaintenance.Set(hubConfig.Maintenance)
+	hubReadOnly.Set(hubConfig.ReadOnly)
+	if hubChaos, err = newChaosInjector(hubConfig.Chaos); err != nil {
+		return fmt.Errorf("chaos config error: %w", err)
+	}
+	term.ReadOnly = hubReadOnly.Enabled
+	hubFeatures = newFeatureFlags(hubConfig.Features)
+	inv, err := loadInventory(hubConfig.InventoryFile)
+	if err != nil {
+		return fmt.Errorf("load inventory error: %w", err)
+	}
+	hubInventory = inv
+	sshPool = sshutil.NewPool(lookupSSHProfile)
+	sshutil.Lookup = lookupSSHProfile
+	sshutil.Credentials = sshCredentials
+	sshutil.HostKeys = hubConfig.SSHHostKeys
+	sshutil.Authorize = authorizeSSHRequest
+	resolver, err := NewAgentResolver(hubConfig.AgentResolver)
+	if err != nil {
+		return fmt.Errorf("agent resolver error: %w", err)
+	}
+	agentResolver = &InventoryResolver{Next: resolver}
+	if hubConfig.Redis.Addr != "" {
+		relay, err := newRedisRelay(hubConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("redis relay error: %w", err)
+		}
+		hubRedis = relay
+		go hubRedis.watchOnline(backgroundCtx)
+	}
+	upload2.ConfigureStaging(upload2.StagingConfig{
+		MemoryDir:      hubConfig.UploadMemoryDir,
+		DiskDir:        hubConfig.UploadDiskDir,
+		SmallFileLimit: hubConfig.UploadSmallFileLimit,
+		MemoryBudget:   hubConfig.UploadMemoryBudget,
+	})
+	err = upload2.ConfigureBlockStore(upload2.BlockStoreConfig{
+		Dir:     hubConfig.UploadBlockStoreDir,
+		GCGrace: hubConfig.UploadBlockGCGrace.D(),
+	})
+	if err != nil {
+		return fmt.Errorf("upload block store error: %w", err)
+	}
+	err = upload2.ConfigureMerge(upload2.MergeConfig{
+		MaxConcurrent:  hubConfig.UploadMergeMaxConcurrent,
+		BytesPerSecond: hubConfig.UploadMergeBytesPerSecond,
+		Nice:           hubConfig.UploadMergeNice,
+		IOClass:        hubConfig.UploadMergeIOClass,
+		IOPriority:     hubConfig.UploadMergeIOPriority,
+	})
+	if err != nil {
+		return fmt.Errorf("upload merge config error: %w", err)
+	}
+	if mode := hubConfig.Cleanup.Mode; mode != CleanupOff && mode != "" && os.Getenv(ListenFDEnv) == "" {
+		lastCleanup = runStartupCleanup(hubConfig.Cleanup)
+	}
+	err = download.ConfigureCache(download.CacheConfig{
+		Dir:         hubConfig.DownloadCacheDir,
+		MaxBytes:    hubConfig.DownloadCacheMaxBytes,
+		MaxFileSize: hubConfig.DownloadCacheMaxFileSize,
+	})
+	if err != nil {
+		return fmt.Errorf("download cache error: %w", err)
+	}
+	hubMetrics.RegisterCollector(collectMetrics)
+	return nil
+}
+
+// backgroundCtx 由 configure 创建，closeServices 时取消，后台任务和 Redis 订阅随之退出
+var (
+	backgroundCtx  = context.Background()
+	stopBackground = context.CancelFunc(func() {})
+)
+
+// startBackground 启动会话清理、指标历史、报告和告警等后台任务
+func startBackground() error {
+	go relayHub.sweep(backgroundCtx, hubConfig.SessionSweepInterval.D())
+	if hubConfig.MetricsHistory.Dir != "" {
+		history, err := newMetricsHistory(hubConfig.MetricsHistory)
+		if err != nil {
+			return fmt.Errorf("metrics history error: %w", err)
+		}
+		hubHistory = history
+		go hubHistory.run(backgroundCtx, hubMetrics)
+	}
+	if hubConfig.Reports.Dir != "" {
+		reports, err := newSessionReports(hubConfig.Reports)
+		if err != nil {
+			return fmt.Errorf("reports error: %w", err)
+		}
+		hubReports = reports
+		go hubReports.run(backgroundCtx)
+	}
+	if len(hubConfig.Alerting.Rules) > 0 {
+		hubAlerter = newAlerter(hubConfig.Alerting)
+		go hubAlerter.run(backgroundCtx)
+	}
+	return nil
+}
+
+// closeServices 关闭 configure 打开的文件和连接，并清空对应的全局变量以便重新 configure
+func closeServices() {
+	stopBackground()
+	if hubEgress != nil {
+		hubEgress.Close()
+		hubEgress = nil
+	}
+	if hubAudit != nil {
+		hubAudit.Close()
+		hubAudit = nil
+	}
+	hubSearch = nil
+	hubObjects = nil
+	if hubJobs != nil {
+		hubJobs.Close()
+		hubJobs = nil
+	}
+	if hubUsers != nil {
+		hubUsers.Close()
+		hubUsers = nil
+	}
+	if hubGeoIP != nil {
+		hubGeoIP.Close()
+		hubGeoIP = nil
+	}
+	if hubLDAP != nil {
+		hubLDAP.Close()
+		hubLDAP = nil
+	}
+	if sshPool != nil {
+		sshPool.Close()
+		sshPool = nil
+	}
+	hubTracer.Close()
+	hubTracer = nil
+}
+
+// collectMetrics 在导出指标前刷新会话数量和各子系统的统计
+func collectMetrics(m *Metrics) {
+	var clients, agents int64
+	sessions := relayHub.listSessions()
+	for _, sess := range sessions {
+		info := sess.info()
+		clients += int64(info.Clients)
+		if info.AgentState == "connected" {
+			agents++
+		}
+	}
+	m.Set("hub_sessions", int64(len(sessions)))
+	m.Set("hub_clients", clients)
+	m.Set("hub_agents_connected", agents)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m.Set("hub_goroutines", int64(runtime.NumGoroutine()))
+	m.Set("hub_heap_bytes", int64(mem.HeapAlloc))
+
+	st := upload2.Stats()
+	m.Set("hub_upload_staging_memory_bytes", st.MemoryBytes)
+	m.Set("hub_upload_staging_chunks_total", st.MemoryChunks, "tier", "memory")
+	m.Set("hub_upload_staging_chunks_total", st.DiskChunks, "tier", "disk")
+	m.Set("hub_upload_staging_spills_total", st.Spills)
+	if hubEgress != nil {
+		es := hubEgress.Stats()
+		m.Set("hub_egress_published_total", es.Published)
+		m.Set("hub_egress_dropped_total", es.Dropped)
+		m.Set("hub_egress_failures_total", es.Failures)
+		m.Set("hub_egress_queued", es.Queued)
+	}
+	ms := upload2.MergeStatsNow()
+	m.Set("hub_upload_merges_running", ms.Running)
+	m.Set("hub_upload_merges_waiting", ms.Waiting)
+
+	if upload2.BlockStoreEnabled() {
+		bs := upload2.BlockStats()
+		m.Set("hub_upload_blocks", bs.Blocks)
+		m.Set("hub_upload_block_bytes", bs.Bytes)
+		m.Set("hub_upload_deduped_blocks_total", bs.DedupedBlocks)
+		m.Set("hub_upload_deduped_bytes_total", bs.DedupedBytes)
+		m.Set("hub_upload_block_gc_removed_total", bs.GCRemoved)
+	}
+
+	ts := term.Stats()
+	m.Set("hub_term_output_writes_total", ts.Writes)
+	m.Set("hub_term_output_frames_total", ts.Frames)
+	m.Set("hub_term_output_frames_saved_total", ts.Writes-ts.Frames)
+	m.Set("hub_term_output_bytes_total", ts.Bytes)
+	m.Set("hub_term_duplicate_inputs_total", term.DuplicateInputs())
+
+	if hubTracer != nil {
+		tr := hubTracer.Stats()
+		m.Set("hub_trace_spans_total", tr.Exported, "result", "exported")
+		m.Set("hub_trace_spans_total", tr.Dropped, "result", "dropped")
+		m.Set("hub_trace_spans_total", tr.Failed, "result", "failed")
+	}
+
+	cs := download.Stats()
+	m.Set("hub_download_cache_bytes", cs.Bytes)
+	m.Set("hub_download_cache_entries", cs.Entries)
+	m.Set("hub_download_cache_requests_total", cs.Hits, "result", "hit")
+	m.Set("hub_download_cache_requests_total", cs.Misses, "result", "miss")
+	m.Set("hub_download_cache_evictions_total", cs.Evictions)
+}
+
+// registerRoutes 注册 agent、管理、登录、审批、自动化和文件接口的路由
+func registerRoutes(e *echo.Echo) {
+	routes := hubConfig.Routes
+	if routes.Client {
+		e.GET("/ws", HandleConnection)
+	}
+	if routes.Terminal {
+		e.GET("/term", term.WsSSHHandler)
+	}
+	e.GET("/metrics", hubMetrics.Handler)
+	e.GET("/agent/ws", HandleAgentConnection)
+	registerAdminRoutes(e)
+
+	// 本地用户登录，签发的 token 用于 WebSocket 和 /api
+	e.POST("/auth/login", LoginHandler)
+	e.GET("/auth/oidc/login", OIDCLoginHandler)
+	e.GET("/auth/oidc/callback", OIDCCallbackHandler)
+
+	// 聊天消息中的终端审批链接，按签名校验
+	e.GET("/approvals/:id/:decision", ApprovalLinkHandler)
+	e.POST("/approvals/:id/:decision", ApprovalLinkHandler)
+
+	// Slack 按钮的交互回调，按 Signing Secret 校验
+	e.POST("/chatops/slack", SlackInteractionHandler)
+
+	// 自动化接口，与管理接口使用同一个令牌，启用授权后也接受用户身份
+	apiGroup := e.Group("/api")
+	apiGroup.Use(apiAuthMiddleware)
+	{
+		apiGroup.POST("/exec", ExecHandler, readOnlyGuard)
+		apiGroup.POST("/exec/batch", BatchExecHandler, readOnlyGuard)
+		apiGroup.POST("/probe", ProbeHandler)
+		apiGroup.POST("/jobs", SubmitJobHandler, jobsEnabled, readOnlyGuard)
+	}
+
+	fileGroup := e.Group("file")
+	fileGroup.Use(uploadOutcomeMiddleware)
+	{
+		if routes.Download {
+			fileGroup.GET("/download", download.DownloadSftpHandler)
+		}
+		fileGroup.POST("/upload", upload2.UploadChunkHandler, readOnlyGuard)
+		// 启用任务队列时合并和清单生成提交为任务，立即返回 202
+		if hubJobs != nil {
+			fileGroup.POST("/merge", MergeJobHandler, readOnlyGuard)
+		} else {
+			fileGroup.POST("/merge", upload2.MergeChunksHandler, readOnlyGuard)
+		}
+		if upload2.BlockStoreEnabled() {
+			fileGroup.POST("/blocks/check", upload2.CheckBlocksHandler)
+			fileGroup.POST("/blocks", upload2.UploadBlockHandler, readOnlyGuard)
+			if hubJobs != nil {
+				fileGroup.POST("/manifests", ManifestJobHandler, readOnlyGuard)
+			} else {
+				fileGroup.POST("/manifests", upload2.CommitManifestHandler, readOnlyGuard)
+			}
+			fileGroup.DELETE("/manifests/:hash", upload2.DeleteManifestHandler, readOnlyGuard)
+		}
+	}
+}
+
+// Main 按 HUB_CONFIG 指定的配置文件独立运行 hub，直到收到退出信号
+func Main() {
+	if path := os.Getenv("HUB_CONFIG"); path != "" {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			log.Fatal("Load config error:", err)
+		}
+		hubConfig = cfg
+	}
+	if err := configure(); err != nil {
+		log.Fatal(err)
+	}
+	defer closeServices()
+
+	e := echo.New()
+	e.Pre(basePathMiddleware)
+	e.IPExtractor = func(r *http.Request) string {
+		if ip := clientIP(r); ip != nil {
+			return ip.String()
+		}
+		return r.RemoteAddr
+	}
+	registerRoutes(e)
+
+	ln, err := hubListener(hubConfig.ListenAddr, hubConfig.ReusePort)
+	if err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	e.Listener = ln
+	e.Server.ConnContext = markUnixPeer
+	scheme := "http"
+	if hubConfig.TLS.Enabled() {
+		tlsCfg, err := serverTLSConfig(hubConfig.TLS)
+		if err != nil {
+			log.Fatal("TLS config error:", err)
+		}
+		// 交接给新进程的仍是底层的 TCP 或 Unix 监听
+		e.Listener = tls.NewListener(ln, tlsCfg)
+		scheme = "https"
+	}
+
+	// 机器客户端的 mTLS 监听与主监听共用路由，身份由客户端证书确定
+	var mtlsServer *http.Server
+	if hubConfig.MTLS.ListenAddr != "" {
+		mtlsLn, err := mtlsListener(hubConfig.MTLS)
+		if err != nil {
+			log.Fatal("mTLS listen error:", err)
+		}
+		mtlsServer = &http.Server{Handler: e}
+		go func() {
+			if err := mtlsServer.Serve(mtlsLn); err != nil && err != http.ErrServerClosed {
+				log.Fatal("mTLS server run error:", err)
+			}
+		}()
+	}
+
+	if err := startBackground(); err != nil {
+		log.Fatal(err)
+	}
+
+	// 交接完成后旧进程主动关闭监听，Serve 因此返回的错误不是故障
+	var handedOver atomic.Bool
+	go func() {
+		log.Printf("Relay server running on %s://%s", scheme, ln.Addr())
+		if err := e.Start(""); err != nil && err != http.ErrServerClosed && !handedOver.Load() {
+			log.Fatal("Server run error:", err)
+		}
+	}()
+	notifyReady()
+
+	// 收到 SIGINT/SIGTERM 后先排空会话再关闭 HTTP 服务；
+	// 收到 SIGHUP 时先把监听交给新进程，等它就绪后关闭本进程的监听，再按同样流程排空退出
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig == syscall.SIGHUP {
+			if _, err := handoverListener(ln); err != nil {
+				log.Println("Listener handover error:", err)
+				continue
+			}
+			// 新连接全部交给新进程，空闲的 keep-alive 连接也不再复用
+			handedOver.Store(true)
+			e.Server.SetKeepAlivesEnabled(false)
+			if err := e.Listener.Close(); err != nil {
+				log.Println("Close listener error:", err)
+			}
+		}
+		log.Println("Shutdown signal received:", sig)
+		break
+	}
+	signal.Stop(sigCh)
+
+	drainTimeout := hubConfig.DrainTimeout.D()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout+5*time.Second)
+	defer cancel()
+	relayHub.drain(shutdownCtx, drainTimeout)
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Println("Server shutdown error:", err)
+	}
+	if mtlsServer != nil {
+		if err := mtlsServer.Shutdown(shutdownCtx); err != nil {
+			log.Println("mTLS server shutdown error:", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 维护模式：开启后只有白名单中的 token 或身份主体能创建新会话，已有会话不受影响
+// -----------------------
+
+type MaintenanceState struct {
+	Enabled   bool       `json:"enabled"`
+	Message   string     `json:"message,omitempty"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`    // 预计结束时间
+	AllowList []string   `json:"allowList,omitempty"` // 会话 token 或身份主体（JWT 的 sub、证书 CN 等）
+}
+
+type maintenanceMode struct {
+	mu    sync.RWMutex
+	state MaintenanceState
+}
+
+func (m *maintenanceMode) Get() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *maintenanceMode) Set(state MaintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+// Allows 判断身份能否在当前状态下创建新会话，按会话 token 和身份主体匹配白名单
+func (m *maintenanceMode) Allows(ident *Identity) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.state.Enabled {
+		return true
+	}
+	for _, allowed := range m.state.AllowList {
+		if allowed == ident.Token || allowed == ident.Subject {
+			return true
+		}
+	}
+	return false
+}
+
+var hubMaintenance = &maintenanceMode{}
+
+// rejectMaintenance 返回 503，并在已知结束时间时设置 Retry-After
+func rejectMaintenance(c echo.Context) error {
+	state := hubMaintenance.Get()
+	hubMetrics.Inc("hub_maintenance_rejections_total")
+	if state.EndsAt != nil {
+		if wait := time.Until(*state.EndsAt); wait > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		}
+	}
+	message := state.Message
+	if message == "" {
+		message = "Hub is under maintenance"
+	}
+	return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+		"error":   "maintenance",
+		"message": message,
+		"endsAt":  state.EndsAt,
+	})
+}
+
+// GetMaintenanceHandler 查询维护模式状态
+func GetMaintenanceHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, hubMaintenance.Get())
+}
+
+// SetMaintenanceHandler 开启/关闭维护模式，请求体为 MaintenanceState
+func SetMaintenanceHandler(c echo.Context) error {
+	var state MaintenanceState
+	if err := c.Bind(&state); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	hubMaintenance.Set(state)
+	return c.JSON(http.StatusOK, state)
+}
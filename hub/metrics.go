@@ -0,0 +1,123 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 指标统计：简单的计数器注册表，按 Prometheus 文本格式输出
+// -----------------------
+
+type Metrics struct {
+	mu         sync.Mutex
+	values     map[string]*atomic.Int64
+	collectors []func(m *Metrics) // 输出前调用，用于采集其它包中的统计值
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		values: make(map[string]*atomic.Int64),
+	}
+}
+
+// metricKey 将指标名和标签拼接为 name{k="v",...} 形式，labels 按 key、value 成对传入
+func metricKey(name string, labels ...string) string {
+	if len(labels) < 2 {
+		return name
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
+
+func (m *Metrics) value(name string, labels ...string) *atomic.Int64 {
+	key := metricKey(name, labels...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		v = &atomic.Int64{}
+		m.values[key] = v
+	}
+	return v
+}
+
+// Add 累加计数器
+func (m *Metrics) Add(name string, delta int64, labels ...string) {
+	m.value(name, labels...).Add(delta)
+}
+
+// Inc 计数器加一
+func (m *Metrics) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+// Set 设置瞬时值（gauge）
+func (m *Metrics) Set(name string, v int64, labels ...string) {
+	m.value(name, labels...).Store(v)
+}
+
+// Get 读取当前值
+func (m *Metrics) Get(name string, labels ...string) int64 {
+	return m.value(name, labels...).Load()
+}
+
+// RegisterCollector 注册一个在输出指标前调用的采集函数
+func (m *Metrics) RegisterCollector(fn func(m *Metrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, fn)
+}
+
+// Snapshot 调用采集函数后返回全部指标的当前值，key 为 name{k="v",...} 形式
+func (m *Metrics) Snapshot() map[string]int64 {
+	m.mu.Lock()
+	collectors := append([]func(m *Metrics){}, m.collectors...)
+	m.mu.Unlock()
+	for _, collect := range collectors {
+		collect(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := make(map[string]int64, len(m.values))
+	for k, v := range m.values {
+		values[k] = v.Load()
+	}
+	return values
+}
+
+// metricName 去掉 key 中的标签部分
+func metricName(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// Handler 以文本格式输出全部指标
+func (m *Metrics) Handler(c echo.Context) error {
+	values := m.Snapshot()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %d\n", k, values[k])
+	}
+	return c.String(http.StatusOK, b.String())
+}
+
+var hubMetrics = NewMetrics()
@@ -0,0 +1,335 @@
+package hub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 指标历史：没有 Prometheus 的部署也能看到趋势。每隔 Interval 把选定指标的当前值追加到按天分割的
+// JSON Lines 文件（metrics-YYYYMMDD.jsonl），超过 Retention 的文件整天删除；
+// GET /admin/metrics/history 按时间范围和步长查询，供图表使用
+// -----------------------
+
+// MetricsHistoryConfig Dir 为空时不启用；Names 为要记录的指标名（不含标签），同名的各标签组合都会记录
+type MetricsHistoryConfig struct {
+	Dir       string   `json:"dir"`
+	Interval  Duration `json:"interval"`
+	Retention Duration `json:"retention"`
+	Names     []string `json:"names,omitempty"`
+}
+
+// defaultHistoryNames 未配置 Names 时记录的指标
+var defaultHistoryNames = []string{
+	"hub_sessions",
+	"hub_clients",
+	"hub_agents_connected",
+	"hub_goroutines",
+	"hub_heap_bytes",
+	"hub_relayed_messages_total",
+	"hub_relayed_bytes_total",
+	"hub_message_errors_total",
+	"hub_send_overflow_total",
+	"hub_slow_consumer_total",
+	"hub_session_evictions_total",
+}
+
+const maxHistoryPoints = 2000
+
+func (cfg MetricsHistoryConfig) validate() error {
+	if cfg.Dir == "" {
+		return nil
+	}
+	if cfg.Interval.D() < time.Second {
+		return errors.New("metricsHistory.interval must be at least 1s")
+	}
+	if cfg.Retention.D() < 24*time.Hour {
+		return errors.New("metricsHistory.retention must be at least 24h")
+	}
+	return nil
+}
+
+// historySample 文件中的一行
+type historySample struct {
+	TS     int64            `json:"ts"` // unix 秒
+	Values map[string]int64 `json:"v"`
+}
+
+// MetricSeries 一个指标（含标签）的历史值，Points 为 [unix 秒, 值]
+type MetricSeries struct {
+	Key    string     `json:"key"`
+	Points [][2]int64 `json:"points"`
+}
+
+type metricsHistory struct {
+	cfg   MetricsHistoryConfig
+	names map[string]bool
+
+	mu   sync.Mutex // 保护当前文件的写入和切换
+	file *os.File
+	day  string
+}
+
+// hubHistory 为 nil 表示未启用
+var hubHistory *metricsHistory
+
+func newMetricsHistory(cfg MetricsHistoryConfig) (*metricsHistory, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	names := cfg.Names
+	if len(names) == 0 {
+		names = defaultHistoryNames
+	}
+	h := &metricsHistory{cfg: cfg, names: make(map[string]bool)}
+	for _, name := range names {
+		h.names[name] = true
+	}
+	return h, nil
+}
+
+// run 定时采样，hub 退出或 ctx 结束时停止
+func (h *metricsHistory) run(ctx context.Context, m *Metrics) {
+	ticker := time.NewTicker(h.cfg.Interval.D())
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil || hubDraining.Load() {
+			break
+		}
+		if err := h.record(now, m.Snapshot()); err != nil {
+			log.Println("Metrics history write error:", err)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+func historyDay(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
+
+func (h *metricsHistory) path(day string) string {
+	return filepath.Join(h.cfg.Dir, "metrics-"+day+".jsonl")
+}
+
+// record 追加一次采样，跨天时切换文件并清理过期文件
+func (h *metricsHistory) record(now time.Time, values map[string]int64) error {
+	sample := historySample{TS: now.Unix(), Values: make(map[string]int64)}
+	for key, v := range values {
+		if h.names[metricName(key)] {
+			sample.Values[key] = v
+		}
+	}
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if day := historyDay(now); day != h.day || h.file == nil {
+		if h.file != nil {
+			h.file.Close()
+			h.file = nil
+		}
+		f, err := os.OpenFile(h.path(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		h.file, h.day = f, day
+		h.prune(now)
+	}
+	_, err = h.file.Write(append(line, '\n'))
+	return err
+}
+
+// prune 删除最后一天早于保留期的文件
+func (h *metricsHistory) prune(now time.Time) {
+	cutoff := historyDay(now.Add(-h.cfg.Retention.D()))
+	for _, day := range h.days() {
+		if day >= cutoff {
+			break
+		}
+		if err := os.Remove(h.path(day)); err != nil {
+			log.Println("Metrics history prune error:", err)
+		}
+	}
+}
+
+// days 按日期升序返回已有的文件
+func (h *metricsHistory) days() []string {
+	matches, _ := filepath.Glob(filepath.Join(h.cfg.Dir, "metrics-*.jsonl"))
+	days := make([]string, 0, len(matches))
+	for _, m := range matches {
+		days = append(days, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), "metrics-"), ".jsonl"))
+	}
+	sort.Strings(days)
+	return days
+}
+
+// query 读取 [from, to] 内的采样；step 大于采样间隔时每个步长只保留最后一个值，
+// 对计数器和瞬时值都能得到正确的趋势。names 为空时返回全部记录的指标
+func (h *metricsHistory) query(names map[string]bool, from, to time.Time, step time.Duration) ([]MetricSeries, error) {
+	series := make(map[string]*MetricSeries)
+	lastBucket := make(map[string]int64)
+	stepSec := int64(step / time.Second)
+	for _, day := range h.days() {
+		if day < historyDay(from) || day > historyDay(to) {
+			continue
+		}
+		if err := h.scan(day, func(s historySample) {
+			if s.TS < from.Unix() || s.TS > to.Unix() {
+				return
+			}
+			for key, v := range s.Values {
+				if len(names) > 0 && !names[metricName(key)] {
+					continue
+				}
+				ms := series[key]
+				if ms == nil {
+					ms = &MetricSeries{Key: key, Points: [][2]int64{}}
+					series[key] = ms
+				}
+				bucket := s.TS
+				if stepSec > 0 {
+					bucket = s.TS - s.TS%stepSec
+				}
+				if n := len(ms.Points); n > 0 && lastBucket[key] == bucket {
+					ms.Points[n-1] = [2]int64{s.TS, v}
+					continue
+				}
+				lastBucket[key] = bucket
+				ms.Points = append(ms.Points, [2]int64{s.TS, v})
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]MetricSeries, 0, len(series))
+	for _, ms := range series {
+		out = append(out, *ms)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (h *metricsHistory) scan(day string, fn func(historySample)) error {
+	f, err := os.Open(h.path(day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		var s historySample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue // 正在追加的行或进程异常退出时留下的不完整行
+		}
+		fn(s)
+	}
+	return scanner.Err()
+}
+
+// parseHistoryTime 接受 RFC3339 或 unix 秒，为空时返回 def
+func parseHistoryTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// MetricsHistoryHandler 查询指标历史：?names=a,b&from=&to=&step=5m，
+// from 默认为一小时前，to 默认为当前时间，未指定 step 时按点数上限自动选择
+func MetricsHistoryHandler(c echo.Context) error {
+	if hubHistory == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "metrics history is not enabled"})
+	}
+	now := time.Now()
+	to, err := parseHistoryTime(c.QueryParam("to"), now)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid to: " + err.Error()})
+	}
+	from, err := parseHistoryTime(c.QueryParam("from"), to.Add(-time.Hour))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid from: " + err.Error()})
+	}
+	if !from.Before(to) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be before to"})
+	}
+
+	interval := hubHistory.cfg.Interval.D()
+	step := interval
+	if v := c.QueryParam("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid step: " + err.Error()})
+		}
+	}
+	if min := to.Sub(from) / maxHistoryPoints; step < min {
+		step = min
+	}
+	if step < interval {
+		step = interval
+	}
+
+	var names map[string]bool
+	if v := c.QueryParam("names"); v != "" {
+		names = make(map[string]bool)
+		for _, name := range strings.Split(v, ",") {
+			names[strings.TrimSpace(name)] = true
+		}
+	}
+	series, err := hubHistory.query(names, from, to, step)
+	if err != nil {
+		log.Println("Metrics history query error:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":   from.Unix(),
+		"to":     to.Unix(),
+		"step":   step.String(),
+		"series": series,
+	})
+}
+
+// MetricsHistoryNamesHandler 返回记录中的指标名
+func MetricsHistoryNamesHandler(c echo.Context) error {
+	if hubHistory == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "metrics history is not enabled"})
+	}
+	names := make([]string, 0, len(hubHistory.names))
+	for name := range hubHistory.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return c.JSON(http.StatusOK, names)
+}
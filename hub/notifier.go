@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"echo_demo/mailer"
+	"echo_demo/upload2"
+	"errors"
+	"log"
+)
+
+// -----------------------
+// 邮件通知：告警的 email 通道、大文件上传完成、agent 重连次数用尽和定期会话报告通过 SMTP 通知运维人员。
+// 服务器、收件人、模板和节流见 Config.SMTP，下面是各事件的内置模板
+// -----------------------
+
+const (
+	MailEventAlert          = "alert"
+	MailEventUploadComplete = "upload_complete"
+	MailEventAgentFailure   = "agent_failure"
+	MailEventReport         = "report"
+)
+
+var defaultMailTemplates = map[string]mailer.Template{
+	MailEventAlert: {
+		Subject: `[{{.State}}] {{.Rule}} {{.Target}}`,
+		Body: `{{.Message}}
+
+Rule: {{.Rule}} ({{.Type}})
+Severity: {{.Severity}}
+Target: {{.Target}}
+Value: {{.Value}}
+Since: {{.StartsAt.Format "2006-01-02 15:04:05 MST"}}
+`,
+	},
+	MailEventUploadComplete: {
+		Subject: `Upload completed: {{.Name}}`,
+		Body: `File {{.Name}} has been uploaded.
+
+Path: {{.Path}}
+Size: {{.Size}} bytes
+Hash: {{.Hash}}
+Finished: {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}}
+`,
+	},
+	MailEventAgentFailure: {
+		Subject: `Agent for session {{.Token}} is unreachable`,
+		Body: `The hub gave up reconnecting to the agent of session {{.Token}} after {{.Retries}} attempts.
+
+Endpoint: {{.Endpoint}}
+Clients disconnected: {{.Clients}}
+`,
+	},
+	MailEventReport: {
+		Subject: `Session report {{.Name}}: {{.Sessions}} sessions, {{.FailedSessions}} failed`,
+		Body: `Session report {{.Name}} ({{.From.Format "2006-01-02"}} - {{.To.Format "2006-01-02"}})
+
+Sessions: {{.Sessions}} ({{.FailedSessions}} failed, average {{.AvgDurationSeconds}}s)
+Bytes from clients: {{.BytesFromClient}}
+Bytes from agents: {{.BytesFromAgent}}
+Agent reconnects: {{.AgentReconnects}}
+Uploads: {{.Uploads}} ({{.UploadBytes}} bytes)
+
+Top users:
+{{range .TopUsers}}  {{.Subject}}: {{.Sessions}} sessions, {{.Bytes}} bytes
+{{end}}
+Recent failures:
+{{range .Failures}}  {{.Time.Format "2006-01-02 15:04:05"}} {{.Token}} {{.Reason}}
+{{end}}`,
+	},
+}
+
+// hubMailer 未配置 SMTP 时 Enabled 返回 false
+var hubMailer *mailer.Mailer
+
+// agentFailureMail agent_failure 邮件的模板数据
+type agentFailureMail struct {
+	Token    string
+	Endpoint string
+	Retries  int
+	Clients  int
+}
+
+// sendMail 在后台发送邮件，节流导致的跳过只计数不记录日志
+func sendMail(event, key string, to []string, data interface{}) {
+	if !hubMailer.Enabled() {
+		return
+	}
+	go func() {
+		err := hubMailer.Send(event, key, to, data)
+		switch {
+		case err == nil:
+			hubMetrics.Inc("hub_mail_sent_total", "event", event)
+		case errors.Is(err, mailer.ErrThrottled):
+			hubMetrics.Inc("hub_mail_throttled_total", "event", event)
+		default:
+			hubMetrics.Inc("hub_mail_errors_total", "event", event)
+			log.Printf("Send %s mail error: %v", event, err)
+		}
+	}()
+}
+
+// onUploadComplete 作为 upload2.OnComplete，记录到会话报告并按需发送邮件
+func onUploadComplete(u upload2.CompletedUpload) {
+	if hubReports != nil {
+		hubReports.recordUpload(u)
+	}
+	notifyUploadComplete(u)
+}
+
+// notifyUploadComplete 文件不小于 UploadMailMinSize 时发送邮件
+func notifyUploadComplete(u upload2.CompletedUpload) {
+	if hubConfig.UploadMailMinSize <= 0 || u.Size < hubConfig.UploadMailMinSize {
+		return
+	}
+	sendMail(MailEventUploadComplete, u.Hash, nil, u)
+}
+
+// notifyAgentFailure agent 重连次数用尽、会话即将关闭时调用
+func (s *RelaySession) notifyAgentFailure(retries int) {
+	if !hubConfig.AgentFailureMail {
+		return
+	}
+	data := agentFailureMail{Token: s.token, Retries: retries, Clients: s.info().Clients}
+	if s.endpoint != nil {
+		data.Endpoint = s.endpoint.URL
+	}
+	sendMail(MailEventAgentFailure, s.token, nil, data)
+}
@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// notify 确认投递：前端在 hello 中协商 notify_ack 后，Actions 中的重要 notify（比如 reconnect_success、exit）
+// 带上会话内递增的 n 字段，前端收到后发送 {"a":"notify_ack","d":{"n":N}}；Timeout 内未确认时重发，
+// 重发 MaxRetries 次仍未确认的前端标记为不健康，停止重发。未协商的前端仍按原方式发送
+// -----------------------
+
+const (
+	FeatureNotifyAck = "notify_ack"
+	ActionNotifyAck  = "notify_ack" // 前端 -> hub，d 为 NotifyAckData，不转发给 agent
+)
+
+// NotifyAckConfig Actions 为空时不接受 notify_ack 协商
+type NotifyAckConfig struct {
+	Actions    []string `json:"actions"`
+	Timeout    Duration `json:"timeout"`
+	MaxRetries int      `json:"maxRetries"`
+}
+
+func (cfg NotifyAckConfig) validate() error {
+	if len(cfg.Actions) == 0 {
+		return nil
+	}
+	if cfg.Timeout.D() <= 0 {
+		return errors.New("notifyAck.timeout must be positive")
+	}
+	if cfg.MaxRetries < 0 {
+		return errors.New("notifyAck.maxRetries must not be negative")
+	}
+	return nil
+}
+
+// NotifyAckData notify_ack 请求的数据
+type NotifyAckData struct {
+	ID int64 `json:"n"`
+}
+
+type pendingNotify struct {
+	data     []byte
+	attempts int
+	timer    *time.Timer
+}
+
+// notifyAcks 单个前端连接等待确认的 notify
+type notifyAcks struct {
+	mu        sync.Mutex
+	enabled   bool
+	unhealthy bool
+	pending   map[int64]*pendingNotify
+}
+
+// ackedNotify 判断 action 是否需要确认投递
+func ackedNotify(action string) bool {
+	return slices.Contains(hubConfig.NotifyAck.Actions, action)
+}
+
+// deliverAcked 为协商了 notify_ack 的前端登记等待确认，再按普通 notify 发送
+func (s *RelaySession) deliverAcked(target *wsClientConn, data []byte, id int64) {
+	s.clientMu.Lock()
+	for _, client := range s.clients {
+		if target == nil || client == target {
+			s.trackNotify(client, id, data)
+		}
+	}
+	s.clientMu.Unlock()
+	s.deliver(target, data, false)
+}
+
+func (s *RelaySession) trackNotify(client *wsClientConn, id int64, data []byte) {
+	a := &client.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.enabled || a.unhealthy {
+		return
+	}
+	if a.pending == nil {
+		a.pending = make(map[int64]*pendingNotify)
+	}
+	p := &pendingNotify{data: data}
+	p.timer = time.AfterFunc(hubConfig.NotifyAck.Timeout.D(), func() { s.retryNotify(client, id) })
+	a.pending[id] = p
+}
+
+// retryNotify 确认超时后重发，超过 MaxRetries 时把前端标记为不健康
+func (s *RelaySession) retryNotify(client *wsClientConn, id int64) {
+	a := &client.acks
+	a.mu.Lock()
+	p, ok := a.pending[id]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	if p.attempts >= hubConfig.NotifyAck.MaxRetries {
+		a.unhealthy = true
+		for _, other := range a.pending {
+			other.timer.Stop()
+		}
+		a.pending = nil
+		a.mu.Unlock()
+		hubMetrics.Inc("hub_notify_ack_unhealthy_total")
+		log.Printf("Session %s client %s did not ack notify %d, marked unhealthy", s.token, client.origin, id)
+		return
+	}
+	p.attempts++
+	p.timer.Reset(hubConfig.NotifyAck.Timeout.D())
+	data := p.data
+	a.mu.Unlock()
+	hubMetrics.Inc("hub_notify_ack_retries_total")
+	s.sendTo(client, data)
+}
+
+// handleNotifyAck 处理前端的确认，返回 true 表示消息已由 hub 处理
+func (s *RelaySession) handleNotifyAck(client *wsClientConn, msg WebSocketMessage) bool {
+	if msg.Action != ActionNotifyAck {
+		return false
+	}
+	var ack NotifyAckData
+	if raw, err := json.Marshal(msg.Data); err == nil {
+		_ = json.Unmarshal(raw, &ack)
+	}
+	a := &client.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p, ok := a.pending[ack.ID]; ok {
+		p.timer.Stop()
+		delete(a.pending, ack.ID)
+		hubMetrics.Inc("hub_notify_acks_total")
+	}
+	return true
+}
+
+// setNotifyAck hello 协商结果，重新协商时清除不健康标记
+func (c *wsClientConn) setNotifyAck(enabled bool) {
+	c.acks.mu.Lock()
+	defer c.acks.mu.Unlock()
+	c.acks.enabled = enabled
+	c.acks.unhealthy = false
+}
+
+func (c *wsClientConn) notifyUnhealthy() bool {
+	c.acks.mu.Lock()
+	defer c.acks.mu.Unlock()
+	return c.acks.unhealthy
+}
+
+// stopNotifyAcks 前端断开时停止重发
+func (c *wsClientConn) stopNotifyAcks() {
+	c.acks.mu.Lock()
+	defer c.acks.mu.Unlock()
+	for _, p := range c.acks.pending {
+		p.timer.Stop()
+	}
+	c.acks.pending = nil
+}
@@ -0,0 +1,139 @@
+package hub
+
+import (
+	"context"
+	"echo_demo/objstore"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// -----------------------
+// 对象存储：把录像、轮转出的审计文件和导出的配置包上传到 S3 或 MinIO，集群中的节点不需要共享磁盘。
+// 键为 {prefix}recordings/{id}.cast|.txt|.json、{prefix}audit/{文件名}、{prefix}bundles/{文件名}。
+// 录像上传成功且不保留本地文件时，读取内容从对象存储取回，启动时从对象存储加载本地没有的录像元数据。
+// 正在写入的审计文件在轮转时才上传。Lifecycle 在启动时写入桶的生命周期规则，前缀相对 prefix
+// -----------------------
+
+// 对象存储中的目录
+const (
+	objectsRecordings = "recordings"
+	objectsAudit      = "audit"
+	objectsBundles    = "bundles"
+)
+
+// ObjectStoreConfig S3.Bucket 为空时不启用；Recordings、Audit、Bundles 选择上传的内容
+type ObjectStoreConfig struct {
+	S3         objstore.Config          `json:"s3"`
+	Prefix     string                   `json:"prefix,omitempty"`
+	Recordings bool                     `json:"recordings"`
+	Audit      bool                     `json:"audit"`
+	Bundles    bool                     `json:"bundles"`
+	KeepLocal  bool                     `json:"keepLocal"` // 上传成功后保留本地文件
+	Lifecycle  []objstore.LifecycleRule `json:"lifecycle,omitempty"`
+}
+
+func (cfg ObjectStoreConfig) validate() error {
+	if cfg.S3.Bucket == "" {
+		return nil
+	}
+	if cfg.S3.Endpoint == "" {
+		return errors.New("objectStore.s3.endpoint is required")
+	}
+	for _, r := range cfg.Lifecycle {
+		if r.ID == "" || r.ExpireDays <= 0 && r.TransitionDays <= 0 {
+			return errors.New("objectStore.lifecycle: each rule needs an id and expireDays or transitionDays")
+		}
+		if r.TransitionDays > 0 && r.StorageClass == "" {
+			return errors.New("objectStore.lifecycle: transitionDays requires storageClass")
+		}
+	}
+	return nil
+}
+
+// objectTimeout 单次上传、下载之外的操作（列表、删除、生命周期）的超时
+const objectTimeout = 30 * time.Second
+
+type objectSink struct {
+	cfg    ObjectStoreConfig
+	client *objstore.Client
+}
+
+// hubObjects 为 nil 表示未启用
+var hubObjects *objectSink
+
+// newObjectSink 生命周期规则写入失败只记录日志，凭据可能没有修改桶配置的权限
+func newObjectSink(cfg ObjectStoreConfig) (*objectSink, error) {
+	client, err := objstore.New(cfg.S3)
+	if err != nil {
+		return nil, err
+	}
+	o := &objectSink{cfg: cfg, client: client}
+	if len(cfg.Lifecycle) > 0 {
+		rules := make([]objstore.LifecycleRule, len(cfg.Lifecycle))
+		for i, r := range cfg.Lifecycle {
+			r.Prefix = cfg.Prefix + r.Prefix
+			rules[i] = r
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+		defer cancel()
+		if err := client.SetLifecycle(ctx, rules); err != nil {
+			log.Printf("Object store lifecycle error: %v", err)
+		}
+	}
+	return o, nil
+}
+
+func (o *objectSink) key(dir, name string) string {
+	return o.cfg.Prefix + dir + "/" + name
+}
+
+// putFile 上传本地文件
+func (o *objectSink) putFile(ctx context.Context, key, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = o.client.Put(ctx, key, f, st.Size(), contentType)
+	o.count("upload", err)
+	return err
+}
+
+func (o *objectSink) count(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	hubMetrics.Inc("hub_object_store_ops_total", "op", op, "result", result)
+}
+
+// uploadAuditBackup 上传轮转出的审计文件，在轮转后的 goroutine 中执行
+func (o *objectSink) uploadAuditBackup(path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := o.putFile(ctx, o.key(objectsAudit, filepath.Base(path)), path, "application/x-ndjson"); err != nil {
+		log.Printf("Upload audit file %s error: %v", path, err)
+		return
+	}
+	if !o.cfg.KeepLocal {
+		os.Remove(path)
+	}
+}
+
+// uploadBundle 保存一份导出的配置包
+func (o *objectSink) uploadBundle(name string, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+	err := o.client.PutBytes(ctx, o.key(objectsBundles, name), data, "application/json")
+	o.count("upload", err)
+	if err != nil {
+		log.Printf("Upload bundle %s error: %v", name, err)
+	}
+}
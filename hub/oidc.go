@@ -0,0 +1,319 @@
+package hub
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"echo_demo/jwtauth"
+	"echo_demo/users"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// OIDC 登录：授权码流程加 PKCE（S256）和 nonce。回调校验 id_token 后，hub 按身份签发会话票据
+// （即登录 token，sid 为登录时指定的会话），票据中的角色由本地用户的角色和 id_token 中的分组映射而来，
+// 中继、终端和文件接口都按这些角色授权。启用本地用户时按 sub 关联用户，否则直接使用 id_token 中的身份
+// -----------------------
+
+// OIDCConfig AuthURL 为空时不启用。id_token 按 IDToken 校验，Audience 为空时使用 ClientID；
+// 首次登录的用户在 AutoCreate 时自动创建，用户名取 UsernameClaim（默认 email，没有时用 sub）。
+// GroupRoles 把 GroupsClaim（默认 groups）中的分组映射为 hub 角色；
+// PostLoginURL 非空时回调成功后跳转到该地址，票据放在 URL 片段中，否则以 JSON 返回。
+// RedirectURL 可以写成 /auth/oidc/callback 这样的相对地址，按请求的协议、主机和 BasePath 补全
+type OIDCConfig struct {
+	AuthURL       string              `json:"authUrl,omitempty"`
+	TokenURL      string              `json:"tokenUrl,omitempty"`
+	ClientID      string              `json:"clientId,omitempty"`
+	ClientSecret  string              `json:"clientSecret,omitempty"` // 公共客户端只用 PKCE 时可以为空
+	RedirectURL   string              `json:"redirectUrl,omitempty"`
+	Scopes        []string            `json:"scopes,omitempty"`
+	IDToken       jwtauth.Config      `json:"idToken"`
+	AutoCreate    bool                `json:"autoCreate,omitempty"`
+	DefaultRoles  []string            `json:"defaultRoles,omitempty"` // 自动创建的用户，以及未启用本地用户时的角色
+	UsernameClaim string              `json:"usernameClaim,omitempty"`
+	GroupsClaim   string              `json:"groupsClaim,omitempty"`
+	GroupRoles    map[string][]string `json:"groupRoles,omitempty"`
+	PostLoginURL  string              `json:"postLoginUrl,omitempty"`
+}
+
+func (o OIDCConfig) validate() error {
+	if o.AuthURL == "" {
+		return nil
+	}
+	if o.TokenURL == "" || o.ClientID == "" || o.RedirectURL == "" {
+		return errors.New("users.oidc requires tokenUrl, clientId and redirectUrl")
+	}
+	if !o.IDToken.Enabled() {
+		return errors.New("users.oidc.idToken requires a key to verify id_token")
+	}
+	return nil
+}
+
+// oidcValidator 校验 OIDC 的 id_token，未启用 OIDC 时为 nil
+var oidcValidator *jwtauth.Validator
+
+func newOIDCValidator(o OIDCConfig) (*jwtauth.Validator, error) {
+	if o.IDToken.Audience == "" {
+		o.IDToken.Audience = o.ClientID
+	}
+	return jwtauth.New(o.IDToken)
+}
+
+const oidcStateCookie = "hub_oidc_state"
+
+// randomString 返回 n 字节随机数的 base64url 编码
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge 按 S256 由 code_verifier 计算 code_challenge
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// OIDCLoginHandler GET /auth/oidc/login，跳转到身份提供方，session 参数在回调时作为票据的 sid。
+// state、code_verifier 和 nonce 保存在短期 cookie 中
+func OIDCLoginHandler(c echo.Context) error {
+	o := hubConfig.Users.OIDC
+	if oidcValidator == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "oidc login is not enabled"})
+	}
+	var values [3]string
+	for i := range values {
+		v, err := randomString(32)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		values[i] = v
+	}
+	state, verifier, nonce := values[0], values[1], values[2]
+	c.SetCookie(&http.Cookie{
+		Name: oidcStateCookie,
+		Value: url.Values{
+			"state":    {state},
+			"verifier": {verifier},
+			"nonce":    {nonce},
+			"session":  {c.QueryParam("session")},
+		}.Encode(),
+		Path:     hubPath("/auth/oidc"),
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   requestScheme(c.Request()) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email"}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {externalURL(c.Request(), o.RedirectURL)},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(o.AuthURL, "?") {
+		sep = "&"
+	}
+	return c.Redirect(http.StatusFound, o.AuthURL+sep+q.Encode())
+}
+
+// OIDCCallbackHandler GET /auth/oidc/callback，用授权码和 code_verifier 换取 id_token，校验后签发会话票据
+func OIDCCallbackHandler(c echo.Context) error {
+	o := hubConfig.Users.OIDC
+	if oidcValidator == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "oidc login is not enabled"})
+	}
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing oidc state"})
+	}
+	// state cookie 只用一次
+	c.SetCookie(&http.Cookie{Name: oidcStateCookie, Path: hubPath("/auth/oidc"), MaxAge: -1, HttpOnly: true})
+	saved, err := url.ParseQuery(cookie.Value)
+	state := saved.Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.QueryParam("state")), []byte(state)) != 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "oidc state mismatch"})
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "oidc: " + e})
+	}
+
+	idToken, err := exchangeOIDCCode(o, externalURL(c.Request(), o.RedirectURL), c.QueryParam("code"), saved.Get("verifier"))
+	if err != nil {
+		log.Println("OIDC token exchange error:", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	claims, err := oidcValidator.Validate(idToken)
+	if err == nil {
+		if nonce, _ := claims.Raw["nonce"].(string); nonce != saved.Get("nonce") {
+			err = errors.New("oidc nonce mismatch")
+		}
+	}
+	if err != nil {
+		hubMetrics.Inc("hub_logins_total", "method", "oidc", "result", "denied")
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	u, err := oidcUser(o, claims)
+	if errors.Is(err, users.ErrNotFound) || errors.Is(err, users.ErrDisabled) {
+		hubMetrics.Inc("hub_logins_total", "method", "oidc", "result", "denied")
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		log.Println("OIDC user error:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	u.Roles = mergeRoles(u.Roles, groupRoles(o, claims))
+	result, err := issueLoginToken(u, saved.Get("session"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	hubMetrics.Inc("hub_logins_total", "method", "oidc", "result", "ok")
+	log.Printf("OIDC login for %s with roles %v", u.Username, u.Roles)
+	if o.PostLoginURL != "" {
+		fragment := url.Values{
+			"token":     {result.Token},
+			"expiresAt": {result.ExpiresAt.UTC().Format(time.RFC3339)},
+		}
+		return c.Redirect(http.StatusFound, externalURL(c.Request(), o.PostLoginURL)+"#"+fragment.Encode())
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// exchangeOIDCCode redirectURI 必须与登录请求中的一致
+func exchangeOIDCCode(o OIDCConfig, redirectURI, code, verifier string) (string, error) {
+	if code == "" {
+		return "", errors.New("missing authorization code")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {o.ClientID},
+		"code_verifier": {verifier},
+	}
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(o.TokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned %d %s", resp.StatusCode, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+// oidcUsername 按 UsernameClaim 取用户名，没有时用 sub
+func oidcUsername(o OIDCConfig, claims *jwtauth.Claims) string {
+	claim := o.UsernameClaim
+	if claim == "" {
+		claim = "email"
+	}
+	if name, _ := claims.Raw[claim].(string); name != "" {
+		return name
+	}
+	return claims.Subject
+}
+
+// oidcUser 未启用本地用户时直接由 id_token 构造身份；否则按 sub 查找用户，找不到且允许自动创建时创建
+func oidcUser(o OIDCConfig, claims *jwtauth.Claims) (users.User, error) {
+	if hubUsers == nil {
+		return users.User{
+			Username:    oidcUsername(o, claims),
+			OIDCSubject: claims.Subject,
+			Roles:       o.DefaultRoles,
+			Tenant:      claims.Tenant,
+		}, nil
+	}
+	u, err := hubUsers.FindUserByOIDC(claims.Subject)
+	if err == nil {
+		if u.Disabled {
+			return users.User{}, users.ErrDisabled
+		}
+		return u, nil
+	}
+	if !errors.Is(err, users.ErrNotFound) || !o.AutoCreate {
+		return users.User{}, err
+	}
+	name := oidcUsername(o, claims)
+	u = users.User{Username: name, OIDCSubject: claims.Subject, Roles: o.DefaultRoles, Tenant: claims.Tenant, CreatedAt: time.Now()}
+	if err := hubUsers.CreateUser(u); err != nil {
+		return users.User{}, err
+	}
+	log.Printf("User %s created on first OIDC login", name)
+	return u, nil
+}
+
+// groupRoles 把 id_token 分组声明映射为 hub 角色，声明可以是字符串数组或空格分隔的字符串
+func groupRoles(o OIDCConfig, claims *jwtauth.Claims) []string {
+	if len(o.GroupRoles) == 0 {
+		return nil
+	}
+	claim := o.GroupsClaim
+	if claim == "" {
+		claim = "groups"
+	}
+	var groups []string
+	switch v := claims.Raw[claim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = strings.Fields(v)
+	}
+	var roles []string
+	for _, g := range groups {
+		roles = append(roles, o.GroupRoles[g]...)
+	}
+	return roles
+}
+
+// mergeRoles 合并并去重，结果排序
+func mergeRoles(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, list := range lists {
+		for _, r := range list {
+			if r != "" && !seen[r] {
+				seen[r] = true
+				roles = append(roles, r)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,371 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 离线 agent 的持久化发件箱：agent 重连次数用尽时，重连期间暂存、尚未发出的前端消息不再丢弃，
+// 而是按 token 追加到 Dir 下的 JSON Lines 文件；该 token 的 agent 下次可用时
+// （反向注册的 agent 连入，或新会话拨号成功）按原顺序补发，超过 MaxAge 的消息丢弃。
+// 反向注册的 agent 在没有会话时也会收到补发，其响应在前端连入后随中继送达
+// -----------------------
+
+// OutboxConfig Dir 为空时不启用；MaxMessages 为每个 token 保存的上限，超过时丢弃最早的消息
+type OutboxConfig struct {
+	Dir         string   `json:"dir"`
+	MaxMessages int      `json:"maxMessages"`
+	MaxAge      Duration `json:"maxAge"`
+}
+
+func (cfg OutboxConfig) validate() error {
+	if cfg.Dir == "" {
+		return nil
+	}
+	if cfg.MaxMessages <= 0 {
+		return errors.New("outbox.maxMessages must be positive")
+	}
+	if cfg.MaxAge.D() <= 0 {
+		return errors.New("outbox.maxAge must be positive")
+	}
+	return nil
+}
+
+// outboxEntry 文件中的一行
+type outboxEntry struct {
+	TS    int64           `json:"ts"` // 保存时间，unix 秒
+	Token string          `json:"token"`
+	Data  json.RawMessage `json:"d"`
+}
+
+// OutboxInfo 管理接口中展示的发件箱
+type OutboxInfo struct {
+	Token    string    `json:"token"`
+	Messages int       `json:"messages"`
+	Oldest   time.Time `json:"oldest"`
+}
+
+type outbox struct {
+	cfg OutboxConfig
+	mu  sync.Mutex // 读改写整个文件，所有 token 共用一把锁
+}
+
+// hubOutbox 为 nil 表示未启用
+var hubOutbox *outbox
+
+func newOutbox(cfg OutboxConfig) (*outbox, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	o := &outbox{cfg: cfg}
+	if err := o.migrateLegacy(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// path token 可能包含任意字符且长度不限，文件名使用 token 的 SHA-256，token 保存在每条记录中
+func (o *outbox) path(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return filepath.Join(o.cfg.Dir, hex.EncodeToString(sum[:])+".jsonl")
+}
+
+// migrateLegacy 旧版本的文件名为 hex 编码的 token，记录中没有 token，改写为当前的格式
+func (o *outbox) migrateLegacy() error {
+	files, err := filepath.Glob(filepath.Join(o.cfg.Dir, "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		entries, err := o.readFile(path)
+		if err != nil || len(entries) == 0 || entries[0].Token != "" {
+			continue
+		}
+		raw, err := hex.DecodeString(strings.TrimSuffix(filepath.Base(path), ".jsonl"))
+		if err != nil {
+			continue
+		}
+		token := string(raw)
+		for i := range entries {
+			entries[i].Token = token
+		}
+		if err := o.writeLocked(token, entries); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLocked 读取 token 的消息并去掉过期的部分，调用方需持有 mu
+func (o *outbox) readLocked(token string) ([]outboxEntry, error) {
+	entries, err := o.readFile(o.path(token))
+	if err != nil {
+		return nil, err
+	}
+	// 只保留属于该 token 的记录
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Token == token {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// readFile 读取文件中未过期的记录，文件不存在时返回空
+func (o *outbox) readFile(path string) ([]outboxEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cutoff := time.Now().Add(-o.cfg.MaxAge.D()).Unix()
+	var entries []outboxEntry
+	expired := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), outboxMaxLine())
+	for scanner.Scan() {
+		var e outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.TS < cutoff {
+			expired++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if expired > 0 {
+		hubMetrics.Add("hub_outbox_expired_total", int64(expired))
+	}
+	return entries, scanner.Err()
+}
+
+// outboxMaxLine 单行上限，消息大小不限制时按 agent 消息上限估计
+func outboxMaxLine() int {
+	limit := hubConfig.ClientMaxMessageSize
+	if limit <= 0 {
+		limit = 16 << 20
+	}
+	return int(limit) + 1<<10
+}
+
+// writeLocked 先写临时文件再 rename，消息为空时删除文件
+func (o *outbox) writeLocked(token string, entries []outboxEntry) error {
+	path := o.path(token)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(o.cfg.Dir, ".outbox-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// store 追加消息，返回写入的条数和因超过上限丢弃的条数；不是合法 JSON 的消息跳过
+func (o *outbox) store(token string, msgs [][]byte) (stored, dropped int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entries, err := o.readLocked(token)
+	if err != nil {
+		return 0, 0, err
+	}
+	now := time.Now().Unix()
+	for _, data := range msgs {
+		if !json.Valid(data) {
+			continue
+		}
+		entries = append(entries, outboxEntry{TS: now, Token: token, Data: data})
+		stored++
+	}
+	if len(entries) > o.cfg.MaxMessages {
+		dropped = len(entries) - o.cfg.MaxMessages
+		entries = entries[dropped:]
+	}
+	if err := o.writeLocked(token, entries); err != nil {
+		return 0, 0, err
+	}
+	hubMetrics.Add("hub_outbox_stored_total", int64(stored))
+	if skipped := len(msgs) - stored; skipped > 0 {
+		hubMetrics.Add("hub_outbox_skipped_total", int64(skipped))
+		log.Printf("Outbox for %s skipped %d messages that are not valid JSON", token, skipped)
+	}
+	if dropped > 0 {
+		hubMetrics.Add("hub_outbox_dropped_total", int64(dropped))
+	}
+	return stored, dropped, nil
+}
+
+// take 取出 token 的全部未过期消息并删除文件
+func (o *outbox) take(token string) ([][]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entries, err := o.readLocked(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.writeLocked(token, nil); err != nil {
+		return nil, err
+	}
+	msgs := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		msgs = append(msgs, e.Data)
+	}
+	return msgs, nil
+}
+
+func (o *outbox) list() ([]OutboxInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	files, err := os.ReadDir(o.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := []OutboxInfo{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".jsonl") {
+			continue
+		}
+		path := filepath.Join(o.cfg.Dir, f.Name())
+		entries, err := o.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			// 全部过期，顺便删除文件
+			_ = os.Remove(path)
+			continue
+		}
+		infos = append(infos, OutboxInfo{Token: entries[0].Token, Messages: len(entries), Oldest: time.Unix(entries[0].TS, 0)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Token < infos[j].Token })
+	return infos, nil
+}
+
+// persistPending agent 重连次数用尽时把暂存消息写入发件箱，并告知前端保存的条数；
+// 写入成功后才从会话中移除这些消息
+func (s *RelaySession) persistPending() {
+	s.stateMu.Lock()
+	pending := s.pending
+	if hubOutbox == nil {
+		s.pending = nil
+	}
+	s.stateMu.Unlock()
+	if hubOutbox == nil || len(pending) == 0 {
+		return
+	}
+	stored, dropped, err := hubOutbox.store(s.token, pending)
+	if err != nil {
+		log.Printf("Session %s outbox store error: %v", s.token, err)
+		return
+	}
+	s.stateMu.Lock()
+	if len(s.pending) >= len(pending) {
+		s.pending = s.pending[len(pending):]
+	}
+	s.stateMu.Unlock()
+	log.Printf("Session %s stored %d pending messages for offline delivery", s.token, stored)
+	s.sendNotify(WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: "outbox_stored",
+		Data:   map[string]int{"messages": stored, "dropped": dropped},
+	})
+}
+
+// replayOutbox 把发件箱中的消息按顺序发给刚可用的 agent，返回补发的条数
+func replayOutbox(token string, agent *wsAgentConn) int {
+	if hubOutbox == nil {
+		return 0
+	}
+	msgs, err := hubOutbox.take(token)
+	if err != nil {
+		log.Printf("Outbox replay for %s error: %v", token, err)
+		return 0
+	}
+	for i, data := range msgs {
+		if err := agent.Send(data); err != nil {
+			// 没有发出的消息放回发件箱，等下次 agent 可用时再补发
+			log.Printf("Outbox replay for %s stopped after %d messages: %v", token, i, err)
+			if _, _, err := hubOutbox.store(token, msgs[i:]); err != nil {
+				log.Printf("Outbox store for %s error: %v", token, err)
+			}
+			msgs = msgs[:i]
+			break
+		}
+	}
+	if len(msgs) > 0 {
+		log.Printf("Replayed %d outbox messages to agent for %s", len(msgs), token)
+		hubMetrics.Add("hub_outbox_replayed_total", int64(len(msgs)))
+	}
+	return len(msgs)
+}
+
+// ListOutboxHandler 列出有待补发消息的 token
+func ListOutboxHandler(c echo.Context) error {
+	if hubOutbox == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "outbox is not enabled"})
+	}
+	infos, err := hubOutbox.list()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, infos)
+}
+
+// PurgeOutboxHandler 丢弃 token 的待补发消息
+func PurgeOutboxHandler(c echo.Context) error {
+	if hubOutbox == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "outbox is not enabled"})
+	}
+	msgs, err := hubOutbox.take(c.Param("token"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]int{"purged": len(msgs)})
+}
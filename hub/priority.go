@@ -0,0 +1,202 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// -----------------------
+// 消息优先级：信封中的 p 为 high 或 low（为空表示普通），未带 p 时按 HighActions、LowActions 归类。
+// 开启后每个连接的发送队列分为高、普通、低三条，writePump 先发高优先级、再发普通、最后发低优先级；
+// 低优先级有积压时，每连续发送 LowShare 帧其它消息后插入一帧低优先级，避免批量传输被交互消息完全饿死。
+// 前端到 agent、agent 到前端两个方向都生效；hub 自己发出的 notify 为高优先级，非必要的 notify 为低优先级。
+// 同一优先级内保持顺序，不同优先级之间不保证顺序。未开启时只有普通队列，不解析帧。
+// 目前没有消息合并，以后实现时合并只应发生在同一队列内
+// -----------------------
+
+// 信封中 p 的取值
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
+// PriorityConfig LowShare 默认 8
+type PriorityConfig struct {
+	Enabled     bool     `json:"enabled"`
+	HighActions []string `json:"highActions,omitempty"` // 比如终端输入、resize、取消
+	LowActions  []string `json:"lowActions,omitempty"`  // 比如文件传输、批量查询
+	LowShare    int      `json:"lowShare"`
+}
+
+func (cfg PriorityConfig) validate() error {
+	if cfg.Enabled && cfg.LowShare <= 0 {
+		return fmt.Errorf("priority.lowShare must be positive")
+	}
+	for _, a := range cfg.HighActions {
+		if containsString(cfg.LowActions, a) {
+			return fmt.Errorf("priority: action %q is both high and low", a)
+		}
+	}
+	return nil
+}
+
+// 发送队列
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+)
+
+// framePriority 返回一帧应进入的队列；非 JSON 的文本心跳为高优先级
+func framePriority(data []byte) int {
+	cfg := hubConfig.Priority
+	if !cfg.Enabled {
+		return laneNormal
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return laneHigh
+	}
+	var head struct {
+		Action   string `json:"a"`
+		Priority string `json:"p"`
+	}
+	if json.Unmarshal(data, &head) != nil {
+		return laneNormal
+	}
+	switch {
+	case head.Priority == PriorityHigh:
+		return laneHigh
+	case head.Priority == PriorityLow:
+		return laneLow
+	case head.Priority != "":
+		return laneNormal
+	case containsString(cfg.HighActions, head.Action):
+		return laneHigh
+	case containsString(cfg.LowActions, head.Action):
+		return laneLow
+	}
+	return laneNormal
+}
+
+// priorityLane 创建高、低优先级队列，未开启时返回 nil，只使用普通队列
+func priorityLane[T any](size int) chan T {
+	if !hubConfig.Priority.Enabled {
+		return nil
+	}
+	return make(chan T, size)
+}
+
+// pickLane 返回 lane 对应的队列，高、低优先级队列不存在时使用普通队列
+func pickLane[T any](lane int, high, normal, low chan T) chan T {
+	switch {
+	case lane == laneHigh && high != nil:
+		return high
+	case lane == laneLow && low != nil:
+		return low
+	}
+	return normal
+}
+
+// laneEvent laneScheduler.next 的结果
+type laneEvent int
+
+const (
+	laneFrame laneEvent = iota
+	lanePing
+	laneClosed
+)
+
+// laneScheduler 在 writePump 中按优先级取出下一帧，只在 writePump 的 goroutine 中使用
+type laneScheduler[T any] struct {
+	high, normal, low chan T
+	lowShare          int
+	streak            int // 上次发送低优先级之后连续发送的其它帧数
+}
+
+func newLaneScheduler[T any](high, normal, low chan T) *laneScheduler[T] {
+	share := hubConfig.Priority.LowShare
+	if share <= 0 {
+		share = 8
+	}
+	return &laneScheduler[T]{high: high, normal: normal, low: low, lowShare: share}
+}
+
+// poll 不阻塞地按优先级取一帧，got 为 false 表示各队列都为空
+func (l *laneScheduler[T]) poll() (v T, got, closed bool) {
+	var ok bool
+	if l.low != nil && l.streak >= l.lowShare {
+		select {
+		case v, ok = <-l.low:
+			l.streak = 0
+			return v, true, !ok
+		default:
+		}
+	}
+	for lane, ch := range [...]chan T{l.high, l.normal, l.low} {
+		if ch == nil {
+			continue
+		}
+		select {
+		case v, ok = <-ch:
+			l.count(lane)
+			return v, true, !ok
+		default:
+		}
+	}
+	return v, false, false
+}
+
+func (l *laneScheduler[T]) count(lane int) {
+	if lane == laneLow {
+		l.streak = 0
+	} else {
+		l.streak++
+	}
+}
+
+// next 返回下一帧，各队列都为空时等待新帧或 ping 定时器
+func (l *laneScheduler[T]) next(ping <-chan time.Time) (T, laneEvent) {
+	if v, got, closed := l.poll(); got {
+		if closed {
+			return v, laneClosed
+		}
+		return v, laneFrame
+	}
+	var v T
+	var ok bool
+	select {
+	case v, ok = <-l.high:
+		l.count(laneHigh)
+	case v, ok = <-l.normal:
+		l.count(laneNormal)
+	case v, ok = <-l.low:
+		l.count(laneLow)
+	case <-ping:
+		return v, lanePing
+	}
+	if !ok {
+		return v, laneClosed
+	}
+	return v, laneFrame
+}
+
+// drain 队列关闭后按优先级取出剩余的帧；各队列在 closeSend 中同时关闭
+func (l *laneScheduler[T]) drain() []T {
+	var out []T
+	for _, ch := range [...]chan T{l.high, l.normal, l.low} {
+		if ch == nil {
+			continue
+		}
+		for v := range ch {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// queueDepth 三条队列中的帧数
+func queueDepth[T any](high, normal, low chan T) int {
+	return len(high) + len(normal) + len(low)
+}
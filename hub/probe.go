@@ -0,0 +1,264 @@
+package hub
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 连通性探测：从 hub 或 agent 所在位置检查 host:port 的 TCP/HTTP 可达性，返回耗时和 TLS 信息，
+// 排查网络问题时不需要打开终端。前端发送 action "probe"，自动化调用 POST /api/probe（只能从 hub 探测）。
+// via 为 "agent" 时请求原样转发给会话的 agent，由 agent 按相同的格式回复
+// -----------------------
+
+const (
+	ProbeTCP   = "tcp"
+	ProbeHTTP  = "http"
+	ProbeHTTPS = "https"
+
+	ProbeViaHub   = "hub"
+	ProbeViaAgent = "agent"
+
+	defaultProbeTimeout = 5 * time.Second
+	maxProbeTimeout     = 30 * time.Second
+)
+
+// CapProbe 从 hub 发起连通性探测
+const CapProbe = "probe"
+
+// ProbeRequest 探测请求，Kind 为 http/https 时 Path 默认为 "/"
+type ProbeRequest struct {
+	Target     string   `json:"target"` // host:port
+	Kind       string   `json:"kind,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	ServerName string   `json:"serverName,omitempty"` // TLS SNI，默认取 Target 的主机名
+	Timeout    Duration `json:"timeout,omitempty"`    // 默认 5s，最长 30s
+	Via        string   `json:"via,omitempty"`        // hub（默认）或 agent
+}
+
+// ProbeTLS 握手得到的 TLS 信息，Verified 为 false 时 VerifyError 说明证书校验失败的原因
+type ProbeTLS struct {
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipherSuite"`
+	ServerName  string    `json:"serverName"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	Verified    bool      `json:"verified"`
+	VerifyError string    `json:"verifyError,omitempty"`
+}
+
+// ProbeResult 探测结果，各阶段耗时均为毫秒，失败时 Error 说明失败的阶段和原因
+type ProbeResult struct {
+	Target     string    `json:"target"`
+	Kind       string    `json:"kind"`
+	Via        string    `json:"via"`
+	OK         bool      `json:"ok"`
+	Address    string    `json:"address,omitempty"` // 实际连接的地址
+	ConnectMs  int64     `json:"connectMs"`
+	TLSMs      int64     `json:"tlsMs,omitempty"`
+	TotalMs    int64     `json:"totalMs"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	TLS        *ProbeTLS `json:"tls,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (req *ProbeRequest) normalize() error {
+	if req.Kind == "" {
+		req.Kind = ProbeTCP
+	}
+	if req.Via == "" {
+		req.Via = ProbeViaHub
+	}
+	switch req.Kind {
+	case ProbeTCP, ProbeHTTP, ProbeHTTPS:
+	default:
+		return fmt.Errorf("unknown probe kind %q", req.Kind)
+	}
+	if req.Via != ProbeViaHub && req.Via != ProbeViaAgent {
+		return fmt.Errorf("unknown probe via %q", req.Via)
+	}
+	host, port, err := net.SplitHostPort(req.Target)
+	if err != nil || host == "" || port == "" {
+		return fmt.Errorf("target must be host:port")
+	}
+	if req.ServerName == "" {
+		req.ServerName = host
+	}
+	if req.Path == "" {
+		req.Path = "/"
+	}
+	return nil
+}
+
+func (req ProbeRequest) timeout() time.Duration {
+	timeout := req.Timeout.D()
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	if timeout > maxProbeTimeout {
+		timeout = maxProbeTimeout
+	}
+	return timeout
+}
+
+// runProbe 在 hub 上执行探测，req 应已 normalize
+func runProbe(ctx context.Context, req ProbeRequest) *ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, req.timeout())
+	defer cancel()
+	result := &ProbeResult{Target: req.Target, Kind: req.Kind, Via: ProbeViaHub}
+	start := time.Now()
+	defer func() {
+		result.TotalMs = time.Since(start).Milliseconds()
+		hubMetrics.Inc("hub_probe_total", "kind", req.Kind, "ok", boolLabel(result.OK))
+	}()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", req.Target)
+	result.ConnectMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = "connect: " + err.Error()
+		return result
+	}
+	defer conn.Close()
+	result.Address = conn.RemoteAddr().String()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if req.Kind == ProbeHTTPS {
+		tlsStart := time.Now()
+		// 证书校验单独进行：校验失败时仍然返回握手信息，便于排查证书问题
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: req.ServerName, InsecureSkipVerify: true})
+		err := tlsConn.HandshakeContext(ctx)
+		result.TLSMs = time.Since(tlsStart).Milliseconds()
+		if err != nil {
+			result.Error = "tls: " + err.Error()
+			return result
+		}
+		result.TLS = probeTLSInfo(tlsConn.ConnectionState(), req.ServerName)
+		conn = tlsConn
+	}
+
+	if req.Kind != ProbeTCP {
+		status, err := probeHTTP(conn, req)
+		if err != nil {
+			result.Error = "http: " + err.Error()
+			return result
+		}
+		result.StatusCode = status
+	}
+	result.OK = true
+	return result
+}
+
+// probeHTTP 在已建立的连接上发送一个 HEAD 请求，只关心能否拿到响应，不判断状态码
+func probeHTTP(conn net.Conn, req ProbeRequest) (int, error) {
+	httpReq, err := http.NewRequest(http.MethodHead, req.Kind+"://"+req.Target+req.Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("User-Agent", "ws-hub-probe")
+	httpReq.Close = true
+	if err := httpReq.Write(conn); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), httpReq)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func probeTLSInfo(state tls.ConnectionState, serverName string) *ProbeTLS {
+	info := &ProbeTLS{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  serverName,
+	}
+	if len(state.PeerCertificates) == 0 {
+		info.VerifyError = "no peer certificate"
+		return info
+	}
+	leaf := state.PeerCertificates[0]
+	info.Subject = leaf.Subject.String()
+	info.Issuer = leaf.Issuer.String()
+	info.NotAfter = leaf.NotAfter
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+	if err != nil {
+		info.VerifyError = err.Error()
+	} else {
+		info.Verified = true
+	}
+	return info
+}
+
+// ProbeHandler 从 hub 探测目标并返回结果，探测失败也返回 200，结果见 ok 和 error
+func ProbeHandler(c echo.Context) error {
+	var req ProbeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	if err := req.normalize(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Via != ProbeViaHub {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "only via=hub is supported over HTTP, use the probe action on a session for agent probes"})
+	}
+	if err := authorize(requestIdentity(c), CapProbe, nil, req.Target); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, runProbe(c.Request().Context(), req))
+}
+
+// handleProbeAction 前端的 probe action：via=agent 时交给 agent；从 hub 探测需要开启 FlagHubProbe，
+// 避免前端借 hub 访问内网地址，并与 HTTP 接口一样检查 probe 权限
+func handleProbeAction(lc *LocalContext, msg WebSocketMessage) (interface{}, error) {
+	raw, err := json.Marshal(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	var req ProbeRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("invalid probe request: %w", err)
+	}
+	if err := req.normalize(); err != nil {
+		return nil, err
+	}
+	if req.Via == ProbeViaAgent {
+		if err := lc.ForwardToAgent(msg); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoReply
+	}
+	if !lc.Feature(FlagHubProbe) {
+		return nil, errors.New("probing from hub is disabled")
+	}
+	if err := authorize(lc.client.ident, CapProbe, nil, req.Target); err != nil {
+		return nil, err
+	}
+	// 探测可能持续到超时，不阻塞读循环
+	go func() {
+		lc.Respond(msg, runProbe(lc.Ctx, req), nil)
+	}()
+	return nil, ErrNoReply
+}
+
+func init() {
+	RegisterLocalHandler("probe", handleProbeAction)
+}
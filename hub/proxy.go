@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 反向代理部署：BasePath 非空时全部路由挂在该前缀下（比如 /hub/admin/sessions），
+// 由 Pre 中间件去掉前缀后再路由，代理不需要改写路径。
+// 直连地址属于 ClientIP.TrustedProxies 或经 Unix 套接字连接时信任 X-Forwarded-Proto 和 X-Forwarded-Host，
+// 用于生成对外的地址（OIDC 回调等相对地址）和判断 Cookie 是否需要 Secure
+// -----------------------
+
+// validateBasePath BasePath 为空或以 / 开头
+func validateBasePath(p string) error {
+	if p != "" && !strings.HasPrefix(p, "/") {
+		return errors.New("basePath must start with /")
+	}
+	return nil
+}
+
+// basePath 规范化后的前缀，不以 / 结尾，未配置时为空
+func basePath() string {
+	return strings.TrimRight(hubConfig.BasePath, "/")
+}
+
+// hubPath 返回对外的路径，path 为路由中注册的路径
+func hubPath(path string) string {
+	return basePath() + path
+}
+
+// basePathMiddleware 去掉请求路径中的 BasePath，不带前缀的请求返回 404
+func basePathMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		prefix := basePath()
+		if prefix == "" {
+			return next(c)
+		}
+		r := c.Request()
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			return echo.ErrNotFound
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r.URL.Path = rest
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+		}
+		return next(c)
+	}
+}
+
+// fromTrustedProxy 请求的直连地址是否为可信代理，经 Unix 套接字的请求总是可信
+func fromTrustedProxy(r *http.Request) bool {
+	_, trusted := peerIP(r)
+	return trusted
+}
+
+// forwardedValue 取转发头的第一个值，即最靠近前端的代理写入的值
+func forwardedValue(r *http.Request, header string) string {
+	v, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.TrimSpace(v)
+}
+
+// requestScheme 前端看到的协议，http 或 https
+func requestScheme(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if proto := strings.ToLower(forwardedValue(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost 前端看到的主机名（含端口）
+func requestHost(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if host := forwardedValue(r, "X-Forwarded-Host"); host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// externalURL 把 path（路由中注册的路径）或以 / 开头的相对地址转换为前端可以访问的绝对地址，已是绝对地址时原样返回
+func externalURL(r *http.Request, path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return path
+	}
+	return requestScheme(r) + "://" + requestHost(r) + hubPath(path)
+}
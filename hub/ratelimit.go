@@ -0,0 +1,229 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// 会话限速：每个会话按方向各有一组令牌桶（消息数和字节数），防止异常的前端压垮 agent。
+// 前端 -> agent 超限时丢弃消息并回复 throttled 通知；agent -> 前端超限时暂停读取 agent，
+// 由 TCP 背压传回 agent，同时通知前端（每秒最多一次）。全局配置可按 token 覆盖，会话创建时确定
+// -----------------------
+
+// ErrCodeRateLimited 前端消息超过会话限速被丢弃
+const ErrCodeRateLimited = "rate_limited"
+
+// throttleNotifyInterval agent -> 前端方向限速通知的最小间隔
+const throttleNotifyInterval = time.Second
+
+// RateLimit 一个方向的限速，速率为 0 表示该项不限制；Burst 为 0 时取一秒的速率
+type RateLimit struct {
+	MessagesPerSecond float64 `json:"messagesPerSecond,omitempty"`
+	MessageBurst      int     `json:"messageBurst,omitempty"`
+	BytesPerSecond    int64   `json:"bytesPerSecond,omitempty"`
+	ByteBurst         int64   `json:"byteBurst,omitempty"`
+}
+
+func (l RateLimit) validate() error {
+	if l.MessagesPerSecond < 0 || l.MessageBurst < 0 || l.BytesPerSecond < 0 || l.ByteBurst < 0 {
+		return errors.New("rate limit values must not be negative")
+	}
+	return nil
+}
+
+// RateLimitConfig 两个方向的限速，零值表示不限速
+type RateLimitConfig struct {
+	ClientToAgent RateLimit `json:"clientToAgent"`
+	AgentToClient RateLimit `json:"agentToClient"`
+}
+
+func (cfg RateLimitConfig) validate() error {
+	if err := cfg.ClientToAgent.validate(); err != nil {
+		return fmt.Errorf("clientToAgent: %w", err)
+	}
+	if err := cfg.AgentToClient.validate(); err != nil {
+		return fmt.Errorf("agentToClient: %w", err)
+	}
+	return nil
+}
+
+// rateLimitFor 返回 token 的限速配置，按 token 配置的优先
+func rateLimitFor(token string) RateLimitConfig {
+	if cfg, ok := hubConfig.TokenRateLimits[token]; ok {
+		return cfg
+	}
+	return hubConfig.RateLimit
+}
+
+// tokenBucket 令牌按 rate 每秒补充，最多 burst 个；令牌可以透支为负数，透支部分由后续补充抵扣
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket rate 为 0 时返回 nil，表示不限制
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// available 是否可以取走 n 个令牌；n 超过 burst 时按 burst 计算，否则大消息永远无法通过
+func (b *tokenBucket) available(n float64) bool {
+	if b == nil {
+		return true
+	}
+	return b.tokens >= min(n, b.burst)
+}
+
+// take 取走 n 个令牌，返回令牌补足前需要等待的时间
+func (b *tokenBucket) take(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// directionLimiter 一个方向的消息数和字节数限速
+type directionLimiter struct {
+	mu         sync.Mutex
+	messages   *tokenBucket
+	bytes      *tokenBucket
+	lastNotify time.Time
+}
+
+// newDirectionLimiter 两项都不限制时返回 nil
+func newDirectionLimiter(l RateLimit) *directionLimiter {
+	d := &directionLimiter{
+		messages: newTokenBucket(l.MessagesPerSecond, float64(l.MessageBurst)),
+		bytes:    newTokenBucket(float64(l.BytesPerSecond), float64(l.ByteBurst)),
+	}
+	if d.messages == nil && d.bytes == nil {
+		return nil
+	}
+	return d
+}
+
+func (d *directionLimiter) refill(now time.Time) {
+	if d.messages != nil {
+		d.messages.refill(now)
+	}
+	if d.bytes != nil {
+		d.bytes.refill(now)
+	}
+}
+
+// allow 两项令牌都足够时取走并返回 true，否则不取走任何令牌
+func (d *directionLimiter) allow(size int) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refill(time.Now())
+	if !d.messages.available(1) || !d.bytes.available(float64(size)) {
+		return false
+	}
+	d.messages.take(1)
+	d.bytes.take(float64(size))
+	return true
+}
+
+// reserve 取走令牌（允许透支），返回发送前需要等待的时间
+func (d *directionLimiter) reserve(size int) time.Duration {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refill(time.Now())
+	return max(d.messages.take(1), d.bytes.take(float64(size)))
+}
+
+// shouldNotify 距上次通知超过 throttleNotifyInterval 时返回 true
+func (d *directionLimiter) shouldNotify() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now := time.Now(); now.Sub(d.lastNotify) >= throttleNotifyInterval {
+		d.lastNotify = now
+		return true
+	}
+	return false
+}
+
+// sessionLimiter 会话两个方向的限速，未限速的方向为 nil
+type sessionLimiter struct {
+	clientToAgent *directionLimiter
+	agentToClient *directionLimiter
+}
+
+func newSessionLimiter(cfg RateLimitConfig) sessionLimiter {
+	return sessionLimiter{
+		clientToAgent: newDirectionLimiter(cfg.ClientToAgent),
+		agentToClient: newDirectionLimiter(cfg.AgentToClient),
+	}
+}
+
+// allowClientMessage 前端消息转发前调用，超限时丢弃消息并通知发送方
+func (s *RelaySession) allowClientMessage(client *wsClientConn, msg WebSocketMessage, size int) bool {
+	if s.limiter.clientToAgent.allow(size) {
+		return true
+	}
+	hubMetrics.Inc("hub_rate_limited_total", "direction", "client_to_agent")
+	s.auditRequest(client, msg, size, "", AuditThrottled, nil)
+	s.notifyClient(client, WebSocketMessage{
+		Type:      MessageTypeNotify,
+		RequestID: msg.RequestID,
+		Action:    "throttled",
+		Data:      "Message rate limit exceeded, message dropped",
+		Error:     &MessageError{Code: ErrCodeRateLimited, Reason: "client to agent rate limit exceeded"},
+	})
+	return false
+}
+
+// throttleAgentMessage agent 消息转发前调用，超限时阻塞读循环直到令牌补足或会话结束
+func (s *RelaySession) throttleAgentMessage(size int) {
+	limiter := s.limiter.agentToClient
+	wait := limiter.reserve(size)
+	if wait <= 0 {
+		return
+	}
+	hubMetrics.Inc("hub_rate_limited_total", "direction", "agent_to_client")
+	if limiter.shouldNotify() {
+		s.sendNotify(WebSocketMessage{
+			Type:   MessageTypeNotify,
+			Action: "throttled",
+			Data:   map[string]interface{}{"direction": "agent_to_client", "delayMs": wait.Milliseconds()},
+		})
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,274 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 透传模式：Tokens 中的会话或 Actions 中的 action，hub 不解析帧，只检查消息类型、长度和帧头中的 a，
+// 直接放入对端的发送队列，用于 hub 不需要查看内容的大流量数据。a 必须是帧的前 rawHeadWindow 个字节中的顶层键，
+// 之前只能有值为标量的键（hub 和 mockagent 的编码顺序 t、r、a 即如此），帧中也不能再有其它可能被解码为 a 的键；
+// 不满足时完整解码一次取 a，与普通方式解码得到的 action 相同，解码失败的帧按普通方式处理。
+// 透传的帧跳过拦截器、授权检查、审计、追踪、序号排序、分组、通道和断线续传缓存，仍然计入限速和会话统计。
+// 以下情况仍按普通方式处理：二进制帧、超过 MaxFrameSize 的帧、只读模式开启时、hub 自己处理、按路由转发或需要二次验证的 action，
+// 以及协商了二进制编码、帧校验、分片或要求端到端加密的前端、打开了逻辑通道的会话。
+// 开启消息优先级时，入队仍会读取帧中的 p 和 a
+// -----------------------
+
+// rawHeadWindow a 及其之前的键所在的帧头长度
+const rawHeadWindow = 256
+
+// RawRelayConfig MaxFrameSize 为 0 表示不限制
+type RawRelayConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Tokens       []string `json:"tokens,omitempty"`  // 整个会话透传
+	Actions      []string `json:"actions,omitempty"` // 只透传这些 action
+	MaxFrameSize int64    `json:"maxFrameSize,omitempty"`
+}
+
+func (cfg RawRelayConfig) validate() error {
+	if cfg.MaxFrameSize < 0 {
+		return errors.New("rawRelay.maxFrameSize must not be negative")
+	}
+	for _, a := range cfg.Actions {
+		if hubActions[a] {
+			return errors.New("rawRelay.actions: " + a + " is handled by the hub")
+		}
+	}
+	return nil
+}
+
+// validateStepUp 需要二次验证的 action 不能透传，否则会跳过验证
+func (cfg RawRelayConfig) validateStepUp(stepUp StepUpConfig) error {
+	for _, a := range cfg.Actions {
+		if stepUp.covers(a) {
+			return errors.New("rawRelay.actions: " + a + " requires step-up verification")
+		}
+	}
+	return nil
+}
+
+// hubActions 由 hub 处理、不能透传的前端 action
+var hubActions = map[string]bool{
+	ActionHello:        true,
+	ActionResume:       true,
+	ActionChannelOpen:  true,
+	ActionChannelClose: true,
+	ActionChannelAck:   true,
+	ActionNotifyAck:    true,
+	ActionGroupJoin:    true,
+	ActionGroupLeave:   true,
+	ActionGroupPublish: true,
+	ActionStepUp:       true,
+}
+
+// escapedActionKeys 转义写法的 a、A，JSON 解码后与 a 相同（Go 按键名匹配字段时不区分大小写）
+var escapedActionKeys = [][]byte{[]byte(`\u0061`), []byte(`\u0041`)}
+
+// frameAction 取出帧中顶层的 a，帧头扫描不能确定时回退到完整解码，不是合法 JSON 对象时返回空
+func frameAction(data []byte) string {
+	if len(data) == 0 || data[0] != '{' {
+		return ""
+	}
+	if action, ok := scanAction(data); ok {
+		return action
+	}
+	var msg struct {
+		Action string `json:"a"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return ""
+	}
+	return msg.Action
+}
+
+// scanAction 不完整解析 JSON，从帧头中取出顶层的 a，a 之前的键的值只能是不含转义的字符串、数字或字面量；
+// 不符合这些条件、帧不以 } 结尾（可能被截断）或帧中还有其它可能被解码为 a 的键时 ok 为 false
+func scanAction(data []byte) (action string, ok bool) {
+	if tail := bytes.TrimRight(data, " \t\r\n"); tail[len(tail)-1] != '}' {
+		return "", false
+	}
+	for _, k := range escapedActionKeys {
+		if bytes.Contains(data, k) {
+			return "", false
+		}
+	}
+	head := data[:min(len(data), rawHeadWindow)]
+	i := 1
+	for {
+		key, end, ok := rawString(head, skipSpace(head, i))
+		if !ok {
+			return "", false
+		}
+		i = skipSpace(head, end)
+		if i >= len(head) || head[i] != ':' {
+			return "", false
+		}
+		i = skipSpace(head, i+1)
+		if key == "a" {
+			action, end, ok := rawString(head, i)
+			if !ok || hasActionKey(data[end:]) {
+				return "", false
+			}
+			return action, true
+		}
+		if key == "A" {
+			return "", false
+		}
+		if i = rawScalar(head, i); i < 0 {
+			return "", false
+		}
+		i = skipSpace(head, i)
+		if i >= len(head) || head[i] != ',' {
+			return "", false
+		}
+		i++
+	}
+}
+
+// rawString 读取 i 处不含转义的字符串，返回内容和字符串之后的位置
+func rawString(data []byte, i int) (string, int, bool) {
+	if i >= len(data) || data[i] != '"' {
+		return "", 0, false
+	}
+	end := bytes.IndexByte(data[i+1:], '"')
+	if end < 0 || bytes.IndexByte(data[i+1:i+1+end], '\\') >= 0 {
+		return "", 0, false
+	}
+	return string(data[i+1 : i+1+end]), i + end + 2, true
+}
+
+// rawScalar 跳过 i 处的标量值，返回之后的位置，对象、数组和含转义的字符串返回 -1
+func rawScalar(data []byte, i int) int {
+	if _, end, ok := rawString(data, i); ok {
+		return end
+	}
+	j := i
+	for j < len(data) && strings.IndexByte("+-.0123456789Eaeflnrstu", data[j]) >= 0 {
+		j++
+	}
+	if j == i {
+		return -1
+	}
+	return j
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// hasActionKey data 中是否有键 "a" 或 "A"，字符串值中出现的同样写法也算，此时按普通方式处理
+func hasActionKey(data []byte) bool {
+	for i := bytes.IndexByte(data, '"'); i >= 0 && i+2 < len(data); {
+		if (data[i+1] == 'a' || data[i+1] == 'A') && data[i+2] == '"' {
+			if j := skipSpace(data, i+3); j < len(data) && data[j] == ':' {
+				return true
+			}
+		}
+		next := bytes.IndexByte(data[i+1:], '"')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+// rawRelayed 返回帧是否透传，只检查类型、长度和帧头
+func (s *RelaySession) rawRelayed(msgType int, data []byte) bool {
+	cfg := hubConfig.RawRelay
+	if !cfg.Enabled || msgType != websocket.TextMessage || len(data) == 0 || data[0] != '{' {
+		return false
+	}
+	if cfg.MaxFrameSize > 0 && int64(len(data)) > cfg.MaxFrameSize {
+		return false
+	}
+	if hubReadOnly.Enabled() {
+		return false
+	}
+	action := frameAction(data)
+	if action == "" || !s.rawAll && !containsString(cfg.Actions, action) {
+		return false
+	}
+	if hubActions[action] || hubConfig.StepUp.covers(action) {
+		return false
+	}
+	if _, routed := s.route(action); routed {
+		return false
+	}
+	_, local := localHandler(action)
+	return !local
+}
+
+// rawClient 前端协商的特性需要 hub 处理每一帧时不透传
+func (s *RelaySession) rawClient(client *wsClientConn) bool {
+	if client.codec != nil || client.checksumOn() || client.fragmentOn() || s.e2eRequired(client) {
+		return false
+	}
+	s.chanMu.Lock()
+	defer s.chanMu.Unlock()
+	return len(s.channels) == 0
+}
+
+// relayRawToAgent 透传前端帧，返回 false 表示应按普通方式处理
+func (s *RelaySession) relayRawToAgent(client *wsClientConn, msgType int, data []byte) bool {
+	if !s.rawRelayed(msgType, data) || !s.rawClient(client) {
+		return false
+	}
+	// agent 重连期间走普通路径暂存并通知前端
+	s.stateMu.Lock()
+	reconnecting := s.agentReconnecting
+	s.stateMu.Unlock()
+	if reconnecting {
+		return false
+	}
+	if !s.allowClientMessage(client, WebSocketMessage{}, len(data)) {
+		return true
+	}
+	s.bytesFromClient.Add(int64(len(data)))
+	s.msgsFromClient.Add(1)
+	s.touch()
+	s.recordRelayed("client_to_agent", len(data))
+	hubMetrics.Inc("hub_raw_relayed_total", "direction", "client_to_agent")
+	s.agentMu.Lock()
+	err := errSendClosed
+	if s.agent != nil {
+		err = s.agent.Send(data)
+	}
+	s.agentMu.Unlock()
+	if err != nil {
+		s.notifySendFailure(client, "", err)
+	}
+	s.checkMemoryLimit()
+	return true
+}
+
+// relayRawToClients 透传 agent 帧给全部前端，返回 false 表示应按普通方式处理
+func (s *RelaySession) relayRawToClients(msgType int, data []byte) bool {
+	if !s.rawRelayed(msgType, data) {
+		return false
+	}
+	s.chanMu.Lock()
+	channels := len(s.channels)
+	s.chanMu.Unlock()
+	if channels > 0 {
+		return false
+	}
+	s.throttleAgentMessage(len(data))
+	s.bytesFromAgent.Add(int64(len(data)))
+	s.msgsFromAgent.Add(1)
+	s.touch()
+	s.recordRelayed("agent_to_client", len(data))
+	hubMetrics.Inc("hub_raw_relayed_total", "direction", "agent_to_client")
+	s.broadcast(data)
+	s.checkMemoryLimit()
+	return true
+}
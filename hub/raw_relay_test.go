@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFrameAction(t *testing.T) {
+	long := strings.Repeat("x", rawHeadWindow)
+	tests := []struct {
+		name  string
+		frame string
+		want  string
+	}{
+		{"plain", `{"t":"request","r":"1","a":"ls","d":{"path":"/tmp"}}`, "ls"},
+		{"whitespace", "{ \"t\" : \"request\" ,\n\t\"a\" : \"ls\" }\n", "ls"},
+		{"scalars before action", `{"s":3,"x":true,"y":null,"z":-1.5e3,"a":"ls"}`, "ls"},
+		{"escaped key", `{"\u0061":"ls"}`, "ls"},
+		{"escaped key after action", `{"a":"ls","\u0061":"rm"}`, "rm"},
+		{"upper case key", `{"A":"ls"}`, "ls"},
+		{"escaped value", `{"a":"l\u0073"}`, "ls"},
+		{"nested object before action", `{"d":{"a":"rm"},"a":"ls"}`, "ls"},
+		{"nested array before action", `{"d":["a",{"a":"rm"}],"a":"ls"}`, "ls"},
+		{"action in later string value", `{"a":"ls","d":"say \"a\": hi"}`, "ls"},
+		{"duplicate action", `{"a":"ls","d":1,"a":"rm"}`, "rm"},
+		{"action outside head window", `{"t":"` + long + `","a":"ls"}`, "ls"},
+		{"no action", `{"t":"ping"}`, ""},
+		{"empty action", `{"a":""}`, ""},
+		{"non-string action", `{"a":1}`, ""},
+		{"truncated in head", `{"t":"request","a":"l`, ""},
+		{"truncated after action", `{"a":"ls","d":{"path":"/tm`, ""},
+		{"open brace only", `{`, ""},
+		{"array", `["a","ls"]`, ""},
+		{"empty", ``, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := frameAction([]byte(tt.frame)); got != tt.want {
+				t.Errorf("frameAction(%q) = %q, want %q", tt.frame, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScanActionAmbiguous 帧头扫描只在能确定 action 时返回 ok，其余交给完整解码
+func TestScanActionAmbiguous(t *testing.T) {
+	tests := []struct {
+		frame string
+		ok    bool
+	}{
+		{`{"t":"request","r":"1","a":"ls","d":{"a":1}}`, false},
+		{`{"t":"request","r":"1","a":"ls","d":{"path":"/tmp"}}`, true},
+		{`{"d":{},"a":"ls"}`, false},
+		{`{"a":"ls","A":"rm"}`, false},
+		{`{"a":"ls"`, false},
+	}
+	for _, tt := range tests {
+		if _, ok := scanAction([]byte(tt.frame)); ok != tt.ok {
+			t.Errorf("scanAction(%q) ok = %v, want %v", tt.frame, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 只读模式：事故冻结期间关闭所有写操作——分片上传、合并、块上传、清单的提交和删除、命令执行、任务提交、
+// 终端输入和信号，以及 ReadOnlyActions 中列出的中继 action；下载、列表、探测和终端的只读观察不受影响。
+// 启动状态来自配置，运行中通过 /admin/readonly 切换；管理接口本身不受限制
+// -----------------------
+
+const ErrCodeReadOnly = "read_only"
+
+type ReadOnlyState struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+type readOnlyMode struct {
+	mu    sync.RWMutex
+	state ReadOnlyState
+}
+
+func (m *readOnlyMode) Get() ReadOnlyState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Set 开启时未指定 Since 的以当前时间为准
+func (m *readOnlyMode) Set(state ReadOnlyState) ReadOnlyState {
+	if state.Enabled && state.Since == nil {
+		now := time.Now()
+		state.Since = &now
+	}
+	if !state.Enabled {
+		state.Since = nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+	return state
+}
+
+func (m *readOnlyMode) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state.Enabled
+}
+
+// blocks 判断中继 action 在当前状态下是否被禁止
+func (m *readOnlyMode) blocks(action string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state.Enabled && slices.Contains(hubConfig.ReadOnlyActions, action)
+}
+
+var hubReadOnly = &readOnlyMode{}
+
+func (m *readOnlyMode) message() string {
+	if reason := m.Get().Reason; reason != "" {
+		return reason
+	}
+	return "Hub is in read-only mode"
+}
+
+// readOnlyGuard 挂在写操作的路由上，只读模式下返回 503
+func readOnlyGuard(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !hubReadOnly.Enabled() {
+			return next(c)
+		}
+		hubMetrics.Inc("hub_readonly_rejections_total", "kind", "http")
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error":   ErrCodeReadOnly,
+			"message": hubReadOnly.message(),
+		})
+	}
+}
+
+// rejectReadOnly 只读模式下禁止的中继 action 回复错误且不转发，返回 true 表示调用方不应再处理该消息
+func (s *RelaySession) rejectReadOnly(client *wsClientConn, msg WebSocketMessage, size int) bool {
+	if !hubReadOnly.blocks(msg.Action) {
+		return false
+	}
+	hubMetrics.Inc("hub_readonly_rejections_total", "kind", "relay")
+	s.auditRequest(client, msg, size, "", AuditRejected, errReadOnly)
+	s.notifyClient(client, WebSocketMessage{
+		Type:      MessageTypeNotify,
+		RequestID: msg.RequestID,
+		Action:    ActionError,
+		Data:      hubReadOnly.message(),
+		Error:     &MessageError{Code: ErrCodeReadOnly, Reason: errReadOnly.Error()},
+	})
+	return true
+}
+
+var errReadOnly = errors.New("action is disabled in read-only mode")
+
+// GetReadOnlyHandler 查询只读模式状态
+func GetReadOnlyHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, hubReadOnly.Get())
+}
+
+// SetReadOnlyHandler 开启/关闭只读模式，请求体为 ReadOnlyState
+func SetReadOnlyHandler(c echo.Context) error {
+	var state ReadOnlyState
+	if err := c.Bind(&state); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "参数绑定错误: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, hubReadOnly.Set(state))
+}
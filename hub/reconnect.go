@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// -----------------------
+// agent 重连策略：指数退避，支持最大退避时间、随机抖动和重试计数的重置窗口。
+// 全局策略可按 token 覆盖，会话创建时确定
+// -----------------------
+
+// ReconnectPolicy MaxRetries 为负数表示不限次数；ResetWindow 为 0 时读到任意消息即清零重试计数，
+// 否则重连后需要稳定保持 ResetWindow 才清零，避免反复闪断的 agent 无限重连
+type ReconnectPolicy struct {
+	MaxRetries      int      `json:"maxRetries"`
+	InitialInterval Duration `json:"initialInterval"`
+	MaxBackoff      Duration `json:"maxBackoff,omitempty"` // 0 表示不限制
+	Jitter          float64  `json:"jitter,omitempty"`     // 0~1，按比例随机增减退避时间
+	ResetWindow     Duration `json:"resetWindow,omitempty"`
+}
+
+// DefaultReconnectPolicy 与原来的常量行为一致
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		MaxRetries:      MaxAgentRetries,
+		InitialInterval: Duration(InitialRetryInterval),
+	}
+}
+
+func (p ReconnectPolicy) validate() error {
+	if p.InitialInterval <= 0 {
+		return errors.New("reconnect policy initialInterval must be positive")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.New("reconnect policy jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// exhausted 第 attempt 次重试是否已超过上限
+func (p ReconnectPolicy) exhausted(attempt int) bool {
+	return p.MaxRetries >= 0 && attempt > p.MaxRetries
+}
+
+// backoff 第 attempt 次（从 1 开始）重试前的等待时间
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	wait := time.Duration(math.Pow(2, float64(attempt-1))) * p.InitialInterval.D()
+	if max := p.MaxBackoff.D(); max > 0 && (wait > max || wait <= 0) {
+		wait = max
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// reconnectPolicyFor 返回 token 的重连策略，按 token 配置的优先
+func reconnectPolicyFor(token string) ReconnectPolicy {
+	if p, ok := hubConfig.TokenReconnectPolicies[token]; ok {
+		return p
+	}
+	return hubConfig.ReconnectPolicy
+}
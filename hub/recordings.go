@@ -0,0 +1,684 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"echo_demo/upload2"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 录像库：保存终端录像（asciicast）和文本记录（transcript），每条记录为 Dir 下的 {id}.cast 或 {id}.txt
+// 加上 {id}.json 元数据，启动时加载元数据作为索引。文件先写入 .recording-* 临时文件再重命名，
+// 启动清理删除中断的临时文件、没有元数据的内容文件和内容已丢失的元数据。目前的来源是管理接口导入的外部录像，
+// 导入时校验格式：asciicast v2 逐行校验，v1 转换为 v2 后保存；transcript 必须是不含 NUL 的 UTF-8 文本
+// -----------------------
+
+const (
+	RecordingAsciicast  = "asciicast"
+	RecordingTranscript = "transcript"
+)
+
+// RecordingsConfig Dir 为空时不启用
+type RecordingsConfig struct {
+	Dir           string `json:"dir"`
+	MaxImportSize int64  `json:"maxImportSize"` // 单个导入文件的大小上限（字节）
+}
+
+func (cfg RecordingsConfig) validate() error {
+	if cfg.Dir != "" && cfg.MaxImportSize <= 0 {
+		return errors.New("recordings.maxImportSize must be positive")
+	}
+	return nil
+}
+
+// Recording 录像的元数据；StartedAt、Duration、Width、Height 对 asciicast 取自文件头和事件，导入时可以覆盖 StartedAt
+type Recording struct {
+	ID         string            `json:"id"`
+	Kind       string            `json:"kind"`
+	Source     string            `json:"source"` // import
+	Title      string            `json:"title,omitempty"`
+	Subject    string            `json:"subject,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Token      string            `json:"token,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	StartedAt  time.Time         `json:"startedAt,omitempty"`
+	Duration   float64           `json:"duration,omitempty"` // 秒
+	Width      int               `json:"width,omitempty"`
+	Height     int               `json:"height,omitempty"`
+	Events     int               `json:"events,omitempty"`
+	Size       int64             `json:"size"`
+	SHA256     string            `json:"sha256"`
+	ImportedAt time.Time         `json:"importedAt"`
+	ImportedBy string            `json:"importedBy,omitempty"`
+}
+
+var (
+	errRecordingNotFound  = errors.New("recording not found")
+	errRecordingDuplicate = errors.New("recording with the same content already exists")
+	errRecordingFormat    = errors.New("unrecognized recording format")
+)
+
+type recordingStore struct {
+	cfg RecordingsConfig
+
+	mu      sync.RWMutex
+	entries map[string]Recording
+}
+
+// hubRecordings 为 nil 表示未启用
+var hubRecordings *recordingStore
+
+// openRecordingStore 创建目录并加载已有元数据，无法解析的元数据文件跳过
+func openRecordingStore(cfg RecordingsConfig) (*recordingStore, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &recordingStore{cfg: cfg, entries: make(map[string]Recording)}
+	matches, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil || rec.ID == "" {
+			log.Printf("Skip recording metadata %s: %v", m, err)
+			continue
+		}
+		s.entries[rec.ID] = rec
+	}
+	if s.remote() {
+		s.loadRemote()
+	}
+	return s, nil
+}
+
+// remote 录像是否保存到对象存储
+func (s *recordingStore) remote() bool {
+	return hubObjects != nil && hubObjects.cfg.Recordings
+}
+
+// loadRemote 加载对象存储中本地没有的录像元数据，内容在读取时再取回
+func (s *recordingStore) loadRemote() {
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+	objects, err := hubObjects.client.List(ctx, hubObjects.key(objectsRecordings, ""))
+	hubObjects.count("list", err)
+	if err != nil {
+		log.Printf("List remote recordings error: %v", err)
+		return
+	}
+	loaded := 0
+	for _, obj := range objects {
+		id := strings.TrimSuffix(path.Base(obj.Key), ".json")
+		if !strings.HasSuffix(obj.Key, ".json") || s.entries[id].ID != "" {
+			continue
+		}
+		body, _, err := hubObjects.client.Get(ctx, obj.Key)
+		if err != nil {
+			log.Printf("Fetch recording metadata %s error: %v", obj.Key, err)
+			continue
+		}
+		var rec Recording
+		err = json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&rec)
+		body.Close()
+		if err != nil || rec.ID != id {
+			log.Printf("Skip remote recording metadata %s: %v", obj.Key, err)
+			continue
+		}
+		s.entries[rec.ID] = rec
+		loaded++
+	}
+	if loaded > 0 {
+		log.Printf("Loaded %d recordings from object store", loaded)
+	}
+}
+
+// upload 上传内容和元数据，不保留本地文件时上传成功后删除本地文件
+func (s *recordingStore) upload(rec Recording, meta []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	content := s.contentPath(rec)
+	if err := hubObjects.putFile(ctx, hubObjects.key(objectsRecordings, filepath.Base(content)), content, ""); err != nil {
+		return err
+	}
+	err := hubObjects.client.PutBytes(ctx, hubObjects.key(objectsRecordings, rec.ID+".json"), meta, "application/json")
+	hubObjects.count("upload", err)
+	if err != nil {
+		return err
+	}
+	if !hubObjects.cfg.KeepLocal {
+		os.Remove(content)
+		os.Remove(filepath.Join(s.cfg.Dir, rec.ID+".json"))
+	}
+	return nil
+}
+
+// open 打开录像内容，本地没有时从对象存储读取
+func (s *recordingStore) open(rec Recording) (io.ReadCloser, error) {
+	f, err := os.Open(s.contentPath(rec))
+	if err == nil || !os.IsNotExist(err) || !s.remote() {
+		return f, err
+	}
+	body, _, err := hubObjects.client.Get(context.Background(), hubObjects.key(objectsRecordings, filepath.Base(s.contentPath(rec))))
+	hubObjects.count("download", err)
+	return body, err
+}
+
+func (s *recordingStore) contentPath(rec Recording) string {
+	ext := ".txt"
+	if rec.Kind == RecordingAsciicast {
+		ext = ".cast"
+	}
+	return filepath.Join(s.cfg.Dir, rec.ID+ext)
+}
+
+// add 保存内容和元数据，内容相同（SHA256 一致）的录像只保存一份
+func (s *recordingStore) add(rec Recording, content []byte) (Recording, error) {
+	sum := sha256.Sum256(content)
+	rec.SHA256 = hex.EncodeToString(sum[:])
+	rec.Size = int64(len(content))
+	id, err := randomString(12)
+	if err != nil {
+		return Recording{}, err
+	}
+	rec.ID = id
+
+	s.mu.Lock()
+	for _, e := range s.entries {
+		if e.SHA256 == rec.SHA256 {
+			s.mu.Unlock()
+			return e, errRecordingDuplicate
+		}
+	}
+	if err := s.writeFile(s.contentPath(rec), content); err != nil {
+		s.mu.Unlock()
+		return Recording{}, err
+	}
+	meta, _ := json.MarshalIndent(rec, "", "  ")
+	if err := s.writeFile(filepath.Join(s.cfg.Dir, rec.ID+".json"), meta); err != nil {
+		os.Remove(s.contentPath(rec))
+		s.mu.Unlock()
+		return Recording{}, err
+	}
+	s.entries[rec.ID] = rec
+	s.mu.Unlock()
+
+	if s.remote() {
+		// 上传失败时保留本地文件，不影响导入
+		if err := s.upload(rec, meta); err != nil {
+			log.Printf("Upload recording %s error: %v", rec.ID, err)
+		}
+	}
+	return rec, nil
+}
+
+// writeFile 先写入临时文件再重命名，中断时不会留下不完整的录像或元数据
+func (s *recordingStore) writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.cfg.Dir, ".recording-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// reconcile 启动清理：删除没有元数据的内容文件（元数据写入前中断），ChunkMaxAge 内写入的保留；
+// 本地模式下内容文件已丢失的录像从索引中移除并删除元数据。保存到对象存储时本地文件可能已上传后删除，不检查缺失的内容
+func (s *recordingStore) reconcile(p upload2.CleanupPolicy) []upload2.Orphan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []upload2.Orphan
+	for _, ext := range []string{".cast", ".txt"} {
+		matches, _ := filepath.Glob(filepath.Join(s.cfg.Dir, "*"+ext))
+		for _, m := range matches {
+			if _, ok := s.entries[strings.TrimSuffix(filepath.Base(m), ext)]; ok {
+				continue
+			}
+			info, err := os.Stat(m)
+			if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < p.ChunkMaxAge {
+				continue
+			}
+			o := upload2.Orphan{Kind: "recording", Path: m, Bytes: info.Size(), Action: upload2.OrphanRemoved, Reason: "no metadata"}
+			if p.DryRun {
+				o.Action = upload2.OrphanWouldRemove
+			} else if err := os.Remove(m); err != nil {
+				o.Action, o.Reason = upload2.OrphanFailed, err.Error()
+			}
+			items = append(items, o)
+		}
+	}
+	if s.remote() {
+		return items
+	}
+	for id, rec := range s.entries {
+		if _, err := os.Stat(s.contentPath(rec)); !os.IsNotExist(err) {
+			continue
+		}
+		meta := filepath.Join(s.cfg.Dir, id+".json")
+		o := upload2.Orphan{Kind: "recording_meta", Path: meta, Action: upload2.OrphanRemoved, Reason: "content missing"}
+		if p.DryRun {
+			o.Action = upload2.OrphanWouldRemove
+		} else if err := os.Remove(meta); err != nil && !os.IsNotExist(err) {
+			o.Action, o.Reason = upload2.OrphanFailed, err.Error()
+		} else {
+			delete(s.entries, id)
+		}
+		items = append(items, o)
+	}
+	return items
+}
+
+func (s *recordingStore) get(id string) (Recording, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.entries[id]
+	return rec, ok
+}
+
+func (s *recordingStore) remove(id string) (Recording, error) {
+	s.mu.Lock()
+	rec, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return Recording{}, errRecordingNotFound
+	}
+	delete(s.entries, id)
+	os.Remove(s.contentPath(rec))
+	os.Remove(filepath.Join(s.cfg.Dir, id+".json"))
+	s.mu.Unlock()
+	if s.remote() {
+		ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+		defer cancel()
+		for _, key := range []string{filepath.Base(s.contentPath(rec)), id + ".json"} {
+			err := hubObjects.client.Delete(ctx, hubObjects.key(objectsRecordings, key))
+			hubObjects.count("delete", err)
+			if err != nil {
+				log.Printf("Delete remote recording %s error: %v", key, err)
+			}
+		}
+	}
+	return rec, nil
+}
+
+// RecordingFilter 列表的过滤条件，为空的字段不过滤；时间按 StartedAt（没有时按 ImportedAt）比较
+type RecordingFilter struct {
+	Kind    string
+	Subject string
+	Host    string
+	Token   string
+	Label   string // key=value
+	Text    string // 标题包含的文字，不区分大小写
+	From    time.Time
+	To      time.Time
+}
+
+func (f RecordingFilter) match(rec Recording) bool {
+	if f.Kind != "" && rec.Kind != f.Kind ||
+		f.Subject != "" && rec.Subject != f.Subject ||
+		f.Host != "" && rec.Host != f.Host ||
+		f.Token != "" && rec.Token != f.Token {
+		return false
+	}
+	if f.Label != "" {
+		k, v, _ := strings.Cut(f.Label, "=")
+		if got, ok := rec.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(rec.Title), strings.ToLower(f.Text)) {
+		return false
+	}
+	at := rec.StartedAt
+	if at.IsZero() {
+		at = rec.ImportedAt
+	}
+	if !f.From.IsZero() && at.Before(f.From) || !f.To.IsZero() && !at.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// list 按开始时间倒序返回匹配的录像
+func (s *recordingStore) list(f RecordingFilter) []Recording {
+	s.mu.RLock()
+	out := []Recording{}
+	for _, rec := range s.entries {
+		if f.match(rec) {
+			out = append(out, rec)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].StartedAt, out[j].StartedAt
+		if a.Equal(b) {
+			return out[i].ImportedAt.After(out[j].ImportedAt)
+		}
+		return a.After(b)
+	})
+	return out
+}
+
+// -----------------------
+// 格式校验
+// -----------------------
+
+// asciicastHeader asciicast v2 的第一行
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Duration  float64           `json:"duration,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// asciicastV1 v1 为单个 JSON 对象，stdout 为 [延迟, 数据] 数组
+type asciicastV1 struct {
+	Version  int               `json:"version"`
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Duration float64           `json:"duration"`
+	Title    string            `json:"title,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	Stdout   []json.RawMessage `json:"stdout"`
+}
+
+// detectRecording 按内容判断格式：第一行是 version 为 1 或 2 的 JSON 对象时为 asciicast，其它为 transcript
+func detectRecording(content []byte) string {
+	first, _, _ := bytes.Cut(bytes.TrimLeft(content, " \t\r\n"), []byte("\n"))
+	var head struct {
+		Version int `json:"version"`
+	}
+	if json.Unmarshal(first, &head) == nil && (head.Version == 1 || head.Version == 2) {
+		return RecordingAsciicast
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) && json.Unmarshal(content, &head) == nil && head.Version == 1 {
+		return RecordingAsciicast
+	}
+	return RecordingTranscript
+}
+
+// parseAsciicast 校验 asciicast 并返回 v2 格式的内容，同时填写 rec 中的尺寸、时长和事件数
+func parseAsciicast(content []byte, rec *Recording) ([]byte, error) {
+	trimmed := bytes.TrimSpace(content)
+	var v1 asciicastV1
+	if bytes.HasPrefix(trimmed, []byte("{")) && json.Unmarshal(trimmed, &v1) == nil && v1.Version == 1 {
+		converted, err := convertAsciicastV1(v1)
+		if err != nil {
+			return nil, err
+		}
+		content = converted
+	}
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	if !sc.Scan() {
+		return nil, errors.New("asciicast: empty file")
+	}
+	var head asciicastHeader
+	if err := json.Unmarshal(sc.Bytes(), &head); err != nil {
+		return nil, fmt.Errorf("asciicast header: %w", err)
+	}
+	if head.Version != 2 {
+		return nil, fmt.Errorf("asciicast: unsupported version %d", head.Version)
+	}
+	if head.Width <= 0 || head.Height <= 0 {
+		return nil, errors.New("asciicast: width and height must be positive")
+	}
+	last := 0.0
+	line := 1
+	for sc.Scan() {
+		line++
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var ev []json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || len(ev) != 3 {
+			return nil, fmt.Errorf("asciicast line %d: event must be [time, code, data]", line)
+		}
+		var at float64
+		var code, data string
+		if json.Unmarshal(ev[0], &at) != nil || json.Unmarshal(ev[1], &code) != nil || json.Unmarshal(ev[2], &data) != nil {
+			return nil, fmt.Errorf("asciicast line %d: invalid event", line)
+		}
+		if at < last {
+			return nil, fmt.Errorf("asciicast line %d: time goes backwards", line)
+		}
+		switch code {
+		case "o", "i", "m", "r":
+		default:
+			return nil, fmt.Errorf("asciicast line %d: unknown event code %q", line, code)
+		}
+		last = at
+		rec.Events++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("asciicast: %w", err)
+	}
+	rec.Width, rec.Height, rec.Duration = head.Width, head.Height, last
+	if head.Duration > last {
+		rec.Duration = head.Duration
+	}
+	if rec.Title == "" {
+		rec.Title = head.Title
+	}
+	if rec.StartedAt.IsZero() && head.Timestamp > 0 {
+		rec.StartedAt = time.Unix(head.Timestamp, 0)
+	}
+	return content, nil
+}
+
+// convertAsciicastV1 v1 的事件时间为相对上一条的延迟，转换为 v2 的绝对时间
+func convertAsciicastV1(v1 asciicastV1) ([]byte, error) {
+	var buf bytes.Buffer
+	head, _ := json.Marshal(asciicastHeader{Version: 2, Width: v1.Width, Height: v1.Height, Title: v1.Title, Env: v1.Env})
+	buf.Write(head)
+	buf.WriteByte('\n')
+	at := 0.0
+	for i, raw := range v1.Stdout {
+		var frame []json.RawMessage
+		var delay float64
+		var data string
+		if json.Unmarshal(raw, &frame) != nil || len(frame) != 2 ||
+			json.Unmarshal(frame[0], &delay) != nil || json.Unmarshal(frame[1], &data) != nil || delay < 0 {
+			return nil, fmt.Errorf("asciicast v1 frame %d: must be [delay, data]", i)
+		}
+		at += delay
+		ev, _ := json.Marshal([]interface{}{at, "o", data})
+		buf.Write(ev)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// checkTranscript transcript 必须是 UTF-8 文本
+func checkTranscript(content []byte) error {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return errors.New("transcript: empty file")
+	}
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		return errors.New("transcript: must be UTF-8 text")
+	}
+	return nil
+}
+
+// -----------------------
+// 管理接口
+// -----------------------
+
+func recordingsEnabled(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if hubRecordings == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "recordings are not enabled"})
+		}
+		return next(c)
+	}
+}
+
+// ImportRecordingHandler 导入外部录像：multipart 的 file 为文件，kind 为 asciicast 或 transcript（为空时按内容判断），
+// 其余表单字段 title、subject、host、token、startedAt（RFC3339）、labels（JSON 对象）、by 为元数据
+func ImportRecordingHandler(c echo.Context) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "缺少文件: " + err.Error()})
+	}
+	if fh.Size > hubRecordings.cfg.MaxImportSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "recording is too large"})
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	content, err := io.ReadAll(io.LimitReader(f, hubRecordings.cfg.MaxImportSize+1))
+	f.Close()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	rec := Recording{
+		Kind:       c.FormValue("kind"),
+		Source:     "import",
+		Title:      c.FormValue("title"),
+		Subject:    c.FormValue("subject"),
+		Host:       c.FormValue("host"),
+		Token:      c.FormValue("token"),
+		ImportedAt: time.Now(),
+		ImportedBy: c.FormValue("by"),
+	}
+	if rec.ImportedBy == "" {
+		rec.ImportedBy = "admin"
+	}
+	if v := c.FormValue("startedAt"); v != "" {
+		if rec.StartedAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid startedAt: " + err.Error()})
+		}
+	}
+	if v := c.FormValue("labels"); v != "" {
+		if err := json.Unmarshal([]byte(v), &rec.Labels); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid labels: " + err.Error()})
+		}
+	}
+	if rec.Kind == "" {
+		rec.Kind = detectRecording(content)
+	}
+	switch rec.Kind {
+	case RecordingAsciicast:
+		content, err = parseAsciicast(content, &rec)
+	case RecordingTranscript:
+		err = checkTranscript(content)
+	default:
+		err = fmt.Errorf("%w: kind %q", errRecordingFormat, rec.Kind)
+	}
+	if err != nil {
+		hubMetrics.Inc("hub_recording_imports_total", "kind", rec.Kind, "result", "invalid")
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+	if rec.Title == "" {
+		rec.Title = fh.Filename
+	}
+
+	saved, err := hubRecordings.add(rec, content)
+	if errors.Is(err, errRecordingDuplicate) {
+		return c.JSON(http.StatusConflict, map[string]interface{}{"error": err.Error(), "recording": saved})
+	}
+	if err != nil {
+		log.Println("Save recording error:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	searchAddRecording(saved)
+	hubMetrics.Inc("hub_recording_imports_total", "kind", saved.Kind, "result", "ok")
+	log.Printf("Recording %s (%s, %d bytes) imported by %s", saved.ID, saved.Kind, saved.Size, saved.ImportedBy)
+	return c.JSON(http.StatusCreated, saved)
+}
+
+// ListRecordingsHandler 列出录像：?kind=&subject=&host=&token=&label=k=v&q=标题&from=&to=（RFC3339）
+func ListRecordingsHandler(c echo.Context) error {
+	f := RecordingFilter{
+		Kind:    c.QueryParam("kind"),
+		Subject: c.QueryParam("subject"),
+		Host:    c.QueryParam("host"),
+		Token:   c.QueryParam("token"),
+		Label:   c.QueryParam("label"),
+		Text:    c.QueryParam("q"),
+	}
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.QueryParam(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid " + name + ": " + err.Error()})
+			}
+			*t = parsed
+		}
+	}
+	return c.JSON(http.StatusOK, hubRecordings.list(f))
+}
+
+// GetRecordingHandler 返回录像的元数据
+func GetRecordingHandler(c echo.Context) error {
+	rec, ok := hubRecordings.get(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errRecordingNotFound.Error()})
+	}
+	return c.JSON(http.StatusOK, rec)
+}
+
+// GetRecordingContentHandler 返回录像文件，asciicast 可直接交给 asciinema-player 播放
+func GetRecordingContentHandler(c echo.Context) error {
+	rec, ok := hubRecordings.get(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errRecordingNotFound.Error()})
+	}
+	contentType := echo.MIMETextPlainCharsetUTF8
+	if rec.Kind == RecordingAsciicast {
+		contentType = "application/x-asciicast"
+	}
+	body, err := hubRecordings.open(rec)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	defer body.Close()
+	return c.Stream(http.StatusOK, contentType, body)
+}
+
+// DeleteRecordingHandler 删除录像和元数据
+func DeleteRecordingHandler(c echo.Context) error {
+	rec, err := hubRecordings.remove(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	searchRemoveRecording(rec)
+	log.Printf("Recording %s deleted", rec.ID)
+	return c.JSON(http.StatusOK, rec)
+}
@@ -0,0 +1,335 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// -----------------------
+// Redis 中继：多个 hub 节点通过 Redis pub/sub 交换消息，前端和反向注册的 agent 可以连到不同节点。
+// 持有 agent 的节点把 agent 发来的消息发布到 {prefix}:{token}:down，并订阅 {prefix}:{token}:up 转发给 agent；
+// 持有前端的节点以 redisAgentConn 作为会话的 agent 连接，读写走上述两个频道
+// -----------------------
+
+// RedisConfig Addr 为空时不启用，会话只在本进程内中继
+type RedisConfig struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	Prefix   string `json:"prefix,omitempty"` // 键和频道前缀，默认 "wshub"
+}
+
+const (
+	// agent 所在节点定期刷新在线标记并发送心跳，在线标记在 3 个周期没有刷新后过期
+	redisHeartbeatInterval = 10 * time.Second
+	redisPresenceTTL       = 3 * redisHeartbeatInterval
+
+	// redisFrameClose 表示 agent 已断开
+	redisFrameClose = -1
+)
+
+var (
+	errRedisAgentClosed  = errors.New("remote agent closed")
+	errRedisAgentTimeout = errors.New("remote agent read timeout")
+)
+
+// redisFrame 频道中传递的一帧，G 为 agent 所在节点分配的连接标识，用于区分同一 token 先后注册的 agent
+type redisFrame struct {
+	G string `json:"g"`
+	T int    `json:"t"` // websocket 消息类型，redisFrameClose 表示关闭
+	D []byte `json:"d,omitempty"`
+}
+
+type redisRelay struct {
+	client *redis.Client
+	prefix string
+	node   string
+
+	mu      sync.Mutex
+	bridges map[string]*wsAgentConn // 本节点持有的 agent，token -> agent
+}
+
+// newRedisRelay 连接 Redis，失败时返回错误
+func newRedisRelay(cfg RedisConfig) (*redisRelay, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "wshub"
+	}
+	hostname, _ := os.Hostname()
+	return &redisRelay{
+		client:  client,
+		prefix:  prefix,
+		node:    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		bridges: make(map[string]*wsAgentConn),
+	}, nil
+}
+
+// hubRedis 为 nil 表示未启用 Redis 中继
+var hubRedis *redisRelay
+
+func (r *redisRelay) presenceKey(token string) string { return r.prefix + ":agent:" + token }
+func (r *redisRelay) upChannel(token string) string   { return r.prefix + ":" + token + ":up" }
+func (r *redisRelay) downChannel(token string) string { return r.prefix + ":" + token + ":down" }
+func (r *redisRelay) onlineChannel() string           { return r.prefix + ":online" }
+
+func (r *redisRelay) publish(ctx context.Context, channel string, frame redisFrame) error {
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, channel, payload).Err()
+}
+
+// bridgeAgent 由持有反向注册 agent 的节点调用，直到 agent 断开才返回
+func (r *redisRelay) bridgeAgent(token string, agent *wsAgentConn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gen := fmt.Sprintf("%s/%d", r.node, time.Now().UnixNano())
+
+	r.mu.Lock()
+	if old := r.bridges[token]; old != nil {
+		log.Printf("Agent for token %s replaced by a new registration", token)
+		old.conn.Close()
+	}
+	r.bridges[token] = agent
+	r.mu.Unlock()
+
+	sub := r.client.Subscribe(ctx, r.upChannel(token))
+	defer sub.Close()
+	go func() {
+		for msg := range sub.Channel() {
+			var frame redisFrame
+			if err := json.Unmarshal([]byte(msg.Payload), &frame); err != nil || frame.G != gen {
+				continue
+			}
+			_ = agent.Send(frame.D)
+		}
+	}()
+
+	if err := r.client.Set(ctx, r.presenceKey(token), gen, redisPresenceTTL).Err(); err != nil {
+		log.Println("Redis presence error:", err)
+	}
+	_ = r.client.Publish(ctx, r.onlineChannel(), token+" "+gen).Err()
+
+	// 刷新在线标记并向订阅方发送心跳，订阅方据此判断 agent 所在节点仍然存活
+	go func() {
+		ticker := time.NewTicker(redisHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.client.Expire(ctx, r.presenceKey(token), redisPresenceTTL)
+				_ = r.publish(ctx, r.downChannel(token), redisFrame{G: gen, T: websocket.PongMessage})
+			}
+		}
+	}()
+
+	for {
+		msgType, data, err := agent.conn.ReadMessage()
+		if err != nil {
+			log.Printf("Bridged agent for token %s disconnected: %v", token, err)
+			break
+		}
+		if msgType == websocket.TextMessage && strings.TrimSpace(string(data)) == MessageTypePing {
+			_ = agent.Send([]byte(MessageTypePong))
+			_ = agent.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+			continue
+		}
+		if err := r.publish(ctx, r.downChannel(token), redisFrame{G: gen, T: msgType, D: data}); err != nil {
+			log.Println("Redis publish error:", err)
+		}
+	}
+
+	_ = r.publish(ctx, r.downChannel(token), redisFrame{G: gen, T: redisFrameClose})
+	// 只删除自己的在线标记，agent 可能已在其它节点重新注册
+	if current, err := r.client.Get(ctx, r.presenceKey(token)).Result(); err == nil && current == gen {
+		r.client.Del(ctx, r.presenceKey(token))
+	}
+	r.mu.Lock()
+	if r.bridges[token] == agent {
+		delete(r.bridges, token)
+	}
+	r.mu.Unlock()
+	agent.conn.Close()
+	agent.closeSend()
+}
+
+// attach 查找 token 对应的在线 agent，存在时返回经 Redis 中继的 agent 连接，不存在时返回 nil
+func (r *redisRelay) attach(ctx context.Context, token string) (*wsAgentConn, error) {
+	gen, err := r.client.Get(ctx, r.presenceKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.newRemoteAgent(token, gen), nil
+}
+
+func (r *redisRelay) newRemoteAgent(token, gen string) *wsAgentConn {
+	conn := &redisAgentConn{
+		relay:  r,
+		token:  token,
+		gen:    gen,
+		sub:    r.client.Subscribe(context.Background(), r.downChannel(token)),
+		closed: make(chan struct{}),
+	}
+	agent := &wsAgentConn{
+		id:       gen,
+		conn:     conn,
+		send:     make(chan []byte, 1000),
+		sendHigh: priorityLane[[]byte](1000),
+		sendLow:  priorityLane[[]byte](1000),
+		overflow: hubConfig.AgentOverflowPolicy,
+	}
+	go agent.writePump()
+	return agent
+}
+
+// watchOnline 订阅 agent 上线通知，本节点有等待该 agent 重连的会话时交给会话
+func (r *redisRelay) watchOnline(ctx context.Context) {
+	sub := r.client.Subscribe(ctx, r.onlineChannel())
+	defer sub.Close()
+	msgs := sub.Channel()
+	for {
+		var msg *redis.Message
+		select {
+		case msg = <-msgs:
+		case <-ctx.Done():
+			return
+		}
+		if msg == nil {
+			return
+		}
+		token, gen, ok := strings.Cut(msg.Payload, " ")
+		if !ok {
+			continue
+		}
+		sess := relayHub.findSession(token)
+		if sess == nil || sess.endpoint != nil {
+			continue
+		}
+		sess.offerAgent(r.newRemoteAgent(token, gen))
+	}
+}
+
+// redisAgentConn 实现 messageConn，对会话来说就像一条普通的 agent 连接
+type redisAgentConn struct {
+	relay *redisRelay
+	token string
+	gen   string
+	sub   *redis.PubSub
+
+	mu       sync.Mutex
+	deadline time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *redisAgentConn) ReadMessage() (int, []byte, error) {
+	msgs := c.sub.Channel()
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+
+		var msg *redis.Message
+		var err error
+		select {
+		case <-c.closed:
+			err = net.ErrClosed
+		case <-timeout:
+			err = errRedisAgentTimeout
+		case m, ok := <-msgs:
+			if !ok {
+				err = errRedisAgentClosed
+			}
+			msg = m
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var frame redisFrame
+		if err := json.Unmarshal([]byte(msg.Payload), &frame); err != nil || frame.G != c.gen {
+			continue
+		}
+		switch frame.T {
+		case redisFrameClose:
+			return 0, nil, errRedisAgentClosed
+		case websocket.PongMessage:
+			// agent 所在节点的心跳，与 pong 一样延长读超时
+			_ = c.SetReadDeadline(time.Now().Add(redisPresenceTTL))
+			continue
+		}
+		return frame.T, frame.D, nil
+	}
+}
+
+func (c *redisAgentConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	default:
+	}
+	return c.relay.publish(context.Background(), c.relay.upChannel(c.token), redisFrame{G: c.gen, T: messageType, D: data})
+}
+
+// WriteControl agent 的保活由其所在节点负责，这里不需要发送控制帧
+func (c *redisAgentConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
+
+func (c *redisAgentConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+// SetWriteDeadline 写入是一次 Redis 发布，不需要写超时
+func (c *redisAgentConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *redisAgentConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.sub.Close()
+	})
+	return nil
+}
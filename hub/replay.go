@@ -0,0 +1,144 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+// -----------------------
+// 断线续传：开启 replay_buffer 后，agent 发给前端的消息带上递增序号 s 并缓存最近的若干条；
+// 最后一个前端断开后会话和 agent 连接保留 ClientResumeGrace，
+// 前端重新连接后发送 {"a":"resume","d":{"lastSeq":N}} 即可补收 N 之后的消息
+// -----------------------
+
+const ActionResume = "resume"
+
+// ResumeData 为 resume 请求的数据
+type ResumeData struct {
+	LastSeq int64 `json:"lastSeq"`
+}
+
+type replayEntry struct {
+	seq  int64
+	data []byte
+}
+
+// stampSeq 在 JSON 对象帧的开头插入 s 字段，其余字节原样保留，非 JSON 对象的帧原样返回。
+// agent 的消息不带 s 字段，直接拼接可以避免每条消息都完整解析、重新编码一次
+func stampSeq(data []byte, seq int64) []byte {
+	body := bytes.TrimLeft(data, " \t\r\n")
+	if len(body) == 0 || body[0] != '{' {
+		return data
+	}
+	rest := body[1:]
+	stamped := make([]byte, 0, len(data)+24)
+	stamped = append(stamped, `{"s":`...)
+	stamped = strconv.AppendInt(stamped, seq, 10)
+	if next := bytes.TrimLeft(rest, " \t\r\n"); len(next) > 0 && next[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, rest...)
+}
+
+// recordReplay 为 agent 发来的消息分配序号并放入缓存，返回带序号的消息；未开启时原样返回
+func (s *RelaySession) recordReplay(data []byte) []byte {
+	if !s.feature(FlagReplayBuffer) || hubConfig.ReplayBufferSize <= 0 {
+		return data
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.replaySeq++
+	stamped := stampSeq(data, s.replaySeq)
+	s.replay = append(s.replay, replayEntry{seq: s.replaySeq, data: stamped})
+	if over := len(s.replay) - hubConfig.ReplayBufferSize; over > 0 {
+		s.replay = append([]replayEntry(nil), s.replay[over:]...)
+	}
+	return stamped
+}
+
+// handleResume 把 lastSeq 之后缓存的消息按顺序补发给该前端，缓存中已缺失部分消息时先通知 replay_gap
+func (s *RelaySession) handleResume(client *wsClientConn, msg WebSocketMessage) {
+	var resume ResumeData
+	if raw, err := json.Marshal(msg.Data); err == nil {
+		_ = json.Unmarshal(raw, &resume)
+	}
+
+	s.stateMu.Lock()
+	var frames [][]byte
+	firstSeq := s.replaySeq + 1
+	if len(s.replay) > 0 {
+		firstSeq = s.replay[0].seq
+	}
+	for _, entry := range s.replay {
+		if entry.seq > resume.LastSeq {
+			frames = append(frames, entry.data)
+		}
+	}
+	lastSeq := s.replaySeq
+	s.stateMu.Unlock()
+
+	if resume.LastSeq+1 < firstSeq {
+		hubMetrics.Inc("hub_replay_gaps_total")
+		s.notifyClient(client, WebSocketMessage{
+			Type:      MessageTypeNotify,
+			RequestID: msg.RequestID,
+			Action:    "replay_gap",
+			Data:      map[string]int64{"lastSeq": resume.LastSeq, "firstAvailable": firstSeq},
+		})
+	}
+	for _, frame := range frames {
+		s.sendTo(client, frame)
+	}
+	hubMetrics.Add("hub_replayed_messages_total", int64(len(frames)))
+
+	respData, err := json.Marshal(WebSocketMessage{
+		Type:      MessageTypeResponse,
+		RequestID: msg.RequestID,
+		Action:    ActionResume,
+		Data:      map[string]int64{"replayed": int64(len(frames)), "seq": lastSeq},
+	})
+	if err != nil {
+		log.Println("Resume marshal error:", err)
+		return
+	}
+	s.sendTo(client, respData)
+}
+
+// holdForResume 在最后一个前端断开时调用，返回 true 表示会话保留等待前端重连，
+// 超过 ClientResumeGrace 仍没有前端连入时关闭会话
+func (s *RelaySession) holdForResume() bool {
+	grace := hubConfig.ClientResumeGrace.D()
+	if grace <= 0 || !s.feature(FlagReplayBuffer) || hubDraining.Load() {
+		return false
+	}
+	log.Printf("Session %s has no client, holding for %v", s.token, grace)
+	s.stateMu.Lock()
+	if s.resumeTimer != nil {
+		s.resumeTimer.Stop()
+	}
+	s.resumeTimer = time.AfterFunc(grace, func() {
+		s.clientMu.Lock()
+		empty := len(s.clients) == 0
+		s.clientMu.Unlock()
+		if empty {
+			log.Printf("Session %s resume grace expired", s.token)
+			s.setEndReason(EndReasonResumeExpired)
+			s.cleanup()
+		}
+	})
+	s.stateMu.Unlock()
+	return true
+}
+
+// cancelResumeHold 有前端重新连入时停止等待
+func (s *RelaySession) cancelResumeHold() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.resumeTimer != nil {
+		s.resumeTimer.Stop()
+		s.resumeTimer = nil
+	}
+}
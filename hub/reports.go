@@ -0,0 +1,523 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"echo_demo/upload2"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 定期会话报告：会话结束和上传完成时把记录追加到按天分割的 activity-YYYYMMDD.jsonl，
+// 每天 Hour 点汇总前一天（周一另外汇总前七天）的会话数、传输量、用户排行和失败情况，
+// 生成 JSON 和 HTML 保存在 Dir/reports 下供管理页面查看，开启 Mail 时通过邮件发送摘要
+// -----------------------
+
+// 会话结束原因，其中 agent_failure、dial_failure、memory_limit 计为失败
+const (
+	EndReasonClosed        = "closed" // 前端全部断开
+	EndReasonIdle          = "idle"
+	EndReasonZombie        = "zombie"
+	EndReasonAgentFailure  = "agent_failure" // agent 重连次数用尽
+	EndReasonDialFailure   = "dial_failure"  // 解析或连接 agent 失败
+	EndReasonMemoryLimit   = "memory_limit"
+	EndReasonShutdown      = "shutdown"
+	EndReasonResumeExpired = "resume_expired"
+	EndReasonLogout        = "logout" // 管理员关闭或强制下线
+)
+
+var failureReasons = map[string]bool{
+	EndReasonAgentFailure: true,
+	EndReasonDialFailure:  true,
+	EndReasonMemoryLimit:  true,
+}
+
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// ReportsConfig Dir 为空时不记录也不生成报告；Hour 为生成报告的本地时刻（0-23），
+// Retention 同时作用于活动记录和报告文件
+type ReportsConfig struct {
+	Dir       string   `json:"dir"`
+	Daily     bool     `json:"daily"`
+	Weekly    bool     `json:"weekly"`
+	Hour      int      `json:"hour"`
+	Top       int      `json:"top"` // 用户排行和失败明细的条数
+	Retention Duration `json:"retention"`
+	Mail      bool     `json:"mail"`
+	MailTo    []string `json:"mailTo,omitempty"` // 为空时发给 SMTP 默认收件人
+}
+
+func (cfg ReportsConfig) validate() error {
+	if cfg.Dir == "" {
+		return nil
+	}
+	if cfg.Hour < 0 || cfg.Hour > 23 {
+		return errors.New("reports.hour must be between 0 and 23")
+	}
+	if cfg.Top <= 0 {
+		return errors.New("reports.top must be positive")
+	}
+	if cfg.Retention.D() < 7*24*time.Hour {
+		return errors.New("reports.retention must be at least 7 days")
+	}
+	return nil
+}
+
+// activityRecord 活动文件中的一行，Kind 为 session 或 upload
+type activityRecord struct {
+	Kind       string    `json:"kind"`
+	Time       time.Time `json:"time"` // 会话结束或上传完成的时间
+	Token      string    `json:"token,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	BytesIn    int64     `json:"bytesIn,omitempty"`  // 前端发给 agent 的字节数
+	BytesOut   int64     `json:"bytesOut,omitempty"` // agent 发给前端的字节数
+	Reconnects int64     `json:"reconnects,omitempty"`
+	Reason     string    `json:"reason,omitempty"` // 会话结束原因
+	ClientIP   string    `json:"clientIP,omitempty"`
+	Country    string    `json:"country,omitempty"`
+	ASN        int       `json:"asn,omitempty"`
+	Name       string    `json:"name,omitempty"` // 上传的文件名
+	Size       int64     `json:"size,omitempty"`
+}
+
+// SessionReport 一个周期的汇总
+type SessionReport struct {
+	Name        string    `json:"name"`
+	Period      string    `json:"period"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	GeneratedAt time.Time `json:"generatedAt"`
+
+	Sessions           int   `json:"sessions"`
+	FailedSessions     int   `json:"failedSessions"`
+	AvgDurationSeconds int64 `json:"avgDurationSeconds"`
+	BytesFromClient    int64 `json:"bytesFromClient"`
+	BytesFromAgent     int64 `json:"bytesFromAgent"`
+	AgentReconnects    int64 `json:"agentReconnects"`
+	Uploads            int   `json:"uploads"`
+	UploadBytes        int64 `json:"uploadBytes"`
+
+	EndReasons map[string]int  `json:"endReasons"`
+	TopUsers   []ReportUser    `json:"topUsers"`
+	Failures   []ReportFailure `json:"failures"` // 最近的失败会话
+}
+
+// ReportUser 按传输量排序的用户，未知主体时为 token
+type ReportUser struct {
+	Subject  string `json:"subject"`
+	Sessions int    `json:"sessions"`
+	Bytes    int64  `json:"bytes"`
+}
+
+type ReportFailure struct {
+	Time    time.Time `json:"time"`
+	Token   string    `json:"token"`
+	Subject string    `json:"subject,omitempty"`
+	Reason  string    `json:"reason"`
+}
+
+type sessionReports struct {
+	cfg ReportsConfig
+
+	mu   sync.Mutex // 保护当前活动文件的写入和切换
+	file *os.File
+	day  string
+}
+
+// hubReports 为 nil 表示未启用
+var hubReports *sessionReports
+
+func newSessionReports(cfg ReportsConfig) (*sessionReports, error) {
+	if err := os.MkdirAll(filepath.Join(cfg.Dir, "reports"), 0o755); err != nil {
+		return nil, err
+	}
+	return &sessionReports{cfg: cfg}, nil
+}
+
+func reportDay(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func (r *sessionReports) activityPath(day string) string {
+	return filepath.Join(r.cfg.Dir, "activity-"+day+".jsonl")
+}
+
+// record 追加一条活动记录，跨天时切换文件并清理过期文件
+func (r *sessionReports) record(rec activityRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if day := reportDay(rec.Time); day != r.day || r.file == nil {
+		if r.file != nil {
+			r.file.Close()
+			r.file = nil
+		}
+		f, err := os.OpenFile(r.activityPath(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Println("Report activity write error:", err)
+			return
+		}
+		r.file, r.day = f, day
+		r.prune(rec.Time)
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		log.Println("Report activity write error:", err)
+	}
+}
+
+// prune 删除早于保留期的活动记录和报告
+func (r *sessionReports) prune(now time.Time) {
+	cutoff := reportDay(now.Add(-r.cfg.Retention.D()))
+	for _, pattern := range []string{"activity-*.jsonl", "reports/*-*.*"} {
+		matches, _ := filepath.Glob(filepath.Join(r.cfg.Dir, pattern))
+		for _, m := range matches {
+			base := filepath.Base(m)
+			day := strings.TrimSuffix(base[strings.LastIndex(base, "-")+1:], filepath.Ext(base))
+			if day < cutoff {
+				if err := os.Remove(m); err != nil {
+					log.Println("Report prune error:", err)
+				}
+			}
+		}
+	}
+}
+
+// recordSession 在会话清理时调用
+func (r *sessionReports) recordSession(s *RelaySession) {
+	s.stateMu.Lock()
+	reason, subject, tenant, origin := s.endReason, s.subject, s.tenant, s.origin
+	s.stateMu.Unlock()
+	if reason == "" {
+		reason = EndReasonClosed
+	}
+	rec := activityRecord{
+		Kind:       "session",
+		Time:       time.Now(),
+		Token:      s.token,
+		Subject:    subject,
+		Tenant:     tenant,
+		StartedAt:  s.createdAt,
+		BytesIn:    s.bytesFromClient.Load(),
+		BytesOut:   s.bytesFromAgent.Load(),
+		Reconnects: s.reconnects.Load(),
+		Reason:     reason,
+		ClientIP:   origin.IP,
+	}
+	if origin.Geo != nil {
+		rec.Country, rec.ASN = origin.Geo.Country, origin.Geo.ASN
+	}
+	r.record(rec)
+}
+
+func (r *sessionReports) recordUpload(u upload2.CompletedUpload) {
+	r.record(activityRecord{Kind: "upload", Time: u.FinishedAt, Name: u.Name, Size: u.Size})
+}
+
+// build 汇总 [from, to) 内的活动记录
+func (r *sessionReports) build(period string, from, to time.Time) (*SessionReport, error) {
+	rep := &SessionReport{
+		Name:        reportName(period, from),
+		Period:      period,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+		EndReasons:  make(map[string]int),
+		TopUsers:    []ReportUser{},
+		Failures:    []ReportFailure{},
+	}
+	users := make(map[string]*ReportUser)
+	var totalDuration time.Duration
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		err := r.scan(reportDay(day), func(rec activityRecord) {
+			if rec.Time.Before(from) || !rec.Time.Before(to) {
+				return
+			}
+			if rec.Kind == "upload" {
+				rep.Uploads++
+				rep.UploadBytes += rec.Size
+				return
+			}
+			rep.Sessions++
+			rep.EndReasons[rec.Reason]++
+			rep.BytesFromClient += rec.BytesIn
+			rep.BytesFromAgent += rec.BytesOut
+			rep.AgentReconnects += rec.Reconnects
+			totalDuration += rec.Time.Sub(rec.StartedAt)
+			subject := rec.Subject
+			if subject == "" {
+				subject = rec.Token
+			}
+			u := users[subject]
+			if u == nil {
+				u = &ReportUser{Subject: subject}
+				users[subject] = u
+			}
+			u.Sessions++
+			u.Bytes += rec.BytesIn + rec.BytesOut
+			if failureReasons[rec.Reason] {
+				rep.FailedSessions++
+				rep.Failures = append(rep.Failures, ReportFailure{Time: rec.Time, Token: rec.Token, Subject: rec.Subject, Reason: rec.Reason})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rep.Sessions > 0 {
+		rep.AvgDurationSeconds = int64(totalDuration.Seconds()) / int64(rep.Sessions)
+	}
+	for _, u := range users {
+		rep.TopUsers = append(rep.TopUsers, *u)
+	}
+	sort.Slice(rep.TopUsers, func(i, j int) bool {
+		if rep.TopUsers[i].Bytes != rep.TopUsers[j].Bytes {
+			return rep.TopUsers[i].Bytes > rep.TopUsers[j].Bytes
+		}
+		return rep.TopUsers[i].Subject < rep.TopUsers[j].Subject
+	})
+	if len(rep.TopUsers) > r.cfg.Top {
+		rep.TopUsers = rep.TopUsers[:r.cfg.Top]
+	}
+	sort.Slice(rep.Failures, func(i, j int) bool { return rep.Failures[i].Time.After(rep.Failures[j].Time) })
+	if len(rep.Failures) > r.cfg.Top {
+		rep.Failures = rep.Failures[:r.cfg.Top]
+	}
+	return rep, nil
+}
+
+func (r *sessionReports) scan(day string, fn func(activityRecord)) error {
+	f, err := os.Open(r.activityPath(day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec activityRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // 不完整的行
+		}
+		fn(rec)
+	}
+	return scanner.Err()
+}
+
+func reportName(period string, from time.Time) string {
+	return period + "-" + reportDay(from)
+}
+
+// reportNamePattern 报告文件名，防止路径穿越
+var reportNamePattern = regexp.MustCompile(`^(daily|weekly)-\d{8}\.(json|html)$`)
+
+// save 写出 JSON 和 HTML 两份报告
+func (r *sessionReports) save(rep *SessionReport) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(r.cfg.Dir, "reports", rep.Name)
+	if err := os.WriteFile(base+".json", data, 0o644); err != nil {
+		return err
+	}
+	var page bytes.Buffer
+	if err := reportHTML.Execute(&page, rep); err != nil {
+		return err
+	}
+	return os.WriteFile(base+".html", page.Bytes(), 0o644)
+}
+
+// periodRange 返回 now 之前最近一个完整周期：daily 为前一天，weekly 为前七天
+func periodRange(period string, now time.Time) (from, to time.Time) {
+	to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if period == ReportWeekly {
+		return to.AddDate(0, 0, -7), to
+	}
+	return to.AddDate(0, 0, -1), to
+}
+
+// generate 生成并保存报告，已存在时跳过（重启后不重复生成和发送）
+func (r *sessionReports) generate(period string, now time.Time) {
+	from, to := periodRange(period, now)
+	name := reportName(period, from)
+	if _, err := os.Stat(filepath.Join(r.cfg.Dir, "reports", name+".json")); err == nil {
+		return
+	}
+	rep, err := r.build(period, from, to)
+	if err != nil {
+		log.Printf("Build %s report error: %v", period, err)
+		return
+	}
+	if err := r.save(rep); err != nil {
+		log.Printf("Save %s report error: %v", period, err)
+		return
+	}
+	hubMetrics.Inc("hub_reports_generated_total", "period", period)
+	log.Printf("Report %s generated: %d sessions, %d failed", name, rep.Sessions, rep.FailedSessions)
+	if r.cfg.Mail {
+		sendMail(MailEventReport, name, r.cfg.MailTo, rep)
+	}
+}
+
+// run 每天 Hour 点生成报告，hub 退出或 ctx 结束时停止
+func (r *sessionReports) run(ctx context.Context) {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), r.cfg.Hour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil || hubDraining.Load() {
+			break
+		}
+		now = time.Now()
+		if r.cfg.Daily {
+			r.generate(ReportDaily, now)
+		}
+		if r.cfg.Weekly && now.Weekday() == time.Monday {
+			r.generate(ReportWeekly, now)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Session report {{.Name}}</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px;text-align:left}</style>
+</head>
+<body>
+<h1>Session report {{.Name}}</h1>
+<p>{{.From.Format "2006-01-02 15:04"}} – {{.To.Format "2006-01-02 15:04"}}, generated {{.GeneratedAt.Format "2006-01-02 15:04:05"}}</p>
+<table>
+<tr><th>Sessions</th><td>{{.Sessions}}</td></tr>
+<tr><th>Failed sessions</th><td>{{.FailedSessions}}</td></tr>
+<tr><th>Average duration (s)</th><td>{{.AvgDurationSeconds}}</td></tr>
+<tr><th>Bytes from clients</th><td>{{.BytesFromClient}}</td></tr>
+<tr><th>Bytes from agents</th><td>{{.BytesFromAgent}}</td></tr>
+<tr><th>Agent reconnects</th><td>{{.AgentReconnects}}</td></tr>
+<tr><th>Uploads</th><td>{{.Uploads}} ({{.UploadBytes}} bytes)</td></tr>
+</table>
+<h2>End reasons</h2>
+<table>
+<tr><th>Reason</th><th>Sessions</th></tr>
+{{range $reason, $n := .EndReasons}}<tr><td>{{$reason}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<h2>Top users</h2>
+<table>
+<tr><th>Subject</th><th>Sessions</th><th>Bytes</th></tr>
+{{range .TopUsers}}<tr><td>{{.Subject}}</td><td>{{.Sessions}}</td><td>{{.Bytes}}</td></tr>
+{{end}}</table>
+<h2>Recent failures</h2>
+<table>
+<tr><th>Time</th><th>Token</th><th>Subject</th><th>Reason</th></tr>
+{{range .Failures}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Token}}</td><td>{{.Subject}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ListReportsHandler 按时间倒序列出已生成的报告文件
+func ListReportsHandler(c echo.Context) error {
+	if hubReports == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "reports are not enabled"})
+	}
+	matches, _ := filepath.Glob(filepath.Join(hubReports.cfg.Dir, "reports", "*.json"))
+	names := []string{}
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), ".json"))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i][strings.Index(names[i], "-"):] > names[j][strings.Index(names[j], "-"):]
+	})
+	return c.JSON(http.StatusOK, names)
+}
+
+// GetReportHandler 返回报告文件，:name 为 daily-20261015.json 或 .html
+func GetReportHandler(c echo.Context) error {
+	if hubReports == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "reports are not enabled"})
+	}
+	name := c.Param("name")
+	if !reportNamePattern.MatchString(name) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid report name"})
+	}
+	path := filepath.Join(hubReports.cfg.Dir, "reports", name)
+	if _, err := os.Stat(path); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "report not found"})
+	}
+	return c.File(path)
+}
+
+// GenerateReportHandler 立即汇总一个周期：?period=daily|weekly&date=2026-10-15，
+// date 为周期的第一天，默认为最近一个完整周期；save=true 时同时保存
+func GenerateReportHandler(c echo.Context) error {
+	if hubReports == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "reports are not enabled"})
+	}
+	period := c.QueryParam("period")
+	if period == "" {
+		period = ReportDaily
+	}
+	if period != ReportDaily && period != ReportWeekly {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown period %q", period)})
+	}
+	from, to := periodRange(period, time.Now())
+	if v := c.QueryParam("date"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid date: " + err.Error()})
+		}
+		from, to = day, day.AddDate(0, 0, 1)
+		if period == ReportWeekly {
+			to = day.AddDate(0, 0, 7)
+		}
+	}
+	rep, err := hubReports.build(period, from, to)
+	if err != nil {
+		log.Println("Build report error:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if c.QueryParam("save") == "true" {
+		if err := hubReports.save(rep); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, rep)
+}
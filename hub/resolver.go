@@ -0,0 +1,167 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// -----------------------
+// Agent 地址解析：token -> agent 地址及拨号凭证
+// -----------------------
+
+var ErrAgentNotFound = errors.New("no agent endpoint configured for token")
+
+// AgentEndpoint 描述如何拨号连接一个 agent
+type AgentEndpoint struct {
+	URL    string            `json:"url"`
+	Header map[string]string `json:"header,omitempty"` // 拨号时附带的请求头，例如鉴权信息
+}
+
+// AgentResolver 根据 token 查找应连接的 agent
+type AgentResolver interface {
+	Resolve(ctx context.Context, token string) (*AgentEndpoint, error)
+}
+
+// AgentResolverConfig 配置 agent 地址的来源
+type AgentResolverConfig struct {
+	Type      string                   `json:"type"`                // "static"（默认）或 "http"
+	File      string                   `json:"file,omitempty"`      // static：额外的 token -> endpoint JSON 文件
+	Endpoints map[string]AgentEndpoint `json:"endpoints,omitempty"` // static：按 token 配置的 endpoint
+	Default   *AgentEndpoint           `json:"default,omitempty"`   // static：未匹配到 token 时使用
+	URL       string                   `json:"url,omitempty"`       // http：查询地址，token 作为查询参数追加，地址可以带有其它参数
+	Timeout   Duration                 `json:"timeout,omitempty"`   // http：查询超时
+}
+
+// StaticResolver 从配置中的映射表解析
+type StaticResolver struct {
+	Endpoints map[string]AgentEndpoint
+	Default   *AgentEndpoint
+}
+
+func (r *StaticResolver) Resolve(ctx context.Context, token string) (*AgentEndpoint, error) {
+	if ep, ok := r.Endpoints[token]; ok {
+		return &ep, nil
+	}
+	if r.Default != nil {
+		ep := *r.Default
+		return &ep, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrAgentNotFound, token)
+}
+
+// HTTPResolver 通过 HTTP 接口查询，接口返回 AgentEndpoint 的 JSON，404 表示没有对应 agent
+type HTTPResolver struct {
+	URL    string
+	Client *http.Client
+
+	parsed *url.URL // NewAgentResolver 解析的 URL，直接构造时在查询时解析
+}
+
+func (r *HTTPResolver) Resolve(ctx context.Context, token string) (*AgentEndpoint, error) {
+	base := r.parsed
+	if base == nil {
+		var err error
+		if base, err = url.Parse(r.URL); err != nil {
+			return nil, err
+		}
+	}
+	u := *base
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrAgentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent lookup returned status %d", resp.StatusCode)
+	}
+	var ep AgentEndpoint
+	if err := json.NewDecoder(resp.Body).Decode(&ep); err != nil {
+		return nil, err
+	}
+	if ep.URL == "" {
+		return nil, ErrAgentNotFound
+	}
+	return &ep, nil
+}
+
+// NewAgentResolver 根据配置创建 resolver
+func NewAgentResolver(cfg AgentResolverConfig) (AgentResolver, error) {
+	switch cfg.Type {
+	case "", "static":
+		endpoints := make(map[string]AgentEndpoint)
+		if cfg.File != "" {
+			data, err := os.ReadFile(cfg.File)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(data, &endpoints); err != nil {
+				return nil, err
+			}
+		}
+		for token, ep := range cfg.Endpoints {
+			endpoints[token] = ep
+		}
+		if len(endpoints) == 0 && cfg.Default == nil {
+			log.Println("Agent resolver: no agent endpoint configured, only inventory and reverse-registered agents are reachable")
+		}
+		return &StaticResolver{Endpoints: endpoints, Default: cfg.Default}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, errors.New("agent resolver url is empty")
+		}
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("agent resolver url: %w", err)
+		}
+		timeout := cfg.Timeout.D()
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		return &HTTPResolver{URL: cfg.URL, Client: &http.Client{Timeout: timeout}, parsed: parsed}, nil
+	default:
+		return nil, fmt.Errorf("unknown agent resolver type %q", cfg.Type)
+	}
+}
+
+var agentResolver AgentResolver = &StaticResolver{}
+
+// dialAgent 按 endpoint 拨号连接 agent 并启动写循环
+func dialAgent(ep *AgentEndpoint) (*wsAgentConn, error) {
+	header := http.Header{}
+	for k, v := range ep.Header {
+		header.Set(k, v)
+	}
+	conn, _, err := agentDialer.Dial(ep.URL, header)
+	if err != nil {
+		return nil, err
+	}
+	agent := &wsAgentConn{
+		conn:     conn,
+		send:     make(chan []byte, 1000),
+		sendHigh: priorityLane[[]byte](1000),
+		sendLow:  priorityLane[[]byte](1000),
+		overflow: hubConfig.AgentOverflowPolicy,
+	}
+	setupKeepalive(conn)
+	setupCompression(conn)
+	setAgentReadLimit(conn)
+	go agent.writePump()
+	return agent, nil
+}
@@ -0,0 +1,174 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 按 action 路由：不同的 action 可以转发给不同的 agent 或由 hub 本地处理，
+// 按 token 配置的路由优先于全局路由，没有匹配的 action 仍转发给会话的主 agent
+// -----------------------
+
+// ActionRoute 一个 action 的去向，Local 与 Endpoint 二选一
+type ActionRoute struct {
+	Local    bool           `json:"local,omitempty"`
+	Endpoint *AgentEndpoint `json:"endpoint,omitempty"`
+}
+
+// ActionRoutesConfig Default 为全局路由（action -> route），Tokens 按 token 覆盖
+type ActionRoutesConfig struct {
+	Default map[string]ActionRoute            `json:"default,omitempty"`
+	Tokens  map[string]map[string]ActionRoute `json:"tokens,omitempty"`
+}
+
+func (r ActionRoute) validate() error {
+	if r.Local == (r.Endpoint != nil) {
+		return fmt.Errorf("action route must set exactly one of local and endpoint")
+	}
+	if r.Endpoint != nil && r.Endpoint.URL == "" {
+		return fmt.Errorf("action route endpoint url is empty")
+	}
+	return nil
+}
+
+func (c ActionRoutesConfig) validate() error {
+	for action, route := range c.Default {
+		if err := route.validate(); err != nil {
+			return fmt.Errorf("action %q: %w", action, err)
+		}
+	}
+	for token, routes := range c.Tokens {
+		for action, route := range routes {
+			if err := route.validate(); err != nil {
+				return fmt.Errorf("token %s action %q: %w", token, action, err)
+			}
+		}
+	}
+	return nil
+}
+
+// route 查找 action 的路由，没有配置时返回 false
+func (s *RelaySession) route(action string) (ActionRoute, bool) {
+	if route, ok := hubConfig.ActionRoutes.Tokens[s.token][action]; ok {
+		return route, true
+	}
+	route, ok := hubConfig.ActionRoutes.Default[action]
+	return route, ok
+}
+
+// relayRouted 把消息转发给路由指定的 agent，连接失败时通知发送方
+func (s *RelaySession) relayRouted(client *wsClientConn, msg WebSocketMessage, ep *AgentEndpoint, data []byte) {
+	agent, err := s.routedAgent(ep)
+	if err != nil {
+		log.Printf("Session %s route %q dial error: %v", s.token, msg.Action, err)
+		s.auditRequest(client, msg, len(data), ep.URL, AuditRouteError, err)
+		s.notifyClient(client, WebSocketMessage{
+			Type:      MessageTypeNotify,
+			RequestID: msg.RequestID,
+			Action:    "route_error",
+			Data:      fmt.Sprintf("Agent for action %q is unavailable", msg.Action),
+			Error:     &MessageError{Code: ErrCodeAgentUnavailable, Reason: err.Error()},
+		})
+		return
+	}
+	s.bytesFromClient.Add(int64(len(data)))
+	s.msgsFromClient.Add(1)
+	s.touch()
+	s.trackRequest(msg.RequestID)
+	s.recordRelayed("client_to_agent", len(data))
+	data, span := s.traceAgentForward(msg, data, ep.URL)
+	s.traceFrame(FrameAgentOut, data)
+	if err := agent.Send(data); err != nil {
+		s.auditRequest(client, msg, len(data), ep.URL, AuditSendFailed, err)
+		s.notifySendFailure(client, msg.RequestID, err)
+	} else {
+		s.auditRequest(client, msg, len(data), ep.URL, AuditRelayed, nil)
+	}
+	span.End()
+	hubMetrics.Inc("hub_routed_messages_total", "action", msg.Action)
+}
+
+// routedAgent 返回 endpoint 对应的连接，没有时拨号建立；同一 URL 的 action 共用一条连接
+func (s *RelaySession) routedAgent(ep *AgentEndpoint) (*wsAgentConn, error) {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+	if s.ctx.Err() != nil {
+		return nil, s.ctx.Err()
+	}
+	if agent := s.routed[ep.URL]; agent != nil {
+		return agent, nil
+	}
+	agent, err := dialAgent(ep)
+	if err != nil {
+		return nil, err
+	}
+	if s.routed == nil {
+		s.routed = make(map[string]*wsAgentConn)
+	}
+	s.routed[ep.URL] = agent
+	s.goLoop(func() { s.routedReadLoop(ep.URL, agent) })
+	return agent, nil
+}
+
+// routedReadLoop 把路由 agent 的消息转发给全部前端；连接断开后移除，下一条消息会重新拨号
+func (s *RelaySession) routedReadLoop(url string, agent *wsAgentConn) {
+	defer func() {
+		s.routeMu.Lock()
+		if s.routed[url] == agent {
+			delete(s.routed, url)
+		}
+		s.routeMu.Unlock()
+		agent.conn.Close()
+		agent.closeSend()
+	}()
+	for {
+		msgType, data, err := readMessage(agent.conn)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Printf("Session %s routed agent %s read error: %v", s.token, url, err)
+			}
+			return
+		}
+		s.traceFrame(FrameAgentIn, data)
+		if !s.injectChaos(ChaosLegAgent, func() { agent.conn.Close() }) {
+			continue
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == MessageTypePing {
+			_ = agent.Send([]byte(MessageTypePong))
+			_ = agent.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+			continue
+		}
+		data, ok := s.hookAgentMessage(data)
+		if !ok {
+			continue
+		}
+		s.throttleAgentMessage(len(data))
+		s.bytesFromAgent.Add(int64(len(data)))
+		s.msgsFromAgent.Add(1)
+		s.touch()
+		s.completeRequest(data)
+		s.auditResponse(data, url)
+		s.recordRelayed("agent_to_client", len(data))
+		deliver := s.traceAgentResponse(data)
+		s.broadcast(s.recordReplay(data))
+		deliver.End()
+	}
+}
+
+// closeRouted 关闭全部路由 agent 连接，会话清理时调用
+func (s *RelaySession) closeRouted() {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+	for url, agent := range s.routed {
+		agent.conn.Close()
+		delete(s.routed, url)
+	}
+}
@@ -0,0 +1,314 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"echo_demo/search"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 全文检索：审计记录、exec 执行的命令和录像库中的终端记录放进同一个内存索引，
+// 启动时从审计文件（包括轮转出的旧文件）和录像库重建，之后随写入和导入、删除增量更新。
+// 审计记录按脱敏后的内容索引；asciicast 只索引输出事件，去掉终端控制序列。
+// /admin/search 返回 JSON，/admin/investigate 是同样查询条件的调查页面
+// -----------------------
+
+// 检索文档的类型
+const (
+	SearchKindAudit      = "audit"
+	SearchKindCommand    = "command"
+	SearchKindTranscript = "transcript"
+)
+
+// SearchConfig MaxDocuments 为索引的文档数上限，超过时淘汰最早加入的；MaxTextBytes 为单个录像索引的文字上限
+type SearchConfig struct {
+	Enabled      bool `json:"enabled"`
+	MaxDocuments int  `json:"maxDocuments"`
+	MaxTextBytes int  `json:"maxTextBytes"`
+}
+
+func (cfg SearchConfig) validate() error {
+	if cfg.Enabled && (cfg.MaxDocuments <= 0 || cfg.MaxTextBytes <= 0) {
+		return errors.New("search.maxDocuments and search.maxTextBytes must be positive")
+	}
+	return nil
+}
+
+// hubSearch 为 nil 表示未启用
+var hubSearch *search.Index
+
+// auditDocSeq 审计记录没有唯一 ID，按加入顺序编号
+var auditDocSeq atomic.Uint64
+
+// openSearchIndex 建立索引并加载已有的审计记录和录像，需要在审计日志和录像库打开之后调用
+func openSearchIndex(cfg SearchConfig) *search.Index {
+	idx := search.New(cfg.MaxDocuments)
+	start := time.Now()
+	if hubConfig.Audit.File != "" {
+		// 轮转文件名带时间戳，按名称排序即为时间顺序，当前文件最后
+		files, _ := filepath.Glob(hubConfig.Audit.File + ".*")
+		sort.Strings(files)
+		for _, f := range append(files, hubConfig.Audit.File) {
+			if err := indexAuditFile(idx, f); err != nil && !os.IsNotExist(err) {
+				log.Printf("Search index %s error: %v", f, err)
+			}
+		}
+	}
+	if hubRecordings != nil {
+		for _, rec := range hubRecordings.list(RecordingFilter{}) {
+			indexRecording(idx, cfg, rec)
+		}
+	}
+	log.Printf("Search index built with %d documents in %v", idx.Len(), time.Since(start).Round(time.Millisecond))
+	return idx
+}
+
+func indexAuditFile(idx *search.Index, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // 不完整的行
+		}
+		idx.Add(auditDocument(e))
+	}
+	return scanner.Err()
+}
+
+// auditDocument 审计记录对应的文档，Host 为转发的 agent 地址或执行命令的主机
+func auditDocument(e AuditEntry) search.Document {
+	kind := SearchKindAudit
+	if e.Direction == AuditCommand {
+		kind = SearchKindCommand
+	}
+	text := []string{e.Action, e.Outcome, e.Token, e.ClientIP, e.RequestID}
+	for _, s := range []string{e.Command, e.Error} {
+		if s != "" {
+			text = append(text, s)
+		}
+	}
+	return search.Document{
+		ID:      fmt.Sprintf("audit-%d", auditDocSeq.Add(1)),
+		Kind:    kind,
+		Subject: e.Subject,
+		Host:    e.Target,
+		Time:    e.Time,
+		Title:   e.Direction + " " + e.Action,
+		Ref:     e.RequestID,
+		Text:    strings.Join(text, "\n"),
+	}
+}
+
+// indexAuditEntry 审计日志写入后调用，e 已经脱敏
+func indexAuditEntry(e AuditEntry) {
+	if hubSearch != nil {
+		hubSearch.Add(auditDocument(e))
+	}
+}
+
+// indexRecording 读取录像内容加入索引，读取失败时只索引元数据
+func indexRecording(idx *search.Index, cfg SearchConfig, rec Recording) {
+	var content []byte
+	body, err := hubRecordings.open(rec)
+	if err == nil {
+		content, err = io.ReadAll(body)
+		body.Close()
+	}
+	if err != nil {
+		log.Printf("Search index recording %s error: %v", rec.ID, err)
+	}
+	text := string(content)
+	if rec.Kind == RecordingAsciicast {
+		text = asciicastText(content)
+	}
+	if len(text) > cfg.MaxTextBytes {
+		text = strings.ToValidUTF8(text[:cfg.MaxTextBytes], "")
+	}
+	at := rec.StartedAt
+	if at.IsZero() {
+		at = rec.ImportedAt
+	}
+	idx.Add(search.Document{
+		ID:      "recording-" + rec.ID,
+		Kind:    SearchKindTranscript,
+		Subject: rec.Subject,
+		Host:    rec.Host,
+		Time:    at,
+		Title:   rec.Title,
+		Ref:     rec.ID,
+		Text:    text,
+	})
+}
+
+// searchAddRecording、searchRemoveRecording 在导入和删除录像后更新索引
+func searchAddRecording(rec Recording) {
+	if hubSearch != nil {
+		indexRecording(hubSearch, hubConfig.Search, rec)
+	}
+}
+
+func searchRemoveRecording(rec Recording) {
+	if hubSearch != nil {
+		hubSearch.Remove("recording-" + rec.ID)
+	}
+}
+
+// ansiEscape CSI、OSC 和其它两字节的终端控制序列
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+// asciicastText 取出 v2 文件中输出事件的文字，去掉控制序列，回车换行统一为换行
+func asciicastText(content []byte) string {
+	var out strings.Builder
+	lines := bytes.Split(content, []byte("\n"))
+	for _, line := range lines[1:] {
+		var ev []interface{}
+		if json.Unmarshal(line, &ev) != nil || len(ev) < 3 || ev[1] != "o" {
+			continue
+		}
+		if s, ok := ev[2].(string); ok {
+			out.WriteString(s)
+		}
+	}
+	text := ansiEscape.ReplaceAllString(out.String(), "")
+	return strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text)
+}
+
+// searchQuery 解析查询参数：q 为检索文字，kind 可以用逗号分隔多个，user、host 精确匹配，
+// from、to 为 RFC3339，limit 默认 50、最多 500
+func searchQuery(c echo.Context) (search.Query, error) {
+	q := search.Query{
+		Text:    c.QueryParam("q"),
+		Subject: c.QueryParam("user"),
+		Host:    c.QueryParam("host"),
+		Limit:   50,
+	}
+	if v := c.QueryParam("kind"); v != "" {
+		q.Kinds = strings.Split(v, ",")
+	}
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.QueryParam(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			*t = parsed
+		}
+	}
+	for name, n := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		if v := c.QueryParam(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 0 {
+				return q, fmt.Errorf("invalid %s", name)
+			}
+			*n = parsed
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = 50
+	}
+	if q.Limit > 500 {
+		q.Limit = 500
+	}
+	return q, nil
+}
+
+func searchEnabled(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if hubSearch == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "search is not enabled"})
+		}
+		return next(c)
+	}
+}
+
+// SearchHandler 检索审计记录、命令和终端记录：?q=&kind=audit,command,transcript&user=&host=&from=&to=&limit=&offset=
+func SearchHandler(c echo.Context) error {
+	q, err := searchQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	hubMetrics.Inc("hub_search_queries_total")
+	return c.JSON(http.StatusOK, hubSearch.Search(q))
+}
+
+// InvestigateHandler 调查页面：检索表单和结果列表，终端记录链接到录像内容
+func InvestigateHandler(c echo.Context) error {
+	q, err := searchQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	hubMetrics.Inc("hub_search_queries_total")
+	page := struct {
+		Query  search.Query
+		Kind   string
+		From   string
+		To     string
+		Result search.Result
+		Next   string
+	}{Query: q, Kind: c.QueryParam("kind"), From: c.QueryParam("from"), To: c.QueryParam("to"), Result: hubSearch.Search(q)}
+	if q.Offset+len(page.Result.Hits) < page.Result.Total {
+		params := c.QueryParams()
+		params.Set("offset", strconv.Itoa(q.Offset+len(page.Result.Hits)))
+		page.Next = "?" + params.Encode()
+	}
+	var buf bytes.Buffer
+	if err := investigateHTML.Execute(&buf, page); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
+
+var investigateHTML = template.Must(template.New("investigate").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Investigate</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px;text-align:left;vertical-align:top}.snippet{font-family:monospace;white-space:pre-wrap}</style>
+</head>
+<body>
+<h1>Investigate</h1>
+<form method="get">
+<input name="q" value="{{.Query.Text}}" placeholder="text, prefix* or &quot;phrase&quot;" size="40">
+<select name="kind">
+<option value="" {{if eq .Kind ""}}selected{{end}}>all</option>
+<option value="audit" {{if eq .Kind "audit"}}selected{{end}}>audit</option>
+<option value="command" {{if eq .Kind "command"}}selected{{end}}>command</option>
+<option value="transcript" {{if eq .Kind "transcript"}}selected{{end}}>transcript</option>
+</select>
+<input name="user" value="{{.Query.Subject}}" placeholder="user">
+<input name="host" value="{{.Query.Host}}" placeholder="host">
+<input name="from" value="{{.From}}" placeholder="from (RFC3339)">
+<input name="to" value="{{.To}}" placeholder="to (RFC3339)">
+<button type="submit">Search</button>
+</form>
+<p>{{.Result.Total}} results</p>
+<table>
+<tr><th>Time</th><th>Kind</th><th>User</th><th>Host</th><th>Title</th><th>Match</th></tr>
+{{range .Result.Hits}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Kind}}</td><td>{{.Subject}}</td><td>{{.Host}}</td>
+<td>{{if eq .Kind "transcript"}}<a href="recordings/{{.Ref}}/content">{{.Title}}</a>{{else}}{{.Title}}{{end}}</td><td class="snippet">{{.Snippet}}</td></tr>
+{{end}}</table>
+{{if .Next}}<p><a href="{{.Next}}">Next page</a></p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,150 @@
+package hub
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// 前端消息序号：前端在 s 字段带上按连接递增的序号（从任意值开始，第一条带序号的消息确定起点），
+// hub 按序号顺序处理：重复或已处理过的序号丢弃，提前到达的消息暂存在重排缓冲中等待缺失的消息，
+// 同时通知前端 seq_gap 以便其重发缺失部分；等待超过 ClientSeqTimeout 或缓冲超过 ClientSeqWindow 条时
+// 放弃缺失的序号，通知 seq_skipped 并继续处理。agent 到前端方向的序号见 replay.go，缺失时前端发送 resume 补收。
+// 不带 s 的消息不参与排序
+// -----------------------
+
+const (
+	ActionSeqGap     = "seq_gap"     // hub -> 前端，d 为 SeqGap，请求重发 Expected 起的消息
+	ActionSeqSkipped = "seq_skipped" // hub -> 前端，d 为 SeqGap，缺失的消息已被放弃
+)
+
+// SeqGap 缺失的序号区间 [Expected, Received)
+type SeqGap struct {
+	Expected int64 `json:"expected"`
+	Received int64 `json:"received"`
+}
+
+type seqMessage struct {
+	msg    WebSocketMessage
+	data   []byte
+	readAt time.Time
+}
+
+// clientSeq 单个前端连接的重排状态
+type clientSeq struct {
+	mu      sync.Mutex // 处理消息时持有，保证读循环和超时回调按顺序处理
+	next    int64      // 下一个应处理的序号，0 表示尚未收到带序号的消息
+	pending map[int64]seqMessage
+	timer   *time.Timer
+}
+
+// sequenceClientMessage 按序号处理前端消息，不带序号时直接处理
+func (s *RelaySession) sequenceClientMessage(client *wsClientConn, msg WebSocketMessage, data []byte, readAt time.Time) {
+	if msg.Seq <= 0 {
+		s.handleClientMessage(client, msg, data, readAt)
+		return
+	}
+	q := &client.seq
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.next == 0 {
+		q.next = msg.Seq
+	}
+	switch {
+	case msg.Seq < q.next:
+		hubMetrics.Inc("hub_client_seq_total", "result", "duplicate")
+		return
+	case msg.Seq > q.next:
+		if _, dup := q.pending[msg.Seq]; dup {
+			hubMetrics.Inc("hub_client_seq_total", "result", "duplicate")
+			return
+		}
+		if q.pending == nil {
+			q.pending = make(map[int64]seqMessage)
+		}
+		q.pending[msg.Seq] = seqMessage{msg: msg, data: data, readAt: readAt}
+		hubMetrics.Inc("hub_client_seq_total", "result", "reordered")
+		if len(q.pending) == 1 {
+			// 第一次出现缺口时通知前端重发，并开始计时
+			s.notifyClient(client, WebSocketMessage{
+				Type:   MessageTypeNotify,
+				Action: ActionSeqGap,
+				Data:   SeqGap{Expected: q.next, Received: msg.Seq},
+			})
+			if timeout := hubConfig.ClientSeqTimeout.D(); timeout > 0 {
+				q.timer = time.AfterFunc(timeout, func() { s.skipSeqGap(client) })
+			}
+		}
+		if window := hubConfig.ClientSeqWindow; window > 0 && len(q.pending) > window {
+			s.skipSeqGapLocked(client)
+		}
+		return
+	}
+	q.next++
+	s.handleClientMessage(client, msg, data, readAt)
+	s.drainSeqLocked(client)
+}
+
+// drainSeqLocked 处理重排缓冲中已连续的消息，缓冲清空时停止计时
+func (s *RelaySession) drainSeqLocked(client *wsClientConn) {
+	q := &client.seq
+	for {
+		m, ok := q.pending[q.next]
+		if !ok {
+			break
+		}
+		delete(q.pending, q.next)
+		q.next++
+		s.handleClientMessage(client, m.msg, m.data, m.readAt)
+	}
+	if len(q.pending) == 0 && q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+}
+
+func (s *RelaySession) skipSeqGap(client *wsClientConn) {
+	client.seq.mu.Lock()
+	defer client.seq.mu.Unlock()
+	s.skipSeqGapLocked(client)
+}
+
+// skipSeqGapLocked 放弃缺失的序号，从缓冲中最小的序号继续处理
+func (s *RelaySession) skipSeqGapLocked(client *wsClientConn) {
+	q := &client.seq
+	if len(q.pending) == 0 {
+		return
+	}
+	seqs := make([]int64, 0, len(q.pending))
+	for seq := range q.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	gap := SeqGap{Expected: q.next, Received: seqs[0]}
+	hubMetrics.Add("hub_client_seq_skipped_total", gap.Received-gap.Expected)
+	s.notifyClient(client, WebSocketMessage{Type: MessageTypeNotify, Action: ActionSeqSkipped, Data: gap})
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.next = seqs[0]
+	s.drainSeqLocked(client)
+	// 之后仍有缺口时重新计时
+	if len(q.pending) > 0 {
+		if timeout := hubConfig.ClientSeqTimeout.D(); timeout > 0 {
+			q.timer = time.AfterFunc(timeout, func() { s.skipSeqGap(client) })
+		}
+	}
+}
+
+// stopSeq 前端断开时调用，丢弃尚未处理的消息
+func (c *wsClientConn) stopSeq() {
+	c.seq.mu.Lock()
+	defer c.seq.mu.Unlock()
+	if c.seq.timer != nil {
+		c.seq.timer.Stop()
+		c.seq.timer = nil
+	}
+	c.seq.pending = nil
+}
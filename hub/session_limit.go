@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 会话数上限：Max 限制整个 hub 同时存在的会话数，PerIP 限制同一来源 IP 创建的会话数，0 表示不限制。
+// 只在创建新会话时检查，加入已有会话的前端不受限制；超出时 HandleConnection 在升级前返回 503 和 Retry-After
+// -----------------------
+
+// SessionLimitConfig 会话数上限，来源 IP 为会话第一个前端的 IP
+type SessionLimitConfig struct {
+	Max        int      `json:"max"`
+	PerIP      int      `json:"perIP"`
+	RetryAfter Duration `json:"retryAfter"`
+}
+
+func (cfg SessionLimitConfig) validate() error {
+	if cfg.Max < 0 || cfg.PerIP < 0 {
+		return errors.New("sessionLimit.max and sessionLimit.perIP must not be negative")
+	}
+	if cfg.RetryAfter.D() < 0 {
+		return errors.New("sessionLimit.retryAfter must not be negative")
+	}
+	return nil
+}
+
+// sessionLimitError 创建会话时超出上限，Scope 为 hub 或 ip
+type sessionLimitError struct {
+	Scope string
+	Limit int
+}
+
+func (e *sessionLimitError) Error() string {
+	return fmt.Sprintf("too many sessions (%s limit %d)", e.Scope, e.Limit)
+}
+
+// admitSession token 的会话已存在或仍有名额时返回 nil，只做检查，名额在 getSession 创建会话时占用
+func (h *RelayHub) admitSession(token, ip string) *sessionLimitError {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.sessions[token]; exists {
+		return nil
+	}
+	return h.checkSessionLimit(ip)
+}
+
+// checkSessionLimit 在 h.mu 锁内调用，ip 为空时（比如 Unix socket 连接）不检查单 IP 上限
+func (h *RelayHub) checkSessionLimit(ip string) *sessionLimitError {
+	cfg := hubConfig.SessionLimit
+	if cfg.Max > 0 && len(h.sessions) >= cfg.Max {
+		return &sessionLimitError{Scope: "hub", Limit: cfg.Max}
+	}
+	if cfg.PerIP > 0 && ip != "" && h.sessionsByIP[ip] >= cfg.PerIP {
+		return &sessionLimitError{Scope: "ip", Limit: cfg.PerIP}
+	}
+	return nil
+}
+
+// rejectSessionLimit 返回 503，Retry-After 为配置的等待秒数
+func rejectSessionLimit(c echo.Context, err *sessionLimitError) error {
+	hubMetrics.Inc("hub_session_limit_rejections_total", "scope", err.Scope)
+	if wait := hubConfig.SessionLimit.RetryAfter.D(); wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	}
+	return c.JSON(http.StatusServiceUnavailable, map[string]string{
+		"error":   "session_limit",
+		"message": err.Error(),
+	})
+}
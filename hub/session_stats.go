@@ -0,0 +1,56 @@
+package hub
+
+import "time"
+
+// -----------------------
+// 会话统计：前端发送本地 action "stats" 获取所在会话两个方向的字节数和消息数、agent 状态和重连次数，
+// 用于在页面上展示连接状况，不需要调用管理接口
+// -----------------------
+
+const ActionStats = "stats"
+
+// DirectionStats 一个方向的累计流量
+type DirectionStats struct {
+	Bytes    int64 `json:"bytes"`
+	Messages int64 `json:"messages"`
+}
+
+// SessionStats "stats" 的响应数据
+type SessionStats struct {
+	ConnectedAt  time.Time      `json:"connectedAt"`
+	UptimeMs     int64          `json:"uptimeMs"`
+	LastActivity time.Time      `json:"lastActivity"`
+	AgentState   string         `json:"agentState"` // connected / reconnecting / none
+	Reconnects   int64          `json:"reconnects"`
+	Clients      int            `json:"clients"`
+	Pending      int            `json:"pending"`  // agent 重连期间暂存的消息数
+	Inflight     int            `json:"inflight"` // 已转发、尚未收到响应的请求数
+	FromClient   DirectionStats `json:"fromClient"`
+	FromAgent    DirectionStats `json:"fromAgent"`
+}
+
+func (s *RelaySession) stats() SessionStats {
+	st := SessionStats{
+		ConnectedAt:  s.createdAt,
+		UptimeMs:     time.Since(s.createdAt).Milliseconds(),
+		LastActivity: s.lastActive(),
+		Reconnects:   s.reconnects.Load(),
+		FromClient:   DirectionStats{Bytes: s.bytesFromClient.Load(), Messages: s.msgsFromClient.Load()},
+		FromAgent:    DirectionStats{Bytes: s.bytesFromAgent.Load(), Messages: s.msgsFromAgent.Load()},
+	}
+	st.AgentState, _ = s.agentState()
+	s.clientMu.Lock()
+	st.Clients = len(s.clients)
+	s.clientMu.Unlock()
+	s.stateMu.Lock()
+	st.Pending = len(s.pending)
+	st.Inflight = len(s.inflight)
+	s.stateMu.Unlock()
+	return st
+}
+
+func init() {
+	RegisterLocalHandler(ActionStats, func(lc *LocalContext, msg WebSocketMessage) (interface{}, error) {
+		return lc.session.stats(), nil
+	})
+}
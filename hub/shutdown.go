@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 优雅退出：停止接受新连接，通知全部前端和 agent，等待进行中的请求完成后关闭会话
+// -----------------------
+
+// hubDraining 为 true 时拒绝新的 WS 升级
+var hubDraining atomic.Bool
+
+// rejectDraining 在 hub 正在退出时返回 503
+func rejectDraining(c echo.Context) error {
+	hubMetrics.Inc("hub_draining_rejections_total")
+	return c.JSON(http.StatusServiceUnavailable, map[string]string{
+		"error":   "server_shutdown",
+		"message": "Hub is shutting down",
+	})
+}
+
+// trackRequest 记录一条已转发给 agent、尚未收到响应的请求
+func (s *RelaySession) trackRequest(requestID string) {
+	if requestID == "" {
+		return
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.inflight == nil {
+		s.inflight = make(map[string]struct{})
+	}
+	s.inflight[requestID] = struct{}{}
+}
+
+// completeRequest 在收到 agent 对某个请求的响应后调用
+func (s *RelaySession) completeRequest(data []byte) {
+	var head struct {
+		Type      string    `json:"t"`
+		RequestID string    `json:"r"`
+		Frag      *Fragment `json:"fg"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil || head.Type != MessageTypeResponse || head.RequestID == "" {
+		return
+	}
+	// 分片响应在最后一片到达时才算完成
+	if head.Frag != nil && !head.Frag.Final {
+		return
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	delete(s.inflight, head.RequestID)
+}
+
+func (s *RelaySession) inflightCount() int {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return len(s.inflight)
+}
+
+// notifyShutdown 向会话的全部前端和 agent 发送 server_shutdown
+func (s *RelaySession) notifyShutdown(drain time.Duration) {
+	notify := WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: "server_shutdown",
+		Data:   map[string]interface{}{"drainSeconds": int(drain.Seconds())},
+	}
+	s.sendNotify(notify)
+	notifyData, _ := json.Marshal(notify)
+	s.agentMu.Lock()
+	if s.agent != nil {
+		_ = s.agent.Send(notifyData)
+	}
+	s.agentMu.Unlock()
+}
+
+// drain 通知全部会话后等待进行中的请求完成，超时或全部完成后关闭所有会话
+func (h *RelayHub) drain(ctx context.Context, timeout time.Duration) {
+	hubDraining.Store(true)
+	sessions := h.listSessions()
+	log.Printf("Draining %d sessions, timeout %v", len(sessions), timeout)
+	for _, sess := range sessions {
+		sess.notifyShutdown(timeout)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for {
+		inflight := 0
+		for _, sess := range sessions {
+			inflight += sess.inflightCount()
+		}
+		if inflight == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-deadline.C:
+			log.Printf("Drain timeout with %d in-flight requests", inflight)
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	for _, sess := range h.listSessions() {
+		sess.setEndReason(EndReasonShutdown)
+		sess.cleanup()
+	}
+	log.Println("All sessions closed")
+}
@@ -0,0 +1,146 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// 慢消费者检测：统计前端连接的写耗时和排队时长，持续偏慢时通知、降级，最后断开。
+// 写阻塞（当前一帧迟迟写不完）和队列积压超过上限时不等连续慢帧计数，直接断开，避免内存持续增长
+// -----------------------
+
+// CloseCodeSlowConsumer 前端跟不上时 hub 以此关闭码断开，原因为 SlowConsumerReason；
+// 属于应用自定义关闭码，前端可以重连，重连后不会收到断开前积压的消息
+const (
+	CloseCodeSlowConsumer = 4008
+	SlowConsumerReason    = "slow consumer"
+)
+
+// 降级状态下可以丢弃的 notify
+var nonEssentialNotifies = map[string]bool{
+	"reconnecting": true,
+}
+
+// SlowConsumerStats 为 slow_consumer 通知中携带的统计信息
+type SlowConsumerStats struct {
+	AvgWriteLatencyMs int64 `json:"avgWriteLatencyMs"`
+	AvgResidencyMs    int64 `json:"avgResidencyMs"`
+	QueueDepth        int   `json:"queueDepth"`
+	StallMs           int64 `json:"stallMs"` // 当前一帧已写了多久，0 表示没有正在写的帧
+	SlowFrames        int   `json:"slowFrames"`
+	Degraded          bool  `json:"degraded"`
+}
+
+type clientStats struct {
+	mu           sync.Mutex
+	avgLatency   time.Duration // 写耗时的滑动平均
+	avgResidency time.Duration // 排队时长的滑动平均
+	slowFrames   int           // 连续慢帧数
+	notified     bool          // 本轮偏慢是否已通知
+	degraded     bool
+	writingSince time.Time // 当前一帧开始写的时间，零值表示没有正在写的帧
+	disconnected bool
+}
+
+// beginWrite 由 writePump 在写一帧之前调用
+func (st *clientStats) beginWrite(start time.Time) {
+	st.mu.Lock()
+	st.writingSince = start
+	st.mu.Unlock()
+}
+
+// observe 由 writePump 在每帧写完后调用
+func (st *clientStats) observe(latency, residency time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.writingSince = time.Time{}
+	st.avgLatency = (st.avgLatency*7 + latency) / 8
+	st.avgResidency = (st.avgResidency*7 + residency) / 8
+	if latency > hubConfig.SlowConsumerWriteLatency.D() || residency > hubConfig.SlowConsumerResidency.D() {
+		st.slowFrames++
+		return
+	}
+	// 恢复正常后解除降级，下次变慢重新通知
+	st.slowFrames = 0
+	st.notified = false
+	st.degraded = false
+}
+
+func (st *clientStats) isDegraded() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.degraded
+}
+
+// checkSlowClient 在向前端入队后调用，调用方需持有 clientMu
+func (s *RelaySession) checkSlowClient(client *wsClientConn) {
+	strikes, maxStall, maxQueue := hubConfig.SlowConsumerStrikes, hubConfig.SlowConsumerStall.D(), hubConfig.SlowConsumerMaxQueue
+	if strikes <= 0 && maxStall <= 0 && maxQueue <= 0 {
+		return
+	}
+	depth := queueDepth(client.sendHigh, client.send, client.sendLow)
+	st := &client.stats
+	st.mu.Lock()
+	if st.disconnected {
+		st.mu.Unlock()
+		return
+	}
+	var stall time.Duration
+	if !st.writingSince.IsZero() {
+		stall = time.Since(st.writingSince)
+	}
+	slowFrames := st.slowFrames
+	// 超过上限直接断开，超过一半时先通知
+	overStall := maxStall > 0 && stall >= maxStall
+	overQueue := maxQueue > 0 && depth >= maxQueue
+	warn := maxStall > 0 && stall*2 >= maxStall || maxQueue > 0 && depth*2 >= maxQueue
+	disconnect := overStall || overQueue ||
+		hubConfig.SlowConsumerDisconnectStrikes > 0 && slowFrames >= hubConfig.SlowConsumerDisconnectStrikes
+	st.disconnected = disconnect
+	notify := (strikes > 0 && slowFrames >= strikes || warn) && !st.notified
+	if notify {
+		st.notified = true
+		st.degraded = hubConfig.SlowConsumerDegrade
+	}
+	stats := SlowConsumerStats{
+		AvgWriteLatencyMs: st.avgLatency.Milliseconds(),
+		AvgResidencyMs:    st.avgResidency.Milliseconds(),
+		QueueDepth:        depth,
+		StallMs:           stall.Milliseconds(),
+		SlowFrames:        slowFrames,
+		Degraded:          st.degraded,
+	}
+	st.mu.Unlock()
+
+	if disconnect {
+		reason := "strikes"
+		switch {
+		case overStall:
+			reason = "stall"
+		case overQueue:
+			reason = "queue"
+		}
+		hubMetrics.Inc("hub_slow_consumer_total", "action", "disconnect", "reason", reason)
+		log.Printf("Session %s client is too slow (%s), disconnecting: %+v", s.token, reason, stats)
+		// 写关闭帧可能因连接阻塞而等待，调用方持有 clientMu，放到 goroutine 中
+		go client.abortWithCode(CloseCodeSlowConsumer, SlowConsumerReason)
+		return
+	}
+	if !notify {
+		return
+	}
+
+	hubMetrics.Inc("hub_slow_consumer_total", "action", "notify")
+	log.Printf("Session %s client is slow: %+v", s.token, stats)
+	notifyData, _ := json.Marshal(WebSocketMessage{
+		Type:     MessageTypeNotify,
+		Action:   "slow_consumer",
+		Data:     stats,
+		Priority: PriorityHigh,
+	})
+	// 队列已经积压，不阻塞等待
+	_ = client.trySend(notifyData)
+}
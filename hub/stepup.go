@@ -0,0 +1,407 @@
+package hub
+
+import (
+	"echo_demo/users"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 二次验证（step-up）：命中 StepUp.Rules 的前端消息（比如生产分组上的 exec、递归删除）在转发前
+// 要求该连接重新完成一次 TOTP 验证。hub 暂存这条消息并回复 step_up_required，前端带上验证码
+// 发送 step_up 后再转发暂存的消息；验证在 MaxAge 内有效，期间同一连接的敏感操作不再重复验证。
+// 同一用户连续验证失败 MaxAttempts 次（跨挑战和连接累计）后锁定 Lockout，期间不再发出挑战也不校验验证码。
+// TOTP 密钥绑定在本地用户上，由管理接口签发
+// -----------------------
+
+const (
+	ActionStepUp         = "step_up"          // 前端 -> hub，d 为 StepUpData
+	ActionStepUpRequired = "step_up_required" // hub -> 前端，d 为 StepUpChallenge
+	ActionStepUpOK       = "step_up_ok"       // hub -> 前端，验证通过，暂存的消息已转发
+)
+
+// 二次验证的错误码
+const (
+	ErrCodeStepUpUnavailable = "step_up_unavailable" // 连接的身份没有绑定第二因素，无法执行该操作
+	ErrCodeStepUpFailed      = "step_up_failed"      // 验证码错误、挑战不存在或已过期
+	ErrCodeStepUpLocked      = "step_up_locked"      // 连续验证失败次数过多，用户处于锁定期
+)
+
+// totpSkew 验证码允许前后偏差的步数，容忍客户端时钟误差
+const totpSkew = 1
+
+// StepUpRule Actions 为空的规则不生效；Groups 为 agent 在清单中的分组，为空表示不限分组；
+// Match 要求消息 d 中的同名字段取值相等，比如 {"recursive": true}；d 为密文时只按 Actions 和 Groups 判断
+type StepUpRule struct {
+	Actions []string               `json:"actions"`
+	Groups  []string               `json:"groups,omitempty"`
+	Match   map[string]interface{} `json:"match,omitempty"`
+}
+
+// StepUpConfig Rules 为空时不启用
+type StepUpConfig struct {
+	Rules            []StepUpRule `json:"rules,omitempty"`
+	MaxAge           Duration     `json:"maxAge"`           // 验证通过后的有效期，0 表示每次都需要验证
+	ChallengeTimeout Duration     `json:"challengeTimeout"` // 暂存消息等待验证的最长时间
+	MaxAttempts      int          `json:"maxAttempts"`      // 每个用户连续验证失败的次数上限，跨挑战累计
+	Lockout          Duration     `json:"lockout"`          // 失败次数达到上限后的锁定时长
+	Issuer           string       `json:"issuer"`           // 验证器应用中显示的签发方
+}
+
+func (cfg StepUpConfig) validate(usersCfg UsersConfig) error {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	if usersCfg.Store == "" {
+		return errors.New("stepUp requires users.store for TOTP secrets")
+	}
+	for i, rule := range cfg.Rules {
+		if len(rule.Actions) == 0 {
+			return fmt.Errorf("stepUp.rules[%d]: actions is required", i)
+		}
+	}
+	if cfg.MaxAge.D() < 0 || cfg.ChallengeTimeout.D() <= 0 {
+		return errors.New("stepUp.maxAge must not be negative and stepUp.challengeTimeout must be positive")
+	}
+	if cfg.MaxAttempts <= 0 || cfg.Lockout.D() <= 0 {
+		return errors.New("stepUp.maxAttempts and stepUp.lockout must be positive")
+	}
+	return nil
+}
+
+// covers 是否有规则对 action 要求二次验证
+func (cfg StepUpConfig) covers(action string) bool {
+	for _, rule := range cfg.Rules {
+		if containsString(rule.Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches 规则是否命中 agent 分组为 groups 的会话上的这条消息
+func (rule StepUpRule) matches(msg WebSocketMessage, groups []string) bool {
+	if !containsString(rule.Actions, msg.Action) {
+		return false
+	}
+	if len(rule.Groups) > 0 {
+		hit := false
+		for _, g := range groups {
+			if containsString(rule.Groups, g) {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+	// 端到端加密时 hub 读不到 d，无法判断 Match，按命中处理
+	if len(rule.Match) == 0 || msg.Enc != "" {
+		return true
+	}
+	data, ok := msg.Data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for k, want := range rule.Match {
+		if !reflect.DeepEqual(data[k], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// StepUpChallenge step_up_required 通知的数据
+type StepUpChallenge struct {
+	Challenge        string   `json:"challenge"`
+	Methods          []string `json:"methods"`
+	ExpiresInSeconds int      `json:"expiresInSeconds"`
+}
+
+// StepUpData 前端的 step_up 消息
+type StepUpData struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// pendingStepUp 等待验证的暂存消息，每个连接最多一条，新的敏感操作会替换旧的
+type pendingStepUp struct {
+	id      string
+	msg     WebSocketMessage
+	data    []byte
+	expires time.Time
+}
+
+// totpReplay 记录每个用户最近一次使用的步数，同一验证码不能使用两次
+var totpReplay = struct {
+	sync.Mutex
+	last map[string]int64
+}{last: make(map[string]int64)}
+
+// useTOTPStep 步数大于上次使用的步数时记录并返回 true
+func useTOTPStep(username string, step int64) bool {
+	totpReplay.Lock()
+	defer totpReplay.Unlock()
+	if last, ok := totpReplay.last[username]; ok && step <= last {
+		return false
+	}
+	totpReplay.last[username] = step
+	return true
+}
+
+// stepUpFailures 每个用户连续验证失败的次数和锁定截止时间，验证通过后清除
+var stepUpFailures = struct {
+	sync.Mutex
+	users map[string]*stepUpFailure
+}{users: make(map[string]*stepUpFailure)}
+
+type stepUpFailure struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// stepUpLockedFor 用户处于锁定期时返回剩余时长
+func stepUpLockedFor(username string) (time.Duration, bool) {
+	stepUpFailures.Lock()
+	defer stepUpFailures.Unlock()
+	f := stepUpFailures.users[username]
+	if f == nil {
+		return 0, false
+	}
+	left := time.Until(f.lockedUntil)
+	return left, left > 0
+}
+
+// recordStepUpFailure 记录一次失败，返回剩余次数；次数用完时锁定用户并重新计数
+func recordStepUpFailure(username string) (left int, locked bool) {
+	stepUpFailures.Lock()
+	defer stepUpFailures.Unlock()
+	f := stepUpFailures.users[username]
+	if f == nil {
+		f = &stepUpFailure{}
+		stepUpFailures.users[username] = f
+	}
+	f.count++
+	left = hubConfig.StepUp.MaxAttempts - f.count
+	if left > 0 {
+		return left, false
+	}
+	f.count = 0
+	f.lockedUntil = time.Now().Add(hubConfig.StepUp.Lockout.D())
+	return 0, true
+}
+
+// resetStepUpFailures 验证通过后清除失败记录
+func resetStepUpFailures(username string) {
+	stepUpFailures.Lock()
+	defer stepUpFailures.Unlock()
+	delete(stepUpFailures.users, username)
+}
+
+// notifyStepUpLocked 回复锁定错误
+func (s *RelaySession) notifyStepUpLocked(client *wsClientConn, requestID string, left time.Duration) {
+	hubMetrics.Inc("hub_step_up_total", "result", "locked")
+	s.notifyError(client, requestID, ErrCodeStepUpLocked, fmt.Sprintf("too many failed verification attempts, try again in %s", left.Round(time.Second)))
+}
+
+// stepUpRuleFor 返回消息命中的第一条规则
+func (s *RelaySession) stepUpRuleFor(msg WebSocketMessage) (StepUpRule, bool) {
+	rules := hubConfig.StepUp.Rules
+	if len(rules) == 0 {
+		return StepUpRule{}, false
+	}
+	agent, _ := hubInventory.agentByToken(s.token)
+	for _, rule := range rules {
+		if rule.matches(msg, agent.Groups) {
+			return rule, true
+		}
+	}
+	return StepUpRule{}, false
+}
+
+// stepUpFresh 连接在 MaxAge 内是否完成过验证
+func (c *wsClientConn) stepUpFresh() bool {
+	maxAge := hubConfig.StepUp.MaxAge.D()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maxAge > 0 && !c.stepUpAt.IsZero() && time.Since(c.stepUpAt) < maxAge
+}
+
+// stepUpUser 连接身份对应的本地用户，没有绑定 TOTP 时返回错误
+func stepUpUser(ident *Identity) (users.User, error) {
+	if hubUsers == nil || ident == nil {
+		return users.User{}, errors.New("no local user for this connection")
+	}
+	u, err := hubUsers.GetUser(ident.Subject)
+	if err != nil {
+		return users.User{}, err
+	}
+	if u.Disabled {
+		return users.User{}, users.ErrDisabled
+	}
+	if u.TOTPSecret == "" {
+		return users.User{}, errors.New("no second factor enrolled")
+	}
+	return u, nil
+}
+
+// requireStepUp 消息需要二次验证且连接没有有效的验证时暂存消息并发出挑战，返回 true 表示消息已被拦下
+func (s *RelaySession) requireStepUp(client *wsClientConn, msg WebSocketMessage, data []byte) bool {
+	if _, ok := s.stepUpRuleFor(msg); !ok || client.stepUpFresh() {
+		return false
+	}
+	if _, err := stepUpUser(client.ident); err != nil {
+		hubMetrics.Inc("hub_step_up_total", "result", "unavailable")
+		s.notifyError(client, msg.RequestID, ErrCodeStepUpUnavailable, fmt.Sprintf("action %q requires step-up verification: %v", msg.Action, err))
+		return true
+	}
+	if left, locked := stepUpLockedFor(client.ident.Subject); locked {
+		s.notifyStepUpLocked(client, msg.RequestID, left)
+		return true
+	}
+	id, err := randomString(16)
+	if err != nil {
+		s.notifyError(client, msg.RequestID, ErrCodeStepUpUnavailable, err.Error())
+		return true
+	}
+	timeout := hubConfig.StepUp.ChallengeTimeout.D()
+	client.mu.Lock()
+	client.stepUp = &pendingStepUp{id: id, msg: msg, data: data, expires: time.Now().Add(timeout)}
+	client.mu.Unlock()
+	hubMetrics.Inc("hub_step_up_total", "result", "challenged")
+	s.notifyClient(client, WebSocketMessage{
+		Type:      MessageTypeNotify,
+		RequestID: msg.RequestID,
+		Action:    ActionStepUpRequired,
+		Data:      StepUpChallenge{Challenge: id, Methods: []string{"totp"}, ExpiresInSeconds: int(timeout.Seconds())},
+	})
+	return true
+}
+
+// handleStepUp 处理 step_up 消息，验证通过后转发暂存的消息；不是 step_up 时返回 false
+func (s *RelaySession) handleStepUp(client *wsClientConn, msg WebSocketMessage) bool {
+	if msg.Action != ActionStepUp {
+		return false
+	}
+	var req StepUpData
+	if err := decodeData(msg.Data, &req); err != nil || req.Challenge == "" || req.Code == "" {
+		s.notifyError(client, msg.RequestID, ErrCodeBadMessage, "step_up requires challenge and code")
+		return true
+	}
+
+	client.mu.Lock()
+	pending := client.stepUp
+	if pending == nil || pending.id != req.Challenge || time.Now().After(pending.expires) {
+		client.stepUp = nil
+		client.mu.Unlock()
+		hubMetrics.Inc("hub_step_up_total", "result", "expired")
+		s.notifyError(client, msg.RequestID, ErrCodeStepUpFailed, "step-up challenge not found or expired")
+		return true
+	}
+	client.mu.Unlock()
+
+	username := client.ident.Subject
+	if left, locked := stepUpLockedFor(username); locked {
+		client.mu.Lock()
+		if client.stepUp == pending {
+			client.stepUp = nil
+		}
+		client.mu.Unlock()
+		s.notifyStepUpLocked(client, msg.RequestID, left)
+		return true
+	}
+	u, err := stepUpUser(client.ident)
+	var verified bool
+	if err == nil {
+		var step int64
+		step, verified = users.VerifyTOTP(u.TOTPSecret, req.Code, time.Now(), totpSkew)
+		verified = verified && useTOTPStep(u.Username, step)
+	}
+
+	client.mu.Lock()
+	if client.stepUp != pending {
+		// 验证期间被新的敏感操作替换
+		client.mu.Unlock()
+		s.notifyError(client, msg.RequestID, ErrCodeStepUpFailed, "step-up challenge was replaced")
+		return true
+	}
+	if !verified {
+		left, locked := recordStepUpFailure(username)
+		if locked {
+			client.stepUp = nil
+		}
+		client.mu.Unlock()
+		hubMetrics.Inc("hub_step_up_total", "result", "failed")
+		if locked {
+			hubMetrics.Inc("hub_step_up_lockouts_total")
+			log.Printf("Session %s step-up failed for %s, locked for %s", s.token, username, hubConfig.StepUp.Lockout.D())
+			s.notifyStepUpLocked(client, msg.RequestID, hubConfig.StepUp.Lockout.D())
+			return true
+		}
+		log.Printf("Session %s step-up failed for %s (%d attempts left)", s.token, username, left)
+		s.notifyError(client, msg.RequestID, ErrCodeStepUpFailed, fmt.Sprintf("invalid verification code, %d attempts left", left))
+		return true
+	}
+	client.stepUp = nil
+	client.stepUpAt = time.Now()
+	client.mu.Unlock()
+	resetStepUpFailures(username)
+
+	hubMetrics.Inc("hub_step_up_total", "result", "verified")
+	log.Printf("Session %s step-up verified for %s, forwarding %q", s.token, u.Username, pending.msg.Action)
+	s.notifyClient(client, WebSocketMessage{
+		Type:      MessageTypeNotify,
+		RequestID: msg.RequestID,
+		Action:    ActionStepUpOK,
+		Data:      map[string]interface{}{"requestId": pending.msg.RequestID, "action": pending.msg.Action},
+	})
+	s.dispatchClientMessage(client, pending.msg, pending.data)
+	return true
+}
+
+// -----------------------
+// TOTP 绑定管理接口
+// -----------------------
+
+// EnrollTOTPHandler 为用户生成新的 TOTP 密钥，替换已有的绑定；密钥和 otpauth URI 只在本次响应中返回
+func EnrollTOTPHandler(c echo.Context) error {
+	u, err := hubUsers.GetUser(c.Param("name"))
+	if err != nil {
+		return usersError(c, err)
+	}
+	secret, err := users.NewTOTPSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	u.TOTPSecret = secret
+	if err := hubUsers.UpdateUser(u); err != nil {
+		return usersError(c, err)
+	}
+	log.Printf("TOTP secret issued for user %s", u.Username)
+	issuer := hubConfig.StepUp.Issuer
+	return c.JSON(http.StatusCreated, map[string]string{
+		"secret": secret,
+		"uri":    users.TOTPURI(issuer, u.Username, secret),
+	})
+}
+
+// DeleteTOTPHandler 解除用户的 TOTP 绑定
+func DeleteTOTPHandler(c echo.Context) error {
+	u, err := hubUsers.GetUser(c.Param("name"))
+	if err != nil {
+		return usersError(c, err)
+	}
+	u.TOTPSecret = ""
+	if err := hubUsers.UpdateUser(u); err != nil {
+		return usersError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
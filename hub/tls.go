@@ -0,0 +1,217 @@
+package hub
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// -----------------------
+// TLS：主监听可以直接提供 HTTPS/WSS，证书来自文件或通过 ACME（Let's Encrypt）自动申请；
+// 证书文件被替换后在下一次握手时重新加载，不需要重启。
+// 主动拨号 agent 时的 TLS 选项（自定义 CA、客户端证书、跳过校验）由 AgentTLS 配置
+// -----------------------
+
+// TLSConfig CertFile 和 Autocert.Domains 都为空时主监听使用明文 HTTP
+type TLSConfig struct {
+	CertFile   string         `json:"certFile,omitempty"`
+	KeyFile    string         `json:"keyFile,omitempty"`
+	Autocert   AutocertConfig `json:"autocert"`
+	MinVersion string         `json:"minVersion"` // "1.2" 或 "1.3"
+}
+
+// AutocertConfig HTTPAddr 不为空时在该地址提供 HTTP-01 验证并把其它请求重定向到 HTTPS，
+// 为空时只使用 TLS-ALPN-01（要求主监听为 443 端口）
+type AutocertConfig struct {
+	Domains      []string `json:"domains,omitempty"`
+	Email        string   `json:"email,omitempty"`
+	CacheDir     string   `json:"cacheDir,omitempty"`
+	HTTPAddr     string   `json:"httpAddr,omitempty"`
+	DirectoryURL string   `json:"directoryURL,omitempty"` // 默认 Let's Encrypt 正式环境
+}
+
+// Enabled 主监听是否使用 TLS
+func (cfg TLSConfig) Enabled() bool {
+	return cfg.CertFile != "" || len(cfg.Autocert.Domains) > 0
+}
+
+func (cfg TLSConfig) validate() error {
+	if _, err := tlsVersion(cfg.MinVersion); err != nil {
+		return err
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("tls.certFile and tls.keyFile must be set together")
+	}
+	if cfg.CertFile != "" && len(cfg.Autocert.Domains) > 0 {
+		return errors.New("tls.certFile and tls.autocert are mutually exclusive")
+	}
+	if len(cfg.Autocert.Domains) > 0 && cfg.Autocert.CacheDir == "" {
+		// 不缓存时每次启动都会重新申请证书，很容易触发 ACME 的频率限制
+		return errors.New("tls.autocert.cacheDir is required")
+	}
+	return nil
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported tls minVersion %q", v)
+}
+
+// certReloader 证书文件的修改时间变化后重新加载，检查间隔为 certCheckInterval
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+const certCheckInterval = 10 * time.Second
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	st, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, st.ModTime()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if st, err := os.Stat(r.certFile); err == nil && !st.ModTime().Equal(r.modTime) {
+			// 证书和私钥可能还没有全部写完，加载失败时继续使用旧证书
+			if err := r.load(); err != nil {
+				log.Println("TLS certificate reload error:", err)
+			} else {
+				log.Println("TLS certificate reloaded from", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// serverTLSConfig 创建主监听的 TLS 配置，使用 autocert 且配置了 HTTPAddr 时同时启动验证服务
+func serverTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	minVersion, err := tlsVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CertFile != "" {
+		reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{GetCertificate: reloader.getCertificate, MinVersion: minVersion}, nil
+	}
+
+	ac := cfg.Autocert
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(ac.Domains...),
+		Cache:      autocert.DirCache(ac.CacheDir),
+		Email:      ac.Email,
+	}
+	if ac.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: ac.DirectoryURL}
+	}
+	if ac.HTTPAddr != "" {
+		ln, err := net.Listen("tcp", ac.HTTPAddr)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			if err := http.Serve(ln, m.HTTPHandler(nil)); err != nil {
+				log.Println("ACME HTTP server error:", err)
+			}
+		}()
+		log.Println("ACME HTTP-01 handler on", ln.Addr())
+	}
+	tlsCfg := m.TLSConfig()
+	tlsCfg.MinVersion = minVersion
+	return tlsCfg, nil
+}
+
+// AgentTLSConfig 主动拨号 wss:// agent 时使用，CertFile 和 KeyFile 为 agent 要求双向认证时的客户端证书
+type AgentTLSConfig struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+func (cfg AgentTLSConfig) validate() error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("agentTLS.certFile and agentTLS.keyFile must be set together")
+	}
+	return nil
+}
+
+// configureAgentTLS 设置拨号器的 TLS 选项，应在 configureCompression 之后调用
+func configureAgentTLS(cfg AgentTLSConfig) error {
+	if cfg == (AgentTLSConfig{}) {
+		return nil
+	}
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.InsecureSkipVerify {
+		log.Println("WARNING: agent TLS certificate verification is disabled")
+	}
+	dialer := *agentDialer
+	dialer.TLSClientConfig = tlsCfg
+	agentDialer = &dialer
+	return nil
+}
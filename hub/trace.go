@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"echo_demo/tracing"
+	"encoding/json"
+	"time"
+)
+
+// -----------------------
+// 中继链路追踪：消息信封中的 tp 字段携带 W3C traceparent，每个带 RequestID 的请求记录四个 span：
+// client-receive（前端消息读到解析完成）、agent-forward（转发给 agent，tp 换成该 span 后注入消息）、
+// agent-response（转发到收到 agent 响应）、client-deliver（响应投递给前端）。
+// 前端没有带 tp 时由 hub 开始新的 trace；agent 可以沿用收到的 tp 继续记录自己的 span
+// -----------------------
+
+// hubTracer 为 nil 表示未启用追踪
+var hubTracer *tracing.Tracer
+
+// maxTracedRequests 每个会话等待响应的请求上限，超过时丢弃最早的记录
+const maxTracedRequests = 4096
+
+// requestTrace 已转发给 agent、等待响应的请求
+type requestTrace struct {
+	forward tracing.SpanContext
+	sentAt  time.Time
+	action  string
+}
+
+// traceClientReceive 前端消息解析完成后调用：以消息中的 tp 为父记录 client-receive，
+// 并把 msg.Trace 换成该 span 的上下文，后续环节以它为父
+func (s *RelaySession) traceClientReceive(msg *WebSocketMessage, readAt time.Time) {
+	if hubTracer == nil || msg.RequestID == "" {
+		return
+	}
+	parent, _ := tracing.ParseTraceparent(msg.Trace)
+	span := hubTracer.StartAt("client-receive", parent, tracing.KindServer, readAt)
+	span.SetAttr("wshub.request_id", msg.RequestID)
+	span.SetAttr("wshub.action", msg.Action)
+	span.End()
+	msg.Trace = span.Context().Traceparent()
+}
+
+// traceAgentForward 转发给 agent 前调用，返回注入了 tp 的消息和 agent-forward span，调用方在入队后结束 span
+func (s *RelaySession) traceAgentForward(msg WebSocketMessage, data []byte, agent string) ([]byte, *tracing.Span) {
+	if hubTracer == nil || msg.RequestID == "" {
+		return data, nil
+	}
+	parent, _ := tracing.ParseTraceparent(msg.Trace)
+	span := hubTracer.Start("agent-forward", parent, tracing.KindProducer)
+	span.SetAttr("wshub.request_id", msg.RequestID)
+	span.SetAttr("wshub.action", msg.Action)
+	span.SetAttr("wshub.agent", agent)
+
+	s.stateMu.Lock()
+	if s.traces == nil {
+		s.traces = make(map[string]requestTrace)
+	}
+	if len(s.traces) >= maxTracedRequests {
+		oldest := ""
+		for id, tr := range s.traces {
+			if oldest == "" || tr.sentAt.Before(s.traces[oldest].sentAt) {
+				oldest = id
+			}
+		}
+		delete(s.traces, oldest)
+	}
+	s.traces[msg.RequestID] = requestTrace{forward: span.Context(), sentAt: time.Now(), action: msg.Action}
+	s.stateMu.Unlock()
+
+	return injectTrace(data, span.Context().Traceparent()), span
+}
+
+// injectTrace 替换消息的 tp 字段，不是 JSON 对象时原样返回
+func injectTrace(data []byte, traceparent string) []byte {
+	var fields map[string]json.RawMessage
+	if traceparent == "" || json.Unmarshal(data, &fields) != nil {
+		return data
+	}
+	fields["tp"], _ = json.Marshal(traceparent)
+	injected, err := json.Marshal(fields)
+	if err != nil {
+		return data
+	}
+	return injected
+}
+
+// traceAgentResponse 收到 agent 消息时调用：是已追踪请求的响应时记录 agent-response，
+// 并返回 client-deliver span，调用方在投递后结束；其它消息返回 nil
+func (s *RelaySession) traceAgentResponse(data []byte) *tracing.Span {
+	if hubTracer == nil {
+		return nil
+	}
+	var head struct {
+		Type      string        `json:"t"`
+		RequestID string        `json:"r"`
+		Error     *MessageError `json:"e"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil || head.Type != MessageTypeResponse || head.RequestID == "" {
+		return nil
+	}
+	s.stateMu.Lock()
+	tr, ok := s.traces[head.RequestID]
+	delete(s.traces, head.RequestID)
+	s.stateMu.Unlock()
+	if !ok {
+		return nil
+	}
+	resp := hubTracer.StartAt("agent-response", tr.forward, tracing.KindConsumer, tr.sentAt)
+	resp.SetAttr("wshub.request_id", head.RequestID)
+	resp.SetAttr("wshub.action", tr.action)
+	if head.Error != nil {
+		resp.SetAttr("wshub.error_code", head.Error.Code)
+		resp.SetError(head.Error.Reason)
+	}
+	resp.End()
+
+	deliver := hubTracer.Start("client-deliver", resp.Context(), tracing.KindProducer)
+	deliver.SetAttr("wshub.request_id", head.RequestID)
+	return deliver
+}
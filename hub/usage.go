@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 会话资源占用：统计各会话排队、暂存、续传缓存和分片重组中的字节数，超过上限时关闭会话
+// -----------------------
+
+// CloseCodeResourceLimit 会话内存占用超过 SessionMemoryLimit 时，hub 以 1008（policy violation）
+// 关闭全部前端连接，原因为 ResourceLimitReason，前端据此可判断不应立即自动重连
+const (
+	CloseCodeResourceLimit = websocket.ClosePolicyViolation
+	ResourceLimitReason    = "session memory limit exceeded"
+)
+
+// SessionUsage 为单个会话的资源占用
+type SessionUsage struct {
+	Token             string `json:"token"`
+	Clients           int    `json:"clients"`
+	ClientQueuedBytes int64  `json:"clientQueuedBytes"` // 全部前端发送队列中的字节数
+	AgentQueuedBytes  int64  `json:"agentQueuedBytes"`  // agent 发送队列中的字节数
+	PendingBytes      int64  `json:"pendingBytes"`      // agent 重连期间暂存的字节数
+	ReplayBytes       int64  `json:"replayBytes"`       // 断线续传缓存的字节数
+	FragmentBytes     int64  `json:"fragmentBytes"`     // 全部前端正在重组的分片消息的字节数
+	TotalBytes        int64  `json:"totalBytes"`
+}
+
+func (s *RelaySession) usage() SessionUsage {
+	u := SessionUsage{Token: s.token}
+	s.clientMu.Lock()
+	u.Clients = len(s.clients)
+	for _, client := range s.clients {
+		u.ClientQueuedBytes += client.queuedBytes.Load()
+		u.FragmentBytes += client.frags.size()
+	}
+	s.clientMu.Unlock()
+
+	s.agentMu.Lock()
+	if s.agent != nil {
+		u.AgentQueuedBytes = s.agent.queuedBytes.Load()
+	}
+	s.agentMu.Unlock()
+
+	s.stateMu.Lock()
+	for _, data := range s.pending {
+		u.PendingBytes += int64(len(data))
+	}
+	for _, entry := range s.replay {
+		u.ReplayBytes += int64(len(entry.data))
+	}
+	s.stateMu.Unlock()
+
+	u.TotalBytes = u.ClientQueuedBytes + u.AgentQueuedBytes + u.PendingBytes + u.ReplayBytes + u.FragmentBytes
+	return u
+}
+
+// checkMemoryLimit 在读循环转发消息后调用，超过上限时通知前端并关闭会话
+func (s *RelaySession) checkMemoryLimit() {
+	limit := hubConfig.SessionMemoryLimit
+	if limit <= 0 {
+		return
+	}
+	u := s.usage()
+	if u.TotalBytes <= limit {
+		return
+	}
+
+	hubMetrics.Inc("hub_session_memory_limit_closes_total")
+	log.Printf("Session %s exceeds memory limit: %+v", s.token, u)
+	s.sendNotify(WebSocketMessage{
+		Type:   MessageTypeNotify,
+		Action: "resource_limit",
+		Data:   u,
+	})
+	// cleanup 按结束原因发送关闭帧
+	s.setEndReason(EndReasonMemoryLimit)
+	s.cleanup()
+}
+
+// SessionUsageHandler 返回占用最多的会话，top 参数限制条数（默认 10）
+func SessionUsageHandler(c echo.Context) error {
+	top := 10
+	if v := c.QueryParam("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "top 参数错误"})
+		}
+		top = n
+	}
+
+	var usages []SessionUsage
+	for _, sess := range relayHub.listSessions() {
+		usages = append(usages, sess.usage())
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].TotalBytes > usages[j].TotalBytes
+	})
+	if len(usages) > top {
+		usages = usages[:top]
+	}
+	return c.JSON(http.StatusOK, usages)
+}
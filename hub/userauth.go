@@ -0,0 +1,331 @@
+package hub
+
+import (
+	"echo_demo/jwtauth"
+	"echo_demo/users"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 本地用户：用户名密码或 OIDC 登录后，hub 用 JWT.HMACSecret 签发登录 token（声明中带角色），
+// 之后与外部签发的 JWT 一样通过 Sec-WebSocket-Protocol 或 Authorization 使用；
+// 机器客户端使用管理员签发的 API key（whk_ 前缀），TokenAuthProvider 识别后按用户和角色认证。
+// 用户的角色与 Authz.Users 中按主体配置的角色合并
+// -----------------------
+
+// UsersConfig Store 为空时不启用本地用户，OIDC 登录可以单独启用；file 使用 File 指定的 JSON 文件，sql 使用 Driver 和 DSN
+type UsersConfig struct {
+	Store         string     `json:"store"`
+	File          string     `json:"file,omitempty"`
+	Driver        string     `json:"driver,omitempty"` // sqlite3、postgres 等，需要在构建时链接对应驱动
+	DSN           string     `json:"dsn,omitempty"`
+	LoginTokenTTL Duration   `json:"loginTokenTTL"`
+	OIDC          OIDCConfig `json:"oidc"`
+}
+
+func (cfg UsersConfig) validate(jwt jwtauth.Config) error {
+	if err := cfg.OIDC.validate(); err != nil {
+		return err
+	}
+	switch cfg.Store {
+	case "":
+		if cfg.OIDC.AuthURL == "" {
+			return nil
+		}
+	case "file":
+		if cfg.File == "" {
+			return errors.New("users.file is required for file store")
+		}
+	case "sql":
+		if cfg.Driver == "" || cfg.DSN == "" {
+			return errors.New("users.driver and users.dsn are required for sql store")
+		}
+	default:
+		return fmt.Errorf("unknown users.store %q", cfg.Store)
+	}
+	if jwt.HMACSecret == "" {
+		return errors.New("users requires jwt.hmacSecret to sign login tokens")
+	}
+	if cfg.LoginTokenTTL.D() <= 0 {
+		return errors.New("users.loginTokenTTL must be positive")
+	}
+	return nil
+}
+
+// hubUsers 为 nil 表示未启用本地用户
+var hubUsers users.Store
+
+func openUserStore(cfg UsersConfig) (users.Store, error) {
+	if cfg.Store == "sql" {
+		return users.OpenSQL(cfg.Driver, cfg.DSN)
+	}
+	return users.OpenFile(cfg.File)
+}
+
+// authenticateAPIKey 由 TokenAuthProvider 在 token 带 whk_ 前缀时调用
+func authenticateAPIKey(key string) (*Identity, error) {
+	k, u, err := users.Authenticate(hubUsers, key)
+	if err != nil {
+		return nil, err
+	}
+	token := k.Token
+	if token == "" {
+		token = u.Username
+	}
+	return &Identity{Method: "apikey", Subject: u.Username, Token: token, Tenant: u.Tenant, Roles: u.Roles}, nil
+}
+
+// rolesClaim 读取登录 token 中的 roles 声明
+func rolesClaim(claims *jwtauth.Claims) []string {
+	list, _ := claims.Raw["roles"].([]interface{})
+	var roles []string
+	for _, r := range list {
+		if s, ok := r.(string); ok {
+			roles = append(roles, s)
+		}
+	}
+	return roles
+}
+
+// LoginResult 登录成功后返回的 token
+type LoginResult struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Username  string    `json:"username"`
+}
+
+// issueLoginToken 签发登录 token，session 非空时作为 sid，即连接时关联的会话 token
+func issueLoginToken(u users.User, session string) (*LoginResult, error) {
+	expires := time.Now().Add(hubConfig.Users.LoginTokenTTL.D())
+	claims := map[string]interface{}{
+		"sub": u.Username,
+		"iat": time.Now().Unix(),
+		"exp": expires.Unix(),
+	}
+	if session != "" {
+		claims["sid"] = session
+	}
+	if u.Tenant != "" {
+		claims["tenant"] = u.Tenant
+	}
+	if len(u.Roles) > 0 {
+		claims["roles"] = u.Roles
+	}
+	token, err := jwtauth.Sign(hubConfig.JWT, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: token, ExpiresAt: expires, Username: u.Username}, nil
+}
+
+// LoginRequest 用户名密码登录，Session 为要连接的会话 token，可以为空
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Session  string `json:"session,omitempty"`
+}
+
+// LoginHandler POST /auth/login，本地用户或 LDAP 域账号
+func LoginHandler(c echo.Context) error {
+	if (hubUsers == nil && hubLDAP == nil) || hubConfig.JWT.HMACSecret == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "login is not enabled"})
+	}
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	u, method, err := passwordLogin(req.Username, req.Password)
+	if errors.Is(err, users.ErrInvalidLogin) || errors.Is(err, users.ErrDisabled) {
+		hubMetrics.Inc("hub_logins_total", "method", method, "result", "denied")
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		log.Println("Login error:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	result, err := issueLoginToken(u, req.Session)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	hubMetrics.Inc("hub_logins_total", "method", method, "result", "ok")
+	return c.JSON(http.StatusOK, result)
+}
+
+// -----------------------
+// 管理接口
+// -----------------------
+
+// userView 管理接口中展示的用户，不包含密码哈希和 TOTP 密钥
+type userView struct {
+	users.User
+	PasswordHash string `json:"passwordHash,omitempty"`
+	TOTPSecret   string `json:"totpSecret,omitempty"`
+	HasPassword  bool   `json:"hasPassword"`
+	HasTOTP      bool   `json:"hasTOTP"`
+}
+
+func viewUser(u users.User) userView {
+	return userView{User: u, HasPassword: u.PasswordHash != "", HasTOTP: u.TOTPSecret != ""}
+}
+
+// UserRequest 创建或修改用户，修改时为空的 Password 表示不修改密码
+type UserRequest struct {
+	Username    string   `json:"username"`
+	Password    string   `json:"password,omitempty"`
+	OIDCSubject string   `json:"oidcSubject,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Tenant      string   `json:"tenant,omitempty"`
+	Disabled    bool     `json:"disabled,omitempty"`
+}
+
+func usersError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, users.ErrNotFound), errors.Is(err, users.ErrKeyNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, users.ErrExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	log.Println("User store error:", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// usersEnabled 未启用本地用户时管理接口返回 404
+func usersEnabled(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if hubUsers == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "users are not enabled"})
+		}
+		return next(c)
+	}
+}
+
+func ListUsersHandler(c echo.Context) error {
+	list, err := hubUsers.ListUsers()
+	if err != nil {
+		return usersError(c, err)
+	}
+	views := make([]userView, 0, len(list))
+	for _, u := range list {
+		views = append(views, viewUser(u))
+	}
+	return c.JSON(http.StatusOK, views)
+}
+
+func GetUserHandler(c echo.Context) error {
+	u, err := hubUsers.GetUser(c.Param("name"))
+	if err != nil {
+		return usersError(c, err)
+	}
+	return c.JSON(http.StatusOK, viewUser(u))
+}
+
+func CreateUserHandler(c echo.Context) error {
+	var req UserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Username == "" || strings.HasPrefix(req.Username, users.KeyPrefix) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid username"})
+	}
+	u := users.User{
+		Username:    req.Username,
+		OIDCSubject: req.OIDCSubject,
+		Roles:       req.Roles,
+		Tenant:      req.Tenant,
+		Disabled:    req.Disabled,
+		CreatedAt:   time.Now(),
+	}
+	if req.Password != "" {
+		hash, err := users.HashPassword(req.Password)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		u.PasswordHash = hash
+	}
+	if err := hubUsers.CreateUser(u); err != nil {
+		return usersError(c, err)
+	}
+	return c.JSON(http.StatusCreated, viewUser(u))
+}
+
+func UpdateUserHandler(c echo.Context) error {
+	var req UserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	u, err := hubUsers.GetUser(c.Param("name"))
+	if err != nil {
+		return usersError(c, err)
+	}
+	u.OIDCSubject = req.OIDCSubject
+	u.Roles = req.Roles
+	u.Tenant = req.Tenant
+	u.Disabled = req.Disabled
+	if req.Password != "" {
+		hash, err := users.HashPassword(req.Password)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		u.PasswordHash = hash
+	}
+	if err := hubUsers.UpdateUser(u); err != nil {
+		return usersError(c, err)
+	}
+	return c.JSON(http.StatusOK, viewUser(u))
+}
+
+func DeleteUserHandler(c echo.Context) error {
+	if err := hubUsers.DeleteUser(c.Param("name")); err != nil {
+		return usersError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// APIKeyRequest TTL 为空表示不过期，Token 为空时连接使用用户名作为会话 token
+type APIKeyRequest struct {
+	Name  string   `json:"name,omitempty"`
+	Token string   `json:"token,omitempty"`
+	TTL   Duration `json:"ttl,omitempty"`
+}
+
+func ListAPIKeysHandler(c echo.Context) error {
+	if _, err := hubUsers.GetUser(c.Param("name")); err != nil {
+		return usersError(c, err)
+	}
+	keys, err := hubUsers.ListKeys(c.Param("name"))
+	if err != nil {
+		return usersError(c, err)
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKeyHandler 签发 API key，明文只在本次响应中返回
+func CreateAPIKeyHandler(c echo.Context) error {
+	var req APIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	plain, key, err := users.NewKey(c.Param("name"), req.Name, req.Token, req.TTL.D())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if err := hubUsers.CreateKey(key); err != nil {
+		return usersError(c, err)
+	}
+	log.Printf("API key %s issued for user %s", key.ID, key.Username)
+	return c.JSON(http.StatusCreated, map[string]interface{}{"key": plain, "apiKey": key})
+}
+
+func DeleteAPIKeyHandler(c echo.Context) error {
+	if err := hubUsers.DeleteKey(c.Param("name"), c.Param("id")); err != nil {
+		return usersError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
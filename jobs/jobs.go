@@ -0,0 +1,537 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------
+// 持久化任务队列：耗时的文件操作（分片合并、清单生成、复制、解压）作为任务排队执行，
+// HTTP 接口提交后立即返回任务 ID。每个任务保存为 Dir 下的一个 JSON 文件，状态变化时原子替换；
+// 重启后上次未完成的任务重新排队。失败的任务按 RetryDelaySeconds 递增等待后自动重试，
+// 超过 MaxAttempts 后标记为失败，可以通过 Retry 重新执行
+// -----------------------
+
+// Config Dir 为空表示不启用
+type Config struct {
+	Dir               string `json:"dir"`
+	Workers           int    `json:"workers"`
+	MaxAttempts       int    `json:"maxAttempts"`       // 包括第一次执行
+	RetryDelaySeconds int    `json:"retryDelaySeconds"` // 第 n 次重试前等待 n 倍
+	RetainSeconds     int    `json:"retainSeconds"`     // 结束的任务保留多久，0 表示一直保留
+}
+
+// Enabled 是否配置了任务目录
+func (cfg Config) Enabled() bool {
+	return cfg.Dir != ""
+}
+
+// State 任务状态
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+)
+
+// Finished 是否为结束状态
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCanceled
+}
+
+// Progress 任务进度，Total 为 0 表示总量未知
+type Progress struct {
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+// Job 任务，Owner 为发起任务的会话 token，进度通过该会话通知前端
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Owner     string          `json:"owner,omitempty"`
+	Key       string          `json:"key,omitempty"`      // 去重键，见 SubmitKeyed
+	Watchers  []string        `json:"watchers,omitempty"` // 合并到该任务的其它提交者，与 Owner 一样接收进度
+	Params    json.RawMessage `json:"params"`
+	State     State           `json:"state"`
+	Attempts  int             `json:"attempts"`
+	Progress  Progress        `json:"progress"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	RunAt     time.Time       `json:"runAt"` // 排队的任务最早在该时间执行
+}
+
+// Func 执行一种任务，params 为提交时的参数，report 报告进度；返回值序列化后作为任务结果。
+// ctx 在任务被取消或队列关闭时取消
+type Func func(ctx context.Context, params json.RawMessage, report func(Progress)) (interface{}, error)
+
+var (
+	ErrNotFound    = errors.New("job not found")
+	ErrUnknownKind = errors.New("unknown job kind")
+	ErrState       = errors.New("job is not in a state that allows this operation")
+)
+
+// permanentError 不重试的错误
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent 包装参数错误等重试也不会成功的错误，任务直接标记为失败
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// progressInterval 进度的保存和通知间隔，状态变化不受限制
+const progressInterval = time.Second
+
+// Queue 并发安全，Register 应在 Start 前调用
+type Queue struct {
+	cfg   Config
+	funcs map[string]Func
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	running  map[string]context.CancelFunc
+	canceled map[string]bool // 用户取消的运行中任务
+
+	// OnUpdate 任务状态或进度变化时调用，在执行任务的 goroutine 中同步执行
+	OnUpdate func(Job)
+
+	wake chan struct{}
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// New 加载 Dir 下保存的任务，上次运行中的任务重新排队
+func New(cfg Config) (*Queue, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	q := &Queue{
+		cfg:      cfg,
+		funcs:    make(map[string]Func),
+		jobs:     make(map[string]*Job),
+		running:  make(map[string]context.CancelFunc),
+		canceled: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		stop:     stop,
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cfg.Dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || job.ID == "" {
+			log.Printf("Skip broken job file %s: %v", entry.Name(), err)
+			continue
+		}
+		if job.State == StateRunning {
+			job.State = StateQueued
+			job.Progress = Progress{}
+			if err := q.save(&job); err != nil {
+				return nil, err
+			}
+		}
+		q.jobs[job.ID] = &job
+	}
+	return q, nil
+}
+
+// Register 注册一种任务的执行函数
+func (q *Queue) Register(kind string, fn Func) {
+	q.funcs[kind] = fn
+}
+
+// Kinds 已注册的任务类型
+func (q *Queue) Kinds() []string {
+	kinds := make([]string, 0, len(q.funcs))
+	for k := range q.funcs {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Start 启动执行任务的 goroutine
+func (q *Queue) Start() {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	if q.cfg.RetainSeconds > 0 {
+		q.wg.Add(1)
+		go q.pruneLoop()
+	}
+}
+
+// Close 停止执行，运行中的任务被中断，下次启动时重新排队
+func (q *Queue) Close() {
+	q.stop()
+	q.wg.Wait()
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.cfg.Dir, id+".json")
+}
+
+// save 写临时文件后重命名，避免崩溃时留下不完整的文件
+func (q *Queue) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := q.path(job.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(job.ID))
+}
+
+// update 在持有锁时修改任务并保存，返回修改后的副本
+func (q *Queue) update(job *Job, fn func(*Job)) Job {
+	fn(job)
+	job.UpdatedAt = time.Now()
+	if err := q.save(job); err != nil {
+		log.Printf("Save job %s error: %v", job.ID, err)
+	}
+	return *job
+}
+
+func (q *Queue) notify(job Job) {
+	if q.OnUpdate != nil {
+		q.OnUpdate(job)
+	}
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Submit 提交任务，params 序列化为 JSON 保存
+func (q *Queue) Submit(kind, owner string, params interface{}) (Job, error) {
+	job, _, err := q.SubmitKeyed(kind, "", owner, params)
+	return job, err
+}
+
+// SubmitKeyed 同一 kind 下已有 key 相同、尚未结束的任务时不新建任务，把 owner 加入该任务的 Watchers
+// 并返回该任务，attached 为 true；key 为空时与 Submit 相同
+func (q *Queue) SubmitKeyed(kind, key, owner string, params interface{}) (job Job, attached bool, err error) {
+	if _, ok := q.funcs[kind]; !ok {
+		return Job{}, false, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, false, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Job{}, false, err
+	}
+	q.mu.Lock()
+	if existing := q.findKeyed(kind, key); existing != nil {
+		snapshot := *existing
+		if owner != "" && owner != existing.Owner && !slices.Contains(existing.Watchers, owner) {
+			snapshot = q.update(existing, func(j *Job) { j.Watchers = append(j.Watchers, owner) })
+		}
+		q.mu.Unlock()
+		return snapshot, true, nil
+	}
+	now := time.Now()
+	created := &Job{ID: id, Kind: kind, Owner: owner, Key: key, Params: data, State: StateQueued, CreatedAt: now, UpdatedAt: now, RunAt: now}
+	if err := q.save(created); err != nil {
+		q.mu.Unlock()
+		return Job{}, false, err
+	}
+	q.jobs[id] = created
+	snapshot := *created
+	q.mu.Unlock()
+	q.notify(snapshot)
+	q.signal()
+	return snapshot, false, nil
+}
+
+// findKeyed 在 q.mu 锁内调用
+func (q *Queue) findKeyed(kind, key string) *Job {
+	if key == "" {
+		return nil
+	}
+	for _, job := range q.jobs {
+		if job.Kind == kind && job.Key == key && !job.State.Finished() {
+			return job
+		}
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Get 返回任务的副本
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List 按提交时间倒序返回任务，state 为空时返回全部
+func (q *Queue) List(state State) []Job {
+	q.mu.Lock()
+	list := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		if state == "" || job.State == state {
+			list = append(list, *job)
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Cancel 取消排队或运行中的任务；运行中的任务在执行函数返回后变为 canceled
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	job, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return Job{}, ErrNotFound
+	}
+	switch job.State {
+	case StateQueued:
+		snapshot := q.update(job, func(j *Job) { j.State = StateCanceled })
+		q.mu.Unlock()
+		q.notify(snapshot)
+		return snapshot, nil
+	case StateRunning:
+		q.canceled[id] = true
+		q.running[id]()
+		snapshot := *job
+		q.mu.Unlock()
+		return snapshot, nil
+	}
+	q.mu.Unlock()
+	return Job{}, ErrState
+}
+
+// Retry 重新执行失败或已取消的任务，重试次数从头计算
+func (q *Queue) Retry(id string) (Job, error) {
+	q.mu.Lock()
+	job, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return Job{}, ErrNotFound
+	}
+	if job.State != StateFailed && job.State != StateCanceled {
+		q.mu.Unlock()
+		return Job{}, ErrState
+	}
+	snapshot := q.update(job, func(j *Job) {
+		j.State = StateQueued
+		j.Attempts = 0
+		j.Error = ""
+		j.Progress = Progress{}
+		j.RunAt = time.Now()
+	})
+	q.mu.Unlock()
+	q.notify(snapshot)
+	q.signal()
+	return snapshot, nil
+}
+
+// next 取出最早提交的可执行任务并标记为运行中
+func (q *Queue) next() (*Job, context.Context, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var found *Job
+	now := time.Now()
+	for _, job := range q.jobs {
+		if job.State != StateQueued || job.RunAt.After(now) {
+			continue
+		}
+		if found == nil || job.CreatedAt.Before(found.CreatedAt) {
+			found = job
+		}
+	}
+	if found == nil {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	q.running[found.ID] = cancel
+	q.update(found, func(j *Job) {
+		j.State = StateRunning
+		j.Attempts++
+		j.Error = ""
+	})
+	return found, ctx, true
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if q.ctx.Err() != nil {
+			// 被中断的任务已重新排队，不能再取出执行
+			return
+		}
+		if job, ctx, ok := q.next(); ok {
+			q.run(ctx, job)
+			// 可能还有其它排队的任务
+			q.signal()
+			continue
+		}
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// run 执行任务并根据结果更新状态
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.mu.Lock()
+	params, kind := job.Params, job.Kind
+	snapshot := *job
+	q.mu.Unlock()
+	q.notify(snapshot)
+
+	var lastReport time.Time
+	report := func(p Progress) {
+		q.mu.Lock()
+		job.Progress = p
+		if time.Since(lastReport) < progressInterval && (p.Total == 0 || p.Done < p.Total) {
+			q.mu.Unlock()
+			return
+		}
+		lastReport = time.Now()
+		snapshot := q.update(job, func(*Job) {})
+		q.mu.Unlock()
+		q.notify(snapshot)
+	}
+	result, err := q.call(ctx, q.funcs[kind], params, report)
+
+	q.mu.Lock()
+	q.running[job.ID]()
+	delete(q.running, job.ID)
+	userCanceled := q.canceled[job.ID]
+	delete(q.canceled, job.ID)
+	snapshot = q.update(job, func(j *Job) {
+		var perm permanentError
+		switch {
+		case userCanceled:
+			j.State = StateCanceled
+		case err == nil:
+			j.State = StateSucceeded
+			j.Result, err = json.Marshal(result)
+			if err != nil {
+				j.State, j.Error = StateFailed, err.Error()
+			}
+		case q.ctx.Err() != nil:
+			// 队列关闭导致的中断不计入重试次数
+			j.State = StateQueued
+			j.Attempts--
+		case errors.As(err, &perm) || j.Attempts >= q.cfg.MaxAttempts:
+			j.State, j.Error = StateFailed, err.Error()
+		default:
+			j.State, j.Error = StateQueued, err.Error()
+			j.RunAt = time.Now().Add(time.Duration(q.cfg.RetryDelaySeconds*j.Attempts) * time.Second)
+		}
+	})
+	q.mu.Unlock()
+	if snapshot.Error != "" {
+		log.Printf("Job %s (%s) attempt %d: %s, state %s", snapshot.ID, snapshot.Kind, snapshot.Attempts, snapshot.Error, snapshot.State)
+	}
+	q.notify(snapshot)
+}
+
+// call 执行函数，panic 按错误处理
+func (q *Queue) call(ctx context.Context, fn Func, params json.RawMessage, report func(Progress)) (result interface{}, err error) {
+	if fn == nil {
+		return nil, Permanent(ErrUnknownKind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, params, report)
+}
+
+func (q *Queue) pruneLoop() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		q.prune()
+	}
+}
+
+// prune 删除结束超过 RetainSeconds 的任务
+func (q *Queue) prune() {
+	cutoff := time.Now().Add(-time.Duration(q.cfg.RetainSeconds) * time.Second)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.State.Finished() && job.UpdatedAt.Before(cutoff) {
+			if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Remove job %s error: %v", id, err)
+				continue
+			}
+			delete(q.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// -----------------------
+// JWT 校验：支持 HS256/384/512 和 RS256/384/512，检查签名、exp/nbf、aud、iss；Sign 以 HS256 签发 hub 自己的登录 token。
+// 中继和终端接口共用 TokenValidator，Sec-WebSocket-Protocol 中携带的 token 即为 JWT
+// -----------------------
+
+var (
+	ErrMalformed = errors.New("malformed jwt")
+	ErrSignature = errors.New("invalid jwt signature")
+	ErrExpired   = errors.New("jwt expired")
+	ErrNotYet    = errors.New("jwt not valid yet")
+	ErrAudience  = errors.New("jwt audience mismatch")
+	ErrIssuer    = errors.New("jwt issuer mismatch")
+)
+
+// Claims 校验通过后的声明，Session 取自自定义声明 sid，用于关联会话；Raw 为全部声明
+type Claims struct {
+	Subject   string
+	Session   string
+	Tenant    string
+	Issuer    string
+	Audience  []string
+	ExpiresAt time.Time
+	Raw       map[string]interface{}
+}
+
+// TokenValidator 校验 token 并返回其中的声明
+type TokenValidator interface {
+	Validate(token string) (*Claims, error)
+}
+
+// Config HMACSecret 和 RSAPublicKeyFile 至少配置一个，都为空时不启用 JWT 校验
+type Config struct {
+	HMACSecret       string `json:"hmacSecret,omitempty"`
+	RSAPublicKeyFile string `json:"rsaPublicKeyFile,omitempty"` // PEM 格式的公钥或证书
+	Audience         string `json:"audience,omitempty"`         // 非空时 aud 必须包含该值
+	Issuer           string `json:"issuer,omitempty"`           // 非空时 iss 必须相等
+	RequireExp       bool   `json:"requireExp,omitempty"`       // 为 true 时拒绝没有 exp 的 token
+	LeewaySeconds    int    `json:"leewaySeconds,omitempty"`    // 校验 exp/nbf 时允许的时钟偏差
+}
+
+// Enabled 是否配置了校验密钥
+func (c Config) Enabled() bool {
+	return c.HMACSecret != "" || c.RSAPublicKeyFile != ""
+}
+
+// Validator 按 Config 校验 JWT
+type Validator struct {
+	cfg    Config
+	hmac   []byte
+	rsaKey *rsa.PublicKey
+	now    func() time.Time
+}
+
+// New 根据配置创建 Validator，读取并解析 RSA 公钥
+func New(cfg Config) (*Validator, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("jwt: no hmac secret or rsa public key configured")
+	}
+	v := &Validator{cfg: cfg, now: time.Now}
+	if cfg.HMACSecret != "" {
+		v.hmac = []byte(cfg.HMACSecret)
+	}
+	if cfg.RSAPublicKeyFile != "" {
+		key, err := loadRSAPublicKey(cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKey = key
+	}
+	return v, nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: no PEM block in %s", path)
+	}
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt: %s is not an RSA public key", path)
+	}
+	return key, nil
+}
+
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// Validate 校验签名和声明，算法由 token 头部的 alg 决定，但必须与已配置的密钥类型匹配
+func (v *Validator) Validate(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.verify(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+	claims := &Claims{Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Session, _ = raw["sid"].(string)
+	claims.Tenant, _ = raw["tenant"].(string)
+	claims.Issuer, _ = raw["iss"].(string)
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				claims.Audience = append(claims.Audience, s)
+			}
+		}
+	}
+
+	now := v.now()
+	leeway := time.Duration(v.cfg.LeewaySeconds) * time.Second
+	if exp, ok := numericDate(raw["exp"]); ok {
+		claims.ExpiresAt = exp
+		if now.After(exp.Add(leeway)) {
+			return nil, ErrExpired
+		}
+	} else if v.cfg.RequireExp {
+		return nil, ErrExpired
+	}
+	if nbf, ok := numericDate(raw["nbf"]); ok && now.Add(leeway).Before(nbf) {
+		return nil, ErrNotYet
+	}
+	if v.cfg.Audience != "" && !contains(claims.Audience, v.cfg.Audience) {
+		return nil, ErrAudience
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return nil, ErrIssuer
+	}
+	return claims, nil
+}
+
+func (v *Validator) verify(alg, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("%w: unsupported alg %q", ErrSignature, alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("%w: unsupported alg %q", ErrSignature, alg)
+	}
+	switch alg[:2] {
+	case "HS":
+		if v.hmac == nil {
+			return fmt.Errorf("%w: no hmac secret for %s", ErrSignature, alg)
+		}
+		mac := hmac.New(hash.New, v.hmac)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+		return nil
+	case "RS":
+		if v.rsaKey == nil {
+			return fmt.Errorf("%w: no rsa key for %s", ErrSignature, alg)
+		}
+		h := hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(v.rsaKey, hash, h.Sum(nil), sig); err != nil {
+			return ErrSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported alg %q", ErrSignature, alg)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// numericDate 解析 JSON 中以秒为单位的时间戳
+func numericDate(v interface{}) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign 用 HMACSecret 以 HS256 签发 token，claims 中的 iss、aud 为空时取 Config 中的值；
+// hub 自己签发的登录 token 由同一个 Validator 校验
+func Sign(cfg Config, claims map[string]interface{}) (string, error) {
+	if cfg.HMACSecret == "" {
+		return "", errors.New("jwt: signing requires hmac secret")
+	}
+	if _, ok := claims["iss"]; !ok && cfg.Issuer != "" {
+		claims["iss"] = cfg.Issuer
+	}
+	if _, ok := claims["aud"]; !ok && cfg.Audience != "" {
+		claims["aud"] = cfg.Audience
+	}
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(crypto.SHA256.New, []byte(cfg.HMACSecret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
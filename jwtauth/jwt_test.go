@@ -0,0 +1,219 @@
+package jwtauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+var testNow = time.Unix(1700000000, 0)
+
+// signToken 按 alg 签发测试 token，HS 系列用 testSecret，RS 系列用 key
+func signToken(t *testing.T, alg string, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := hashes[alg[2:]]
+	var sig []byte
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(hash.New, []byte(testSecret))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS":
+		h := hash.New()
+		h.Write([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writePublicKey(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestValidator(t *testing.T, cfg Config) *Validator {
+	t.Helper()
+	v, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacOnly := Config{HMACSecret: testSecret}
+	both := Config{HMACSecret: testSecret, RSAPublicKeyFile: writePublicKey(t, key)}
+	exp := testNow.Add(time.Minute).Unix()
+	// 换掉载荷而保留原签名
+	alice := strings.Split(signToken(t, "HS256", map[string]interface{}{"sub": "alice"}, nil), ".")
+	tampered := alice[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + alice[2]
+
+	tests := []struct {
+		name   string
+		cfg    Config
+		token  string
+		want   error
+		claims *Claims
+	}{
+		{
+			name:   "HS256",
+			cfg:    hmacOnly,
+			token:  signToken(t, "HS256", map[string]interface{}{"sub": "alice", "sid": "s1", "tenant": "acme", "exp": exp}, nil),
+			claims: &Claims{Subject: "alice", Session: "s1", Tenant: "acme"},
+		},
+		{
+			name:   "HS512",
+			cfg:    hmacOnly,
+			token:  signToken(t, "HS512", map[string]interface{}{"sub": "alice"}, nil),
+			claims: &Claims{Subject: "alice"},
+		},
+		{
+			name:   "RS256",
+			cfg:    both,
+			token:  signToken(t, "RS256", map[string]interface{}{"sub": "bob"}, key),
+			claims: &Claims{Subject: "bob"},
+		},
+		{
+			name:  "RS256 signed by another key",
+			cfg:   both,
+			token: signToken(t, "RS256", map[string]interface{}{"sub": "bob"}, other),
+			want:  ErrSignature,
+		},
+		{
+			name:  "RS256 without rsa key",
+			cfg:   hmacOnly,
+			token: signToken(t, "RS256", map[string]interface{}{"sub": "bob"}, key),
+			want:  ErrSignature,
+		},
+		{
+			name:  "alg none",
+			cfg:   hmacOnly,
+			token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + ".",
+			want:  ErrSignature,
+		},
+		{
+			name:  "tampered payload",
+			cfg:   hmacOnly,
+			token: tampered,
+			want:  ErrSignature,
+		},
+		{
+			name:  "two segments",
+			cfg:   hmacOnly,
+			token: "a.b",
+			want:  ErrMalformed,
+		},
+		{
+			name:  "expired",
+			cfg:   hmacOnly,
+			token: signToken(t, "HS256", map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()}, nil),
+			want:  ErrExpired,
+		},
+		{
+			name:   "expired within leeway",
+			cfg:    Config{HMACSecret: testSecret, LeewaySeconds: 120},
+			token:  signToken(t, "HS256", map[string]interface{}{"sub": "alice", "exp": testNow.Add(-time.Minute).Unix()}, nil),
+			claims: &Claims{Subject: "alice"},
+		},
+		{
+			name:  "missing exp when required",
+			cfg:   Config{HMACSecret: testSecret, RequireExp: true},
+			token: signToken(t, "HS256", map[string]interface{}{"sub": "alice"}, nil),
+			want:  ErrExpired,
+		},
+		{
+			name:  "not yet valid",
+			cfg:   hmacOnly,
+			token: signToken(t, "HS256", map[string]interface{}{"nbf": testNow.Add(time.Minute).Unix()}, nil),
+			want:  ErrNotYet,
+		},
+		{
+			name:   "audience list",
+			cfg:    Config{HMACSecret: testSecret, Audience: "hub"},
+			token:  signToken(t, "HS256", map[string]interface{}{"sub": "alice", "aud": []string{"web", "hub"}}, nil),
+			claims: &Claims{Subject: "alice"},
+		},
+		{
+			name:  "audience mismatch",
+			cfg:   Config{HMACSecret: testSecret, Audience: "hub"},
+			token: signToken(t, "HS256", map[string]interface{}{"aud": "web"}, nil),
+			want:  ErrAudience,
+		},
+		{
+			name:  "issuer mismatch",
+			cfg:   Config{HMACSecret: testSecret, Issuer: "https://idp"},
+			token: signToken(t, "HS256", map[string]interface{}{"iss": "https://evil"}, nil),
+			want:  ErrIssuer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := newTestValidator(t, tt.cfg).Validate(tt.token)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("Validate error = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate error = %v", err)
+			}
+			if claims.Subject != tt.claims.Subject || claims.Session != tt.claims.Session || claims.Tenant != tt.claims.Tenant {
+				t.Fatalf("claims = %+v, want %+v", claims, tt.claims)
+			}
+		})
+	}
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	cfg := Config{HMACSecret: testSecret, Issuer: "hub", Audience: "hub"}
+	token, err := Sign(cfg, map[string]interface{}{"sub": "alice", "exp": testNow.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := newTestValidator(t, cfg).Validate(token)
+	if err != nil {
+		t.Fatalf("Validate error = %v", err)
+	}
+	if claims.Subject != "alice" || claims.Issuer != "hub" || len(claims.Audience) != 1 || claims.Audience[0] != "hub" {
+		t.Fatalf("claims = %+v", claims)
+	}
+	if _, err := Sign(Config{RSAPublicKeyFile: "pub.pem"}, map[string]interface{}{}); err == nil {
+		t.Fatal("Sign without hmac secret succeeded")
+	}
+}
@@ -0,0 +1,190 @@
+package ldapauth
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// BER 编解码，只实现 LDAP 用到的部分：定长编码、最多 4 字节的长度、单字节标签
+
+const (
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+var errMalformed = errors.New("ldap: malformed ber")
+
+// maxPacket 单条响应的上限，防止异常的长度字段导致大内存分配
+const maxPacket = 16 << 20
+
+func appendLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	var tmp [4]byte
+	i := len(tmp)
+	for n > 0 {
+		i--
+		tmp[i] = byte(n)
+		n >>= 8
+	}
+	b = append(b, 0x80|byte(len(tmp)-i))
+	return append(b, tmp[i:]...)
+}
+
+// tlv 编码一个元素
+func tlv(tag byte, content []byte) []byte {
+	b := make([]byte, 0, len(content)+6)
+	b = append(b, tag)
+	b = appendLength(b, len(content))
+	return append(b, content...)
+}
+
+// seq 编码构造类型，parts 为已编码的子元素
+func seq(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, p := range parts {
+		content = append(content, p...)
+	}
+	return tlv(tag, content)
+}
+
+func berString(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+func berInt(tag byte, n int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(n)}, content...)
+		if (n >= -128 && n < 128) || len(content) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return tlv(tag, content)
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return tlv(tagBoolean, []byte{0xff})
+	}
+	return tlv(tagBoolean, []byte{0})
+}
+
+// element 解码后的元素，构造类型的子元素通过 children 读取
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// parseElement 从 b 中解析一个元素，返回剩余部分
+func parseElement(b []byte) (element, []byte, error) {
+	if len(b) < 2 {
+		return element{}, nil, errMalformed
+	}
+	tag := b[0]
+	n, k, err := parseLength(b[1:])
+	if err != nil {
+		return element{}, nil, err
+	}
+	b = b[1+k:]
+	if n > len(b) {
+		return element{}, nil, errMalformed
+	}
+	return element{tag: tag, content: b[:n]}, b[n:], nil
+}
+
+func parseLength(b []byte) (n, size int, err error) {
+	if len(b) == 0 {
+		return 0, 0, errMalformed
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), 1, nil
+	}
+	count := int(b[0] & 0x7f)
+	if count == 0 || count > 4 || len(b) < 1+count {
+		return 0, 0, errMalformed
+	}
+	for _, c := range b[1 : 1+count] {
+		n = n<<8 | int(c)
+	}
+	if n > maxPacket {
+		return 0, 0, errMalformed
+	}
+	return n, 1 + count, nil
+}
+
+// children 解析构造类型的全部子元素
+func (e element) children() ([]element, error) {
+	var list []element
+	b := e.content
+	for len(b) > 0 {
+		child, rest, err := parseElement(b)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, child)
+		b = rest
+	}
+	return list, nil
+}
+
+func (e element) int() int64 {
+	var n int64
+	for i, c := range e.content {
+		if i == 0 && c&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(c)
+	}
+	return n
+}
+
+func (e element) str() string {
+	return string(e.content)
+}
+
+// readPacket 从连接读取一个完整的顶层元素
+func readPacket(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	n := int(first)
+	if first >= 0x80 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			return element{}, errMalformed
+		}
+		n = 0
+		for i := 0; i < count; i++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return element{}, err
+			}
+			n = n<<8 | int(c)
+		}
+		if n > maxPacket {
+			return element{}, errMalformed
+		}
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, content: content}, nil
+}
@@ -0,0 +1,141 @@
+package ldapauth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestAppendLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x81, 0x80}},
+		{0xff, []byte{0x81, 0xff}},
+		{0x100, []byte{0x82, 0x01, 0x00}},
+		{70000, []byte{0x83, 0x01, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		got := appendLength(nil, tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("appendLength(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+		n, size, err := parseLength(got)
+		if err != nil || n != tt.n || size != len(got) {
+			t.Errorf("parseLength(% x) = %d, %d, %v", got, n, size, err)
+		}
+	}
+}
+
+func TestBerInt(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+	}
+	for _, tt := range tests {
+		got := berInt(tagInteger, tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("berInt(%d) = % x, want % x", tt.n, got, tt.want)
+			continue
+		}
+		e, rest, err := parseElement(got)
+		if err != nil || len(rest) != 0 || e.tag != tagInteger || e.int() != tt.n {
+			t.Errorf("parseElement(% x) = %+v, % x, %v", got, e, rest, err)
+		}
+	}
+}
+
+func TestParseElement(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	tests := []struct {
+		name    string
+		data    []byte
+		content []byte
+		rest    []byte
+		err     bool
+	}{
+		{"short form", []byte{0x04, 0x02, 'h', 'i', 0x01}, []byte("hi"), []byte{0x01}, false},
+		{"long form", append([]byte{0x04, 0x82, 0x01, 0x2c}, long...), long, nil, false},
+		{"empty content", []byte{0x30, 0x00}, []byte{}, nil, false},
+		{"one byte", []byte{0x04}, nil, nil, true},
+		{"content truncated", []byte{0x04, 0x05, 'h', 'i'}, nil, nil, true},
+		{"indefinite length", []byte{0x30, 0x80, 0x00, 0x00}, nil, nil, true},
+		{"length of five bytes", []byte{0x04, 0x85, 0, 0, 0, 0, 1, 'x'}, nil, nil, true},
+		{"length field truncated", []byte{0x04, 0x82, 0x01}, nil, nil, true},
+		{"length over max packet", []byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, rest, err := parseElement(tt.data)
+			if tt.err {
+				if !errors.Is(err, errMalformed) {
+					t.Fatalf("parseElement error = %v, want errMalformed", err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(e.content, tt.content) || !bytes.Equal(rest, tt.rest) {
+				t.Fatalf("parseElement = % x, % x, %v", e.content, rest, err)
+			}
+		})
+	}
+}
+
+func TestChildren(t *testing.T) {
+	msg := seq(tagSequence, berInt(tagInteger, 7), seq(classApplication|constructed|1, berString(tagOctetString, "cn=a"), berBool(true)))
+	e, _, err := parseElement(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kids, err := e.children()
+	if err != nil || len(kids) != 2 || kids[0].int() != 7 {
+		t.Fatalf("children = %+v, %v", kids, err)
+	}
+	inner, err := kids[1].children()
+	if err != nil || len(inner) != 2 || inner[0].str() != "cn=a" || inner[1].content[0] != 0xff {
+		t.Fatalf("inner children = %+v, %v", inner, err)
+	}
+
+	bad := element{tag: tagSequence, content: []byte{0x02, 0x01, 0x07, 0x04, 0x09, 'x'}}
+	if _, err := bad.children(); !errors.Is(err, errMalformed) {
+		t.Fatalf("children of truncated sequence error = %v", err)
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	msg := seq(tagSequence, berInt(tagInteger, 1), berString(tagOctetString, string(bytes.Repeat([]byte{'y'}, 200))))
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"complete", msg, nil},
+		{"truncated content", msg[:len(msg)-1], io.ErrUnexpectedEOF},
+		{"missing length", []byte{0x30}, io.EOF},
+		{"indefinite length", []byte{0x30, 0x80}, errMalformed},
+		{"length over max packet", []byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff}, errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := readPacket(bufio.NewReader(bytes.NewReader(tt.data)))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("readPacket error = %v, want %v", err, tt.want)
+			}
+			if err == nil && (e.tag != tagSequence || !bytes.Equal(tlv(e.tag, e.content), msg)) {
+				t.Fatalf("readPacket = %+v", e)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package ldapauth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP 协议操作，一条连接同一时间只执行一个操作
+
+const (
+	appBindRequest     = classApplication | constructed | 0
+	appBindResponse    = classApplication | constructed | 1
+	appUnbindRequest   = classApplication | 2
+	appSearchRequest   = classApplication | constructed | 3
+	appSearchEntry     = classApplication | constructed | 4
+	appSearchDone      = classApplication | constructed | 5
+	appSearchReference = classApplication | constructed | 19
+	appExtendedRequest = classApplication | constructed | 23
+	appExtendedResp    = classApplication | constructed | 24
+
+	oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+
+	scopeWholeSubtree = 2
+)
+
+// ResultError 服务端返回的非成功结果
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry 搜索结果中的一个条目
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// conn 一条 LDAP 连接
+type conn struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	msgID   int64
+	timeout time.Duration
+	broken  bool // 协议错误或网络错误后不再放回连接池
+}
+
+// dial 按 URL 建立连接，ldaps 直接使用 TLS，ldap 在 startTLS 时升级
+func dial(cfg Config, tlsConfig *tls.Config) (*conn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	dialer := &net.Dialer{Timeout: timeout}
+	host := u.Host
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		nc, err = dialer.Dial("tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{nc: nc, r: bufio.NewReader(nc), timeout: timeout}
+	if u.Scheme == "ldap" && cfg.StartTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// roundTrip 发送一条请求，handle 依次处理同一 messageID 的响应，返回 true 表示操作结束
+func (c *conn) roundTrip(op []byte, handle func(op element) (bool, error)) error {
+	c.msgID++
+	id := c.msgID
+	msg := seq(tagSequence, berInt(tagInteger, id), op)
+	if c.timeout > 0 {
+		_ = c.nc.SetDeadline(time.Now().Add(c.timeout))
+		defer c.nc.SetDeadline(time.Time{})
+	}
+	if _, err := c.nc.Write(msg); err != nil {
+		c.broken = true
+		return err
+	}
+	for {
+		packet, err := readPacket(c.r)
+		if err != nil {
+			c.broken = true
+			return err
+		}
+		parts, err := packet.children()
+		if err != nil || len(parts) < 2 {
+			c.broken = true
+			return errMalformed
+		}
+		if parts[0].int() != id {
+			continue // 例如服务端主动发送的通知
+		}
+		done, err := handle(parts[1])
+		if err != nil {
+			var re *ResultError
+			if !errors.As(err, &re) {
+				c.broken = true
+			}
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// result 解析 LDAPResult 中的结果码和诊断信息
+func result(op element) error {
+	parts, err := op.children()
+	if err != nil || len(parts) < 3 {
+		return errMalformed
+	}
+	if code := parts[0].int(); code != resultSuccess {
+		return &ResultError{Code: code, Message: parts[2].str()}
+	}
+	return nil
+}
+
+func (c *conn) startTLS(tlsConfig *tls.Config) error {
+	op := seq(appExtendedRequest, berString(classContext|0, oidStartTLS))
+	err := c.roundTrip(op, func(op element) (bool, error) {
+		if op.tag != appExtendedResp {
+			return false, errMalformed
+		}
+		return true, result(op)
+	})
+	if err != nil {
+		return fmt.Errorf("ldap: starttls: %w", err)
+	}
+	tc := tls.Client(c.nc, tlsConfig)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.nc = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+// bind 简单绑定，密码为空时服务端会按匿名绑定处理，调用方需要事先拒绝
+func (c *conn) bind(dn, password string) error {
+	op := seq(appBindRequest,
+		berInt(tagInteger, 3),
+		berString(tagOctetString, dn),
+		berString(classContext|0, password),
+	)
+	return c.roundTrip(op, func(op element) (bool, error) {
+		if op.tag != appBindResponse {
+			return false, errMalformed
+		}
+		return true, result(op)
+	})
+}
+
+// search 在 base 下按过滤器搜索整棵子树，sizeLimit 为 0 表示不限制
+func (c *conn) search(base, filter string, attrs []string, sizeLimit int) ([]Entry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrList [][]byte
+	for _, a := range attrs {
+		attrList = append(attrList, berString(tagOctetString, a))
+	}
+	op := seq(appSearchRequest,
+		berString(tagOctetString, base),
+		berInt(tagEnumerated, scopeWholeSubtree),
+		berInt(tagEnumerated, 0), // neverDerefAliases
+		berInt(tagInteger, int64(sizeLimit)),
+		berInt(tagInteger, int64(c.timeout/time.Second)),
+		berBool(false),
+		f,
+		seq(tagSequence, attrList...),
+	)
+	var entries []Entry
+	err = c.roundTrip(op, func(op element) (bool, error) {
+		switch op.tag {
+		case appSearchEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return false, err
+			}
+			entries = append(entries, entry)
+			return false, nil
+		case appSearchReference:
+			return false, nil
+		case appSearchDone:
+			return true, result(op)
+		}
+		return false, errMalformed
+	})
+	return entries, err
+}
+
+// Get 按属性名（不区分大小写）取值
+func (e Entry) Get(attr string) []string {
+	for name, vals := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return vals
+		}
+	}
+	return nil
+}
+
+func parseEntry(op element) (Entry, error) {
+	parts, err := op.children()
+	if err != nil || len(parts) < 2 {
+		return Entry{}, errMalformed
+	}
+	entry := Entry{DN: parts[0].str(), Attributes: make(map[string][]string)}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, a := range attrs {
+		pair, err := a.children()
+		if err != nil || len(pair) < 2 {
+			return Entry{}, errMalformed
+		}
+		vals, err := pair[1].children()
+		if err != nil {
+			return Entry{}, err
+		}
+		for _, v := range vals {
+			entry.Attributes[pair[0].str()] = append(entry.Attributes[pair[0].str()], v.str())
+		}
+	}
+	return entry, nil
+}
+
+// close 发送 unbind 后关闭连接
+func (c *conn) close() {
+	if !c.broken {
+		c.msgID++
+		_ = c.nc.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = c.nc.Write(seq(tagSequence, berInt(tagInteger, c.msgID), tlv(appUnbindRequest, nil)))
+	}
+	c.nc.Close()
+}
+
+// isInvalidCredentials 判断是否为用户名或密码错误
+func isInvalidCredentials(err error) bool {
+	var re *ResultError
+	return errors.As(err, &re) && re.Code == resultInvalidCredentials
+}
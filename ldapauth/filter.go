@@ -0,0 +1,168 @@
+package ldapauth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// 搜索过滤器：按 RFC 4515 的字符串形式解析并编码为 BER，
+// 支持 & | ! 以及 =、>=、<=、~=、存在（=*）和子串匹配，不支持扩展匹配（:=）
+
+// EscapeFilter 转义过滤器中的值，替换用户名等外部输入前必须调用
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter 把过滤器字符串编码为 BER
+func compileFilter(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") {
+		s = "(" + s + ")"
+	}
+	f, rest, err := parseFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return f, nil
+}
+
+// parseFilter 解析一个带括号的过滤器，返回编码和剩余部分
+func parseFilter(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(' near %q", s)
+	}
+	s = s[1:]
+	switch s[0] {
+	case '&', '|':
+		tag := byte(classContext | constructed)
+		if s[0] == '|' {
+			tag |= 1
+		}
+		s = s[1:]
+		var parts [][]byte
+		for len(s) > 0 && s[0] == '(' {
+			f, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, f)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return seq(tag, parts...), s[1:], nil
+	case '!':
+		f, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return seq(classContext|constructed|2, f), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	f, err := parseItem(item)
+	return f, rest, err
+}
+
+// parseItem 解析 attr op value 形式的简单过滤器
+func parseItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(classContext | constructed | 3) // equalityMatch
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = classContext|constructed|5, attr[:len(attr)-1]
+	case '<':
+		tag, attr = classContext|constructed|6, attr[:len(attr)-1]
+	case '~':
+		tag, attr = classContext|constructed|8, attr[:len(attr)-1]
+	case ':':
+		return nil, fmt.Errorf("ldap: extensible match is not supported in %q", item)
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	if tag == classContext|constructed|3 && value == "*" {
+		return berString(classContext|7, attr), nil // present
+	}
+	if tag == classContext|constructed|3 && strings.Contains(value, "*") {
+		return substringFilter(attr, value)
+	}
+	v, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return seq(tag, berString(tagOctetString, attr), berString(tagOctetString, v)), nil
+}
+
+func substringFilter(attr, value string) ([]byte, error) {
+	pieces := strings.Split(value, "*")
+	var subs [][]byte
+	for i, p := range pieces {
+		if p == "" {
+			continue
+		}
+		v, err := unescapeValue(p)
+		if err != nil {
+			return nil, err
+		}
+		tag := byte(classContext | 1) // any
+		switch i {
+		case 0:
+			tag = classContext | 0 // initial
+		case len(pieces) - 1:
+			tag = classContext | 2 // final
+		}
+		subs = append(subs, berString(tag, v))
+	}
+	return seq(classContext|constructed|4, berString(tagOctetString, attr), seq(tagSequence, subs...)), nil
+}
+
+// unescapeValue 还原 \XX 形式的转义
+func unescapeValue(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		raw, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		b.Write(raw)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,98 @@
+package ldapauth
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscapeFilter(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"alice", "alice"},
+		{"a*b", `a\2ab`},
+		{"*)(uid=*", `\2a\29\28uid=\2a`},
+		{`dom\user`, `dom\5cuser`},
+		{"nul\x00", `nul\00`},
+		{"张三", "张三"},
+	}
+	for _, tt := range tests {
+		if got := EscapeFilter(tt.in); got != tt.want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestEscapedInputStaysOneValue 转义后的用户输入只能成为单个相等匹配的值
+func TestEscapedInputStaysOneValue(t *testing.T) {
+	for _, input := range []string{"alice", "*", "*)(uid=*", "a)(|(objectClass=*)", `x\`, "a\x00b"} {
+		got, err := compileFilter("(uid=" + EscapeFilter(input) + ")")
+		if err != nil {
+			t.Errorf("compileFilter(%q): %v", input, err)
+			continue
+		}
+		want := seq(classContext|constructed|3, berString(tagOctetString, "uid"), berString(tagOctetString, input))
+		if !bytes.Equal(got, want) {
+			t.Errorf("input %q compiled to % x, want % x", input, got, want)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	eq := func(attr, value string) []byte {
+		return seq(classContext|constructed|3, berString(tagOctetString, attr), berString(tagOctetString, value))
+	}
+	tests := []struct {
+		name   string
+		filter string
+		want   []byte
+	}{
+		{"equality", "(uid=alice)", []byte{0xa3, 0x0c, 0x04, 0x03, 'u', 'i', 'd', 0x04, 0x05, 'a', 'l', 'i', 'c', 'e'}},
+		{"without parentheses", " uid=alice ", eq("uid", "alice")},
+		{"present", "(uid=*)", []byte{0x87, 0x03, 'u', 'i', 'd'}},
+		{"greater or equal", "(n>=5)", seq(classContext|constructed|5, berString(tagOctetString, "n"), berString(tagOctetString, "5"))},
+		{"less or equal", "(n<=5)", seq(classContext|constructed|6, berString(tagOctetString, "n"), berString(tagOctetString, "5"))},
+		{"approx", "(cn~=bob)", seq(classContext|constructed|8, berString(tagOctetString, "cn"), berString(tagOctetString, "bob"))},
+		{"escaped value", `(cn=a\2ab)`, eq("cn", "a*b")},
+		{"substring", "(cn=a*b*c)", []byte{0xa4, 0x0f, 0x04, 0x02, 'c', 'n', 0x30, 0x09, 0x80, 0x01, 'a', 0x81, 0x01, 'b', 0x82, 0x01, 'c'}},
+		{"substring final only", "(cn=*c)", seq(classContext|constructed|4, berString(tagOctetString, "cn"), seq(tagSequence, berString(classContext|2, "c")))},
+		{"substring escaped piece", `(cn=\28*)`, seq(classContext|constructed|4, berString(tagOctetString, "cn"), seq(tagSequence, berString(classContext|0, "(")))},
+		{"and", "(&(a=1)(b=2))", seq(classContext|constructed, eq("a", "1"), eq("b", "2"))},
+		{"or", "(|(a=1)(b=2))", seq(classContext|constructed|1, eq("a", "1"), eq("b", "2"))},
+		{"not", "(!(a=1))", seq(classContext|constructed|2, eq("a", "1"))},
+		{"nested", "(&(objectClass=person)(|(uid=a)(!(mail=*))))", seq(classContext|constructed,
+			eq("objectClass", "person"),
+			seq(classContext|constructed|1, eq("uid", "a"), seq(classContext|constructed|2, berString(classContext|7, "mail"))))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compileFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("compileFilter(%q): %v", tt.filter, err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("compileFilter(%q) = % x, want % x", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		"(uid=alice",
+		"(&(a=1)(b=2)",
+		"(!(a=1)",
+		"(uid=a)(uid=b)",
+		"(=alice)",
+		"(uid)",
+		"(cn:dn:=x)",
+		"(>=5)",
+		`(uid=a\zz)`,
+		`(uid=a\2)`,
+		"()",
+	} {
+		if got, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) = % x, want error", filter, got)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// -----------------------
+// LDAP / Active Directory 认证：服务账号绑定后按 UserFilter 查找用户 DN，再用用户的 DN 和密码绑定校验，
+// 校验通过后按 GroupFilter 搜索用户所在的组。服务账号的连接放在连接池中复用，用户绑定使用单独的连接。
+// 支持 ldaps:// 和 StartTLS，没有引入外部库，只实现了认证需要的 bind、search 和 StartTLS
+// -----------------------
+
+var (
+	ErrInvalidCredentials = errors.New("ldap: invalid username or password")
+	ErrUserNotFound       = errors.New("ldap: user not found")
+)
+
+// Config URL 为空表示不启用。UserFilter 和 GroupFilter 中的 {username}、{dn} 替换为转义后的值
+type Config struct {
+	URL                string `json:"url"` // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool   `json:"startTLS,omitempty"`
+	CAFile             string `json:"caFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	BindDN             string `json:"bindDN,omitempty"` // 服务账号，为空时匿名搜索
+	BindPassword       string `json:"bindPassword,omitempty"`
+	BaseDN             string `json:"baseDN"`
+	UserFilter         string `json:"userFilter,omitempty"`  // 默认 (sAMAccountName={username})
+	GroupBaseDN        string `json:"groupBaseDN,omitempty"` // 默认与 BaseDN 相同
+	GroupFilter        string `json:"groupFilter,omitempty"` // 默认 (member={dn})
+	GroupAttr          string `json:"groupAttr,omitempty"`   // 组名属性，默认 cn
+	PoolSize           int    `json:"poolSize"`
+	TimeoutSeconds     int    `json:"timeoutSeconds"`
+}
+
+// Enabled 是否配置了服务器地址
+func (cfg Config) Enabled() bool {
+	return cfg.URL != ""
+}
+
+// Result 认证通过的用户，Groups 为组名（GroupAttr 的值）
+type Result struct {
+	Username string
+	DN       string
+	Groups   []string
+}
+
+// Client 并发安全
+type Client struct {
+	cfg       Config
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	idle []*conn // 已用服务账号绑定的空闲连接
+}
+
+// New 检查配置并加载 CA，不会立即连接服务器
+func New(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+	if cfg.BaseDN == "" {
+		return nil, errors.New("ldap: baseDN is required")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(sAMAccountName={username})"
+	}
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	if cfg.GroupFilter == "" {
+		cfg.GroupFilter = "(member={dn})"
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = "cn"
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 4
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 10
+	}
+	// 提前检查过滤器语法，避免到第一次登录时才发现配置错误
+	for _, f := range []string{cfg.UserFilter, cfg.GroupFilter} {
+		if _, err := compileFilter(strings.NewReplacer("{username}", "x", "{dn}", "x").Replace(f)); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldap: no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &Client{cfg: cfg, tlsConfig: tlsConfig}, nil
+}
+
+// get 取出一条服务账号连接，没有空闲连接时新建
+func (cl *Client) get() (*conn, error) {
+	cl.mu.Lock()
+	if n := len(cl.idle); n > 0 {
+		c := cl.idle[n-1]
+		cl.idle = cl.idle[:n-1]
+		cl.mu.Unlock()
+		return c, nil
+	}
+	cl.mu.Unlock()
+	c, err := dial(cl.cfg, cl.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cl.cfg.BindDN != "" {
+		if err := c.bind(cl.cfg.BindDN, cl.cfg.BindPassword); err != nil {
+			c.close()
+			return nil, fmt.Errorf("ldap: service bind: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// put 放回连接池，出错的连接或池已满时关闭
+func (cl *Client) put(c *conn) {
+	cl.mu.Lock()
+	if !c.broken && len(cl.idle) < cl.cfg.PoolSize {
+		cl.idle = append(cl.idle, c)
+		c = nil
+	}
+	cl.mu.Unlock()
+	if c != nil {
+		c.close()
+	}
+}
+
+// withConn 使用服务账号连接执行 fn；空闲连接可能已被服务端关闭，网络错误时换新连接重试一次
+func (cl *Client) withConn(fn func(c *conn) error) error {
+	for attempt := 0; ; attempt++ {
+		c, err := cl.get()
+		if err != nil {
+			return err
+		}
+		err = fn(c)
+		broken := c.broken
+		cl.put(c)
+		if err == nil || !broken || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// Authenticate 校验用户名和密码并查询所在的组
+func (cl *Client) Authenticate(username, password string) (*Result, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	var user Entry
+	err := cl.withConn(func(c *conn) error {
+		filter := strings.ReplaceAll(cl.cfg.UserFilter, "{username}", EscapeFilter(username))
+		entries, err := c.search(cl.cfg.BaseDN, filter, []string{"1.1"}, 2) // 1.1 表示不返回属性
+		if err != nil {
+			return err
+		}
+		if len(entries) != 1 {
+			return ErrUserNotFound
+		}
+		user = entries[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uc, err := dial(cl.cfg, cl.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = uc.bind(user.DN, password)
+	uc.close()
+	if isInvalidCredentials(err) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Username: username, DN: user.DN}
+	err = cl.withConn(func(c *conn) error {
+		filter := strings.ReplaceAll(cl.cfg.GroupFilter, "{dn}", EscapeFilter(user.DN))
+		filter = strings.ReplaceAll(filter, "{username}", EscapeFilter(username))
+		groups, err := c.search(cl.cfg.GroupBaseDN, filter, []string{cl.cfg.GroupAttr}, 0)
+		if err != nil {
+			return err
+		}
+		result.Groups = result.Groups[:0]
+		for _, g := range groups {
+			result.Groups = append(result.Groups, g.Get(cl.cfg.GroupAttr)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ldap: group search: %w", err)
+	}
+	return result, nil
+}
+
+// Close 关闭连接池中的连接
+func (cl *Client) Close() {
+	cl.mu.Lock()
+	idle := cl.idle
+	cl.idle = nil
+	cl.mu.Unlock()
+	for _, c := range idle {
+		c.close()
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+// 中继压测：起一个回显请求的模拟 agent 和 N 个模拟前端，前端持续发送请求并等待 agent 的响应，
+// 统计中继吞吐、往返延迟分位数和内存。默认在进程内嵌入 hub（见 hub.NewHub），-hub 指定时压测外部的 hub，
+// 此时需要把外部 hub 的 agentResolver 指向打印出的模拟 agent 地址。
+//
+//	go run ./loadtest -clients 200 -duration 30s -size 1024
+//	go run ./loadtest -clients 50 -rate 100 -hub ws://127.0.0.1:8080/ws -agent-addr 0.0.0.0:9000
+
+import (
+	"echo_demo/hub"
+	"echo_demo/mockagent"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+type options struct {
+	clients   int
+	sessions  int
+	duration  time.Duration
+	rate      float64
+	inflight  int
+	size      int
+	hubURL    string
+	agentAddr string
+	verbose   bool
+}
+
+// message 只解析路由需要的字段，d 原样回显
+type message struct {
+	Type      string          `json:"t"`
+	RequestID string          `json:"r,omitempty"`
+	Action    string          `json:"a"`
+	Data      json.RawMessage `json:"d,omitempty"`
+}
+
+func main() {
+	var o options
+	flag.IntVar(&o.clients, "clients", 100, "number of simulated clients")
+	flag.IntVar(&o.sessions, "sessions", 0, "number of sessions (tokens) shared by the clients, 0 means one per client")
+	flag.DurationVar(&o.duration, "duration", 10*time.Second, "how long to send requests")
+	flag.Float64Var(&o.rate, "rate", 0, "requests per second per client, 0 sends as fast as responses arrive")
+	flag.IntVar(&o.inflight, "inflight", 1, "max outstanding requests per client when -rate is 0")
+	flag.IntVar(&o.size, "size", 256, "payload size in bytes")
+	flag.StringVar(&o.hubURL, "hub", "", "client WebSocket URL of an external hub, empty runs the hub in process")
+	flag.StringVar(&o.agentAddr, "agent-addr", "127.0.0.1:0", "listen address of the mock agent")
+	flag.BoolVar(&o.verbose, "v", false, "keep hub logs")
+	flag.Parse()
+	if o.sessions <= 0 || o.sessions > o.clients {
+		o.sessions = o.clients
+	}
+	if !o.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	agent := mockagent.New()
+	agentURL, err := agent.Listen(o.agentAddr)
+	if err != nil {
+		fatal("mock agent: %v", err)
+	}
+	defer agent.Close()
+	fmt.Printf("mock agent listening on %s\n", agentURL)
+
+	target := o.hubURL
+	if target == "" {
+		url, stopHub, err := serveHub(agentURL)
+		if err != nil {
+			fatal("hub: %v", err)
+		}
+		defer stopHub()
+		target = url
+	}
+
+	res, err := run(o, target)
+	if err != nil {
+		fatal("%v", err)
+	}
+	res.report(o)
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// serveHub 在进程内启动 hub，全部 token 都解析到模拟 agent
+func serveHub(agentURL string) (string, func(), error) {
+	cfg := hub.DefaultConfig()
+	cfg.AgentResolver = hub.AgentResolverConfig{Type: "static", Default: &hub.AgentEndpoint{URL: agentURL}}
+	cfg.Cleanup.Mode = hub.CleanupOff
+	h, err := hub.NewHub(hub.WithConfig(cfg), hub.WithoutBackground())
+	if err != nil {
+		return "", nil, err
+	}
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	e.GET("/ws", h.HandleClient)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.Close()
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: e}
+	go srv.Serve(ln)
+	return "ws://" + ln.Addr().String() + "/ws", func() {
+		srv.Close()
+		h.Close()
+	}, nil
+}
+
+type result struct {
+	sent, received, errors atomic.Int64
+	bytes                  atomic.Int64
+	elapsed                time.Duration
+	heapPeak, sysPeak      uint64
+	goroutinePeak          int
+
+	mu  sync.Mutex
+	lat []time.Duration
+}
+
+func run(o options, target string) (*result, error) {
+	res := &result{}
+	payload, _ := json.Marshal(strings.Repeat("x", o.size))
+	conns := make([]*websocket.Conn, 0, o.clients)
+	defer func() {
+		for _, ws := range conns {
+			ws.Close()
+		}
+	}()
+	for i := 0; i < o.clients; i++ {
+		header := http.Header{"Authorization": {"Bearer loadtest-" + strconv.Itoa(i%o.sessions)}}
+		ws, _, err := websocket.DefaultDialer.Dial(target, header)
+		if err != nil {
+			return nil, fmt.Errorf("client %d: %w", i, err)
+		}
+		conns = append(conns, ws)
+	}
+
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go res.sample(stop, sampled)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(o.duration)
+	for i, ws := range conns {
+		wg.Add(1)
+		go func(i int, ws *websocket.Conn) {
+			defer wg.Done()
+			if err := runClient(o, res, i, ws, payload, deadline); err != nil {
+				res.errors.Add(1)
+			}
+		}(i, ws)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	close(stop)
+	<-sampled
+	return res, nil
+}
+
+// runClient 发送请求直到 deadline，再等待已发出的请求返回（最多 5 秒）
+func runClient(o options, res *result, id int, ws *websocket.Conn, payload json.RawMessage, deadline time.Time) error {
+	var mu sync.Mutex
+	pending := make(map[string]time.Time)
+	slots := make(chan struct{}, max(o.inflight, 1))
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			var msg message
+			if json.Unmarshal(data, &msg) != nil || msg.Type != hub.MessageTypeResponse {
+				continue
+			}
+			mu.Lock()
+			sentAt, ok := pending[msg.RequestID]
+			delete(pending, msg.RequestID)
+			left := len(pending)
+			mu.Unlock()
+			if !ok {
+				continue
+			}
+			res.received.Add(1)
+			res.bytes.Add(int64(len(data)))
+			res.mu.Lock()
+			res.lat = append(res.lat, time.Since(sentAt))
+			res.mu.Unlock()
+			if o.rate <= 0 {
+				<-slots
+			}
+			if left == 0 && time.Now().After(deadline) {
+				readErr <- nil
+				return
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if o.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / o.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for seq := 0; time.Now().Before(deadline); seq++ {
+		if tick != nil {
+			<-tick
+		} else {
+			select {
+			case slots <- struct{}{}:
+			case err := <-readErr:
+				return err
+			}
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		msg := message{
+			Type:      hub.MessageTypeRequest,
+			RequestID: fmt.Sprintf("%d-%d", id, seq),
+			Action:    "loadtest",
+			Data:      payload,
+		}
+		data, _ := json.Marshal(msg)
+		mu.Lock()
+		pending[msg.RequestID] = time.Now()
+		mu.Unlock()
+		if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+		res.sent.Add(1)
+	}
+	mu.Lock()
+	left := len(pending)
+	mu.Unlock()
+	if left == 0 {
+		return nil
+	}
+	select {
+	case err := <-readErr:
+		return err
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+// sample 每 100ms 记录一次堆、向系统申请的内存和 goroutine 数的峰值；进程内模式包含 hub 本身
+func (r *result) sample(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		r.heapPeak = max(r.heapPeak, mem.HeapAlloc)
+		r.sysPeak = max(r.sysPeak, mem.Sys)
+		r.goroutinePeak = max(r.goroutinePeak, runtime.NumGoroutine())
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *result) report(o options) {
+	fmt.Printf("clients=%d sessions=%d size=%dB duration=%v\n", o.clients, o.sessions, o.size, r.elapsed.Round(time.Millisecond))
+	secs := r.elapsed.Seconds()
+	fmt.Printf("requests sent=%d received=%d lost=%d client errors=%d\n",
+		r.sent.Load(), r.received.Load(), r.sent.Load()-r.received.Load(), r.errors.Load())
+	fmt.Printf("throughput %.0f msg/s %.2f MB/s\n", float64(r.received.Load())/secs, float64(r.bytes.Load())/secs/(1<<20))
+	if len(r.lat) > 0 {
+		lat := r.lat
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		pct := func(p float64) time.Duration { return lat[int(float64(len(lat)-1)*p)] }
+		var sum time.Duration
+		for _, d := range lat {
+			sum += d
+		}
+		fmt.Printf("latency avg=%v p50=%v p90=%v p99=%v max=%v\n",
+			sum/time.Duration(len(lat)), pct(0.5), pct(0.9), pct(0.99), lat[len(lat)-1])
+	}
+	fmt.Printf("memory heap peak=%.1fMB sys peak=%.1fMB goroutines peak=%d\n",
+		float64(r.heapPeak)/(1<<20), float64(r.sysPeak)/(1<<20), r.goroutinePeak)
+}
@@ -0,0 +1,241 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// -----------------------
+// SMTP 邮件通知：按事件类型选择模板渲染主题和正文，告警、上传完成等子系统共用。
+// 节流分两层：同一事件同一 key 在 ThrottleSeconds 内只发送一次，全部邮件每小时不超过 MaxPerHour 封
+// -----------------------
+
+var (
+	ErrDisabled    = errors.New("smtp is not configured")
+	ErrThrottled   = errors.New("mail throttled")
+	ErrNoRecipient = errors.New("no mail recipient")
+)
+
+// TLS 模式
+const (
+	TLSStartTLS = "starttls" // 服务端支持时升级为 TLS（默认）
+	TLSImplicit = "tls"      // 直接建立 TLS 连接，常见于 465 端口
+	TLSNone     = "none"
+)
+
+// Template 主题和正文均为 text/template
+type Template struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Config Addr 为空表示不发送邮件；To 为默认收件人，调用方未指定收件人时使用
+type Config struct {
+	Addr               string              `json:"addr"` // host:port
+	Username           string              `json:"username,omitempty"`
+	Password           string              `json:"password,omitempty"`
+	From               string              `json:"from"`
+	To                 []string            `json:"to,omitempty"`
+	TLS                string              `json:"tls,omitempty"`
+	InsecureSkipVerify bool                `json:"insecureSkipVerify,omitempty"`
+	Templates          map[string]Template `json:"templates,omitempty"` // 事件类型 -> 模板，覆盖内置模板
+	ThrottleSeconds    int                 `json:"throttleSeconds"`
+	MaxPerHour         int                 `json:"maxPerHour"` // 0 表示不限制
+}
+
+// Enabled 是否配置了 SMTP 服务器
+func (cfg Config) Enabled() bool {
+	return cfg.Addr != ""
+}
+
+type parsedTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// Mailer 并发安全
+type Mailer struct {
+	cfg       Config
+	templates map[string]parsedTemplate
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time // 事件/key -> 最后发送时间
+	hourly   []time.Time          // 最近一小时内的发送时间
+}
+
+// New 解析模板，defaults 为调用方提供的内置模板，Config.Templates 中同名的模板优先
+func New(cfg Config, defaults map[string]Template) (*Mailer, error) {
+	if cfg.Enabled() && cfg.From == "" {
+		return nil, errors.New("smtp from is required")
+	}
+	switch cfg.TLS {
+	case "", TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q", cfg.TLS)
+	}
+	m := &Mailer{cfg: cfg, templates: make(map[string]parsedTemplate), lastSent: make(map[string]time.Time)}
+	all := make(map[string]Template)
+	for event, t := range defaults {
+		all[event] = t
+	}
+	for event, t := range cfg.Templates {
+		all[event] = t
+	}
+	for event, t := range all {
+		subject, err := template.New(event + ".subject").Parse(t.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("template %s subject: %w", event, err)
+		}
+		body, err := template.New(event + ".body").Parse(t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("template %s body: %w", event, err)
+		}
+		m.templates[event] = parsedTemplate{subject: subject, body: body}
+	}
+	return m, nil
+}
+
+// Enabled 为 nil 或未配置服务器时返回 false
+func (m *Mailer) Enabled() bool {
+	return m != nil && m.cfg.Enabled()
+}
+
+// Send 用 event 对应的模板渲染 data 后发送；key 用于节流（例如告警规则和目标），to 为空时发送给默认收件人
+func (m *Mailer) Send(event, key string, to []string, data interface{}) error {
+	if !m.Enabled() {
+		return ErrDisabled
+	}
+	if len(to) == 0 {
+		to = m.cfg.To
+	}
+	if len(to) == 0 {
+		return ErrNoRecipient
+	}
+	t, ok := m.templates[event]
+	if !ok {
+		return fmt.Errorf("no mail template for event %q", event)
+	}
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return err
+	}
+	if !m.allow(event+"/"+key, time.Now()) {
+		return ErrThrottled
+	}
+	return m.deliver(to, buildMessage(m.cfg.From, to, strings.TrimSpace(subject.String()), body.String()))
+}
+
+// allow 检查节流，通过时记录本次发送
+func (m *Mailer) allow(key string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if throttle := time.Duration(m.cfg.ThrottleSeconds) * time.Second; throttle > 0 {
+		if last, ok := m.lastSent[key]; ok && now.Sub(last) < throttle {
+			return false
+		}
+		// 顺带清理已过节流期的记录
+		for k, last := range m.lastSent {
+			if now.Sub(last) >= throttle {
+				delete(m.lastSent, k)
+			}
+		}
+	}
+	if m.cfg.MaxPerHour > 0 {
+		kept := m.hourly[:0]
+		for _, at := range m.hourly {
+			if now.Sub(at) < time.Hour {
+				kept = append(kept, at)
+			}
+		}
+		m.hourly = kept
+		if len(m.hourly) >= m.cfg.MaxPerHour {
+			return false
+		}
+		m.hourly = append(m.hourly, now)
+	}
+	m.lastSent[key] = now
+	return true
+}
+
+func buildMessage(from string, to []string, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func (m *Mailer) deliver(to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(m.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: m.cfg.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var conn net.Conn
+	if m.cfg.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.cfg.Addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", m.cfg.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.cfg.TLS == "" || m.cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.cfg.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
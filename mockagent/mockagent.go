@@ -0,0 +1,437 @@
+package mockagent
+
+// -----------------------
+// 模拟 agent：实现 hub 与 agent 之间的消息协议，不依赖真实主机即可联调 hub 和前端。
+// 默认把每个请求以相同的 r、a、d 作为响应发回（回显），按 action 匹配的规则可以改为固定响应、错误、
+// 延迟响应、先发 notify、不响应或断开连接。两种连接方式：
+//
+//	a := mockagent.New()
+//	url, _ := a.Listen("127.0.0.1:0")               // hub 拨号连接 agent，agentResolver 指向 url
+//	go a.Dial(ctx, "ws://hub/agent/ws", "agent-key-1", "dev-1") // agent 以清单中的密钥主动连入 hub 的 /agent/ws
+//
+// 规则按加入顺序匹配，第一个生效的规则决定如何处理；没有规则生效时回显
+// -----------------------
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 消息类型，与 hub 的 WebSocketMessage 一致
+const (
+	TypeRequest  = "request"
+	TypeResponse = "response"
+	TypeNotify   = "notify"
+)
+
+// Message hub 与 agent 之间的一条消息，只包含 agent 关心的字段
+type Message struct {
+	Type      string          `json:"t"`
+	RequestID string          `json:"r,omitempty"`
+	Action    string          `json:"a"`
+	Data      json.RawMessage `json:"d,omitempty"`
+	Error     *Error          `json:"e,omitempty"`
+	Channel   string          `json:"ch,omitempty"`
+	Priority  string          `json:"p,omitempty"`
+}
+
+// Error 响应中的错误码和原因
+type Error struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Duration 在脚本文件中写作 "200ms" 这样的字符串，也接受纳秒数
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return errors.New("invalid duration")
+	}
+	return nil
+}
+
+// Rule 对匹配的请求如何处理。Action 为空匹配全部；After 为跳过的匹配次数，Times 为生效次数（0 不限），
+// 比如 After 为 3、Times 为 1、Disconnect 为 true 表示第 4 个请求到达时断开连接
+type Rule struct {
+	Action string   `json:"action,omitempty"`
+	After  int      `json:"after,omitempty"`
+	Times  int      `json:"times,omitempty"`
+	Delay  Duration `json:"delay,omitempty"`  // 响应前等待
+	Jitter Duration `json:"jitter,omitempty"` // 在 Delay 上随机增加 [0, Jitter)
+
+	Data   json.RawMessage `json:"data,omitempty"`   // 响应的 d，为空时回显请求的 d
+	Error  *Error          `json:"error,omitempty"`  // 以错误响应
+	Notify []Message       `json:"notify,omitempty"` // 响应前发送的 notify，t 自动设为 notify
+
+	NoReply    bool `json:"noReply,omitempty"`    // 不响应，用于验证超时
+	Disconnect bool `json:"disconnect,omitempty"` // 断开连接（在 Delay 之后、不响应），用于验证重连
+
+	// Handler 不为空时代替上面的字段生成响应，返回 nil 表示不响应；只能在代码中设置
+	Handler func(req Message) *Message `json:"-"`
+}
+
+type rule struct {
+	Rule
+	matched int
+}
+
+// active 记录一次匹配并返回本次是否生效
+func (r *rule) active(action string) bool {
+	if r.Action != "" && r.Action != action {
+		return false
+	}
+	r.matched++
+	if r.matched <= r.After {
+		return false
+	}
+	return r.Times == 0 || r.matched-r.After <= r.Times
+}
+
+// Agent 可以同时接受多个 hub 连接，规则和统计在连接间共享
+type Agent struct {
+	// Logf 不为空时记录收到的请求和连接变化
+	Logf func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	rules    []*rule
+	conns    map[*conn]struct{}
+	received []Message
+	accepted int
+	refusing bool
+	server   *http.Server
+	closed   bool
+}
+
+// New 创建一个回显所有请求的模拟 agent，rules 依次加入
+func New(rules ...Rule) *Agent {
+	a := &Agent{conns: make(map[*conn]struct{})}
+	a.Script(rules...)
+	return a
+}
+
+// Script 追加规则，可以在运行中调用
+func (a *Agent) Script(rules ...Rule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range rules {
+		a.rules = append(a.rules, &rule{Rule: r})
+	}
+}
+
+// Reset 清空规则和收到的请求，恢复回显
+func (a *Agent) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules, a.received = nil, nil
+}
+
+// Refuse 为 true 时拒绝新的连接（hub 拨号时返回 503），已有连接不受影响
+func (a *Agent) Refuse(refuse bool) {
+	a.mu.Lock()
+	a.refusing = refuse
+	a.mu.Unlock()
+}
+
+// maxReceived Received 保留的请求数，长时间压测时不无限增长
+const maxReceived = 1000
+
+// Received 返回最近收到的请求，最多 maxReceived 个
+func (a *Agent) Received() []Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Message(nil), a.received...)
+}
+
+// Connections 返回累计建立的连接数和当前连接数
+func (a *Agent) Connections() (total, open int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.accepted, len(a.conns)
+}
+
+// Notify 向当前所有连接发送 notify
+func (a *Agent) Notify(action string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	for _, c := range a.current() {
+		c.write(Message{Type: TypeNotify, Action: action, Data: raw})
+	}
+	return nil
+}
+
+// DisconnectAll 断开当前所有连接，模拟 agent 掉线
+func (a *Agent) DisconnectAll() {
+	for _, c := range a.current() {
+		c.ws.Close()
+	}
+}
+
+func (a *Agent) current() []*conn {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]*conn, 0, len(a.conns))
+	for c := range a.conns {
+		out = append(out, c)
+	}
+	return out
+}
+
+func (a *Agent) logf(format string, args ...interface{}) {
+	if a.Logf != nil {
+		a.Logf(format, args...)
+	}
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// ServeHTTP 接受 hub 的拨号，可以挂到任意 HTTP 服务上
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	refusing := a.refusing
+	a.mu.Unlock()
+	if refusing {
+		http.Error(w, "agent unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	a.serve(ws, r.RemoteAddr)
+}
+
+// Listen 在 addr 上启动 HTTP 服务并返回 hub 应该拨号的地址，Close 时停止
+func (a *Agent) Listen(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{Handler: a}
+	a.mu.Lock()
+	a.server = srv
+	a.mu.Unlock()
+	go srv.Serve(ln)
+	return "ws://" + ln.Addr().String() + "/", nil
+}
+
+// Dial 以 agent 密钥（hub 清单中 agent 的 key）连接 hub 的 /agent/ws 进行反向注册，连接断开后返回；
+// 需要持续在线时由调用方循环重连
+func (a *Agent) Dial(ctx context.Context, hubURL, key, agentID string) error {
+	u, err := url.Parse(hubURL)
+	if err != nil {
+		return err
+	}
+	if agentID != "" {
+		q := u.Query()
+		q.Set("agent_id", agentID)
+		u.RawQuery = q.Encode()
+	}
+	header := http.Header{"Authorization": {"Bearer " + key}}
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("dial %s: %w (%s)", hubURL, err, resp.Status)
+		}
+		return fmt.Errorf("dial %s: %w", hubURL, err)
+	}
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+	a.serve(ws, hubURL)
+	return ctx.Err()
+}
+
+// Close 停止 Listen 的服务并断开所有连接
+func (a *Agent) Close() {
+	a.mu.Lock()
+	srv := a.server
+	a.closed = true
+	a.mu.Unlock()
+	if srv != nil {
+		srv.Close()
+	}
+	a.DisconnectAll()
+}
+
+// conn 一个 hub 连接，写入需要加锁，延迟的响应在单独的 goroutine 中发送
+type conn struct {
+	ws  *websocket.Conn
+	wmu sync.Mutex
+}
+
+func (c *conn) write(msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+func (a *Agent) serve(ws *websocket.Conn, peer string) {
+	c := &conn{ws: ws}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		ws.Close()
+		return
+	}
+	a.conns[c] = struct{}{}
+	a.accepted++
+	a.mu.Unlock()
+	a.logf("connected: %s", peer)
+	defer func() {
+		a.mu.Lock()
+		delete(a.conns, c)
+		a.mu.Unlock()
+		ws.Close()
+		a.logf("disconnected: %s", peer)
+	}()
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		// 文本心跳
+		if strings.TrimSpace(string(data)) == "ping" {
+			c.wmu.Lock()
+			_ = ws.WriteMessage(websocket.TextMessage, []byte("pong"))
+			c.wmu.Unlock()
+			continue
+		}
+		var req Message
+		if json.Unmarshal(data, &req) != nil || req.Type == TypeResponse || req.Type == TypeNotify {
+			continue
+		}
+		a.mu.Lock()
+		a.received = append(a.received, req)
+		if len(a.received) > maxReceived {
+			a.received = append(a.received[:0], a.received[len(a.received)-maxReceived:]...)
+		}
+		var matched *Rule
+		for _, r := range a.rules {
+			if r.active(req.Action) {
+				copied := r.Rule
+				matched = &copied
+				break
+			}
+		}
+		a.mu.Unlock()
+		a.logf("request %s %s %s", req.RequestID, req.Action, req.Data)
+
+		if matched == nil {
+			c.write(reply(req, nil))
+			continue
+		}
+		if delay := matched.delay(); delay > 0 {
+			// 延迟的请求不阻塞后续请求，模拟处理时间不同的并发请求
+			go func() {
+				time.Sleep(delay)
+				c.handle(req, matched)
+			}()
+			continue
+		}
+		c.handle(req, matched)
+	}
+}
+
+func (r *Rule) delay() time.Duration {
+	d := time.Duration(r.Delay)
+	if r.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(r.Jitter)))
+	}
+	return d
+}
+
+func (c *conn) handle(req Message, r *Rule) {
+	if r.Disconnect {
+		c.ws.Close()
+		return
+	}
+	for _, n := range r.Notify {
+		n.Type = TypeNotify
+		c.write(n)
+	}
+	if r.Handler != nil {
+		if resp := r.Handler(req); resp != nil {
+			if resp.Type == "" {
+				resp.Type = TypeResponse
+			}
+			if resp.RequestID == "" {
+				resp.RequestID = req.RequestID
+			}
+			c.write(*resp)
+		}
+		return
+	}
+	if r.NoReply {
+		return
+	}
+	c.write(reply(req, r))
+}
+
+// reply 以相同的 r、a、p 响应，r 为 nil 时回显
+func reply(req Message, r *Rule) Message {
+	resp := Message{Type: TypeResponse, RequestID: req.RequestID, Action: req.Action, Data: req.Data, Channel: req.Channel, Priority: req.Priority}
+	if r != nil {
+		if r.Data != nil {
+			resp.Data = r.Data
+		}
+		if r.Error != nil {
+			resp.Data, resp.Error = nil, r.Error
+		}
+	}
+	return resp
+}
+
+// LoadScript 读取 JSON 数组格式的规则文件
+func LoadScript(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// StdLogf 使用标准库 log 的 Logf
+func StdLogf(format string, args ...interface{}) {
+	log.Printf("mockagent: "+format, args...)
+}
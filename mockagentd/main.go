@@ -0,0 +1,79 @@
+package main
+
+// 本地联调用的模拟 agent（见 mockagent 包）：默认监听 -addr 等待 hub 拨号，hub 的 agentResolver 指向打印出的地址；
+// 指定 -hub 时改为以 -key（hub 清单中 agent 的密钥）主动连入 hub 的 /agent/ws，断开后按 -retry 间隔重连。
+// -script 为 JSON 数组格式的规则文件，比如让 ls 延迟 2 秒、第 5 个请求到达时断开：
+//
+//	[{"action": "ls", "delay": "2s"}, {"after": 4, "times": 1, "disconnect": true}]
+//
+//	go run ./mockagentd -addr 127.0.0.1:9000 -script rules.json
+//	go run ./mockagentd -hub ws://127.0.0.1:8080/agent/ws -key agent-key-1
+
+import (
+	"context"
+	"echo_demo/mockagent"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9000", "listen address when the hub dials the agent")
+	hubURL := flag.String("hub", "", "agent WebSocket URL of the hub, e.g. ws://127.0.0.1:8080/agent/ws; empty listens on -addr")
+	key := flag.String("key", "", "agent key registered in the hub inventory, used with -hub")
+	agentID := flag.String("agent-id", "mockagent", "agent_id reported with -hub")
+	script := flag.String("script", "", "JSON file with response rules, empty echoes every request")
+	delay := flag.Duration("delay", 0, "delay before every reply that no rule matches")
+	retry := flag.Duration("retry", 2*time.Second, "reconnect interval with -hub")
+	quiet := flag.Bool("q", false, "do not log requests")
+	flag.Parse()
+
+	var rules []mockagent.Rule
+	if *script != "" {
+		var err error
+		if rules, err = mockagent.LoadScript(*script); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *delay > 0 {
+		// 放在最后，只作用于没有被脚本规则处理的请求
+		rules = append(rules, mockagent.Rule{Delay: mockagent.Duration(*delay)})
+	}
+	agent := mockagent.New(rules...)
+	if !*quiet {
+		agent.Logf = mockagent.StdLogf
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *hubURL == "" {
+		url, err := agent.Listen(*addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		log.Printf("mock agent listening on %s", url)
+		<-ctx.Done()
+		agent.Close()
+		return
+	}
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "-key is required with -hub")
+		os.Exit(2)
+	}
+	for ctx.Err() == nil {
+		if err := agent.Dial(ctx, *hubURL, *key, *agentID); err != nil && ctx.Err() == nil {
+			log.Printf("mock agent: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(*retry):
+		}
+	}
+}
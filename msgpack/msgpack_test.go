@@ -0,0 +1,187 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string // hex
+	}{
+		{"nil", nil, "c0"},
+		{"false", false, "c2"},
+		{"true", true, "c3"},
+		{"positive fixint", 127, "7f"},
+		{"uint8", 128, "cc80"},
+		{"uint16", 256, "cd0100"},
+		{"uint32", 1 << 16, "ce00010000"},
+		{"uint64", uint64(1) << 32, "cf0000000100000000"},
+		{"max uint64", uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{"negative fixint", -32, "e0"},
+		{"int8", -33, "d0df"},
+		{"int16", -129, "d1ff7f"},
+		{"int32", -32769, "d2ffff7fff"},
+		{"int64", int64(math.MinInt64), "d38000000000000000"},
+		{"integral float64", 3.0, "03"},
+		{"float64", 1.5, "cb3ff8000000000000"},
+		{"float32", float32(1.5), "ca3fc00000"},
+		{"json integer", json.Number("-1"), "ff"},
+		{"json big unsigned", json.Number("18446744073709551615"), "cfffffffffffffffff"},
+		{"json float", json.Number("0.5"), "cb3fe0000000000000"},
+		{"fixstr", "hi", "a26869"},
+		{"str8", strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{"str16", strings.Repeat("a", 256), "da0100" + strings.Repeat("61", 256)},
+		{"bin8", []byte{1, 2}, "c4020102"},
+		{"fixarray", []interface{}{1, "a"}, "9201a161"},
+		{"array16", make([]interface{}, 16), "dc0010" + strings.Repeat("c0", 16)},
+		{"map sorted by key", map[string]interface{}{"b": 2, "a": 1}, "82a16101a16202"},
+		{"nested", map[string]interface{}{"a": []interface{}{map[string]interface{}{}}}, "81a1619180"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(got) != tt.want {
+				t.Fatalf("Marshal(%v) = %x, want %s", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	deep := interface{}("x")
+	for i := 0; i <= maxDepth+1; i++ {
+		deep = []interface{}{deep}
+	}
+	tests := []struct {
+		name string
+		v    interface{}
+		want error
+	}{
+		{"struct", struct{}{}, ErrUnsupported},
+		{"typed slice", []string{"a"}, ErrUnsupported},
+		{"unsupported nested value", map[string]interface{}{"a": []int{1}}, ErrUnsupported},
+		{"invalid json number", json.Number("abc"), nil},
+		{"too deep", deep, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Marshal(tt.v)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Marshal error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		data string // hex
+		want interface{}
+	}{
+		{"nil", "c0", nil},
+		{"true", "c3", true},
+		{"positive fixint", "05", int64(5)},
+		{"negative fixint", "ff", int64(-1)},
+		{"uint8", "cc80", int64(128)},
+		{"uint64 in int64 range", "cf7fffffffffffffff", int64(math.MaxInt64)},
+		{"uint64 above int64 range", "cfffffffffffffffff", uint64(math.MaxUint64)},
+		{"int8", "d0df", int64(-33)},
+		{"int16", "d1ff7f", int64(-129)},
+		{"int32", "d2ffff7fff", int64(-32769)},
+		{"int64", "d38000000000000000", int64(math.MinInt64)},
+		{"float32", "ca3fc00000", 1.5},
+		{"float64", "cb3ff8000000000000", 1.5},
+		{"fixstr", "a26869", "hi"},
+		{"str8", "d9026869", "hi"},
+		{"str16", "da00026869", "hi"},
+		{"str32", "db000000026869", "hi"},
+		{"bin16", "c500020102", []byte{1, 2}},
+		{"array32", "dd000000020102", []interface{}{int64(1), int64(2)}},
+		{"map16", "de0001a161c0", map[string]interface{}{"a": nil}},
+		{"map32", "df00000001a161c3", map[string]interface{}{"a": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			got, err := Unmarshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Unmarshal(%s) = %#v, want %#v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	deep := strings.Repeat("91", maxDepth+2) + "c0"
+	tests := []struct {
+		name string
+		data string // hex
+		want error
+	}{
+		{"empty", "", ErrTruncated},
+		{"truncated uint16", "cd01", ErrTruncated},
+		{"truncated string", "a36869", ErrTruncated},
+		{"truncated str8 length", "d9", ErrTruncated},
+		{"truncated binary", "c40501", ErrTruncated},
+		{"array longer than data", "dcffff01", ErrTruncated},
+		{"map longer than data", "dfffffffff", ErrTruncated},
+		{"truncated array element", "9201", ErrTruncated},
+		{"trailing data", "c0c0", ErrTrailing},
+		{"never used byte", "c1", ErrUnsupported},
+		{"extension type", "d40100", ErrUnsupported},
+		{"non-string map key", "810102", ErrUnsupported},
+		{"too deep", deep, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			_, err := Unmarshal(data)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Unmarshal(%s) error = %v, want %v", tt.data, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestJSONRoundTrip 前端消息经 JSON 解码后编码为 msgpack，再解码回来应与原 JSON 等价
+func TestJSONRoundTrip(t *testing.T) {
+	for _, doc := range []string{
+		`{"t":"request","r":"1","a":"ls","d":{"path":"/tmp","depth":2,"all":true,"size":1.25,"tags":["x",null]}}`,
+		`[-1,0,4294967296,-4294967296,"` + strings.Repeat("长", 100) + `"]`,
+		`{}`,
+	} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatal(err)
+		}
+		packed, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := Unmarshal(packed)
+		if err != nil {
+			t.Fatalf("Unmarshal(%x): %v", packed, err)
+		}
+		want, _ := json.Marshal(v)
+		got, _ := json.Marshal(back)
+		if !bytes.Equal(got, want) {
+			t.Errorf("round trip of %s = %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -----------------------
+// S3 兼容对象存储（AWS S3、MinIO）的最小客户端：PutObject、GetObject、DeleteObject、ListObjectsV2
+// 和 PutBucketLifecycleConfiguration，请求用 SigV4 签名。没有引入 AWS SDK，不支持分片上传，
+// 单个对象受 S3 的 5GB 单次上传上限限制。MinIO 通常需要 PathStyle
+// -----------------------
+
+// Config Endpoint 为 https://s3.amazonaws.com 或 MinIO 的地址，包含协议
+type Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"` // 默认 us-east-1
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	PathStyle bool   `json:"pathStyle"` // {endpoint}/{bucket}/{key}，否则为 {bucket}.{host}/{key}
+
+	TimeoutSeconds int `json:"timeoutSeconds"` // 单个请求的超时，默认 300
+}
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("object not found")
+
+// Error S3 返回的错误
+type Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Object 列表中的一个对象
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	ETag         string    `json:"etag"`
+}
+
+// LifecycleRule Prefix 下的对象在 ExpireDays 天后删除；TransitionDays 大于 0 时先转为 StorageClass
+type LifecycleRule struct {
+	ID             string `json:"id"`
+	Prefix         string `json:"prefix"`
+	ExpireDays     int    `json:"expireDays,omitempty"`
+	TransitionDays int    `json:"transitionDays,omitempty"`
+	StorageClass   string `json:"storageClass,omitempty"` // 比如 STANDARD_IA、GLACIER
+}
+
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	http     *http.Client
+}
+
+// New 校验配置，不会访问存储
+func New(cfg Config) (*Client, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("objstore: endpoint and bucket are required")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("objstore: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 300
+	}
+	return &Client{cfg: cfg, endpoint: u, http: &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}}, nil
+}
+
+// Bucket 返回桶名
+func (c *Client) Bucket() string {
+	return c.cfg.Bucket
+}
+
+// Put 上传对象，body 需要先完整读取一遍计算 SHA256，然后从头发送
+func (c *Client) Put(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, nil, header, io.NopCloser(body), size, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PutBytes 上传内存中的数据
+func (c *Client) PutBytes(ctx context.Context, key string, data []byte, contentType string) error {
+	return c.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// Get 返回对象内容，调用方负责关闭；不存在时返回 ErrNotFound
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil, 0, emptySHA256)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Delete 删除对象，对象不存在不算错误
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil, 0, emptySHA256)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List 列出 prefix 下的全部对象，自动翻页
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil, 0, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+				ETag         string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("objstore: list: %w", err)
+		}
+		for _, o := range page.Contents {
+			out = append(out, Object{Key: o.Key, Size: o.Size, LastModified: o.LastModified, ETag: strings.Trim(o.ETag, `"`)})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return out, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// SetLifecycle 替换桶的生命周期规则，rules 为空时不做任何操作
+func (c *Client) SetLifecycle(ctx context.Context, rules []LifecycleRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	type transition struct {
+		Days         int    `xml:"Days"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	type expiration struct {
+		Days int `xml:"Days"`
+	}
+	type rule struct {
+		ID         string      `xml:"ID"`
+		Prefix     string      `xml:"Filter>Prefix"`
+		Status     string      `xml:"Status"`
+		Transition *transition `xml:"Transition,omitempty"`
+		Expiration *expiration `xml:"Expiration,omitempty"`
+	}
+	doc := struct {
+		XMLName xml.Name `xml:"LifecycleConfiguration"`
+		Rules   []rule   `xml:"Rule"`
+	}{}
+	for _, r := range rules {
+		x := rule{ID: r.ID, Prefix: r.Prefix, Status: "Enabled"}
+		if r.TransitionDays > 0 {
+			x.Transition = &transition{Days: r.TransitionDays, StorageClass: r.StorageClass}
+		}
+		if r.ExpireDays > 0 {
+			x.Expiration = &expiration{Days: r.ExpireDays}
+		}
+		doc.Rules = append(doc.Rules, x)
+	}
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(body)
+	payload := sha256.Sum256(body)
+	header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}, "Content-Type": {"application/xml"}}
+	resp, err := c.do(ctx, http.MethodPut, "", url.Values{"lifecycle": {""}}, header,
+		io.NopCloser(bytes.NewReader(body)), int64(len(body)), hex.EncodeToString(payload[:]))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// do 签名并发送请求，非 2xx 时解析错误并关闭响应
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.ReadCloser, size int64, payloadHash string) (*http.Response, error) {
+	u := *c.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if c.cfg.PathStyle {
+		path += "/" + c.cfg.Bucket
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+	}
+	path += "/" + key
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	c.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, ErrNotFound
+	}
+	e := &Error{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, e) != nil || e.Code == "" {
+		e.Code, e.Message = resp.Status, strings.TrimSpace(string(data))
+	}
+	return nil, e
+}
+
+// sign 添加 SigV4 的 Authorization 头，签名 host、Content-MD5、Content-Type 和全部 x-amz- 头
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-md5" || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + c.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), day)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery 按 SigV4 的要求编码并按键排序，同时作为实际发送的查询串
+func canonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 只保留 A-Z a-z 0-9 - . _ ~，encodeSlash 为 false 时保留路径中的 /
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' ||
+			ch == '-' || ch == '.' || ch == '_' || ch == '~' || ch == '/' && !encodeSlash {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package main
+
+// 缓冲区池基准：对比 gorilla/websocket 的 ReadMessage 与 bufpool.ReadMessage 读消息、
+// json.Marshal 与 bufpool.EncodeJSON 编码后立即写出的每次耗时、分配次数和字节数，以及每万次操作的 GC 次数。
+//
+//	go run ./poolbench -sizes 256,4096,65536
+
+import (
+	"echo_demo/bufpool"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// message 与 hub.WebSocketMessage 的常用字段相同
+type message struct {
+	Type      string `json:"t"`
+	RequestID string `json:"r,omitempty"`
+	Action    string `json:"a"`
+	Data      any    `json:"d,omitempty"`
+}
+
+func main() {
+	sizes := flag.String("sizes", "256,4096,65536", "comma separated payload sizes in bytes")
+	flag.Parse()
+
+	for _, s := range strings.Split(*sizes, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || size <= 0 {
+			log.Fatalf("bad size %q", s)
+		}
+		benchRead(size)
+		benchMarshal(size)
+	}
+}
+
+func benchRead(size int) {
+	conn, stop, err := pipe(size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+	run(fmt.Sprintf("read %dB", size), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := bufpool.ReadMessage(conn, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func benchMarshal(size int) {
+	msg := message{Type: "response", RequestID: "1-42", Action: "exec", Data: strings.Repeat("x", size)}
+	// 模拟写出，只读取数据
+	var written int
+	sink := func(data []byte) error {
+		written += len(data)
+		return nil
+	}
+	run(fmt.Sprintf("encode %dB", size), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = sink(data)
+		}
+	}, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := bufpool.EncodeJSON(msg, sink); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// run 依次运行默认写法和池化写法，输出每次的耗时、分配和每万次操作的 GC 次数
+func run(name string, baseline, pooled func(b *testing.B)) {
+	for _, c := range []struct {
+		mode string
+		fn   func(b *testing.B)
+	}{{"baseline", baseline}, {"pooled", pooled}} {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		r := testing.Benchmark(c.fn)
+		runtime.ReadMemStats(&after)
+		gcs := float64(after.NumGC-before.NumGC) * 10000 / float64(max(r.N, 1))
+		fmt.Printf("%-14s %-8s %10d ns/op %8d B/op %4d allocs/op %8.2f GC/10k op\n",
+			name, c.mode, r.NsPerOp(), r.AllocedBytesPerOp(), r.AllocsPerOp(), gcs)
+	}
+}
+
+// pipe 在本地起一个不停发送 size 字节文本消息的 WebSocket 服务，返回连到它的客户端连接
+func pipe(size int) (*websocket.Conn, func(), error) {
+	payload := []byte(strings.Repeat("x", size))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	upgrader := websocket.Upgrader{WriteBufferPool: bufpool.WritePool}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			if err := ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		}
+	})}
+	go srv.Serve(ln)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String(), nil)
+	if err != nil {
+		srv.Close()
+		return nil, nil, err
+	}
+	return conn, func() {
+		conn.Close()
+		srv.Close()
+	}, nil
+}
@@ -0,0 +1,388 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// -----------------------
+// 内存中的全文索引：按词建立倒排表，查询时所有词都要命中（AND），按 TF-IDF 打分，分数相同时新的在前。
+// 英文、数字按非字母数字切分并转为小写，中文按相邻两字切分，查询词以 * 结尾时按前缀匹配，
+// 双引号中的短语还要求原文（不区分大小写）包含该短语。文档数超过上限时淘汰最早加入的文档
+// -----------------------
+
+// Document 被索引的一条记录，Text 只用于检索和摘要，不在结果中返回
+type Document struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Subject string    `json:"subject,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Time    time.Time `json:"time"`
+	Title   string    `json:"title,omitempty"`
+	Ref     string    `json:"ref,omitempty"` // 原始记录的引用，比如录像 ID
+	Text    string    `json:"-"`
+}
+
+// Query 为空的字段不过滤；Text 为空时只按条件过滤，按时间倒序返回
+type Query struct {
+	Text    string
+	Kinds   []string
+	Subject string
+	Host    string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Offset  int
+}
+
+// Hit 一条命中的文档
+type Hit struct {
+	Document
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+// Result Total 为过滤后命中的总数
+type Result struct {
+	Total int   `json:"total"`
+	Hits  []Hit `json:"hits"`
+}
+
+type entry struct {
+	doc   Document
+	terms map[string]int // 词 -> 词频
+}
+
+// Index 并发安全
+type Index struct {
+	maxDocs int
+
+	mu       sync.RWMutex
+	docs     map[uint32]*entry
+	ids      map[string]uint32
+	postings map[string]map[uint32]struct{}
+	order    []uint32 // 加入顺序，用于淘汰
+	next     uint32
+}
+
+// New maxDocs 为 0 表示不限制
+func New(maxDocs int) *Index {
+	return &Index{
+		maxDocs:  maxDocs,
+		docs:     make(map[uint32]*entry),
+		ids:      make(map[string]uint32),
+		postings: make(map[string]map[uint32]struct{}),
+	}
+}
+
+// Len 返回文档数
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Add 加入文档，ID 相同的旧文档被替换
+func (x *Index) Add(doc Document) {
+	terms := make(map[string]int)
+	for _, t := range Tokenize(doc.Title + "\n" + doc.Text) {
+		terms[t]++
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.ids[doc.ID]; ok {
+		x.removeLocked(old)
+	}
+	x.next++
+	n := x.next
+	x.docs[n] = &entry{doc: doc, terms: terms}
+	x.ids[doc.ID] = n
+	for t := range terms {
+		p := x.postings[t]
+		if p == nil {
+			p = make(map[uint32]struct{})
+			x.postings[t] = p
+		}
+		p[n] = struct{}{}
+	}
+	x.order = append(x.order, n)
+	for x.maxDocs > 0 && len(x.docs) > x.maxDocs && len(x.order) > 0 {
+		x.removeLocked(x.order[0])
+		x.order = x.order[1:]
+	}
+	// 被替换或删除的文档在 order 中留下的空位较多时压缩
+	if len(x.order) > 2*len(x.docs)+1024 {
+		live := x.order[:0]
+		for _, id := range x.order {
+			if _, ok := x.docs[id]; ok {
+				live = append(live, id)
+			}
+		}
+		x.order = live
+	}
+}
+
+// Remove 删除文档，不存在时忽略
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if n, ok := x.ids[id]; ok {
+		x.removeLocked(n)
+	}
+}
+
+func (x *Index) removeLocked(n uint32) {
+	e, ok := x.docs[n]
+	if !ok {
+		return
+	}
+	for t := range e.terms {
+		if p := x.postings[t]; p != nil {
+			delete(p, n)
+			if len(p) == 0 {
+				delete(x.postings, t)
+			}
+		}
+	}
+	delete(x.docs, n)
+	if x.ids[e.doc.ID] == n {
+		delete(x.ids, e.doc.ID)
+	}
+}
+
+// parsedQuery 查询文字拆分后的词、前缀和短语
+type parsedQuery struct {
+	terms    []string
+	prefixes []string
+	phrases  []string
+}
+
+func parseQuery(text string) parsedQuery {
+	var q parsedQuery
+	for {
+		start := strings.IndexByte(text, '"')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start+1:], '"')
+		if end < 0 {
+			break
+		}
+		phrase := text[start+1 : start+1+end]
+		if strings.TrimSpace(phrase) != "" {
+			q.phrases = append(q.phrases, strings.ToLower(phrase))
+			q.terms = append(q.terms, Tokenize(phrase)...)
+		}
+		text = text[:start] + " " + text[start+2+end:]
+	}
+	for _, word := range strings.Fields(text) {
+		if strings.HasSuffix(word, "*") {
+			if p := Tokenize(strings.TrimSuffix(word, "*")); len(p) == 1 {
+				q.prefixes = append(q.prefixes, p[0])
+				continue
+			}
+		}
+		q.terms = append(q.terms, Tokenize(word)...)
+	}
+	return q
+}
+
+func (q parsedQuery) empty() bool {
+	return len(q.terms) == 0 && len(q.prefixes) == 0
+}
+
+// Search 返回按分数排序的一页结果
+func (x *Index) Search(q Query) Result {
+	pq := parseQuery(q.Text)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	total := float64(len(x.docs))
+	scores := make(map[uint32]float64)
+	first := true
+	// 每个词（前缀展开为多个词的并集）命中的文档取交集
+	match := func(sets []map[uint32]struct{}) {
+		hit := make(map[uint32]float64)
+		for _, set := range sets {
+			idf := math.Log(1 + total/float64(len(set)))
+			for n := range set {
+				if !first {
+					if _, ok := scores[n]; !ok {
+						continue
+					}
+				}
+				hit[n] += idf
+			}
+		}
+		for n, s := range hit {
+			hit[n] = scores[n] + s
+		}
+		scores, first = hit, false
+	}
+	for _, t := range pq.terms {
+		match([]map[uint32]struct{}{x.postings[t]})
+	}
+	for _, p := range pq.prefixes {
+		var sets []map[uint32]struct{}
+		for t, set := range x.postings {
+			if strings.HasPrefix(t, p) {
+				sets = append(sets, set)
+			}
+		}
+		match(sets)
+	}
+	if pq.empty() {
+		for n := range x.docs {
+			scores[n] = 0
+		}
+	}
+
+	var hits []Hit
+	for n, score := range scores {
+		e := x.docs[n]
+		if !q.matches(e.doc) || !containsPhrases(e.doc, pq.phrases) {
+			continue
+		}
+		// 词频加权：同样命中时出现次数多的排在前面
+		for _, t := range pq.terms {
+			score += math.Log(float64(e.terms[t])) / 10
+		}
+		hits = append(hits, Hit{Document: e.doc, Score: math.Round(score*1000) / 1000})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Time.After(hits[j].Time)
+	})
+
+	res := Result{Total: len(hits), Hits: []Hit{}}
+	if q.Offset < len(hits) {
+		hits = hits[q.Offset:]
+		if q.Limit > 0 && len(hits) > q.Limit {
+			hits = hits[:q.Limit]
+		}
+		for i := range hits {
+			hits[i].Snippet = snippet(hits[i].Text, pq)
+		}
+		res.Hits = hits
+	}
+	return res
+}
+
+func (q Query) matches(doc Document) bool {
+	if len(q.Kinds) > 0 {
+		ok := false
+		for _, k := range q.Kinds {
+			ok = ok || k == doc.Kind
+		}
+		if !ok {
+			return false
+		}
+	}
+	if q.Subject != "" && doc.Subject != q.Subject || q.Host != "" && doc.Host != q.Host {
+		return false
+	}
+	if !q.From.IsZero() && doc.Time.Before(q.From) || !q.To.IsZero() && !doc.Time.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+func containsPhrases(doc Document, phrases []string) bool {
+	if len(phrases) == 0 {
+		return true
+	}
+	text := strings.ToLower(doc.Title + "\n" + doc.Text)
+	for _, p := range phrases {
+		if !strings.Contains(text, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// snippetRunes 摘要在命中位置前后各保留的字符数
+const snippetRunes = 80
+
+// snippet 取第一个命中位置附近的原文，没有命中时取开头
+func snippet(text string, q parsedQuery) string {
+	lower := strings.ToLower(text)
+	pos := -1
+	for _, w := range append(append(append([]string{}, q.phrases...), q.terms...), q.prefixes...) {
+		if i := strings.Index(lower, w); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+		}
+	}
+	if len(lower) != len(text) {
+		// 转小写改变了字节长度，位置不可靠
+		pos = -1
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	start := pos
+	for i := 0; i < snippetRunes && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	end := pos
+	for i := 0; i < 2*snippetRunes && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	out := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+// Tokenize 把文字切分为索引词：字母数字按连续串、转小写，汉字、假名、韩文按相邻两字，单独的一个字保留为一个词
+func Tokenize(s string) []string {
+	var out []string
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			out = append(out, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		switch len(cjk) {
+		case 0:
+		case 1:
+			out = append(out, string(cjk))
+		default:
+			for i := 0; i+1 < len(cjk); i++ {
+				out = append(out, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range s {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return out
+}
@@ -0,0 +1,21 @@
+package sshutil
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrForbidden 调用方无权对目标主机执行该操作
+var ErrForbidden = errors.New("forbidden")
+
+// 主机操作的能力名称，与授权规则中的 capabilities 对应
+const (
+	CapTerminal = "terminal"
+	CapUpload   = "upload"
+	CapDownload = "download"
+	CapExec     = "exec"
+)
+
+// Authorize 由主程序设置，term、download 等子包在建立 SSH 连接前调用；
+// host 为资产清单中的主机 ID，使用 default 主机时为空
+var Authorize = func(r *http.Request, capability, host string) error { return nil }
@@ -0,0 +1,165 @@
+package sshutil
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// -----------------------
+// 主机密钥校验：profile 配置了 HostKeyFingerprints 时只接受这些指纹；否则按 known_hosts 文件校验
+// （profile 的 KnownHostsFile，未配置时使用 HostKeys.KnownHostsFile）。开启 TrustOnFirstUse 时，
+// known_hosts 中没有记录的主机在首次连接时记录下来，之后按记录校验，密钥变化时仍然拒绝。
+// 默认以 TOFU 方式使用当前目录下的 known_hosts；显式清空 KnownHostsFile 后不校验主机密钥，每台主机首次连接时记录警告。
+// 校验失败时拨号返回包含 *HostKeyError 的错误
+// -----------------------
+
+// HostKeyConfig 主机密钥校验的全局设置
+type HostKeyConfig struct {
+	KnownHostsFile  string `json:"knownHostsFile,omitempty"`
+	TrustOnFirstUse bool   `json:"trustOnFirstUse,omitempty"`
+}
+
+// DefaultKnownHostsFile 默认的 known_hosts 文件
+const DefaultKnownHostsFile = "known_hosts"
+
+// HostKeys 由主程序设置
+var HostKeys = HostKeyConfig{KnownHostsFile: DefaultKnownHostsFile, TrustOnFirstUse: true}
+
+// 主机密钥校验失败的原因
+const (
+	HostKeyUnknown  = "unknown"  // known_hosts 中没有该主机
+	HostKeyMismatch = "mismatch" // 与记录或固定的指纹不一致
+)
+
+// HostKeyError 主机密钥校验失败，可以直接序列化发给前端
+type HostKeyError struct {
+	Host        string   `json:"host"`
+	Reason      string   `json:"reason"`
+	Fingerprint string   `json:"fingerprint"`        // 对方出示的密钥指纹（SHA256）
+	Expected    []string `json:"expected,omitempty"` // 记录或固定的指纹
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("ssh: host key %s for %s (%s)", e.Reason, e.Host, e.Fingerprint)
+}
+
+// AsHostKeyError 从拨号错误中取出主机密钥校验错误
+func AsHostKeyError(err error) (*HostKeyError, bool) {
+	var hk *HostKeyError
+	ok := errors.As(err, &hk)
+	return hk, ok
+}
+
+// hostKeyCallback 按 profile 和 HostKeys 选择校验方式
+func (p Profile) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if len(p.HostKeyFingerprints) > 0 {
+		return pinnedHostKeys(p.HostKeyFingerprints), nil
+	}
+	file := p.KnownHostsFile
+	if file == "" {
+		file = HostKeys.KnownHostsFile
+	}
+	if file == "" {
+		return unverifiedHostKeys, nil
+	}
+	if HostKeys.TrustOnFirstUse {
+		return trustOnFirstUse(file)
+	}
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		return nil, err
+	}
+	return knownHostsError(cb), nil
+}
+
+// insecureWarned 已经警告过不校验主机密钥的主机
+var insecureWarned sync.Map
+
+// unverifiedHostKeys 不校验主机密钥，每台主机首次连接时记录警告
+func unverifiedHostKeys(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if _, warned := insecureWarned.LoadOrStore(hostname, true); !warned {
+		log.Printf("WARNING: ssh host key of %s (%s) is NOT verified, configure a known_hosts file or host key fingerprints", hostname, ssh.FingerprintSHA256(key))
+	}
+	return nil
+}
+
+// pinnedHostKeys 只接受指定的指纹，指纹可以带或不带 "SHA256:" 前缀
+func pinnedHostKeys(fingerprints []string) ssh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		for _, fp := range fingerprints {
+			if strings.TrimPrefix(fp, "SHA256:") == strings.TrimPrefix(got, "SHA256:") {
+				return nil
+			}
+		}
+		return &HostKeyError{Host: hostname, Reason: HostKeyMismatch, Fingerprint: got, Expected: fingerprints}
+	}
+}
+
+// knownHostsError 将 knownhosts 的 KeyError 转换为 HostKeyError
+func knownHostsError(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) {
+			return err
+		}
+		hk := &HostKeyError{Host: hostname, Reason: HostKeyUnknown, Fingerprint: ssh.FingerprintSHA256(key)}
+		if len(ke.Want) > 0 {
+			hk.Reason = HostKeyMismatch
+			for _, w := range ke.Want {
+				hk.Expected = append(hk.Expected, ssh.FingerprintSHA256(w.Key))
+			}
+		}
+		return hk
+	}
+}
+
+// tofuMu 串行化 known_hosts 文件的追加，避免同一主机并发首次连接时重复记录
+var tofuMu sync.Mutex
+
+// trustOnFirstUse 文件不存在时创建，未知主机的密钥追加到文件中
+func trustOnFirstUse(file string) (ssh.HostKeyCallback, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		return nil, err
+	}
+	verify := knownHostsError(cb)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := verify(hostname, remote, key)
+		if hk, ok := AsHostKeyError(err); !ok || hk.Reason != HostKeyUnknown {
+			return err
+		}
+		tofuMu.Lock()
+		defer tofuMu.Unlock()
+		// 重新读取文件，其它连接可能刚记录了该主机
+		latest, err := knownhosts.New(file)
+		if err != nil {
+			return err
+		}
+		err = knownHostsError(latest)(hostname, remote, key)
+		if hk, ok := AsHostKeyError(err); !ok || hk.Reason != HostKeyUnknown {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
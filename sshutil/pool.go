@@ -0,0 +1,240 @@
+package sshutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Profile 一台目标主机的 SSH 连接参数。认证方式按私钥、keyboard-interactive、密码的顺序尝试：
+// 私钥取 PrivateKey（PEM 内容）或 PrivateKeyFile，加密的私钥需要 Passphrase；
+// KeyboardInteractive 为 true 时以 Password 回答服务端不回显的提示（通常即密码提示）
+type Profile struct {
+	Addr                string   `json:"addr"` // host:port
+	User                string   `json:"user"`
+	Password            string   `json:"password,omitempty"`
+	PrivateKey          string   `json:"privateKey,omitempty"`
+	PrivateKeyFile      string   `json:"privateKeyFile,omitempty"`
+	Passphrase          string   `json:"passphrase,omitempty"`
+	KeyboardInteractive bool     `json:"keyboardInteractive,omitempty"`
+	KnownHostsFile      string   `json:"knownHostsFile,omitempty"`      // 为空时使用 HostKeys.KnownHostsFile
+	HostKeyFingerprints []string `json:"hostKeyFingerprints,omitempty"` // 固定的主机密钥指纹，配置后不再查 known_hosts
+}
+
+// signer 解析 profile 中的私钥，没有配置私钥时返回 nil
+func (p Profile) signer() (ssh.Signer, error) {
+	key := []byte(p.PrivateKey)
+	if len(key) == 0 && p.PrivateKeyFile != "" {
+		data, err := os.ReadFile(p.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = data
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if p.Passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(p.Passphrase))
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.New("private key is encrypted, passphrase is required")
+	}
+	return signer, err
+}
+
+// keyboardInteractive 以密码回答不回显的提示，回显的提示回答空字符串
+func (p Profile) keyboardInteractive(user, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i := range questions {
+		if !echos[i] {
+			answers[i] = p.Password
+		}
+	}
+	return answers, nil
+}
+
+// ClientConfig 根据 profile 生成 ssh.ClientConfig
+func (p Profile) ClientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	signer, err := p.signer()
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if p.KeyboardInteractive {
+		auth = append(auth, ssh.KeyboardInteractive(p.keyboardInteractive))
+	}
+	if p.Password != "" {
+		auth = append(auth, ssh.Password(p.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("profile has no auth method")
+	}
+	hostKeyCallback, err := p.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            p.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         5 * time.Second,
+	}, nil
+}
+
+// ErrUnknownProfile 请求的 profile 不存在
+var ErrUnknownProfile = errors.New("unknown ssh profile")
+
+// ProfileFunc 按名称（或清单中的主机 ID）查找 profile
+type ProfileFunc func(name string) (Profile, bool)
+
+// Lookup 由主程序设置，供 download、term 等子包按主机 ID 查找连接参数
+var Lookup ProfileFunc = func(string) (Profile, bool) { return Profile{}, false }
+
+// LoadProfiles 读取 JSON 格式的 profile 文件（名称 -> Profile），供独立运行的程序设置 Lookup
+func LoadProfiles(path string) (ProfileFunc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return func(name string) (Profile, bool) {
+		p, ok := profiles[name]
+		return p, ok
+	}, nil
+}
+
+// DefaultProfile 终端等未指定 host 时使用的 profile 名称，需要在配置中登记
+const DefaultProfile = "default"
+
+// CredentialFunc 按连接（请求中的调用方身份）和 profile 名称选择连接参数
+type CredentialFunc func(r *http.Request, name string) (Profile, bool)
+
+// Credentials 由主程序设置，可以为不同调用方使用不同的账号和密钥；默认按 Lookup 查找
+var Credentials CredentialFunc = func(_ *http.Request, name string) (Profile, bool) { return Lookup(name) }
+
+// ResolveRequest 按连接选择 host 的地址和连接参数，host 为空表示默认主机；找不到时返回 ErrUnknownProfile
+func ResolveRequest(r *http.Request, host string) (string, *ssh.ClientConfig, error) {
+	name := host
+	if name == "" {
+		name = DefaultProfile
+	}
+	profile, ok := Credentials(r, name)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	cfg, err := profile.ClientConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	return profile.Addr, cfg, nil
+}
+
+// ResolveHost 按主机 ID 查找地址和连接参数，找不到时返回 ErrUnknownProfile
+func ResolveHost(id string) (string, *ssh.ClientConfig, error) {
+	profile, ok := Lookup(id)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnknownProfile, id)
+	}
+	cfg, err := profile.ClientConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	return profile.Addr, cfg, nil
+}
+
+// Pool 按 profile 复用 SSH 连接，每个 profile 保持一条连接，在其上按需开 session
+type Pool struct {
+	mu      sync.Mutex
+	lookup  ProfileFunc
+	clients map[string]*ssh.Client
+}
+
+func NewPool(lookup ProfileFunc) *Pool {
+	return &Pool{
+		lookup:  lookup,
+		clients: make(map[string]*ssh.Client),
+	}
+}
+
+// Get 返回 profile 对应的连接，已有连接失效时重新拨号
+func (p *Pool) Get(ctx context.Context, name string) (*ssh.Client, error) {
+	profile, ok := p.lookup(name)
+	p.mu.Lock()
+	client := p.clients[name]
+	p.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	if client != nil {
+		// 发送 keepalive 请求探测连接是否仍然可用
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+			return client, nil
+		}
+		p.Invalidate(name, client)
+	}
+
+	config, err := profile.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err = DialContext(ctx, "tcp", profile.Addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 并发拨号时保留先放入的连接
+	if existing := p.clients[name]; existing != nil {
+		client.Close()
+		return existing, nil
+	}
+	p.clients[name] = client
+	return client, nil
+}
+
+// Invalidate 关闭并移除失效的连接，client 已被替换时不做处理
+func (p *Pool) Invalidate(name string, client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients[name] == client {
+		delete(p.clients, name)
+	}
+	client.Close()
+}
+
+// Forget 关闭并移除 profile 的连接，profile 修改或删除后调用，下次使用时按新参数拨号
+func (p *Pool) Forget(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client := p.clients[name]; client != nil {
+		client.Close()
+		delete(p.clients, name)
+	}
+}
+
+// Close 关闭全部连接
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, client := range p.clients {
+		client.Close()
+		delete(p.clients, name)
+	}
+}
@@ -0,0 +1,133 @@
+package sshutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultOpTimeout 单次 SSH/SFTP 操作（Stat、Open、MkdirAll 等）的默认超时
+const DefaultOpTimeout = 30 * time.Second
+
+// DialContext 带 context 的 ssh.Dial，握手阶段同样受 context 控制
+func DialContext(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	d := net.Dialer{Timeout: config.Timeout}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// 交互式终端的按键是小包，显式关闭 Nagle（Go 默认也是关闭的，这里不依赖默认值）
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(true)
+	}
+	// 握手期间 context 取消时关闭底层连接以中断握手
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// CloseOnDone 在 ctx 结束（请求取消、WebSocket 断开）时关闭连接，使阻塞中的 SFTP 调用立即返回。
+// 返回的 stop 用于在正常结束时解除关联
+func CloseOnDone(ctx context.Context, closer io.Closer) (stop func() bool) {
+	return context.AfterFunc(ctx, func() { closer.Close() })
+}
+
+// Do 在超时时间内执行一次操作，超时或 ctx 取消时关闭 closer 来中断操作并返回 ctx 的错误。
+// SFTP 协议本身不支持取消单个请求，只能通过关闭连接来释放阻塞的调用
+func Do(ctx context.Context, timeout time.Duration, closer io.Closer, op func() error) error {
+	if timeout <= 0 {
+		timeout = DefaultOpTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		closer.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// CopyContext 与 io.Copy 相同，但每次读写前检查 ctx，并在 idle 时间内没有任何数据进展时中断
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader, idle time.Duration, closer io.Closer) (int64, error) {
+	if idle <= 0 {
+		idle = DefaultOpTimeout
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 看门狗：超过 idle 没有进展时取消
+	progress := make(chan struct{}, 1)
+	go func() {
+		timer := time.NewTimer(idle)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-progress:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(idle)
+			case <-timer.C:
+				cancel()
+				return
+			}
+		}
+	}()
+	stop := CloseOnDone(ctx, closer)
+	defer stop()
+
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		nr, rErr := src.Read(buf)
+		if nr > 0 {
+			nw, wErr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if wErr != nil {
+				return written, wErr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+			select {
+			case progress <- struct{}{}:
+			default:
+			}
+		}
+		if rErr == io.EOF {
+			return written, nil
+		}
+		if rErr != nil {
+			if ctx.Err() != nil {
+				return written, ctx.Err()
+			}
+			return written, rErr
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package term
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 输出合并：cat 大文件等高输出场景下 SSH 每次只读出几百字节，逐个发送会产生大量小帧。
+// 空闲时的小输出（按键回显）仍立即发送；连续输出时先暂存，每 BatchWindow 或攒够 BatchSize 时合并为一帧
+// -----------------------
+
+var (
+	BatchWindow = 5 * time.Millisecond
+	BatchSize   = 8 << 10
+)
+
+// BatchStats 输出合并的统计，Writes - Frames 即合并节省的帧数
+type BatchStats struct {
+	Writes int64 `json:"writes"`
+	Frames int64 `json:"frames"`
+	Bytes  int64 `json:"bytes"`
+}
+
+var batchWrites, batchFrames, batchBytes atomic.Int64
+
+// Stats 返回全部终端的输出合并统计
+func Stats() BatchStats {
+	return BatchStats{
+		Writes: batchWrites.Load(),
+		Frames: batchFrames.Load(),
+		Bytes:  batchBytes.Load(),
+	}
+}
+
+// BatchWriter 包装 WsWriter 合并输出，同时串行化 stdout、stderr 两个 goroutine 的写入
+type BatchWriter struct {
+	out *WsWriter
+
+	mu        sync.Mutex
+	buf       []byte
+	timer     *time.Timer
+	lastWrite time.Time
+	err       error
+}
+
+func NewBatchWriter(out *WsWriter) *BatchWriter {
+	return &BatchWriter{out: out}
+}
+
+func (w *BatchWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		// 等待重连期间丢弃输出，不让 SSH 的输出复制因写失败而结束
+		if ReattachGrace > 0 {
+			return len(b), nil
+		}
+		return 0, w.err
+	}
+	batchWrites.Add(1)
+	batchBytes.Add(int64(len(b)))
+	now := time.Now()
+	busy := now.Sub(w.lastWrite) < BatchWindow
+	w.lastWrite = now
+
+	// 空闲时的小输出直接发送，保证交互延迟
+	if !busy && len(w.buf) == 0 && len(b) <= FastPathSize {
+		return w.writeFrame(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= BatchSize {
+		if _, err := w.flushLocked(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(BatchWindow, func() { _ = w.Flush() })
+	}
+	return len(b), nil
+}
+
+// swap 重连后换用新的连接，清除之前的写错误
+func (w *BatchWriter) swap(conn *websocket.Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out = &WsWriter{Conn: conn, Session: w.out.Session}
+	w.err = nil
+	w.buf = w.buf[:0]
+}
+
+// Close 关闭当前的连接，重连后为新的连接
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Conn.Close()
+}
+
+// WriteText 先发送暂存的输出再发送一条文本控制消息，保证控制消息不会越过之前的输出
+func (w *BatchWriter) WriteText(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.flushLocked(); err != nil {
+		return err
+	}
+	return w.out.Conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Flush 立即发送暂存的输出
+func (w *BatchWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.flushLocked()
+	return err
+}
+
+func (w *BatchWriter) flushLocked() (int, error) {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.buf) == 0 || w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.writeFrame(w.buf)
+	w.buf = w.buf[:0]
+	return n, err
+}
+
+func (w *BatchWriter) writeFrame(b []byte) (int, error) {
+	n, err := w.out.Write(b)
+	if err != nil {
+		w.err = err
+		return n, err
+	}
+	batchFrames.Add(1)
+	return n, nil
+}
@@ -0,0 +1,190 @@
+package term
+
+import (
+	"bytes"
+	"echo_demo/bufpool"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 工作目录跟踪：从终端输出中解析 OSC 7（ESC ] 7 ; file://host/path BEL），
+// 目录变化时向前端推送 {"t":"cwd",...}，前端也可以发送 {"t":"cwd"} 查询，或通过 HTTP 接口按终端 ID 查询。
+// 启动 shell 时为 bash 设置 PROMPT_COMMAND 输出 OSC 7，其它 shell 需要自行配置（多数发行版的 vte.sh 已包含）
+// -----------------------
+
+// maxOSCPending 跨输出块的未完成转义序列最多保留的字节数
+const maxOSCPending = 4096
+
+var (
+	osc7Prefix = []byte("\x1b]7;")
+	oscBEL     = []byte{0x07}
+	oscST      = []byte("\x1b\\")
+)
+
+// CwdInfo 终端的当前工作目录
+type CwdInfo struct {
+	T         string    `json:"t"` // 固定为 "cwd"，作为控制消息发送给前端时使用
+	ID        string    `json:"id"`
+	Host      string    `json:"host,omitempty"` // 资产清单中的主机 ID，使用默认主机时为空
+	Hostname  string    `json:"hostname,omitempty"`
+	Cwd       string    `json:"cwd"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// termSession 一个终端连接的状态
+type termSession struct {
+	id        string
+	host      string
+	startedAt time.Time
+	writer    *BatchWriter
+
+	mu       sync.Mutex
+	cwd      string
+	hostname string
+	updated  time.Time
+	pending  []byte
+
+	// 断线重连：owner 为连接身份（JWT 主体或 token），detached 时等待 attachCh 送来新的连接
+	owner    string
+	attachCh chan *websocket.Conn
+	detached bool
+	inputSeq int64 // 已写入 shell 的最大输入序号
+}
+
+var (
+	termSessionsMu sync.Mutex
+	termSessions   = map[string]*termSession{}
+)
+
+func registerTermSession(t *termSession) {
+	termSessionsMu.Lock()
+	defer termSessionsMu.Unlock()
+	termSessions[t.id] = t
+}
+
+func unregisterTermSession(id string) {
+	termSessionsMu.Lock()
+	defer termSessionsMu.Unlock()
+	delete(termSessions, id)
+}
+
+func (t *termSession) info() CwdInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return CwdInfo{
+		T:         "cwd",
+		ID:        t.id,
+		Host:      t.host,
+		Hostname:  t.hostname,
+		Cwd:       t.cwd,
+		UpdatedAt: t.updated,
+		StartedAt: t.startedAt,
+	}
+}
+
+// scan 在输出中查找 OSC 7，返回目录是否变化
+func (t *termSession) scan(b []byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data := b
+	if len(t.pending) > 0 {
+		data = append(t.pending, b...)
+		t.pending = nil
+	}
+	changed := false
+	for {
+		i := bytes.Index(data, osc7Prefix)
+		if i < 0 {
+			// 输出末尾可能是前缀的一部分
+			for k := len(osc7Prefix) - 1; k > 0; k-- {
+				if bytes.HasSuffix(data, osc7Prefix[:k]) {
+					t.pending = append([]byte(nil), data[len(data)-k:]...)
+					break
+				}
+			}
+			return changed
+		}
+		data = data[i+len(osc7Prefix):]
+		end, termLen := bytes.Index(data, oscBEL), 1
+		if st := bytes.Index(data, oscST); st >= 0 && (end < 0 || st < end) {
+			end, termLen = st, len(oscST)
+		}
+		if end < 0 {
+			if len(data) < maxOSCPending {
+				t.pending = append(append([]byte(nil), osc7Prefix...), data...)
+			}
+			return changed
+		}
+		if hostname, cwd, ok := parseOSC7(string(data[:end])); ok && (cwd != t.cwd || hostname != t.hostname) {
+			t.cwd, t.hostname, t.updated = cwd, hostname, time.Now()
+			changed = true
+		}
+		data = data[end+termLen:]
+	}
+}
+
+// parseOSC7 解析 file://host/path，路径中的百分号编码会被解码
+func parseOSC7(payload string) (hostname, cwd string, ok bool) {
+	if !strings.HasPrefix(payload, "file://") {
+		return "", "", false
+	}
+	if u, err := url.Parse(payload); err == nil && u.Path != "" {
+		return u.Host, u.Path, true
+	}
+	rest := strings.TrimPrefix(payload, "file://")
+	slash := strings.IndexByte(rest, '/')
+	if slash < 0 {
+		return "", "", false
+	}
+	return rest[:slash], rest[slash:], true
+}
+
+// cwdWriter 转发终端输出，目录变化时在输出之后推送 cwd 控制消息
+type cwdWriter struct {
+	term *termSession
+}
+
+func (w *cwdWriter) Write(b []byte) (int, error) {
+	changed := w.term.scan(b)
+	n, err := w.term.writer.Write(b)
+	if err == nil && changed {
+		w.term.sendCwd()
+	}
+	return n, err
+}
+
+func (t *termSession) sendCwd() {
+	_ = bufpool.EncodeJSON(t.info(), t.writer.WriteText)
+}
+
+// ListTermSessionsHandler 列出当前的终端及其工作目录
+func ListTermSessionsHandler(c echo.Context) error {
+	termSessionsMu.Lock()
+	list := make([]CwdInfo, 0, len(termSessions))
+	for _, t := range termSessions {
+		list = append(list, t.info())
+	}
+	termSessionsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return c.JSON(http.StatusOK, list)
+}
+
+// GetTermCwdHandler 按终端 ID 查询工作目录
+func GetTermCwdHandler(c echo.Context) error {
+	termSessionsMu.Lock()
+	t := termSessions[c.Param("id")]
+	termSessionsMu.Unlock()
+	if t == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "terminal not found"})
+	}
+	return c.JSON(http.StatusOK, t.info())
+}
@@ -2,6 +2,9 @@ package term
 
 import (
 	"context"
+	"echo_demo/bufpool"
+	"echo_demo/jwtauth"
+	"echo_demo/sshutil"
 	"encoding/json"
 	"io"
 	"log"
@@ -26,23 +29,47 @@ type WsOut struct {
 	Message string `json:"msg"`
 }
 
+// ReadOnly 由主程序设置，返回 true 时丢弃终端输入和信号，只保留输出、窗口调整和工作目录查询
+var ReadOnly func() bool
+
+func readOnly() bool {
+	return ReadOnly != nil && ReadOnly()
+}
+
+// Approve 由主程序设置，在拨号前调用，打开受保护的主机需要等待审批通过；notify 向前端发送提示文字
+var Approve func(ctx context.Context, r *http.Request, host string, notify func(string)) error
+
 // WsReader 从 WebSocket 读取数据，实现 io.Reader 接口
 type WsReader struct {
 	Conn    *websocket.Conn
 	Session *ssh.Session
+	Client  *ssh.Client
+	TermID  string
+	term    *termSession
+	gone    func() // 连接断开且没有重连时调用
 }
 
 func (r *WsReader) Read(b []byte) (int, error) {
 	for {
 		msgType, reader, err := r.Conn.NextReader()
 		if err != nil {
+			if r.term != nil {
+				if ws, ok := r.term.waitReattach(); ok {
+					r.Conn.Close()
+					r.Conn = ws
+					continue
+				}
+			}
+			if r.gone != nil {
+				r.gone()
+			}
 			return 0, err
 		}
 		if msgType != websocket.TextMessage {
 			// 只处理文本消息
 			continue
 		}
-		data, err := io.ReadAll(reader)
+		data, err := bufpool.ReadAll(reader)
 		if err != nil {
 			return 0, err
 		}
@@ -56,10 +83,37 @@ func (r *WsReader) Read(b []byte) (int, error) {
 				}
 				// 调整窗口后继续等待下一个消息
 				continue
+			} else if resize.T == "cwd" && r.term != nil {
+				r.term.sendCwd()
+				continue
+			} else if readOnly() {
+				continue
+			} else if resize.T == "input" && r.term != nil {
+				// 带序号的输入，重连后重发的已写入帧直接丢弃
+				var in InputData
+				_ = json.Unmarshal(data, &in)
+				if !r.term.applyInput(in.Seq) {
+					continue
+				}
+				if in.Seq > 0 {
+					r.term.sendAck("input_ack", in.Seq)
+				}
+				return copy(b, in.D), nil
+			} else if resize.T == "signal" {
+				// 发送信号失败（例如服务端不支持 signal 请求）不影响终端继续使用
+				var sig SignalData
+				_ = json.Unmarshal(data, &sig)
+				if err := sendSignal(r.Client, r.Session, r.TermID, sig.Sig); err != nil {
+					log.Printf("Send signal %q error: %v", sig.Sig, err)
+				}
+				continue
 			} else {
 				// 如果是其它 JSON 数据，可根据需求处理，这里直接返回原始数据
 				return copy(b, data), nil
 			}
+		} else if readOnly() {
+			// 只读模式下丢弃键盘输入
+			continue
 		} else {
 			// 非 JSON 消息，直接返回原始数据
 			return copy(b, data), nil
@@ -74,6 +128,14 @@ type WsWriter struct {
 }
 
 func (p *WsWriter) Write(b []byte) (n int, err error) {
+	// 按键回显等小帧直接写出，不经过写缓冲
+	if len(b) <= FastPathSize {
+		if err := p.Conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+			slog.Info("websocket write fail: " + err.Error())
+			return 0, err
+		}
+		return len(b), nil
+	}
 	w, wErr := p.Conn.NextWriter(websocket.BinaryMessage)
 	if wErr != nil {
 		slog.Info("websocket write fail: " + wErr.Error())
@@ -88,8 +150,13 @@ func (p *WsWriter) Write(b []byte) (n int, err error) {
 	return w.Write(b)
 }
 
+// Validator 由主程序设置，为 nil 时只检查 token 非空
+var Validator jwtauth.TokenValidator
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	WriteBufferSize: WriteBufferSize,
+	WriteBufferPool: bufpool.WritePool,
+	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
 func ReleaseSSHResources(client *ssh.Client, session *ssh.Session) {
@@ -116,39 +183,80 @@ func WsSSHHandler(c echo.Context) error {
 		log.Println("token is empty")
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing token"})
 	}
+	owner := token
+	if Validator != nil {
+		claims, err := Validator.Validate(token)
+		if err != nil {
+			log.Println("token validate error:", err)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		owner = claims.Subject
+	}
 	respHeader := http.Header{
 		"Sec-WebSocket-Protocol": []string{token},
 	}
+	if id := c.QueryParam("attach"); id != "" {
+		return attachHandler(c, id, owner, respHeader)
+	}
 
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), respHeader)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return err
 	}
+	TuneConn(ws)
 
 	// 创建 context，用于监听关闭事件
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// 设置关闭处理器，WebSocket 关闭时取消 context
+	// 设置关闭处理器，WebSocket 关闭时取消 context；允许重连时由 WsReader 在等待超时后取消
 	ws.SetCloseHandler(func(code int, text string) error {
 		log.Printf("WebSocket close: %d %s", code, text)
-		cancel()
+		if ReattachGrace <= 0 {
+			cancel()
+		}
 		return nil
 	})
 
-	// 配置 SSH 客户端参数
-	sshConfig := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("vUbFTsMJUY3AhpyT"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+	host := c.QueryParam("host")
+	if err := sshutil.Authorize(c.Request(), sshutil.CapTerminal, host); err != nil {
+		_ = ws.WriteMessage(websocket.TextMessage, []byte("SSH authz error: "+err.Error()))
+		log.Println("SSH authz error:", err)
+		ws.Close()
+		return err
+	}
+	if Approve != nil {
+		notify := func(text string) { _ = ws.WriteMessage(websocket.TextMessage, []byte(text)) }
+		if err := Approve(ctx, c.Request(), host, notify); err != nil {
+			_ = ws.WriteMessage(websocket.TextMessage, []byte("SSH approval error: "+err.Error()))
+			log.Println("SSH approval error:", err)
+			ws.Close()
+			return err
+		}
+	}
+	// 按调用方和 host 选择连接参数，未指定 host 时连接默认主机
+	sshAddr, sshConfig, err := sshutil.ResolveRequest(c.Request(), host)
+	if err != nil {
+		_ = ws.WriteMessage(websocket.TextMessage, []byte("SSH host error: "+err.Error()))
+		log.Println("SSH host error:", err)
+		ws.Close()
+		return err
 	}
 
-	// 建立 SSH 连接
-	sshClient, err := ssh.Dial("tcp", "39.98.79.46:22", sshConfig)
+	// 建立 SSH 连接，WebSocket 关闭时中断拨号
+	sshClient, err := sshutil.DialContext(ctx, "tcp", sshAddr, sshConfig)
+	if hkErr, ok := sshutil.AsHostKeyError(err); ok {
+		// 主机密钥校验失败时返回结构化错误，前端据此提示用户核对或更新主机指纹
+		out.Code = http.StatusBadGateway
+		out.Message = "主机密钥校验失败"
+		out.Data = hkErr
+		message, _ := json.Marshal(&out)
+		_ = ws.WriteMessage(websocket.BinaryMessage, message)
+		log.Println("SSH host key error:", err)
+		ws.Close()
+		return err
+	}
 	if err != nil {
 		_ = ws.WriteMessage(websocket.TextMessage, []byte("SSH dial error: "+err.Error()))
 		log.Println("SSH dial error:", err)
@@ -183,14 +291,18 @@ func WsSSHHandler(c echo.Context) error {
 	}
 
 	// 创建自定义的 WsReader 和 WsWriter，并重定向 SSH I/O
-	wsReader := &WsReader{Conn: ws, Session: session}
-	wsWriter := &WsWriter{Conn: ws, Session: session}
+	termID := newTermID()
+	wsWriter := NewBatchWriter(&WsWriter{Conn: ws, Session: session})
+	ts := &termSession{id: termID, host: host, startedAt: time.Now(), writer: wsWriter, owner: owner, attachCh: make(chan *websocket.Conn, 1)}
+	registerTermSession(ts)
+	defer unregisterTermSession(termID)
+	wsReader := &WsReader{Conn: ws, Session: session, Client: sshClient, TermID: termID, term: ts, gone: cancel}
 	session.Stdin = wsReader
-	session.Stdout = wsWriter
+	session.Stdout = &cwdWriter{term: ts}
 	session.Stderr = wsWriter
 
 	// 启动交互式 shell
-	if err := session.Shell(); err != nil {
+	if err := session.Start(shellCommand(termID)); err != nil {
 		out.Code = http.StatusBadRequest
 		out.Message = "shell终端打开失败"
 		message, _ := json.Marshal(&out)
@@ -200,29 +312,31 @@ func WsSSHHandler(c echo.Context) error {
 		return err
 	}
 
+	// 告知前端终端 ID，用于按 ID 查询工作目录
+	ts.sendCwd()
+
 	// 在一个新的 goroutine 中调用 session.Wait()
+	waitDone := make(chan struct{})
 	go func() {
+		defer close(waitDone)
 		waitErr := session.Wait()
 		if waitErr != nil {
-			slog.Info("session wait error:", waitErr)
+			slog.Info("session wait error", "err", waitErr)
 		}
 	}()
 
-	// 在主 goroutine 中监听 WebSocket 连接关闭事件
-	for {
-		select {
-		case <-ctx.Done():
-			// WebSocket 连接已关闭，中断 session.Wait()
-			sigErr := session.Signal(ssh.SIGINT)
-			if sigErr != nil {
-				break
-			}
-			break
-		default:
-			// 继续等待
-			time.Sleep(time.Millisecond * 100)
+	// 在主 goroutine 中等待 WebSocket 关闭或 shell 退出
+	select {
+	case <-ctx.Done():
+		// WebSocket 连接已关闭，按 CloseBehavior 结束远程 shell
+		if err := sendSignal(sshClient, session, termID, CloseBehavior); err != nil {
+			log.Printf("Close behavior %q error: %v", CloseBehavior, err)
 		}
+	case <-waitDone:
+		_ = wsWriter.Flush()
+		_ = wsWriter.Close()
 	}
+	return nil
 }
 
 //func main() {
@@ -0,0 +1,133 @@
+package term
+
+import (
+	"echo_demo/bufpool"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 断线重连与输入去重：ReattachGrace 大于 0 时，WebSocket 断开后终端保留该时长，期间的输出被丢弃；
+// 前端以同一身份连接 /term?attach={终端 ID} 即可接回原来的 shell，hub 先回复 {"t":"attached","id":...,"seq":N}。
+// 前端以 {"t":"input","seq":N,"d":"..."} 发送带序号的输入，hub 写入 shell 后回复 {"t":"input_ack","seq":N}；
+// 重连后前端重发尚未确认的输入，序号不大于已写入序号的帧直接丢弃，避免同一命令执行两次
+// -----------------------
+
+// ReattachGrace 由主程序设置，0 表示 WebSocket 断开即结束终端
+var ReattachGrace time.Duration
+
+// InputData 带序号的输入帧，Seq 为 0 时不做去重
+type InputData struct {
+	T   string `json:"t"`
+	Seq int64  `json:"seq"`
+	D   string `json:"d"`
+}
+
+// InputAck 输入确认，也用于重连后的 attached 消息
+type InputAck struct {
+	T   string `json:"t"`
+	ID  string `json:"id,omitempty"`
+	Seq int64  `json:"seq"`
+}
+
+var duplicateInputs atomic.Int64
+
+// DuplicateInputs 重连后丢弃的重复输入帧数
+func DuplicateInputs() int64 {
+	return duplicateInputs.Load()
+}
+
+// applyInput 记录输入序号，返回 false 表示该帧已写入过
+func (t *termSession) applyInput(seq int64) bool {
+	if seq <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq <= t.inputSeq {
+		duplicateInputs.Add(1)
+		return false
+	}
+	t.inputSeq = seq
+	return true
+}
+
+func (t *termSession) sendAck(kind string, seq int64) {
+	ack := InputAck{T: kind, Seq: seq}
+	if kind == "attached" {
+		ack.ID = t.id
+	}
+	_ = bufpool.EncodeJSON(ack, t.writer.WriteText)
+}
+
+// waitReattach 在 WebSocket 读取失败后调用，等待前端重新连接，超时返回 false
+func (t *termSession) waitReattach() (*websocket.Conn, bool) {
+	if ReattachGrace <= 0 {
+		return nil, false
+	}
+	t.mu.Lock()
+	t.detached = true
+	t.mu.Unlock()
+	log.Printf("Terminal %s detached, waiting %v for reattach", t.id, ReattachGrace)
+	timer := time.NewTimer(ReattachGrace)
+	defer timer.Stop()
+	select {
+	case ws := <-t.attachCh:
+		t.writer.swap(ws)
+		t.mu.Lock()
+		t.detached = false
+		seq := t.inputSeq
+		t.mu.Unlock()
+		log.Printf("Terminal %s reattached", t.id)
+		t.sendAck("attached", seq)
+		return ws, true
+	case <-timer.C:
+		t.mu.Lock()
+		t.detached = false
+		t.mu.Unlock()
+		log.Printf("Terminal %s reattach grace expired", t.id)
+		return nil, false
+	}
+}
+
+// reattach 把新的 WebSocket 交给等待中的终端，终端未断开或身份不同时返回 false
+func (t *termSession) reattach(owner string, ws *websocket.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.detached || owner != t.owner {
+		return false
+	}
+	select {
+	case t.attachCh <- ws:
+		return true
+	default:
+		return false
+	}
+}
+
+// attachHandler 处理 /term?attach={终端 ID}，成功后连接由原终端的读写循环接管
+func attachHandler(c echo.Context, id, owner string, respHeader http.Header) error {
+	termSessionsMu.Lock()
+	t := termSessions[id]
+	termSessionsMu.Unlock()
+	if t == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "terminal not found"})
+	}
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), respHeader)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return err
+	}
+	TuneConn(ws)
+	if !t.reattach(owner, ws) {
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "terminal is not waiting for reattach")
+		_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		ws.Close()
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package term
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignalData 前端发送的信号控制消息，例如 {"t":"signal","sig":"TERM"}
+type SignalData struct {
+	T   string `json:"t"`
+	Sig string `json:"sig"`
+}
+
+// KillGroup 不是 SSH 信号，表示结束终端会话中的全部进程（shell 及其启动的作业）
+const KillGroup = "killgroup"
+
+// CloseBehavior WebSocket 关闭时对远程 shell 的处理，取值为 signals 中的信号名或 KillGroup
+var CloseBehavior = "INT"
+
+// signals 允许前端发送的信号，与 RFC 4254 中定义的信号名一致
+var signals = map[string]ssh.Signal{
+	"HUP":  ssh.SIGHUP,
+	"INT":  ssh.SIGINT,
+	"QUIT": ssh.SIGQUIT,
+	"KILL": ssh.SIGKILL,
+	"TERM": ssh.SIGTERM,
+	"USR1": ssh.SIGUSR1,
+	"USR2": ssh.SIGUSR2,
+	"ALRM": ssh.SIGALRM,
+	"PIPE": ssh.SIGPIPE,
+}
+
+// ValidateSignal 检查信号名是否可用于控制消息或 CloseBehavior
+func ValidateSignal(name string) error {
+	if _, ok := signals[name]; ok || name == KillGroup {
+		return nil
+	}
+	return fmt.Errorf("unknown terminal signal %q", name)
+}
+
+// newTermID 生成终端标识，写入 shell 的环境变量，用于 KillGroup 时找到属于该终端的进程
+func newTermID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// shellCommand 导出终端标识后以登录 shell 替换当前进程，效果与请求默认 shell 相同；
+// PROMPT_COMMAND 让 bash 每次显示提示符时输出 OSC 7，用于跟踪工作目录
+func shellCommand(termID string) string {
+	return fmt.Sprintf(`export WSHUB_TERM=%s; `+
+		`export PROMPT_COMMAND='printf "\033]7;file://%%s%%s\007" "$HOSTNAME" "$PWD"'; `+
+		`exec "${SHELL:-/bin/sh}" -l`, termID)
+}
+
+// sendSignal 向 shell 发送信号；KillGroup 时另开 session，结束环境变量带有终端标识的进程及其会话中的进程（依赖 /proc，仅支持 Linux）
+func sendSignal(client *ssh.Client, session *ssh.Session, termID, name string) error {
+	if name == KillGroup {
+		s, err := client.NewSession()
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		cmd := fmt.Sprintf(`for f in $(grep -l 'WSHUB_TERM=%s' /proc/[0-9]*/environ 2>/dev/null); do `+
+			`p=${f#/proc/}; p=${p%%/environ}; pkill -KILL -s "$p" 2>/dev/null; kill -KILL "$p" 2>/dev/null; done; true`, termID)
+		return s.Run(cmd)
+	}
+	sig, ok := signals[name]
+	if !ok {
+		return ValidateSignal(name)
+	}
+	log.Printf("Send SIG%s to terminal %s", name, termID)
+	return session.Signal(sig)
+}
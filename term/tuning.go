@@ -0,0 +1,35 @@
+package term
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// -----------------------
+// 按键延迟优化：终端的输入和回显大多是几个字节的小帧，
+// 关闭 Nagle 避免小包等待前一个包的 ACK，小帧走 WriteMessage 一次写出帧头和数据
+// -----------------------
+
+const (
+	// WriteBufferSize 终端 WebSocket 的写缓冲，大输出按该大小分片发送，小帧不经过缓冲
+	WriteBufferSize = 1024
+	// FastPathSize 不超过该大小的输出按单帧直接写出
+	FastPathSize = 512
+)
+
+// SetNoDelay 在 conn 底层的 TCP 连接上设置 TCP_NODELAY，TLS 连接取其内部连接
+func SetNoDelay(conn net.Conn, noDelay bool) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(noDelay)
+	}
+}
+
+// TuneConn 对终端 WebSocket 连接应用延迟优化
+func TuneConn(ws *websocket.Conn) {
+	SetNoDelay(ws.UnderlyingConn(), true)
+}
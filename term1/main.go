@@ -1,8 +1,9 @@
 package main
 
 import (
+	"echo_demo/sshutil"
 	"encoding/json"
-	"io/ioutil"
+	"flag"
 	"log"
 	"net/http"
 
@@ -16,6 +17,12 @@ type windowSize struct {
 	Cols int `json:"cols"`
 }
 
+// hostKeyError is sent to the frontend when the host key of the target cannot be verified
+type hostKeyError struct {
+	T string `json:"t"`
+	*sshutil.HostKeyError
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -25,6 +32,16 @@ var upgrader = websocket.Upgrader{
 }
 
 func main() {
+	profiles := flag.String("ssh-profiles", "ssh_profiles.json", "JSON file of SSH profiles (name -> profile)")
+	knownHosts := flag.String("known-hosts", sshutil.DefaultKnownHostsFile, "known_hosts file, unknown hosts are trusted on first use")
+	flag.Parse()
+	lookup, err := sshutil.LoadProfiles(*profiles)
+	if err != nil {
+		log.Fatal("load ssh profiles error:", err)
+	}
+	sshutil.Lookup = lookup
+	sshutil.HostKeys.KnownHostsFile = *knownHosts
+
 	e := echo.New()
 	e.GET("/term", sshWebsocket)
 	if err := e.Start(":8080"); err != nil {
@@ -40,31 +57,26 @@ func sshWebsocket(c echo.Context) error {
 	}
 	defer conn.Close()
 
-	//get private key of user
-	key, err := ioutil.ReadFile("/home/richard_lin/.ssh/id_rsa")
+	// look up address and credentials of the target host ("default" when no host is given)
+	addr, config, err := sshutil.ResolveRequest(c.Request(), c.QueryParam("host"))
 	if err != nil {
-		log.Fatalf("unable to read private key: %v", err)
+		log.Printf("unable to resolve ssh host: %v", err)
+		conn.WriteMessage(websocket.BinaryMessage, []byte(err.Error()))
+		return nil
 	}
 
-	// Create the Signer for this private key.
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		log.Fatalf("unable to parse private key: %v", err)
-	}
-
-	config := &ssh.ClientConfig{
-		User: "richard_lin",
-		Auth: []ssh.AuthMethod{
-			// Use the PublicKeys method for remote authentication.
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	// Connect to the remote server and perform the SSH handshake, the host key is verified by sshutil.
+	sshConn, err := sshutil.DialContext(c.Request().Context(), "tcp", addr, config)
+	if hkErr, ok := sshutil.AsHostKeyError(err); ok {
+		log.Printf("ssh host key error: %v", err)
+		message, _ := json.Marshal(hostKeyError{T: "host_key_error", HostKeyError: hkErr})
+		conn.WriteMessage(websocket.TextMessage, message)
+		return nil
 	}
-
-	// Connect to the remote server and perform the SSH handshake.
-	sshConn, err := ssh.Dial("tcp", "10.200.252.123:22", config)
 	if err != nil {
-		log.Fatalf("unable to connect: %v", err)
+		log.Printf("unable to connect: %v", err)
+		conn.WriteMessage(websocket.BinaryMessage, []byte(err.Error()))
+		return nil
 	}
 	defer sshConn.Close()
 
@@ -2,6 +2,11 @@ package term2
 
 import (
 	"bytes"
+	"context"
+	"echo_demo/bufpool"
+	"echo_demo/sshutil"
+	"echo_demo/term"
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/ssh"
@@ -14,10 +19,24 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// ResizeData 前端（xterm.js）调整窗口大小时发送的消息，格式与 term 包相同
+type ResizeData struct {
+	T string `json:"t"`
+	W int    `json:"w"`
+	H int    `json:"h"`
+}
+
+// HostKeyErrorData 主机密钥校验失败时发给前端的消息
+type HostKeyErrorData struct {
+	T string `json:"t"`
+	*sshutil.HostKeyError
+}
+
 // WsReader 从 WebSocket 读取数据，并使用内部缓冲区确保数据完整传递
 type WsReader struct {
-	Conn   *websocket.Conn
-	buffer bytes.Buffer
+	Conn    *websocket.Conn
+	Session *ssh.Session // 用于处理 resize 消息
+	buffer  bytes.Buffer
 }
 
 func (r *WsReader) Read(p []byte) (int, error) {
@@ -26,14 +45,24 @@ func (r *WsReader) Read(p []byte) (int, error) {
 		return r.buffer.Read(p)
 	}
 
-	// 读取一条完整消息
-	_, msg, err := r.Conn.ReadMessage()
-	if err != nil {
-		return 0, err
+	for {
+		// 读取一条完整消息
+		_, msg, err := bufpool.ReadMessage(r.Conn, 0)
+		if err != nil {
+			return 0, err
+		}
+		// resize 消息调整伪终端大小，不写入 shell
+		var resize ResizeData
+		if json.Unmarshal(msg, &resize) == nil && resize.T == "resize" {
+			if err := r.Session.WindowChange(resize.H, resize.W); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		// 将消息写入内部缓冲区
+		r.buffer.Write(msg)
+		return r.buffer.Read(p)
 	}
-	// 将消息写入内部缓冲区
-	r.buffer.Write(msg)
-	return r.buffer.Read(p)
 }
 
 // WsWriter 将数据写入 WebSocket，并使用互斥锁保护写入操作
@@ -94,19 +123,10 @@ func (ts *TerminalSession) Close() {
 	}
 }
 
-// CreateTerminalSession 建立 SSH 连接、创建 SSH 会话并设置伪终端，重定向 I/O 到自定义读写器
-func CreateTerminalSession(ws *websocket.Conn) (*TerminalSession, error) {
-	// 配置 SSH 客户端参数
-	sshConfig := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("vUbFTsMJUY3AhpyT"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-
+// CreateTerminalSession 建立 SSH 连接、创建 SSH 会话并设置伪终端，重定向 I/O 到自定义读写器；ctx 结束时中断拨号
+func CreateTerminalSession(ctx context.Context, ws *websocket.Conn, addr string, sshConfig *ssh.ClientConfig) (*TerminalSession, error) {
 	// 建立 SSH 连接
-	sshClient, err := ssh.Dial("tcp", "39.98.79.46:22", sshConfig)
+	sshClient, err := sshutil.DialContext(ctx, "tcp", addr, sshConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -131,7 +151,7 @@ func CreateTerminalSession(ws *websocket.Conn) (*TerminalSession, error) {
 	}
 
 	// 创建自定义的 WebSocket 读写器
-	wsReader := &WsReader{Conn: ws}
+	wsReader := &WsReader{Conn: ws, Session: session}
 	wsWriter := &WsWriter{Conn: ws}
 
 	// 将 SSH 会话的标准输入、输出和错误输出重定向到 WsReader/WsWriter
@@ -165,11 +185,46 @@ func TerminalHandler(c echo.Context) error {
 		return err
 	}
 
-	terminalSession, err := CreateTerminalSession(ws)
+	host := c.QueryParam("host")
+	if err := sshutil.Authorize(c.Request(), sshutil.CapTerminal, host); err != nil {
+		ws.WriteMessage(websocket.TextMessage, []byte("SSH authz error: "+err.Error()))
+		log.Printf("SSH authz error: %v", err)
+		ws.Close()
+		return err
+	}
+	// 与 term 共用审批钩子，受保护的主机需要等待审批通过
+	if term.Approve != nil {
+		notify := func(text string) { _ = ws.WriteMessage(websocket.TextMessage, []byte(text)) }
+		if err := term.Approve(c.Request().Context(), c.Request(), host, notify); err != nil {
+			ws.WriteMessage(websocket.TextMessage, []byte("SSH approval error: "+err.Error()))
+			log.Printf("SSH approval error: %v", err)
+			ws.Close()
+			return err
+		}
+	}
+
+	// 按调用方和 host 参数选择连接参数，未指定 host 时连接默认主机
+	addr, sshConfig, err := sshutil.ResolveRequest(c.Request(), host)
+	if err != nil {
+		ws.WriteMessage(websocket.TextMessage, []byte("SSH host error: "+err.Error()))
+		log.Printf("SSH host error: %v", err)
+		ws.Close()
+		return err
+	}
+
+	terminalSession, err := CreateTerminalSession(c.Request().Context(), ws, addr, sshConfig)
+	if hkErr, ok := sshutil.AsHostKeyError(err); ok {
+		// 主机密钥校验失败时返回结构化错误，前端据此提示用户核对或更新主机指纹
+		message, _ := json.Marshal(HostKeyErrorData{T: "host_key_error", HostKeyError: hkErr})
+		ws.WriteMessage(websocket.TextMessage, message)
+		log.Printf("SSH host key error: %v", err)
+		ws.Close()
+		return err
+	}
 	if err != nil {
 		ws.WriteMessage(websocket.TextMessage, []byte("Terminal session error: "+err.Error()))
 		log.Printf("CreateTerminalSession error: %v", err)
-		terminalSession.Close()
+		ws.Close()
 		return err
 	}
 	// 设置关闭回调，当 ws 主动关闭时，释放资源
@@ -0,0 +1,147 @@
+package main
+
+// 按键回显延迟基准：本地起一个回显终端输出的 WebSocket 服务，客户端按固定间隔发送单字节按键，
+// 统计每个按键从发出到收到回显的延迟，对比默认写法（开启 Nagle、经缓冲写出）和 term 包的优化写法。
+//
+//	go run ./termbench -n 2000 -interval 2ms
+
+import (
+	"echo_demo/term"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type mode struct {
+	name  string
+	tuned bool
+}
+
+func main() {
+	n := flag.Int("n", 2000, "number of keystrokes per mode")
+	interval := flag.Duration("interval", 2*time.Millisecond, "delay between keystrokes")
+	flag.Parse()
+
+	for _, m := range []mode{{"baseline", false}, {"tuned", true}} {
+		lat, err := run(m, *n, *interval)
+		if err != nil {
+			log.Fatalf("%s: %v", m.name, err)
+		}
+		report(m.name, lat)
+	}
+}
+
+// serve 回显收到的每一帧，tuned 时使用 term 包的连接设置和写出方式
+func serve(m mode) (string, func(), error) {
+	upgrader := websocket.Upgrader{}
+	if m.tuned {
+		upgrader.WriteBufferSize = term.WriteBufferSize
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		term.SetNoDelay(ws.UnderlyingConn(), m.tuned)
+		out := &term.WsWriter{Conn: ws}
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if m.tuned {
+				_, err = out.Write(data)
+			} else {
+				err = bufferedWrite(ws, data)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})}
+	go srv.Serve(ln)
+	return "ws://" + ln.Addr().String() + "/", func() { srv.Close() }, nil
+}
+
+// bufferedWrite 优化前的写法：每次输出都经过 NextWriter 缓冲
+func bufferedWrite(ws *websocket.Conn, data []byte) error {
+	w, err := ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func run(m mode, n int, interval time.Duration) ([]time.Duration, error) {
+	addr, stop, err := serve(m)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+	ws, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Close()
+	term.SetNoDelay(ws.UnderlyingConn(), m.tuned)
+
+	// 回显按发送顺序返回，按 FIFO 匹配发送时间
+	var mu sync.Mutex
+	sent := make([]time.Time, 0, n)
+	lat := make([]time.Duration, 0, n)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				done <- err
+				return
+			}
+			mu.Lock()
+			lat = append(lat, time.Since(sent[i]))
+			mu.Unlock()
+		}
+		done <- nil
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; i < n; i++ {
+		<-ticker.C
+		mu.Lock()
+		sent = append(sent, time.Now())
+		mu.Unlock()
+		if err := ws.WriteMessage(websocket.TextMessage, []byte{'a' + byte(i%26)}); err != nil {
+			return nil, err
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return lat, nil
+}
+
+func report(name string, lat []time.Duration) {
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	pct := func(p float64) time.Duration { return lat[int(float64(len(lat)-1)*p)] }
+	var sum time.Duration
+	for _, d := range lat {
+		sum += d
+	}
+	fmt.Printf("%-8s n=%d avg=%v p50=%v p90=%v p99=%v max=%v\n",
+		name, len(lat), sum/time.Duration(len(lat)), pct(0.5), pct(0.9), pct(0.99), lat[len(lat)-1])
+}
@@ -0,0 +1,227 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLP/HTTP JSON 编码，字段名和取值规则见 opentelemetry-proto 的 JSON 映射：
+// traceId/spanId 为十六进制字符串，64 位整数为十进制字符串
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 未设置，2 出错
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+func attrValue(v interface{}) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		s := strconv.FormatInt(int64(x), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+// record 把结束的 span 转为 OTLP 格式，属性按键排序
+func (s *Span) record(end time.Time) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		rec.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	keys := make([]string, 0, len(s.attrs))
+	for k := range s.attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rec.Attributes = append(rec.Attributes, otlpAttr{Key: k, Value: attrValue(s.attrs[k])})
+	}
+	if s.isError {
+		rec.Status = otlpStatus{Code: 2, Message: s.errMsg}
+	}
+	return rec
+}
+
+// exporter 攒够 BatchSize 或每隔 FlushSeconds 发送一批，队列满时丢弃新的 span
+type exporter struct {
+	tracer *Tracer
+	client *http.Client
+
+	mu    sync.Mutex
+	queue []otlpSpan
+
+	kick chan struct{}
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func newExporter(t *Tracer) *exporter {
+	return &exporter{
+		tracer: t,
+		client: &http.Client{Timeout: 10 * time.Second},
+		kick:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (e *exporter) add(span otlpSpan) {
+	e.mu.Lock()
+	if len(e.queue) >= e.tracer.cfg.QueueSize {
+		e.mu.Unlock()
+		e.tracer.dropped.Add(1)
+		return
+	}
+	e.queue = append(e.queue, span)
+	full := len(e.queue) >= e.tracer.cfg.BatchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(time.Duration(e.tracer.cfg.FlushSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.kick:
+		case <-e.done:
+			e.flush()
+			return
+		}
+		e.flush()
+	}
+}
+
+func (e *exporter) close() {
+	e.once.Do(func() { close(e.done) })
+	e.wg.Wait()
+}
+
+// flush 按批发送队列中的全部 span
+func (e *exporter) flush() {
+	for {
+		e.mu.Lock()
+		n := min(len(e.queue), e.tracer.cfg.BatchSize)
+		batch := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		e.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Println("Trace export error:", err)
+			e.tracer.failed.Add(int64(n))
+			return
+		}
+		e.tracer.exported.Add(int64(n))
+	}
+}
+
+func (e *exporter) send(spans []otlpSpan) error {
+	cfg := e.tracer.cfg
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = []otlpAttr{{Key: "service.name", Value: attrValue(cfg.ServiceName)}}
+	scope := otlpScopeSpans{Spans: spans}
+	scope.Scope.Name = "echo_demo/tracing"
+	rs.ScopeSpans = []otlpScopeSpans{scope}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------
+// 分布式追踪：W3C traceparent 传播和 OpenTelemetry 兼容的 span，以 OTLP/HTTP JSON 批量导出，
+// Jaeger、Tempo 等后端可以直接接收。没有上游上下文的请求按 SampleRatio 采样，
+// 有上游上下文时沿用其采样标志。Tracer 为 nil 时所有方法都是空操作
+// -----------------------
+
+// Config Endpoint 为空表示不启用，例如 http://localhost:4318/v1/traces
+type Config struct {
+	Endpoint     string            `json:"endpoint"`
+	Headers      map[string]string `json:"headers,omitempty"` // 导出请求附加的请求头，例如鉴权
+	ServiceName  string            `json:"serviceName"`
+	SampleRatio  float64           `json:"sampleRatio"` // 0~1
+	BatchSize    int               `json:"batchSize"`
+	FlushSeconds int               `json:"flushSeconds"`
+	QueueSize    int               `json:"queueSize"` // 待导出 span 的上限，超过时丢弃
+}
+
+// Enabled 是否配置了导出地址
+func (cfg Config) Enabled() bool {
+	return cfg.Endpoint != ""
+}
+
+// Kind span 类型，取值与 OTLP 一致
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// SpanContext 跨进程传播的追踪上下文
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Valid trace ID 和 span ID 都不为全零
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent 返回 W3C traceparent 头的值，无效时返回空串
+func (sc SpanContext) Traceparent() string {
+	if !sc.Valid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent 解析 W3C traceparent，格式不对或 ID 全零时返回 false
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// 版本 00 只有四段，更高版本允许在后面追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.Valid()
+}
+
+// Stats 导出统计
+type Stats struct {
+	Exported int64 // 成功导出的 span 数
+	Dropped  int64 // 队列满时丢弃的 span 数
+	Failed   int64 // 导出失败的 span 数
+}
+
+// Tracer 并发安全
+type Tracer struct {
+	cfg      Config
+	exporter *exporter
+
+	exported atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+// New 创建 Tracer 并启动后台导出
+func New(cfg Config) (*Tracer, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("tracing: endpoint is not configured")
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, errors.New("tracing: sampleRatio must be between 0 and 1")
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "go_ws_hub"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 256
+	}
+	if cfg.FlushSeconds <= 0 {
+		cfg.FlushSeconds = 5
+	}
+	if cfg.QueueSize < cfg.BatchSize {
+		cfg.QueueSize = cfg.BatchSize * 4
+	}
+	t := &Tracer{cfg: cfg}
+	t.exporter = newExporter(t)
+	t.exporter.wg.Add(1)
+	go t.exporter.run()
+	return t, nil
+}
+
+// Stats 返回导出统计，Tracer 为 nil 时返回零值
+func (t *Tracer) Stats() Stats {
+	if t == nil {
+		return Stats{}
+	}
+	return Stats{Exported: t.exported.Load(), Dropped: t.dropped.Load(), Failed: t.failed.Load()}
+}
+
+// Close 导出剩余的 span 后停止
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	t.exporter.close()
+}
+
+// Start 以当前时间开始一个 span
+func (t *Tracer) Start(name string, parent SpanContext, kind Kind) *Span {
+	return t.StartAt(name, parent, kind, time.Now())
+}
+
+// StartAt 以指定时间开始一个 span。parent 无效时开始新的 trace 并按 SampleRatio 采样；
+// 未采样的 span 仍然分配 ID 以便向下游传播，但不导出
+func (t *Tracer) StartAt(name string, parent SpanContext, kind Kind, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: start}
+	if parent.Valid() {
+		s.ctx.TraceID = parent.TraceID
+		s.ctx.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = t.sample(s.ctx.TraceID)
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	return s
+}
+
+// sample 按 trace ID 的低 8 字节决定是否采样，同一 trace 在各进程的结果一致
+func (t *Tracer) sample(id [16]byte) bool {
+	switch {
+	case t.cfg.SampleRatio >= 1:
+		return true
+	case t.cfg.SampleRatio <= 0:
+		return false
+	}
+	var n uint64
+	for _, b := range id[8:] {
+		n = n<<8 | uint64(b)
+	}
+	return float64(n>>11) < t.cfg.SampleRatio*(1<<53)
+}
+
+// Span 一段操作，End 之后不能再修改。Span 为 nil 时所有方法都是空操作
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   Kind
+	ctx    SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu      sync.Mutex
+	attrs   map[string]interface{}
+	errMsg  string
+	isError bool
+	ended   bool
+}
+
+// Context 返回用于向下游传播的上下文
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttr 设置属性，值可以是 string、bool、整数或浮点数，其它类型按字符串导出
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// SetError 把 span 标记为出错
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.isError = true
+	s.errMsg = msg
+}
+
+// End 结束 span，采样的 span 放入导出队列；重复调用只有第一次生效
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt 以指定时间结束 span
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+	if !s.ctx.Sampled {
+		return
+	}
+	s.tracer.exporter.add(s.record(end))
+}
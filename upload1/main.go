@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"echo_demo/sshutil"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -73,6 +77,12 @@ func (f *SftpPathLib) Size() (int64, error) {
 
 const BuffSize = 1024 * 256
 
+// OpTimeout 单次 SFTP 操作超时，IdleTimeout 传输过程中无进展的最长时间
+const (
+	OpTimeout   = 30 * time.Second
+	IdleTimeout = 60 * time.Second
+)
+
 // 定义 DTO，用于绑定表单字段
 type RemoteFileUploadDto struct {
 	File       *multipart.FileHeader `form:"file" json:"file"`
@@ -85,13 +95,45 @@ type RemoteFileUploadDto struct {
 	UploadPath string                `form:"uploadPath" json:"uploadPath"`
 	Now        int64                 `form:"now"   json:"now"`
 	Extra      string                `form:"extra" json:"extra"`
+	// Offset 本次上传的数据在分片中的起始位置，大于 0 时续传已写入的部分分片
+	Offset int64 `form:"offset" json:"offset"`
 }
 
+// Received 为服务器上该分片已写入的字节数，写入失败或 Offset 不匹配时客户端从该位置续传
 type SftpFileUploadOut struct {
 	Result    string
 	Size      int64
 	CheckSize int
 	TmpPath   string
+	Received  int64
+}
+
+// validChunkHash 分片目录以前端提供的 hash 命名，只接受 MD5 到 SHA-512 长度的十六进制摘要，避免路径穿越
+func validChunkHash(hash string) bool {
+	if len(hash) < 32 || len(hash) > 128 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// chunkPath 分片在远程服务器上的临时目录和文件，调用前需用 validChunkHash 检查 hash
+func chunkPath(hash string, index int64) (string, string) {
+	chunksPath := path.Join("/tmp", hash, "/")
+	return chunksPath, path.Join(chunksPath + "/" + hash + "-" + strconv.FormatInt(index, 10))
+}
+
+// receivedSize 分片已写入的字节数，文件不存在时为 0
+func receivedSize(ctx context.Context, sshClient *ssh.Client, lib *SftpPathLib) (int64, error) {
+	var size int64
+	err := sshutil.Do(ctx, OpTimeout, sshClient, func() (opErr error) {
+		size, opErr = lib.Size()
+		if os.IsNotExist(opErr) {
+			size, opErr = 0, nil
+		}
+		return opErr
+	})
+	return size, err
 }
 
 // 初始化客户端
@@ -104,6 +146,23 @@ func initSftpClient(conn *ssh.Client) (*sftp.Client, error) {
 	return c, nil
 }
 
+// dialSSH 按 default profile 连接上传目标主机，请求取消时中断拨号
+func dialSSH(c echo.Context) (*ssh.Client, error) {
+	addr, sshConfig, err := sshutil.ResolveRequest(c.Request(), "")
+	if err != nil {
+		return nil, err
+	}
+	return sshutil.DialContext(c.Request().Context(), "tcp", addr, sshConfig)
+}
+
+// sshError 返回 SSH 连接错误，主机密钥校验失败时附带结构化的 hostKey 信息
+func sshError(c echo.Context, status int, key string, err error) error {
+	if hk, ok := sshutil.AsHostKeyError(err); ok {
+		return c.JSON(http.StatusBadGateway, map[string]interface{}{key: "host key verification failed", "hostKey": hk})
+	}
+	return c.JSON(status, map[string]interface{}{key: "SSH Dial error: " + err.Error()})
+}
+
 // UploadChunkHandler 处理单个分片上传请求
 func UploadChunkHandler(c echo.Context) error {
 	var dto RemoteFileUploadDto
@@ -121,22 +180,33 @@ func UploadChunkHandler(c echo.Context) error {
 		})
 	}
 
-	// 配置 SSH 客户端参数
-	sshConfig := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("vUbFTsMJUY3AhpyT"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+	if dto.Offset < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "offset 不能为负数",
+		})
+	}
+
+	if !validChunkHash(dto.Hash) || dto.Index < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "hash 必须是十六进制摘要，index 不能为负数",
+		})
+	}
+
+	if err := sshutil.Authorize(c.Request(), sshutil.CapUpload, ""); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{"msg": err.Error()})
 	}
 
+	// 请求取消时关闭 SSH 连接，中断阻塞中的 SFTP 调用
+	ctx := c.Request().Context()
+
 	// 建立 SSH 连接
-	sshClient, err := ssh.Dial("tcp", "39.98.79.46:22", sshConfig)
+	sshClient, err := dialSSH(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "SSH Dial error: " + err.Error()})
+		return sshError(c, http.StatusBadRequest, "msg", err)
 	}
 	defer sshClient.Close()
+	stop := sshutil.CloseOnDone(ctx, sshClient)
+	defer stop()
 	sftpClient, err := initSftpClient(sshClient)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
@@ -146,9 +216,13 @@ func UploadChunkHandler(c echo.Context) error {
 	defer sftpClient.Close()
 
 	// 检查上传目录是否存在以及文件是否已存在
-	chunksPath := path.Join("/tmp", dto.Hash, "/")
+	chunksPath, tmpFile := chunkPath(dto.Hash, dto.Index)
 	chunksPathLib := NewSftpPathLib(chunksPath, sftpClient)
-	isExists, err := chunksPathLib.Exists()
+	var isExists bool
+	err = sshutil.Do(ctx, OpTimeout, sshClient, func() (opErr error) {
+		isExists, opErr = chunksPathLib.Exists()
+		return opErr
+	})
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
 			"msg": "sftp connection error: " + err.Error(),
@@ -156,7 +230,7 @@ func UploadChunkHandler(c echo.Context) error {
 	}
 
 	if !isExists {
-		err = chunksPathLib.MkdirAll()
+		err = sshutil.Do(ctx, OpTimeout, sshClient, chunksPathLib.MkdirAll)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]interface{}{
 				"msg": "sftp connection error: " + err.Error(),
@@ -166,31 +240,31 @@ func UploadChunkHandler(c echo.Context) error {
 
 	// 检查是否存在已无损上传的分片，如果存在，则直接返回上传成功
 	// 检查文件块是否已经完整上传
-	tmpFile := path.Join(chunksPath + "/" + dto.Hash + "-" + strconv.FormatInt(dto.Index, 10))
 	tmpPathLib := NewSftpPathLib(tmpFile, sftpClient)
-	isTmpPathExists, err := tmpPathLib.Exists()
+	sourceSize, err := receivedSize(ctx, sshClient, tmpPathLib)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
 			"msg": "sftp connection error: " + err.Error(),
 		})
 	}
-
-	if isTmpPathExists {
-		// 获取已上传块的大小
-		sourceSize, err := tmpPathLib.Size()
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{
-				"msg": "sftp connection error: " + err.Error(),
-			})
-		}
-		// 如果已上传块的大小与期望的块大小匹配，返回上传成功
-		if sourceSize == dto.SliceSize {
-			return c.JSON(http.StatusOK, map[string]interface{}{
-				"msg": "文件已上传",
-			})
-		}
-
-		err = tmpPathLib.Remove() // 删除损坏的分片
+	// 如果已上传块的大小与期望的块大小匹配，返回上传成功
+	if sourceSize == dto.SliceSize {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"msg":      "文件已上传",
+			"received": sourceSize,
+		})
+	}
+	switch {
+	case dto.Offset > 0 && dto.Offset == sourceSize && sourceSize < dto.SliceSize:
+		// 续传：从已写入的位置继续追加
+	case dto.Offset > 0:
+		// 客户端的续传位置与服务器不一致，返回已写入的字节数由客户端重新续传
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"msg":      "分片续传位置不匹配",
+			"received": sourceSize,
+		})
+	case sourceSize > 0:
+		err = sshutil.Do(ctx, OpTimeout, sshClient, tmpPathLib.Remove) // 删除损坏的分片
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]interface{}{
 				"msg": "删除损坏分片失败" + err.Error(),
@@ -198,13 +272,18 @@ func UploadChunkHandler(c echo.Context) error {
 		}
 	}
 
-	// 打开（或创建）临时文件用于上传
-	fs, err := sftpClient.OpenFile(tmpFile, os.O_CREATE|os.O_RDWR|os.O_APPEND)
+	// 打开（或创建）临时文件用于上传；以追加方式写入，续传时上面已确认 Offset 等于已写入的大小
+	var fs *sftp.File
+	err = sshutil.Do(ctx, OpTimeout, sshClient, func() (opErr error) {
+		fs, opErr = sftpClient.OpenFile(tmpFile, os.O_CREATE|os.O_RDWR|os.O_APPEND)
+		return opErr
+	})
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
 			"msg": "sftp OpenFile error: " + err.Error(),
 		})
 	}
+	defer fs.Close()
 
 	// 获取上传的分片文件，字段名为 "chunk"
 	fileHeader, err := c.FormFile("chunk")
@@ -222,10 +301,12 @@ func UploadChunkHandler(c echo.Context) error {
 	}
 	defer src.Close()
 
-	// 写入分片数据
-	if _, err = io.Copy(fs, src); err != nil {
+	// 写入分片数据，中途失败时已写入的部分保留在服务器上，返回分片的实际大小供客户端续传
+	written, err := sshutil.CopyContext(ctx, fs, src, IdleTimeout, sshClient)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"message": "写入分片数据失败：" + err.Error(),
+			"message":  "写入分片数据失败：" + err.Error(),
+			"received": dto.Offset + written,
 		})
 	}
 	fileInfo, _ := fs.Stat()
@@ -235,6 +316,56 @@ func UploadChunkHandler(c echo.Context) error {
 		Size:      dto.Size,
 		CheckSize: int(fileInfo.Size()),
 		TmpPath:   chunksPath,
+		Received:  fileInfo.Size(),
+	})
+}
+
+// ChunkStatusHandler 返回分片已写入服务器的字节数，客户端在重试前查询续传位置
+func ChunkStatusHandler(c echo.Context) error {
+	hash := c.QueryParam("hash")
+	index, err := strconv.ParseInt(c.QueryParam("index"), 10, 64)
+	if hash == "" || err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "缺少必要参数: hash和index",
+		})
+	}
+	if !validChunkHash(hash) || index < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "hash 必须是十六进制摘要，index 不能为负数",
+		})
+	}
+
+	if err := sshutil.Authorize(c.Request(), sshutil.CapUpload, ""); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{"msg": err.Error()})
+	}
+
+	ctx := c.Request().Context()
+	sshClient, err := dialSSH(c)
+	if err != nil {
+		return sshError(c, http.StatusBadRequest, "msg", err)
+	}
+	defer sshClient.Close()
+	stop := sshutil.CloseOnDone(ctx, sshClient)
+	defer stop()
+	sftpClient, err := initSftpClient(sshClient)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"msg": "sftp connection error: " + err.Error(),
+		})
+	}
+	defer sftpClient.Close()
+
+	_, tmpFile := chunkPath(hash, index)
+	received, err := receivedSize(ctx, sshClient, NewSftpPathLib(tmpFile, sftpClient))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"msg": "sftp connection error: " + err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"hash":     hash,
+		"index":    index,
+		"received": received,
 	})
 }
 
@@ -254,25 +385,27 @@ func MergeChunksHandler(c echo.Context) error {
 			"message": "参数 total 格式不正确",
 		})
 	}
+	if !validChunkHash(hash) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "hash 必须是十六进制摘要",
+		})
+	}
 
-	// SSH配置，可考虑从配置或环境变量读取敏感信息
-	sshConfig := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("vUbFTsMJUY3AhpyT"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+	if err := sshutil.Authorize(c.Request(), sshutil.CapUpload, ""); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{"msg": err.Error()})
 	}
 
+	// 请求取消时关闭 SSH 连接，中断阻塞中的 SFTP 调用
+	ctx := c.Request().Context()
+
 	// 建立SSH连接
-	sshClient, err := ssh.Dial("tcp", "39.98.79.46:22", sshConfig)
+	sshClient, err := dialSSH(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"message": "SSH Dial error: " + err.Error(),
-		})
+		return sshError(c, http.StatusInternalServerError, "message", err)
 	}
 	defer sshClient.Close()
+	stop := sshutil.CloseOnDone(ctx, sshClient)
+	defer stop()
 
 	sftpClient, err := initSftpClient(sshClient)
 	if err != nil {
@@ -286,7 +419,7 @@ func MergeChunksHandler(c echo.Context) error {
 	tmpDir := path.Join("/tmp", hash)
 	// 最终合并文件目录，例如 /upload_final/
 	finalDir := "/upload_final"
-	if err := sftpClient.MkdirAll(finalDir); err != nil {
+	if err := sshutil.Do(ctx, OpTimeout, sshClient, func() error { return sftpClient.MkdirAll(finalDir) }); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"message": "创建最终文件目录失败：" + err.Error(),
 		})
@@ -294,7 +427,11 @@ func MergeChunksHandler(c echo.Context) error {
 	finalFilename := path.Join(finalDir, hash+"_merged")
 
 	// 在远程服务器上创建最终文件（覆盖或新建）
-	finalFile, err := sftpClient.Create(finalFilename)
+	var finalFile *sftp.File
+	err = sshutil.Do(ctx, OpTimeout, sshClient, func() (opErr error) {
+		finalFile, opErr = sftpClient.Create(finalFilename)
+		return opErr
+	})
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"message": "创建最终文件失败：" + err.Error(),
@@ -305,13 +442,17 @@ func MergeChunksHandler(c echo.Context) error {
 	// 按顺序合并所有分片：分片文件命名为 "<hash>-<index>"
 	for i := 0; i < total; i++ {
 		chunkFilePath := path.Join(tmpDir, fmt.Sprintf("%s-%d", hash, i))
-		chunkFile, err := sftpClient.Open(chunkFilePath)
+		var chunkFile *sftp.File
+		err := sshutil.Do(ctx, OpTimeout, sshClient, func() (opErr error) {
+			chunkFile, opErr = sftpClient.Open(chunkFilePath)
+			return opErr
+		})
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 				"message": fmt.Sprintf("打开分片 %d 失败：%v", i, err),
 			})
 		}
-		_, err = io.Copy(finalFile, chunkFile)
+		_, err = sshutil.CopyContext(ctx, finalFile, chunkFile, IdleTimeout, sshClient)
 		chunkFile.Close()
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
@@ -330,6 +471,16 @@ func MergeChunksHandler(c echo.Context) error {
 }
 
 func main() {
+	profiles := flag.String("ssh-profiles", "ssh_profiles.json", "JSON file of SSH profiles (name -> profile), uploads go to the \"default\" profile")
+	knownHosts := flag.String("known-hosts", sshutil.DefaultKnownHostsFile, "known_hosts file, unknown hosts are trusted on first use")
+	flag.Parse()
+	lookup, err := sshutil.LoadProfiles(*profiles)
+	if err != nil {
+		log.Fatal("load ssh profiles error:", err)
+	}
+	sshutil.Lookup = lookup
+	sshutil.HostKeys.KnownHostsFile = *knownHosts
+
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -338,6 +489,7 @@ func main() {
 	fileGroup := e.Group("files")
 	{
 		fileGroup.POST("remote_upload", UploadChunkHandler)
+		fileGroup.GET("remote_upload", ChunkStatusHandler)
 		fileGroup.POST("chunks", MergeChunksHandler)
 	}
 
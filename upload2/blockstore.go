@@ -0,0 +1,436 @@
+package upload2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// -----------------------
+// 去重块存储：分片按内容的 sha256 存为块，文件是引用块的清单（manifest）。
+// 重新上传内容大部分相同的文件时，前端先查询缺少哪些块，只上传并存储新块；
+// 不再被任何清单引用的块由 GC 清理
+// -----------------------
+
+// BlockStoreConfig 块存储配置
+type BlockStoreConfig struct {
+	Dir     string        // 存储目录，为空时不启用
+	GCGrace time.Duration // 未被引用的块至少保留这么久才会被 GC，给上传中的文件留出提交清单的时间
+}
+
+// BlockRef 清单中对一个块的引用
+type BlockRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Manifest 一个文件由哪些块按顺序组成
+type Manifest struct {
+	Hash       string     `json:"hash"`
+	Name       string     `json:"name"`
+	UploadPath string     `json:"uploadPath"`
+	Blocks     []BlockRef `json:"blocks"`
+}
+
+// BlockStoreStats 块存储统计
+type BlockStoreStats struct {
+	Blocks        int64 `json:"blocks"`
+	Bytes         int64 `json:"bytes"`
+	DedupedBlocks int64 `json:"dedupedBlocks"` // 查询时已存在、无需上传的块数
+	DedupedBytes  int64 `json:"dedupedBytes"`
+	GCRemoved     int64 `json:"gcRemoved"`
+}
+
+var errInvalidBlockHash = errors.New("invalid block hash")
+
+type blockStore struct {
+	cfg BlockStoreConfig
+
+	// 写清单和 GC 互斥，避免 GC 删除刚被新清单引用的块
+	mu sync.Mutex
+
+	dedupedBlocks atomic.Int64
+	dedupedBytes  atomic.Int64
+	gcRemoved     atomic.Int64
+}
+
+var blocks = &blockStore{}
+
+// ConfigureBlockStore 设置块存储，需在注册路由前调用
+func ConfigureBlockStore(cfg BlockStoreConfig) error {
+	if cfg.Dir != "" {
+		for _, dir := range []string{"blocks", "manifests"} {
+			if err := os.MkdirAll(path.Join(cfg.Dir, dir), os.ModePerm); err != nil {
+				return err
+			}
+		}
+	}
+	blocks = &blockStore{cfg: cfg}
+	return nil
+}
+
+// BlockStoreEnabled 是否启用了块存储
+func BlockStoreEnabled() bool {
+	return blocks.cfg.Dir != ""
+}
+
+func validBlockHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// blockPath 块按 hash 前两位分目录存放
+func (bs *blockStore) blockPath(hash string) string {
+	return path.Join(bs.cfg.Dir, "blocks", hash[:2], hash)
+}
+
+func (bs *blockStore) manifestPath(fileHash string) string {
+	return path.Join(bs.cfg.Dir, "manifests", fileHash+".json")
+}
+
+func (bs *blockStore) hasBlock(hash string) bool {
+	_, err := os.Stat(bs.blockPath(hash))
+	return err == nil
+}
+
+// missing 返回尚未存储的块，已存在的块计入去重统计
+func (bs *blockStore) missing(refs []BlockRef) ([]string, error) {
+	missing := []string{}
+	for _, ref := range refs {
+		if !validBlockHash(ref.Hash) {
+			return nil, errInvalidBlockHash
+		}
+		// 已存在的块刷新修改时间，避免在提交清单前被 GC
+		now := time.Now()
+		if err := os.Chtimes(bs.blockPath(ref.Hash), now, now); err == nil {
+			bs.dedupedBlocks.Add(1)
+			bs.dedupedBytes.Add(ref.Size)
+			continue
+		}
+		missing = append(missing, ref.Hash)
+	}
+	return missing, nil
+}
+
+// putBlock 写入一个块，内容的 sha256 必须与 hash 一致；块已存在时直接返回
+func (bs *blockStore) putBlock(hash string, r io.Reader) error {
+	if !validBlockHash(hash) {
+		return errInvalidBlockHash
+	}
+	dst := bs.blockPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		// 刷新修改时间，避免被 GC 当作长期未引用的块
+		now := time.Now()
+		return os.Chtimes(dst, now, now)
+	}
+	if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(path.Dir(dst), hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		os.Remove(tmp.Name())
+		return errors.New("block content does not match hash")
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// commit 保存清单并按块顺序拼出最终文件，progress 报告已写入的字节数，可以为 nil
+func (bs *blockStore) commit(ctx context.Context, m Manifest, progress func(done, total int64)) (string, error) {
+	if m.Hash == "" || strings.ContainsAny(m.Hash, `/\`) {
+		return "", errors.New("invalid file hash")
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for _, ref := range m.Blocks {
+		if !validBlockHash(ref.Hash) {
+			return "", errInvalidBlockHash
+		}
+		if !bs.hasBlock(ref.Hash) {
+			return "", errors.New("missing block " + ref.Hash)
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(bs.manifestPath(m.Hash), data, 0644); err != nil {
+		return "", err
+	}
+
+	finalFile := path.Join(m.UploadPath, m.Name)
+	out, err := os.Create(finalFile)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	pw := newProgressWriter(ctx, out, m.size(), progress)
+	for _, ref := range m.Blocks {
+		in, err := os.Open(bs.blockPath(ref.Hash))
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(pw, in)
+		in.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return finalFile, nil
+}
+
+// removeManifest 删除清单，其引用的块在下次 GC 时按需清理
+func (bs *blockStore) removeManifest(fileHash string) error {
+	if fileHash == "" || strings.ContainsAny(fileHash, `/\`) {
+		return errors.New("invalid file hash")
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return os.Remove(bs.manifestPath(fileHash))
+}
+
+// gc 删除不被任何清单引用、且超过 GCGrace 未修改的块，返回删除的块数和字节数
+func (bs *blockStore) gc() (int, int64, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	referenced := make(map[string]bool)
+	manifests, err := os.ReadDir(path.Join(bs.cfg.Dir, "manifests"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range manifests {
+		data, err := os.ReadFile(path.Join(bs.cfg.Dir, "manifests", entry.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("skip broken manifest %s: %v", entry.Name(), err)
+			continue
+		}
+		for _, ref := range m.Blocks {
+			referenced[ref.Hash] = true
+		}
+	}
+
+	removed := 0
+	var removedBytes int64
+	cutoff := time.Now().Add(-bs.cfg.GCGrace)
+	err = bs.walkBlocks(func(name string, info os.FileInfo) {
+		if referenced[name] || info.ModTime().After(cutoff) {
+			return
+		}
+		if err := os.Remove(path.Join(bs.cfg.Dir, "blocks", name[:2], name)); err != nil {
+			log.Printf("remove block %s failed: %v", name, err)
+			return
+		}
+		removed++
+		removedBytes += info.Size()
+	})
+	bs.gcRemoved.Add(int64(removed))
+	return removed, removedBytes, err
+}
+
+// walkBlocks 遍历全部块文件，跳过写入中的临时文件
+func (bs *blockStore) walkBlocks(fn func(name string, info os.FileInfo)) error {
+	root := path.Join(bs.cfg.Dir, "blocks")
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(path.Join(root, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !validBlockHash(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			fn(entry.Name(), info)
+		}
+	}
+	return nil
+}
+
+// BlockStats 返回块存储统计
+func BlockStats() BlockStoreStats {
+	st := BlockStoreStats{
+		DedupedBlocks: blocks.dedupedBlocks.Load(),
+		DedupedBytes:  blocks.dedupedBytes.Load(),
+		GCRemoved:     blocks.gcRemoved.Load(),
+	}
+	if !BlockStoreEnabled() {
+		return st
+	}
+	_ = blocks.walkBlocks(func(name string, info os.FileInfo) {
+		st.Blocks++
+		st.Bytes += info.Size()
+	})
+	return st
+}
+
+// CheckBlocksHandler 前端提交文件的块列表，返回需要上传的块，请求体 {"blocks":[{"hash":"...","size":1}]}
+func CheckBlocksHandler(c echo.Context) error {
+	var req struct {
+		Blocks []BlockRef `json:"blocks"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "参数绑定错误: " + err.Error(),
+		})
+	}
+	missing, err := blocks.missing(req.Blocks)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"missing": missing,
+	})
+}
+
+// UploadBlockHandler 上传一个块，表单字段 hash 为块内容的 sha256，file 为块内容
+func UploadBlockHandler(c echo.Context) error {
+	hash := c.FormValue("hash")
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "缺少文件字段 file",
+		})
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"message": "打开上传块失败: " + err.Error(),
+		})
+	}
+	defer src.Close()
+
+	if err := blocks.putBlock(hash, src); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "保存块失败: " + err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"msg":  "块上传成功",
+		"hash": hash,
+	})
+}
+
+// size 文件大小，即全部块的大小之和
+func (m Manifest) size() int64 {
+	var size int64
+	for _, b := range m.Blocks {
+		size += b.Size
+	}
+	return size
+}
+
+// CommitManifest 保存清单并生成最终文件，progress 报告已写入的字节数，可以为 nil
+func CommitManifest(ctx context.Context, m Manifest, progress func(done, total int64)) (CompletedUpload, error) {
+	if !BlockStoreEnabled() {
+		return CompletedUpload{}, errors.New("block store is not enabled")
+	}
+	var finalFile string
+	err := merges.run(ctx, func() error {
+		var err error
+		finalFile, err = blocks.commit(ctx, m, progress)
+		return err
+	})
+	if err != nil {
+		return CompletedUpload{}, errors.New("文件合并失败: " + err.Error())
+	}
+	u := CompletedUpload{Name: m.Name, Path: finalFile, Size: m.size(), Hash: m.Hash, Method: "blocks"}
+	uploadCompleted(u)
+	return u, nil
+}
+
+// CommitManifestHandler 提交文件清单，全部块都已存在时生成最终文件
+func CommitManifestHandler(c echo.Context) error {
+	var m Manifest
+	if err := c.Bind(&m); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "参数绑定错误: " + err.Error(),
+		})
+	}
+	u, err := CommitManifest(c.Request().Context(), m, nil)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":   "文件合并成功",
+		"finalFile": u.Path,
+	})
+}
+
+// DeleteManifestHandler 删除文件清单，释放对块的引用
+func DeleteManifestHandler(c echo.Context) error {
+	if err := blocks.removeManifest(c.Param("hash")); err != nil {
+		status := http.StatusBadRequest
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, map[string]interface{}{
+			"message": "删除清单失败: " + err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "清单已删除",
+	})
+}
+
+// BlockGCHandler 清理未被引用的块
+func BlockGCHandler(c echo.Context) error {
+	removed, removedBytes, err := blocks.gc()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"message": "块清理失败: " + err.Error(),
+		})
+	}
+	log.Printf("block gc removed %d blocks, %d bytes", removed, removedBytes)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"removed":      removed,
+		"removedBytes": removedBytes,
+	})
+}
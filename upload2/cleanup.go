@@ -0,0 +1,201 @@
+package upload2
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------
+// 启动清理：进程崩溃或重启后，暂存目录中可能留下没有合并的分片目录和中断的合并标记，
+// 目标目录中可能留下合并了一半的输出文件，块存储中可能留下写了一半的临时块。
+// 分片目录按文件名格式 {hash}-{index} 识别，目录中的其它内容不受影响；最近仍有写入的目录保留，
+// tmpfs 中保留的目录重新计入内存预算。分片目录在合并成功后才删除，中断的合并可以重新发起
+// -----------------------
+
+// 清理结果中的 Action
+const (
+	OrphanRemoved     = "removed"
+	OrphanWouldRemove = "would_remove" // DryRun 时本应删除
+	OrphanKept        = "kept"
+	OrphanReconciled  = "reconciled" // tmpfs 中的分片目录重新计入内存预算
+	OrphanFailed      = "failed"
+)
+
+// Orphan 清理发现的一项遗留文件，Kind 为 chunks、merge_lock、merge_output 或 block_tmp
+type Orphan struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// CleanupPolicy ChunkMaxAge 内有写入的分片目录视为仍在上传；Keep 返回 true 的 hash
+// 仍被引用（比如排队中的合并任务），不删除
+type CleanupPolicy struct {
+	ChunkMaxAge time.Duration
+	Keep        func(hash string) bool
+	DryRun      bool
+}
+
+// CleanupOrphans 检查暂存目录和块存储，应在 ConfigureStaging、ConfigureBlockStore 之后、接受上传之前调用
+func CleanupOrphans(p CleanupPolicy) ([]Orphan, error) {
+	var orphans []Orphan
+	dirs := []string{staging.cfg.DiskDir}
+	if staging.cfg.MemoryDir != "" {
+		dirs = append(dirs, staging.cfg.MemoryDir)
+	}
+	for _, dir := range dirs {
+		found, err := staging.cleanupDir(dir, p)
+		if err != nil && !os.IsNotExist(err) {
+			return orphans, err
+		}
+		orphans = append(orphans, found...)
+	}
+	orphans = append(orphans, staging.cleanupMerges(p)...)
+	if BlockStoreEnabled() {
+		orphans = append(orphans, blocks.cleanupTemp(p)...)
+	}
+	return orphans, nil
+}
+
+// chunkDirInfo 目录中的文件都是 {hash}-{index} 格式的分片时返回总大小和最后修改时间
+func chunkDirInfo(dir, hash string) (int64, time.Time, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return 0, time.Time{}, false
+	}
+	var size int64
+	var latest time.Time
+	for _, entry := range entries {
+		index, ok := strings.CutPrefix(entry.Name(), hash+"-")
+		if !ok || !entry.Type().IsRegular() {
+			return 0, time.Time{}, false
+		}
+		if _, err := strconv.ParseInt(index, 10, 64); err != nil {
+			return 0, time.Time{}, false
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		size += info.Size()
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return size, latest, true
+}
+
+func (st *chunkStaging) cleanupDir(dir string, p CleanupPolicy) ([]Orphan, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	inMemory := st.cfg.MemoryDir != "" && path.Clean(dir) == path.Clean(st.cfg.MemoryDir)
+	var orphans []Orphan
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		hash := entry.Name()
+		if !validChunkHash(hash) {
+			continue
+		}
+		chunksDir := path.Join(dir, hash)
+		size, latest, ok := chunkDirInfo(chunksDir, hash)
+		if !ok {
+			continue
+		}
+		o := Orphan{Kind: "chunks", Path: chunksDir, Bytes: size}
+		switch {
+		case p.Keep != nil && p.Keep(hash):
+			o.Action, o.Reason = OrphanKept, "referenced"
+		case time.Since(latest) < p.ChunkMaxAge:
+			o.Action, o.Reason = OrphanKept, "recently written"
+		case p.DryRun:
+			o.Action = OrphanWouldRemove
+		default:
+			o.Action = OrphanRemoved
+			if err := os.RemoveAll(chunksDir); err != nil {
+				o.Action, o.Reason = OrphanFailed, err.Error()
+			}
+		}
+		if o.Action == OrphanKept && inMemory && !p.DryRun {
+			st.mu.Lock()
+			if _, ok := st.memoryBytes[hash]; !ok {
+				st.memoryBytes[hash] = size
+				st.memoryTotal.Add(size)
+				o.Action = OrphanReconciled
+			}
+			st.mu.Unlock()
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// cleanupMerges 处理中断的合并：删除标记指向的输出文件和标记本身。启动时没有进行中的合并，不按 ChunkMaxAge 保留
+func (st *chunkStaging) cleanupMerges(p CleanupPolicy) []Orphan {
+	markers, _ := filepath.Glob(path.Join(st.cfg.DiskDir, "*"+mergingSuffix))
+	var orphans []Orphan
+	for _, marker := range markers {
+		info, err := os.Stat(marker)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// 只删除以 mergingSuffix 结尾的输出文件，标记内容异常时不碰其它文件
+		if data, err := os.ReadFile(marker); err == nil && strings.HasSuffix(string(data), mergingSuffix) {
+			if out, err := os.Stat(string(data)); err == nil && out.Mode().IsRegular() {
+				orphans = append(orphans, removeOrphan(Orphan{Kind: "merge_output", Path: string(data), Bytes: out.Size()}, p))
+			}
+		}
+		orphans = append(orphans, removeOrphan(Orphan{Kind: "merge_lock", Path: marker, Bytes: info.Size()}, p))
+	}
+	return orphans
+}
+
+// removeOrphan 删除单个文件，DryRun 时只记录
+func removeOrphan(o Orphan, p CleanupPolicy) Orphan {
+	o.Action = OrphanRemoved
+	if p.DryRun {
+		o.Action = OrphanWouldRemove
+	} else if err := os.Remove(o.Path); err != nil {
+		o.Action, o.Reason = OrphanFailed, err.Error()
+	}
+	return o
+}
+
+// cleanupTemp 删除写入中断的临时块，putBlock 的临时文件名为 {hash}.*.tmp；ChunkMaxAge 内有写入的同样保留
+func (bs *blockStore) cleanupTemp(p CleanupPolicy) []Orphan {
+	var orphans []Orphan
+	root := path.Join(bs.cfg.Dir, "blocks")
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(path.Join(root, dir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".tmp") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) < p.ChunkMaxAge {
+				continue
+			}
+			orphans = append(orphans, removeOrphan(Orphan{Kind: "block_tmp", Path: path.Join(root, dir.Name(), entry.Name()), Bytes: info.Size()}, p))
+		}
+	}
+	return orphans
+}
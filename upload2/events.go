@@ -0,0 +1,28 @@
+package upload2
+
+import "time"
+
+// -----------------------
+// 上传完成事件：文件合并成功后调用 OnComplete，供通知等子系统使用
+// -----------------------
+
+// CompletedUpload 一次完成的上传
+type CompletedUpload struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"` // 最终文件路径
+	Size       int64     `json:"size"`
+	Hash       string    `json:"hash"`
+	Method     string    `json:"method"` // chunks：分片合并，blocks：块清单提交
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// OnComplete 为 nil 时不通知，回调在请求的 goroutine 中执行，耗时的处理应自行启动 goroutine
+var OnComplete func(CompletedUpload)
+
+func uploadCompleted(u CompletedUpload) {
+	if OnComplete == nil {
+		return
+	}
+	u.FinishedAt = time.Now()
+	OnComplete(u)
+}
@@ -0,0 +1,247 @@
+package upload2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// -----------------------
+// 合并限流：同时进行的合并数超过 MaxConcurrent 时排队等待；每个合并的写入速率不超过 BytesPerSecond。
+// 配置了 Nice 或 IOClass 时，合并在单独锁定的系统线程中执行并降低该线程的 CPU 和 I/O 优先级，
+// 合并结束后丢弃该线程，不影响处理交互流量的其它线程。
+// 同一 hash 的合并正在进行时，再次调用 MergeChunks 不会重复合并，而是等待并返回同一个结果，
+// 等待期间同样收到合并进度；合并不随发起方的请求结束而取消，所有调用方都离开后才取消。
+// 目标路径与进行中的合并不同时返回 ErrMergeConflict
+// -----------------------
+
+// MergeConfig 合并的并发和优先级，零值表示不限制
+type MergeConfig struct {
+	MaxConcurrent  int    // 同时进行的合并数
+	BytesPerSecond int64  // 每个合并的写入速率上限
+	Nice           int    // 合并线程的 nice 值，1-19
+	IOClass        string // I/O 调度类：best-effort 或 idle，为空时不调整
+	IOPriority     int    // best-effort 的优先级，0（最高）- 7
+}
+
+// MergeStats 合并统计
+type MergeStats struct {
+	Running int64 `json:"running"`
+	Waiting int64 `json:"waiting"`
+}
+
+const (
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// ioprio_set 的参数，见 linux/ioprio.h
+const (
+	ioprioWhoProcess    = 1
+	ioprioClassShift    = 13
+	ioprioClassBE       = 2
+	ioprioClassIdle     = 3
+	ioprioMaxBEPriority = 7
+)
+
+// ErrMergeConflict 同一 hash 的合并正在写入另一个目标路径
+var ErrMergeConflict = errors.New("a merge of the same file to another destination is in progress")
+
+// mergeCall 进行中的一次合并，done 关闭后 u 和 err 可读
+type mergeCall struct {
+	done   chan struct{}
+	dest   string
+	cancel context.CancelFunc
+	u      CompletedUpload
+	err    error
+
+	mu       sync.Mutex
+	nextID   int
+	progress map[int]func(done, total int64) // 调用方 ID -> 进度回调，可以为 nil
+}
+
+var (
+	mergeCallsMu sync.Mutex
+	mergeCalls   = map[string]*mergeCall{}
+)
+
+// join 登记一个等待的调用方，返回其 ID
+func (call *mergeCall) join(progress func(done, total int64)) int {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	call.nextID++
+	call.progress[call.nextID] = progress
+	return call.nextID
+}
+
+// leave 调用方不再等待，最后一个调用方离开时取消合并
+func (call *mergeCall) leave(id int) {
+	call.mu.Lock()
+	delete(call.progress, id)
+	last := len(call.progress) == 0
+	call.mu.Unlock()
+	if last {
+		call.cancel()
+	}
+}
+
+// report 把进度发给所有等待的调用方
+func (call *mergeCall) report(done, total int64) {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	for _, fn := range call.progress {
+		if fn != nil {
+			fn(done, total)
+		}
+	}
+}
+
+// coalesceMerge 同一 hash 只执行一次 fn，其余调用方等待其结果并收到同样的进度；
+// fn 使用独立的 ctx，任一调用方的 ctx 结束时只有该调用方返回，全部返回后才取消 fn
+func coalesceMerge(ctx context.Context, hash, dest string, progress func(done, total int64),
+	fn func(ctx context.Context, progress func(done, total int64)) (CompletedUpload, error)) (CompletedUpload, error) {
+	mergeCallsMu.Lock()
+	call, ok := mergeCalls[hash]
+	if ok && call.dest != dest {
+		mergeCallsMu.Unlock()
+		return CompletedUpload{}, ErrMergeConflict
+	}
+	if !ok {
+		mergeCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &mergeCall{done: make(chan struct{}), dest: dest, cancel: cancel, progress: map[int]func(done, total int64){}}
+		mergeCalls[hash] = call
+		go func() {
+			u, err := fn(mergeCtx, call.report)
+			mergeCallsMu.Lock()
+			delete(mergeCalls, hash)
+			mergeCallsMu.Unlock()
+			call.u, call.err = u, err
+			close(call.done)
+			cancel()
+		}()
+	}
+	id := call.join(progress)
+	mergeCallsMu.Unlock()
+	defer call.leave(id)
+
+	select {
+	case <-call.done:
+		return call.u, call.err
+	case <-ctx.Done():
+		return CompletedUpload{}, ctx.Err()
+	}
+}
+
+type mergeLimiter struct {
+	cfg   MergeConfig
+	slots chan struct{} // 为 nil 时不限制并发
+
+	running atomic.Int64
+	waiting atomic.Int64
+}
+
+var merges = &mergeLimiter{}
+
+// ConfigureMerge 设置合并限流，需在注册路由前调用
+func ConfigureMerge(cfg MergeConfig) error {
+	if cfg.Nice < 0 || cfg.Nice > 19 {
+		return errors.New("merge nice must be between 0 and 19")
+	}
+	switch cfg.IOClass {
+	case "", IOClassIdle:
+	case IOClassBestEffort:
+		if cfg.IOPriority < 0 || cfg.IOPriority > ioprioMaxBEPriority {
+			return errors.New("merge io priority must be between 0 and 7")
+		}
+	default:
+		return fmt.Errorf("unknown merge io class %q", cfg.IOClass)
+	}
+	m := &mergeLimiter{cfg: cfg}
+	if cfg.MaxConcurrent > 0 {
+		m.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	merges = m
+	return nil
+}
+
+// MergeStatsNow 返回合并统计
+func MergeStatsNow() MergeStats {
+	return MergeStats{Running: merges.running.Load(), Waiting: merges.waiting.Load()}
+}
+
+// run 等待空闲的合并名额后执行 fn，ctx 结束时放弃等待
+func (m *mergeLimiter) run(ctx context.Context, fn func() error) error {
+	if m.slots != nil {
+		m.waiting.Add(1)
+		select {
+		case m.slots <- struct{}{}:
+			m.waiting.Add(-1)
+		case <-ctx.Done():
+			m.waiting.Add(-1)
+			return ctx.Err()
+		}
+		defer func() { <-m.slots }()
+	}
+	m.running.Add(1)
+	defer m.running.Add(-1)
+	if m.cfg.Nice == 0 && m.cfg.IOClass == "" {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		// 不调用 UnlockOSThread：goroutine 退出时线程随之结束，调整过的优先级不会被其它 goroutine 继承
+		runtime.LockOSThread()
+		m.lowerPriority()
+		done <- fn()
+	}()
+	return <-done
+}
+
+// lowerPriority 降低当前线程的优先级，失败时只记录日志
+func (m *mergeLimiter) lowerPriority() {
+	tid := unix.Gettid()
+	if m.cfg.Nice > 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, m.cfg.Nice); err != nil {
+			log.Println("merge setpriority error:", err)
+		}
+	}
+	var prio uintptr
+	switch m.cfg.IOClass {
+	case IOClassBestEffort:
+		prio = ioprioClassBE<<ioprioClassShift | uintptr(m.cfg.IOPriority)
+	case IOClassIdle:
+		prio = ioprioClassIdle << ioprioClassShift
+	default:
+		return
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio); errno != 0 {
+		log.Println("merge ioprio_set error:", errno)
+	}
+}
+
+// throttle 写入 done 字节后，按 BytesPerSecond 应已用去的时间比实际多时等待
+func (m *mergeLimiter) throttle(ctx context.Context, start time.Time, done int64) error {
+	limit := m.cfg.BytesPerSecond
+	if limit <= 0 {
+		return nil
+	}
+	ahead := time.Duration(float64(done)/float64(limit)*float64(time.Second)) - time.Since(start)
+	if ahead <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(ahead)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
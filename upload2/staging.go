@@ -0,0 +1,219 @@
+package upload2
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+// -----------------------
+// 分片暂存：小文件的分片放在 tmpfs（内存）目录，超过阈值时转存到磁盘目录
+// -----------------------
+
+// StagingConfig 暂存配置
+type StagingConfig struct {
+	MemoryDir      string // tmpfs 目录，例如 /dev/shm/upload，为空时全部写磁盘
+	DiskDir        string // 磁盘目录
+	SmallFileLimit int64  // 文件总大小不超过该值时使用 tmpfs
+	MemoryBudget   int64  // tmpfs 中暂存分片的总字节上限，超过后转存到磁盘
+}
+
+// StagingStats 暂存统计
+type StagingStats struct {
+	MemoryBytes  int64 // 当前 tmpfs 中暂存的字节数
+	MemoryChunks int64 // 写入 tmpfs 的分片数
+	DiskChunks   int64 // 写入磁盘的分片数
+	Spills       int64 // 从 tmpfs 转存到磁盘的次数
+}
+
+type chunkStaging struct {
+	cfg StagingConfig
+
+	mu          sync.Mutex
+	memoryBytes map[string]int64 // hash -> tmpfs 中该文件的字节数
+
+	memoryTotal  atomic.Int64
+	memoryChunks atomic.Int64
+	diskChunks   atomic.Int64
+	spills       atomic.Int64
+}
+
+var staging = newChunkStaging(StagingConfig{DiskDir: "/tmp"})
+
+func newChunkStaging(cfg StagingConfig) *chunkStaging {
+	return &chunkStaging{
+		cfg:         cfg,
+		memoryBytes: make(map[string]int64),
+	}
+}
+
+// ConfigureStaging 设置分片暂存方式，需在注册路由前调用
+func ConfigureStaging(cfg StagingConfig) {
+	if cfg.DiskDir == "" {
+		cfg.DiskDir = "/tmp"
+	}
+	staging = newChunkStaging(cfg)
+}
+
+// Stats 返回暂存统计
+func Stats() StagingStats {
+	return StagingStats{
+		MemoryBytes:  staging.memoryTotal.Load(),
+		MemoryChunks: staging.memoryChunks.Load(),
+		DiskChunks:   staging.diskChunks.Load(),
+		Spills:       staging.spills.Load(),
+	}
+}
+
+// validChunkHash 分片目录以前端提供的 hash 命名，只接受 MD5 到 SHA-512 长度的十六进制摘要，避免路径穿越
+func validChunkHash(hash string) bool {
+	if len(hash) < 32 || len(hash) > 128 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// chunksDir 返回 hash 对应的分片目录：已在 tmpfs 中的继续使用 tmpfs，否则使用磁盘目录
+func (st *chunkStaging) chunksDir(hash string) string {
+	st.mu.Lock()
+	_, inMemory := st.memoryBytes[hash]
+	st.mu.Unlock()
+	if inMemory {
+		return path.Join(st.cfg.MemoryDir, hash)
+	}
+	return path.Join(st.cfg.DiskDir, hash)
+}
+
+// mergingSuffix 合并中的输出文件和合并标记的后缀
+const mergingSuffix = ".merging"
+
+// mergeMarker 合并期间的标记文件，放在磁盘暂存目录，内容为合并中的输出文件路径
+func (st *chunkStaging) mergeMarker(hash string) string {
+	return path.Join(st.cfg.DiskDir, hash+mergingSuffix)
+}
+
+// dirForChunk 为即将写入的分片选择目录，必要时将该文件已有的 tmpfs 分片转存到磁盘。
+// 使用 tmpfs 时按 chunkSize 预留预算，返回预留的字节数，写入后需调用 settle 按实际写入的字节数结算
+func (st *chunkStaging) dirForChunk(hash string, fileSize, chunkSize int64) (string, int64) {
+	diskDir := path.Join(st.cfg.DiskDir, hash)
+	if st.cfg.MemoryDir == "" || fileSize > st.cfg.SmallFileLimit {
+		return diskDir, 0
+	}
+
+	st.mu.Lock()
+	used, inMemory := st.memoryBytes[hash]
+	if !inMemory {
+		// 磁盘上已有该文件的分片时不再切回 tmpfs
+		if _, err := os.Stat(diskDir); err == nil {
+			st.mu.Unlock()
+			return diskDir, 0
+		}
+	}
+	if st.memoryTotal.Load()+chunkSize <= st.cfg.MemoryBudget {
+		st.memoryBytes[hash] = used + chunkSize
+		st.memoryTotal.Add(chunkSize)
+		st.mu.Unlock()
+		return path.Join(st.cfg.MemoryDir, hash), chunkSize
+	}
+	if inMemory {
+		delete(st.memoryBytes, hash)
+		st.memoryTotal.Add(-used)
+	}
+	st.mu.Unlock()
+
+	if inMemory {
+		if err := spillDir(path.Join(st.cfg.MemoryDir, hash), diskDir); err != nil {
+			log.Printf("spill chunks of %s to disk failed: %v", hash, err)
+		} else {
+			st.spills.Add(1)
+		}
+	}
+	return diskDir, 0
+}
+
+// settle 把 dirForChunk 预留的 reserved 字节调整为实际写入 tmpfs 的 actual 字节，
+// 分片没有写入（已上传、出错）时 actual 为 0；删除 tmpfs 中已有的分片时 reserved 为 0、actual 为负的文件大小
+func (st *chunkStaging) settle(hash string, reserved, actual int64) {
+	delta := actual - reserved
+	if delta == 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	// 已经转存或释放的文件不再计入 tmpfs
+	used, ok := st.memoryBytes[hash]
+	if !ok {
+		return
+	}
+	if used+delta < 0 {
+		delta = -used
+	}
+	st.memoryBytes[hash] = used + delta
+	st.memoryTotal.Add(delta)
+}
+
+// inMemory 判断分片目录是否在 tmpfs 中
+func (st *chunkStaging) inMemory(dir string) bool {
+	return st.cfg.MemoryDir != "" && path.Dir(dir) == path.Clean(st.cfg.MemoryDir)
+}
+
+// recordChunk 统计分片写入位置
+func (st *chunkStaging) recordChunk(dir string) {
+	if st.inMemory(dir) {
+		st.memoryChunks.Add(1)
+	} else {
+		st.diskChunks.Add(1)
+	}
+}
+
+// release 文件合并或清理后释放其 tmpfs 占用
+func (st *chunkStaging) release(hash string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if used, ok := st.memoryBytes[hash]; ok {
+		st.memoryTotal.Add(-used)
+		delete(st.memoryBytes, hash)
+	}
+}
+
+// spillDir 将 tmpfs 目录中的分片复制到磁盘目录后删除原目录（跨文件系统无法直接 rename）
+func spillDir(src, dst string) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyFile(path.Join(src, entry.Name()), path.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,6 +1,9 @@
 package upload2
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"io"
 	"mime/multipart"
@@ -9,6 +12,7 @@ import (
 	"path"
 	"sort"
 	"strconv"
+	"time"
 )
 
 // 定义 DTO，用于绑定表单字段
@@ -58,8 +62,24 @@ func UploadChunkHandler(c echo.Context) error {
 		})
 	}
 
-	// 设定存储分片的临时目录，使用文件hash来标识
-	chunksDir := path.Join("/tmp", dto.Hash)
+	// tmpfs 预算按声明的大小预留，大小必须为正
+	if dto.Size <= 0 || dto.Total <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "size 和 total 必须大于 0",
+		})
+	}
+
+	if !validChunkHash(dto.Hash) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "hash 必须是十六进制摘要",
+		})
+	}
+
+	// 设定存储分片的临时目录，使用文件hash来标识；小文件优先暂存在 tmpfs 中。
+	// 返回前按实际写入的字节数结算预留的 tmpfs 预算
+	chunksDir, reserved := staging.dirForChunk(dto.Hash, dto.Total, dto.Size)
+	var written int64
+	defer func() { staging.settle(dto.Hash, reserved, written) }()
 	if _, err := os.Stat(chunksDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(chunksDir, os.ModePerm); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
@@ -68,7 +88,7 @@ func UploadChunkHandler(c echo.Context) error {
 		}
 	}
 
-	// 构造当前分片的临时文件名，格式为: /tmp/{hash}/{hash}-{index}
+	// 构造当前分片的临时文件名，格式为: {chunksDir}/{hash}-{index}
 	tmpFile := path.Join(chunksDir, dto.Hash+"-"+strconv.FormatInt(dto.Index, 10))
 
 	// 检查文件块是否已经完整上传
@@ -85,6 +105,9 @@ func UploadChunkHandler(c echo.Context) error {
 				"msg": "删除损坏的分片失败: " + err.Error(),
 			})
 		}
+		if staging.inMemory(chunksDir) {
+			staging.settle(dto.Hash, 0, -info.Size())
+		}
 	}
 	// 打开或创建临时文件，用于追加写入分片数据
 	fs, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
@@ -112,12 +135,19 @@ func UploadChunkHandler(c echo.Context) error {
 	defer src.Close()
 
 	// 将上传的分片数据写入临时文件
-	if _, err = io.Copy(fs, src); err != nil {
+	n, err := io.Copy(fs, src)
+	if err != nil {
+		// 不完整的分片删除后由客户端重传
+		os.Remove(tmpFile)
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"message": "写入分片数据失败: " + err.Error(),
 		})
 	}
 
+	if reserved > 0 {
+		written = n
+	}
+
 	// 检查当前临时文件大小
 	fi, err := fs.Stat()
 	if err != nil {
@@ -126,6 +156,7 @@ func UploadChunkHandler(c echo.Context) error {
 		})
 	}
 	currentSize := fi.Size()
+	staging.recordChunk(chunksDir)
 
 	// 如果累计写入的大小与整个文件总大小相同，认为所有分片已上传完毕
 	if currentSize != dto.SliceSize {
@@ -145,14 +176,24 @@ func UploadChunkHandler(c echo.Context) error {
 }
 
 // mergeChunks 将 chunksDir 目录下所有分片合并成 finalFile
-// 假设每个分片文件名格式为 "{hash}-{index}"
-func mergeChunks(chunksDir, hash, finalFile string) error {
-	// 创建或覆盖最终文件
-	out, err := os.Create(finalFile)
+// 假设每个分片文件名格式为 "{hash}-{index}"；w 为 nil 时直接写入文件。
+// 先写入 finalFile + mergingSuffix，完成后再重命名，合并失败时删除写了一半的文件
+func mergeChunks(chunksDir, hash, finalFile string, w func(io.Writer) io.Writer) (err error) {
+	tmpFile := finalFile + mergingSuffix
+	out, err := os.Create(tmpFile)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(tmpFile)
+		}
+	}()
+	var dst io.Writer = out
+	if w != nil {
+		dst = w(out)
+	}
 
 	// 读取目录下所有文件
 	entries, err := os.ReadDir(chunksDir)
@@ -196,31 +237,44 @@ func mergeChunks(chunksDir, hash, finalFile string) error {
 		if err != nil {
 			return err
 		}
-		_, err = io.Copy(out, in)
+		_, err = io.Copy(dst, in)
 		in.Close()
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, finalFile)
 }
 
-// MergeChunksHandler 用于将分片合并成完整文件，清理临时目录
-func MergeChunksHandler(c echo.Context) error {
-	var dto MergeChunksDto
-	if err := c.Bind(&dto); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"message": "参数绑定错误: " + err.Error(),
-		})
+// MergeInputError 合并参数错误或分片不齐全，重试也不会成功
+type MergeInputError struct {
+	msg string
+}
+
+func (e *MergeInputError) Error() string { return e.msg }
+
+// MergeChunks 检查分片是否齐全，合并成最终文件并清理临时目录；progress 报告已写入的字节数，可以为 nil
+func MergeChunks(ctx context.Context, dto MergeChunksDto, progress func(done, total int64)) (CompletedUpload, error) {
+	if dto.Hash == "" || dto.SliceSize <= 0 || dto.Name == "" || dto.UploadPath == "" {
+		return CompletedUpload{}, &MergeInputError{"hash, sliceSize, name and uploadPath are required"}
+	}
+	if !validChunkHash(dto.Hash) {
+		return CompletedUpload{}, &MergeInputError{"hash must be a hex digest"}
 	}
+	return coalesceMerge(ctx, dto.Hash, path.Clean(path.Join(dto.UploadPath, dto.Name)), progress, func(ctx context.Context, progress func(done, total int64)) (CompletedUpload, error) {
+		return mergeChunksOnce(ctx, dto, progress)
+	})
+}
 
-	// 构造临时分片目录，假设为 /tmp/{hash}
-	chunksDir := path.Join("/tmp", dto.Hash)
+func mergeChunksOnce(ctx context.Context, dto MergeChunksDto, progress func(done, total int64)) (CompletedUpload, error) {
+	// 构造临时分片目录，分片可能暂存在 tmpfs 或磁盘中
+	chunksDir := staging.chunksDir(dto.Hash)
 	info, err := os.Stat(chunksDir)
 	if err != nil || !info.IsDir() {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"message": "分片临时目录不存在",
-		})
+		return CompletedUpload{}, &MergeInputError{"分片临时目录不存在"}
 	}
 
 	// 计算预期的分片数（考虑最后一个分片可能比标准分片小）
@@ -232,36 +286,105 @@ func MergeChunksHandler(c echo.Context) error {
 	// 读取临时目录下分片数量
 	entries, err := os.ReadDir(chunksDir)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"message": "读取临时目录失败: " + err.Error(),
-		})
+		return CompletedUpload{}, errors.New("读取临时目录失败: " + err.Error())
 	}
 	if int64(len(entries)) < expectedChunks {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"message": "未完成所有分片上传，当前分片数量: " + strconv.Itoa(len(entries)) + "，预期: " + strconv.FormatInt(expectedChunks, 10),
-		})
+		return CompletedUpload{}, &MergeInputError{"未完成所有分片上传，当前分片数量: " + strconv.Itoa(len(entries)) + "，预期: " + strconv.FormatInt(expectedChunks, 10)}
 	}
 
 	// 构造最终文件完整路径：UploadPath目录下的 Name 文件
 	finalFile := path.Join(dto.UploadPath, dto.Name)
+	// 合并期间在暂存目录中留下标记，进程中途退出时由启动清理删除写了一半的文件
+	marker := staging.mergeMarker(dto.Hash)
+	if err := os.WriteFile(marker, []byte(finalFile+mergingSuffix), 0o644); err != nil {
+		return CompletedUpload{}, fmt.Errorf("文件合并失败: %w", err)
+	}
+	defer os.Remove(marker)
 	// 进行合并操作
-	if err := mergeChunks(chunksDir, dto.Hash, finalFile); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"message": "文件合并失败: " + err.Error(),
+	err = merges.run(ctx, func() error {
+		return mergeChunks(chunksDir, dto.Hash, finalFile, func(out io.Writer) io.Writer {
+			return newProgressWriter(ctx, out, dto.Total, progress)
 		})
+	})
+	if err != nil {
+		return CompletedUpload{}, fmt.Errorf("文件合并失败: %w", err)
 	}
 
 	// 删除临时分片目录，清理数据
 	if err := os.RemoveAll(chunksDir); err != nil {
 		// 如果删除失败可以记录日志，但返回成功信息
 	}
+	staging.release(dto.Hash)
+	u := CompletedUpload{Name: dto.Name, Path: finalFile, Size: dto.Total, Hash: dto.Hash, Method: "chunks"}
+	uploadCompleted(u)
+	return u, nil
+}
+
+// progressWriter 每次写入前检查 ctx，写入后报告累计的字节数并按合并限速等待
+type progressWriter struct {
+	ctx   context.Context
+	w     io.Writer
+	start time.Time
+	done  int64
+	total int64
+	fn    func(done, total int64)
+}
+
+func newProgressWriter(ctx context.Context, w io.Writer, total int64, fn func(done, total int64)) *progressWriter {
+	return &progressWriter{ctx: ctx, w: w, start: time.Now(), total: total, fn: fn}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if p.fn != nil {
+		p.fn(p.done, p.total)
+	}
+	if err == nil {
+		err = merges.throttle(p.ctx, p.start, p.done)
+	}
+	return n, err
+}
+
+// MergeChunksHandler 用于将分片合并成完整文件，清理临时目录
+func MergeChunksHandler(c echo.Context) error {
+	var dto MergeChunksDto
+	if err := c.Bind(&dto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "参数绑定错误: " + err.Error(),
+		})
+	}
+
+	u, err := MergeChunks(c.Request().Context(), dto, nil)
+	if err != nil {
+		return c.JSON(mergeErrorStatus(err), map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message":   "文件合并成功",
-		"finalFile": finalFile,
+		"finalFile": u.Path,
 	})
 }
 
+// mergeErrorStatus 参数错误返回 400，目标冲突返回 409，等待合并名额时请求结束返回 503，其余为 500
+func mergeErrorStatus(err error) int {
+	var inputErr *MergeInputError
+	switch {
+	case errors.As(err, &inputErr):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrMergeConflict):
+		return http.StatusConflict
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 // getDirSize 遍历指定目录下所有文件，并返回文件总大小
 func getDirSize(dir string) (int64, error) {
 	var total int64
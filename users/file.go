@@ -0,0 +1,206 @@
+package users
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileStore 把用户和 API key 保存在一个 JSON 文件中，适合单实例部署
+type FileStore struct {
+	path string
+
+	mu   sync.RWMutex
+	data fileData
+}
+
+type fileData struct {
+	Users map[string]*User   `json:"users"`
+	Keys  map[string]*APIKey `json:"keys"` // ID -> key
+}
+
+// OpenFile 读取文件，文件不存在时从空数据开始，第一次修改时创建
+func OpenFile(path string) (*FileStore, error) {
+	s := &FileStore{path: path, data: fileData{Users: make(map[string]*User), Keys: make(map[string]*APIKey)}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Users == nil {
+		s.data.Users = make(map[string]*User)
+	}
+	if s.data.Keys == nil {
+		s.data.Keys = make(map[string]*APIKey)
+	}
+	return s, nil
+}
+
+// saveLocked 先写临时文件再 rename，调用方需持有写锁
+func (s *FileStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".users-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStore) GetUser(username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.data.Users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return *u, nil
+}
+
+func (s *FileStore) FindUserByOIDC(subject string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.data.Users {
+		if u.OIDCSubject != "" && u.OIDCSubject == subject {
+			return *u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *FileStore) ListUsers() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]User, 0, len(s.data.Users))
+	for _, u := range s.data.Users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list, nil
+}
+
+func (s *FileStore) CreateUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Users[u.Username]; ok {
+		return ErrExists
+	}
+	s.data.Users[u.Username] = &u
+	if err := s.saveLocked(); err != nil {
+		delete(s.data.Users, u.Username)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) UpdateUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data.Users[u.Username]
+	if !ok {
+		return ErrNotFound
+	}
+	s.data.Users[u.Username] = &u
+	if err := s.saveLocked(); err != nil {
+		s.data.Users[u.Username] = old
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Users[username]; !ok {
+		return ErrNotFound
+	}
+	delete(s.data.Users, username)
+	for id, k := range s.data.Keys {
+		if k.Username == username {
+			delete(s.data.Keys, id)
+		}
+	}
+	return s.saveLocked()
+}
+
+func (s *FileStore) CreateKey(k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Users[k.Username]; !ok {
+		return ErrNotFound
+	}
+	s.data.Keys[k.ID] = &k
+	if err := s.saveLocked(); err != nil {
+		delete(s.data.Keys, k.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) FindKey(hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.data.Keys {
+		if k.Hash == hash {
+			return *k, nil
+		}
+	}
+	return APIKey{}, ErrKeyNotFound
+}
+
+func (s *FileStore) ListKeys(username string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := []APIKey{}
+	for _, k := range s.data.Keys {
+		if k.Username == username {
+			list = append(list, *k)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (s *FileStore) DeleteKey(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.data.Keys[id]
+	if !ok || k.Username != username {
+		return ErrKeyNotFound
+	}
+	delete(s.data.Keys, id)
+	return s.saveLocked()
+}
+
+// TouchKey 只更新内存，随下一次修改一起写入文件，避免每次认证都写盘
+func (s *FileStore) TouchKey(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.data.Keys[id]; ok {
+		k.LastUsedAt = at
+	}
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
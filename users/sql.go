@@ -0,0 +1,261 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLStore 基于 database/sql，适用于 SQLite 和 Postgres。hub 本身不引入数据库驱动，
+// 使用前需要在构建时以空导入注册驱动（例如 github.com/mattn/go-sqlite3 或 github.com/lib/pq），
+// driver 为注册的驱动名。角色以逗号分隔保存，时间以 unix 秒保存，0 表示未设置
+type SQLStore struct {
+	db       *sql.DB
+	postgres bool
+}
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS hub_users (
+	username      VARCHAR(255) PRIMARY KEY,
+	password_hash VARCHAR(255) NOT NULL DEFAULT '',
+	oidc_subject  VARCHAR(255) NOT NULL DEFAULT '',
+	roles         TEXT NOT NULL DEFAULT '',
+	tenant        VARCHAR(255) NOT NULL DEFAULT '',
+	disabled      BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at    BIGINT NOT NULL,
+	totp_secret   VARCHAR(64) NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS hub_api_keys (
+	id           VARCHAR(64) PRIMARY KEY,
+	username     VARCHAR(255) NOT NULL REFERENCES hub_users(username) ON DELETE CASCADE,
+	name         VARCHAR(255) NOT NULL DEFAULT '',
+	hash         VARCHAR(64) NOT NULL UNIQUE,
+	token        VARCHAR(255) NOT NULL DEFAULT '',
+	created_at   BIGINT NOT NULL,
+	expires_at   BIGINT NOT NULL DEFAULT 0,
+	last_used_at BIGINT NOT NULL DEFAULT 0
+);`
+
+// sqlMigrations 给旧版本创建的表补充新列，列已存在时执行失败，忽略错误
+var sqlMigrations = []string{
+	"ALTER TABLE hub_users ADD COLUMN totp_secret VARCHAR(64) NOT NULL DEFAULT ''",
+}
+
+// OpenSQL 连接数据库并创建缺少的表和列
+func OpenSQL(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w (is the driver linked in?)", driver, err)
+	}
+	s := &SQLStore{db: db, postgres: driver == "postgres" || driver == "pgx"}
+	for _, stmt := range strings.Split(sqlSchema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	for _, stmt := range sqlMigrations {
+		_, _ = db.Exec(stmt)
+	}
+	return s, nil
+}
+
+// q 把 ? 占位符转换为 Postgres 的 $n
+func (s *SQLStore) q(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func splitRoles(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+const userColumns = "username, password_hash, oidc_subject, roles, tenant, disabled, created_at, totp_secret"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	var roles string
+	var created int64
+	err := row.Scan(&u.Username, &u.PasswordHash, &u.OIDCSubject, &roles, &u.Tenant, &u.Disabled, &created, &u.TOTPSecret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	u.Roles = splitRoles(roles)
+	u.CreatedAt = timeOrZero(created)
+	return u, nil
+}
+
+func (s *SQLStore) GetUser(username string) (User, error) {
+	return scanUser(s.db.QueryRow(s.q("SELECT "+userColumns+" FROM hub_users WHERE username = ?"), username))
+}
+
+func (s *SQLStore) FindUserByOIDC(subject string) (User, error) {
+	return scanUser(s.db.QueryRow(s.q("SELECT "+userColumns+" FROM hub_users WHERE oidc_subject = ? AND oidc_subject <> ''"), subject))
+}
+
+func (s *SQLStore) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM hub_users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) CreateUser(u User) error {
+	if _, err := s.GetUser(u.Username); err == nil {
+		return ErrExists
+	}
+	_, err := s.db.Exec(s.q("INSERT INTO hub_users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		u.Username, u.PasswordHash, u.OIDCSubject, strings.Join(u.Roles, ","), u.Tenant, u.Disabled, unixOrZero(u.CreatedAt), u.TOTPSecret)
+	return err
+}
+
+func (s *SQLStore) UpdateUser(u User) error {
+	res, err := s.db.Exec(s.q("UPDATE hub_users SET password_hash = ?, oidc_subject = ?, roles = ?, tenant = ?, disabled = ?, totp_secret = ? WHERE username = ?"),
+		u.PasswordHash, u.OIDCSubject, strings.Join(u.Roles, ","), u.Tenant, u.Disabled, u.TOTPSecret, u.Username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUser 显式删除 API key，SQLite 默认不启用外键约束
+func (s *SQLStore) DeleteUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(s.q("DELETE FROM hub_api_keys WHERE username = ?"), username); err != nil {
+		return err
+	}
+	res, err := tx.Exec(s.q("DELETE FROM hub_users WHERE username = ?"), username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+const keyColumns = "id, username, name, hash, token, created_at, expires_at, last_used_at"
+
+func scanKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	var created, expires, lastUsed int64
+	err := row.Scan(&k.ID, &k.Username, &k.Name, &k.Hash, &k.Token, &created, &expires, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.CreatedAt = timeOrZero(created)
+	k.ExpiresAt = timeOrZero(expires)
+	k.LastUsedAt = timeOrZero(lastUsed)
+	return k, nil
+}
+
+func (s *SQLStore) CreateKey(k APIKey) error {
+	if _, err := s.GetUser(k.Username); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(s.q("INSERT INTO hub_api_keys ("+keyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		k.ID, k.Username, k.Name, k.Hash, k.Token, unixOrZero(k.CreatedAt), unixOrZero(k.ExpiresAt), unixOrZero(k.LastUsedAt))
+	return err
+}
+
+func (s *SQLStore) FindKey(hash string) (APIKey, error) {
+	return scanKey(s.db.QueryRow(s.q("SELECT "+keyColumns+" FROM hub_api_keys WHERE hash = ?"), hash))
+}
+
+func (s *SQLStore) ListKeys(username string) ([]APIKey, error) {
+	rows, err := s.db.Query(s.q("SELECT "+keyColumns+" FROM hub_api_keys WHERE username = ? ORDER BY created_at"), username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []APIKey{}
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) DeleteKey(username, id string) error {
+	res, err := s.db.Exec(s.q("DELETE FROM hub_api_keys WHERE id = ? AND username = ?"), id, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) TouchKey(id string, at time.Time) error {
+	_, err := s.db.Exec(s.q("UPDATE hub_api_keys SET last_used_at = ? WHERE id = ?"), at.Unix(), id)
+	return err
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,98 @@
+package users
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238 附录 B 中 SHA-1 测试向量的密钥 "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 的 8 位验证码取后 6 位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeSecretForms(t *testing.T) {
+	step := TOTPStep(time.Unix(59, 0))
+	for _, secret := range []string{strings.ToLower(rfc6238Secret), rfc6238Secret + "===="} {
+		if got, err := TOTPCode(secret, step); err != nil || got != "287082" {
+			t.Errorf("TOTPCode(%q) = %s, %v", secret, got, err)
+		}
+	}
+	if _, err := TOTPCode("not base32!", step); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	code := func(offset int64) string {
+		c, err := TOTPCode(rfc6238Secret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, code(0), 0, step, true},
+		{"surrounding spaces", rfc6238Secret, " " + code(0) + "\n", 0, step, true},
+		{"previous step within skew", rfc6238Secret, code(-1), 1, step - 1, true},
+		{"next step within skew", rfc6238Secret, code(1), 1, step + 1, true},
+		{"previous step without skew", rfc6238Secret, code(-1), 0, 0, false},
+		{"outside skew", rfc6238Secret, code(2), 1, 0, false},
+		{"wrong code", rfc6238Secret, "000000", 1, 0, false},
+		{"short code", rfc6238Secret, code(0)[:5], 1, 0, false},
+		{"long code", rfc6238Secret, code(0) + "0", 1, 0, false},
+		{"invalid secret", "not base32!", code(0), 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := VerifyTOTP(tt.secret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Fatalf("VerifyTOTP = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TOTPCode(secret, 1); err != nil {
+		t.Fatalf("generated secret %q is unusable: %v", secret, err)
+	}
+	if uri := TOTPURI("hub", "alice", secret); !strings.HasPrefix(uri, "otpauth://totp/hub:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("TOTPURI = %q", uri)
+	}
+}
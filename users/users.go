@@ -0,0 +1,170 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// -----------------------
+// 用户管理：hub 自带的用户、角色和 API key，使 hub 不依赖外部身份服务也能独立运行。
+// 存储通过 Store 接口抽象，内置 JSON 文件和 database/sql 两种实现。
+// 密码以 bcrypt 保存；API key 只保存 SHA-256，明文只在签发时返回一次
+// -----------------------
+
+var (
+	ErrNotFound     = errors.New("user not found")
+	ErrExists       = errors.New("user already exists")
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrInvalidLogin = errors.New("invalid username or password")
+	ErrDisabled     = errors.New("user is disabled")
+	ErrKeyExpired   = errors.New("api key expired")
+)
+
+// KeyPrefix API key 的前缀，认证时据此区分 API key 和普通 token
+const KeyPrefix = "whk_"
+
+// User 一个本地用户，PasswordHash 为空时不能用密码登录（例如只通过 OIDC 登录的用户）
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	OIDCSubject  string    `json:"oidcSubject,omitempty"` // OIDC 登录时 id_token 的 sub
+	TOTPSecret   string    `json:"totpSecret,omitempty"`  // 二次验证的 TOTP 密钥，为空表示未绑定
+	Roles        []string  `json:"roles,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// APIKey 机器客户端使用的密钥，Token 为连接时关联的会话 token，为空时使用用户名
+type APIKey struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Name       string    `json:"name,omitempty"`
+	Hash       string    `json:"hash"` // 完整密钥的 SHA-256（hex）
+	Token      string    `json:"token,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"` // 零值表示不过期
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+// Store 用户和 API key 的存储，实现需要并发安全。删除用户时同时删除其 API key
+type Store interface {
+	GetUser(username string) (User, error)
+	FindUserByOIDC(subject string) (User, error)
+	ListUsers() ([]User, error)
+	CreateUser(u User) error
+	UpdateUser(u User) error
+	DeleteUser(username string) error
+
+	CreateKey(k APIKey) error
+	FindKey(hash string) (APIKey, error)
+	ListKeys(username string) ([]APIKey, error)
+	DeleteKey(username, id string) error
+	TouchKey(id string, at time.Time) error
+
+	Close() error
+}
+
+// HashPassword 以 bcrypt 默认强度生成密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Login 校验用户名和密码，用户不存在和密码错误返回相同的错误
+func Login(store Store, username, password string) (User, error) {
+	u, err := store.GetUser(username)
+	if errors.Is(err, ErrNotFound) {
+		// 仍然做一次比较，避免通过响应时间判断用户是否存在
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return User{}, ErrInvalidLogin
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if u.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return User{}, ErrInvalidLogin
+	}
+	if u.Disabled {
+		return User{}, ErrDisabled
+	}
+	return u, nil
+}
+
+// dummyHash 第一次用到时再生成，避免导入包时的 bcrypt 开销
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// HashKey 返回 API key 的存储哈希
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKey 生成 API key，返回明文和待保存的记录；ttl 为 0 表示不过期
+func NewKey(username, name, token string, ttl time.Duration) (string, APIKey, error) {
+	idBytes := make([]byte, 6)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	id := hex.EncodeToString(idBytes)
+	plain := KeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k := APIKey{
+		ID:        id,
+		Username:  username,
+		Name:      name,
+		Hash:      HashKey(plain),
+		Token:     token,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		k.ExpiresAt = k.CreatedAt.Add(ttl)
+	}
+	return plain, k, nil
+}
+
+// IsKey 判断 token 是否为 API key
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, KeyPrefix)
+}
+
+// Authenticate 校验 API key，返回密钥和所属用户，并记录最近使用时间
+func Authenticate(store Store, key string) (APIKey, User, error) {
+	k, err := store.FindKey(HashKey(key))
+	if err != nil {
+		return APIKey{}, User{}, err
+	}
+	now := time.Now()
+	if !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt) {
+		return APIKey{}, User{}, ErrKeyExpired
+	}
+	u, err := store.GetUser(k.Username)
+	if err != nil {
+		return APIKey{}, User{}, err
+	}
+	if u.Disabled {
+		return APIKey{}, User{}, ErrDisabled
+	}
+	// 最近使用时间只用于展示，写入失败不影响认证
+	if now.Sub(k.LastUsedAt) > time.Minute {
+		_ = store.TouchKey(k.ID, now)
+	}
+	return k, u, nil
+}
@@ -0,0 +1,156 @@
+package users
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestStore(t *testing.T) *FileStore {
+	t.Helper()
+	s, err := OpenFile(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []User{
+		{Username: "alice", PasswordHash: string(hash), Roles: []string{"ops"}, Tenant: "acme"},
+		{Username: "bob", PasswordHash: string(hash), Disabled: true},
+		{Username: "carol", OIDCSubject: "oidc-carol"},
+	} {
+		if err := s.CreateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestStore(t)
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     error
+	}{
+		{"valid", "alice", "secret", nil},
+		{"wrong password", "alice", "wrong", ErrInvalidLogin},
+		{"unknown user", "mallory", "secret", ErrInvalidLogin},
+		{"disabled user", "bob", "secret", ErrDisabled},
+		{"no password", "carol", "", ErrInvalidLogin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := Login(s, tt.username, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Login error = %v, want %v", err, tt.want)
+			}
+			if err == nil && u.Username != tt.username {
+				t.Fatalf("Login user = %q, want %q", u.Username, tt.username)
+			}
+		})
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	plain, k, err := NewKey("alice", "ci", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsKey(plain) || !strings.HasPrefix(plain, KeyPrefix+k.ID+"_") {
+		t.Fatalf("key %q does not carry prefix and id %q", plain, k.ID)
+	}
+	if k.Hash != HashKey(plain) {
+		t.Fatalf("stored hash %q does not match key", k.Hash)
+	}
+	if got := k.ExpiresAt.Sub(k.CreatedAt); got != time.Hour {
+		t.Fatalf("key expires after %v, want 1h", got)
+	}
+	if _, k, _ := NewKey("alice", "", "", 0); !k.ExpiresAt.IsZero() {
+		t.Fatalf("key without ttl expires at %v", k.ExpiresAt)
+	}
+	if IsKey("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Fatal("jwt treated as api key")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestStore(t)
+	issue := func(username, token string, ttl time.Duration) string {
+		plain, k, err := NewKey(username, "", token, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateKey(k); err != nil {
+			t.Fatal(err)
+		}
+		return plain
+	}
+	valid := issue("alice", "session-1", 0)
+	expired := issue("alice", "", time.Nanosecond)
+	disabled := issue("bob", "", 0)
+	deleted := issue("alice", "", 0)
+	deletedID := strings.SplitN(strings.TrimPrefix(deleted, KeyPrefix), "_", 2)[0]
+	if err := s.DeleteKey("alice", deletedID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		name string
+		key  string
+		want error
+	}{
+		{"valid", valid, nil},
+		{"tampered", valid + "x", ErrKeyNotFound},
+		{"unknown", KeyPrefix + "000000000000_AAAA", ErrKeyNotFound},
+		{"expired", expired, ErrKeyExpired},
+		{"disabled user", disabled, ErrDisabled},
+		{"deleted", deleted, ErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, u, err := Authenticate(s, tt.key)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.want)
+			}
+			if err == nil && (u.Username != "alice" || u.Tenant != "acme" || k.Token != "session-1") {
+				t.Fatalf("Authenticate = %+v, %+v", k, u)
+			}
+		})
+	}
+
+	keys, err := s.ListKeys("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k.Hash == HashKey(valid) && k.LastUsedAt.IsZero() {
+			t.Fatal("last used time not recorded")
+		}
+	}
+}
+
+func TestDeleteUserRemovesKeys(t *testing.T) {
+	s := newTestStore(t)
+	plain, k, err := NewKey("alice", "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateKey(k); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Authenticate(s, plain); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Authenticate after delete error = %v", err)
+	}
+}